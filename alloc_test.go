@@ -0,0 +1,88 @@
+package ftp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp/ftptest"
+)
+
+// benchClient returns a client logged in to an in-memory server.
+func benchClient(b *testing.B) (*Client, *ftptest.Server) {
+	b.Helper()
+	srv := ftptest.NewServer(b)
+	c, err := Dial(srv.Addr, WithTimeout(5*time.Second))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = c.Quit() })
+	if err := c.Login("bench", "bench"); err != nil {
+		b.Fatal(err)
+	}
+	return c, srv
+}
+
+// BenchmarkSmallFiles measures the cost of transferring many small files,
+// as in a sync of a large tree. The allocations include the in-process
+// server's; compare them with -memprofile and pprof's -focus.
+func BenchmarkSmallFiles(b *testing.B) {
+	data := bytes.Repeat([]byte("x"), 1024)
+
+	b.Run("Store", func(b *testing.B) {
+		c, _ := benchClient(b)
+		b.ReportAllocs()
+		for b.Loop() {
+			if err := c.Store("small.txt", bytes.NewReader(data)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Retrieve", func(b *testing.B) {
+		c, srv := benchClient(b)
+		if err := srv.WriteFile("/small.txt", data); err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		for b.Loop() {
+			if err := c.Retrieve("small.txt", io.Discard); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkList measures the cost of listing a directory of 1000 files.
+func BenchmarkList(b *testing.B) {
+	setup := func(b *testing.B) *Client {
+		c, srv := benchClient(b)
+		for i := range 1000 {
+			if err := srv.WriteFile(fmt.Sprintf("/dir/file-%04d.txt", i), []byte("data")); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportAllocs()
+		return c
+	}
+
+	b.Run("LIST", func(b *testing.B) {
+		c := setup(b)
+		for b.Loop() {
+			if entries, err := c.List("/dir"); err != nil || len(entries) != 1000 {
+				b.Fatalf("List = %d entries, %v", len(entries), err)
+			}
+		}
+	})
+
+	b.Run("MLSD", func(b *testing.B) {
+		c := setup(b)
+		for b.Loop() {
+			if entries, err := c.MLList("/dir"); err != nil || len(entries) != 1000 {
+				b.Fatalf("MLList = %d entries, %v", len(entries), err)
+			}
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package ftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newAllocMockServer returns a mock server that replies to ALLO with reply,
+// sending the arguments to allo, and stores uploads in stored.
+func newAllocMockServer(t *testing.T, reply string, allo chan<- string, stored *bytes.Buffer) *mockServer {
+	t.Helper()
+	ms := newMockServer(t)
+	ms.serveData(t)
+
+	ms.handlers["ALLO"] = func(c *textproto.Conn, args string) {
+		allo <- args
+		_ = c.PrintfLine("%s", reply)
+	}
+	ms.handlers["STOR"] = ms.transfer(func(dconn net.Conn) {
+		_, _ = io.Copy(stored, dconn)
+	})
+	return ms
+}
+
+func TestStoreWithSize(t *testing.T) {
+	t.Parallel()
+	allo := make(chan string, 1)
+	var stored bytes.Buffer
+	ms := newAllocMockServer(t, "200 ALLO command successful.", allo, &stored)
+	c := dialMock(t, ms)
+
+	var percents []float64
+	pr := &ProgressReader{Reader: strings.NewReader("0123456789")}
+	pr.Callback = func(int64) {
+		percents = append(percents, pr.Percent())
+	}
+	if err := c.StoreWithSize("file.bin", pr, 10); err != nil {
+		t.Fatalf("StoreWithSize failed: %v", err)
+	}
+
+	if got := <-allo; got != "10" {
+		t.Errorf("ALLO %s, want 10", got)
+	}
+	if stored.String() != "0123456789" {
+		t.Errorf("stored %q", stored.String())
+	}
+	if len(percents) == 0 || percents[len(percents)-1] != 100 {
+		t.Errorf("progress = %v, want to end at 100", percents)
+	}
+}
+
+func TestStoreWithSize_Rejected(t *testing.T) {
+	t.Parallel()
+
+	t.Run("not implemented", func(t *testing.T) {
+		t.Parallel()
+		allo := make(chan string, 1)
+		var stored bytes.Buffer
+		ms := newAllocMockServer(t, "502 Command not implemented.", allo, &stored)
+		c := dialMock(t, ms)
+
+		if err := c.StoreWithSize("file.bin", strings.NewReader("data"), 4); err != nil {
+			t.Fatalf("StoreWithSize failed: %v", err)
+		}
+		if stored.String() != "data" {
+			t.Errorf("stored %q", stored.String())
+		}
+	})
+
+	t.Run("no space", func(t *testing.T) {
+		t.Parallel()
+		allo := make(chan string, 1)
+		var stored bytes.Buffer
+		ms := newAllocMockServer(t, "552 Insufficient storage space.", allo, &stored)
+		c := dialMock(t, ms)
+
+		err := c.StoreWithSize("file.bin", strings.NewReader("data"), 4)
+		var pe *ProtocolError
+		if !errors.As(err, &pe) || pe.Code != 552 {
+			t.Errorf("Expected 552 error, got %v", err)
+		}
+	})
+}
+
+func TestWithAllocate(t *testing.T) {
+	t.Parallel()
+	allo := make(chan string, 1)
+	var stored bytes.Buffer
+	ms := newAllocMockServer(t, "202 ALLO not needed.", allo, &stored)
+	c := dialMock(t, ms, WithAllocate())
+
+	local := filepath.Join(t.TempDir(), "local.txt")
+	if err := os.WriteFile(local, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StoreFrom("remote.txt", local); err != nil {
+		t.Fatalf("StoreFrom failed: %v", err)
+	}
+	if got := <-allo; got != "11" {
+		t.Errorf("ALLO %s, want 11", got)
+	}
+}
+
+func TestReaderSize(t *testing.T) {
+	t.Parallel()
+
+	partly := strings.NewReader("abcdef")
+	_, _ = partly.Read(make([]byte, 2))
+
+	f, err := os.CreateTemp(t.TempDir(), "size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, _ = f.WriteString("12345")
+	_, _ = f.Seek(1, io.SeekStart)
+
+	tests := []struct {
+		name string
+		r    io.Reader
+		size int64
+		ok   bool
+	}{
+		{"bytes.Reader", bytes.NewReader([]byte("abc")), 3, true},
+		{"partly read", partly, 4, true},
+		{"section", io.NewSectionReader(strings.NewReader("0123456789"), 2, 5), 5, true},
+		{"file", f, 4, true},
+		{"progress", &ProgressReader{Reader: strings.NewReader("xy")}, 2, true},
+		{"unknown", io.MultiReader(strings.NewReader("x")), 0, false},
+	}
+	for _, tt := range tests {
+		size, ok := readerSize(tt.r)
+		if size != tt.size || ok != tt.ok {
+			t.Errorf("%s: readerSize = %d, %v; want %d, %v", tt.name, size, ok, tt.size, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package ftp
+
+import "time"
+
+// pipelineWindow is the maximum number of commands written ahead of their
+// replies. Bounding it keeps both peers from blocking on full socket buffers.
+const pipelineWindow = 32
+
+// StatResult holds the metadata returned by BatchStat for a single path.
+type StatResult struct {
+	// Path is the path as passed to BatchStat
+	Path string
+
+	// Size is the file size in bytes
+	Size int64
+
+	// ModTime is the modification time
+	ModTime time.Time
+
+	// Err is set if the server could not stat this path (e.g. a 550 reply).
+	// It does not affect the other results of the batch.
+	Err error
+}
+
+// pipelinedCommand is a command sent by sendPipelined.
+type pipelinedCommand struct {
+	command string
+	args    []string
+}
+
+// BatchStat returns the size and modification time of each path, pipelining
+// the requests over the control connection. Instead of waiting a full round
+// trip per command, up to 32 commands are written before their replies are
+// read, which makes stat-ing many files on high-latency links much faster.
+//
+// MLST is used when the server advertises it; otherwise SIZE and MDTM are
+// sent for each path. Only these read-only commands are pipelined, and
+// replies are matched to commands by order as required by RFC 959.
+//
+// Results are returned in the same order as paths. Per-path failures are
+// reported in StatResult.Err; the returned error is only set if the control
+// connection fails, in which case the connection should be considered unusable.
+//
+// Example:
+//
+//	results, err := client.BatchStat([]string{"a.txt", "b.txt", "c.txt"})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, r := range results {
+//	    if r.Err != nil {
+//	        fmt.Printf("%s: %v\n", r.Path, r.Err)
+//	        continue
+//	    }
+//	    fmt.Printf("%s: %d bytes, modified %s\n", r.Path, r.Size, r.ModTime)
+//	}
+func (c *Client) BatchStat(paths []string) ([]StatResult, error) {
+	results := make([]StatResult, len(paths))
+	if len(paths) == 0 {
+		return results, nil
+	}
+
+	useMLST := c.HasFeature("MLST")
+
+	cmds := make([]pipelinedCommand, 0, len(paths)*2)
+	for _, path := range paths {
+		if useMLST {
+			cmds = append(cmds, pipelinedCommand{"MLST", []string{path}})
+		} else {
+			cmds = append(cmds,
+				pipelinedCommand{"SIZE", []string{path}},
+				pipelinedCommand{"MDTM", []string{path}},
+			)
+		}
+	}
+
+	resps, err := c.sendPipelined(cmds)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, path := range paths {
+		r := &results[i]
+		r.Path = path
+
+		if useMLST {
+			resp := resps[i]
+			if resp.Code != 250 {
+				r.Err = &ProtocolError{Command: "MLST", Response: resp.Message, Code: resp.Code}
+				continue
+			}
+			entry, err := parseMLSTResponse(resp)
+			if err != nil {
+				r.Err = err
+				continue
+			}
+			r.Size = entry.Size
+			r.ModTime = entry.ModTime
+			continue
+		}
+
+		sizeResp, mdtmResp := resps[2*i], resps[2*i+1]
+		if !sizeResp.Is2xx() {
+			r.Err = &ProtocolError{Command: "SIZE", Response: sizeResp.Message, Code: sizeResp.Code}
+			continue
+		}
+		if !mdtmResp.Is2xx() {
+			r.Err = &ProtocolError{Command: "MDTM", Response: mdtmResp.Message, Code: mdtmResp.Code}
+			continue
+		}
+		if r.Size, err = parseSizeResponse(sizeResp); err != nil {
+			r.Err = err
+			continue
+		}
+		if r.ModTime, err = parseMDTMResponse(mdtmResp); err != nil {
+			r.Err = err
+		}
+	}
+
+	return results, nil
+}
+
+// sendPipelined sends cmds without waiting for each reply, keeping at most
+// pipelineWindow replies outstanding, and returns the replies in command
+// order. The client lock is held for the whole batch so no other command
+// (including keep-alives) can interleave and break reply matching.
+func (c *Client) sendPipelined(cmds []pipelinedCommand) ([]*Response, error) {
+	// Check every command first: a failure after some were sent would
+	// leave their replies unread
+	for _, cmd := range cmds {
+		if err := checkArgs(cmd.args); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resps := make([]*Response, 0, len(cmds))
+	for sent, cmd := range cmds {
+		if sent-len(resps) >= pipelineWindow {
+			resp, err := c.readResponseLocked(c.timeout)
+			if err != nil {
+				return nil, err
+			}
+			resps = append(resps, resp)
+		}
+		if err := c.writeCommandLocked(cmd.command, cmd.args...); err != nil {
+			return nil, err
+		}
+	}
+
+	for len(resps) < len(cmds) {
+		resp, err := c.readResponseLocked(c.timeout)
+		if err != nil {
+			return nil, err
+		}
+		resps = append(resps, resp)
+	}
+
+	return resps, nil
+}
@@ -0,0 +1,77 @@
+package ftp_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+func TestClient_BatchStat(t *testing.T) {
+	t.Parallel()
+	addr, cleanup, rootDir := setupServer(t)
+	defer cleanup()
+
+	// More files than the pipeline window, to exercise interleaved reads
+	var paths []string
+	for i := range 100 {
+		name := fmt.Sprintf("file%03d.txt", i)
+		if err := os.WriteFile(filepath.Join(rootDir, name), bytes.Repeat([]byte("x"), i), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, name)
+	}
+	paths = append(paths, "missing.txt")
+
+	c, err := ftp.Dial(addr, ftp.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Quit()
+
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	results, err := c.BatchStat(paths)
+	if err != nil {
+		t.Fatalf("BatchStat failed: %v", err)
+	}
+	if len(results) != len(paths) {
+		t.Fatalf("Expected %d results, got %d", len(paths), len(results))
+	}
+
+	for i, r := range results[:100] {
+		if r.Path != paths[i] {
+			t.Errorf("Result %d: expected path %q, got %q", i, paths[i], r.Path)
+		}
+		if r.Err != nil {
+			t.Errorf("Result %d: unexpected error: %v", i, r.Err)
+			continue
+		}
+		if r.Size != int64(i) {
+			t.Errorf("Result %d: expected size %d, got %d", i, i, r.Size)
+		}
+		if r.ModTime.IsZero() {
+			t.Errorf("Result %d: expected modification time", i)
+		}
+	}
+
+	var pe *ftp.ProtocolError
+	if missing := results[100]; !errors.As(missing.Err, &pe) || pe.Code != 550 {
+		t.Errorf("Expected 550 error for missing file, got %v", missing.Err)
+	}
+
+	// The control connection must still be in sync
+	if err := c.Noop(); err != nil {
+		t.Errorf("Noop after BatchStat failed: %v", err)
+	}
+	if size, err := c.Size("file042.txt"); err != nil || size != 42 {
+		t.Errorf("Size after BatchStat: got %d, %v", size, err)
+	}
+}
@@ -0,0 +1,65 @@
+package ftp
+
+import (
+	"net"
+
+	"github.com/gonzalop/ftp/internal/blockmode"
+)
+
+// useBlockMode reports whether transfers use block mode. With
+// WithDataConnectionReuse, the first transfer switches to it (MODE B) if
+// the server lists DATA-REUSE in its features.
+func (c *Client) useBlockMode() bool {
+	if !c.dataReuse {
+		return false
+	}
+	if !c.blockChecked {
+		c.blockChecked = true
+		caps, err := c.Capabilities()
+		if err == nil && caps.DataReuse {
+			resp, err := c.sendCommand("MODE", "B")
+			c.blockMode = err == nil && resp.Code == 200
+		}
+		if c.debugEnabled() {
+			c.logger.Debug("ftp data connection reuse", "enabled", c.blockMode)
+		}
+	}
+	return c.blockMode
+}
+
+// openBlockDataConn returns a block mode data connection: the one kept from
+// the last transfer, or a new one. The server answers the transfer command
+// with 125 when it reuses the connection.
+func (c *Client) openBlockDataConn() (net.Conn, error) {
+	c.mu.Lock()
+	conn := c.keptData
+	c.keptData = nil
+	c.mu.Unlock()
+
+	if conn == nil {
+		var err error
+		if conn, err = c.newDataConn(); err != nil {
+			return nil, err
+		}
+	}
+	return blockmode.NewConn(conn, func(reusable net.Conn) {
+		if reusable != nil {
+			c.mu.Lock()
+			c.keptData = reusable
+			c.mu.Unlock()
+		}
+	}), nil
+}
+
+// closeKeptData closes the data connection kept from the last block mode
+// transfer, if any, as the server does when the data connection settings
+// change.
+func (c *Client) closeKeptData() {
+	c.mu.Lock()
+	conn := c.keptData
+	c.keptData = nil
+	c.mu.Unlock()
+	if conn != nil {
+		_ = conn.Close()
+	}
+}
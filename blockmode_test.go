@@ -0,0 +1,142 @@
+package ftp_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
+	"github.com/gonzalop/ftp/server"
+)
+
+// replyCounter counts the replies with each code.
+type replyCounter struct {
+	mu     sync.Mutex
+	counts map[int]int
+}
+
+func (r *replyCounter) observe(_ string, resp *ftp.Response) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.counts == nil {
+		r.counts = make(map[int]int)
+	}
+	r.counts[resp.Code]++
+}
+
+func (r *replyCounter) count(code int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[code]
+}
+
+func TestDataConnectionReuse(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		tls  bool
+	}{
+		{"plain", false},
+		{"TLS", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var replies replyCounter
+			opts := []ftp.Option{ftp.WithDataConnectionReuse(), ftp.WithResponseObserver(replies.observe)}
+			var s *ftptest.Server
+			if tt.tls {
+				s = tlsTestServer(t, server.WithDataConnectionReuse(true))
+				opts = append(opts, ftp.WithExplicitTLS(&tls.Config{InsecureSkipVerify: true}))
+			} else {
+				s = ftptest.NewServer(t, server.WithDataConnectionReuse(true))
+			}
+			c := dialTestServer(t, s, opts...)
+
+			files := map[string]string{"a.txt": "first", "b.txt": "", "c.txt": strings.Repeat("x", 200000)}
+			for name, data := range files {
+				if err := c.Store(name, strings.NewReader(data)); err != nil {
+					t.Fatalf("Store(%s): %v", name, err)
+				}
+			}
+			for name, want := range files {
+				var buf bytes.Buffer
+				if err := c.Retrieve(name, &buf); err != nil || buf.String() != want {
+					t.Fatalf("Retrieve(%s) = %d bytes, %v; want %d bytes", name, buf.Len(), err, len(want))
+				}
+			}
+			entries, err := c.List("/")
+			if err != nil || len(entries) != len(files) {
+				t.Fatalf("List = %d entries, %v", len(entries), err)
+			}
+
+			// One data connection for the seven transfers
+			if n := replies.count(229) + replies.count(227); n != 1 {
+				t.Errorf("%d passive mode replies, want 1", n)
+			}
+			if n := replies.count(125); n != 7 {
+				t.Errorf("%d transfers with an open connection (125), want 7", n)
+			}
+			if tt.tls {
+				if got := c.TLSResumptionStats().Handshakes; got != 2 {
+					t.Errorf("%d TLS handshakes, want 2 (control and data)", got)
+				}
+			}
+			if !c.Config().DataConnectionReuse {
+				t.Error("Config().DataConnectionReuse = false, want true")
+			}
+		})
+	}
+}
+
+// TestDataConnectionReuse_Failures checks that a refused transfer starts
+// over with a new data connection.
+func TestDataConnectionReuse_Failures(t *testing.T) {
+	t.Parallel()
+	var replies replyCounter
+	s := ftptest.NewServer(t, server.WithDataConnectionReuse(true))
+	if err := s.WriteFile("/a.txt", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	c := dialTestServer(t, s, ftp.WithDataConnectionReuse(), ftp.WithResponseObserver(replies.observe))
+
+	retrieve := func() {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := c.Retrieve("a.txt", &buf); err != nil || buf.String() != "data" {
+			t.Fatalf("Retrieve = %q, %v", buf.String(), err)
+		}
+	}
+	retrieve()
+	if err := c.Retrieve("missing.txt", &bytes.Buffer{}); err == nil {
+		t.Fatal("Retrieve of a missing file succeeded")
+	}
+	retrieve()
+	retrieve()
+
+	// New connections for the first transfer and after the refused RETR
+	if n := replies.count(229) + replies.count(227); n != 2 {
+		t.Errorf("%d passive mode replies, want 2", n)
+	}
+}
+
+func TestDataConnectionReuse_Unsupported(t *testing.T) {
+	t.Parallel()
+	var replies replyCounter
+	s := ftptest.NewServer(t)
+	c := dialTestServer(t, s, ftp.WithDataConnectionReuse(), ftp.WithResponseObserver(replies.observe))
+	for range 2 {
+		if err := c.Store("a.txt", strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := replies.count(229) + replies.count(227); n != 2 {
+		t.Errorf("%d passive mode replies, want 2 (stream mode)", n)
+	}
+	if caps, err := c.Capabilities(); err != nil || caps.DataReuse {
+		t.Errorf("Capabilities().DataReuse = true (%v), want false", err)
+	}
+}
@@ -0,0 +1,124 @@
+package ftp
+
+import (
+	"strings"
+)
+
+// Capabilities summarizes the optional operations a server advertises in its
+// FEAT reply, so callers can branch on typed fields instead of feature
+// strings. Servers that do not support FEAT report no capabilities, even if
+// they implement some of these commands.
+type Capabilities struct {
+	// MLSD is true if the server supports machine-readable listings
+	// (RFC 3659), used by MLList.
+	MLSD bool
+
+	// MFMT is true if the server supports setting modification times with
+	// MFMT, used by SetModTime.
+	MFMT bool
+
+	// MFF is true if the server supports changing several facts of a file
+	// with MFF (draft-somers-ftp-mfxx), used by SetEntryFacts.
+	MFF bool
+
+	// MFFFacts lists the facts MFF can change, as advertised (e.g.
+	// "Modify", "UNIX.mode").
+	MFFFacts []string
+
+	// HASH is true if the server supports the HASH command
+	// (draft-bryan-ftp-hash), used by Hash.
+	HASH bool
+
+	// HashAlgorithms lists the algorithms offered for HASH, in the server's
+	// order (e.g. "SHA-256", "MD5"). The server's default is not marked.
+	HashAlgorithms []string
+
+	// REST is true if the server supports restarting stream-mode transfers
+	// (REST STREAM, RFC 3659), used by RetrieveFrom and StoreAt.
+	REST bool
+
+	// EPSV is true if the server advertises extended passive mode (RFC 2428).
+	// Many servers implement EPSV without listing it.
+	EPSV bool
+
+	// UTF8 is true if the server supports UTF-8 pathnames (RFC 2640).
+	UTF8 bool
+
+	// ModeZ is true if the server supports deflate transmission mode (MODE Z).
+	ModeZ bool
+
+	// AVBL is true if the server can report available space, used by Avbl.
+	AVBL bool
+
+	// DataReuse is true if the server keeps block mode data connections
+	// open between transfers (DATA-REUSE), used by WithDataConnectionReuse.
+	DataReuse bool
+}
+
+// Capabilities returns the optional operations the server supports, based on
+// its FEAT reply. The result is computed from the cached features, so calling
+// it repeatedly does not send more commands.
+//
+// Example:
+//
+//	caps, err := client.Capabilities()
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	if caps.MLSD {
+//	    entries, err = client.MLList("/")
+//	}
+func (c *Client) Capabilities() (Capabilities, error) {
+	feats, err := c.Features()
+	if err != nil {
+		return Capabilities{}, err
+	}
+	caps := parseCapabilities(feats)
+	if c.quirks.DisableMLSD {
+		caps.MLSD = false
+	}
+	return caps, nil
+}
+
+// parseCapabilities derives Capabilities from the parsed FEAT lines.
+func parseCapabilities(feats map[string]string) Capabilities {
+	has := func(name string) bool {
+		_, ok := feats[name]
+		return ok
+	}
+
+	caps := Capabilities{
+		MLSD: has("MLSD"),
+		MFMT: has("MFMT"),
+		MFF:  has("MFF"),
+		HASH: has("HASH"),
+		EPSV: has("EPSV"),
+		UTF8: has("UTF8"),
+		AVBL: has("AVBL"),
+
+		DataReuse: has("DATA-REUSE"),
+	}
+
+	if params, ok := feats["REST"]; ok {
+		caps.REST = strings.EqualFold(strings.TrimSpace(params), "STREAM")
+	}
+	if params, ok := feats["MODE"]; ok {
+		caps.ModeZ = strings.EqualFold(strings.TrimSpace(params), "Z")
+	}
+	if caps.MFF {
+		for fact := range strings.SplitSeq(feats["MFF"], ";") {
+			if fact = strings.TrimSpace(fact); fact != "" {
+				caps.MFFFacts = append(caps.MFFFacts, fact)
+			}
+		}
+	}
+	if caps.HASH {
+		for algo := range strings.SplitSeq(feats["HASH"], ";") {
+			if algo = strings.TrimSuffix(strings.TrimSpace(algo), "*"); algo != "" {
+				caps.HashAlgorithms = append(caps.HashAlgorithms, algo)
+			}
+		}
+	}
+
+	return caps
+}
@@ -0,0 +1,39 @@
+package ftp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	t.Parallel()
+
+	caps := parseCapabilities(parseFeatureLines([]string{
+		"211-Features:",
+		" MLSD",
+		" REST STREAM",
+		" MODE Z",
+		" MFF Modify;UNIX.mode;",
+		" HASH SHA-1;SHA-256*;MD5",
+		" UTF8",
+		"211 End",
+	}))
+	want := Capabilities{
+		MLSD:           true,
+		MFF:            true,
+		MFFFacts:       []string{"Modify", "UNIX.mode"},
+		HASH:           true,
+		HashAlgorithms: []string{"SHA-1", "SHA-256", "MD5"},
+		REST:           true,
+		UTF8:           true,
+		ModeZ:          true,
+	}
+	if !reflect.DeepEqual(caps, want) {
+		t.Errorf("parseCapabilities = %+v, want %+v", caps, want)
+	}
+
+	// REST without STREAM only covers block mode
+	if caps := parseCapabilities(map[string]string{"REST": "BLOCK"}); caps.REST {
+		t.Error("REST BLOCK reported as stream restart support")
+	}
+}
@@ -1,6 +1,9 @@
 package ftp
 
 import (
+	"bufio"
+	"net"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -17,3 +20,69 @@ func FuzzParseFeatures(f *testing.F) {
 		_ = parseFeatureLines(lines)
 	})
 }
+
+func FuzzParsePASV(f *testing.F) {
+	f.Add("227 Entering Passive Mode (192,168,1,1,195,149).")
+	f.Add("227 =127,0,0,1,4,1")
+	f.Add("227 (999,1,1,1,1,1)")
+	f.Add("227 (1,2,3,4,256,0)")
+
+	f.Fuzz(func(t *testing.T, response string) {
+		addr, err := parsePASV(response)
+		if err != nil {
+			return
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			t.Fatalf("parsePASV(%q) = %q: %v", response, addr, err)
+		}
+		if ip := net.ParseIP(host); ip == nil || ip.To4() == nil {
+			t.Errorf("parsePASV(%q) = %q: not an IPv4 address", response, addr)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+			t.Errorf("parsePASV(%q) = %q: invalid port", response, addr)
+		}
+	})
+}
+
+func FuzzParseEPSV(f *testing.F) {
+	f.Add("229 Entering Extended Passive Mode (|||6446|)")
+	f.Add("229 (!!!6446!)")
+	f.Add("229 (|||65536|)")
+	f.Add("229 (|||-1|)")
+
+	f.Fuzz(func(t *testing.T, response string) {
+		port, err := parseEPSV(response)
+		if err != nil {
+			return
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+			t.Errorf("parseEPSV(%q) = %q: invalid port", response, port)
+		}
+	})
+}
+
+func FuzzReadResponse(f *testing.F) {
+	f.Add("220 Service ready\r\n")
+	f.Add("211-Features:\r\n MDTM\r\n SIZE\r\n211 End\r\n")
+	f.Add("230-Welcome\r\n230-\r\n230 Logged in\r\n")
+	f.Add("-12 negative\r\n")
+	f.Add("200")
+	f.Add("211-unterminated\r\n")
+
+	f.Fuzz(func(t *testing.T, data string) {
+		r := bufio.NewReader(strings.NewReader(data))
+		for {
+			resp, err := readResponse(r)
+			if err != nil {
+				return
+			}
+			if resp.Code < 0 || resp.Code > 999 {
+				t.Fatalf("readResponse(%q): code %d", data, resp.Code)
+			}
+			if len(resp.Lines) == 0 {
+				t.Fatalf("readResponse(%q): no lines", data)
+			}
+		}
+	})
+}
@@ -9,21 +9,27 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
+	"maps"
 	"math/big"
 	"net"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
 	"github.com/gonzalop/ftp/server"
 )
 
@@ -53,6 +59,11 @@ func (s *safeBuffer) Bytes() []byte {
 // setupServer starts a local FTP server for testing.
 // Returns the server address, a cleanup function, and the root directory path.
 func setupServer(t *testing.T) (string, func(), string) {
+	return setupServerWithOptions(t)
+}
+
+// setupServerWithOptions is like setupServer but applies extra server options.
+func setupServerWithOptions(t *testing.T, opts ...server.Option) (string, func(), string) {
 	// 1. Setup temporary directory for server root
 	rootDir := t.TempDir()
 
@@ -67,7 +78,7 @@ func setupServer(t *testing.T) (string, func(), string) {
 	}
 
 	// Use a random port
-	s, err := server.NewServer("127.0.0.1:0", server.WithDriver(driver))
+	s, err := server.NewServer("127.0.0.1:0", append([]server.Option{server.WithDriver(driver)}, opts...)...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -380,6 +391,185 @@ func TestClient_KeepAlive(t *testing.T) {
 	}
 }
 
+func TestClient_TransferKeepAlive(t *testing.T) {
+	t.Parallel()
+	addr, cleanup, rootDir := setupServer(t)
+	defer cleanup()
+
+	content := bytes.Repeat([]byte("x"), 64*1024)
+	if err := os.WriteFile(filepath.Join(rootDir, "slow.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logBuf safeBuffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	// The slow data link stretches the download to half a second
+	c, err := ftp.Dial(addr,
+		ftp.WithTimeout(5*time.Second),
+		ftp.WithTransferKeepAlive(50*time.Millisecond),
+		ftp.WithDataConnDialer(ftptest.Link{Bandwidth: 128 * 1024}),
+		ftp.WithLogger(logger),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer func() { _ = c.Quit() }()
+
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Retrieve("slow.bin", &buf); err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	if buf.Len() != len(content) {
+		t.Errorf("Retrieved %d bytes, want %d", buf.Len(), len(content))
+	}
+	if !bytes.Contains(logBuf.Bytes(), []byte("ftp keep-alive reply")) {
+		t.Errorf("Expected NOOPs during the transfer, got:\n%s", logBuf.String())
+	}
+
+	// The control connection is still in sync
+	if _, err := c.CurrentDir(); err != nil {
+		t.Errorf("CurrentDir after transfer failed: %v", err)
+	}
+}
+
+// dialerFunc is an ftp.Dialer calling itself.
+type dialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f dialerFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+// dataDialingConn is a control connection dialing data connections itself,
+// counting them.
+type dataDialingConn struct {
+	net.Conn
+	dials *atomic.Int32
+}
+
+func (c dataDialingConn) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	c.dials.Add(1)
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+func TestClient_ControlDialer(t *testing.T) {
+	t.Parallel()
+	s := ftptest.NewServer(t)
+	var controlDials, dataDials atomic.Int32
+	dialer := dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		controlDials.Add(1)
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return dataDialingConn{Conn: conn, dials: &dataDials}, nil
+	})
+	c := dialTestServer(t, s, ftp.WithControlDialer(dialer))
+	if err := c.Store("a.txt", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.List("/"); err != nil {
+		t.Fatal(err)
+	}
+	if controlDials.Load() != 1 || dataDials.Load() != 2 {
+		t.Errorf("Dials: control %d, data %d; want 1 and 2", controlDials.Load(), dataDials.Load())
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		tls  bool
+	}{
+		{"plain", false},
+		{"explicit TLS", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			opts := []ftp.Option{ftp.WithTimeout(5 * time.Second)}
+			var s *ftptest.Server
+			if tt.tls {
+				s = tlsTestServer(t)
+				opts = append(opts, ftp.WithExplicitTLS(&tls.Config{InsecureSkipVerify: true}))
+			} else {
+				s = ftptest.NewServer(t)
+			}
+			var dataDials atomic.Int32
+			opts = append(opts, ftp.WithDataConnDialer(dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+				dataDials.Add(1)
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			})))
+
+			conn, err := net.Dial("tcp", s.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := ftp.NewClient(conn, opts...)
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+			defer func() { _ = c.Quit() }()
+			if err := c.Login("user", "pass"); err != nil {
+				t.Fatalf("Login failed: %v", err)
+			}
+
+			content := bytes.Repeat([]byte("0123456789"), 100000)
+			if err := c.Store("a.bin", bytes.NewReader(content)); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			// No other connection can be opened: a single stream download
+			f, err := os.Create(filepath.Join(t.TempDir(), "a.bin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if err := c.RetrieveParallel("a.bin", f, 4); err != nil {
+				t.Fatalf("RetrieveParallel failed: %v", err)
+			}
+			if got, _ := os.ReadFile(f.Name()); !bytes.Equal(got, content) {
+				t.Errorf("Retrieved %d bytes, want %d", len(got), len(content))
+			}
+			if n := dataDials.Load(); n != 2 {
+				t.Errorf("%d data connections dialed, want 2", n)
+			}
+		})
+	}
+}
+
+func TestNewClient_Errors(t *testing.T) {
+	t.Parallel()
+	// An invalid option closes the connection
+	client, conn := net.Pipe()
+	defer client.Close()
+	if _, err := ftp.NewClient(conn, ftp.WithLegacyTLS()); err == nil {
+		t.Fatal("NewClient with an invalid option succeeded")
+	}
+	if _, err := conn.Write([]byte("x")); err == nil {
+		t.Error("Connection not closed after a failed NewClient")
+	}
+
+	// So does a server refusing the session
+	client, conn = net.Pipe()
+	defer client.Close()
+	go func() { _, _ = client.Write([]byte("421 Too many users, sorry.\r\n")) }()
+	var pe *ftp.ProtocolError
+	if _, err := ftp.NewClient(conn, ftp.WithTimeout(5*time.Second)); !errors.As(err, &pe) || pe.Code != 421 {
+		t.Fatalf("NewClient error = %v, want 421", err)
+	}
+	if _, err := conn.Write([]byte("x")); err == nil {
+		t.Error("Connection not closed after a failed NewClient")
+	}
+}
+
 func TestClient_ActiveMode(t *testing.T) {
 	t.Parallel()
 	addr, cleanup, _ := setupServer(t)
@@ -741,12 +931,12 @@ func testHashCommands(t *testing.T, c *ftp.Client) {
 		t.Errorf("SetHashAlgo failed: %v", err)
 	}
 
-	hash, err := c.Hash("a.txt")
+	res, err := c.Hash("a.txt")
 	if err != nil {
-		t.Errorf("Hash failed: %v", err)
+		t.Fatalf("Hash failed: %v", err)
 	}
-	if hash == "" {
-		t.Error("Hash returned empty string")
+	if res.Value == "" {
+		t.Error("Hash returned empty value")
 	}
 }
 
@@ -2107,3 +2297,305 @@ func ExampleClient_SetOption() {
 		}
 	}
 }
+
+func TestVerifyTransfers_Integration(t *testing.T) {
+	t.Parallel()
+	addr, cleanup, rootDir := setupServer(t)
+	defer cleanup()
+
+	client, err := ftp.Dial(addr, ftp.WithTimeout(5*time.Second), ftp.WithVerifyTransfers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Quit() }()
+	if err := client.Login("test", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	content := bytes.Repeat([]byte("verified data\n"), 5000)
+	if err := client.Store("verified.txt", bytes.NewReader(content)); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := client.Retrieve("verified.txt", &buf); err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Error("Downloaded content mismatch")
+	}
+
+	// The server's copy changes while it is downloaded: verification must fail
+	tamper := tamperingWriter{path: filepath.Join(rootDir, "verified.txt")}
+	var mismatch *ftp.ChecksumMismatchError
+	if err := client.Retrieve("verified.txt", tamper); !errors.As(err, &mismatch) {
+		t.Errorf("Expected ChecksumMismatchError, got %v", err)
+	}
+}
+
+// tamperingWriter discards data and replaces the file at path with shorter
+// content, so the server's checksum no longer matches what was received.
+type tamperingWriter struct {
+	path string
+}
+
+func (w tamperingWriter) Write(p []byte) (int, error) {
+	return len(p), os.WriteFile(w.path, []byte("tampered"), 0644)
+}
+
+func TestCapabilitiesAndAvbl_Integration(t *testing.T) {
+	t.Parallel()
+	addr, cleanup, _ := setupServer(t)
+	defer cleanup()
+
+	client, err := ftp.Dial(addr, ftp.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Quit() }()
+	if err := client.Login("test", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	caps, err := client.Capabilities()
+	if err != nil {
+		t.Fatalf("Capabilities failed: %v", err)
+	}
+	if !caps.MLSD || !caps.MFMT || !caps.HASH || !caps.REST || !caps.EPSV || !caps.UTF8 || !caps.AVBL {
+		t.Errorf("Missing capabilities: %+v", caps)
+	}
+	if caps.ModeZ {
+		t.Error("ModeZ reported but not advertised")
+	}
+	if !slices.Contains(caps.HashAlgorithms, "SHA-256") {
+		t.Errorf("HashAlgorithms = %v", caps.HashAlgorithms)
+	}
+
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		free, err := client.Avbl("")
+		if err != nil {
+			t.Fatalf("Avbl failed: %v", err)
+		}
+		if free <= 0 {
+			t.Errorf("Avbl = %d, want > 0", free)
+		}
+
+		var pe *ftp.ProtocolError
+		if _, err := client.Avbl("missing"); !errors.As(err, &pe) || pe.Code != 550 {
+			t.Errorf("Expected 550 error, got %v", err)
+		}
+	}
+}
+
+func TestDirOptions_Integration(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need special privileges on Windows")
+	}
+	addr, cleanup, rootDir := setupServerWithOptions(t, server.WithSiteSymlink(true))
+	defer cleanup()
+
+	c, err := ftp.Dial(addr, ftp.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Quit() }()
+	if err := c.Login("test", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// file1.txt, subdir/file2.txt, subdir/nested/file3.txt, plus links
+	srcDir := t.TempDir()
+	createTestStructure(t, srcDir)
+	if err := os.WriteFile(filepath.Join(srcDir, "skip.tmp"), []byte("tmp"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{"alias.txt": "file1.txt", "loop": "."} {
+		if err := os.Symlink(target, filepath.Join(srcDir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collect := func(events *[]ftp.DirFileEvent) func(ftp.DirFileEvent) {
+		return func(ev ftp.DirFileEvent) { *events = append(*events, ev) }
+	}
+	skipped := func(events []ftp.DirFileEvent) map[string]string {
+		m := make(map[string]string)
+		for _, ev := range events {
+			if ev.Skipped {
+				m[ev.Path] = ev.Reason
+			}
+		}
+		return m
+	}
+
+	t.Run("filters and depth", func(t *testing.T) {
+		var events []ftp.DirFileEvent
+		err := c.UploadDirWithOptions(srcDir, "/filtered", ftp.DirOptions{
+			Exclude:  []string{"*.tmp"},
+			MaxDepth: 2,
+			OnFile:   collect(&events),
+		})
+		if err != nil {
+			t.Fatalf("UploadDirWithOptions failed: %v", err)
+		}
+		for _, name := range []string{"file1.txt", "subdir/file2.txt"} {
+			if _, err := os.Stat(filepath.Join(rootDir, "filtered", name)); err != nil {
+				t.Errorf("%s not uploaded: %v", name, err)
+			}
+		}
+		for _, name := range []string{"skip.tmp", "subdir/nested", "alias.txt"} {
+			if _, err := os.Lstat(filepath.Join(rootDir, "filtered", name)); err == nil {
+				t.Errorf("%s uploaded", name)
+			}
+		}
+		want := map[string]string{"skip.tmp": ftp.SkipFiltered, "alias.txt": ftp.SkipSymlink, "loop": ftp.SkipSymlink}
+		if got := skipped(events); !maps.Equal(got, want) {
+			t.Errorf("skipped = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		var events []ftp.DirFileEvent
+		err := c.UploadDirWithOptions(srcDir, "/followed", ftp.DirOptions{
+			Symlinks: ftp.SymlinkFollow,
+			OnFile:   collect(&events),
+		})
+		if err != nil {
+			t.Fatalf("UploadDirWithOptions failed: %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(rootDir, "followed", "alias.txt"))
+		if err != nil || string(data) != "content1" {
+			t.Errorf("alias.txt = %q, %v", data, err)
+		}
+		if reason := skipped(events)["loop"]; reason != ftp.SkipLinkLoop {
+			t.Errorf("loop skipped with %q, want %q", reason, ftp.SkipLinkLoop)
+		}
+	})
+
+	t.Run("materialize", func(t *testing.T) {
+		err := c.UploadDirWithOptions(srcDir, "/materialized", ftp.DirOptions{
+			Exclude:  []string{"loop"},
+			Symlinks: ftp.SymlinkMaterialize,
+		})
+		if err != nil {
+			t.Fatalf("UploadDirWithOptions failed: %v", err)
+		}
+		if target, err := os.Readlink(filepath.Join(rootDir, "materialized", "alias.txt")); err != nil || target != "file1.txt" {
+			t.Errorf("remote link -> %q, %v", target, err)
+		}
+
+		destDir := t.TempDir()
+		err = c.DownloadDirWithOptions("/materialized", destDir, ftp.DirOptions{Symlinks: ftp.SymlinkMaterialize})
+		if err != nil {
+			t.Fatalf("DownloadDirWithOptions failed: %v", err)
+		}
+		if target, err := os.Readlink(filepath.Join(destDir, "alias.txt")); err != nil || target != "file1.txt" {
+			t.Errorf("local link -> %q, %v", target, err)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		destDir := t.TempDir()
+		if err := c.DownloadDir("/filtered", destDir); err != nil {
+			t.Fatalf("DownloadDir failed: %v", err)
+		}
+		// A local change with the same size, made after the download
+		local := filepath.Join(destDir, "file1.txt")
+		if err := os.WriteFile(local, []byte("CONTENT1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			policy ftp.OverwritePolicy
+			reason string
+		}{
+			{ftp.OverwriteNever, ftp.SkipExists},
+			{ftp.OverwriteIfSizeDiffers, ftp.SkipSameSize},
+			{ftp.OverwriteIfNewer, ftp.SkipNotNewer},
+		}
+		for _, tt := range tests {
+			var events []ftp.DirFileEvent
+			err := c.DownloadDirWithOptions("/filtered", destDir, ftp.DirOptions{Overwrite: tt.policy, OnFile: collect(&events)})
+			if err != nil {
+				t.Fatalf("DownloadDirWithOptions(%v) failed: %v", tt.policy, err)
+			}
+			if reason := skipped(events)["file1.txt"]; reason != tt.reason {
+				t.Errorf("policy %v: file1.txt skipped with %q, want %q", tt.policy, reason, tt.reason)
+			}
+		}
+		if data, _ := os.ReadFile(local); string(data) != "CONTENT1" {
+			t.Errorf("local file overwritten: %q", data)
+		}
+
+		if err := c.DownloadDirWithOptions("/filtered", destDir, ftp.DirOptions{Overwrite: ftp.OverwriteAlways}); err != nil {
+			t.Fatalf("DownloadDirWithOptions failed: %v", err)
+		}
+		if data, _ := os.ReadFile(local); string(data) != "content1" {
+			t.Errorf("local file not overwritten: %q", data)
+		}
+	})
+}
+
+func TestTransferProgress_Integration(t *testing.T) {
+	t.Parallel()
+	addr, cleanup, _ := setupServer(t)
+	defer cleanup()
+
+	client, err := ftp.Dial(addr, ftp.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Quit() }()
+	if err := client.Login("test", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	data := strings.Repeat("progress", 10000)
+	var last ftp.Progress
+	err = client.Store("progress.txt", strings.NewReader(data), ftp.WithProgress(func(p ftp.Progress) {
+		last = p
+	}))
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if last.Transferred != int64(len(data)) || last.Total != int64(len(data)) || last.Percent() != 100 {
+		t.Errorf("Store progress = %+v", last)
+	}
+
+	var buf bytes.Buffer
+	calls := 0
+	err = client.Retrieve("progress.txt", &buf, ftp.WithProgress(func(p ftp.Progress) {
+		calls++
+		last = p
+	}), ftp.WithProgressInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	if buf.String() != data {
+		t.Error("Retrieve content mismatch")
+	}
+	if calls != 1 || last.Transferred == 0 {
+		t.Errorf("Retrieve progress: %d calls, last %+v", calls, last)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.Retrieve("progress.txt", io.Discard, ftp.WithTransferContext(ctx)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Retrieve with canceled context: %v", err)
+	}
+
+	// Cancel in the middle of the transfer
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	err = client.Retrieve("progress.txt", io.Discard, ftp.WithTransferContext(ctx),
+		ftp.WithProgress(func(ftp.Progress) { cancel() }))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Retrieve canceled during transfer: %v", err)
+	}
+
+	// The connection is still usable
+	if _, err := client.Size("progress.txt"); err != nil {
+		t.Errorf("Size after canceled transfer failed: %v", err)
+	}
+}
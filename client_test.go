@@ -1,10 +1,13 @@
 package ftp
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/textproto"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -134,6 +137,58 @@ func (s *mockServer) stop() {
 	<-s.done
 }
 
+// serveData gives s a passive data listener, advertised in the replies to
+// EPSV and to PASV (as 127.0.0.1). Tests can still replace either handler.
+func (s *mockServer) serveData(t *testing.T) {
+	t.Helper()
+	dataL, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.dataListener = dataL
+	port := dataL.Addr().(*net.TCPAddr).Port
+
+	s.handlers["EPSV"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("229 Entering Extended Passive Mode (|||%d|)", port)
+	}
+	s.handlers["PASV"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("227 Entering Passive Mode (127,0,0,1,%d,%d).", port/256, port%256)
+	}
+}
+
+// transfer returns a handler for a command with a data transfer: it accepts
+// the data connection, passes it to fn, then closes it and replies 226.
+func (s *mockServer) transfer(fn func(dconn net.Conn)) func(*textproto.Conn, string) {
+	return func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("150 Opening data connection.")
+		dconn, err := s.dataListener.Accept()
+		if err != nil {
+			return
+		}
+		fn(dconn)
+		dconn.Close()
+		_ = c.PrintfLine("226 Transfer complete.")
+	}
+}
+
+// dialMock starts s and returns a client logged in to it, with a one second
+// timeout and opts. The client quits and s stops when the test ends.
+func dialMock(t *testing.T, s *mockServer, opts ...Option) *Client {
+	t.Helper()
+	s.start()
+	t.Cleanup(s.stop)
+
+	c, err := Dial(s.addr, append([]Option{WithTimeout(time.Second)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Quit() })
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
 func TestClient_EPSV_Fallback(t *testing.T) {
 	t.Parallel()
 	ms := newMockServer(t)
@@ -351,3 +406,314 @@ func TestClient_EPSV_FailButNot502(t *testing.T) {
 		t.Errorf("Expected 2 EPSV commands (retry on non-502), got %d. Commands: %v", epsvCount, ms.receivedCommands)
 	}
 }
+
+func TestClient_BatchStat_SizeMDTMFallback(t *testing.T) {
+	t.Parallel()
+	ms := newMockServer(t)
+
+	// No FEAT handler: the server does not advertise MLST
+	ms.handlers["SIZE"] = func(c *textproto.Conn, args string) {
+		if args == "missing.txt" {
+			_ = c.PrintfLine("550 File not found.")
+			return
+		}
+		_ = c.PrintfLine("213 %d", len(args))
+	}
+	ms.handlers["MDTM"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("213 20240102030405")
+	}
+
+	ms.start()
+	defer ms.stop()
+
+	c, err := Dial(ms.addr, WithTimeout(1*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Quit() }()
+
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := c.BatchStat([]string{"a.txt", "missing.txt", "long-name.txt"})
+	if err != nil {
+		t.Fatalf("BatchStat failed: %v", err)
+	}
+
+	want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if results[0].Err != nil || results[0].Size != 5 || !results[0].ModTime.Equal(want) {
+		t.Errorf("Unexpected result for a.txt: %+v", results[0])
+	}
+	if pe, ok := results[1].Err.(*ProtocolError); !ok || pe.Command != "SIZE" || pe.Code != 550 {
+		t.Errorf("Expected SIZE 550 error for missing.txt, got %v", results[1].Err)
+	}
+	if results[2].Err != nil || results[2].Size != 13 {
+		t.Errorf("Unexpected result for long-name.txt: %+v", results[2])
+	}
+}
+
+func TestStouName(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{"FILE: ftp-1735084800", "ftp-1735084800"},
+		{"file: pureftpd.6591a2c3.3f.0000", "pureftpd.6591a2c3.3f.0000"},
+		{"Transfer complete (unique file name:/incoming/data.1).", "/incoming/data.1"},
+		{`Opening data channel for file upload to server of "/up/file_1.txt"`, "/up/file_1.txt"},
+		{"Opening BINARY mode data connection for ftp1234.tmp (42 bytes).", "ftp1234.tmp"},
+		{"Opening ASCII mode data connection for /incoming/u0001.", "/incoming/u0001"},
+		{"Transfer complete.", ""},
+		{"Ok to send data.", ""},
+	}
+	for _, tt := range tests {
+		if got := stouName(tt.msg); got != tt.want {
+			t.Errorf("stouName(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestClient_LoginWithAccount(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		replies  map[string]string // Reply to each login command
+		account  string
+		wantCmds string
+		wantErr  string // Command of the expected *ProtocolError
+	}{
+		{
+			name:     "account after password",
+			replies:  map[string]string{"USER": "331 Password required.", "PASS": "332 Need account for login.", "ACCT": "230 Logged in."},
+			account:  "ACCT0042",
+			wantCmds: "USER PASS ACCT",
+		},
+		{
+			name:     "account instead of password",
+			replies:  map[string]string{"USER": "332 Need account for login.", "ACCT": "230 Logged in."},
+			account:  "ACCT0042",
+			wantCmds: "USER ACCT",
+		},
+		{
+			name:     "password not needed",
+			replies:  map[string]string{"USER": "331 Password required.", "PASS": "202 Command not needed."},
+			wantCmds: "USER PASS",
+		},
+		{
+			name:     "account not given",
+			replies:  map[string]string{"USER": "331 Password required.", "PASS": "332 Need account for login."},
+			wantCmds: "USER PASS",
+			wantErr:  "PASS",
+		},
+		{
+			name:     "account rejected",
+			replies:  map[string]string{"USER": "331 Password required.", "PASS": "332 Need account for login.", "ACCT": "530 Invalid account."},
+			account:  "nope",
+			wantCmds: "USER PASS ACCT",
+			wantErr:  "ACCT",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ms := newMockServer(t)
+			for cmd, reply := range tt.replies {
+				ms.handlers[cmd] = func(c *textproto.Conn, _ string) {
+					_ = c.PrintfLine("%s", reply)
+				}
+			}
+			ms.start()
+
+			c, err := Dial(ms.addr, WithTimeout(time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.LoginWithAccount("user", "pass", tt.account)
+			_ = c.Quit()
+			ms.stop()
+
+			var pe *ProtocolError
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Login failed: %v", err)
+			case tt.wantErr != "" && (!errors.As(err, &pe) || pe.Command != tt.wantErr):
+				t.Errorf("Login error = %v, want a protocol error for %s", err, tt.wantErr)
+			}
+			if got := strings.Join(ms.receivedCommands[:len(ms.receivedCommands)-1], " "); got != tt.wantCmds {
+				t.Errorf("Commands = %q, want %q", got, tt.wantCmds)
+			}
+		})
+	}
+}
+
+func TestClient_GreetingLoginMessageHelp(t *testing.T) {
+	t.Parallel()
+	ms := newMockServer(t)
+	ms.handlers["PASS"] = func(c *textproto.Conn, _ string) {
+		_ = c.PrintfLine("230-Maintenance window: Sunday 02:00-04:00 UTC")
+		_ = c.PrintfLine(" Quota: 812 MB of 1 GB used")
+		_ = c.PrintfLine("230 User logged in.")
+	}
+	ms.handlers["HELP"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("214-Help for %s:", args)
+		_ = c.PrintfLine(" SITE CHMOD <mode> <path>")
+		_ = c.PrintfLine("214 End of help.")
+	}
+	ms.start()
+	t.Cleanup(ms.stop)
+
+	c, err := Dial(ms.addr, WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Quit() })
+
+	if g := c.Greeting(); g == nil || g.Code != 220 || g.Message != "Service ready" {
+		t.Errorf("Greeting() = %+v", g)
+	}
+	if m := c.LoginMessage(); m != nil {
+		t.Errorf("LoginMessage() before login = %+v", m)
+	}
+	if err := c.Login("user", "pass"); err != nil {
+		t.Fatal(err)
+	}
+	m := c.LoginMessage()
+	if m == nil || m.Code != 230 || m.Message != "Maintenance window: Sunday 02:00-04:00 UTC\n Quota: 812 MB of 1 GB used\nUser logged in." {
+		t.Errorf("LoginMessage() = %+v", m)
+	}
+
+	// Later commands do not replace it
+	resp, err := c.Help("SITE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != 214 || len(resp.Lines) != 3 || resp.Message != "Help for SITE:\n SITE CHMOD <mode> <path>\nEnd of help." {
+		t.Errorf("Help() = %+v", resp)
+	}
+	if c.LoginMessage() != m {
+		t.Error("LoginMessage() changed after HELP")
+	}
+}
+
+func TestClient_HashReplyFormats(t *testing.T) {
+	t.Parallel()
+	const sum = "2cf24dba5fb0a30e26e83b2ac5b9e29e"
+	tests := []struct {
+		reply string
+		want  HashResult
+	}{
+		{"SHA-256 " + sum + " hello.txt", HashResult{Algo: "SHA-256", End: -1, Value: sum, Path: "hello.txt"}},
+		{"SHA-256 0-4 " + sum + " hello.txt", HashResult{Algo: "SHA-256", Start: 0, End: 4, Value: sum, Path: "hello.txt"}},
+		{"MD5 " + sum, HashResult{Algo: "MD5", End: -1, Value: sum}},
+		{"SHA-1 " + sum + " my file.txt", HashResult{Algo: "SHA-1", End: -1, Value: sum, Path: "my file.txt"}},
+		{"SHA-1 10-20 " + sum + ` "a ""quoted"" name"`, HashResult{Algo: "SHA-1", Start: 10, End: 20, Value: sum, Path: `a "quoted" name`}},
+	}
+	ms := newMockServer(t)
+	var n int
+	ms.handlers["HASH"] = func(c *textproto.Conn, _ string) {
+		_ = c.PrintfLine("213 %s", tests[n].reply)
+		n++
+	}
+	ms.start()
+	t.Cleanup(ms.stop)
+
+	c, err := Dial(ms.addr, WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Quit() })
+	if err := c.Login("user", "pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		if res, err := c.Hash("hello.txt"); err != nil || *res != tt.want {
+			t.Errorf("Hash with reply %q = %+v, %v; want %+v", tt.reply, res, err, tt.want)
+		}
+	}
+}
+
+func TestParseHashReply_Invalid(t *testing.T) {
+	t.Parallel()
+	for _, msg := range []string{"", "SHA-256", "  SHA-256  "} {
+		if res, ok := parseHashReply(msg); ok {
+			t.Errorf("parseHashReply(%q) = %+v, want failure", msg, res)
+		}
+	}
+}
+
+func TestClient_EntryFacts(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		feat string // MFF feature, if any
+		want []string
+	}{
+		{"", []string{
+			"MFF Modify=20240102030405;UNIX.mode=0640;UNIX.owner=alice; a b.txt",
+			"SITE CHOWN alice a b.txt",
+			"SITE CHGRP 100 a b.txt",
+		}},
+		{"Modify;UNIX.mode;UNIX.owner;UNIX.group;", []string{
+			"MFF Modify=20240102030405;UNIX.mode=0640;UNIX.owner=alice; a b.txt",
+			"MFF UNIX.owner=alice; a b.txt",
+			"MFF UNIX.group=100; a b.txt",
+		}},
+	} {
+		ms := newMockServer(t)
+		var mu sync.Mutex
+		var got []string
+		ms.handlers["FEAT"] = func(c *textproto.Conn, _ string) {
+			_ = c.PrintfLine("211-Features:")
+			if tt.feat != "" {
+				_ = c.PrintfLine(" MFF %s", tt.feat)
+			}
+			_ = c.PrintfLine("211 End")
+		}
+		for _, cmd := range []string{"MFF", "SITE"} {
+			ms.handlers[cmd] = func(c *textproto.Conn, args string) {
+				mu.Lock()
+				got = append(got, cmd+" "+args)
+				mu.Unlock()
+				_ = c.PrintfLine("213 %s", args)
+			}
+		}
+		ms.start()
+		t.Cleanup(ms.stop)
+
+		c, err := Dial(ms.addr, WithTimeout(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Login("user", "pass"); err != nil {
+			t.Fatal(err)
+		}
+
+		facts := EntryFacts{
+			ModTime: time.Date(2024, 1, 2, 4, 4, 5, 0, time.FixedZone("CET", 3600)),
+			Mode:    0640,
+			Owner:   "alice",
+		}
+		if err := c.SetEntryFacts("a b.txt", facts); err != nil {
+			t.Errorf("SetEntryFacts: %v", err)
+		}
+		if err := c.Chown("a b.txt", "alice"); err != nil {
+			t.Errorf("Chown: %v", err)
+		}
+		if err := c.Chgrp("a b.txt", "100"); err != nil {
+			t.Errorf("Chgrp: %v", err)
+		}
+		if err := c.SetEntryFacts("a b.txt", EntryFacts{}); err == nil {
+			t.Error("SetEntryFacts with no facts succeeded")
+		}
+		_ = c.Quit()
+
+		mu.Lock()
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("FEAT MFF %q: commands = %q, want %q", tt.feat, got, tt.want)
+		}
+		mu.Unlock()
+	}
+}
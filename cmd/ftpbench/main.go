@@ -0,0 +1,185 @@
+// Command ftpbench generates load on an FTP server: concurrent clients log
+// in, list, send NOOPs and transfer files of mixed sizes, and the
+// throughput and per-command latencies are reported.
+//
+// Without -addr, it starts a server of the server package on a temporary
+// directory, so the profiles written with -cpuprofile and -memprofile
+// cover both the clients and the server:
+//
+//	go run ./cmd/ftpbench -clients 64 -duration 30s -cpuprofile cpu.out
+//	go tool pprof -top cpu.out
+//
+// With -addr, it runs against any server:
+//
+//	go run ./cmd/ftpbench -addr ftp.example.com:21 -user bench -pass secret -dir /tmp
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/internal/loadgen"
+	"github.com/gonzalop/ftp/server"
+)
+
+func main() {
+	var (
+		addr       = flag.String("addr", "", "server address (default: start a local server)")
+		user       = flag.String("user", "bench", "user name")
+		pass       = flag.String("pass", "bench", "password")
+		dir        = flag.String("dir", "", "directory to work in (default: the login directory)")
+		clients    = flag.Int("clients", 16, "number of concurrent clients")
+		duration   = flag.Duration("duration", 10*time.Second, "length of the run")
+		ops        = flag.Int("ops", 0, "number of operations to run instead of -duration")
+		sizes      = flag.String("sizes", "1k,64k,1m", "comma-separated transfer sizes")
+		sessionOps = flag.Int("session-ops", 50, "operations per session before logging in again (-1 = never)")
+		timeout    = flag.Duration("timeout", 30*time.Second, "client timeout")
+		cpuProfile = flag.String("cpuprofile", "", "write a CPU profile to this file")
+		memProfile = flag.String("memprofile", "", "write an allocation profile to this file")
+	)
+	flag.Parse()
+
+	sizeList, err := parseSizes(*sizes)
+	if err != nil {
+		log.Fatalf("Invalid -sizes: %v", err)
+	}
+
+	if *addr == "" {
+		local, stop, err := startServer()
+		if err != nil {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+		defer stop()
+		*addr = local
+	}
+
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			log.Fatalf("Failed to create CPU profile: %v", err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatalf("Failed to start CPU profile: %v", err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	res, err := loadgen.Run(ctx, loadgen.Config{
+		Addr:       *addr,
+		User:       *user,
+		Password:   *pass,
+		Clients:    *clients,
+		Ops:        *ops,
+		Duration:   *duration,
+		Sizes:      sizeList,
+		SessionOps: *sessionOps,
+		Dir:        *dir,
+		Options:    []ftp.Option{ftp.WithTimeout(*timeout)},
+	})
+	if err != nil {
+		log.Fatalf("Load run failed: %v", err)
+	}
+	runtime.ReadMemStats(&after)
+
+	fmt.Print(res)
+	if res.Ops > 0 {
+		fmt.Printf("allocs %d/op, %d B/op (this process)\n",
+			(after.Mallocs-before.Mallocs)/uint64(res.Ops),
+			(after.TotalAlloc-before.TotalAlloc)/uint64(res.Ops))
+	}
+
+	if *memProfile != "" {
+		f, err := os.Create(*memProfile)
+		if err != nil {
+			log.Fatalf("Failed to create allocation profile: %v", err)
+		}
+		defer f.Close()
+		if err := pprof.Lookup("allocs").WriteTo(f, 0); err != nil {
+			log.Fatalf("Failed to write allocation profile: %v", err)
+		}
+	}
+}
+
+// startServer starts a server on a temporary directory, accepting any user,
+// and returns its address and a function that stops it.
+func startServer() (string, func(), error) {
+	rootDir, err := os.MkdirTemp("", "ftpbench")
+	if err != nil {
+		return "", nil, err
+	}
+	driver, err := server.NewFSDriver(rootDir,
+		server.WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+			return rootDir, false, nil
+		}),
+	)
+	if err != nil {
+		os.RemoveAll(rootDir)
+		return "", nil, err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(rootDir)
+		return "", nil, err
+	}
+	srv, err := server.NewServer(ln.Addr().String(),
+		server.WithDriver(driver),
+		server.WithLogger(slog.New(slog.DiscardHandler)),
+	)
+	if err != nil {
+		ln.Close()
+		os.RemoveAll(rootDir)
+		return "", nil, err
+	}
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+
+	stop := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+		os.RemoveAll(rootDir)
+	}
+	return ln.Addr().String(), stop, nil
+}
+
+// parseSizes parses a comma-separated list of sizes, with optional k or m
+// suffixes (KiB and MiB).
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+	for field := range strings.SplitSeq(s, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		mult := 1
+		switch {
+		case strings.HasSuffix(field, "k"):
+			mult, field = 1<<10, strings.TrimSuffix(field, "k")
+		case strings.HasSuffix(field, "m"):
+			mult, field = 1<<20, strings.TrimSuffix(field, "m")
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid size %q", field)
+		}
+		sizes = append(sizes, n*mult)
+	}
+	return sizes, nil
+}
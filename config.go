@@ -0,0 +1,425 @@
+package ftp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config holds client settings in a form that can be loaded from a
+// configuration file, as an alternative to listing functional options in
+// code. The zero value of each field keeps the default behavior.
+//
+// Example, loading the settings from JSON:
+//
+//	var cfg ftp.Config
+//	if err := json.Unmarshal(data, &cfg); err != nil {
+//	    log.Fatal(err)
+//	}
+//	client, err := ftp.NewClientFromConfig(cfg)
+//
+// with a file such as:
+//
+//	{
+//	    "address": "ftp.example.com:21",
+//	    "user": "alice",
+//	    "password": "secret",
+//	    "timeout": "10s",
+//	    "tls": {"mode": "explicit"},
+//	    "server_profile": "vsftpd",
+//	    "bandwidth_limit": 1048576
+//	}
+//
+// Options for settings that cannot be written in a file, such as a logger,
+// a custom dialer or transfer transforms, can be appended to Options:
+//
+//	client, err := ftp.Dial(cfg.Address, append(cfg.Options(), ftp.WithLogger(logger))...)
+type Config struct {
+	// Address is the server address, as host:port. It is used by
+	// NewClientFromConfig.
+	Address string `json:"address" yaml:"address"`
+
+	// User, Password and Account are the credentials NewClientFromConfig
+	// logs in with (see LoginWithAccount). No login is done if User is
+	// empty.
+	User     string `json:"user,omitempty" yaml:"user,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+	Account  string `json:"account,omitempty" yaml:"account,omitempty"`
+
+	// Timeout, IdleTimeout and TransferKeepAlive set WithTimeout,
+	// WithIdleTimeout and WithTransferKeepAlive.
+	Timeout           Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	IdleTimeout       Duration `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty"`
+	TransferKeepAlive Duration `json:"transfer_keep_alive,omitempty" yaml:"transfer_keep_alive,omitempty"`
+
+	// TLS enables FTPS. Plain FTP is used if nil.
+	TLS *TLSSettings `json:"tls,omitempty" yaml:"tls,omitempty"`
+
+	// Mode is the data connection mode: "passive" (the default) or
+	// "active". ActivePortMin, ActivePortMax and ActiveAddress set
+	// WithActivePortRange and WithActiveAddress.
+	Mode          string `json:"mode,omitempty" yaml:"mode,omitempty"`
+	ActivePortMin int    `json:"active_port_min,omitempty" yaml:"active_port_min,omitempty"`
+	ActivePortMax int    `json:"active_port_max,omitempty" yaml:"active_port_max,omitempty"`
+	ActiveAddress string `json:"active_address,omitempty" yaml:"active_address,omitempty"`
+
+	// ServerProfile and Quirks set WithServerProfile and WithQuirks.
+	ServerProfile string `json:"server_profile,omitempty" yaml:"server_profile,omitempty"`
+	Quirks        Quirks `json:"quirks,omitzero" yaml:"quirks,omitempty"`
+
+	// ListParsers names built-in listing parsers to try first, in order:
+	// "eplf", "dos", "vms", "mvs", "netware" or "unix".
+	ListParsers []string `json:"list_parsers,omitempty" yaml:"list_parsers,omitempty"`
+
+	// NameListFallback sets WithNameListFallback.
+	NameListFallback bool `json:"name_list_fallback,omitempty" yaml:"name_list_fallback,omitempty"`
+
+	// PathStyle sets WithRemotePathStyle: "unix", "auto", "windows" or
+	// "vms".
+	PathStyle RemotePathStyle `json:"path_style,omitzero" yaml:"path_style,omitempty"`
+
+	// FilenameEncoding sets WithFilenameEncoding: "utf-8" (the default) or
+	// "latin1".
+	FilenameEncoding string `json:"filename_encoding,omitempty" yaml:"filename_encoding,omitempty"`
+
+	// BandwidthLimit sets WithBandwidthLimit, in bytes per second.
+	BandwidthLimit int64 `json:"bandwidth_limit,omitempty" yaml:"bandwidth_limit,omitempty"`
+
+	// VerifyTransfers, Allocate, CreateParents and AutoReconnect set
+	// WithVerifyTransfers, WithAllocate, WithCreateParents and
+	// WithAutoReconnect.
+	VerifyTransfers bool `json:"verify_transfers,omitempty" yaml:"verify_transfers,omitempty"`
+	Allocate        bool `json:"allocate,omitempty" yaml:"allocate,omitempty"`
+	CreateParents   bool `json:"create_parents,omitempty" yaml:"create_parents,omitempty"`
+	AutoReconnect   bool `json:"auto_reconnect,omitempty" yaml:"auto_reconnect,omitempty"`
+
+	// DataConnectionReuse sets WithDataConnectionReuse.
+	DataConnectionReuse bool `json:"data_connection_reuse,omitempty" yaml:"data_connection_reuse,omitempty"`
+
+	// Proxy sets WithProxy with a proxy URL, or WithProxyFromEnvironment
+	// if it is "environment".
+	Proxy string `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+
+	// Netrc sets WithNetrc. NewClientFromConfig then logs in even if User
+	// is empty.
+	Netrc bool `json:"netrc,omitempty" yaml:"netrc,omitempty"`
+}
+
+// TLSSettings are the FTPS settings of a Config.
+type TLSSettings struct {
+	// Mode is "explicit" (AUTH TLS, the default) or "implicit".
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+
+	// ServerName is the name the server certificate is checked against.
+	// It defaults to the host of the address.
+	ServerName string `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+
+	// InsecureSkipVerify disables certificate verification, for testing.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty"`
+
+	// CAFile is a PEM file of certificate authorities trusted instead of
+	// the system pool.
+	CAFile string `json:"ca_file,omitempty" yaml:"ca_file,omitempty"`
+
+	// CertFile and KeyFile are a PEM client certificate and its key, for
+	// servers requiring mutual TLS.
+	CertFile string `json:"cert_file,omitempty" yaml:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty" yaml:"key_file,omitempty"`
+
+	// DataProtection is the protection level of the data connections:
+	// "P" (private, the default) or "C" (clear). See WithDataProtection.
+	DataProtection string `json:"data_protection,omitempty" yaml:"data_protection,omitempty"`
+
+	// Legacy enables the AUTH SSL fallback of WithLegacyTLS.
+	Legacy bool `json:"legacy,omitempty" yaml:"legacy,omitempty"`
+}
+
+// Duration is a time.Duration written as a string, like "30s" or "5m", in
+// configuration files.
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Options returns the options for the settings of the configuration.
+// Invalid settings, or TLS files that cannot be read, make Dial fail.
+func (cfg Config) Options() []Option {
+	var opts []Option
+	if cfg.Timeout != 0 {
+		opts = append(opts, WithTimeout(time.Duration(cfg.Timeout)))
+	}
+	if cfg.IdleTimeout != 0 {
+		opts = append(opts, WithIdleTimeout(time.Duration(cfg.IdleTimeout)))
+	}
+	if cfg.TransferKeepAlive != 0 {
+		opts = append(opts, WithTransferKeepAlive(time.Duration(cfg.TransferKeepAlive)))
+	}
+	if cfg.TLS != nil {
+		opts = append(opts, cfg.tlsOption())
+		if cfg.TLS.DataProtection != "" {
+			opts = append(opts, WithDataProtection(cfg.TLS.DataProtection))
+		}
+		if cfg.TLS.Legacy {
+			opts = append(opts, WithLegacyTLS())
+		}
+	}
+
+	switch strings.ToLower(cfg.Mode) {
+	case "", "passive":
+	case "active":
+		opts = append(opts, WithActiveMode())
+	default:
+		opts = append(opts, failOption(fmt.Errorf("invalid data connection mode: %s", cfg.Mode)))
+	}
+	if cfg.ActivePortMin != 0 || cfg.ActivePortMax != 0 {
+		opts = append(opts, WithActivePortRange(cfg.ActivePortMin, cfg.ActivePortMax))
+	}
+	if cfg.ActiveAddress != "" {
+		opts = append(opts, WithActiveAddress(cfg.ActiveAddress))
+	}
+
+	if cfg.ServerProfile != "" {
+		opts = append(opts, WithServerProfile(cfg.ServerProfile))
+	}
+	if cfg.Quirks != (Quirks{}) {
+		opts = append(opts, WithQuirks(cfg.Quirks))
+	}
+
+	// WithCustomListParser prepends, so the first parser is added last
+	for i := len(cfg.ListParsers) - 1; i >= 0; i-- {
+		parser, err := builtinParser(cfg.ListParsers[i])
+		if err != nil {
+			opts = append(opts, failOption(err))
+			continue
+		}
+		opts = append(opts, WithCustomListParser(parser))
+	}
+	if cfg.NameListFallback {
+		opts = append(opts, WithNameListFallback())
+	}
+	if cfg.PathStyle != PathStyleUnix {
+		opts = append(opts, WithRemotePathStyle(cfg.PathStyle))
+	}
+
+	switch strings.ToLower(cfg.FilenameEncoding) {
+	case "", "utf-8", "utf8":
+	case "latin1", "iso-8859-1":
+		opts = append(opts, WithFilenameEncoding(Latin1))
+	default:
+		opts = append(opts, failOption(fmt.Errorf("unknown filename encoding: %s", cfg.FilenameEncoding)))
+	}
+
+	if cfg.BandwidthLimit != 0 {
+		opts = append(opts, WithBandwidthLimit(cfg.BandwidthLimit))
+	}
+	if cfg.VerifyTransfers {
+		opts = append(opts, WithVerifyTransfers())
+	}
+	if cfg.Allocate {
+		opts = append(opts, WithAllocate())
+	}
+	if cfg.CreateParents {
+		opts = append(opts, WithCreateParents())
+	}
+	if cfg.AutoReconnect {
+		opts = append(opts, WithAutoReconnect())
+	}
+	if cfg.DataConnectionReuse {
+		opts = append(opts, WithDataConnectionReuse())
+	}
+	switch cfg.Proxy {
+	case "":
+	case "environment":
+		opts = append(opts, WithProxyFromEnvironment())
+	default:
+		opts = append(opts, WithProxy(cfg.Proxy))
+	}
+	if cfg.Netrc {
+		opts = append(opts, WithNetrc())
+	}
+	return opts
+}
+
+// NewClientFromConfig connects to cfg.Address with the options of cfg, and
+// logs in if cfg.User or cfg.Netrc is set.
+func NewClientFromConfig(cfg Config) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("config has no server address")
+	}
+	c, err := Dial(cfg.Address, cfg.Options()...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.User != "" || cfg.Netrc {
+		if err := c.LoginWithAccount(cfg.User, cfg.Password, cfg.Account); err != nil {
+			_ = c.Quit()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// failOption returns an option that fails with err.
+func failOption(err error) Option {
+	return func(*Client) error {
+		return err
+	}
+}
+
+// tlsOption returns the TLS option of the configuration.
+func (cfg Config) tlsOption() Option {
+	s := cfg.TLS
+	config := &tls.Config{
+		ServerName:         s.ServerName,
+		InsecureSkipVerify: s.InsecureSkipVerify,
+	}
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(cfg.Address)
+	}
+	if s.CAFile != "" {
+		pem, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return failOption(fmt.Errorf("failed to read CA file: %w", err))
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return failOption(fmt.Errorf("no certificates found in CA file %s", s.CAFile))
+		}
+	}
+	if s.CertFile != "" || s.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return failOption(fmt.Errorf("failed to load client certificate: %w", err))
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	switch strings.ToLower(s.Mode) {
+	case "", "explicit":
+		return WithExplicitTLS(config)
+	case "implicit":
+		return WithImplicitTLS(config)
+	}
+	return failOption(fmt.Errorf("invalid TLS mode: %s", s.Mode))
+}
+
+// builtinParser returns a new built-in listing parser by name.
+func builtinParser(name string) (ListingParser, error) {
+	switch strings.ToLower(name) {
+	case "eplf":
+		return &EPLFParser{}, nil
+	case "dos":
+		return &DOSParser{}, nil
+	case "vms":
+		return &VMSParser{}, nil
+	case "mvs":
+		return &MVSParser{}, nil
+	case "netware":
+		return &NetWareParser{}, nil
+	case "unix":
+		return &UnixParser{}, nil
+	}
+	return nil, fmt.Errorf("unknown listing parser: %s", name)
+}
+
+// builtinParserName returns the name of a built-in listing parser, or ""
+// for other parsers.
+func builtinParserName(p ListingParser) string {
+	switch p.(type) {
+	case *EPLFParser:
+		return "eplf"
+	case *DOSParser:
+		return "dos"
+	case *VMSParser:
+		return "vms"
+	case *MVSParser:
+		return "mvs"
+	case *NetWareParser:
+		return "netware"
+	case *UnixParser:
+		return "unix"
+	}
+	return ""
+}
+
+// Config returns the settings of the client that a Config can describe,
+// for example to save them for later connections. The password, account,
+// TLS certificate files, custom listing parsers, custom filename encodings
+// and the proxy password are not reported. Quirks include those detected
+// at runtime.
+func (c *Client) Config() Config {
+	cfg := Config{
+		Address:             net.JoinHostPort(c.host, c.port),
+		User:                c.username,
+		Timeout:             Duration(c.timeout),
+		IdleTimeout:         Duration(c.idleTimeout),
+		TransferKeepAlive:   Duration(c.transferKeepAliveInterval),
+		Mode:                "passive",
+		ActivePortMin:       c.activePortMin,
+		ActivePortMax:       c.activePortMax,
+		Quirks:              c.quirks,
+		NameListFallback:    c.nameListFallback,
+		PathStyle:           c.pathStyle,
+		BandwidthLimit:      c.bandwidthLimit,
+		VerifyTransfers:     c.verifyTransfers,
+		Allocate:            c.allocate,
+		CreateParents:       c.createParents,
+		AutoReconnect:       c.autoReconnect,
+		DataConnectionReuse: c.dataReuse,
+	}
+	if c.activeMode {
+		cfg.Mode = "active"
+	}
+	if c.activeAddress != nil {
+		cfg.ActiveAddress = c.activeAddress.String()
+	}
+	if c.tlsMode != tlsModeNone && c.tlsConfig != nil {
+		cfg.TLS = &TLSSettings{
+			Mode:               "explicit",
+			ServerName:         c.tlsConfig.ServerName,
+			InsecureSkipVerify: c.tlsConfig.InsecureSkipVerify,
+			DataProtection:     c.dataProtection,
+			Legacy:             c.legacyTLS,
+		}
+		if c.tlsMode == tlsModeImplicit {
+			cfg.TLS.Mode = "implicit"
+		}
+	}
+	for _, p := range c.parsers {
+		if name := builtinParserName(p); name != "" {
+			cfg.ListParsers = append(cfg.ListParsers, name)
+		}
+	}
+	if c.filenameEncoding == Latin1 {
+		cfg.FilenameEncoding = "latin1"
+	}
+	switch {
+	case c.proxyFromEnv:
+		cfg.Proxy = "environment"
+	case c.proxyURL != nil:
+		u := *c.proxyURL
+		if u.User != nil {
+			u.User = url.User(u.User.Username())
+		}
+		cfg.Proxy = u.String()
+	}
+	cfg.Netrc = c.netrc
+	return cfg
+}
@@ -0,0 +1,91 @@
+package ftp_test
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
+)
+
+func TestConfig(t *testing.T) {
+	t.Parallel()
+	s := ftptest.NewServer(t)
+	if err := s.WriteFile("/pub/a.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg ftp.Config
+	data := `{
+		"address": "` + s.Addr + `",
+		"user": "user",
+		"password": "pass",
+		"timeout": "2s",
+		"idle_timeout": "1m",
+		"quirks": {"disable_mlsd": true},
+		"list_parsers": ["unix", "dos"],
+		"path_style": "auto",
+		"filename_encoding": "latin1",
+		"bandwidth_limit": 1048576,
+		"create_parents": true
+	}`
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ftp.NewClientFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+	if _, err := c.List("/pub"); err != nil {
+		t.Errorf("List failed: %v", err)
+	}
+
+	got := c.Config()
+	if got.Timeout != ftp.Duration(2*time.Second) || got.IdleTimeout != ftp.Duration(time.Minute) {
+		t.Errorf("Timeouts = %v, %v", time.Duration(got.Timeout), time.Duration(got.IdleTimeout))
+	}
+	if got.User != "user" || got.Password != "" {
+		t.Errorf("Credentials = %q, %q; want the user without password", got.User, got.Password)
+	}
+	if !slices.Equal(got.ListParsers, []string{"unix", "dos"}) {
+		t.Errorf("ListParsers = %v, want [unix dos]", got.ListParsers)
+	}
+	if !got.Quirks.DisableMLSD || got.PathStyle != ftp.PathStyleAuto || got.FilenameEncoding != "latin1" ||
+		got.BandwidthLimit != 1<<20 || !got.CreateParents || got.Mode != "passive" || got.TLS != nil {
+		t.Errorf("Unexpected config %+v", got)
+	}
+
+	// The reported configuration can be saved and loaded again
+	saved, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reloaded ftp.Config
+	if err := json.Unmarshal(saved, &reloaded); err != nil {
+		t.Fatalf("Saved config %s cannot be loaded: %v", saved, err)
+	}
+	if reloaded.Timeout != got.Timeout || reloaded.PathStyle != got.PathStyle {
+		t.Errorf("Reloaded config %+v, want %+v", reloaded, got)
+	}
+
+	for name, bad := range map[string]ftp.Config{
+		"no address": {},
+		"mode":       {Address: s.Addr, Mode: "sideways"},
+		"parser":     {Address: s.Addr, ListParsers: []string{"amiga"}},
+		"encoding":   {Address: s.Addr, FilenameEncoding: "ebcdic"},
+		"TLS mode":   {Address: s.Addr, TLS: &ftp.TLSSettings{Mode: "opportunistic"}},
+		"CA file":    {Address: s.Addr, TLS: &ftp.TLSSettings{CAFile: "/nonexistent/ca.pem"}},
+	} {
+		if c, err := ftp.NewClientFromConfig(bad); err == nil {
+			_ = c.Quit()
+			t.Errorf("%s: invalid config accepted", name)
+		}
+	}
+	if err := json.Unmarshal([]byte(`{"timeout": "soon"}`), &cfg); err == nil {
+		t.Error("Invalid duration accepted")
+	}
+}
@@ -0,0 +1,467 @@
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The account and file of the scripted servers of RunClientTests.
+const (
+	ClientUser     = "conformance"
+	ClientPassword = "secret"
+	ClientPath     = "/pub/conformance.bin"
+)
+
+// FileContent returns the content of ClientPath, which clients download
+// from the scripted servers.
+func FileContent() []byte {
+	return testData(64 * 1024)
+}
+
+// A Behavior scripts how a Server talks to clients.
+type Behavior struct {
+	// Name and Description name the behavior and describe the servers
+	// found in the wild that behave this way
+	Name        string
+	Description string
+
+	// Greeting is the greeting reply, in full (default "220 Ready")
+	Greeting string
+
+	// Replies overrides the default replies to commands, by command. Each
+	// is the reply in full; a multi-line one has its lines separated by
+	// "\n". A transfer command with a reply here transfers nothing.
+	Replies map[string]string
+
+	// Start and Done are the replies that open and close a transfer
+	// (defaults "150 Opening data connection" and "226 Transfer complete")
+	Start string
+	Done  string
+
+	// DoneFirst sends Done before the data of a download, as some servers
+	// do once the data is queued
+	DoneFirst bool
+
+	// Delay delays every reply
+	Delay time.Duration
+
+	// Upload makes RunClientTests check an upload through the server
+	// instead of a download
+	Upload bool
+
+	// WantErr means the client must fail to download or upload
+	WantErr bool
+}
+
+// Behaviors returns the behaviors that RunClientTests checks clients
+// against.
+func Behaviors() []Behavior {
+	return []Behavior{
+		{
+			Name:        "basic",
+			Description: "Replies as RFC 959 and its extensions describe",
+		},
+		{
+			Name:        "multi-line greeting",
+			Description: "Sends a banner over several lines, some of them without the reply code",
+			Greeting:    "220-Welcome to the conformance server\n220-\n  indented line\n 220 padded line\n220 Ready",
+		},
+		{
+			Name:        "multi-line login",
+			Description: "Sends a login message over several lines",
+			Replies: map[string]string{
+				"PASS": "230-Welcome back\n230-You have 0 new messages\n230 Logged in",
+			},
+		},
+		{
+			Name:        "login without password",
+			Description: "Accepts the user without asking for a password",
+			Replies:     map[string]string{"USER": "230 Logged in"},
+		},
+		{
+			Name:        "no extensions",
+			Description: "Implements RFC 959 only, without FEAT, EPSV, SIZE or MDTM",
+			Replies: map[string]string{
+				"FEAT": "502 Command not implemented",
+				"OPTS": "502 Command not implemented",
+				"EPSV": "502 Command not implemented",
+				"SIZE": "502 Command not implemented",
+				"MDTM": "502 Command not implemented",
+				"MLST": "502 Command not implemented",
+			},
+		},
+		{
+			Name:        "PASV refused",
+			Description: "Only allows EPSV, as IPv6-only servers do",
+			Replies:     map[string]string{"PASV": "502 Command not implemented"},
+		},
+		{
+			Name:        "PASV without parentheses",
+			Description: "Sends the address of PASV without parentheses",
+			Replies: map[string]string{
+				"EPSV": "502 Command not implemented",
+				"PASV": "227 Entering Passive Mode 127,0,0,1,%d,%d",
+			},
+		},
+		{
+			Name:        "125 transfer",
+			Description: "Replies 125 to transfers, for an open data connection",
+			Start:       "125 Data connection already open; transfer starting",
+		},
+		{
+			Name:        "250 completion",
+			Description: "Completes transfers with 250 instead of 226",
+			Done:        "250 Requested file action completed",
+		},
+		{
+			Name:        "early completion",
+			Description: "Sends the completion reply before the data",
+			DoneFirst:   true,
+		},
+		{
+			Name:        "slow replies",
+			Description: "Takes a while to answer every command",
+			Delay:       100 * time.Millisecond,
+		},
+		{
+			Name:        "service unavailable",
+			Description: "Greets with 421 and closes the connection",
+			Greeting:    "421 Service not available",
+			WantErr:     true,
+		},
+		{
+			Name:        "file not found",
+			Description: "Rejects the download with 550",
+			Replies:     map[string]string{"RETR": "550 No such file or directory"},
+			WantErr:     true,
+		},
+		{
+			Name:        "failed transfer",
+			Description: "Aborts the download with 426 after the data",
+			Done:        "426 Connection closed; transfer aborted",
+			WantErr:     true,
+		},
+		{
+			Name:        "upload",
+			Description: "Stores an upload",
+			Upload:      true,
+		},
+		{
+			Name:        "upload with 125",
+			Description: "Replies 125 to STOR and completes it with 250",
+			Start:       "125 Data connection already open; transfer starting",
+			Done:        "250 Requested file action completed",
+			Upload:      true,
+		},
+		{
+			Name:        "upload refused",
+			Description: "Rejects the upload with 553",
+			Replies:     map[string]string{"STOR": "553 Requested action not taken"},
+			Upload:      true,
+			WantErr:     true,
+		},
+	}
+}
+
+// Client is the client checked by RunClientTests. Each function connects
+// to addr, logs in with user and password, runs the transfer in binary
+// mode and quits.
+type Client struct {
+	// Download downloads path
+	Download func(addr, user, password, path string) ([]byte, error)
+
+	// Upload uploads data to path. If nil, upload behaviors are skipped.
+	Upload func(addr, user, password, path string, data []byte) error
+}
+
+// RunClientTests checks c against a Server for each of Behaviors, as
+// subtests named after them.
+func RunClientTests(t *testing.T, c Client) {
+	t.Helper()
+	for _, b := range Behaviors() {
+		t.Run(b.Name, func(t *testing.T) {
+			if b.Upload && c.Upload == nil {
+				t.Skip("Client.Upload is nil")
+			}
+			if !b.Upload && c.Download == nil {
+				t.Skip("Client.Download is nil")
+			}
+			s := NewServer(t, b)
+
+			want := FileContent()
+			var got []byte
+			var err error
+			if b.Upload {
+				err = c.Upload(s.Addr(), ClientUser, ClientPassword, ClientPath, want)
+				got = s.Uploaded()
+			} else {
+				got, err = c.Download(s.Addr(), ClientUser, ClientPassword, ClientPath)
+			}
+
+			switch {
+			case b.WantErr && err == nil:
+				t.Errorf("%s: got no error, want one", b.Description)
+			case b.WantErr:
+				t.Logf("%s: got error %v", b.Description, err)
+			case err != nil:
+				t.Errorf("%s: %v", b.Description, err)
+			case !bytes.Equal(got, want):
+				t.Errorf("%s: got %d bytes, want %d bytes of the file", b.Description, len(got), len(want))
+			}
+		})
+	}
+}
+
+// Server is a scripted FTP server that talks to clients as its Behavior
+// describes. It serves FileContent at ClientPath, to ClientUser, and keeps
+// the last upload.
+type Server struct {
+	b  Behavior
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	uploaded []byte
+}
+
+// NewServer starts a Server on the loopback interface, stopped when the
+// test ends.
+func NewServer(t testing.TB, b Behavior) *Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("conformance: %v", err)
+	}
+	s := &Server{b: b, ln: ln}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Addr returns the address of the server, as "host:port".
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Uploaded returns the data of the last upload.
+func (s *Server) Uploaded() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.uploaded
+}
+
+// Close stops the server and waits for its connections to end.
+func (s *Server) Close() {
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// scriptSession is the state of a control connection to a Server.
+type scriptSession struct {
+	s      *Server
+	conn   net.Conn
+	w      *bufio.Writer
+	pasv   net.Listener
+	offset int64
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	ss := &scriptSession{s: s, conn: conn, w: bufio.NewWriter(conn)}
+	defer ss.closePassive()
+	_ = conn.SetDeadline(time.Now().Add(time.Minute))
+
+	greeting := orDefault(s.b.Greeting, "220 Ready")
+	ss.reply(greeting)
+	if !strings.HasPrefix(greeting, "220") {
+		return
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		cmd = strings.ToUpper(cmd)
+		time.Sleep(s.b.Delay)
+
+		if reply, ok := s.b.Replies[cmd]; ok && cmd != "PASV" {
+			ss.reply(reply)
+			continue
+		}
+		if !ss.handleCommand(cmd, arg) {
+			return
+		}
+	}
+}
+
+// handleCommand replies to cmd by default, and reports whether the
+// connection stays open.
+func (ss *scriptSession) handleCommand(cmd, arg string) bool {
+	content := FileContent()
+	switch cmd {
+	case "USER":
+		ss.reply("331 Password required")
+	case "PASS":
+		ss.reply("230 Logged in")
+	case "SYST":
+		ss.reply("215 UNIX Type: L8")
+	case "FEAT":
+		ss.reply("211-Features:\n EPSV\n MDTM\n PASV\n REST STREAM\n SIZE\n UTF8\n211 End")
+	case "OPTS", "TYPE", "MODE", "STRU", "NOOP":
+		ss.reply("200 OK")
+	case "PWD":
+		ss.reply(`257 "/" is the current directory`)
+	case "CWD":
+		ss.reply("250 Directory changed")
+	case "SIZE":
+		if arg != ClientPath {
+			ss.reply("550 No such file")
+		} else {
+			ss.reply("213 " + strconv.Itoa(len(content)))
+		}
+	case "MDTM":
+		ss.reply("213 20240101000000")
+	case "REST":
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n < 0 {
+			ss.reply("501 Invalid offset")
+		} else {
+			ss.offset = n
+			ss.reply("350 Restarting at " + arg)
+		}
+	case "PASV", "EPSV":
+		ss.passive(cmd)
+	case "RETR":
+		if arg != ClientPath {
+			ss.reply("550 No such file")
+			break
+		}
+		offset := min(ss.offset, int64(len(content)))
+		ss.offset = 0
+		ss.transfer(func(data net.Conn) error {
+			_, err := data.Write(content[offset:])
+			return err
+		})
+	case "STOR":
+		ss.transfer(func(data net.Conn) error {
+			got, err := io.ReadAll(data)
+			ss.s.mu.Lock()
+			ss.s.uploaded = got
+			ss.s.mu.Unlock()
+			return err
+		})
+	case "QUIT":
+		ss.reply("221 Goodbye")
+		return false
+	default:
+		ss.reply("502 Command not implemented")
+	}
+	return true
+}
+
+// reply sends reply, converting its line breaks to CRLF.
+func (ss *scriptSession) reply(reply string) {
+	_, _ = ss.w.WriteString(strings.ReplaceAll(reply, "\n", "\r\n") + "\r\n")
+	_ = ss.w.Flush()
+}
+
+// passive listens for a data connection and replies to PASV or EPSV.
+func (ss *scriptSession) passive(cmd string) {
+	ss.closePassive()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		ss.reply("425 Cannot open data connection")
+		return
+	}
+	ss.pasv = ln
+	port := ln.Addr().(*net.TCPAddr).Port
+	if cmd == "EPSV" {
+		ss.reply(fmt.Sprintf("229 Entering Extended Passive Mode (|||%d|)", port))
+		return
+	}
+	format := orDefault(ss.s.b.Replies["PASV"], "227 Entering Passive Mode (127,0,0,1,%d,%d)")
+	if !strings.Contains(format, "%d") {
+		// A refusal, such as 502
+		ss.closePassive()
+		ss.reply(format)
+		return
+	}
+	ss.reply(fmt.Sprintf(format, port/256, port%256))
+}
+
+func (ss *scriptSession) closePassive() {
+	if ss.pasv != nil {
+		ss.pasv.Close()
+		ss.pasv = nil
+	}
+}
+
+// transfer runs a transfer over the passive data connection, with the
+// replies of the behavior.
+func (ss *scriptSession) transfer(run func(data net.Conn) error) {
+	if ss.pasv == nil {
+		ss.reply("425 Use PASV or EPSV first")
+		return
+	}
+	ln := ss.pasv
+	ss.pasv = nil
+	defer ln.Close()
+
+	b := ss.s.b
+	ss.reply(orDefault(b.Start, "150 Opening data connection"))
+	_ = ln.(*net.TCPListener).SetDeadline(time.Now().Add(10 * time.Second))
+	data, err := ln.Accept()
+	if err != nil {
+		ss.reply("425 Cannot open data connection")
+		return
+	}
+	_ = data.SetDeadline(time.Now().Add(10 * time.Second))
+	done := orDefault(b.Done, "226 Transfer complete")
+	if b.DoneFirst {
+		ss.reply(done)
+	}
+	err = run(data)
+	data.Close()
+	switch {
+	case b.DoneFirst:
+	case err != nil:
+		ss.reply("426 Connection closed; transfer aborted")
+	default:
+		ss.reply(done)
+	}
+}
+
+// orDefault returns s, or def if s is empty.
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,298 @@
+// Package conformance checks FTP servers and clients against the protocol.
+//
+// RunServerTests runs a battery of protocol checks, grouped by the RFCs of
+// the FTP command registry (RFC 5797), against a running server. It works
+// with any server: this package's own, one built on a custom driver, or a
+// vendor's.
+//
+//	func TestMyServer(t *testing.T) {
+//	    conformance.RunServerTests(t, conformance.ServerConfig{
+//	        Addr:     "127.0.0.1:2121",
+//	        User:     "test",
+//	        Password: "test",
+//	        Dir:      "/upload", // Writable; the write checks are skipped without it
+//	    })
+//	}
+//
+// RunClientTests works the other way around: it starts scripted servers
+// with behaviors found in the wild (multi-line replies, missing extensions,
+// unusual transfer replies) and checks that a client downloads and uploads
+// files through each of them.
+//
+//	func TestMyClient(t *testing.T) {
+//	    conformance.RunClientTests(t, conformance.Client{
+//	        Download: func(addr, user, password, path string) ([]byte, error) {
+//	            ... // Download path with the client under test
+//	        },
+//	    })
+//	}
+//
+// Only plain FTP data connections are used; explicit TLS is checked on the
+// control connection of servers that advertise AUTH TLS.
+package conformance
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTimeout is the timeout of each reply and data transfer, unless
+// ServerConfig.Timeout is set.
+const defaultTimeout = 10 * time.Second
+
+// conn is a control connection to the server under test.
+type conn struct {
+	*textproto.Conn
+	raw     net.Conn
+	host    string // Host of the control connection, also used for data
+	timeout time.Duration
+}
+
+// dial connects to addr and reads the greeting.
+func dial(addr string, timeout time.Duration) (*conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: textproto.NewConn(raw), raw: raw, host: host, timeout: timeout}
+
+	// 120 (ready in n minutes) may precede the 220 greeting
+	code, msg, err := c.read()
+	if err == nil && code == 120 {
+		code, msg, err = c.read()
+	}
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("greeting: %w", err)
+	}
+	if code != 220 {
+		c.Close()
+		return nil, fmt.Errorf("greeting: got %d %q, want 220", code, msg)
+	}
+	return c, nil
+}
+
+// read reads a reply.
+func (c *conn) read() (int, string, error) {
+	_ = c.raw.SetReadDeadline(time.Now().Add(c.timeout))
+	code, msg, err := c.ReadResponse(0)
+	if _, ok := err.(*textproto.Error); ok {
+		err = nil // Codes are checked by the callers
+	}
+	return code, msg, err
+}
+
+// cmd sends a command and reads its reply.
+func (c *conn) cmd(format string, args ...any) (int, string, error) {
+	_ = c.raw.SetWriteDeadline(time.Now().Add(c.timeout))
+	if err := c.PrintfLine(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.read()
+}
+
+// expect sends a command, failing unless the reply has one of codes.
+func (c *conn) expect(codes []int, format string, args ...any) (string, error) {
+	code, msg, err := c.cmd(format, args...)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", commandName(format, args), err)
+	}
+	if !slices.Contains(codes, code) {
+		return msg, fmt.Errorf("%s: got %d %q, want %s", commandName(format, args), code, msg, codeList(codes))
+	}
+	return msg, nil
+}
+
+// expectReject sends a command, failing unless it is rejected with a 5xx
+// reply.
+func (c *conn) expectReject(format string, args ...any) error {
+	code, msg, err := c.cmd(format, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", commandName(format, args), err)
+	}
+	if code < 500 || code > 599 {
+		return fmt.Errorf("%s: got %d %q, want a 5xx rejection", commandName(format, args), code, msg)
+	}
+	return nil
+}
+
+// commandName returns the command line for error messages.
+func commandName(format string, args []any) string {
+	return fmt.Sprintf(format, args...)
+}
+
+// codeList formats reply codes for error messages.
+func codeList(codes []int) string {
+	s := make([]string, len(codes))
+	for i, code := range codes {
+		s[i] = strconv.Itoa(code)
+	}
+	return strings.Join(s, " or ")
+}
+
+// login logs in, sending PASS if the server asks for it.
+func (c *conn) login(user, password string) error {
+	if user == "" {
+		user, password = "anonymous", "conformance@example.com"
+	}
+	code, msg, err := c.cmd("USER %s", user)
+	if err != nil {
+		return fmt.Errorf("USER: %w", err)
+	}
+	switch code {
+	case 230:
+		return nil
+	case 331:
+		_, err := c.expect([]int{230, 202}, "PASS %s", password)
+		return err
+	}
+	return fmt.Errorf("USER: got %d %q, want 230 or 331", code, msg)
+}
+
+// startTLS upgrades the control connection with AUTH TLS (RFC 4217).
+func (c *conn) startTLS() error {
+	if _, err := c.expect([]int{234}, "AUTH TLS"); err != nil {
+		return err
+	}
+	tc := tls.Client(c.raw, &tls.Config{ServerName: c.host, InsecureSkipVerify: true})
+	_ = tc.SetDeadline(time.Now().Add(c.timeout))
+	if err := tc.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake: %w", err)
+	}
+	c.raw = tc
+	c.Conn = textproto.NewConn(tc)
+	return nil
+}
+
+// passive opens a data connection with PASV, or EPSV if PASV is refused.
+// The host of the control connection is used, since servers behind NAT
+// often advertise an unreachable address.
+func (c *conn) passive() (net.Conn, error) {
+	code, msg, err := c.cmd("PASV")
+	if err != nil {
+		return nil, fmt.Errorf("PASV: %w", err)
+	}
+	var port int
+	if code == 227 {
+		port, err = parsePASV(msg)
+	} else {
+		msg, err = c.expect([]int{229}, "EPSV")
+		if err == nil {
+			port, err = parseEPSV(msg)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTimeout("tcp", net.JoinHostPort(c.host, strconv.Itoa(port)), c.timeout)
+}
+
+// parsePASV returns the port of a 227 reply, e.g.
+// "Entering Passive Mode (192,168,1,2,195,80)". Some servers leave out the
+// parentheses.
+func parsePASV(msg string) (int, error) {
+	addr := msg
+	if i := strings.Index(addr, "("); i >= 0 {
+		addr = addr[i+1:]
+	} else if i := strings.IndexAny(addr, "0123456789"); i >= 0 {
+		addr = addr[i:]
+	}
+	addr, _, _ = strings.Cut(addr, ")")
+	fields := strings.Split(strings.TrimRight(addr, ". \r\n"), ",")
+	if len(fields) != 6 {
+		return 0, fmt.Errorf("PASV: malformed address in %q", msg)
+	}
+	p1, err1 := strconv.Atoi(strings.TrimSpace(fields[4]))
+	p2, err2 := strconv.Atoi(strings.TrimSpace(fields[5]))
+	if err1 != nil || err2 != nil || p1 > 255 || p2 > 255 {
+		return 0, fmt.Errorf("PASV: malformed port in %q", msg)
+	}
+	return p1*256 + p2, nil
+}
+
+// parseEPSV returns the port of a 229 reply, e.g.
+// "Entering Extended Passive Mode (|||6446|)".
+func parseEPSV(msg string) (int, error) {
+	start := strings.Index(msg, "(")
+	end := strings.LastIndex(msg, ")")
+	if start < 0 || end < start+5 {
+		return 0, fmt.Errorf("EPSV: no port in %q", msg)
+	}
+	d := msg[start+1]
+	parts := strings.Split(msg[start+1:end], string(d))
+	if len(parts) != 5 {
+		return 0, fmt.Errorf("EPSV: malformed reply %q", msg)
+	}
+	port, err := strconv.Atoi(parts[3])
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("EPSV: malformed port in %q", msg)
+	}
+	return port, nil
+}
+
+// transfer runs a data transfer command over data, sending send (for
+// uploads) or returning the data received.
+func (c *conn) transfer(data net.Conn, send []byte, format string, args ...any) ([]byte, error) {
+	defer data.Close()
+	if _, err := c.expect([]int{125, 150}, format, args...); err != nil {
+		return nil, err
+	}
+
+	_ = data.SetDeadline(time.Now().Add(c.timeout))
+	var got []byte
+	var err error
+	if send != nil {
+		_, err = data.Write(send)
+	} else {
+		got, err = io.ReadAll(data)
+	}
+	data.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: data connection: %w", commandName(format, args), err)
+	}
+
+	code, msg, err := c.read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", commandName(format, args), err)
+	}
+	if code != 226 && code != 250 {
+		return nil, fmt.Errorf("%s: got %d %q after the transfer, want 226 or 250", commandName(format, args), code, msg)
+	}
+	return got, nil
+}
+
+// download retrieves a file in binary mode over a passive connection.
+func (c *conn) download(path string) ([]byte, error) {
+	if _, err := c.expect([]int{200}, "TYPE I"); err != nil {
+		return nil, err
+	}
+	data, err := c.passive()
+	if err != nil {
+		return nil, err
+	}
+	return c.transfer(data, nil, "RETR %s", path)
+}
+
+// upload stores a file in binary mode over a passive connection.
+func (c *conn) upload(cmd, path string, content []byte) error {
+	if _, err := c.expect([]int{200}, "TYPE I"); err != nil {
+		return err
+	}
+	data, err := c.passive()
+	if err != nil {
+		return err
+	}
+	_, err = c.transfer(data, content, "%s %s", cmd, path)
+	return err
+}
@@ -0,0 +1,110 @@
+package conformance_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/conformance"
+	"github.com/gonzalop/ftp/ftptest"
+	"github.com/gonzalop/ftp/server"
+)
+
+func TestServer(t *testing.T) {
+	root := t.TempDir()
+	driver, err := server.NewFSDriver(root,
+		server.WithAuthenticator(func(user, pass, _ string, _ net.IP) (string, bool, error) {
+			if user != "test" || pass != "secret" {
+				return "", false, os.ErrPermission
+			}
+			return root, false, nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.NewServer(ln.Addr().String(),
+		server.WithDriver(driver),
+		server.WithLogger(slog.New(slog.DiscardHandler)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(ln) }()
+	defer s.Shutdown(t.Context())
+
+	conformance.RunServerTests(t, conformance.ServerConfig{
+		Addr:     ln.Addr().String(),
+		User:     "test",
+		Password: "secret",
+		Dir:      "/",
+	})
+}
+
+func TestFTPTestServer(t *testing.T) {
+	s := ftptest.NewServer(t)
+	conformance.RunServerTests(t, conformance.ServerConfig{
+		Addr: s.Addr,
+		User: "test",
+		Dir:  "/",
+	})
+}
+
+// TestExternalServer checks the server at $FTP_CONFORMANCE_ADDR, with
+// $FTP_CONFORMANCE_USER, $FTP_CONFORMANCE_PASSWORD and the writable
+// directory $FTP_CONFORMANCE_DIR.
+func TestExternalServer(t *testing.T) {
+	addr := os.Getenv("FTP_CONFORMANCE_ADDR")
+	if addr == "" {
+		t.Skip("FTP_CONFORMANCE_ADDR not set")
+	}
+	conformance.RunServerTests(t, conformance.ServerConfig{
+		Addr:     addr,
+		User:     os.Getenv("FTP_CONFORMANCE_USER"),
+		Password: os.Getenv("FTP_CONFORMANCE_PASSWORD"),
+		Dir:      os.Getenv("FTP_CONFORMANCE_DIR"),
+	})
+}
+
+func TestClient(t *testing.T) {
+	dial := func(addr, user, password string) (*ftp.Client, error) {
+		c, err := ftp.Dial(addr, ftp.WithTimeout(5*time.Second))
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Login(user, password); err != nil {
+			c.Quit()
+			return nil, err
+		}
+		return c, nil
+	}
+
+	conformance.RunClientTests(t, conformance.Client{
+		Download: func(addr, user, password, path string) ([]byte, error) {
+			c, err := dial(addr, user, password)
+			if err != nil {
+				return nil, err
+			}
+			defer c.Quit()
+			var buf bytes.Buffer
+			err = c.Retrieve(path, &buf)
+			return buf.Bytes(), err
+		},
+		Upload: func(addr, user, password, path string, data []byte) error {
+			c, err := dial(addr, user, password)
+			if err != nil {
+				return err
+			}
+			defer c.Quit()
+			return c.Store(path, bytes.NewReader(data))
+		},
+	})
+}
@@ -0,0 +1,582 @@
+package conformance
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ServerConfig describes the server checked by RunServerTests.
+type ServerConfig struct {
+	// Addr is the address of the server, as "host:port"
+	Addr string
+
+	// User and Password log in to the server ("" = anonymous)
+	User     string
+	Password string
+
+	// Dir is a writable directory for the checks that create files and
+	// directories, which are removed afterwards. If empty, those checks
+	// are skipped.
+	Dir string
+
+	// Skip lists the names of checks to skip (see Checks), such as
+	// "PORT" for servers that cannot connect back to the test.
+	Skip []string
+
+	// Timeout limits the wait for each reply and data transfer (default
+	// 10 seconds).
+	Timeout time.Duration
+}
+
+// errNotImplemented is returned by checks of optional commands the server
+// does not implement; the check is skipped.
+var errNotImplemented = errors.New("not implemented by the server")
+
+// A Check is one of the checks of RunServerTests.
+type Check struct {
+	// Name names the check after the commands it sends, e.g. "RNFR/RNTO"
+	Name string
+
+	// RFC is the document defining the commands, e.g. "RFC 959"
+	RFC string
+
+	// Feature is the FEAT line that makes the check apply, e.g. "SIZE".
+	// Checks without one apply to every server.
+	Feature string
+
+	// Write reports whether the check creates files, in ServerConfig.Dir
+	Write bool
+
+	// noLogin makes run get a connection that is not logged in yet
+	noLogin bool
+
+	// run performs the check on c. Files it creates start with base.
+	run func(c *conn, cfg ServerConfig, base string) error
+}
+
+// Checks returns the checks of RunServerTests, in the order they run.
+func Checks() []Check {
+	return slices.Clone(checks)
+}
+
+// RunServerTests runs the conformance checks against the server of cfg,
+// each as a subtest named after its RFC and check, on its own control
+// connection. Checks of extensions run only if the server advertises them
+// in its FEAT reply.
+func RunServerTests(t *testing.T, cfg ServerConfig) {
+	t.Helper()
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	feats, err := serverFeatures(cfg)
+	if err != nil {
+		t.Fatalf("conformance: %v", err)
+	}
+
+	for _, check := range checks {
+		t.Run(check.RFC+"/"+check.Name, func(t *testing.T) {
+			switch {
+			case slices.Contains(cfg.Skip, check.Name):
+				t.Skip("skipped by ServerConfig.Skip")
+			case check.Feature != "" && !hasFeature(feats, check.Feature):
+				t.Skipf("the server does not advertise %s", check.Feature)
+			case check.Write && cfg.Dir == "":
+				t.Skip("needs ServerConfig.Dir")
+			}
+
+			c, err := dial(cfg.Addr, cfg.Timeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if !check.noLogin {
+				if err := c.login(cfg.User, cfg.Password); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			base := path.Join(cfg.Dir, fmt.Sprintf("conformance-%08x", rand.Uint32()))
+			err = check.run(c, cfg, base)
+			if errors.Is(err, errNotImplemented) {
+				t.Skip(err)
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// serverFeatures returns the lines of the server's FEAT reply, upper-cased
+// (none if it does not implement FEAT).
+func serverFeatures(cfg ServerConfig) ([]string, error) {
+	c, err := dial(cfg.Addr, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if err := c.login(cfg.User, cfg.Password); err != nil {
+		return nil, err
+	}
+	code, msg, err := c.cmd("FEAT")
+	if err != nil || code != 211 {
+		return nil, err
+	}
+	var feats []string
+	for _, line := range strings.Split(msg, "\n") {
+		if strings.HasPrefix(line, " ") {
+			feats = append(feats, strings.ToUpper(strings.TrimSpace(line)))
+		}
+	}
+	return feats, nil
+}
+
+// hasFeature reports whether feats include feature, e.g. "REST STREAM" or
+// "MLST" (which has facts after it).
+func hasFeature(feats []string, feature string) bool {
+	return slices.ContainsFunc(feats, func(f string) bool {
+		return f == feature || strings.HasPrefix(f, feature+" ")
+	})
+}
+
+// notImplemented reports whether code means the command is not implemented.
+func notImplemented(code int) bool {
+	return code == 500 || code == 502
+}
+
+// testData returns n bytes of data to upload.
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + i/251)
+	}
+	return data
+}
+
+// quotedPath matches the path of a 257 reply.
+var quotedPath = regexp.MustCompile(`"((?:[^"]|"")*)"`)
+
+// workDir returns the working directory, from PWD.
+func (c *conn) workDir() (string, error) {
+	msg, err := c.expect([]int{257}, "PWD")
+	if err != nil {
+		return "", err
+	}
+	m := quotedPath.FindStringSubmatch(msg)
+	if m == nil {
+		return "", fmt.Errorf("PWD: no quoted path in %q", msg)
+	}
+	return strings.ReplaceAll(m[1], `""`, `"`), nil
+}
+
+// list runs a listing command over a passive connection.
+func (c *conn) list(format string, args ...any) ([]byte, error) {
+	data, err := c.passive()
+	if err != nil {
+		return nil, err
+	}
+	return c.transfer(data, nil, format, args...)
+}
+
+// active listens for an active data connection, on the address of the
+// control connection, and returns the listener and its address.
+func (c *conn) active() (net.Listener, *net.TCPAddr, error) {
+	local := c.raw.LocalAddr().(*net.TCPAddr)
+	ln, err := net.Listen("tcp", net.JoinHostPort(local.IP.String(), "0"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return ln, ln.Addr().(*net.TCPAddr), nil
+}
+
+// listActive runs LIST over an active data connection, which the server
+// opens to ln.
+func (c *conn) listActive(ln net.Listener) error {
+	if _, err := c.expect([]int{125, 150}, "LIST"); err != nil {
+		return err
+	}
+	_ = ln.(*net.TCPListener).SetDeadline(time.Now().Add(c.timeout))
+	data, err := ln.Accept()
+	if err != nil {
+		return fmt.Errorf("LIST: the server did not connect: %w", err)
+	}
+	_ = data.SetDeadline(time.Now().Add(c.timeout))
+	_, err = io.Copy(io.Discard, data)
+	data.Close()
+	if err != nil {
+		return fmt.Errorf("LIST: data connection: %w", err)
+	}
+	code, msg, err := c.read()
+	if err != nil {
+		return fmt.Errorf("LIST: %w", err)
+	}
+	if code != 226 && code != 250 {
+		return fmt.Errorf("LIST: got %d %q after the transfer, want 226 or 250", code, msg)
+	}
+	return nil
+}
+
+// checks are the conformance checks, by RFC.
+var checks = []Check{
+	// RFC 959: File Transfer Protocol
+	{Name: "NOOP", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		_, err := c.expect([]int{200}, "NOOP")
+		return err
+	}},
+	{Name: "SYST", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		_, err := c.expect([]int{215}, "SYST")
+		return err
+	}},
+	{Name: "PWD", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		_, err := c.workDir()
+		return err
+	}},
+	{Name: "CWD", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, base string) error {
+		dir, err := c.workDir()
+		if err != nil {
+			return err
+		}
+		if _, err := c.expect([]int{250}, "CWD %s", dir); err != nil {
+			return err
+		}
+		return c.expectReject("CWD %s", path.Join(dir, path.Base(base)+"-missing"))
+	}},
+	{Name: "CDUP", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		// RFC 959 gives 200, and RFC 1123 allows 250
+		_, err := c.expect([]int{200, 250}, "CDUP")
+		return err
+	}},
+	{Name: "TYPE", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		for _, t := range []string{"A", "A N", "I", "L 8"} {
+			if _, err := c.expect([]int{200}, "TYPE %s", t); err != nil {
+				return err
+			}
+		}
+		return c.expectReject("TYPE Q")
+	}},
+	{Name: "MODE/STRU", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		// The minimum implementation of RFC 959 Section 5.1
+		if _, err := c.expect([]int{200}, "MODE S"); err != nil {
+			return err
+		}
+		_, err := c.expect([]int{200}, "STRU F")
+		return err
+	}},
+	{Name: "PASV", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		msg, err := c.expect([]int{227}, "PASV")
+		if err != nil {
+			return err
+		}
+		_, err = parsePASV(msg)
+		return err
+	}},
+	{Name: "LIST", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		_, err := c.list("LIST")
+		return err
+	}},
+	{Name: "NLST", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		_, err := c.list("NLST")
+		return err
+	}},
+	{Name: "PORT", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		ln, addr, err := c.active()
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		ip := addr.IP.To4()
+		if ip == nil {
+			return fmt.Errorf("PORT: %w over IPv6", errNotImplemented)
+		}
+		if _, err := c.expect([]int{200}, "PORT %d,%d,%d,%d,%d,%d", ip[0], ip[1], ip[2], ip[3], addr.Port/256, addr.Port%256); err != nil {
+			return err
+		}
+		return c.listActive(ln)
+	}},
+	{Name: "STAT", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		_, err := c.expect([]int{211, 212, 213}, "STAT")
+		return err
+	}},
+	{Name: "HELP", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		_, err := c.expect([]int{211, 214}, "HELP")
+		return err
+	}},
+	{Name: "ABOR", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		// With no transfer in progress
+		_, err := c.expect([]int{225, 226}, "ABOR")
+		return err
+	}},
+	{Name: "unknown command", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		_, err := c.expect([]int{500, 502}, "XYZZY")
+		return err
+	}},
+	{Name: "STOR/RETR", RFC: "RFC 959", Write: true, run: func(c *conn, _ ServerConfig, base string) error {
+		data := testData(256 * 1024)
+		if err := c.upload("STOR", base, data); err != nil {
+			return err
+		}
+		defer c.cmd("DELE %s", base)
+		got, err := c.download(base)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, data) {
+			return fmt.Errorf("RETR: got %d bytes back from a %d byte upload, or different data", len(got), len(data))
+		}
+		return nil
+	}},
+	{Name: "APPE", RFC: "RFC 959", Write: true, run: func(c *conn, _ ServerConfig, base string) error {
+		if err := c.upload("STOR", base, []byte("first,")); err != nil {
+			return err
+		}
+		defer c.cmd("DELE %s", base)
+		if err := c.upload("APPE", base, []byte("second")); err != nil {
+			return err
+		}
+		got, err := c.download(base)
+		if err != nil {
+			return err
+		}
+		if string(got) != "first,second" {
+			return fmt.Errorf("APPE: file contains %q, want %q", got, "first,second")
+		}
+		return nil
+	}},
+	{Name: "DELE", RFC: "RFC 959", Write: true, run: func(c *conn, _ ServerConfig, base string) error {
+		if err := c.upload("STOR", base, []byte("delete me")); err != nil {
+			return err
+		}
+		if _, err := c.expect([]int{250}, "DELE %s", base); err != nil {
+			return err
+		}
+		return c.expectReject("DELE %s", base)
+	}},
+	{Name: "RNFR/RNTO", RFC: "RFC 959", Write: true, run: func(c *conn, _ ServerConfig, base string) error {
+		if _, err := c.expect([]int{503}, "RNTO %s", base+".new"); err != nil {
+			return fmt.Errorf("without RNFR: %w", err)
+		}
+		if err := c.upload("STOR", base, []byte("rename me")); err != nil {
+			return err
+		}
+		if _, err := c.expect([]int{350}, "RNFR %s", base); err != nil {
+			c.cmd("DELE %s", base)
+			return err
+		}
+		if _, err := c.expect([]int{250}, "RNTO %s", base+".new"); err != nil {
+			c.cmd("DELE %s", base)
+			return err
+		}
+		defer c.cmd("DELE %s", base+".new")
+		if got, err := c.download(base + ".new"); err != nil || string(got) != "rename me" {
+			return fmt.Errorf("renamed file: got %q, %v", got, err)
+		}
+		return nil
+	}},
+	{Name: "MKD/RMD", RFC: "RFC 959", Write: true, run: func(c *conn, _ ServerConfig, base string) error {
+		if _, err := c.expect([]int{257}, "MKD %s", base); err != nil {
+			return err
+		}
+		if _, err := c.expect([]int{250}, "CWD %s", base); err != nil {
+			c.cmd("RMD %s", base)
+			return err
+		}
+		if _, err := c.expect([]int{200, 250}, "CDUP"); err != nil {
+			return err
+		}
+		_, err := c.expect([]int{250}, "RMD %s", base)
+		return err
+	}},
+	{Name: "QUIT", RFC: "RFC 959", run: func(c *conn, _ ServerConfig, _ string) error {
+		_, err := c.expect([]int{221}, "QUIT")
+		return err
+	}},
+
+	// RFC 2389: Feature negotiation
+	{Name: "FEAT", RFC: "RFC 2389", run: func(c *conn, _ ServerConfig, _ string) error {
+		code, msg, err := c.cmd("FEAT")
+		if err != nil {
+			return fmt.Errorf("FEAT: %w", err)
+		}
+		if notImplemented(code) {
+			return fmt.Errorf("FEAT: %w", errNotImplemented)
+		}
+		if code != 211 {
+			return fmt.Errorf("FEAT: got %d %q, want 211", code, msg)
+		}
+		lines := strings.Split(msg, "\n")
+		for _, line := range lines[1 : len(lines)-1] {
+			if !strings.HasPrefix(line, " ") {
+				return fmt.Errorf("FEAT: feature line %q does not start with a space", line)
+			}
+		}
+		return nil
+	}},
+	{Name: "OPTS UTF8", RFC: "RFC 2389", Feature: "UTF8", run: func(c *conn, _ ServerConfig, _ string) error {
+		_, err := c.expect([]int{200, 202}, "OPTS UTF8 ON")
+		return err
+	}},
+
+	// RFC 2428: FTP Extensions for IPv6 and NATs
+	{Name: "EPSV", RFC: "RFC 2428", run: func(c *conn, _ ServerConfig, _ string) error {
+		code, msg, err := c.cmd("EPSV")
+		if err != nil {
+			return fmt.Errorf("EPSV: %w", err)
+		}
+		if notImplemented(code) {
+			return fmt.Errorf("EPSV: %w", errNotImplemented)
+		}
+		if code != 229 {
+			return fmt.Errorf("EPSV: got %d %q, want 229", code, msg)
+		}
+		port, err := parseEPSV(msg)
+		if err != nil {
+			return err
+		}
+		data, err := net.DialTimeout("tcp", net.JoinHostPort(c.host, strconv.Itoa(port)), c.timeout)
+		if err != nil {
+			return fmt.Errorf("EPSV: %w", err)
+		}
+		_, err = c.transfer(data, nil, "LIST")
+		return err
+	}},
+	{Name: "EPRT", RFC: "RFC 2428", run: func(c *conn, _ ServerConfig, _ string) error {
+		ln, addr, err := c.active()
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		proto := 1
+		if addr.IP.To4() == nil {
+			proto = 2
+		}
+		code, msg, err := c.cmd("EPRT |%d|%s|%d|", proto, addr.IP, addr.Port)
+		if err != nil {
+			return fmt.Errorf("EPRT: %w", err)
+		}
+		if notImplemented(code) {
+			return fmt.Errorf("EPRT: %w", errNotImplemented)
+		}
+		if code != 200 {
+			return fmt.Errorf("EPRT: got %d %q, want 200", code, msg)
+		}
+		return c.listActive(ln)
+	}},
+
+	// RFC 2640: Internationalization of FTP
+	{Name: "LANG", RFC: "RFC 2640", Feature: "LANG", run: func(c *conn, _ ServerConfig, _ string) error {
+		_, err := c.expect([]int{200}, "LANG")
+		return err
+	}},
+
+	// RFC 3659: Extensions to FTP
+	{Name: "SIZE", RFC: "RFC 3659", Feature: "SIZE", Write: true, run: func(c *conn, _ ServerConfig, base string) error {
+		if err := c.upload("STOR", base, testData(1234)); err != nil {
+			return err
+		}
+		defer c.cmd("DELE %s", base)
+		msg, err := c.expect([]int{213}, "SIZE %s", base)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(msg) != "1234" {
+			return fmt.Errorf("SIZE: got %q, want 1234", msg)
+		}
+		return c.expectReject("SIZE %s", base+"-missing")
+	}},
+	{Name: "MDTM", RFC: "RFC 3659", Feature: "MDTM", Write: true, run: func(c *conn, _ ServerConfig, base string) error {
+		if err := c.upload("STOR", base, []byte("x")); err != nil {
+			return err
+		}
+		defer c.cmd("DELE %s", base)
+		msg, err := c.expect([]int{213}, "MDTM %s", base)
+		if err != nil {
+			return err
+		}
+		if !mdtmTime.MatchString(strings.TrimSpace(msg)) {
+			return fmt.Errorf("MDTM: got %q, want YYYYMMDDHHMMSS[.sss]", msg)
+		}
+		return nil
+	}},
+	{Name: "REST STREAM", RFC: "RFC 3659", Feature: "REST STREAM", Write: true, run: func(c *conn, _ ServerConfig, base string) error {
+		if err := c.upload("STOR", base, []byte("0123456789")); err != nil {
+			return err
+		}
+		defer c.cmd("DELE %s", base)
+		data, err := c.passive()
+		if err != nil {
+			return err
+		}
+		if _, err := c.expect([]int{350}, "REST 4"); err != nil {
+			data.Close()
+			return err
+		}
+		got, err := c.transfer(data, nil, "RETR %s", base)
+		if err != nil {
+			return err
+		}
+		if string(got) != "456789" {
+			return fmt.Errorf("RETR after REST 4: got %q, want %q", got, "456789")
+		}
+		return nil
+	}},
+	{Name: "MLST", RFC: "RFC 3659", Feature: "MLST", run: func(c *conn, _ ServerConfig, _ string) error {
+		msg, err := c.expect([]int{250}, "MLST")
+		if err != nil {
+			return err
+		}
+		lines := strings.Split(msg, "\n")
+		if len(lines) < 3 || !strings.HasPrefix(lines[1], " ") || !strings.Contains(lines[1], "=") {
+			return fmt.Errorf("MLST: no facts line in %q", msg)
+		}
+		return nil
+	}},
+	{Name: "MLSD", RFC: "RFC 3659", Feature: "MLST", run: func(c *conn, _ ServerConfig, _ string) error {
+		listing, err := c.list("MLSD")
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(strings.TrimRight(string(listing), "\r\n"), "\n") {
+			if line != "" && !strings.Contains(line, "; ") && !strings.HasPrefix(line, " ") {
+				return fmt.Errorf("MLSD: line %q has no facts", line)
+			}
+		}
+		return nil
+	}},
+
+	// RFC 4217: Securing FTP with TLS
+	{Name: "AUTH TLS", RFC: "RFC 4217", Feature: "AUTH TLS", noLogin: true, run: func(c *conn, cfg ServerConfig, _ string) error {
+		if err := c.startTLS(); err != nil {
+			return err
+		}
+		if err := c.login(cfg.User, cfg.Password); err != nil {
+			return err
+		}
+		if _, err := c.expect([]int{200}, "PBSZ 0"); err != nil {
+			return err
+		}
+		_, err := c.expect([]int{200}, "PROT P")
+		return err
+	}},
+
+	// RFC 7151: HOST command
+	{Name: "HOST", RFC: "RFC 7151", Feature: "HOST", noLogin: true, run: func(c *conn, cfg ServerConfig, _ string) error {
+		if _, err := c.expect([]int{220}, "HOST %s", c.host); err != nil {
+			return err
+		}
+		return c.login(cfg.User, cfg.Password)
+	}},
+}
+
+// mdtmTime matches the time of an MDTM reply.
+var mdtmTime = regexp.MustCompile(`^\d{14}(\.\d+)?$`)
@@ -2,18 +2,45 @@ package ftp
 
 import (
 	"net"
+	"sync"
 	"time"
 )
 
-// deadlineConn wraps a net.Conn and sets a read/write deadline before every operation.
+// deadlineChunk is the most deadlineConn writes under one deadline. A Write
+// only returns once the peer has taken all of its data, so on slow links a
+// large buffer could outlast the timeout even though data is flowing.
+const deadlineChunk = 8 * 1024
+
+// deadlineConn wraps a net.Conn with an idle timeout: the read or write
+// deadline is re-armed before every operation, and before every chunk of a
+// large write, so transfers of any length succeed as long as data keeps
+// moving. Deadlines set explicitly, such as the one set to cancel a
+// transfer, are not extended.
 type deadlineConn struct {
 	net.Conn
 	timeout time.Duration
+
+	mu        sync.Mutex // Serializes re-arming with explicit deadlines
+	readStop  time.Time  // Explicit read deadline (zero = none)
+	writeStop time.Time  // Explicit write deadline (zero = none)
+}
+
+// idleDeadline returns the deadline of the next operation, which is at most
+// the explicit deadline stop.
+func (c *deadlineConn) idleDeadline(stop time.Time) time.Time {
+	d := time.Now().Add(c.timeout)
+	if !stop.IsZero() && stop.Before(d) {
+		return stop
+	}
+	return d
 }
 
 func (c *deadlineConn) Read(b []byte) (n int, err error) {
 	if c.timeout > 0 {
-		if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		c.mu.Lock()
+		err := c.Conn.SetReadDeadline(c.idleDeadline(c.readStop))
+		c.mu.Unlock()
+		if err != nil {
 			return 0, err
 		}
 	}
@@ -21,10 +48,46 @@ func (c *deadlineConn) Read(b []byte) (n int, err error) {
 }
 
 func (c *deadlineConn) Write(b []byte) (n int, err error) {
-	if c.timeout > 0 {
-		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
-			return 0, err
+	if c.timeout <= 0 {
+		return c.Conn.Write(b)
+	}
+
+	for len(b) > 0 {
+		chunk := b[:min(len(b), deadlineChunk)]
+		c.mu.Lock()
+		err := c.Conn.SetWriteDeadline(c.idleDeadline(c.writeStop))
+		c.mu.Unlock()
+		if err != nil {
+			return n, err
 		}
+
+		m, err := c.Conn.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		b = b[m:]
 	}
-	return c.Conn.Write(b)
+	return n, nil
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readStop, c.writeStop = t, t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readStop = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeStop = t
+	return c.Conn.SetWriteDeadline(t)
 }
@@ -0,0 +1,105 @@
+package ftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp/ftptest"
+)
+
+// The timeout is an idle timeout: transfers lasting many times the timeout
+// succeed while data keeps flowing. These stand in for multi-minute
+// transfers, with the times scaled down.
+func TestDeadlineConnIdleTimeout(t *testing.T) {
+	t.Parallel()
+	const timeout = 100 * time.Millisecond
+	data := bytes.Repeat([]byte("0123456789abcdef"), 16*1024) // 256 KiB
+
+	t.Run("write", func(t *testing.T) {
+		t.Parallel()
+		client, server := net.Pipe()
+		dc := &deadlineConn{Conn: client, timeout: timeout}
+
+		var got bytes.Buffer
+		done := make(chan error, 1)
+		go func() {
+			_, err := io.Copy(&got, ftptest.Link{Bandwidth: 400 << 10}.Conn(server))
+			done <- err
+		}()
+
+		// A single large Write, as io.Copy does with its buffer, takes
+		// about 640ms at this rate
+		start := time.Now()
+		if _, err := dc.Write(data); err != nil {
+			t.Fatalf("Write failed after %v: %v", time.Since(start), err)
+		}
+		if d := time.Since(start); d < 3*timeout {
+			t.Errorf("Write took %v; the test needs a transfer longer than the timeout", d)
+		}
+		dc.Close()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), data) {
+			t.Errorf("Got %d bytes, want %d", got.Len(), len(data))
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		t.Parallel()
+		client, server := net.Pipe()
+		dc := &deadlineConn{Conn: client, timeout: timeout}
+
+		go func() {
+			defer server.Close()
+			_, _ = ftptest.Link{Bandwidth: 128 << 10}.Conn(server).Write(data[:64*1024])
+		}()
+
+		got, err := io.ReadAll(dc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data[:64*1024]) {
+			t.Errorf("Got %d bytes, want %d", len(got), 64*1024)
+		}
+	})
+
+	t.Run("stall", func(t *testing.T) {
+		t.Parallel()
+		client, server := net.Pipe()
+		defer server.Close()
+		dc := &deadlineConn{Conn: client, timeout: timeout}
+
+		start := time.Now()
+		_, err := dc.Read(make([]byte, 1))
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Read from a stalled peer: %v, want a timeout", err)
+		}
+		if d := time.Since(start); d > 10*timeout {
+			t.Errorf("Stalled read took %v", d)
+		}
+	})
+
+	// Explicit deadlines, used to cancel transfers, are not extended
+	t.Run("canceled", func(t *testing.T) {
+		t.Parallel()
+		client, server := net.Pipe()
+		defer server.Close()
+		dc := &deadlineConn{Conn: client, timeout: time.Hour}
+
+		if err := dc.SetDeadline(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dc.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Read after SetDeadline(now): %v, want a timeout", err)
+		}
+		if _, err := dc.Write([]byte("x")); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Write after SetDeadline(now): %v, want a timeout", err)
+		}
+	})
+}
@@ -2,8 +2,11 @@ package ftp
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -19,6 +22,33 @@ type Response struct {
 
 	// Lines contains all lines of the response (for multi-line responses)
 	Lines []string
+
+	// Duration is the time from sending the command to receiving the
+	// response. For the response completing a data transfer, it includes the
+	// transfer. It is zero for the greeting.
+	Duration time.Duration
+
+	// line backs Lines for single-line responses, saving an allocation
+	line [1]string
+}
+
+// ResponseObserver is called with each response read from the control
+// connection and the name of the command it answers, such as "CWD" ("" for
+// the greeting). Preliminary (1xx) responses are reported too, followed by
+// the final response to the same command. The observer is called with the
+// client locked, so it must not call the Client's methods.
+type ResponseObserver func(command string, resp *Response)
+
+// sentCommand is a command awaiting its final response.
+type sentCommand struct {
+	name      string
+	sent      time.Time
+	keepAlive bool // a NOOP sent by the client itself
+}
+
+// Is1xx returns true if the response code is in the 1xx range (preliminary).
+func (r *Response) Is1xx() bool {
+	return r.Code >= 100 && r.Code < 200
 }
 
 // Is2xx returns true if the response code is in the 2xx range (success).
@@ -46,6 +76,43 @@ func (r *Response) String() string {
 	return strings.Join(r.Lines, "\n")
 }
 
+// maxResponseSize is the maximum size of a response, so that a hostile or
+// broken server cannot make the client buffer an endless response.
+const maxResponseSize = 16 << 20
+
+// errResponseTooLong is returned for responses over maxResponseSize.
+var errResponseTooLong = errors.New("response too long")
+
+// readLine reads a line from r, including the line break, and subtracts its
+// length from budget. Lines over the remaining budget are an error.
+func readLine(r *bufio.Reader, budget *int) (string, error) {
+	var buf []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(buf)+len(chunk) > *budget {
+			return "", errResponseTooLong
+		}
+		if err != bufio.ErrBufferFull {
+			*budget -= len(buf) + len(chunk)
+			if buf == nil {
+				// The whole line is in the reader's buffer: copy it once
+				return string(chunk), err
+			}
+			return string(append(buf, chunk...)), err
+		}
+		buf = append(buf, chunk...)
+	}
+}
+
+// unpadCR removes the NUL that pads a CR inside a reply line, as in a
+// pathname (RFC 2640 Section 3.1).
+func unpadCR(line string) string {
+	if strings.IndexByte(line, '\r') < 0 {
+		return line
+	}
+	return strings.ReplaceAll(line, "\r\x00", "\r")
+}
+
 // readResponse reads a complete FTP response from the reader.
 // It handles both single-line and multi-line responses.
 //
@@ -59,48 +126,56 @@ func (r *Response) String() string {
 // The response is complete when a line starts with the code followed by a space.
 func readResponse(r *bufio.Reader) (*Response, error) {
 	// Read the first line
-	line, err := r.ReadString('\n')
+	budget := maxResponseSize
+	line, err := readLine(r, &budget)
 	if err != nil {
 		return nil, err
 	}
 
-	line = strings.TrimRight(line, "\r\n")
+	line = unpadCR(strings.TrimRight(line, "\r\n"))
 	if len(line) < 4 {
 		return nil, fmt.Errorf("invalid response line: %q", line)
 	}
 
+	// Atoi accepts signs, as in "-12"; codes are three digits
 	code, err := strconv.Atoi(line[0:3])
-	if err != nil {
+	if err != nil || line[0] < '0' || line[0] > '9' {
 		return nil, fmt.Errorf("invalid response code: %q", line[0:3])
 	}
 
-	lines := []string{line}
-
 	// Optimization for common single-line response
 	if line[3] == ' ' {
-		return &Response{
+		resp := &Response{
 			Code:    code,
 			Message: line[4:],
-			Lines:   lines,
-		}, nil
+			line:    [1]string{line},
+		}
+		resp.Lines = resp.line[:]
+		return resp, nil
 	}
 
+	lines := []string{line}
+
 	// Multi-line response must start with '-'
 	if line[3] != '-' {
 		return nil, fmt.Errorf("invalid response format: %q", line)
 	}
 
 	// Read remaining lines
-	if err := readMultiLine(r, code, &lines); err != nil {
+	if err := readMultiLine(r, code, &lines, &budget); err != nil {
 		return nil, err
 	}
 
-	// Build the message
-	var messageLines []string
+	// Build the message, keeping every line: the text after the code of
+	// "230-" style lines, and other lines (such as RFC 2389 continuations
+	// starting with a space) as they are
+	codeStr := line[0:3]
+	messageLines := make([]string, 0, len(lines))
 	for _, l := range lines {
-		if len(l) > 4 {
-			messageLines = append(messageLines, l[4:])
+		if strings.HasPrefix(l, codeStr) && len(l) >= 4 && (l[3] == '-' || l[3] == ' ') {
+			l = l[4:]
 		}
+		messageLines = append(messageLines, l)
 	}
 
 	return &Response{
@@ -110,11 +185,13 @@ func readResponse(r *bufio.Reader) (*Response, error) {
 	}, nil
 }
 
-func readMultiLine(r *bufio.Reader, code int, lines *[]string) error {
+// readMultiLine reads the lines of a multi-line response after the first
+// one, using up to budget bytes.
+func readMultiLine(r *bufio.Reader, code int, lines *[]string, budget *int) error {
 	codeStr := fmt.Sprintf("%03d", code)
 
 	for {
-		line, err := r.ReadString('\n')
+		line, err := readLine(r, budget)
 		if err != nil {
 			if err == io.EOF && len(*lines) > 0 {
 				return fmt.Errorf("unexpected EOF reading response")
@@ -122,7 +199,7 @@ func readMultiLine(r *bufio.Reader, code int, lines *[]string) error {
 			return err
 		}
 
-		line = strings.TrimRight(line, "\r\n")
+		line = unpadCR(strings.TrimRight(line, "\r\n"))
 
 		// Check for RFC 2389 continuation (starts with space)
 		if len(line) > 0 && line[0] == ' ' {
@@ -149,26 +226,73 @@ func readMultiLine(r *bufio.Reader, code int, lines *[]string) error {
 
 // sendCommand sends an FTP command and returns the response.
 func (c *Client) sendCommand(command string, args ...string) (*Response, error) {
-	// Build the full command
-	var cmd string
-	if len(args) > 0 {
-		cmd = fmt.Sprintf("%s %s", command, strings.Join(args, " "))
-	} else {
-		cmd = command
+	// Lock the client to prevent concurrent commands
+	c.mu.Lock()
+	resp, err := c.sendCommandLocked(command, args...)
+	c.mu.Unlock()
+
+	if c.autoReconnect && connectionLost(resp, err) {
+		return c.reconnectAndRetry(command, args, resp, err)
+	}
+	return resp, err
+}
+
+// sendCommandLocked sends an FTP command and returns the response.
+// c.mu must be held.
+func (c *Client) sendCommandLocked(command string, args ...string) (*Response, error) {
+	if err := c.writeCommandLocked(command, args...); err != nil {
+		return nil, err
+	}
+
+	return c.readResponseLocked(c.timeout)
+}
+
+// writeCommand sends an FTP command without waiting for its reply.
+// The caller is responsible for reading the reply with readReply.
+func (c *Client) writeCommand(command string, args ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeCommandLocked(command, args...)
+}
+
+// readReply reads the next reply from the control connection.
+// A zero timeout waits for the reply indefinitely.
+func (c *Client) readReply(timeout time.Duration) (*Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readResponseLocked(timeout)
+}
+
+// writeCommandLocked writes a command to the control connection.
+// c.mu must be held.
+func (c *Client) writeCommandLocked(command string, args ...string) error {
+	if err := checkArgs(args); err != nil {
+		return err
+	}
+
+	// Build the command line in the reusable buffer
+	line := append(c.cmdBuf[:0], command...)
+	for _, arg := range args {
+		line = append(line, ' ')
+		line = append(line, arg...)
 	}
 
 	// Log if debug is enabled
-	if c.logger != nil {
-		altCmd := cmd
-		if strings.HasPrefix(cmd, "PASS ") {
+	if c.debugEnabled() {
+		altCmd := string(line)
+		if strings.HasPrefix(altCmd, "PASS ") {
 			altCmd = "PASS xxxx"
 		}
 		c.logger.Debug("ftp command", "cmd", altCmd)
 	}
 
-	// Lock the client to prevent concurrent commands
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	if c.filenameEncoding != nil || bytes.ContainsAny(line, "\xff\r") {
+		line = append(line[:0], telnetEscaper.Replace(c.encodeName(string(line)))...)
+	}
+	line = append(line, "\r\n"...)
+	if cap(line) <= maxCmdBuf {
+		c.cmdBuf = line
+	}
 
 	// Update last command time
 	c.lastCommand = time.Now()
@@ -176,22 +300,81 @@ func (c *Client) sendCommand(command string, args ...string) (*Response, error)
 	// Set write deadline
 	if c.timeout > 0 {
 		if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
-			return nil, fmt.Errorf("failed to set write deadline: %w", err)
+			return fmt.Errorf("failed to set write deadline: %w", err)
 		}
 	}
 
 	// Send the command
-	_, err := fmt.Fprintf(c.conn, "%s\r\n", cmd)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send command: %w", err)
+	if _, err := c.conn.Write(line); err != nil {
+		return fmt.Errorf("failed to send command: %w", err)
 	}
 
+	c.sent = append(c.sent, sentCommand{name: strings.ToUpper(command), sent: c.lastCommand})
+	return nil
+}
+
+// maxCmdBuf is the largest command buffer kept for reuse.
+const maxCmdBuf = 4096
+
+// telnetEscaper escapes a command line for the Telnet protocol used by the
+// control connection: 0xFF bytes, which legacy encodings produce, are sent
+// as IAC IAC (RFC 854), and CR as CR NUL (RFC 2640 Section 3.1).
+var telnetEscaper = strings.NewReplacer("\xff", "\xff\xff", "\r", "\r\x00")
+
+// checkArgs fails with ErrLineFeed if an argument cannot be sent.
+func checkArgs(args []string) error {
+	for _, arg := range args {
+		if strings.ContainsRune(arg, '\n') {
+			return ErrLineFeed
+		}
+	}
+	return nil
+}
+
+// writeKeepAliveLocked sends a keep-alive NOOP, whose response is not
+// recorded as the last response.
+// c.mu must be held.
+func (c *Client) writeKeepAliveLocked() error {
+	if err := c.writeCommandLocked("NOOP"); err != nil {
+		return err
+	}
+	c.sent[len(c.sent)-1].keepAlive = true
+	return nil
+}
+
+// recordResponseLocked matches resp with the command at index i of the
+// commands awaiting a response, sets its Duration and reports it to the
+// observer. The command is done unless resp is a preliminary (1xx) response.
+// c.mu must be held.
+func (c *Client) recordResponseLocked(resp *Response, i int) {
+	var cmd sentCommand
+	if i >= 0 && i < len(c.sent) {
+		cmd = c.sent[i]
+		resp.Duration = time.Since(cmd.sent)
+		if resp.Code >= 200 {
+			c.sent = slices.Delete(c.sent, i, i+1)
+		}
+	}
+
+	if !cmd.keepAlive {
+		c.lastResponse = resp
+	}
+	if c.responseObserver != nil {
+		c.responseObserver(cmd.name, resp)
+	}
+}
+
+// readResponseLocked reads a reply from the control connection.
+// c.mu must be held.
+func (c *Client) readResponseLocked(timeout time.Duration) (*Response, error) {
 	// Set read deadline for response
 	// Note: We set it on the underlying connection, not the bufio Reader
-	if c.timeout > 0 {
-		if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
-			return nil, fmt.Errorf("failed to set read deadline: %w", err)
-		}
+	deadline := time.Time{}
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("failed to set read deadline: %w", err)
 	}
 
 	// Read the response
@@ -200,11 +383,20 @@ func (c *Client) sendCommand(command string, args ...string) (*Response, error)
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
+	// Decode names in replies such as PWD and MLST
+	if c.filenameEncoding != nil {
+		resp.Message = c.decodeName(resp.Message)
+		for i, line := range resp.Lines {
+			resp.Lines[i] = c.decodeName(line)
+		}
+	}
+
 	// Log the response if debug is enabled
-	if c.logger != nil {
+	if c.debugEnabled() {
 		c.logger.Debug("ftp response", "code", resp.Code, "message", resp.Message)
 	}
 
+	c.recordResponseLocked(resp, 0)
 	return resp, nil
 }
 
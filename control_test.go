@@ -2,8 +2,17 @@ package ftp
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp/ftptest"
 )
 
 func TestReadResponse_SingleLine(t *testing.T) {
@@ -36,6 +45,18 @@ func TestReadResponse_SingleLine(t *testing.T) {
 			wantMsg:  "",
 			wantErr:  false,
 		},
+		{
+			name:     "padded CR in pathname",
+			input:    "257 \"a\r\x00b\" created\r\n",
+			wantCode: 257,
+			wantMsg:  "\"a\rb\" created",
+			wantErr:  false,
+		},
+		{
+			name:    "signed code",
+			input:   "-12 Negative\r\n",
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -78,6 +99,15 @@ func TestReadResponse_MultiLine(t *testing.T) {
 			wantMsg:  "Welcome to FTP\nThis is line 2\nReady",
 			wantErr:  false,
 		},
+		{
+			name: "padded CR in continuation",
+			input: "250- Listing follows\r\n" +
+				" type=file; cr\r\x00.txt\r\n" +
+				"250 End\r\n",
+			wantCode: 250,
+			wantMsg:  " Listing follows\n type=file; cr\r.txt\nEnd",
+			wantErr:  false,
+		},
 		{
 			name: "transfer complete",
 			input: "226-Transfer complete\r\n" +
@@ -86,6 +116,15 @@ func TestReadResponse_MultiLine(t *testing.T) {
 			wantMsg:  "Transfer complete\nClosing data connection",
 			wantErr:  false,
 		},
+		{
+			name: "blank and indented lines",
+			input: "230-Welcome\r\n" +
+				"230-\r\n" +
+				" Quota: 10 MB used\r\n" +
+				"230 Logged in\r\n",
+			wantCode: 230,
+			wantMsg:  "Welcome\n\n Quota: 10 MB used\nLogged in",
+		},
 	}
 
 	for _, tt := range tests {
@@ -130,6 +169,30 @@ func TestParsePASV(t *testing.T) {
 			wantAddr: "10.0.0.5:20020",
 			wantErr:  false,
 		},
+		{
+			name:     "PASV without parentheses",
+			input:    "227 Entering Passive Mode 10,0,0,5,78,52",
+			wantAddr: "10.0.0.5:20020",
+			wantErr:  false,
+		},
+		{
+			name:     "PASV without parentheses after an equals sign",
+			input:    "227 Entering Passive Mode =10,0,0,5,78,52.",
+			wantAddr: "10.0.0.5:20020",
+			wantErr:  false,
+		},
+		{
+			name:     "PASV without parentheses after other numbers",
+			input:    "227 Passive mode 1,2,3,4,5,6 closed, use 10,0,0,5,78,52",
+			wantAddr: "10.0.0.5:20020",
+			wantErr:  false,
+		},
+		{
+			name:     "PASV parentheses take precedence",
+			input:    "227 Entering Passive Mode (10,0,0,5,78,52) 1,2,3,4,5,6",
+			wantAddr: "10.0.0.5:20020",
+			wantErr:  false,
+		},
 		{
 			name:     "invalid PASV response",
 			input:    "227 Invalid response",
@@ -270,6 +333,34 @@ func TestProtocolError(t *testing.T) {
 	}
 }
 
+func TestReadResponse_TooLong(t *testing.T) {
+	t.Parallel()
+	lines := strings.Repeat("211-"+strings.Repeat("x", 1000)+"\r\n", maxResponseSize/1000)
+	for _, input := range []string{
+		lines + "211 End\r\n",
+		"220 " + strings.Repeat("x", maxResponseSize) + "\r\n",
+	} {
+		reader := bufio.NewReader(strings.NewReader(input))
+		if _, err := readResponse(reader); !errors.Is(err, errResponseTooLong) {
+			t.Errorf("readResponse() error = %v, want %v", err, errResponseTooLong)
+		}
+	}
+}
+
+func TestReadResponse_LongLine(t *testing.T) {
+	t.Parallel()
+	// Longer than the reader's buffer, so the line is read in chunks
+	long := strings.Repeat("x", 10000)
+	reader := bufio.NewReaderSize(strings.NewReader("257 "+long+"\r\n200 OK\r\n"), 16)
+	resp, err := readResponse(reader)
+	if err != nil || resp.Code != 257 || resp.Message != long {
+		t.Fatalf("readResponse() = %v, %v", resp, err)
+	}
+	if resp, err := readResponse(reader); err != nil || resp.Code != 200 || len(resp.Lines) != 1 {
+		t.Errorf("second readResponse() = %v, %v", resp, err)
+	}
+}
+
 func TestReadResponse_RFC2389(t *testing.T) {
 	t.Parallel()
 	// Example from RFC 2389 - feature lines start with space
@@ -293,3 +384,91 @@ func TestReadResponse_RFC2389(t *testing.T) {
 		t.Errorf("expected 6 lines, got %d", len(resp.Lines))
 	}
 }
+
+func TestLastResponseAndObserver(t *testing.T) {
+	t.Parallel()
+	ms := newMockServer(t)
+
+	dataL, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms.dataListener = dataL
+	_, portStr, _ := net.SplitHostPort(dataL.Addr().String())
+
+	ms.handlers["DELE"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("250-Quota warning: 95%% used.")
+		_ = c.PrintfLine("250 File deleted.")
+	}
+	ms.handlers["EPSV"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("229 Entering Extended Passive Mode (|||%s|)", portStr)
+	}
+	ms.handlers["RETR"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("150 Opening data connection.")
+		dconn, err := dataL.Accept()
+		if err != nil {
+			return
+		}
+		// 15 bytes at 150 B/s take 100ms
+		slow := ftptest.Link{Bandwidth: 150}.Conn(dconn)
+		_, _ = slow.Write([]byte(strings.Repeat("chunk", 3)))
+		slow.Close()
+		_ = c.PrintfLine("226 Transfer complete.")
+	}
+	ms.handlers["NOOP"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("200 NOOP ok.")
+	}
+	ms.start()
+	t.Cleanup(ms.stop)
+
+	var observed []string
+	c, err := Dial(ms.addr, WithTimeout(time.Second), WithTransferKeepAlive(20*time.Millisecond),
+		WithResponseObserver(func(cmd string, resp *Response) {
+			observed = append(observed, fmt.Sprintf("%s %d", cmd, resp.Code))
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Quit() })
+	if resp := c.LastResponse(); resp == nil || resp.Code != 220 {
+		t.Errorf("LastResponse after Dial = %v", resp)
+	}
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Delete("file"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	resp := c.LastResponse()
+	if resp.Code != 250 || resp.Message != "Quota warning: 95% used.\nFile deleted." || len(resp.Lines) != 2 {
+		t.Errorf("LastResponse after Delete = %+v", resp)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Retrieve("file", &buf); err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	resp = c.LastResponse()
+	if resp.Code != 226 || resp.Duration < 90*time.Millisecond {
+		t.Errorf("LastResponse after Retrieve = %+v", resp)
+	}
+
+	want := []string{" 220", "USER 331", "PASS 230", "DELE 250", "TYPE 200", "EPSV 229", "RETR 150"}
+	if len(observed) < len(want) || !slices.Equal(observed[:len(want)], want) {
+		t.Fatalf("observed = %q, want prefix %q", observed, want)
+	}
+	var noops int
+	for _, o := range observed[len(want):] {
+		switch o {
+		case "NOOP 200":
+			noops++
+		case "RETR 226":
+		default:
+			t.Errorf("unexpected response %q", o)
+		}
+	}
+	if noops == 0 || len(observed) != len(want)+noops+1 {
+		t.Errorf("observed = %q", observed)
+	}
+}
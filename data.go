@@ -4,25 +4,38 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"math/rand/v2"
 	"net"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/gonzalop/ftp/internal/blockmode"
 )
 
 var (
 	// pasvRegex matches the PASV response format: 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
 	pasvRegex = regexp.MustCompile(`\((\d+),(\d+),(\d+),(\d+),(\d+),(\d+)\)`)
 
-	// epsvRegex matches the EPSV response format: 229 Entering Extended Passive Mode (|||port|)
-	epsvRegex = regexp.MustCompile(`\(\|\|\|(\d+)\|\)`)
+	// pasvBareRegex matches the address without parentheses, as some servers
+	// send it (RFC 1123 Section 4.1.2.6): "227 Entering Passive Mode h1,h2,h3,h4,p1,p2"
+	pasvBareRegex = regexp.MustCompile(`(\d+),(\d+),(\d+),(\d+),(\d+),(\d+)`)
 )
 
 // parsePASV parses a PASV response and returns the host and port.
 // Example: "227 Entering Passive Mode (192,168,1,1,195,149)"
 // Returns: "192.168.1.1:50069" (195*256 + 149 = 50069)
+//
+// Without parentheses, the last group of six numbers is the address, as
+// the text before it may contain numbers too.
 func parsePASV(response string) (string, error) {
 	matches := pasvRegex.FindStringSubmatch(response)
+	if matches == nil {
+		if all := pasvBareRegex.FindAllStringSubmatch(response, -1); len(all) > 0 {
+			matches = all[len(all)-1]
+		}
+	}
 	if len(matches) != 7 {
 		return "", fmt.Errorf("invalid PASV response: %s", response)
 	}
@@ -56,17 +69,29 @@ func parsePASV(response string) (string, error) {
 // Example: "229 Entering Extended Passive Mode (|||6446|)"
 // Returns: "6446"
 func parseEPSV(response string) (string, error) {
-	matches := epsvRegex.FindStringSubmatch(response)
-	if len(matches) != 2 {
-		return "", fmt.Errorf("invalid EPSV response: %s", response)
-	}
+	// Find the first "(|||port|)"; scanning saves the allocations of a
+	// regexp match on every transfer
+	for rest := response; ; {
+		i := strings.Index(rest, "(|||")
+		if i < 0 {
+			return "", fmt.Errorf("invalid EPSV response: %s", response)
+		}
+		rest = rest[i+4:]
+		n := 0
+		for n < len(rest) && rest[n] >= '0' && rest[n] <= '9' {
+			n++
+		}
+		if n == 0 || !strings.HasPrefix(rest[n:], "|)") {
+			continue
+		}
 
-	port, err := strconv.Atoi(matches[1])
-	if err != nil || port < 0 || port > 65535 {
-		return "", fmt.Errorf("invalid EPSV port: %s", matches[1])
+		portStr := rest[:n]
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 0 || port > 65535 {
+			return "", fmt.Errorf("invalid EPSV port: %s", portStr)
+		}
+		return portStr, nil
 	}
-
-	return matches[1], nil
 }
 
 // formatPORT formats an address for the PORT command.
@@ -119,6 +144,14 @@ func resolveDataAddr(pasvAddr, controlHost string) string {
 // openDataConn opens a data connection using either active (PORT) or passive (PASV/EPSV) mode.
 // If TLS is enabled, the data connection will use TLS with session reuse.
 func (c *Client) openDataConn() (net.Conn, error) {
+	if c.useBlockMode() {
+		return c.openBlockDataConn()
+	}
+	return c.newDataConn()
+}
+
+// newDataConn opens a new data connection in the configured mode.
+func (c *Client) newDataConn() (net.Conn, error) {
 	if c.activeMode {
 		return c.openActiveDataConn()
 	}
@@ -164,72 +197,108 @@ func (c *Client) openActiveDataConn() (net.Conn, error) {
 		host = "127.0.0.1" // Fallback
 	}
 
-	// Listen on a random port on the same interface
-	listener, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
-	if err != nil {
-		// Fallback to all interfaces if listening on specific IP fails
-		listener, err = net.Listen("tcp", ":0")
+	var listener net.Listener
+	if c.activePortMax > 0 {
+		// Listen on a port of the configured range. Behind NAT the router
+		// forwards these ports, so listen on all interfaces.
+		listener, err = listenInRange(c.activePortMin, c.activePortMax)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Listen on a random port on the same interface
+		listener, err = net.Listen("tcp", net.JoinHostPort(host, "0"))
 		if err != nil {
-			return nil, fmt.Errorf("failed to create listener: %w", err)
+			// Fallback to all interfaces if listening on specific IP fails
+			listener, err = net.Listen("tcp", ":0")
+			if err != nil {
+				return nil, fmt.Errorf("failed to create listener: %w", err)
+			}
 		}
 	}
 
-	// Get the local address
-	addr := listener.Addr().String()
+	// Advertise the configured public address, or the control connection's
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	if c.activeAddress != nil {
+		host = c.activeAddress.String()
+	}
+	addr := net.JoinHostPort(host, port)
 
-	// Parse local address to determine protocol version
-	localHost, _, err := net.SplitHostPort(addr)
-	if err != nil {
+	if err := c.sendPort(addr); err != nil {
+		listener.Close()
 		return nil, err
 	}
-	ip := net.ParseIP(localHost)
+
+	// Accept the connection from the server
+	// Note: The actual connection happens after we send the transfer command (RETR, STOR, etc.)
+	// So we return a wrapper that will accept when needed
+	return &activeDataConn{
+		listener:  listener,
+		tlsConfig: c.dataTLSConfig(),
+		timeout:   c.timeout,
+	}, nil
+}
+
+// listenInRange listens on the first free port of the range min-max,
+// starting at a random port so concurrent transfers rarely collide.
+func listenInRange(min, max int) (net.Listener, error) {
+	n := max - min + 1
+	start := rand.IntN(n)
+	var err error
+	for i := range n {
+		port := min + (start+i)%n
+		var listener net.Listener
+		listener, err = net.Listen("tcp", ":"+strconv.Itoa(port))
+		if err == nil {
+			return listener, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to create listener in port range %d-%d: %w", min, max, err)
+}
+
+// sendPort tells the server to connect to addr for the next transfer.
+// It uses PORT for IPv4 addresses and EPRT for IPv6 addresses.
+func (c *Client) sendPort(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
 	if ip == nil {
-		return nil, fmt.Errorf("failed to parse local IP: %s", localHost)
+		return fmt.Errorf("failed to parse IP: %s", host)
 	}
 
-	var resp *Response
-	var cmd string
+	var cmd, arg string
 
 	// Use EPRT if IPv6, or stick to PORT for IPv4 (unless configured otherwise in future)
 	// We could use EPRT for IPv4 too, but PORT is more widely supported by legacy servers.
 	if ip.To4() == nil {
 		// IPv6 requires EPRT
 		cmd = "EPRT"
-		eprtCmd, err2 := formatEPRT(addr)
-		if err2 != nil {
-			return nil, fmt.Errorf("failed to format EPRT command: %w", err2)
-		}
-		resp, err = c.sendCommand("EPRT", eprtCmd)
+		arg, err = formatEPRT(addr)
 	} else {
 		// IPv4 uses PORT
 		cmd = "PORT"
-		portCmd, err2 := formatPORT(addr)
-		if err2 != nil {
-			return nil, fmt.Errorf("failed to format PORT command: %w", err2)
-		}
-		resp, err = c.sendCommand("PORT", portCmd)
+		arg, err = formatPORT(addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to format %s command: %w", cmd, err)
 	}
 
+	resp, err := c.sendCommand(cmd, arg)
 	if err != nil {
-		return nil, fmt.Errorf("%s failed: %w", cmd, err)
+		return fmt.Errorf("%s failed: %w", cmd, err)
 	}
 
 	if !resp.Is2xx() {
-		return nil, &ProtocolError{
+		return &ProtocolError{
 			Command:  cmd,
 			Response: resp.Message,
 			Code:     resp.Code,
 		}
 	}
 
-	// Accept the connection from the server
-	// Note: The actual connection happens after we send the transfer command (RETR, STOR, etc.)
-	// So we return a wrapper that will accept when needed
-	return &activeDataConn{
-		listener:  listener,
-		tlsConfig: c.tlsConfig,
-		timeout:   c.timeout,
-	}, nil
+	return nil
 }
 
 // activeDataConn wraps a listener for active mode connections.
@@ -250,21 +319,22 @@ func (a *activeDataConn) accept() error {
 	if err != nil {
 		return err
 	}
-	a.conn = c
 
 	// Wrap in TLS if needed
 	if a.tlsConfig != nil {
-		tlsConn := tls.Server(a.conn, a.tlsConfig)
+		tlsConn := tls.Server(c, a.tlsConfig)
 		// Set deadline for handshake
 		if a.timeout > 0 {
-			_ = a.conn.SetDeadline(time.Now().Add(a.timeout))
+			_ = c.SetDeadline(time.Now().Add(a.timeout))
 		}
 		if err := tlsConn.Handshake(); err != nil {
-			a.conn.Close()
+			c.Close()
 			return err
 		}
-		a.conn = tlsConn
+		_ = c.SetDeadline(time.Time{})
+		c = tlsConn
 	}
+	a.conn = &deadlineConn{Conn: c, timeout: a.timeout}
 	return nil
 }
 
@@ -274,9 +344,6 @@ func (a *activeDataConn) Read(p []byte) (n int, err error) {
 			return 0, err
 		}
 	}
-	if a.timeout > 0 {
-		_ = a.conn.SetReadDeadline(time.Now().Add(a.timeout))
-	}
 	return a.conn.Read(p)
 }
 
@@ -286,9 +353,6 @@ func (a *activeDataConn) Write(p []byte) (n int, err error) {
 			return 0, err
 		}
 	}
-	if a.timeout > 0 {
-		_ = a.conn.SetWriteDeadline(time.Now().Add(a.timeout))
-	}
 	return a.conn.Write(p)
 }
 
@@ -343,92 +407,164 @@ func (a *activeDataConn) SetWriteDeadline(t time.Time) error {
 
 // openPassiveDataConn opens a data connection using passive mode (PASV/EPSV).
 // This is the default and recommended mode.
+//
+// PASV only carries IPv4 addresses, so on IPv6 control connections EPSV is
+// tried even when disabled, unless the server rejected it. If the server
+// then offers an IPv4 PASV address, the data port is dialed both on the
+// control connection's host and on that address (see dialDataRace).
 func (c *Client) openPassiveDataConn() (net.Conn, error) {
+	var dataConn net.Conn
+	var epsvUnreachable bool
+	ipv6 := c.controlIPv6()
+
 	// Try EPSV first (supports IPv6), fall back to PASV
-	var addr string
-
-	// Try EPSV
-	if !c.disableEPSV {
-		if resp, err := c.sendCommand("EPSV"); err == nil {
-			if resp.Code == 502 { // 502 = Not implemented
-				c.disableEPSV = true
-			} else if resp.Is2xx() {
-				port, parseErr := parseEPSV(resp.String())
-				if parseErr == nil {
-					// Use the same host as the control connection
-					addr = net.JoinHostPort(c.host, port)
-				}
+	if !c.quirks.DisableEPSV || (ipv6 && !c.epsvRejected) {
+		if addr := c.epsvAddr(); addr != "" {
+			conn, err := c.dialData(addr)
+			if err == nil {
+				dataConn = conn
+			} else {
+				epsvUnreachable = true
 			}
 		}
 	}
 
 	// Fall back to PASV if EPSV failed
-	if addr == "" {
-		resp, err := c.sendCommand("PASV")
+	if dataConn == nil {
+		addr, err := c.pasvAddr()
 		if err != nil {
-			return nil, fmt.Errorf("PASV failed: %w", err)
-		}
-
-		if !resp.Is2xx() {
-			return nil, &ProtocolError{
-				Command:  "PASV",
-				Response: resp.Message,
-				Code:     resp.Code,
+			if ipv6 {
+				return nil, &AddressFamilyError{ControlAddr: c.conn.RemoteAddr().String(), Err: err}
 			}
+			return nil, err
 		}
-
-		addr, err = parsePASV(resp.String())
+		addrs := []string{addr}
+		if ipv6 {
+			addrs = c.pasvCandidates(addr)
+		}
+		dataConn, err = c.dialDataRace(addrs)
 		if err != nil {
-			return nil, err
+			if ipv6 {
+				return nil, &AddressFamilyError{ControlAddr: c.conn.RemoteAddr().String(), DataAddr: addr, Err: err}
+			}
+			return nil, fmt.Errorf("failed to connect to data port: %w", err)
+		}
+		if epsvUnreachable {
+			// The EPSV data port is unreachable but the PASV one is not
+			c.quirks.DisableEPSV = true
+			c.detectedQuirk("DisableEPSV")
 		}
+	}
 
-		// If the server sends 0.0.0.0, we use the control connection address.
-		addr = resolveDataAddr(addr, c.host)
+	// If TLS is enabled, wrap the data connection. Servers such as vsftpd
+	// only start the handshake once they have the transfer command, which is
+	// sent after this returns, so the handshake runs in the background;
+	// reads and writes wait for it, and fail if it does.
+	if config := c.dataTLSConfig(); config != nil {
+		tlsConn := tls.Client(dataConn, config)
+		go func() {
+			if tlsConn.Handshake() == nil {
+				c.recordTLSHandshake(tlsConn)
+			}
+		}()
+		dataConn = tlsConn
 	}
 
-	// Connect to the data port
-	var dataConn net.Conn
-	var err error
+	// Wrap with deadline connection if timeout is set
+	if c.timeout > 0 {
+		return &deadlineConn{Conn: dataConn, timeout: c.timeout}, nil
+	}
 
-	if c.customDialer != nil {
-		// Use custom dialer with context
-		ctx := context.Background()
-		if c.timeout > 0 {
-			var cancel context.CancelFunc
-			ctx, cancel = context.WithTimeout(ctx, c.timeout)
-			defer cancel()
-		}
-		dataConn, err = c.customDialer.DialContext(ctx, "tcp", addr)
-	} else {
-		// Use standard dialer
-		dataConn, err = c.dialer.Dial("tcp", addr)
+	return dataConn, nil
+}
+
+// epsvAddr sends EPSV and returns the address of the data port, or "" if
+// EPSV failed. The data port is on the control connection's host.
+func (c *Client) epsvAddr() string {
+	resp, err := c.sendCommand("EPSV")
+	if err != nil {
+		return ""
+	}
+	if resp.Code == 502 { // 502 = Not implemented
+		c.quirks.DisableEPSV = true
+		c.epsvRejected = true
+		c.detectedQuirk("DisableEPSV")
+		return ""
+	}
+	if !resp.Is2xx() {
+		c.epsvRejected = resp.Code >= 500
+		return ""
+	}
+	port, err := parseEPSV(resp.String())
+	if err != nil {
+		return ""
 	}
+	return net.JoinHostPort(c.host, port)
+}
 
+// pasvAddr sends PASV and returns the address of the data port.
+func (c *Client) pasvAddr() (string, error) {
+	resp, err := c.sendCommand("PASV")
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to data port: %w", err)
+		return "", fmt.Errorf("PASV failed: %w", err)
 	}
 
-	// If TLS is enabled, wrap the data connection
-	if c.tlsConfig != nil {
-		tlsConn := tls.Client(dataConn, c.tlsConfig)
-		if err := tlsConn.Handshake(); err != nil {
-			dataConn.Close()
-			return nil, fmt.Errorf("data connection TLS handshake failed: %w", err)
+	if !resp.Is2xx() {
+		return "", &ProtocolError{
+			Command:  "PASV",
+			Response: resp.Message,
+			Code:     resp.Code,
 		}
-		dataConn = tlsConn
 	}
 
-	// Wrap with deadline connection if timeout is set
-	if c.timeout > 0 {
-		return &deadlineConn{Conn: dataConn, timeout: c.timeout}, nil
+	addr, err := parsePASV(resp.String())
+	if err != nil {
+		return "", err
 	}
 
-	return dataConn, nil
+	host, port, _ := net.SplitHostPort(addr)
+	if !c.quirks.IgnorePASVAddress && c.unreachablePASVAddress(net.ParseIP(host)) {
+		c.quirks.IgnorePASVAddress = true
+		c.detectedQuirk("IgnorePASVAddress")
+	}
+	if c.quirks.IgnorePASVAddress {
+		// Keep only the port; the address may be private (NAT)
+		return net.JoinHostPort(c.host, port), nil
+	}
+	// If the server sends 0.0.0.0, we use the control connection address.
+	return resolveDataAddr(addr, c.host), nil
+}
+
+// dialData opens the TCP (or custom transport) connection to a data port.
+func (c *Client) dialData(addr string) (net.Conn, error) {
+	dialer := c.customDialer
+	if dialer == nil {
+		dialer = c.connDialer
+	}
+	if dialer == nil && c.proxyURL == nil {
+		// Use standard dialer
+		return c.dialer.Dial("tcp", addr)
+	}
+
+	ctx := context.Background()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	if dialer == nil {
+		return c.dialProxy(ctx, addr)
+	}
+	// Use custom dialer with context
+	return dialer.DialContext(ctx, "tcp", addr)
 }
 
 // cmdDataConnFrom executes a command that requires a data connection.
 // It opens the data connection, sends the command, and returns the response and data connection.
 // The caller is responsible for closing the data connection and reading the final response.
+//
+// The response is the preliminary reply (125 or 150), or the final 2xx reply
+// for servers that send it first; finishDataConn handles both.
 func (c *Client) cmdDataConnFrom(cmd string, args ...string) (*Response, net.Conn, error) {
 	// Open the data connection first
 	dataConn, err := c.openDataConn()
@@ -444,57 +580,79 @@ func (c *Client) cmdDataConnFrom(cmd string, args ...string) (*Response, net.Con
 	// Send the command
 	resp, err := c.sendCommand(cmd, args...)
 	if err != nil {
-		dataConn.Close()
+		blockmode.Interrupt(dataConn)
 		c.mu.Lock()
 		c.activeDataConn = nil
 		c.mu.Unlock()
 		return nil, nil, err
 	}
 
-	// Check for preliminary success (1xx) or immediate success (2xx)
-	if !resp.Is2xx() && !resp.Is3xx() && resp.Code < 100 || resp.Code >= 200 {
-		// For data transfer commands, we expect:
-		// - 1xx (preliminary positive) - transfer starting
-		// - 2xx (positive completion) - already done
-		// We'll be lenient and accept both
-		if resp.Code < 100 || resp.Code >= 400 {
-			dataConn.Close()
-			c.mu.Lock()
-			c.activeDataConn = nil
-			c.mu.Unlock()
-			return resp, nil, &ProtocolError{
-				Command:  cmd,
-				Response: resp.Message,
-				Code:     resp.Code,
-			}
+	switch {
+	case resp.Is1xx():
+		// 125 (connection already open) or 150 (opening connection); the
+		// final reply follows the transfer
+	case resp.Is2xx():
+		// Some servers skip the preliminary reply and complete the
+		// transfer at once (e.g. an empty listing). finishDataConn must
+		// not wait for another reply.
+		c.mu.Lock()
+		c.transferReply = resp
+		c.mu.Unlock()
+	default:
+		// A block mode connection is not kept: the server drops its end
+		// when the next transfer sets up a new one
+		blockmode.Interrupt(dataConn)
+		c.mu.Lock()
+		c.activeDataConn = nil
+		c.mu.Unlock()
+		return resp, nil, &ProtocolError{
+			Command:  cmd,
+			Response: resp.Message,
+			Code:     resp.Code,
 		}
 	}
 
+	c.startTransferKeepAlive()
+
 	return resp, dataConn, nil
 }
 
 // finishDataConn closes the data connection and reads the final response.
 // This should be called after the data transfer is complete.
 func (c *Client) finishDataConn(dataConn net.Conn) error {
+	_, err := c.finishDataConnReply(dataConn)
+	return err
+}
+
+// finishDataConnReply is finishDataConn, also returning the final reply.
+func (c *Client) finishDataConnReply(dataConn net.Conn) (*Response, error) {
+	// Stop the keep-alive NOOPs; their replies are read with the final one
+	noops := c.stopTransferKeepAlive()
+
 	// Close the data connection
 	if err := dataConn.Close(); err != nil {
-		return fmt.Errorf("failed to close data connection: %w", err)
+		return nil, fmt.Errorf("failed to close data connection: %w", err)
 	}
 
 	// Set read deadline for the final response
 	if c.timeout > 0 {
 		if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
-			return fmt.Errorf("failed to set read deadline: %w", err)
+			return nil, fmt.Errorf("failed to set read deadline: %w", err)
 		}
 	}
 
-	// Read the final response (should be 226 Transfer complete)
-	resp, err := readResponse(c.reader)
+	// Read the final response (should be 226 Transfer complete), unless
+	// it came in place of the preliminary one
+	c.mu.Lock()
+	early := c.transferReply
+	c.transferReply = nil
+	c.mu.Unlock()
+	resp, err := c.readTransferReply(c.reader, early, noops)
 	if err != nil {
-		return fmt.Errorf("failed to read completion response: %w", err)
+		return nil, fmt.Errorf("failed to read completion response: %w", err)
 	}
 
-	if c.logger != nil {
+	if c.debugEnabled() {
 		c.logger.Debug("ftp data transfer complete", "code", resp.Code, "message", resp.Message)
 	}
 
@@ -504,12 +662,12 @@ func (c *Client) finishDataConn(dataConn net.Conn) error {
 	c.mu.Unlock()
 
 	if !resp.Is2xx() {
-		return &ProtocolError{
+		return resp, &ProtocolError{
 			Command:  "DATA_TRANSFER",
 			Response: resp.Message,
 			Code:     resp.Code,
 		}
 	}
 
-	return nil
+	return resp, nil
 }
@@ -1,7 +1,11 @@
 package ftp
 
 import (
+	"fmt"
 	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -143,3 +147,227 @@ func TestActiveDataConn_Coverage(t *testing.T) {
 
 	<-done
 }
+
+func TestIgnorePASVAddress(t *testing.T) {
+	t.Parallel()
+	ms := newMockServer(t)
+	dataL, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dataL.Close()
+	port := dataL.Addr().(*net.TCPAddr).Port
+
+	// The server advertises an unreachable (private) address
+	ms.handlers["PASV"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("227 Entering Passive Mode (192,0,2,1,%d,%d)", port/256, port%256)
+	}
+	ms.handlers["NLST"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("150 Opening data connection.")
+		dconn, err := dataL.Accept()
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(dconn, "file.txt\r\n")
+		dconn.Close()
+		_ = c.PrintfLine("226 Transfer complete.")
+	}
+	ms.start()
+	defer ms.stop()
+
+	c, err := Dial(ms.addr, WithTimeout(time.Second), WithDisableEPSV(), WithIgnorePASVAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Quit() }()
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := c.NameList(".")
+	if err != nil {
+		t.Fatalf("NameList failed: %v", err)
+	}
+	if len(names) != 1 || names[0] != "file.txt" {
+		t.Errorf("NameList = %v", names)
+	}
+}
+
+func TestActivePortRangeAndAddress(t *testing.T) {
+	t.Parallel()
+	// Find a free port for the range
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	ms := newMockServer(t)
+	portArgs := make(chan string, 1)
+	ms.handlers["PORT"] = func(c *textproto.Conn, args string) {
+		portArgs <- args
+		_ = c.PrintfLine("200 PORT command successful.")
+	}
+	ms.handlers["NLST"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("150 Opening data connection.")
+		// The advertised address is the public one; connect locally
+		dconn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			_ = c.PrintfLine("425 Can't open data connection.")
+			return
+		}
+		fmt.Fprintf(dconn, "file.txt\r\n")
+		dconn.Close()
+		_ = c.PrintfLine("226 Transfer complete.")
+	}
+	ms.start()
+	defer ms.stop()
+
+	c, err := Dial(ms.addr, WithTimeout(time.Second), WithActiveMode(),
+		WithActivePortRange(port, port), WithActiveAddress("203.0.113.5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Quit() }()
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.NameList("."); err != nil {
+		t.Fatalf("NameList failed: %v", err)
+	}
+	want := fmt.Sprintf("203,0,113,5,%d,%d", port/256, port%256)
+	if got := <-portArgs; got != want {
+		t.Errorf("PORT %s, want %s", got, want)
+	}
+}
+
+func TestActiveOptionsValidation(t *testing.T) {
+	t.Parallel()
+	c := &Client{}
+	for _, opt := range []Option{
+		WithActivePortRange(0, 100),
+		WithActivePortRange(2000, 1000),
+		WithActivePortRange(1000, 70000),
+		WithActiveAddress("not-an-ip"),
+	} {
+		if err := opt(c); err == nil {
+			t.Error("Expected error for invalid option")
+		}
+	}
+}
+
+// TestTransferReplyOrderings checks the reply orderings servers use for a
+// transfer: the control connection must stay in sync after each.
+func TestTransferReplyOrderings(t *testing.T) {
+	t.Parallel()
+	const data = "hello, world"
+
+	// Each script answers RETR; dconn is the accepted data connection.
+	tests := []struct {
+		name    string
+		script  func(c *textproto.Conn, dconn net.Conn)
+		wantErr bool
+	}{
+		{"150 then 226", func(c *textproto.Conn, dconn net.Conn) {
+			_ = c.PrintfLine("150 Opening data connection.")
+			_, _ = dconn.Write([]byte(data))
+			dconn.Close()
+			_ = c.PrintfLine("226 Transfer complete.")
+		}, false},
+		{"125 then 226", func(c *textproto.Conn, dconn net.Conn) {
+			_ = c.PrintfLine("125 Data connection already open; transfer starting.")
+			_, _ = dconn.Write([]byte(data))
+			dconn.Close()
+			_ = c.PrintfLine("226 Transfer complete.")
+		}, false},
+		{"150 then 250", func(c *textproto.Conn, dconn net.Conn) {
+			_ = c.PrintfLine("150 Opening data connection.")
+			_, _ = dconn.Write([]byte(data))
+			dconn.Close()
+			_ = c.PrintfLine("250 Requested file action okay, completed.")
+		}, false},
+		{"226 before the data", func(c *textproto.Conn, dconn net.Conn) {
+			_ = c.PrintfLine("150 Opening data connection.")
+			_ = c.PrintfLine("226 Transfer complete.")
+			_, _ = dconn.Write([]byte(data))
+			dconn.Close()
+		}, false},
+		{"150 and 226 in one read", func(c *textproto.Conn, dconn net.Conn) {
+			_, _ = dconn.Write([]byte(data))
+			dconn.Close()
+			_, _ = c.W.WriteString("150 Opening data connection.\r\n226 Transfer complete.\r\n")
+			_ = c.W.Flush()
+		}, false},
+		{"226 without 1xx", func(c *textproto.Conn, dconn net.Conn) {
+			_, _ = dconn.Write([]byte(data))
+			dconn.Close()
+			_ = c.PrintfLine("226 Transfer complete.")
+		}, false},
+		{"150 then 451", func(c *textproto.Conn, dconn net.Conn) {
+			_ = c.PrintfLine("150 Opening data connection.")
+			dconn.Close()
+			_ = c.PrintfLine("451 Local error in processing.")
+		}, true},
+		{"550 without 1xx", func(c *textproto.Conn, dconn net.Conn) {
+			dconn.Close()
+			_ = c.PrintfLine("550 No such file.")
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ms := newMockServer(t)
+			dataL, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ms.dataListener = dataL
+			_, portStr, _ := net.SplitHostPort(dataL.Addr().String())
+
+			ms.handlers["EPSV"] = func(c *textproto.Conn, args string) {
+				_ = c.PrintfLine("229 Entering Extended Passive Mode (|||%s|)", portStr)
+			}
+			ms.handlers["RETR"] = func(c *textproto.Conn, args string) {
+				dconn, err := dataL.Accept()
+				if err != nil {
+					return
+				}
+				tt.script(c, dconn)
+			}
+			ms.handlers["PWD"] = func(c *textproto.Conn, args string) {
+				_ = c.PrintfLine(`257 "/" is the current directory.`)
+			}
+			ms.start()
+			t.Cleanup(ms.stop)
+
+			c, err := Dial(ms.addr, WithTimeout(time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = c.Quit() })
+			if err := c.Login("anonymous", "anonymous"); err != nil {
+				t.Fatal(err)
+			}
+
+			var buf strings.Builder
+			err = c.Retrieve("file.txt", &buf)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Retrieve succeeded, want error")
+				}
+			} else if err != nil {
+				t.Errorf("Retrieve failed: %v", err)
+			} else if buf.String() != data {
+				t.Errorf("Retrieve = %q, want %q", buf.String(), data)
+			}
+
+			// The next command gets its own reply.
+			if dir, err := c.CurrentDir(); err != nil || dir != "/" {
+				t.Errorf("CurrentDir() = %q, %v after the transfer", dir, err)
+			}
+		})
+	}
+}
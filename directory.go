@@ -2,14 +2,18 @@ package ftp
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"iter"
 	"log/slog"
 	"net"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -46,16 +50,17 @@ func (c *Client) Walk(root string, walkFn WalkFunc) error {
 	// We try to list the parent to find the root entry.
 	var rootEntry *Entry
 	// Handle root cases
-	cleanRoot := path.Clean(root)
-	if cleanRoot == "." || cleanRoot == "/" {
+	paths := c.remotePaths()
+	cleanRoot := paths.clean(root)
+	if isRoot(paths, cleanRoot) {
 		rootEntry = &Entry{
 			Name: cleanRoot,
 			Type: "dir",
 		}
 	} else {
 		// List parent to find root
-		parent := path.Dir(cleanRoot)
-		if parent == "." && !strings.Contains(cleanRoot, "/") {
+		parent := paths.dir(cleanRoot)
+		if parent == "." {
 			parent = "" // Use current working directory
 		}
 		entries, err := c.List(parent)
@@ -66,7 +71,7 @@ func (c *Client) Walk(root string, walkFn WalkFunc) error {
 			// We'll call walkFn with the error.
 			return walkFn(root, nil, err)
 		}
-		targetName := path.Base(cleanRoot)
+		targetName := paths.base(cleanRoot)
 		for _, e := range entries {
 			if e.Name == targetName {
 				rootEntry = e
@@ -79,10 +84,10 @@ func (c *Client) Walk(root string, walkFn WalkFunc) error {
 		}
 	}
 
-	return c.walk(cleanRoot, rootEntry, walkFn)
+	return c.walk(paths, cleanRoot, rootEntry, walkFn)
 }
 
-func (c *Client) walk(pathStr string, info *Entry, walkFn WalkFunc) error {
+func (c *Client) walk(paths pathSyntax, pathStr string, info *Entry, walkFn WalkFunc) error {
 	err := walkFn(pathStr, info, nil)
 	if err != nil {
 		if info != nil && info.Type == "dir" && err == SkipDir {
@@ -108,8 +113,8 @@ func (c *Client) walk(pathStr string, info *Entry, walkFn WalkFunc) error {
 			continue
 		}
 
-		fullPath := path.Join(pathStr, entry.Name)
-		if err := c.walk(fullPath, entry, walkFn); err != nil {
+		fullPath := paths.join(pathStr, entry.Name, entry.Type == "dir")
+		if err := c.walk(paths, fullPath, entry, walkFn); err != nil {
 			if err == SkipDir {
 				// Skip directory requested by one of the children?
 				// No, SkipDir from child only skips that child directory.
@@ -126,11 +131,12 @@ func (c *Client) walk(pathStr string, info *Entry, walkFn WalkFunc) error {
 
 // Entry represents a file or directory entry from a LIST command.
 type Entry struct {
-	Name   string
-	Type   string // "file", "dir", or "link"
-	Size   int64
-	Target string // For symlinks, the target path (empty for files/dirs)
-	Raw    string // The raw line from the LIST command
+	Name    string
+	Type    string // "file", "dir", or "link"
+	Size    int64
+	Target  string    // For symlinks, the target path (empty for files/dirs)
+	Raw     string    // The raw line from the LIST command
+	ModTime time.Time // Modification time, set by Stat (zero in LIST entries)
 }
 
 // List returns a list of files and directories in the specified path.
@@ -141,8 +147,11 @@ type Entry struct {
 //   - Unix-style (9-field): perms links owner group size month day time/year name
 //   - Unix-style (8-field): perms links owner size month day time/year name (no group)
 //   - Unix-style (numeric): 644 links owner group size month day time/year name
-//   - DOS/Windows: MM-DD-YY HH:MMAM/PM size|<DIR> filename
+//   - DOS/Windows: MM-DD-YY HH:MMAM/PM size|<DIR>|<JUNCTION> filename
 //   - EPLF: +facts\tname or +facts name
+//   - VMS: NAME.EXT;version blocks DD-MMM-YYYY HH:MM:SS [owner] (protection)
+//   - IBM MVS: dataset listings and PDS member listings
+//   - NetWare: type [rights] owner size month day time/year name
 //
 // For standardized, machine-readable listings, use MLList instead (requires MLSD support).
 //
@@ -159,41 +168,102 @@ type Entry struct {
 //	    }
 //	}
 func (c *Client) List(path string) ([]*Entry, error) {
-	// Open data connection and send LIST command
-	var dataConn net.Conn
-	var err error
-
-	if path == "" {
-		_, dataConn, err = c.cmdDataConnFrom("LIST")
-	} else {
-		_, dataConn, err = c.cmdDataConnFrom("LIST", path)
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	// Read the directory listing
 	var entries []*Entry
-	scanner := bufio.NewScanner(dataConn)
-	for scanner.Scan() {
-		line := scanner.Text()
-		entry := parseListLine(line, c.parsers)
-		if entry != nil {
-			entries = append(entries, entry)
+	for entry, err := range c.ListIter(path) {
+		if err != nil {
+			return nil, err
 		}
+		entries = append(entries, entry)
 	}
+	return entries, nil
+}
 
-	if err := scanner.Err(); err != nil {
-		dataConn.Close()
-		return nil, fmt.Errorf("failed to read directory listing: %w", err)
-	}
+// ListIter returns an iterator over the entries of a LIST directory listing.
+// Entries are yielded as they are parsed off the data connection, so huge
+// directories can be processed without holding the whole listing in memory.
+// Entries are parsed as described for List.
+//
+// If the listing fails, the iterator yields a single non-nil error and stops.
+// Breaking out of the loop early closes the data connection and discards the
+// rest of the listing. Each range over the iterator sends a new LIST command.
+//
+// Other commands must not be sent on the client while iterating, since the
+// transfer is still in progress on the control connection.
+//
+// Example:
+//
+//	for entry, err := range client.ListIter("/pub") {
+//	    if err != nil {
+//	        log.Fatal(err)
+//	    }
+//	    fmt.Printf("%s: %d bytes\n", entry.Name, entry.Size)
+//	}
+func (c *Client) ListIter(path string) iter.Seq2[*Entry, error] {
+	return func(yield func(*Entry, error) bool) {
+		// Resolve the parser chain first, as it may send SYST
+		parsers := c.listingParsers()
+
+		dataConn, err := c.listDataConn("LIST", path)
+		if err != nil && c.nameListFallback && notImplemented(err) {
+			entries, err := c.listFromNames(path)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, entry := range entries {
+				if !yield(entry, nil) {
+					return
+				}
+			}
+			return
+		}
+		if err != nil {
+			yield(nil, err)
+			return
+		}
 
-	// Finish the data connection
-	if err := c.finishDataConn(dataConn); err != nil {
-		return nil, err
+		buf := listBufPool.Get().(*[4096]byte)
+		defer listBufPool.Put(buf)
+		scanner := bufio.NewScanner(dataConn)
+		scanner.Buffer(buf[:], bufio.MaxScanTokenSize)
+		for scanner.Scan() {
+			line := c.decodeName(scanner.Text())
+			entry := parseListLine(line, parsers)
+			if entry == nil {
+				continue
+			}
+			if !yield(entry, nil) {
+				// Stopped early: the server reports the aborted transfer
+				_ = c.finishDataConn(dataConn)
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			dataConn.Close()
+			yield(nil, fmt.Errorf("failed to read directory listing: %w", err))
+			return
+		}
+
+		// Finish the data connection
+		if err := c.finishDataConn(dataConn); err != nil {
+			yield(nil, err)
+		}
 	}
+}
 
-	return entries, nil
+// listDataConn sends a listing command, with path as argument if it is not
+// empty, and returns the data connection to read the listing from.
+func (c *Client) listDataConn(cmd, path string) (net.Conn, error) {
+	var dataConn net.Conn
+	var err error
+
+	if path == "" {
+		_, dataConn, err = c.cmdDataConnFrom(cmd)
+	} else {
+		_, dataConn, err = c.cmdDataConnFrom(cmd, path)
+	}
+	return dataConn, err
 }
 
 // ListingParser is an interface for parsing directory listing entries.
@@ -201,11 +271,29 @@ type ListingParser interface {
 	Parse(line string) (*Entry, bool)
 }
 
+// listBufPool holds the buffers of the scanners reading listings, so that
+// listing many directories does not allocate a buffer for each. Scanners
+// grow past the buffer for longer lines.
+var listBufPool = sync.Pool{New: func() any { return new([4096]byte) }}
+
+// maxListFields is the number of fields listing parsers keep on the stack.
+const maxListFields = 16
+
+// listFields splits line like strings.Fields, appending the fields to buf.
+// Parsers pass a stack array, saving an allocation per listing line.
+func listFields(buf []string, line string) []string {
+	for field := range strings.FieldsSeq(line) {
+		buf = append(buf, field)
+	}
+	return buf
+}
+
 // UnixParser parses Unix-style directory entries.
 type UnixParser struct{}
 
 func (p *UnixParser) Parse(line string) (*Entry, bool) {
-	fields := strings.Fields(line)
+	var buf [maxListFields]string
+	fields := listFields(buf[:0], line)
 	// Supports both 9-field and 8-field formats (and numeric perms)
 	if len(fields) < 8 {
 		return nil, false
@@ -221,7 +309,8 @@ func (p *UnixParser) Parse(line string) (*Entry, bool) {
 type DOSParser struct{}
 
 func (p *DOSParser) Parse(line string) (*Entry, bool) {
-	fields := strings.Fields(line)
+	var buf [maxListFields]string
+	fields := listFields(buf[:0], line)
 	if len(fields) < 4 {
 		return nil, false
 	}
@@ -255,8 +344,9 @@ type CompositeParser struct {
 }
 
 func (p *CompositeParser) Parse(line string) *Entry {
-	trimmed := strings.TrimSpace(line)
-	if trimmed == "" {
+	// Trailing spaces may belong to the file name
+	trimmed := strings.TrimLeft(line, " \t")
+	if strings.TrimSpace(trimmed) == "" {
 		if line != "" {
 			slog.Debug("Skipping whitespace-only line", "raw", line)
 		}
@@ -278,14 +368,23 @@ func (p *CompositeParser) Parse(line string) *Entry {
 	}
 }
 
+// defaultListingParsers returns the built-in parsers, in the order they are
+// tried. Stricter formats come first so they are not misread as Unix entries.
+func defaultListingParsers() []ListingParser {
+	return []ListingParser{
+		&EPLFParser{},
+		&DOSParser{},
+		&VMSParser{},
+		&MVSParser{},
+		&NetWareParser{},
+		&UnixParser{},
+	}
+}
+
 // parseListLine parses a single line using registered parsers.
 func parseListLine(line string, parsers []ListingParser) *Entry {
 	if len(parsers) == 0 {
-		parsers = []ListingParser{
-			&EPLFParser{},
-			&DOSParser{},
-			&UnixParser{},
-		}
+		parsers = defaultListingParsers()
 	}
 	parser := &CompositeParser{
 		Parsers: parsers,
@@ -382,7 +481,12 @@ func parseUnixLayout(fields []string) (sizeIdx, nameStartIdx int, ok bool) {
 }
 
 func parseUnixName(entry *Entry, fields []string, nameStartIdx int) {
-	fullName := strings.Join(fields[nameStartIdx:], " ")
+	// Take the name from the line, as it may contain runs of spaces or
+	// start or end with spaces
+	fullName := afterFields(entry.Raw, nameStartIdx)
+	if fullName == "" {
+		fullName = strings.Join(fields[nameStartIdx:], " ")
+	}
 
 	if entry.Type == "link" {
 		if before, after, ok := strings.Cut(fullName, " -> "); ok {
@@ -399,6 +503,25 @@ func parseUnixName(entry *Entry, fields []string, nameStartIdx int) {
 	}
 }
 
+// afterFields returns the rest of line after n whitespace-separated fields
+// and the single space that follows them.
+func afterFields(line string, n int) string {
+	isSpace := func(b byte) bool { return b == ' ' || b == '\t' }
+	i := 0
+	for range n {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		for i < len(line) && !isSpace(line[i]) {
+			i++
+		}
+	}
+	if i < len(line) {
+		i++
+	}
+	return line[i:]
+}
+
 // parseEPLFEntry parses an EPLF (Easily Parsed LIST Format) entry.
 // Format: +facts\tname or +facts name
 // Facts are comma-separated, e.g.: i=inode, m=mtime, s=size, /, r, etc.
@@ -501,32 +624,50 @@ func parseDOSEntry(entry *Entry, fields []string) bool {
 	// DOS format: date time size-or-<DIR> filename...
 	// Example: "12-14-23  12:22PM           1037794 large-document.pdf"
 	// Example: "09-24-24  10:30AM       <DIR>          logger"
+	// Example: "01/15/2024  09:12 AM    <JUNCTION>     Application Data [C:\Users\me\AppData]"
 
-	if len(fields) < 4 {
+	// Windows "dir" output separates AM/PM from the time
+	sizeIdx := 2
+	if len(fields) > 3 && (strings.EqualFold(fields[2], "AM") || strings.EqualFold(fields[2], "PM")) {
+		sizeIdx = 3
+	}
+
+	if len(fields) < sizeIdx+2 {
 		return false
 	}
+	name := strings.Join(fields[sizeIdx+1:], " ")
 
-	// Check if it's a directory
-	if fields[2] == "<DIR>" {
+	switch fields[sizeIdx] {
+	case "<DIR>":
 		entry.Type = "dir"
 		entry.Size = 0
-		entry.Name = strings.Join(fields[3:], " ")
+		entry.Name = name
+		return true
+	case "<JUNCTION>", "<SYMLINKD>", "<SYMLINK>":
+		// Reparse points show their target in brackets after the name
+		entry.Type = "link"
+		entry.Size = 0
+		entry.Name = name
+		if open := strings.LastIndex(name, " ["); open != -1 && strings.HasSuffix(name, "]") {
+			entry.Name = name[:open]
+			entry.Target = name[open+2 : len(name)-1]
+		}
 		return true
 	}
 
-	// It's a file - parse the size
-	size, err := parseSize(fields[2])
+	// It's a file - parse the size (which may use thousands separators)
+	size, err := parseSize(strings.ReplaceAll(fields[sizeIdx], ",", ""))
 	if err != nil {
 		slog.Debug("Failed to parse size in DOS format",
 			"raw", entry.Raw,
-			"size_field", fields[2],
+			"size_field", fields[sizeIdx],
 			"error", err)
 		return false
 	}
 
 	entry.Type = "file"
 	entry.Size = size
-	entry.Name = strings.Join(fields[3:], " ")
+	entry.Name = name
 	return true
 }
 
@@ -553,9 +694,12 @@ func (c *Client) NameList(path string) ([]string, error) {
 
 	// Read the name list
 	var names []string
+	buf := listBufPool.Get().(*[4096]byte)
+	defer listBufPool.Put(buf)
 	scanner := bufio.NewScanner(dataConn)
+	scanner.Buffer(buf[:], bufio.MaxScanTokenSize)
 	for scanner.Scan() {
-		name := strings.TrimSpace(scanner.Text())
+		name := strings.TrimSpace(c.decodeName(scanner.Text()))
 		if name != "" {
 			names = append(names, name)
 		}
@@ -574,17 +718,68 @@ func (c *Client) NameList(path string) ([]string, error) {
 	return names, nil
 }
 
+// listFromNames lists dir with NLST for servers without LIST. Each name is
+// probed with CWD to find directories, and with SIZE to get the size of
+// files. The working directory is restored after each successful CWD.
+func (c *Client) listFromNames(dir string) ([]*Entry, error) {
+	names, err := c.NameList(dir)
+	if err != nil {
+		return nil, err
+	}
+	wd, err := c.CurrentDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(names))
+	for _, name := range names {
+		// Some servers return paths rather than names
+		p := name
+		if !strings.Contains(name, "/") {
+			p = path.Join(dir, name)
+		}
+		entry := &Entry{Name: path.Base(name), Type: "file", Raw: name}
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+
+		if c.ChangeDir(p) == nil {
+			entry.Type = "dir"
+			if err := c.ChangeDir(wd); err != nil {
+				return nil, err
+			}
+		} else if size, err := c.Size(p); err == nil {
+			entry.Size = size
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// notImplemented reports whether err is a reply saying that a command is
+// not recognized or not implemented.
+func notImplemented(err error) bool {
+	var pe *ProtocolError
+	return errors.As(err, &pe) && (pe.Code == 500 || pe.Code == 502)
+}
+
 // ChangeDir changes the current working directory.
 func (c *Client) ChangeDir(path string) error {
-	_, err := c.expect2xx("CWD", path)
-	return err
+	if _, err := c.expect2xx("CWD", path); err != nil {
+		return err
+	}
+	c.rememberDir()
+	return nil
 }
 
 // ChangeDirToParent changes the current working directory to the parent directory.
 // This implements the CDUP command.
 func (c *Client) ChangeDirToParent() error {
-	_, err := c.expect2xx("CDUP")
-	return err
+	if _, err := c.expect2xx("CDUP"); err != nil {
+		return err
+	}
+	c.rememberDir()
+	return nil
 }
 
 // CurrentDir returns the current working directory.
@@ -594,19 +789,37 @@ func (c *Client) CurrentDir() (string, error) {
 		return "", err
 	}
 
-	// Parse the directory from the response
-	// Example: 257 "/home/user" is the current directory
-	msg := resp.Message
-	start := strings.Index(msg, "\"")
-	if start == -1 {
-		return "", fmt.Errorf("invalid PWD response: %s", msg)
+	dir, ok := parsePathname(resp.Message)
+	if !ok {
+		return "", fmt.Errorf("invalid PWD response: %s", resp.Message)
 	}
-	end := strings.Index(msg[start+1:], "\"")
-	if end == -1 {
-		return "", fmt.Errorf("invalid PWD response: %s", msg)
+	return dir, nil
+}
+
+// parsePathname extracts the quoted pathname of a 257 reply, such as
+// `"/home/user" is the current directory`. Embedded double quotes are
+// doubled (RFC 959 Appendix II). The CR NUL of a CR (RFC 2640 Section 3.1)
+// has already been decoded by readResponse.
+func parsePathname(msg string) (string, bool) {
+	start := strings.IndexByte(msg, '"')
+	if start == -1 {
+		return "", false
 	}
 
-	return msg[start+1 : start+1+end], nil
+	var b strings.Builder
+	for i := start + 1; i < len(msg); i++ {
+		if msg[i] != '"' {
+			b.WriteByte(msg[i])
+			continue
+		}
+		if i+1 < len(msg) && msg[i+1] == '"' {
+			b.WriteByte('"')
+			i++
+			continue
+		}
+		return b.String(), true
+	}
+	return "", false
 }
 
 // MakeDir creates a new directory.
@@ -615,6 +828,53 @@ func (c *Client) MakeDir(path string) error {
 	return err
 }
 
+// MkdirAll creates a directory and any missing parents, like os.MkdirAll.
+// Directories that already exist, including ones created concurrently by
+// another client, are not an error. An existing file in the way is.
+//
+// FTP has no recursive MKD, so missing parents are created one at a time,
+// checking with Stat whether a refused MKD means the directory exists.
+//
+// Example:
+//
+//	err := client.MkdirAll("/backups/2024/06")
+func (c *Client) MkdirAll(dirPath string) error {
+	paths := c.remotePaths()
+	dirPath = paths.clean(dirPath)
+	if isRoot(paths, dirPath) {
+		return nil
+	}
+
+	err := c.MakeDir(dirPath)
+	if err == nil {
+		return nil
+	}
+	var pe *ProtocolError
+	if !errors.As(err, &pe) {
+		return err // Not a refusal from the server
+	}
+	if c.isExistingDir(dirPath) {
+		return nil
+	}
+
+	// A parent may be missing
+	if parent := paths.dir(dirPath); parent != dirPath {
+		if err := c.MkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := c.MakeDir(dirPath); err != nil && !c.isExistingDir(dirPath) {
+		return err
+	}
+	return nil
+}
+
+// isExistingDir reports whether dirPath is known to be a directory.
+func (c *Client) isExistingDir(dirPath string) bool {
+	isDir, err := c.IsDir(dirPath)
+	return err == nil && isDir
+}
+
 // RemoveDir removes a directory.
 func (c *Client) RemoveDir(path string) error {
 	_, err := c.expect2xx("RMD", path)
@@ -706,13 +966,87 @@ func (c *Client) Rename(from, to string) error {
 }
 
 // Size returns the size of a file in bytes.
+//
+// Some servers refuse SIZE in ASCII mode. When that is detected (or the
+// SizeNeedsBinary quirk is set), binary mode is selected for the SIZE command
+// and the previous transfer type is restored afterwards.
 func (c *Client) Size(path string) (int64, error) {
+	if c.quirks.SizeNeedsBinary && c.currentType != "I" {
+		return c.binarySize(path)
+	}
+
+	resp, err := c.expect2xx("SIZE", path)
+	if err != nil {
+		var pe *ProtocolError
+		if c.currentType != "I" && errors.As(err, &pe) && pe.Code == 550 &&
+			strings.Contains(strings.ToUpper(pe.Response), "ASCII") {
+			c.quirks.SizeNeedsBinary = true
+			c.detectedQuirk("SizeNeedsBinary")
+			return c.binarySize(path)
+		}
+		return 0, err
+	}
+
+	return parseSizeResponse(resp)
+}
+
+// binarySize sends SIZE in binary mode, then restores the previous transfer
+// type.
+func (c *Client) binarySize(path string) (int64, error) {
+	previous := c.currentType
+	if err := c.Type("I"); err != nil {
+		return 0, err
+	}
+
 	resp, err := c.expect2xx("SIZE", path)
+	if previous != "" {
+		if typeErr := c.Type(previous); err == nil {
+			err = typeErr
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return parseSizeResponse(resp)
+}
+
+// Avbl returns the number of bytes available for uploads in a directory, using
+// the AVBL command (draft-peterson-streamlined-ftp-command-extensions). An
+// empty path queries the current directory. Check Capabilities().AVBL first;
+// servers reply 502 if they cannot report free space.
+//
+// Example:
+//
+//	free, err := client.Avbl("/uploads")
+func (c *Client) Avbl(path string) (int64, error) {
+	var args []string
+	if path != "" {
+		args = append(args, path)
+	}
+
+	resp, err := c.sendCommand("AVBL", args...)
 	if err != nil {
 		return 0, err
 	}
 
-	// Parse the size from the response
+	if resp.Code != 213 {
+		return 0, &ProtocolError{
+			Command:  "AVBL",
+			Response: resp.Message,
+			Code:     resp.Code,
+		}
+	}
+
+	var free int64
+	if _, err := fmt.Sscanf(resp.Message, "%d", &free); err != nil {
+		return 0, fmt.Errorf("invalid AVBL response: %s", resp.Message)
+	}
+	return free, nil
+}
+
+// parseSizeResponse parses the file size from a successful SIZE reply.
+func parseSizeResponse(resp *Response) (int64, error) {
 	var size int64
 	_, parseErr := fmt.Sscanf(resp.Message, "%d", &size)
 	if parseErr != nil {
@@ -738,7 +1072,11 @@ func (c *Client) ModTime(path string) (time.Time, error) {
 		return time.Time{}, err
 	}
 
-	// Parse the timestamp from the response
+	return parseMDTMResponse(resp)
+}
+
+// parseMDTMResponse parses the timestamp from a successful MDTM reply.
+func parseMDTMResponse(resp *Response) (time.Time, error) {
 	// Format: YYYYMMDDHHMMSS (e.g., "20231220143000" for Dec 20, 2023 14:30:00)
 	timestamp := strings.TrimSpace(resp.Message)
 	if len(timestamp) != 14 {
@@ -781,3 +1119,96 @@ func (c *Client) Chmod(path string, mode os.FileMode) error {
 	_, err := c.expect2xx("SITE", "CHMOD", octalMode, path)
 	return err
 }
+
+// EntryFacts are the facts of a file changed by SetEntryFacts. Zero fields
+// are left unchanged.
+type EntryFacts struct {
+	ModTime    time.Time   // Modify: the modification time
+	CreateTime time.Time   // Create: the creation time
+	Mode       os.FileMode // UNIX.mode: the permission bits; use Chmod to clear them all
+	Owner      string      // UNIX.owner: a user name or numeric ID
+	Group      string      // UNIX.group: a group name or numeric ID
+}
+
+// mff returns the facts of f in the format of the MFF command, such as
+// "Modify=20240101120000;UNIX.mode=0644;".
+func (f EntryFacts) mff() string {
+	var b strings.Builder
+	if !f.ModTime.IsZero() {
+		b.WriteString("Modify=" + f.ModTime.UTC().Format("20060102150405") + ";")
+	}
+	if !f.CreateTime.IsZero() {
+		b.WriteString("Create=" + f.CreateTime.UTC().Format("20060102150405") + ";")
+	}
+	if f.Mode&os.ModePerm != 0 {
+		fmt.Fprintf(&b, "UNIX.mode=%04o;", f.Mode&os.ModePerm)
+	}
+	if f.Owner != "" {
+		b.WriteString("UNIX.owner=" + f.Owner + ";")
+	}
+	if f.Group != "" {
+		b.WriteString("UNIX.group=" + f.Group + ";")
+	}
+	return b.String()
+}
+
+// SetEntryFacts changes several facts of a file in one round trip, using the
+// MFF command (draft-somers-ftp-mfxx). Times are converted to UTC. Servers
+// reject the whole command if they cannot change one of the facts;
+// Capabilities lists the facts a server supports in MFFFacts.
+//
+// Example:
+//
+//	err := client.SetEntryFacts("report.pdf", ftp.EntryFacts{
+//	    ModTime: modTime,
+//	    Mode:    0644,
+//	})
+func (c *Client) SetEntryFacts(path string, facts EntryFacts) error {
+	f := facts.mff()
+	if f == "" {
+		return fmt.Errorf("no facts to set for %s", path)
+	}
+	_, err := c.expect2xx("MFF", f, path)
+	return err
+}
+
+// Chown changes the owner of a file, given as a user name or numeric ID. It
+// uses MFF when the server lists the UNIX.owner fact in FEAT, and the SITE
+// CHOWN command otherwise.
+//
+// Example:
+//
+//	err := client.Chown("shared/report.pdf", "alice")
+func (c *Client) Chown(path, owner string) error {
+	if c.canModifyFact("UNIX.owner") {
+		return c.SetEntryFacts(path, EntryFacts{Owner: owner})
+	}
+	// SITE CHOWN <owner> <path>
+	_, err := c.expect2xx("SITE", "CHOWN", owner, path)
+	return err
+}
+
+// Chgrp changes the group of a file, given as a group name or numeric ID.
+// It uses MFF when the server lists the UNIX.group fact in FEAT, and the
+// SITE CHGRP command otherwise.
+//
+// Example:
+//
+//	err := client.Chgrp("shared/report.pdf", "staff")
+func (c *Client) Chgrp(path, group string) error {
+	if c.canModifyFact("UNIX.group") {
+		return c.SetEntryFacts(path, EntryFacts{Group: group})
+	}
+	// SITE CHGRP <group> <path>
+	_, err := c.expect2xx("SITE", "CHGRP", group, path)
+	return err
+}
+
+// canModifyFact reports whether the server lists fact in its MFF feature.
+func (c *Client) canModifyFact(fact string) bool {
+	caps, err := c.Capabilities()
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(caps.MFFFacts, func(f string) bool { return strings.EqualFold(f, fact) })
+}
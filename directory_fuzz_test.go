@@ -12,9 +12,31 @@ func FuzzParseListLine(f *testing.F) {
 	f.Add("12-14-23  12:22PM           1037794 large-document.pdf")
 	f.Add("+i8388621.48594,m825718503,r,s280,\tdjb.html")
 	f.Add("+/,m824255907\tdata")
+	f.Add("01/15/2024  09:12 AM    <JUNCTION>     Application Data [C:\\Users\\me\\AppData]")
+	f.Add("FILE.TXT;1                 3/4  12-JUN-2023 10:15:32  [GROUP,OWNER]  (RWED,RWED,RE,)")
+	f.Add("WYOSPT 3390   2023/01/12  1   15  FB      80  3120  PO  ADMIN.CNTL")
+	f.Add("d [RWCEAFMS] supervisor            512 Jan 16 18:53 login")
 
 	f.Fuzz(func(t *testing.T, line string) {
 		// Just ensure it doesn't panic
 		_ = parseListLine(line, nil)
 	})
 }
+
+func FuzzParseMLEntry(f *testing.F) {
+	f.Add("type=file;size=1024;modify=20231220103000;perm=rw; file.txt")
+	f.Add("type=dir;modify=20231220103000.123; My Documents")
+	f.Add("Type=OS.unix=slink:/target;UNIX.mode=0777; link")
+	f.Add("size=-5;;=;a==b; x")
+	f.Add("no-space")
+
+	f.Fuzz(func(t *testing.T, line string) {
+		entry, err := parseMLEntry(line)
+		if err != nil {
+			return
+		}
+		if entry.Facts == nil {
+			t.Fatalf("parseMLEntry(%q): nil facts", line)
+		}
+	})
+}
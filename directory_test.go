@@ -254,3 +254,24 @@ func TestCustomParser(t *testing.T) {
 		t.Errorf("Expected custom, got %s", entry.Name)
 	}
 }
+
+func TestParsePathname(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg  string
+		want string
+		ok   bool
+	}{
+		{`"/home/user" is the current directory.`, "/home/user", true},
+		{`"/say ""hi""" created.`, `/say "hi"`, true},
+		{`"" is odd`, "", true},
+		{`"/unterminated`, "", false},
+		{`no quotes`, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := parsePathname(tt.msg)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parsePathname(%q) = %q, %v; want %q, %v", tt.msg, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,553 @@
+package ftp
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SymlinkPolicy selects how UploadDirWithOptions and DownloadDirWithOptions
+// handle symbolic links.
+type SymlinkPolicy int
+
+const (
+	// SymlinkSkip ignores symbolic links. This is the default.
+	SymlinkSkip SymlinkPolicy = iota
+
+	// SymlinkFollow transfers the targets of links as if they were regular
+	// files and directories. Links that would loop back into one of their
+	// parent directories are skipped.
+	SymlinkFollow
+
+	// SymlinkMaterialize recreates links at the destination: with SITE SYMLINK
+	// on the server when uploading, and with os.Symlink when downloading.
+	// Only relative links pointing inside the transferred tree are recreated;
+	// others are skipped.
+	SymlinkMaterialize
+)
+
+// OverwritePolicy selects what UploadDirWithOptions and
+// DownloadDirWithOptions do with files that already exist at the destination.
+type OverwritePolicy int
+
+const (
+	// OverwriteAlways transfers every file. This is the default.
+	OverwriteAlways OverwritePolicy = iota
+
+	// OverwriteIfNewer transfers a file only if the source was modified after
+	// the destination. Remote modification times come from MLST or MDTM;
+	// files are transferred if they cannot be determined.
+	OverwriteIfNewer
+
+	// OverwriteIfSizeDiffers transfers a file only if its size differs from
+	// the destination's.
+	OverwriteIfSizeDiffers
+
+	// OverwriteNever never replaces existing files.
+	OverwriteNever
+)
+
+// Reasons reported in DirFileEvent.Reason for skipped files.
+const (
+	SkipFiltered  = "filtered"   // Excluded, or not matched by Include
+	SkipSymlink   = "symlink"    // A link skipped by the SymlinkPolicy
+	SkipExists    = "exists"     // The destination exists (OverwriteNever)
+	SkipNotNewer  = "not newer"  // The source is not newer (OverwriteIfNewer)
+	SkipSameSize  = "same size"  // The sizes match (OverwriteIfSizeDiffers)
+	SkipLinkLoop  = "link loop"  // Following the link would loop
+	SkipBadTarget = "bad target" // The link target is unknown or outside the tree
+)
+
+// DirFileEvent reports what happened to one file during
+// UploadDirWithOptions or DownloadDirWithOptions.
+type DirFileEvent struct {
+	// Path is the file's path relative to the transferred directory, with
+	// forward slashes.
+	Path string
+
+	// Size is the size of the source file in bytes, if known
+	Size int64
+
+	// Skipped is true if the file was not transferred
+	Skipped bool
+
+	// Reason tells why the file was skipped (one of the Skip constants)
+	Reason string
+}
+
+// DirOptions configures UploadDirWithOptions and DownloadDirWithOptions.
+// The zero value transfers every file and skips symbolic links, like
+// UploadDir and DownloadDir.
+type DirOptions struct {
+	// Include, if set, restricts the transfer to files matching one of these
+	// patterns. Directories are always walked unless excluded.
+	Include []string
+
+	// Exclude skips files and directories matching one of these patterns.
+	// It takes precedence over Include.
+	//
+	// Patterns use path.Match syntax. They are matched against the base name,
+	// or against the path relative to the transferred directory if they
+	// contain a slash (e.g. "*.tmp", "logs/*.gz").
+	Exclude []string
+
+	// MaxDepth limits how many directory levels are transferred: 1 transfers
+	// only the files directly in the directory. Zero means no limit.
+	MaxDepth int
+
+	// Symlinks selects how symbolic links are handled
+	Symlinks SymlinkPolicy
+
+	// Overwrite selects what to do with files that exist at the destination
+	Overwrite OverwritePolicy
+
+	// OnFile, if set, is called after each file is transferred or skipped
+	OnFile func(DirFileEvent)
+}
+
+// validate checks the filter patterns.
+func (o *DirOptions) validate() error {
+	for _, p := range slices.Concat(o.Include, o.Exclude) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// filtered reports whether rel is excluded by the filters.
+func (o *DirOptions) filtered(rel string, isDir bool) bool {
+	matches := func(patterns []string) bool {
+		for _, p := range patterns {
+			name := path.Base(rel)
+			if strings.Contains(p, "/") {
+				name = rel
+			}
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if matches(o.Exclude) {
+		return true
+	}
+	return !isDir && len(o.Include) > 0 && !matches(o.Include)
+}
+
+// descend reports whether the directory rel is within MaxDepth.
+func (o *DirOptions) descend(rel string) bool {
+	return o.MaxDepth <= 0 || strings.Count(rel, "/")+1 < o.MaxDepth
+}
+
+// report calls OnFile, if set.
+func (o *DirOptions) report(rel string, size int64, reason string) {
+	if o.OnFile != nil {
+		o.OnFile(DirFileEvent{Path: rel, Size: size, Skipped: reason != "", Reason: reason})
+	}
+}
+
+// skipExisting applies the overwrite policy to a file whose destination
+// exists, returning the reason to skip it or "" to transfer it. srcTime is
+// zero if the source modification time is unknown.
+func (o *DirOptions) skipExisting(srcSize, dstSize int64, srcTime, dstTime time.Time) string {
+	switch o.Overwrite {
+	case OverwriteNever:
+		return SkipExists
+	case OverwriteIfSizeDiffers:
+		if srcSize == dstSize {
+			return SkipSameSize
+		}
+	case OverwriteIfNewer:
+		// Remote times have a resolution of one second
+		if !srcTime.IsZero() && !dstTime.IsZero() &&
+			!srcTime.Truncate(time.Second).After(dstTime.Truncate(time.Second)) {
+			return SkipNotNewer
+		}
+	}
+	return ""
+}
+
+// withinTree reports whether a relative link target, resolved from the
+// directory of the link at rel, stays inside the transferred tree.
+func withinTree(rel, target string) bool {
+	if target == "" || path.IsAbs(target) {
+		return false
+	}
+	t := path.Join(path.Dir(rel), target)
+	return t != ".." && !strings.HasPrefix(t, "../")
+}
+
+// UploadDirWithOptions uploads a local directory to the server recursively,
+// like UploadDir, with filters, a depth limit, a symbolic link policy and an
+// overwrite policy. Destination files are checked with BatchStat, one
+// pipelined batch per directory, unless opts.Overwrite is OverwriteAlways.
+//
+// Example:
+//
+//	err := client.UploadDirWithOptions("site", "/www", ftp.DirOptions{
+//	    Exclude:   []string{".git", "*.tmp"},
+//	    Overwrite: ftp.OverwriteIfNewer,
+//	    OnFile: func(ev ftp.DirFileEvent) {
+//	        if !ev.Skipped {
+//	            fmt.Println("uploaded", ev.Path)
+//	        }
+//	    },
+//	})
+func (c *Client) UploadDirWithOptions(localDir, remoteDir string, opts DirOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	localDir = filepath.Clean(localDir)
+	info, err := os.Stat(localDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory: %s", localDir)
+	}
+
+	if c.createParents {
+		if err := c.MkdirAll(remoteDir); err != nil {
+			return err
+		}
+	} else {
+		// The remote root may already exist
+		_ = c.MakeDir(remoteDir)
+	}
+
+	real, err := filepath.EvalSymlinks(localDir)
+	if err != nil {
+		return err
+	}
+	return c.uploadTree(localDir, remoteDir, "", &opts, []string{real})
+}
+
+// uploadTree uploads the contents of the local directory localDir/rel.
+// ancestors holds the resolved paths of the directories being uploaded, to
+// detect link loops.
+func (c *Client) uploadTree(localDir, remoteDir, rel string, opts *DirOptions, ancestors []string) error {
+	entries, err := os.ReadDir(filepath.Join(localDir, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+
+	type upload struct {
+		rel  string
+		info fs.FileInfo
+	}
+	var files []upload
+
+	paths := c.remotePaths()
+	for _, entry := range entries {
+		entryRel := path.Join(rel, entry.Name())
+		localPath := filepath.Join(localDir, filepath.FromSlash(entryRel))
+		remotePath := joinRel(paths, remoteDir, entryRel, false)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch opts.Symlinks {
+			case SymlinkFollow:
+				if info, err = os.Stat(localPath); err != nil {
+					opts.report(entryRel, 0, SkipBadTarget)
+					continue
+				}
+			case SymlinkMaterialize:
+				if err := c.uploadSymlink(localPath, remotePath, entryRel, opts); err != nil {
+					return err
+				}
+				continue
+			default:
+				opts.report(entryRel, 0, SkipSymlink)
+				continue
+			}
+		}
+
+		if opts.filtered(entryRel, info.IsDir()) {
+			if !info.IsDir() {
+				opts.report(entryRel, info.Size(), SkipFiltered)
+			}
+			continue
+		}
+
+		if !info.IsDir() {
+			files = append(files, upload{entryRel, info})
+			continue
+		}
+		if !opts.descend(entryRel) {
+			continue
+		}
+
+		real, err := filepath.EvalSymlinks(localPath)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(ancestors, real) {
+			opts.report(entryRel, 0, SkipLinkLoop)
+			continue
+		}
+
+		// The directory may already exist; if it cannot be created, the
+		// uploads into it fail.
+		_ = c.MakeDir(joinRel(paths, remoteDir, entryRel, true))
+		if err := c.uploadTree(localDir, remoteDir, entryRel, opts, append(ancestors, real)); err != nil {
+			return err
+		}
+	}
+
+	existing := make(map[string]StatResult)
+	if opts.Overwrite != OverwriteAlways && len(files) > 0 {
+		paths := make([]string, len(files))
+		for i, f := range files {
+			paths[i] = c.remoteFilePath(remoteDir, f.rel)
+		}
+		results, err := c.BatchStat(paths)
+		if err != nil {
+			return err
+		}
+		for i, r := range results {
+			if r.Err == nil {
+				existing[files[i].rel] = r
+			}
+		}
+	}
+
+	for _, f := range files {
+		if dst, ok := existing[f.rel]; ok {
+			if reason := opts.skipExisting(f.info.Size(), dst.Size, f.info.ModTime(), dst.ModTime); reason != "" {
+				opts.report(f.rel, f.info.Size(), reason)
+				continue
+			}
+		}
+
+		if err := c.StoreFrom(c.remoteFilePath(remoteDir, f.rel), filepath.Join(localDir, filepath.FromSlash(f.rel))); err != nil {
+			return err
+		}
+		opts.report(f.rel, f.info.Size(), "")
+	}
+
+	return nil
+}
+
+// uploadSymlink recreates the local link at localPath on the server with
+// SITE SYMLINK.
+func (c *Client) uploadSymlink(localPath, remotePath, rel string, opts *DirOptions) error {
+	target, err := os.Readlink(localPath)
+	if err != nil {
+		return err
+	}
+	target = filepath.ToSlash(target)
+	if !withinTree(rel, target) {
+		opts.report(rel, 0, SkipBadTarget)
+		return nil
+	}
+
+	if _, err := c.expect2xx("SITE", "SYMLINK", quoteArg(target), quoteArg(remotePath)); err != nil {
+		return err
+	}
+	opts.report(rel, 0, "")
+	return nil
+}
+
+// quoteArg encloses a command argument in double quotes if it contains
+// spaces, for commands that take several paths.
+func quoteArg(s string) string {
+	if strings.Contains(s, " ") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// DownloadDirWithOptions downloads a remote directory to the local filesystem
+// recursively, like DownloadDir, with filters, a depth limit, a symbolic link
+// policy and an overwrite policy. With OverwriteIfNewer, remote modification
+// times are fetched with BatchStat, one pipelined batch per directory.
+//
+// Links are recognized from LIST output. With SymlinkFollow, linked
+// directories are only followed if the server shows the link target, so
+// loops can be detected.
+//
+// Example:
+//
+//	err := client.DownloadDirWithOptions("/pub/mirror", "mirror", ftp.DirOptions{
+//	    Include:   []string{"*.iso"},
+//	    Symlinks:  ftp.SymlinkMaterialize,
+//	    Overwrite: ftp.OverwriteIfSizeDiffers,
+//	})
+func (c *Client) DownloadDirWithOptions(remoteDir, localDir string, opts DirOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return err
+	}
+	return c.downloadTree(c.remotePaths().clean(remoteDir), localDir, "", &opts)
+}
+
+// downloadTree downloads the contents of the remote directory remoteDir/rel.
+func (c *Client) downloadTree(remoteDir, localDir, rel string, opts *DirOptions) error {
+	paths := c.remotePaths()
+	entries, err := c.List(joinRel(paths, remoteDir, rel, true))
+	if err != nil {
+		return err
+	}
+
+	type download struct {
+		rel  string
+		size int64
+	}
+	var files []download
+
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		entryRel := path.Join(rel, entry.Name)
+		remotePath := joinRel(paths, remoteDir, entryRel, false)
+		localPath := filepath.Join(localDir, filepath.FromSlash(entryRel))
+
+		isDir := entry.Type == "dir"
+		if entry.Type == "link" {
+			switch opts.Symlinks {
+			case SymlinkFollow:
+				var ok bool
+				if isDir, ok = c.followRemoteLink(entry, remotePath, entryRel, opts); !ok {
+					continue
+				}
+			case SymlinkMaterialize:
+				if err := downloadSymlink(entry, localPath, entryRel, opts); err != nil {
+					return err
+				}
+				continue
+			default:
+				opts.report(entryRel, 0, SkipSymlink)
+				continue
+			}
+		}
+
+		if opts.filtered(entryRel, isDir) {
+			if !isDir {
+				opts.report(entryRel, entry.Size, SkipFiltered)
+			}
+			continue
+		}
+
+		if !isDir {
+			files = append(files, download{entryRel, entry.Size})
+			continue
+		}
+		if !opts.descend(entryRel) {
+			continue
+		}
+
+		if err := os.MkdirAll(localPath, 0755); err != nil {
+			return err
+		}
+		if err := c.downloadTree(remoteDir, localDir, entryRel, opts); err != nil {
+			return err
+		}
+	}
+
+	// Remote modification times are only needed for files that exist locally
+	remoteTimes := make(map[string]time.Time)
+	if opts.Overwrite == OverwriteIfNewer {
+		var statPaths []string
+		for _, f := range files {
+			if _, err := os.Stat(filepath.Join(localDir, filepath.FromSlash(c.localFileRel(f.rel)))); err == nil {
+				statPaths = append(statPaths, joinRel(paths, remoteDir, f.rel, false))
+			}
+		}
+		if len(statPaths) > 0 {
+			results, err := c.BatchStat(statPaths)
+			if err != nil {
+				return err
+			}
+			for _, r := range results {
+				if r.Err == nil {
+					remoteTimes[r.Path] = r.ModTime
+				}
+			}
+		}
+	}
+
+	for _, f := range files {
+		remotePath := joinRel(paths, remoteDir, f.rel, false)
+		localPath := filepath.Join(localDir, filepath.FromSlash(c.localFileRel(f.rel)))
+
+		if dst, err := os.Stat(localPath); err == nil {
+			if reason := opts.skipExisting(f.size, dst.Size(), remoteTimes[remotePath], dst.ModTime()); reason != "" {
+				opts.report(f.rel, f.size, reason)
+				continue
+			}
+		}
+
+		if err := c.DownloadFile(remotePath, localPath); err != nil {
+			return err
+		}
+		opts.report(f.rel, f.size, "")
+	}
+
+	return nil
+}
+
+// followRemoteLink resolves a remote link for SymlinkFollow. It returns
+// whether the target is a directory, and false if the link must be skipped.
+// The link's entry is updated with the target's size.
+func (c *Client) followRemoteLink(entry *Entry, remotePath, rel string, opts *DirOptions) (isDir, ok bool) {
+	if size, err := c.Size(remotePath); err == nil {
+		entry.Size = size
+		return false, true
+	}
+
+	// Not a file: follow it as a directory, unless it points to one of its
+	// parents or its target is unknown
+	if entry.Target == "" {
+		opts.report(rel, 0, SkipBadTarget)
+		return false, false
+	}
+	target := entry.Target
+	if !path.IsAbs(target) {
+		target = path.Join(path.Dir(remotePath), target)
+	}
+	if parent := path.Dir(remotePath); target == parent || strings.HasPrefix(parent, strings.TrimSuffix(target, "/")+"/") {
+		opts.report(rel, 0, SkipLinkLoop)
+		return false, false
+	}
+	return true, true
+}
+
+// downloadSymlink recreates a remote link locally for SymlinkMaterialize.
+func downloadSymlink(entry *Entry, localPath, rel string, opts *DirOptions) error {
+	if !withinTree(rel, entry.Target) {
+		opts.report(rel, 0, SkipBadTarget)
+		return nil
+	}
+
+	if _, err := os.Lstat(localPath); err == nil {
+		if opts.Overwrite != OverwriteAlways {
+			opts.report(rel, 0, SkipExists)
+			return nil
+		}
+		if err := os.Remove(localPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Symlink(filepath.FromSlash(entry.Target), localPath); err != nil {
+		return err
+	}
+	opts.report(rel, 0, "")
+	return nil
+}
@@ -61,7 +61,8 @@
 //
 // When TLS is enabled, the library automatically enables data channel protection
 // (PROT P) for all data connections. This ensures that file transfers and
-// directory listings are encrypted.
+// directory listings are encrypted. WithDataProtection and Client.SetProt
+// choose clear data connections (PROT C) instead.
 //
 // # File Transfers
 //
@@ -102,6 +103,12 @@
 //	}
 //	err := client.Store("remote.txt", pr)
 //
+// Or pass WithProgress to Store or Retrieve to get the rate and ETA too:
+//
+//	err := client.Store("remote.txt", file, ftp.WithProgress(func(p ftp.Progress) {
+//	    fmt.Printf("%.0f%% ETA %s\n", p.Percent(), p.ETA)
+//	}), ftp.WithProgressInterval(time.Second))
+//
 // # Error Handling
 //
 // Errors returned by this package include detailed protocol context. Use type
@@ -128,6 +135,8 @@
 // The idle timeout automatically sends NOOP commands to prevent the server
 // from closing idle connections. This is useful for long-running operations
 // or when keeping a connection open for extended periods.
+// WithTransferKeepAlive sends NOOP during data transfers too, for servers
+// that drop the control connection during long transfers.
 //
 // # Custom Listing Parsers
 //
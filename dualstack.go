@@ -0,0 +1,100 @@
+package ftp
+
+import (
+	"net"
+	"time"
+)
+
+// defaultFallbackDelay is how long a data connection attempt runs before the
+// next address is tried in parallel, as recommended by RFC 8305.
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// controlIPv6 reports whether the control connection runs over IPv6.
+func (c *Client) controlIPv6() bool {
+	if c.conn == nil {
+		return false
+	}
+	remote, ok := c.conn.RemoteAddr().(*net.TCPAddr)
+	return ok && remote.IP.To4() == nil
+}
+
+// pasvCandidates returns the addresses to dial for a PASV reply on an IPv6
+// control connection: the PASV port on the control connection's host first,
+// since the server most likely listens on both families, then the IPv4
+// address of the reply.
+func (c *Client) pasvCandidates(addr string) []string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == c.host {
+		return []string{addr}
+	}
+	return []string{net.JoinHostPort(c.host, port), addr}
+}
+
+// dialDataRace dials the data port at addrs in order and returns the first
+// connection established, in the manner of Happy Eyeballs (RFC 8305): each
+// address is tried when the previous attempt fails or has not completed
+// after the dialer's FallbackDelay (300ms by default). The connections
+// established later are closed. If all attempts fail, the error of the
+// first one is returned.
+func (c *Client) dialDataRace(addrs []string) (net.Conn, error) {
+	if len(addrs) == 1 {
+		return c.dialData(addrs[0])
+	}
+
+	delay := c.dialer.FallbackDelay
+	if delay <= 0 {
+		delay = defaultFallbackDelay
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	next, pending := 0, 0
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := c.dialData(addr)
+			results <- result{conn, err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// Close the losers as they complete
+				go func(n int) {
+					for range n {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				start()
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if next < len(addrs) {
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, firstErr
+}
@@ -0,0 +1,111 @@
+package ftp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/server"
+)
+
+func TestPassiveIPv6(t *testing.T) {
+	t.Parallel()
+
+	// startIPv6Server starts a server on [::1] that advertises an
+	// unreachable IPv4 address in its PASV replies.
+	startIPv6Server := func(t *testing.T, disabled ...string) string {
+		l, err := net.Listen("tcp6", "[::1]:0")
+		if err != nil {
+			t.Skip("IPv6 not supported or disabled:", err)
+		}
+		rootDir := t.TempDir()
+		driver, err := server.NewFSDriver(rootDir,
+			server.WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+				return rootDir, false, nil
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := server.NewServer(l.Addr().String(),
+			server.WithDriver(driver),
+			server.WithDisableCommands(disabled...),
+			server.WithPassiveAddress(func(server.SessionInfo) (net.IP, error) {
+				return net.ParseIP("192.0.2.1"), nil // TEST-NET-1
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			_ = s.Serve(l)
+		}()
+		t.Cleanup(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_ = s.Shutdown(ctx)
+		})
+		return l.Addr().String()
+	}
+	dial := func(t *testing.T, addr string, opts ...ftp.Option) *ftp.Client {
+		c, err := ftp.Dial(addr, append([]ftp.Option{ftp.WithTimeout(2 * time.Second)}, opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = c.Quit() })
+		if err := c.Login("user", "pass"); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	roundTrip := func(c *ftp.Client) error {
+		if err := c.Store("f.txt", strings.NewReader("hello")); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := c.Retrieve("f.txt", &buf); err != nil {
+			return err
+		}
+		if buf.String() != "hello" {
+			return errors.New("content mismatch: " + buf.String())
+		}
+		return nil
+	}
+
+	t.Run("EPSVDisabledByClient", func(t *testing.T) {
+		t.Parallel()
+		// EPSV is still used, as PASV cannot carry IPv6 addresses
+		c := dial(t, startIPv6Server(t), ftp.WithDisableEPSV())
+		if err := roundTrip(c); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("PASVWithIPv4Address", func(t *testing.T) {
+		t.Parallel()
+		// The PASV port is reached on the control connection's address
+		c := dial(t, startIPv6Server(t, "EPSV"))
+		if err := roundTrip(c); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("NoPassiveMode", func(t *testing.T) {
+		t.Parallel()
+		c := dial(t, startIPv6Server(t, "EPSV", "PASV"))
+		_, err := c.List("/")
+		var afe *ftp.AddressFamilyError
+		if !errors.As(err, &afe) || afe.DataAddr != "" {
+			t.Fatalf("List returned %v, want an AddressFamilyError without data address", err)
+		}
+		var pe *ftp.ProtocolError
+		if !errors.As(err, &pe) || pe.Code != 502 {
+			t.Errorf("AddressFamilyError wraps %v, want the 502 reply to PASV", afe.Err)
+		}
+	})
+}
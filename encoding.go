@@ -0,0 +1,79 @@
+package ftp
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// FilenameEncoding converts file names between UTF-8 and the encoding a
+// legacy server uses for names on the wire.
+//
+// Encodings from golang.org/x/text can be adapted in a few lines, since their
+// decoders and encoders have matching String methods:
+//
+//	type textEncoding struct{ enc encoding.Encoding }
+//
+//	func (e textEncoding) Decode(s string) (string, error) { return e.enc.NewDecoder().String(s) }
+//	func (e textEncoding) Encode(s string) (string, error) { return e.enc.NewEncoder().String(s) }
+//
+//	client, _ := ftp.Dial(addr, ftp.WithFilenameEncoding(textEncoding{japanese.ShiftJIS}))
+type FilenameEncoding interface {
+	// Decode converts a name received from the server to UTF-8.
+	Decode(name string) (string, error)
+
+	// Encode converts a UTF-8 name to the server's encoding.
+	Encode(name string) (string, error)
+}
+
+// Latin1 is the ISO-8859-1 filename encoding, common on legacy Western
+// European systems.
+var Latin1 FilenameEncoding = latin1Encoding{}
+
+type latin1Encoding struct{}
+
+func (latin1Encoding) Decode(name string) (string, error) {
+	var b strings.Builder
+	b.Grow(len(name))
+	for i := 0; i < len(name); i++ {
+		b.WriteRune(rune(name[i]))
+	}
+	return b.String(), nil
+}
+
+func (latin1Encoding) Encode(name string) (string, error) {
+	b := make([]byte, 0, len(name))
+	for _, r := range name {
+		if r > 0xff || r == utf8.RuneError {
+			return "", fmt.Errorf("character %q cannot be represented in ISO-8859-1", r)
+		}
+		b = append(b, byte(r))
+	}
+	return string(b), nil
+}
+
+// decodeName converts text received from the server to UTF-8.
+// If no encoding is set or the text cannot be decoded, it is returned unchanged.
+func (c *Client) decodeName(name string) string {
+	if c.filenameEncoding == nil {
+		return name
+	}
+	decoded, err := c.filenameEncoding.Decode(name)
+	if err != nil {
+		return name
+	}
+	return decoded
+}
+
+// encodeName converts UTF-8 text to the server's encoding.
+// If no encoding is set or the text cannot be encoded, it is returned unchanged.
+func (c *Client) encodeName(name string) string {
+	if c.filenameEncoding == nil {
+		return name
+	}
+	encoded, err := c.filenameEncoding.Encode(name)
+	if err != nil {
+		return name
+	}
+	return encoded
+}
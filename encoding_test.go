@@ -0,0 +1,100 @@
+package ftp_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/server"
+)
+
+func TestClient_FilenameEncoding(t *testing.T) {
+	t.Parallel()
+
+	// A legacy server that sends ISO-8859-1 names
+	addr, cleanup, rootDir := setupServerWithOptions(t, server.WithFilenameEncoding(server.Latin1))
+	defer cleanup()
+
+	if err := os.WriteFile(filepath.Join(rootDir, "café.txt"), []byte("coffee"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an encoding the raw Latin-1 bytes come through
+	raw, err := ftp.Dial(addr, ftp.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer raw.Quit()
+	if err := raw.Login("test", "test"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	names, err := raw.NameList(".")
+	if err != nil {
+		t.Fatalf("NameList failed: %v", err)
+	}
+	if !slices.Contains(names, "caf\xe9.txt") {
+		t.Errorf("Expected raw Latin-1 name, got %q", names)
+	}
+
+	c, err := ftp.Dial(addr, ftp.WithTimeout(5*time.Second), ftp.WithFilenameEncoding(ftp.Latin1))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Quit()
+	if err := c.Login("test", "test"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	names, err = c.NameList(".")
+	if err != nil {
+		t.Fatalf("NameList failed: %v", err)
+	}
+	if !slices.Contains(names, "café.txt") {
+		t.Errorf("NameList: expected decoded name, got %q", names)
+	}
+
+	entries, err := c.List(".")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "café.txt" {
+		t.Errorf("List: expected café.txt, got %+v", entries)
+	}
+
+	mlEntries, err := c.MLList(".")
+	if err != nil {
+		t.Fatalf("MLList failed: %v", err)
+	}
+	if len(mlEntries) != 1 || mlEntries[0].Name != "café.txt" {
+		t.Errorf("MLList: expected café.txt, got %+v", mlEntries)
+	}
+
+	if size, err := c.Size("café.txt"); err != nil || size != 6 {
+		t.Errorf("Size: got %d, %v", size, err)
+	}
+
+	if err := c.MakeDir("répertoire"); err != nil {
+		t.Fatalf("MakeDir failed: %v", err)
+	}
+	if err := c.ChangeDir("répertoire"); err != nil {
+		t.Fatalf("ChangeDir failed: %v", err)
+	}
+	cwd, err := c.CurrentDir()
+	if err != nil {
+		t.Fatalf("CurrentDir failed: %v", err)
+	}
+	if cwd != "/répertoire" {
+		t.Errorf("CurrentDir: got %q, want %q", cwd, "/répertoire")
+	}
+
+	if err := c.Store("naïve.txt", strings.NewReader("data")); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, "répertoire", "naïve.txt")); err != nil {
+		t.Errorf("Uploaded file not stored under its UTF-8 name: %v", err)
+	}
+}
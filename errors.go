@@ -1,6 +1,9 @@
 package ftp
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 // ProtocolError represents an FTP protocol error with full context of the
 // command/response conversation. This provides detailed debugging information
@@ -51,3 +54,86 @@ func (e *ProtocolError) IsTemporary() bool {
 func (e *ProtocolError) IsPermanent() bool {
 	return e.Is5xx()
 }
+
+// ErrLineFeed is returned for commands with an argument containing a line
+// feed, such as a file name with an embedded newline. FTP commands end at
+// the first line feed, so such arguments cannot be sent. Carriage returns
+// are sent escaped (RFC 2640 Section 3.1).
+var ErrLineFeed = errors.New("ftp: line feed in command argument")
+
+// ErrReconnected is matched by the errors returned when the control
+// connection was lost during a command that is not safe to repeat, and
+// WithAutoReconnect restored it (see ReconnectedError).
+var ErrReconnected = errors.New("ftp: connection lost and restored")
+
+// ReconnectedError is returned when the control connection was lost during
+// a command that is not safe to repeat, such as STOR or DELE, and the client
+// reconnected (see WithAutoReconnect). The command may or may not have been
+// carried out by the server; the caller decides whether to repeat it. The
+// client is ready for new commands.
+type ReconnectedError struct {
+	// Command is the command that failed (e.g., "STOR")
+	Command string
+
+	// Err is the error that ended the previous connection
+	Err error
+}
+
+// Error implements the error interface.
+func (e *ReconnectedError) Error() string {
+	return fmt.Sprintf("ftp: connection lost during %s, reconnected without retrying: %v", e.Command, e.Err)
+}
+
+// Unwrap returns ErrReconnected and the error that ended the connection.
+func (e *ReconnectedError) Unwrap() []error {
+	return []error{ErrReconnected, e.Err}
+}
+
+// ChecksumMismatchError is returned when the checksum of transferred data
+// does not match the server's checksum of the file (see WithVerifyTransfers).
+type ChecksumMismatchError struct {
+	// Path is the remote file
+	Path string
+
+	// Algorithm is the checksum algorithm (e.g., "SHA-256", "CRC32")
+	Algorithm string
+
+	// Local is the checksum of the data sent or received by the client
+	Local string
+
+	// Remote is the checksum reported by the server
+	Remote string
+}
+
+// Error implements the error interface.
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("ftp: %s checksum mismatch for %s: local %s, server %s", e.Algorithm, e.Path, e.Local, e.Remote)
+}
+
+// AddressFamilyError is returned when no data connection can be opened on an
+// IPv6 control connection because EPSV is not available, and PASV, which
+// only carries IPv4 addresses, failed or offered an unreachable address.
+type AddressFamilyError struct {
+	// ControlAddr is the server address of the control connection
+	ControlAddr string
+
+	// DataAddr is the IPv4 address offered with PASV, or empty if PASV
+	// failed
+	DataAddr string
+
+	// Err is the error of PASV or of the last connection attempt
+	Err error
+}
+
+// Error implements the error interface.
+func (e *AddressFamilyError) Error() string {
+	if e.DataAddr == "" {
+		return fmt.Sprintf("ftp: no data connection over IPv6 to %s: EPSV is unavailable and PASV failed: %v", e.ControlAddr, e.Err)
+	}
+	return fmt.Sprintf("ftp: no data connection over IPv6 to %s: EPSV is unavailable and the IPv4 PASV address %s is unreachable: %v", e.ControlAddr, e.DataAddr, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *AddressFamilyError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,38 @@
+//go:build ignore_test_folder
+
+// Command configserver runs an FTP server configured from a JSON file.
+//
+// Usage:
+//
+//	go run -tags ignore_test_folder ./examples/configserver ftpserver.json
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/gonzalop/ftp/server"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatalf("usage: %s config.json", os.Args[0])
+	}
+	data, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var cfg server.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		log.Fatalf("Failed to parse %s: %v", os.Args[1], err)
+	}
+	srv, err := server.NewServerFromConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Serving %s on %s", cfg.RootPath, cfg.Addr)
+	log.Fatal(srv.ListenAndServe())
+}
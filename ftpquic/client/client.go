@@ -0,0 +1,166 @@
+// Package client dials FTP servers over QUIC, returning an ftp.Client that
+// works as it does over TCP.
+//
+// Example:
+//
+//	c, err := client.Dial("ftp.example.com:4242", &tls.Config{ServerName: "ftp.example.com"})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer c.Quit()
+//	if err := c.Login("user", "pass"); err != nil {
+//	    log.Fatal(err)
+//	}
+//
+// See the ftpquic package for how the session maps to QUIC streams.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftpquic"
+	"github.com/quic-go/quic-go"
+)
+
+// Dialer dials FTP servers over QUIC. Its connections share TLS session
+// tickets: after the first connection to a server, the next ones resume
+// the TLS session, with 0-RTT if the server accepts it. This includes the
+// additional connections of ftp.Pool, RetrieveParallel and
+// WithAutoReconnect.
+//
+// A Dialer is an ftp.Dialer for the control connection, and is safe for
+// concurrent use. Its fields must not be changed after the first dial.
+type Dialer struct {
+	// TLSConfig is the TLS configuration of QUIC connections. NextProtos
+	// defaults to ftpquic.NextProto, and ClientSessionCache to a cache
+	// owned by the Dialer.
+	TLSConfig *tls.Config
+
+	// QUICConfig is the QUIC configuration, or nil for the defaults.
+	QUICConfig *quic.Config
+
+	once      sync.Once
+	tlsConfig *tls.Config
+}
+
+var _ ftp.Dialer = (*Dialer)(nil)
+
+// Dial connects to the FTP over QUIC server at addr, using a new Dialer
+// with tlsConfig. options are applied as by ftp.Dial; TLS options do not
+// apply, since QUIC connections are always encrypted.
+func Dial(addr string, tlsConfig *tls.Config, options ...ftp.Option) (*ftp.Client, error) {
+	return (&Dialer{TLSConfig: tlsConfig}).Dial(addr, options...)
+}
+
+// Dial connects to the FTP over QUIC server at addr. options are applied as
+// by ftp.Dial; TLS options do not apply, since QUIC connections are always
+// encrypted.
+func (d *Dialer) Dial(addr string, options ...ftp.Option) (*ftp.Client, error) {
+	opts := append([]ftp.Option{ftp.WithControlDialer(d)}, options...)
+	return ftp.Dial(addr, opts...)
+}
+
+// DialContext opens a QUIC connection to address and returns its control
+// stream. network is ignored. It is used by ftp.Client through
+// ftp.WithControlDialer.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.once.Do(d.init)
+	conn, err := quic.DialAddrEarly(ctx, address, d.tlsConfig, d.QUICConfig)
+	if err != nil {
+		return nil, err
+	}
+	// The server opens the control stream, with the greeting
+	stream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		_ = conn.CloseWithError(0, "")
+		return nil, fmt.Errorf("failed to accept control stream: %w", err)
+	}
+	return &controlConn{Conn: ftpquic.NewConn(stream, conn)}, nil
+}
+
+func (d *Dialer) init() {
+	config := &tls.Config{}
+	if d.TLSConfig != nil {
+		config = d.TLSConfig.Clone()
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{ftpquic.NextProto}
+	}
+	if config.ClientSessionCache == nil {
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	config.ClientSessionCache = sessionCache{config.ClientSessionCache}
+	d.tlsConfig = config
+}
+
+// sessionCache returns copies of the sessions in a cache. quic-go stores
+// its data in the session state of the connection resuming it, so
+// connections dialed at the same time must not share one.
+type sessionCache struct {
+	tls.ClientSessionCache
+}
+
+func (c sessionCache) Get(key string) (*tls.ClientSessionState, bool) {
+	session, ok := c.ClientSessionCache.Get(key)
+	if !ok || session == nil {
+		return session, ok
+	}
+	ticket, state, err := session.ResumptionState()
+	if err != nil || state == nil {
+		return nil, false
+	}
+	b, err := state.Bytes()
+	if err != nil {
+		return nil, false
+	}
+	if state, err = tls.ParseSessionState(b); err != nil {
+		return nil, false
+	}
+	session, err = tls.NewResumptionState(ticket, state)
+	return session, err == nil
+}
+
+// controlConn is the control stream of a QUIC connection. It dials data
+// connections as new streams of the connection.
+type controlConn struct {
+	*ftpquic.Conn
+}
+
+var _ ftp.Dialer = (*controlConn)(nil)
+
+// DialContext opens a data stream for the passive listener at address.
+func (c *controlConn) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	_, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data port: %s", portStr)
+	}
+	stream, err := c.QUICConn().OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := ftpquic.WriteDataHeader(stream, port); err != nil {
+		stream.CancelWrite(0)
+		stream.CancelRead(0)
+		return nil, err
+	}
+	return ftpquic.NewConn(stream, c.QUICConn()), nil
+}
+
+// Close closes the control stream and the QUIC connection.
+func (c *controlConn) Close() error {
+	err := c.Conn.Close()
+	if cerr := c.QUICConn().CloseWithError(0, ""); err == nil {
+		err = cerr
+	}
+	return err
+}
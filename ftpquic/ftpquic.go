@@ -0,0 +1,133 @@
+// Package ftpquic runs FTP over QUIC. It holds what the client
+// (github.com/gonzalop/ftp/ftpquic/client) and server
+// (github.com/gonzalop/ftp/server/quiclistener) sides share.
+//
+// It is a separate module, so that the ftp module does not depend on
+// quic-go.
+//
+// One QUIC connection carries one FTP session:
+//
+//   - The server opens the control stream and sends the greeting on it, as
+//     it would on a TCP connection.
+//   - The client opens a stream for each passive data connection. The
+//     stream starts with a header holding the port of the PASV or EPSV
+//     reply, which tells the server which passive listener the stream is
+//     for. Data follows as in stream mode.
+//
+// Active mode (PORT and EPRT) is not available. QUIC encrypts the
+// connection with TLS 1.3, so AUTH TLS is not used either.
+package ftpquic
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// NextProto is the ALPN protocol of FTP over QUIC.
+const NextProto = "ftp-over-quic"
+
+// ErrorCodeNoListener is the stream error code of data streams without a
+// matching passive listener.
+const ErrorCodeNoListener quic.StreamErrorCode = 1
+
+const headerSize = 2
+
+// Conn is a QUIC stream used as a net.Conn.
+type Conn struct {
+	stream *quic.Stream
+	conn   *quic.Conn
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+// NewConn returns stream, a stream of conn, as a net.Conn.
+func NewConn(stream *quic.Stream, conn *quic.Conn) *Conn {
+	return &Conn{stream: stream, conn: conn}
+}
+
+// Read reads data from the stream.
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.stream.Read(b)
+}
+
+// Write writes data to the stream.
+func (c *Conn) Write(b []byte) (int, error) {
+	return c.stream.Write(b)
+}
+
+// Close closes both directions of the stream. Data already written is
+// still delivered, unless the peer stopped reading.
+func (c *Conn) Close() error {
+	c.stream.CancelRead(0)
+	err := c.stream.Close()
+	var serr *quic.StreamError
+	if err != nil && errors.As(context.Cause(c.stream.Context()), &serr) && serr.Remote {
+		// The peer is not interested in more data
+		err = nil
+	}
+	return err
+}
+
+// LocalAddr returns the local address of the QUIC connection.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the QUIC connection.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the stream.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.stream.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the stream.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.stream.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the stream.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.stream.SetWriteDeadline(t)
+}
+
+// Stream returns the QUIC stream.
+func (c *Conn) Stream() *quic.Stream {
+	return c.stream
+}
+
+// QUICConn returns the QUIC connection of the stream.
+func (c *Conn) QUICConn() *quic.Conn {
+	return c.conn
+}
+
+// WriteDataHeader writes the header of a data stream for the passive
+// listener on port.
+func WriteDataHeader(w io.Writer, port int) error {
+	if port <= 0 || port > 0xffff {
+		return fmt.Errorf("invalid data port: %d", port)
+	}
+	var hdr [headerSize]byte
+	binary.BigEndian.PutUint16(hdr[:], uint16(port))
+	_, err := w.Write(hdr[:])
+	return err
+}
+
+// ReadDataHeader reads the header of a data stream, returning the port of
+// its passive listener.
+func ReadDataHeader(r io.Reader) (int, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(hdr[:])), nil
+}
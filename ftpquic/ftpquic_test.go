@@ -0,0 +1,33 @@
+package ftpquic
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestDataHeader(t *testing.T) {
+	t.Parallel()
+	for _, port := range []int{1, 1234, 0xffff} {
+		var buf bytes.Buffer
+		if err := WriteDataHeader(&buf, port); err != nil {
+			t.Fatalf("WriteDataHeader(%d): %v", port, err)
+		}
+		buf.WriteString("data")
+		if got, err := ReadDataHeader(&buf); err != nil || got != port {
+			t.Errorf("ReadDataHeader = %d, %v; want %d", got, err, port)
+		}
+		if buf.String() != "data" {
+			t.Errorf("ReadDataHeader consumed %q", buf.String())
+		}
+	}
+
+	for _, port := range []int{0, -1, 0x10000} {
+		if err := WriteDataHeader(io.Discard, port); err == nil {
+			t.Errorf("WriteDataHeader(%d) succeeded", port)
+		}
+	}
+	if _, err := ReadDataHeader(bytes.NewReader([]byte{1})); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadDataHeader of a short header = %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package ftptest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// faultListener wraps the control connections accepted by the server to
+// inject the faults of FailCommand, DelayReplies, DisconnectAfter and
+// Chaos.MaxDelay, and to pass them through the Link of SetLink.
+type faultListener struct {
+	net.Listener
+	s *Server
+}
+
+func (l *faultListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	conn = l.s.currentLink().Conn(conn)
+	return &controlConn{Conn: conn, s: l.s, r: bufio.NewReader(conn)}, nil
+}
+
+// controlConn is a control connection with faults. Reads return one command
+// line at a time, so commands set to fail with FailCommand can be answered
+// here instead of reaching the server. After AUTH, the connection carries
+// TLS and commands are passed through unchanged.
+type controlConn struct {
+	net.Conn
+	s *Server
+	r *bufio.Reader
+
+	pending  []byte // Rest of the command line being read by the server
+	raw      bool   // AUTH was sent: stop looking at commands
+	commands int    // Number of commands read
+	wmu      sync.Mutex
+}
+
+func (c *controlConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.raw {
+			return c.r.Read(b)
+		}
+
+		line, err := c.r.ReadString('\n')
+		if line == "" {
+			return 0, err
+		}
+
+		c.commands++
+		if limit := c.s.commandLimit(); limit > 0 && c.commands > limit {
+			_, _ = fmt.Fprintf(c, "421 Service not available, closing control connection.\r\n")
+			c.Conn.Close()
+			return 0, io.EOF
+		}
+
+		verb, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		verb = strings.ToUpper(verb)
+		if f, ok := c.s.failure(verb); ok && err == nil {
+			if _, err := fmt.Fprintf(c, "%d %s\r\n", f.code, f.message); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		c.raw = verb == "AUTH"
+		c.pending = []byte(line)
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *controlConn) Write(b []byte) (int, error) {
+	if d := c.s.replyDelay(); d > 0 {
+		time.Sleep(d)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,270 @@
+// Package ftptest provides an in-memory FTP server for testing code that
+// uses an FTP client, with knobs to inject faults.
+//
+// Example:
+//
+//	func TestUpload(t *testing.T) {
+//	    srv := ftptest.NewServer(t)
+//	    srv.FailCommand("MKD", 550, "Permission denied.")
+//
+//	    c, _ := ftp.Dial(srv.Addr)
+//	    defer c.Quit()
+//	    c.Login("user", "pass")
+//	    upload(c) // the code under test
+//
+//	    data, err := srv.ReadFile("/reports/today.csv")
+//	    ...
+//	}
+package ftptest
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp/server"
+)
+
+// Server is an FTP server storing files in memory. Any user name and
+// password are accepted, and all sessions share the same files.
+type Server struct {
+	// Addr is the address the server listens on, as "127.0.0.1:port"
+	Addr string
+
+	srv *server.Server
+	fs  *memFS
+
+	mu              sync.Mutex
+	failures        map[string]failure // Replies of FailCommand, by command
+	dropBytes       int64              // DropDataAfter limit (-1 = none)
+	corruptBytes    int64              // CorruptData count
+	delay           time.Duration      // DelayReplies delay
+	disconnectAfter int                // DisconnectAfter count (0 = never)
+	chaos           Chaos
+	rng             *rand.Rand // Chaos random source, seeded with chaos.Seed
+	link            Link       // SetLink network conditions
+}
+
+// failure is a reply set with FailCommand.
+type failure struct {
+	code    int
+	message string
+}
+
+// NewServer starts a server on a random port of the loopback interface and
+// stops it when the test ends. The options are applied after the ftptest
+// ones, so they can, for example, enable TLS with server.WithTLS; they
+// must not replace the driver or the listener factory.
+func NewServer(t testing.TB, opts ...server.Option) *Server {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ftptest: failed to listen: %v", err)
+	}
+
+	s := &Server{Addr: ln.Addr().String(), fs: newMemFS(), dropBytes: -1}
+	opts = append([]server.Option{
+		server.WithDriver(&memDriver{s: s}),
+		server.WithListenerFactory(dataListeners{s: s}),
+		server.WithLogger(slog.New(slog.DiscardHandler)),
+	}, opts...)
+	s.srv, err = server.NewServer(s.Addr, opts...)
+	if err != nil {
+		ln.Close()
+		t.Fatalf("ftptest: failed to create server: %v", err)
+	}
+
+	go func() {
+		_ = s.srv.Serve(&faultListener{Listener: ln, s: s})
+	}()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close stops the server, closing all connections. It is called when the
+// test ends.
+func (s *Server) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = s.srv.Shutdown(ctx)
+}
+
+// ReadFile returns the contents of the file at the absolute path p.
+func (s *Server) ReadFile(p string) ([]byte, error) {
+	return s.fs.readFile(path.Clean("/" + p))
+}
+
+// WriteFile creates or replaces the file at the absolute path p, creating
+// its parent directories.
+func (s *Server) WriteFile(p string, data []byte) error {
+	return s.fs.writeFile(path.Clean("/"+p), data)
+}
+
+// Files returns the absolute paths of all files, sorted. Directories are not
+// included.
+func (s *Server) Files() []string {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	var files []string
+	for name, n := range s.fs.nodes {
+		if !n.dir {
+			files = append(files, name)
+		}
+	}
+	slices.Sort(files)
+	return files
+}
+
+// FailCommand makes the server reply with code and message to every
+// following cmd (e.g. "STOR"), without running it. Use a 4xx code to
+// test retries of temporary failures.
+func (s *Server) FailCommand(cmd string, code int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures == nil {
+		s.failures = make(map[string]failure)
+	}
+	s.failures[strings.ToUpper(cmd)] = failure{code: code, message: message}
+}
+
+// DropDataAfter makes transfers fail after n bytes of each file opened
+// from now on: the data connection is closed, and the client gets a 426
+// reply. A negative n transfers files completely again.
+func (s *Server) DropDataAfter(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropBytes = n
+}
+
+// DelayReplies delays every reply on the control connection by d.
+func (s *Server) DelayReplies(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// CorruptData inverts the first n bytes of each file transferred from now
+// on, in both directions, so the client receives or stores wrong data. Use
+// it to test checksum verification, e.g. ftp.WithVerifyTransfers. Zero
+// transfers files unchanged again.
+func (s *Server) CorruptData(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.corruptBytes = n
+}
+
+// DisconnectAfter makes the server reply 421 and close the control
+// connection when a session sends a command after its first n ones,
+// as servers shutting down or enforcing limits do. Zero disables it.
+func (s *Server) DisconnectAfter(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnectAfter = n
+}
+
+// Chaos configures random faults, for testing retry logic against realistic
+// failure modes. The faults only depend on Seed and on the order of the
+// transfers and replies, so a failing test can be replayed.
+type Chaos struct {
+	// Seed seeds the random faults
+	Seed uint64
+
+	// DropRate is the probability (0 to 1) that a transfer is cut off at a
+	// random point: anywhere in a download, and within the first 64 KiB of
+	// an upload
+	DropRate float64
+
+	// MaxDelay delays each reply by a random duration up to MaxDelay, in
+	// addition to the DelayReplies delay
+	MaxDelay time.Duration
+}
+
+// SetChaos enables random faults, replacing the ones set before. The zero
+// Chaos disables them.
+func (s *Server) SetChaos(c Chaos) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chaos = c
+	s.rng = rand.New(rand.NewPCG(c.Seed, c.Seed))
+}
+
+// SetLink passes the control and data connections opened from now on
+// through a simulated network link, with limited bandwidth and added
+// latency. The zero Link removes it.
+func (s *Server) SetLink(l Link) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.link = l
+}
+
+// ClearFaults removes the faults set with FailCommand, DropDataAfter,
+// CorruptData, DelayReplies, DisconnectAfter and SetChaos.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = nil
+	s.dropBytes = -1
+	s.corruptBytes = 0
+	s.delay = 0
+	s.disconnectAfter = 0
+	s.chaos = Chaos{}
+}
+
+func (s *Server) failure(cmd string) (failure, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.failures[cmd]
+	return f, ok
+}
+
+// chaosUploadWindow is the part of an upload Chaos.DropRate can cut it off in.
+const chaosUploadWindow = 64 << 10
+
+// transferFaults returns the number of bytes a file of the given size can
+// transfer before the transfer fails (-1 = no limit), and the number of
+// bytes to corrupt.
+func (s *Server) transferFaults(size int64, upload bool) (drop, corrupt int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	drop = s.dropBytes
+	if s.chaos.DropRate > 0 && s.rng.Float64() < s.chaos.DropRate {
+		if upload {
+			size = chaosUploadWindow
+		}
+		if n := s.rng.Int64N(max(size, 1)); drop < 0 || n < drop {
+			drop = n
+		}
+	}
+	return drop, s.corruptBytes
+}
+
+func (s *Server) replyDelay() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.delay
+	if s.chaos.MaxDelay > 0 {
+		d += time.Duration(s.rng.Int64N(int64(s.chaos.MaxDelay) + 1))
+	}
+	return d
+}
+
+func (s *Server) currentLink() Link {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.link
+}
+
+func (s *Server) commandLimit() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.disconnectAfter
+}
@@ -0,0 +1,292 @@
+package ftptest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+func dial(t *testing.T, s *Server) *ftp.Client {
+	t.Helper()
+	c, err := ftp.Dial(s.Addr, ftp.WithTimeout(2*time.Second))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Quit() })
+	if err := c.Login("user", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	return c
+}
+
+func TestServer_Files(t *testing.T) {
+	t.Parallel()
+	s := NewServer(t)
+	c := dial(t, s)
+
+	if err := s.WriteFile("/pub/readme.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := c.Retrieve("pub/readme.txt", &buf); err != nil || buf.String() != "hello" {
+		t.Errorf("Retrieve = %q, %v", buf.String(), err)
+	}
+
+	if err := c.MakeDir("incoming"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ChangeDir("incoming"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Store("data.bin", strings.NewReader("uploaded")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := s.ReadFile("/incoming/data.bin"); err != nil || string(data) != "uploaded" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+	if err := c.Rename("data.bin", "/pub/data.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := c.List("/pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	if want := []string{"data.bin", "readme.txt"}; !slices.Equal(names, want) {
+		t.Errorf("List = %v, want %v", names, want)
+	}
+	if want := []string{"/pub/data.bin", "/pub/readme.txt"}; !slices.Equal(s.Files(), want) {
+		t.Errorf("Files = %v, want %v", s.Files(), want)
+	}
+}
+
+func TestServer_FailCommand(t *testing.T) {
+	t.Parallel()
+	s := NewServer(t)
+	c := dial(t, s)
+
+	s.FailCommand("mkd", 452, "Insufficient storage space.")
+	err := c.MakeDir("dir")
+	var pe *ftp.ProtocolError
+	if !errors.As(err, &pe) || pe.Code != 452 {
+		t.Fatalf("MakeDir = %v, want 452", err)
+	}
+
+	s.ClearFaults()
+	if err := c.MakeDir("dir"); err != nil {
+		t.Errorf("MakeDir after ClearFaults: %v", err)
+	}
+}
+
+func TestServer_DropDataAfter(t *testing.T) {
+	t.Parallel()
+	s := NewServer(t)
+	c := dial(t, s)
+
+	if err := s.WriteFile("/big.bin", bytes.Repeat([]byte("x"), 100000)); err != nil {
+		t.Fatal(err)
+	}
+	s.DropDataAfter(1000)
+
+	var buf bytes.Buffer
+	if err := c.Retrieve("big.bin", &buf); err == nil {
+		t.Error("Retrieve succeeded")
+	}
+	if buf.Len() != 1000 {
+		t.Errorf("Retrieved %d bytes, want 1000", buf.Len())
+	}
+
+	if err := c.Store("up.bin", bytes.NewReader(make([]byte, 100000))); err == nil {
+		t.Error("Store succeeded")
+	}
+
+	s.DropDataAfter(-1)
+	buf.Reset()
+	if err := c.Retrieve("big.bin", &buf); err != nil || buf.Len() != 100000 {
+		t.Errorf("Retrieve = %d bytes, %v", buf.Len(), err)
+	}
+}
+
+func TestServer_DelayReplies(t *testing.T) {
+	t.Parallel()
+	s := NewServer(t)
+	c := dial(t, s)
+
+	s.DelayReplies(100 * time.Millisecond)
+	start := time.Now()
+	if err := c.Noop(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("NOOP took %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestServer_CorruptData(t *testing.T) {
+	t.Parallel()
+	s := NewServer(t)
+	c := dial(t, s)
+
+	if err := s.WriteFile("/file.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	s.CorruptData(2)
+
+	var buf bytes.Buffer
+	if err := c.Retrieve("file.txt", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := string([]byte{^byte('h'), ^byte('e')}) + "llo"; buf.String() != want {
+		t.Errorf("Retrieve = %q, want %q", buf.String(), want)
+	}
+
+	if err := c.Store("up.txt", strings.NewReader("world")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := s.ReadFile("/up.txt"); bytes.Equal(data, []byte("world")) {
+		t.Error("Upload not corrupted")
+	}
+}
+
+func TestServer_DisconnectAfter(t *testing.T) {
+	t.Parallel()
+	s := NewServer(t)
+	s.DisconnectAfter(3) // USER, PASS and one more
+	c := dial(t, s)
+
+	if err := c.Noop(); err != nil {
+		t.Fatalf("First NOOP failed: %v", err)
+	}
+	err := c.Noop()
+	var pe *ftp.ProtocolError
+	if !errors.As(err, &pe) || pe.Code != 421 {
+		t.Errorf("Second NOOP = %v, want 421", err)
+	}
+}
+
+func TestServer_Chaos(t *testing.T) {
+	t.Parallel()
+	s := NewServer(t)
+	c := dial(t, s)
+
+	if err := s.WriteFile("/big.bin", make([]byte, 100000)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same seed cuts transfers off at the same points
+	var sizes [2][]int
+	for run := range sizes {
+		s.SetChaos(Chaos{Seed: 42, DropRate: 1})
+		for range 3 {
+			var buf bytes.Buffer
+			if err := c.Retrieve("big.bin", &buf); err == nil && buf.Len() == 100000 {
+				t.Error("Retrieve was not cut off")
+			}
+			sizes[run] = append(sizes[run], buf.Len())
+		}
+	}
+	if !slices.Equal(sizes[0], sizes[1]) {
+		t.Errorf("Transfers cut off at %v and %v", sizes[0], sizes[1])
+	}
+
+	s.SetChaos(Chaos{})
+	var buf bytes.Buffer
+	if err := c.Retrieve("big.bin", &buf); err != nil || buf.Len() != 100000 {
+		t.Errorf("Retrieve without chaos = %d bytes, %v", buf.Len(), err)
+	}
+}
+
+func TestServer_SetLink(t *testing.T) {
+	t.Parallel()
+	s := NewServer(t)
+	if err := s.WriteFile("/big.bin", make([]byte, 64<<10)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 64 KiB at 256 KiB/s take 250ms, reported as they arrive
+	s.SetLink(Link{Bandwidth: 256 << 10, Latency: 20 * time.Millisecond})
+	c := dial(t, s)
+	var reports []ftp.Progress
+	start := time.Now()
+	err := c.Retrieve("big.bin", io.Discard,
+		ftp.WithProgress(func(p ftp.Progress) { reports = append(reports, p) }),
+		ftp.WithProgressInterval(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("Retrieve took %v, want at least 250ms", elapsed)
+	}
+	if len(reports) < 3 {
+		t.Errorf("Got %d progress reports, want several: %+v", len(reports), reports)
+	}
+
+	start = time.Now()
+	if err := c.Noop(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("NOOP took %v, want at least the 20ms latency", elapsed)
+	}
+}
+
+func TestLink_Conn(t *testing.T) {
+	t.Parallel()
+
+	t.Run("bandwidth", func(t *testing.T) {
+		t.Parallel()
+		client, server := net.Pipe()
+		defer client.Close()
+		conn := Link{Bandwidth: 100 << 10}.Conn(server)
+
+		go func() {
+			_, _ = conn.Write(make([]byte, 20<<10))
+			conn.Close()
+		}()
+		start := time.Now()
+		got, err := io.ReadAll(client)
+		if err != nil || len(got) != 20<<10 {
+			t.Fatalf("ReadAll = %d bytes, %v", len(got), err)
+		}
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Errorf("20 KiB at 100 KiB/s took %v, want at least 200ms", elapsed)
+		}
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		t.Parallel()
+		client, server := net.Pipe()
+		defer client.Close()
+		conn := Link{Latency: 100 * time.Millisecond, Jitter: 50 * time.Millisecond, Seed: 1}.Conn(server)
+
+		// Writes do not wait for the latency, and the data stays in order
+		start := time.Now()
+		for i := range 10 {
+			if _, err := conn.Write([]byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+			t.Errorf("Writes took %v", elapsed)
+		}
+		go conn.Close()
+		got, err := io.ReadAll(client)
+		if err != nil || !bytes.Equal(got, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+			t.Fatalf("ReadAll = %v, %v", got, err)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("Data arrived after %v, want at least 100ms", elapsed)
+		}
+	})
+}
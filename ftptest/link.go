@@ -0,0 +1,268 @@
+package ftptest
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gonzalop/ftp/server"
+)
+
+// Link simulates a slow network link, to exercise progress reporting, stall
+// timeouts and keep-alives without sleeping in the code under test. The
+// zero Link is a perfect link.
+//
+// A Link can wrap any net.Conn or net.Listener, be used as the data
+// connection dialer of a client (ftp.WithDataConnDialer), or be set on a
+// Server with SetLink:
+//
+//	link := ftptest.Link{Bandwidth: 64 << 10, Latency: 20 * time.Millisecond}
+//	srv.SetLink(link)
+//	c, _ := ftp.Dial(srv.Addr, ftp.WithDataConnDialer(link))
+type Link struct {
+	// Bandwidth limits each direction to this many bytes per second
+	// (0 = unlimited)
+	Bandwidth int64
+
+	// Latency delays the delivery of the data written to the connection,
+	// without blocking the writer
+	Latency time.Duration
+
+	// Jitter adds a random delay up to Jitter to the Latency of each
+	// write. The data is still delivered in order.
+	Jitter time.Duration
+
+	// Seed seeds the jitter. Each connection draws its delays from a
+	// source seeded with Seed, so the delays repeat from run to run.
+	Seed uint64
+}
+
+// linkChunk is the largest piece of data a linkConn moves at once.
+const linkChunk = 32 << 10
+
+// Conn wraps conn with the link. Wrapping one end of a connection is
+// enough: its writes are delayed and both directions are paced.
+func (l Link) Conn(conn net.Conn) net.Conn {
+	if l == (Link{}) {
+		return conn
+	}
+	c := &linkConn{
+		Conn: conn,
+		link: l,
+		in:   pacer{rate: l.Bandwidth},
+		out:  pacer{rate: l.Bandwidth},
+		rng:  rand.New(rand.NewPCG(l.Seed, l.Seed)),
+	}
+	if l.Latency > 0 || l.Jitter > 0 {
+		c.queue = make(chan packet, 64)
+		c.done = make(chan struct{})
+		go c.deliver()
+	}
+	return c
+}
+
+// Listener wraps the connections accepted from ln with the link.
+func (l Link) Listener(ln net.Listener) net.Listener {
+	return &linkListener{Listener: ln, link: func() Link { return l }}
+}
+
+// DialContext dials address and wraps the connection with the link. It
+// makes a Link usable with ftp.WithDataConnDialer.
+func (l Link) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return l.Conn(conn), nil
+}
+
+// chunk returns the size of the pieces data is moved in: 50ms worth of
+// data, so pacing is smooth at low rates.
+func (l Link) chunk() int {
+	if l.Bandwidth <= 0 {
+		return linkChunk
+	}
+	return int(min(max(l.Bandwidth/20, 1), linkChunk))
+}
+
+// pacer spreads the data of one direction over time at a rate.
+type pacer struct {
+	rate int64
+
+	mu   sync.Mutex
+	next time.Time // When the direction is free again
+}
+
+// wait blocks for the time n bytes take at the rate.
+func (p *pacer) wait(n int) {
+	if p.rate <= 0 || n <= 0 {
+		return
+	}
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	p.next = p.next.Add(time.Duration(int64(n) * int64(time.Second) / p.rate))
+	until := p.next
+	p.mu.Unlock()
+	time.Sleep(time.Until(until))
+}
+
+// packet is data written to a linkConn, delivered at due.
+type packet struct {
+	data []byte
+	due  time.Time
+}
+
+// linkConn is a connection through a Link.
+type linkConn struct {
+	net.Conn
+	link    Link
+	in, out pacer
+
+	mu      sync.Mutex
+	rng     *rand.Rand
+	queue   chan packet // Data in flight (nil = no latency)
+	lastDue time.Time
+	closed  bool
+
+	errMu sync.Mutex
+	err   error // First error delivering the data in flight
+
+	done      chan struct{} // Closed when deliver returns
+	closeOnce sync.Once
+}
+
+func (c *linkConn) Read(b []byte) (int, error) {
+	if chunk := c.link.chunk(); len(b) > chunk {
+		b = b[:chunk]
+	}
+	n, err := c.Conn.Read(b)
+	c.in.wait(n)
+	return n, err
+}
+
+func (c *linkConn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), c.link.chunk())
+		c.out.wait(n)
+		if err := c.send(b[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// send writes data, or queues it for delivery after the latency.
+func (c *linkConn) send(data []byte) error {
+	if c.queue == nil {
+		_, err := c.Conn.Write(data)
+		return err
+	}
+
+	if err := c.deliveryErr(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	delay := c.link.Latency
+	if c.link.Jitter > 0 {
+		delay += time.Duration(c.rng.Int64N(int64(c.link.Jitter) + 1))
+	}
+	due := time.Now().Add(delay)
+	if due.Before(c.lastDue) {
+		due = c.lastDue // Jitter does not reorder the data
+	}
+	c.lastDue = due
+	c.queue <- packet{data: append([]byte(nil), data...), due: due}
+	return nil
+}
+
+// deliver writes the queued data when it is due.
+func (c *linkConn) deliver() {
+	defer close(c.done)
+	for p := range c.queue {
+		time.Sleep(time.Until(p.due))
+		if c.deliveryErr() != nil {
+			continue
+		}
+		if _, err := c.Conn.Write(p.data); err != nil {
+			c.errMu.Lock()
+			c.err = err
+			c.errMu.Unlock()
+		}
+	}
+}
+
+func (c *linkConn) deliveryErr() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
+
+// Close delivers the data in flight, like TCP does after a close, and
+// closes the connection. It gives up on the data if the peer does not
+// read it within a second of its due time.
+func (c *linkConn) Close() error {
+	c.closeOnce.Do(func() {
+		if c.queue == nil {
+			return
+		}
+		c.mu.Lock()
+		c.closed = true
+		close(c.queue)
+		c.mu.Unlock()
+		select {
+		case <-c.done:
+		case <-time.After(c.link.Latency + c.link.Jitter + time.Second):
+		}
+	})
+	return c.Conn.Close()
+}
+
+// linkListener wraps accepted connections with a Link.
+type linkListener struct {
+	net.Listener
+	link func() Link // The Link at the time a connection is accepted
+}
+
+func (l *linkListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.link().Conn(conn), nil
+}
+
+// SetDeadline sets the deadline of Accept, if the listener has one, as
+// *net.TCPListener does.
+func (l *linkListener) SetDeadline(t time.Time) error {
+	if d, ok := l.Listener.(interface{ SetDeadline(time.Time) error }); ok {
+		return d.SetDeadline(t)
+	}
+	return nil
+}
+
+// dataListeners opens the passive data listeners of a Server, with its
+// Link.
+type dataListeners struct {
+	s *Server
+}
+
+func (f dataListeners) Listen(network, address string) (net.Listener, error) {
+	ln, err := (&server.DefaultListenerFactory{}).Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &linkListener{Listener: ln, link: f.s.currentLink}, nil
+}
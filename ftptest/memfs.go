@@ -0,0 +1,475 @@
+package ftptest
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gonzalop/ftp/server"
+)
+
+// memFS is the in-memory file tree of a Server, shared by all its sessions.
+type memFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode // By clean absolute path; "/" is the root
+}
+
+// memNode is a file or directory of a memFS.
+type memNode struct {
+	dir     bool
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newMemFS() *memFS {
+	return &memFS{nodes: map[string]*memNode{
+		"/": {dir: true, mode: os.ModeDir | 0755, modTime: time.Now()},
+	}}
+}
+
+// parentDir returns the directory node containing p.
+// fs.mu must be held.
+func (fs *memFS) parentDir(p string) (*memNode, error) {
+	parent, ok := fs.nodes[path.Dir(p)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if !parent.dir {
+		return nil, errors.New("not a directory")
+	}
+	return parent, nil
+}
+
+// children returns the paths of the entries of directory p, sorted.
+// fs.mu must be held.
+func (fs *memFS) children(p string) []string {
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	var names []string
+	for name := range fs.nodes {
+		if name != "/" && path.Dir(name) == p && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// writeFile creates or replaces the file at p, creating its parent
+// directories.
+func (fs *memFS) writeFile(p string, data []byte) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for dir := path.Dir(p); ; dir = path.Dir(dir) {
+		if n, ok := fs.nodes[dir]; ok {
+			if !n.dir {
+				return errors.New("not a directory")
+			}
+			break
+		}
+		fs.nodes[dir] = &memNode{dir: true, mode: os.ModeDir | 0755, modTime: time.Now()}
+	}
+	if n, ok := fs.nodes[p]; ok && n.dir {
+		return errors.New("is a directory")
+	}
+	fs.nodes[p] = &memNode{data: slices.Clone(data), mode: 0644, modTime: time.Now()}
+	return nil
+}
+
+// readFile returns a copy of the contents of the file at p.
+func (fs *memFS) readFile(p string) ([]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, ok := fs.nodes[p]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if n.dir {
+		return nil, errors.New("is a directory")
+	}
+	return slices.Clone(n.data), nil
+}
+
+// memDriver authenticates every user to the Server's memFS.
+type memDriver struct {
+	s *Server
+}
+
+func (d *memDriver) Authenticate(user, pass, host string, remoteIP net.IP) (server.ClientContext, error) {
+	return &memContext{s: d.s, fs: d.s.fs, cwd: "/"}, nil
+}
+
+// memContext is the server.ClientContext of a session.
+type memContext struct {
+	s   *Server
+	fs  *memFS
+	mu  sync.Mutex
+	cwd string
+}
+
+// abs returns the clean absolute path of p.
+func (c *memContext) abs(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		c.mu.Lock()
+		p = c.cwd + "/" + p
+		c.mu.Unlock()
+	}
+	return path.Clean(p)
+}
+
+func (c *memContext) ChangeDir(p string) error {
+	p = c.abs(p)
+	c.fs.mu.Lock()
+	n, ok := c.fs.nodes[p]
+	c.fs.mu.Unlock()
+	if !ok {
+		return os.ErrNotExist
+	}
+	if !n.dir {
+		return errors.New("not a directory")
+	}
+	c.mu.Lock()
+	c.cwd = p
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *memContext) GetWd() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cwd, nil
+}
+
+func (c *memContext) MakeDir(p string) error {
+	p = c.abs(p)
+	c.fs.mu.Lock()
+	defer c.fs.mu.Unlock()
+	if _, ok := c.fs.nodes[p]; ok {
+		return os.ErrExist
+	}
+	if _, err := c.fs.parentDir(p); err != nil {
+		return err
+	}
+	c.fs.nodes[p] = &memNode{dir: true, mode: os.ModeDir | 0755, modTime: time.Now()}
+	return nil
+}
+
+func (c *memContext) RemoveDir(p string) error {
+	p = c.abs(p)
+	c.fs.mu.Lock()
+	defer c.fs.mu.Unlock()
+	n, ok := c.fs.nodes[p]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if !n.dir || p == "/" {
+		return errors.New("not a removable directory")
+	}
+	if len(c.fs.children(p)) > 0 {
+		return errors.New("directory not empty")
+	}
+	delete(c.fs.nodes, p)
+	return nil
+}
+
+func (c *memContext) DeleteFile(p string) error {
+	p = c.abs(p)
+	c.fs.mu.Lock()
+	defer c.fs.mu.Unlock()
+	n, ok := c.fs.nodes[p]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if n.dir {
+		return errors.New("is a directory")
+	}
+	delete(c.fs.nodes, p)
+	return nil
+}
+
+func (c *memContext) Rename(fromPath, toPath string) error {
+	from, to := c.abs(fromPath), c.abs(toPath)
+	c.fs.mu.Lock()
+	defer c.fs.mu.Unlock()
+	n, ok := c.fs.nodes[from]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if from == "/" || strings.HasPrefix(to, from+"/") {
+		return os.ErrPermission
+	}
+	if _, err := c.fs.parentDir(to); err != nil {
+		return err
+	}
+
+	// Directories are moved with everything below them
+	moved := map[string]*memNode{to: n}
+	if n.dir {
+		for name, child := range c.fs.nodes {
+			if strings.HasPrefix(name, from+"/") {
+				moved[to+strings.TrimPrefix(name, from)] = child
+				delete(c.fs.nodes, name)
+			}
+		}
+	}
+	delete(c.fs.nodes, from)
+	for name, node := range moved {
+		c.fs.nodes[name] = node
+	}
+	return nil
+}
+
+func (c *memContext) ListDir(p string) ([]os.FileInfo, error) {
+	p = c.abs(p)
+	c.fs.mu.Lock()
+	defer c.fs.mu.Unlock()
+	n, ok := c.fs.nodes[p]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if !n.dir {
+		return nil, errors.New("not a directory")
+	}
+
+	var infos []os.FileInfo
+	for _, name := range c.fs.children(p) {
+		infos = append(infos, newFileInfo(name, c.fs.nodes[name]))
+	}
+	return infos, nil
+}
+
+func (c *memContext) OpenFile(p string, flag int) (io.ReadWriteCloser, error) {
+	p = c.abs(p)
+	c.fs.mu.Lock()
+	defer c.fs.mu.Unlock()
+
+	n, ok := c.fs.nodes[p]
+	switch {
+	case ok && n.dir:
+		return nil, errors.New("is a directory")
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, os.ErrNotExist
+	case !ok:
+		if _, err := c.fs.parentDir(p); err != nil {
+			return nil, err
+		}
+		n = &memNode{mode: 0644}
+		c.fs.nodes[p] = n
+	}
+
+	if flag&os.O_TRUNC != 0 {
+		n.data = nil
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		n.modTime = time.Now()
+	}
+	upload := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	drop, corrupt := c.s.transferFaults(int64(len(n.data)), upload)
+	return &memFile{
+		fs:          c.fs,
+		node:        n,
+		append:      flag&os.O_APPEND != 0,
+		dropLeft:    drop,
+		corruptLeft: corrupt,
+	}, nil
+}
+
+func (c *memContext) GetFileInfo(p string) (os.FileInfo, error) {
+	p = c.abs(p)
+	c.fs.mu.Lock()
+	defer c.fs.mu.Unlock()
+	n, ok := c.fs.nodes[p]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return newFileInfo(p, n), nil
+}
+
+func (c *memContext) GetHash(p string, algo string) (string, error) {
+	data, err := c.fs.readFile(c.abs(p))
+	if err != nil {
+		return "", err
+	}
+
+	var h hash.Hash
+	switch strings.ToUpper(algo) {
+	case "SHA-256":
+		h = sha256.New()
+	case "SHA-512":
+		h = sha512.New()
+	case "SHA-1":
+		h = sha1.New()
+	case "MD5":
+		h = md5.New()
+	case "CRC32":
+		h = crc32.NewIEEE()
+	default:
+		return "", errors.New("unsupported algorithm")
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *memContext) SetTime(p string, t time.Time) error {
+	p = c.abs(p)
+	c.fs.mu.Lock()
+	defer c.fs.mu.Unlock()
+	n, ok := c.fs.nodes[p]
+	if !ok {
+		return os.ErrNotExist
+	}
+	n.modTime = t
+	return nil
+}
+
+func (c *memContext) Chmod(p string, mode os.FileMode) error {
+	p = c.abs(p)
+	c.fs.mu.Lock()
+	defer c.fs.mu.Unlock()
+	n, ok := c.fs.nodes[p]
+	if !ok {
+		return os.ErrNotExist
+	}
+	n.mode = n.mode&os.ModeType | mode&os.ModePerm
+	return nil
+}
+
+func (c *memContext) Close() error {
+	return nil
+}
+
+func (c *memContext) GetSettings() *server.Settings {
+	return nil
+}
+
+// errDropped is returned by a memFile once DropDataAfter's limit is reached.
+var errDropped = errors.New("ftptest: data connection dropped")
+
+// memFile is an open file of a memFS. It implements io.Seeker, so
+// transfers can be resumed with REST.
+type memFile struct {
+	fs     *memFS
+	node   *memNode
+	offset int64
+	append bool
+
+	// dropLeft is the number of bytes that can be transferred before the
+	// transfer fails (-1 = no limit)
+	dropLeft int64
+
+	// corruptLeft is the number of bytes still to corrupt (see CorruptData)
+	corruptLeft int64
+}
+
+// corrupt inverts the bytes of b that are still to be corrupted.
+func (f *memFile) corrupt(b []byte) {
+	for i := 0; i < len(b) && f.corruptLeft > 0; i++ {
+		b[i] = ^b[i]
+		f.corruptLeft--
+	}
+}
+
+// limit returns how much of n bytes can be transferred before the drop.
+func (f *memFile) limit(n int) int {
+	if f.dropLeft >= 0 && int64(n) > f.dropLeft {
+		return int(f.dropLeft)
+	}
+	return n
+}
+
+// consume records n bytes transferred.
+func (f *memFile) consume(n int) {
+	if f.dropLeft >= 0 {
+		f.dropLeft -= int64(n)
+	}
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	if f.dropLeft == 0 {
+		return 0, errDropped
+	}
+	n := copy(b[:f.limit(len(b))], f.node.data[f.offset:])
+	f.corrupt(b[:n])
+	f.offset += int64(n)
+	f.consume(n)
+	return n, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.append {
+		f.offset = int64(len(f.node.data))
+	}
+	n := f.limit(len(b))
+	if end := f.offset + int64(n); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[f.offset:], b[:n])
+	f.corrupt(f.node.data[f.offset : f.offset+int64(n)])
+	f.offset += int64(n)
+	f.consume(n)
+	if n < len(b) {
+		return n, errDropped
+	}
+	return n, nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+// fileInfo is the os.FileInfo of a memNode.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(p string, n *memNode) fileInfo {
+	return fileInfo{name: path.Base(p), size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fileInfo) Sys() any           { return nil }
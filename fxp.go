@@ -0,0 +1,173 @@
+package ftp
+
+import (
+	"fmt"
+	"net"
+)
+
+// TransferTo copies srcPath from this client's server directly to dstPath on
+// the server dst is connected to, using a server-to-server (FXP) transfer.
+// The file contents never pass through the local machine: the destination
+// server is put in passive mode and the source server is told to connect
+// to it with PORT (or EPRT for IPv6).
+//
+// Both clients must be logged in. The transfer is performed in binary mode
+// (TYPE I). Servers commonly reject FXP because it relaxes FTP bounce
+// protection; the source server must allow its data connection to target a
+// host other than the client (see server.WithAllowFXP for this package's server).
+//
+// FXP is not supported over protected data channels (PROT P), since both
+// servers would expect to act as the TLS server. With TLS, switch both
+// clients to clear data channels with SetProt("C") first.
+//
+// Example:
+//
+//	src, _ := ftp.Dial("mirror-a.example.com:21")
+//	dst, _ := ftp.Dial("mirror-b.example.com:21")
+//	// ... login both ...
+//	err := src.TransferTo(dst, "/pub/release.tar.gz", "/incoming/release.tar.gz")
+func (c *Client) TransferTo(dst *Client, srcPath, dstPath string) error {
+	if dst == nil {
+		return fmt.Errorf("destination client is nil")
+	}
+	if c.dataTLSConfig() != nil || dst.dataTLSConfig() != nil {
+		return fmt.Errorf("FXP transfers are not supported with protected data channels")
+	}
+	if c.blockMode || dst.blockMode {
+		return fmt.Errorf("FXP transfers are not supported with data connection reuse")
+	}
+
+	// Set binary mode on both sides
+	if err := c.Type("I"); err != nil {
+		return fmt.Errorf("failed to set binary mode on source: %w", err)
+	}
+	if err := dst.Type("I"); err != nil {
+		return fmt.Errorf("failed to set binary mode on destination: %w", err)
+	}
+
+	// Put the destination server in passive mode
+	addr, err := dst.fxpPassiveAddr()
+	if err != nil {
+		return err
+	}
+
+	// Point the source server at the destination's passive port
+	if err := c.sendPort(addr); err != nil {
+		return err
+	}
+
+	// Start the source first. Its connection is queued by the destination's
+	// passive listener until the destination accepts it for STOR, so a
+	// missing source file fails here without leaving the destination waiting.
+	resp, err := c.sendCommand("RETR", srcPath)
+	if err != nil {
+		return err
+	}
+	if resp.Code < 100 || resp.Code >= 200 {
+		return &ProtocolError{
+			Command:  "RETR",
+			Response: resp.Message,
+			Code:     resp.Code,
+		}
+	}
+
+	dstResp, err := dst.sendCommand("STOR", dstPath)
+	if err != nil {
+		c.abortFXP()
+		return err
+	}
+	if dstResp.Code < 100 || dstResp.Code >= 200 {
+		c.abortFXP()
+		return &ProtocolError{
+			Command:  "STOR",
+			Response: dstResp.Message,
+			Code:     dstResp.Code,
+		}
+	}
+
+	// Wait for both servers to report completion. The data flows between the
+	// servers, so there is no local activity to bound these waits by.
+	srcFinal, srcErr := c.readReply(0)
+	dstFinal, dstErr := dst.readReply(0)
+
+	if srcErr != nil {
+		return fmt.Errorf("failed to read source completion response: %w", srcErr)
+	}
+	if dstErr != nil {
+		return fmt.Errorf("failed to read destination completion response: %w", dstErr)
+	}
+	if !srcFinal.Is2xx() {
+		return &ProtocolError{
+			Command:  "RETR",
+			Response: srcFinal.Message,
+			Code:     srcFinal.Code,
+		}
+	}
+	if !dstFinal.Is2xx() {
+		return &ProtocolError{
+			Command:  "STOR",
+			Response: dstFinal.Message,
+			Code:     dstFinal.Code,
+		}
+	}
+
+	return nil
+}
+
+// abortFXP aborts the source side of a failed FXP transfer and consumes the
+// replies for both the transfer and the ABOR command, so the control
+// connection stays in sync.
+func (c *Client) abortFXP() {
+	if err := c.writeCommand("ABOR"); err != nil {
+		return
+	}
+	for range 2 {
+		if _, err := c.readReply(c.timeout); err != nil {
+			return
+		}
+	}
+}
+
+// fxpPassiveAddr puts the server in passive mode and returns the address
+// another server can reach it on. Unlike openPassiveDataConn it needs a
+// complete IP address, so PASV is preferred and EPSV is only used as a
+// fallback, combined with the control connection's remote IP.
+func (c *Client) fxpPassiveAddr() (string, error) {
+	// Use the control connection's peer IP for unroutable or missing hosts
+	controlIP, _, err := net.SplitHostPort(c.conn.RemoteAddr().String())
+	if err != nil {
+		return "", fmt.Errorf("failed to determine server address: %w", err)
+	}
+
+	resp, err := c.sendCommand("PASV")
+	if err != nil {
+		return "", fmt.Errorf("PASV failed: %w", err)
+	}
+
+	if resp.Is2xx() {
+		addr, err := parsePASV(resp.String())
+		if err != nil {
+			return "", err
+		}
+		return resolveDataAddr(addr, controlIP), nil
+	}
+
+	// PASV is IPv4-only; fall back to EPSV for IPv6 servers
+	eresp, err := c.sendCommand("EPSV")
+	if err != nil {
+		return "", fmt.Errorf("EPSV failed: %w", err)
+	}
+	if !eresp.Is2xx() {
+		return "", &ProtocolError{
+			Command:  "PASV",
+			Response: resp.Message,
+			Code:     resp.Code,
+		}
+	}
+
+	port, err := parseEPSV(eresp.String())
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(controlIP, port), nil
+}
@@ -0,0 +1,109 @@
+package ftp_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/server"
+)
+
+func TestClient_TransferTo(t *testing.T) {
+	t.Parallel()
+
+	srcAddr, srcCleanup, srcRoot := setupServer(t)
+	defer srcCleanup()
+
+	// The destination server receives the data connection from the source
+	// server, so the source is the one that needs FXP enabled. Both run on
+	// localhost here, but enable it anyway to exercise the option.
+	dstRoot := t.TempDir()
+	driver, err := server.NewFSDriver(dstRoot,
+		server.WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+			return dstRoot, false, nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstServer, err := server.NewServer(ln.Addr().String(), server.WithDriver(driver), server.WithAllowFXP(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := dstServer.Serve(ln); err != nil && err != server.ErrServerClosed {
+			t.Logf("Server stopped: %v", err)
+		}
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = dstServer.Shutdown(ctx)
+	}()
+
+	content := bytes.Repeat([]byte("fxp transfer data "), 4096)
+	if err := os.WriteFile(filepath.Join(srcRoot, "source.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := ftp.Dial(srcAddr, ftp.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Quit()
+	if err := src.Login("test", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := ftp.Dial(ln.Addr().String(), ftp.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Quit()
+	if err := dst.Login("test", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := src.TransferTo(dst, "source.bin", "copy.bin"); err != nil {
+		t.Fatalf("TransferTo failed: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dstRoot, "copy.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Content mismatch: got %d bytes, want %d", len(got), len(content))
+	}
+
+	// A missing source file must leave both control connections usable
+	if err := src.TransferTo(dst, "missing.bin", "copy2.bin"); err == nil {
+		t.Error("Expected error for missing source file")
+	}
+	if err := src.Noop(); err != nil {
+		t.Errorf("Source connection unusable after failed transfer: %v", err)
+	}
+	if err := dst.Noop(); err != nil {
+		t.Errorf("Destination connection unusable after failed transfer: %v", err)
+	}
+
+	// A rejected destination path must abort the source transfer
+	if err := src.TransferTo(dst, "source.bin", "no/such/dir/copy.bin"); err == nil {
+		t.Error("Expected error for invalid destination path")
+	}
+	if err := src.Noop(); err != nil {
+		t.Errorf("Source connection unusable after aborted transfer: %v", err)
+	}
+	if err := dst.Noop(); err != nil {
+		t.Errorf("Destination connection unusable after aborted transfer: %v", err)
+	}
+}
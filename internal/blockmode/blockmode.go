@@ -0,0 +1,234 @@
+// Package blockmode implements the block transmission mode of RFC 959
+// Section 3.4.2 (MODE B), used by the FTP client and server to keep a data
+// connection open between transfers.
+//
+// In block mode, data is sent as blocks with a three byte header: a
+// descriptor and a 16-bit byte count. The end of a file is marked by a
+// block with the EOF descriptor instead of by closing the connection, so
+// the connection can carry the next transfer.
+package blockmode
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Block descriptors (RFC 959 Section 3.4.2).
+const (
+	DescEOR     = 0x80 // End of data block is EOR
+	DescEOF     = 0x40 // End of data block is EOF
+	DescError   = 0x20 // Suspected errors in data block
+	DescRestart = 0x10 // Data block is a restart marker
+)
+
+// MaxBlock is the largest number of data bytes in a block.
+const MaxBlock = 0xffff
+
+const headerSize = 3
+
+// Writer writes data as blocks.
+type Writer struct {
+	w   io.Writer
+	hdr [headerSize]byte
+}
+
+// NewWriter returns a Writer writing blocks to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write writes p as one or more data blocks.
+func (w *Writer) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), MaxBlock)]
+		if err := w.writeBlock(0, chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Close writes an empty block marking the end of the file. It does not
+// close the underlying writer.
+func (w *Writer) Close() error {
+	return w.writeBlock(DescEOF, nil)
+}
+
+func (w *Writer) writeBlock(desc byte, data []byte) error {
+	w.hdr[0] = desc
+	binary.BigEndian.PutUint16(w.hdr[1:], uint16(len(data)))
+	// A single write (writev) per block on TCP connections
+	if len(data) == 0 {
+		_, err := w.w.Write(w.hdr[:])
+		return err
+	}
+	bufs := net.Buffers{w.hdr[:], data}
+	_, err := bufs.WriteTo(w.w)
+	return err
+}
+
+// Reader reads the data of blocks up to the end of the file. Restart
+// markers are skipped.
+type Reader struct {
+	r         io.Reader
+	hdr       [headerSize]byte
+	remaining int  // Data bytes left in the current block
+	last      bool // The current block is the last one
+	eof       bool
+}
+
+// NewReader returns a Reader reading blocks from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Read reads data, returning io.EOF after the block marked as the end of
+// the file. If r ends before that block, Read returns
+// io.ErrUnexpectedEOF.
+func (r *Reader) Read(p []byte) (int, error) {
+	for r.remaining == 0 {
+		if r.last {
+			r.eof = true
+		}
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.readHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.r.Read(p[:min(len(p), r.remaining)])
+	r.remaining -= n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// EOF reports whether the block marked as the end of the file was read.
+func (r *Reader) EOF() bool {
+	return r.eof || (r.last && r.remaining == 0)
+}
+
+// readHeader reads the header of the next block, skipping restart markers.
+func (r *Reader) readHeader() error {
+	for {
+		if _, err := io.ReadFull(r.r, r.hdr[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		desc := r.hdr[0]
+		count := int(binary.BigEndian.Uint16(r.hdr[1:]))
+		if desc&DescRestart != 0 {
+			if _, err := io.CopyN(io.Discard, r.r, int64(count)); err != nil {
+				return io.ErrUnexpectedEOF
+			}
+			continue
+		}
+		r.remaining = count
+		r.last = desc&DescEOF != 0
+		return nil
+	}
+}
+
+// Conn is a block mode data connection carrying one transfer. Closing it
+// ends the transfer and, if the connection can carry another one, hands
+// it to the release function instead of closing it.
+type Conn struct {
+	net.Conn
+	r       *Reader
+	w       *Writer
+	release func(reusable net.Conn)
+
+	reading bool  // Data was read: this side receives the file
+	err     error // The first error of the underlying connection
+	aborted atomic.Bool
+	kept    atomic.Bool // Handed to release for another transfer
+	once    sync.Once
+}
+
+// NewConn returns conn as a block mode connection for one transfer.
+// release is called once, when the Conn is closed, with conn if it can
+// carry another transfer or nil if it was closed.
+func NewConn(conn net.Conn, release func(reusable net.Conn)) *Conn {
+	return &Conn{
+		Conn:    conn,
+		r:       NewReader(conn),
+		w:       NewWriter(conn),
+		release: release,
+	}
+}
+
+// Read reads the data of the transfer, returning io.EOF at its end.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.reading = true
+	n, err := c.r.Read(p)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// Write sends p as data of the transfer.
+func (c *Conn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// Close ends the transfer. A receiving side keeps the connection if all
+// the data was read; a sending side, or one that neither read nor wrote,
+// sends the end of file mark and keeps the connection if that succeeds.
+func (c *Conn) Close() error {
+	var err error
+	c.once.Do(func() {
+		reusable := c.err == nil && !c.aborted.Load()
+		if reusable && c.reading {
+			reusable = c.r.EOF()
+		} else if reusable {
+			err = c.w.Close()
+			reusable = err == nil
+		}
+		if !reusable || c.aborted.Load() {
+			c.Conn.Close()
+			c.release(nil)
+			return
+		}
+		c.kept.Store(true)
+		c.release(c.Conn)
+	})
+	return err
+}
+
+// Abort closes the connection, interrupting the transfer, unless the
+// transfer already ended and the connection was kept. Unlike Close, it may
+// be called while another goroutine reads or writes.
+func (c *Conn) Abort() {
+	c.aborted.Store(true)
+	if c.kept.Load() {
+		return
+	}
+	c.Conn.Close()
+	c.once.Do(func() { c.release(nil) })
+}
+
+// Interrupt closes conn to interrupt the transfer using it: a Conn is
+// aborted, and other connections are closed.
+func Interrupt(conn net.Conn) error {
+	if c, ok := conn.(*Conn); ok {
+		c.Abort()
+		return nil
+	}
+	return conn.Close()
+}
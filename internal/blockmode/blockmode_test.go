@@ -0,0 +1,138 @@
+package blockmode
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestWriterReader(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"short", "hello"},
+		{"several blocks", strings.Repeat("0123456789", 20000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			if _, err := io.Copy(w, strings.NewReader(tt.data)); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			buf.WriteString("next transfer")
+
+			r := NewReader(&buf)
+			got, err := io.ReadAll(r)
+			if err != nil || string(got) != tt.data {
+				t.Fatalf("ReadAll = %d bytes, %v; want %d bytes", len(got), err, len(tt.data))
+			}
+			if !r.EOF() {
+				t.Error("EOF() = false after the end of file block")
+			}
+			if rest := buf.String(); rest != "next transfer" {
+				t.Errorf("Reader consumed past the end of file: %q left", rest)
+			}
+		})
+	}
+}
+
+func TestReader(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		blocks string
+		want   string
+		err    error
+	}{
+		{"data in EOF block", "\x40\x00\x02ab", "ab", nil},
+		{"restart marker", "\x00\x00\x01a\x10\x00\x03xyz\x40\x00\x01b", "ab", nil},
+		{"EOR", "\x80\x00\x01a\x40\x00\x00", "a", nil},
+		{"no EOF block", "\x00\x00\x01a", "a", io.ErrUnexpectedEOF},
+		{"short block", "\x40\x00\x05ab", "ab", io.ErrUnexpectedEOF},
+		{"short header", "\x40\x00", "", io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := io.ReadAll(NewReader(strings.NewReader(tt.blocks)))
+			if string(got) != tt.want || err != tt.err {
+				t.Errorf("ReadAll = %q, %v; want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestConn(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	kept := make(chan net.Conn, 2)
+	release := func(conn net.Conn) { kept <- conn }
+
+	// Two transfers on the same connection
+	for _, data := range []string{"first", ""} {
+		go func() {
+			sender := NewConn(server, release)
+			_, _ = sender.Write([]byte(data))
+			_ = sender.Close()
+		}()
+		receiver := NewConn(client, release)
+		got, err := io.ReadAll(receiver)
+		if err != nil || string(got) != data {
+			t.Fatalf("ReadAll = %q, %v; want %q", got, err, data)
+		}
+		_ = receiver.Close()
+		if a, b := <-kept, <-kept; a == nil || b == nil {
+			t.Fatal("Connection not kept after a complete transfer")
+		}
+	}
+
+	// A receiver closing before the end of file drops the connection
+	go func() {
+		sender := NewConn(server, release)
+		_, _ = sender.Write([]byte("data"))
+		_ = sender.Close()
+	}()
+	receiver := NewConn(client, release)
+	if _, err := receiver.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	_ = receiver.Close()
+	if a, b := <-kept, <-kept; a != nil || b != nil {
+		t.Error("Connection kept after an incomplete transfer")
+	}
+}
+
+func TestAbort(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer server.Close()
+
+	released := make(chan net.Conn, 1)
+	c := NewConn(client, func(conn net.Conn) { released <- conn })
+	done := make(chan error)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		done <- err
+	}()
+	if err := Interrupt(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err == nil {
+		t.Error("Read succeeded after Abort")
+	}
+	_ = c.Close()
+	if conn := <-released; conn != nil {
+		t.Error("Connection kept after Abort")
+	}
+}
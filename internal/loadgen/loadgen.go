@@ -0,0 +1,327 @@
+// Package loadgen drives concurrent FTP clients against a server, to
+// measure throughput and command latency.
+//
+// It is used by the server load benchmark and by cmd/ftpbench.
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+// Operation names, as keys of Result.Latency.
+const (
+	OpLogin = "login"
+	OpNoop  = "noop"
+	OpList  = "list"
+	OpStore = "stor"
+	OpRetr  = "retr"
+)
+
+// Config describes a load run.
+type Config struct {
+	// Addr is the server address ("host:port")
+	Addr string
+
+	// User and Password are the login credentials
+	User, Password string
+
+	// Clients is the number of concurrent clients (default 1)
+	Clients int
+
+	// Ops is the total number of operations to run, not counting
+	// logins. If zero, the run lasts Duration instead.
+	Ops int
+
+	// Duration is how long the run lasts when Ops is zero (default 10s)
+	Duration time.Duration
+
+	// Sizes are the file sizes uploaded and downloaded, in turn
+	// (default 1 KiB, 64 KiB and 1 MiB)
+	Sizes []int
+
+	// SessionOps is the number of operations each client runs before it
+	// logs out and in again (default 50; negative = never)
+	SessionOps int
+
+	// Dir is the directory the clients work in (default the login
+	// directory). It must exist.
+	Dir string
+
+	// Options are passed to ftp.Dial
+	Options []ftp.Option
+}
+
+// Latency summarizes the durations of one operation.
+type Latency struct {
+	Count         int
+	Mean          time.Duration
+	P50, P99, Max time.Duration
+}
+
+// Result is the outcome of a load run.
+type Result struct {
+	// Ops is the number of operations run, logins included, and Errors
+	// how many failed
+	Ops, Errors int
+
+	// Bytes is the number of bytes transferred
+	Bytes int64
+
+	// Elapsed is the duration of the run
+	Elapsed time.Duration
+
+	// Latency has the latency of each operation, by name (OpLogin, ...)
+	Latency map[string]Latency
+
+	// Err is the first error, if any
+	Err error
+}
+
+// OpsPerSecond returns the operation rate of the run.
+func (r *Result) OpsPerSecond() float64 {
+	return float64(r.Ops) / r.Elapsed.Seconds()
+}
+
+// Throughput returns the transfer rate of the run, in bytes per second.
+func (r *Result) Throughput() float64 {
+	return float64(r.Bytes) / r.Elapsed.Seconds()
+}
+
+// String formats the result as a report, one line per operation.
+func (r *Result) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d ops in %v (%.0f ops/s), %d errors, %.2f MB/s\n",
+		r.Ops, r.Elapsed.Round(time.Millisecond), r.OpsPerSecond(), r.Errors, r.Throughput()/1e6)
+	for _, op := range []string{OpLogin, OpNoop, OpList, OpStore, OpRetr} {
+		l, ok := r.Latency[op]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "%-6s %7d  mean %-10v p50 %-10v p99 %-10v max %v\n",
+			op, l.Count, l.Mean.Round(time.Microsecond), l.P50.Round(time.Microsecond),
+			l.P99.Round(time.Microsecond), l.Max.Round(time.Microsecond))
+	}
+	if r.Err != nil {
+		fmt.Fprintf(&b, "first error: %v\n", r.Err)
+	}
+	return b.String()
+}
+
+// Run runs the load described by cfg until it is done or ctx is canceled.
+// Failed operations are counted in the result; Run only returns an error
+// if cfg is invalid.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("loadgen: no address")
+	}
+	cfg.Clients = max(cfg.Clients, 1)
+	if cfg.Ops == 0 {
+		if cfg.Duration <= 0 {
+			cfg.Duration = 10 * time.Second
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+	if len(cfg.Sizes) == 0 {
+		cfg.Sizes = []int{1 << 10, 64 << 10, 1 << 20}
+	}
+	if cfg.SessionOps == 0 {
+		cfg.SessionOps = 50
+	}
+
+	// One payload, sliced for each size
+	payload := make([]byte, slices.Max(cfg.Sizes))
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range payload {
+		payload[i] = byte(rng.Uint32())
+	}
+
+	var (
+		remaining atomic.Int64 // Operations left to start (Ops > 0)
+		wg        sync.WaitGroup
+	)
+	remaining.Store(int64(cfg.Ops))
+	workers := make([]*worker, cfg.Clients)
+	start := time.Now()
+	for i := range workers {
+		w := &worker{id: i, cfg: &cfg, payload: payload, latency: make(map[string][]time.Duration)}
+		workers[i] = w
+		wg.Go(func() {
+			w.run(ctx, func() bool {
+				if ctx.Err() != nil {
+					return false
+				}
+				return cfg.Ops == 0 || remaining.Add(-1) >= 0
+			})
+		})
+	}
+	wg.Wait()
+
+	res := &Result{Elapsed: time.Since(start), Latency: make(map[string]Latency)}
+	all := make(map[string][]time.Duration)
+	for _, w := range workers {
+		res.Ops += w.ops
+		res.Errors += w.errors
+		res.Bytes += w.bytes
+		if res.Err == nil {
+			res.Err = w.err
+		}
+		for op, d := range w.latency {
+			all[op] = append(all[op], d...)
+		}
+	}
+	for op, d := range all {
+		res.Latency[op] = summarize(d)
+	}
+	return res, nil
+}
+
+// summarize computes the latency statistics of d, which it sorts.
+func summarize(d []time.Duration) Latency {
+	slices.Sort(d)
+	var sum time.Duration
+	for _, v := range d {
+		sum += v
+	}
+	return Latency{
+		Count: len(d),
+		Mean:  sum / time.Duration(len(d)),
+		P50:   d[len(d)*50/100],
+		P99:   d[len(d)*99/100],
+		Max:   d[len(d)-1],
+	}
+}
+
+// worker is one client of a run.
+type worker struct {
+	id      int
+	cfg     *Config
+	payload []byte
+
+	c       *ftp.Client
+	session int // Operations run in the current session
+	step    int // Position in the operation cycle
+
+	ops, errors int
+	bytes       int64
+	err         error
+	latency     map[string][]time.Duration
+}
+
+// run runs operations while next allows it.
+func (w *worker) run(ctx context.Context, next func() bool) {
+	defer w.logout()
+	for next() {
+		if w.c == nil || (w.cfg.SessionOps > 0 && w.session >= w.cfg.SessionOps) {
+			w.logout()
+			w.login()
+			if w.c == nil {
+				// Back off, so a refusing server does not spin the loop
+				select {
+				case <-ctx.Done():
+				case <-time.After(10 * time.Millisecond):
+				}
+				continue
+			}
+		}
+		w.operate()
+	}
+}
+
+// record records the outcome of an operation.
+func (w *worker) record(op string, start time.Time, err error) {
+	w.ops++
+	w.latency[op] = append(w.latency[op], time.Since(start))
+	if err != nil {
+		w.errors++
+		if w.err == nil {
+			w.err = fmt.Errorf("%s: %w", op, err)
+		}
+	}
+}
+
+func (w *worker) login() {
+	start := time.Now()
+	c, err := ftp.Dial(w.cfg.Addr, w.cfg.Options...)
+	if err == nil {
+		err = c.Login(w.cfg.User, w.cfg.Password)
+		if err == nil && w.cfg.Dir != "" {
+			err = c.ChangeDir(w.cfg.Dir)
+		}
+		if err != nil {
+			_ = c.Quit()
+		}
+	}
+	w.record(OpLogin, start, err)
+	if err == nil {
+		w.c = c
+		w.session = 0
+	}
+}
+
+func (w *worker) logout() {
+	if w.c != nil {
+		_ = w.c.Quit()
+		w.c = nil
+	}
+}
+
+// operate runs the next operation of the cycle: for each size, an upload,
+// a download of the uploaded file, a listing and a NOOP.
+func (w *worker) operate() {
+	size := w.cfg.Sizes[w.step/4%len(w.cfg.Sizes)]
+	name := fmt.Sprintf("load-%d-%d.bin", w.id, size)
+	op := w.step % 4
+	w.step++
+	w.session++
+
+	start := time.Now()
+	var err error
+	switch op {
+	case 0:
+		err = w.c.Store(name, bytes.NewReader(w.payload[:size]))
+		if err == nil {
+			w.bytes += int64(size)
+		}
+		w.record(OpStore, start, err)
+	case 1:
+		cw := &countingWriter{}
+		err = w.c.Retrieve(name, cw)
+		w.bytes += cw.n
+		if err == nil && cw.n != int64(size) {
+			err = fmt.Errorf("%s: got %d bytes, want %d", name, cw.n, size)
+		}
+		w.record(OpRetr, start, err)
+	case 2:
+		_, err = w.c.List("")
+		w.record(OpList, start, err)
+	case 3:
+		err = w.c.Noop()
+		w.record(OpNoop, start, err)
+	}
+	if err != nil {
+		// Start over with a new session; the control connection may be
+		// out of sync
+		w.logout()
+	}
+}
+
+// countingWriter discards what is written, counting the bytes.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,82 @@
+package loadgen
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp/server"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	rootDir := t.TempDir()
+	driver, err := server.NewFSDriver(rootDir,
+		server.WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+			return rootDir, false, nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := server.NewServer(ln.Addr().String(), server.WithDriver(driver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	})
+
+	res, err := Run(context.Background(), Config{
+		Addr:       ln.Addr().String(),
+		User:       "user",
+		Password:   "pass",
+		Clients:    3,
+		Ops:        48,
+		Sizes:      []int{100, 10000},
+		SessionOps: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Errors != 0 {
+		t.Fatalf("%d errors, first: %v", res.Errors, res.Err)
+	}
+
+	// Every operation ran, with a login every 5 operations of a client
+	ops := 0
+	for _, op := range []string{OpNoop, OpList, OpStore, OpRetr} {
+		l := res.Latency[op]
+		if l.Count == 0 || l.P50 > l.P99 || l.P99 > l.Max {
+			t.Errorf("%s latency = %+v", op, l)
+		}
+		ops += l.Count
+	}
+	if ops != 48 {
+		t.Errorf("ran %d operations, want 48", ops)
+	}
+	if logins := res.Latency[OpLogin].Count; logins < 48/5 {
+		t.Errorf("%d logins, want at least %d", logins, 48/5)
+	}
+	if res.Ops != ops+res.Latency[OpLogin].Count {
+		t.Errorf("Ops = %d, want %d", res.Ops, ops+res.Latency[OpLogin].Count)
+	}
+	if res.Bytes == 0 {
+		t.Error("no bytes transferred")
+	}
+}
+
+func TestRun_NoAddress(t *testing.T) {
+	t.Parallel()
+	if _, err := Run(context.Background(), Config{}); err == nil {
+		t.Error("Run without address succeeded")
+	}
+}
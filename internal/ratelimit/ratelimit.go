@@ -42,6 +42,38 @@ func New(bytesPerSecond int64) *Limiter {
 	}
 }
 
+// NewAdjustable creates a rate limiter whose limit can be changed with
+// SetRate. Unlike New, it returns a limiter even when bytesPerSecond is 0 or
+// less, which means no limit.
+func NewAdjustable(bytesPerSecond int64) *Limiter {
+	rl := &Limiter{lastUpdate: time.Now()}
+	rl.SetRate(bytesPerSecond)
+	rl.tokens = rl.burst // Start with full bucket
+	return rl
+}
+
+// SetRate changes the limit to bytesPerSecond, from the next chunk read or
+// written on. A value of 0 or less removes the limit.
+func (rl *Limiter) SetRate(bytesPerSecond int64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.rate = max(float64(bytesPerSecond), 0)
+	rl.burst = rl.rate // Allow 1 second burst
+	rl.tokens = min(rl.tokens, rl.burst)
+}
+
+// Limited reports whether the limiter has a limit. It is false for a nil
+// limiter.
+func (rl *Limiter) Limited() bool {
+	if rl == nil {
+		return false
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.rate > 0
+}
+
 // take attempts to consume n tokens from the bucket.
 // If insufficient tokens are available, it sleeps for the minimum time needed.
 func (rl *Limiter) take(n int) {
@@ -52,6 +84,12 @@ func (rl *Limiter) take(n int) {
 	rl.mu.Lock()
 
 	now := time.Now()
+	if rl.rate <= 0 {
+		// No limit (see SetRate)
+		rl.lastUpdate = now
+		rl.mu.Unlock()
+		return
+	}
 	elapsed := now.Sub(rl.lastUpdate).Seconds()
 
 	// Add tokens based on elapsed time
@@ -241,6 +241,41 @@ func TestUnlimitedRate(t *testing.T) {
 	}
 }
 
+func TestSetRate(t *testing.T) {
+	t.Parallel()
+	data := make([]byte, 4*1024)
+
+	limiter := NewAdjustable(0)
+	if limiter == nil || limiter.Limited() {
+		t.Fatal("NewAdjustable(0) should return an unlimited limiter")
+	}
+
+	// 1 KB/s: the first second is the burst, the rest takes about 3 seconds
+	limiter.SetRate(1024)
+	if !limiter.Limited() {
+		t.Error("Limiter should be limited after SetRate(1024)")
+	}
+	start := time.Now()
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		limiter.SetRate(0) // Lift the limit mid-transfer
+	}()
+	if _, err := io.Copy(io.Discard, NewReader(bytes.NewReader(data), limiter)); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Read took %v after the limit was lifted", d)
+	}
+	if limiter.Limited() {
+		t.Error("Limiter should be unlimited after SetRate(0)")
+	}
+
+	var nilLimiter *Limiter
+	if nilLimiter.Limited() {
+		t.Error("Nil limiter should not be limited")
+	}
+}
+
 func BenchmarkReader(b *testing.B) {
 	data := make([]byte, 1024)
 	limiter := New(1024 * 1024) // 1 MB/s
@@ -0,0 +1,110 @@
+package ftp_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+func TestClient_ListIter(t *testing.T) {
+	t.Parallel()
+
+	addr, cleanup, rootDir := setupServer(t)
+	defer cleanup()
+
+	const numFiles = 5000
+	for i := range numFiles {
+		if err := os.WriteFile(filepath.Join(rootDir, fmt.Sprintf("file%05d.txt", i)), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := ftp.Dial(addr, ftp.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Quit() }()
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for entry, err := range c.ListIter("") {
+		if err != nil {
+			t.Fatalf("ListIter failed: %v", err)
+		}
+		if entry.Type != "file" || entry.Size != 1 {
+			t.Errorf("Unexpected entry: %+v", entry)
+		}
+		count++
+	}
+	if count != numFiles {
+		t.Errorf("ListIter yielded %d entries, want %d", count, numFiles)
+	}
+
+	count = 0
+	for entry, err := range c.MLListIter("") {
+		if err != nil {
+			t.Fatalf("MLListIter failed: %v", err)
+		}
+		if entry.Type != "file" {
+			t.Errorf("Unexpected entry: %+v", entry)
+		}
+		count++
+	}
+	if count != numFiles {
+		t.Errorf("MLListIter yielded %d entries, want %d", count, numFiles)
+	}
+
+	// Breaking early aborts the listing and leaves the connection usable
+	for _, name := range []string{"ListIter", "MLListIter"} {
+		count = 0
+		if name == "ListIter" {
+			for _, err := range c.ListIter("") {
+				if err != nil {
+					t.Fatalf("%s failed: %v", name, err)
+				}
+				if count++; count == 10 {
+					break
+				}
+			}
+		} else {
+			for _, err := range c.MLListIter("") {
+				if err != nil {
+					t.Fatalf("%s failed: %v", name, err)
+				}
+				if count++; count == 10 {
+					break
+				}
+			}
+		}
+		if err := c.Noop(); err != nil {
+			t.Fatalf("NOOP after breaking out of %s failed: %v", name, err)
+		}
+	}
+
+	entries, err := c.List("")
+	if err != nil {
+		t.Fatalf("List after early break failed: %v", err)
+	}
+	if len(entries) != numFiles {
+		t.Errorf("List returned %d entries, want %d", len(entries), numFiles)
+	}
+
+	// Errors are yielded once
+	errs := 0
+	for entry, err := range c.ListIter("missing") {
+		if err == nil {
+			t.Errorf("Unexpected entry for missing directory: %+v", entry)
+			continue
+		}
+		errs++
+	}
+	if errs != 1 {
+		t.Errorf("Got %d errors for missing directory, want 1", errs)
+	}
+}
@@ -0,0 +1,104 @@
+package ftp
+
+import (
+	"bufio"
+	"slices"
+	"time"
+)
+
+// transferKeepAlive sends NOOP commands on the control connection while a
+// data transfer is in progress (see WithTransferKeepAlive).
+type transferKeepAlive struct {
+	stop chan struct{}
+	done chan struct{}
+
+	// sent is the number of NOOPs sent; read only after done is closed
+	sent int
+}
+
+// startTransferKeepAlive starts sending NOOP commands every
+// transferKeepAliveInterval until stopTransferKeepAlive is called.
+func (c *Client) startTransferKeepAlive() {
+	if c.transferKeepAliveInterval <= 0 {
+		return
+	}
+
+	k := &transferKeepAlive{stop: make(chan struct{}), done: make(chan struct{})}
+	c.mu.Lock()
+	c.transferKeepAlive = k
+	c.mu.Unlock()
+
+	go func() {
+		defer close(k.done)
+		ticker := time.NewTicker(c.transferKeepAliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.mu.Lock()
+				err := c.writeKeepAliveLocked()
+				c.mu.Unlock()
+				if err != nil {
+					return
+				}
+				k.sent++
+			case <-k.stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopTransferKeepAlive stops sending NOOP commands and returns how many
+// were sent, whose replies are still to be read.
+func (c *Client) stopTransferKeepAlive() int {
+	c.mu.Lock()
+	k := c.transferKeepAlive
+	c.transferKeepAlive = nil
+	c.mu.Unlock()
+
+	if k == nil {
+		return 0
+	}
+	close(k.stop)
+	<-k.done
+	return k.sent
+}
+
+// readTransferReply reads the reply that completes a data transfer, skipping
+// the replies to the NOOPs sent during it. Some servers reply to NOOP as soon
+// as it arrives, others only after the transfer, so the NOOP replies may come
+// before or after the transfer reply. Before it, only 200 replies are taken
+// as NOOP replies. If final is not nil, it is the transfer reply, already
+// read, and only the NOOP replies are left.
+func (c *Client) readTransferReply(r *bufio.Reader, final *Response, noops int) (*Response, error) {
+	for final == nil || noops > 0 {
+		resp, err := readResponse(r)
+		if err != nil {
+			return nil, err
+		}
+		if final == nil && (noops == 0 || resp.Code != 200) {
+			c.recordTransferReply(resp, false)
+			final = resp
+			continue
+		}
+		if c.debugEnabled() {
+			c.logger.Debug("ftp keep-alive reply", "code", resp.Code, "message", resp.Message)
+		}
+		c.recordTransferReply(resp, true)
+		noops--
+	}
+	return final, nil
+}
+
+// recordTransferReply records a reply read by readTransferReply, matching it
+// with the oldest keep-alive NOOP or, if keepAlive is false, the oldest other
+// command awaiting a response.
+func (c *Client) recordTransferReply(resp *Response, keepAlive bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := slices.IndexFunc(c.sent, func(cmd sentCommand) bool {
+		return cmd.keepAlive == keepAlive
+	})
+	c.recordResponseLocked(resp, i)
+}
@@ -0,0 +1,112 @@
+package ftp
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp/ftptest"
+)
+
+func TestTransferKeepAlive_DelayedReplies(t *testing.T) {
+	t.Parallel()
+	ms := newMockServer(t)
+
+	dataL, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms.dataListener = dataL
+	_, portStr, _ := net.SplitHostPort(dataL.Addr().String())
+
+	// The mock handles one command at a time, so the NOOPs sent during
+	// RETR are answered after the transfer reply.
+	var noops atomic.Int32
+	ms.handlers["EPSV"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("229 Entering Extended Passive Mode (|||%s|)", portStr)
+	}
+	ms.handlers["RETR"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("150 Opening data connection.")
+		dconn, err := dataL.Accept()
+		if err != nil {
+			return
+		}
+		// 25 bytes at 200 B/s take 125ms
+		slow := ftptest.Link{Bandwidth: 200}.Conn(dconn)
+		_, _ = slow.Write([]byte(strings.Repeat("chunk", 5)))
+		slow.Close()
+		_ = c.PrintfLine("226 Transfer complete.")
+	}
+	ms.handlers["NOOP"] = func(c *textproto.Conn, args string) {
+		noops.Add(1)
+		_ = c.PrintfLine("200 NOOP ok.")
+	}
+	ms.handlers["PWD"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine(`257 "/home" is the current directory.`)
+	}
+	ms.start()
+	t.Cleanup(ms.stop)
+
+	c, err := Dial(ms.addr, WithTimeout(time.Second), WithTransferKeepAlive(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Quit() })
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Retrieve("file", &buf); err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	if buf.String() != "chunkchunkchunkchunkchunk" {
+		t.Errorf("content = %q", buf.String())
+	}
+
+	// The NOOP replies were consumed, so PWD gets its own reply
+	dir, err := c.CurrentDir()
+	if err != nil || dir != "/home" {
+		t.Errorf("CurrentDir = %q, %v", dir, err)
+	}
+	if noops.Load() == 0 {
+		t.Error("No NOOP sent during the transfer")
+	}
+}
+
+func TestReadTransferReply(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		replies string
+		noops   int
+		want    int
+	}{
+		{"no noops", "226 Done\r\n", 0, 226},
+		{"noops before", "200 NOOP\r\n200 NOOP\r\n226 Done\r\n", 2, 226},
+		{"noops after", "226 Done\r\n200 NOOP\r\n200 NOOP\r\n", 2, 226},
+		{"noops around", "200 NOOP\r\n226 Done\r\n200 NOOP\r\n", 2, 226},
+		{"failed transfer", "451 Aborted\r\n200 NOOP\r\n", 1, 451},
+		{"completed with 200", "200 NOOP\r\n200 Done\r\n", 1, 200},
+	}
+	for _, tt := range tests {
+		c := &Client{}
+		r := bufio.NewReader(strings.NewReader(tt.replies + "257 next\r\n"))
+		resp, err := c.readTransferReply(r, nil, tt.noops)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if resp.Code != tt.want {
+			t.Errorf("%s: code = %d, want %d", tt.name, resp.Code, tt.want)
+		}
+		if next, _ := readResponse(r); next == nil || next.Code != 257 {
+			t.Errorf("%s: replies not fully consumed", tt.name)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package ftp_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+// legacyTLSServer is a control connection only server with scripted
+// replies to AUTH, PBSZ and PROT, for servers that predate RFC 4217.
+type legacyTLSServer struct {
+	addr     string
+	commands chan []string
+}
+
+// startLegacyTLSServer starts a server answering the commands in replies,
+// and switching to TLS after a 234 or 334 reply to AUTH.
+func startLegacyTLSServer(t *testing.T, replies map[string]string) *legacyTLSServer {
+	t.Helper()
+	certPath, keyPath, _, _ := generateCert(t, false, nil, nil)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &legacyTLSServer{addr: ln.Addr().String(), commands: make(chan []string, 1)}
+	go func() {
+		var commands []string
+		defer func() { s.commands <- commands }()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { conn.Close() }()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		r := bufio.NewReader(conn)
+		fmt.Fprintf(conn, "220 Legacy device ready\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.TrimSpace(line)
+			commands = append(commands, cmd)
+			reply, ok := replies[cmd]
+			switch {
+			case ok:
+			case strings.HasPrefix(cmd, "USER"):
+				reply = "331 Password required"
+			case strings.HasPrefix(cmd, "PASS"):
+				reply = "230 Logged in"
+			case cmd == "QUIT":
+				fmt.Fprintf(conn, "221 Bye\r\n")
+				return
+			default:
+				reply = "502 Command not implemented"
+			}
+			fmt.Fprintf(conn, "%s\r\n", reply)
+			if strings.HasPrefix(cmd, "AUTH") && (strings.HasPrefix(reply, "234") || strings.HasPrefix(reply, "334")) {
+				tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+				if tlsConn.Handshake() != nil {
+					return
+				}
+				conn = tlsConn
+				r = bufio.NewReader(conn)
+			}
+		}
+	}()
+	return s
+}
+
+func TestLegacyTLS(t *testing.T) {
+	t.Parallel()
+	authSSL := map[string]string{"AUTH TLS": "504 Unknown mechanism", "AUTH SSL": "234 AUTH SSL OK"}
+	with := func(replies map[string]string) map[string]string {
+		m := map[string]string{"PBSZ 0": "200 PBSZ=0", "PROT P": "200 Protection set to Private"}
+		for k, v := range authSSL {
+			m[k] = v
+		}
+		for k, v := range replies {
+			m[k] = v
+		}
+		return m
+	}
+
+	tests := []struct {
+		name     string
+		replies  map[string]string
+		commands []string
+		err      string
+	}{
+		{
+			name:     "AUTH SSL",
+			replies:  with(nil),
+			commands: []string{"AUTH TLS", "AUTH SSL", "PBSZ 0", "PROT P"},
+		},
+		{
+			name:     "AUTH SSL draft reply",
+			replies:  with(map[string]string{"AUTH SSL": "334 AUTH SSL OK"}),
+			commands: []string{"AUTH TLS", "AUTH SSL", "PBSZ 0", "PROT P"},
+		},
+		{
+			name:     "AUTH TLS",
+			replies:  with(map[string]string{"AUTH TLS": "234 Proceed"}),
+			commands: []string{"AUTH TLS", "PBSZ 0", "PROT P"},
+		},
+		{
+			name:     "PBSZ unknown",
+			replies:  with(map[string]string{"PBSZ 0": "500 Unknown command"}),
+			commands: []string{"AUTH TLS", "AUTH SSL", "PBSZ 0", "PROT P"},
+		},
+		{
+			name:     "PROT before PBSZ",
+			replies:  with(map[string]string{"PBSZ 0": "503 PROT first"}),
+			commands: []string{"AUTH TLS", "AUTH SSL", "PBSZ 0", "PROT P", "PBSZ 0"},
+		},
+		{
+			name:    "PROT refused",
+			replies: with(map[string]string{"PROT P": "536 Level not supported"}),
+			err:     "server accepted PBSZ 0 but refused PROT P",
+		},
+		{
+			name:    "PBSZ and PROT refused",
+			replies: with(map[string]string{"PBSZ 0": "500 Unknown command", "PROT P": "500 Unknown command"}),
+			err:     "server refused PBSZ 0 (500 Unknown command) and PROT P",
+		},
+		{
+			name:    "AUTH refused",
+			replies: with(map[string]string{"AUTH SSL": "504 Unknown mechanism"}),
+			err:     "server refused AUTH TLS (504 Unknown mechanism) and AUTH SSL",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := startLegacyTLSServer(t, tt.replies)
+			c, err := ftp.Dial(s.addr,
+				ftp.WithTimeout(5*time.Second),
+				ftp.WithExplicitTLS(&tls.Config{InsecureSkipVerify: true}),
+				ftp.WithLegacyTLS(),
+			)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Dial error = %v, want %q", err, tt.err)
+				}
+				var pe *ftp.ProtocolError
+				if !errors.As(err, &pe) {
+					t.Errorf("Dial error %v is not a ProtocolError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			if err := c.Login("user", "pass"); err != nil {
+				t.Fatalf("Login failed: %v", err)
+			}
+			if !c.Config().TLS.Legacy {
+				t.Error("Config().TLS.Legacy = false, want true")
+			}
+			_ = c.Quit()
+			got := <-s.commands
+			if len(got) < len(tt.commands) || !slices.Equal(got[:len(tt.commands)], tt.commands) {
+				t.Errorf("Commands = %q, want %q first", got, tt.commands)
+			}
+		})
+	}
+}
+
+func TestLegacyTLS_Disabled(t *testing.T) {
+	t.Parallel()
+	s := startLegacyTLSServer(t, map[string]string{"AUTH TLS": "504 Unknown mechanism", "AUTH SSL": "234 AUTH SSL OK"})
+	_, err := ftp.Dial(s.addr,
+		ftp.WithTimeout(5*time.Second),
+		ftp.WithExplicitTLS(&tls.Config{InsecureSkipVerify: true}),
+	)
+	var pe *ftp.ProtocolError
+	if !errors.As(err, &pe) || pe.Code != 504 {
+		t.Fatalf("Dial error = %v, want AUTH TLS refused", err)
+	}
+	if got := <-s.commands; slices.Contains(got, "AUTH SSL") {
+		t.Errorf("Commands = %q, AUTH SSL sent without WithLegacyTLS", got)
+	}
+
+	if _, err := ftp.Dial(s.addr, ftp.WithLegacyTLS()); err == nil {
+		t.Error("WithLegacyTLS without explicit TLS accepted")
+	}
+}
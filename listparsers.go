@@ -0,0 +1,180 @@
+package ftp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// vmsBlockSize is the size of a disk block as reported in VMS listings.
+const vmsBlockSize = 512
+
+// VMSParser parses OpenVMS directory entries.
+//
+// Example: "FILE.TXT;1   3/4   12-JUN-2023 10:15:32  [GROUP,OWNER]  (RWED,RWED,RE,)"
+//
+// The version suffix is removed from the name, ".DIR" files are reported as
+// directories without the extension, and the size is computed from the used
+// block count. Header and "Total of ..." lines are not matched.
+type VMSParser struct{}
+
+func (p *VMSParser) Parse(line string) (*Entry, bool) {
+	var buf [maxListFields]string
+	fields := listFields(buf[:0], line)
+	if len(fields) < 3 || !isVMSDate(fields[2]) {
+		return nil, false
+	}
+
+	name, version, ok := strings.Cut(fields[0], ";")
+	if !ok || name == "" || !isDigits(version) {
+		return nil, false
+	}
+
+	// Size is "used" or "used/allocated" blocks
+	used, _, _ := strings.Cut(fields[1], "/")
+	blocks, err := strconv.ParseInt(used, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	entry := &Entry{Raw: line, Name: name, Type: "file", Size: blocks * vmsBlockSize}
+	if base, ok := strings.CutSuffix(name, ".DIR"); ok && base != "" {
+		entry.Name = base
+		entry.Type = "dir"
+		entry.Size = 0
+	}
+	return entry, true
+}
+
+// isVMSDate checks if a string looks like a VMS date (DD-MMM-YYYY).
+func isVMSDate(s string) bool {
+	parts := strings.Split(s, "-")
+	if len(parts) != 3 {
+		return false
+	}
+	if len(parts[0]) < 1 || len(parts[0]) > 2 || !isDigits(parts[0]) {
+		return false
+	}
+	if _, ok := monthNames[strings.ToLower(parts[1])]; !ok {
+		return false
+	}
+	return len(parts[2]) == 4 && isDigits(parts[2])
+}
+
+// MVSParser parses IBM MVS (z/OS) dataset and PDS member listings.
+//
+// Dataset example:
+//
+//	"WYOSPT 3390   2023/01/12  1   15  FB      80  3120  PO  ADMIN.CNTL"
+//
+// Member example:
+//
+//	"MEMBER1   01.01 2023/01/12 2023/01/12 10:15    10    10     0 USER1"
+//
+// Partitioned datasets (PO, PO-E) and pseudo directories are reported as
+// directories; other datasets and members are reported as files. Sizes are
+// not available in bytes on MVS, so they are left as zero.
+type MVSParser struct{}
+
+func (p *MVSParser) Parse(line string) (*Entry, bool) {
+	var buf [maxListFields]string
+	fields := listFields(buf[:0], line)
+
+	switch {
+	case len(fields) == 2 && fields[0] == "Migrated":
+		return &Entry{Raw: line, Name: fields[1], Type: "file"}, true
+	case len(fields) == 3 && fields[0] == "Pseudo" && fields[1] == "Directory":
+		return &Entry{Raw: line, Name: fields[2], Type: "dir"}, true
+	}
+
+	// Dataset: Volume Unit Referred Ext Used Recfm Lrecl BlkSz Dsorg Dsname
+	if len(fields) >= 9 && (isMVSDate(fields[2]) || fields[2] == "**NONE**") {
+		switch fields[len(fields)-2] {
+		case "PO", "PO-E":
+			return &Entry{Raw: line, Name: fields[len(fields)-1], Type: "dir"}, true
+		case "PS", "DA", "IS", "VS", "VSAM":
+			return &Entry{Raw: line, Name: fields[len(fields)-1], Type: "file"}, true
+		}
+	}
+
+	// Member: Name VV.MM Created Changed [Time] Size Init Mod Id
+	if len(fields) >= 8 && isMVSVersion(fields[1]) && isMVSDate(fields[2]) {
+		return &Entry{Raw: line, Name: fields[0], Type: "file"}, true
+	}
+
+	return nil, false
+}
+
+// isMVSDate checks if a string looks like an MVS date (YYYY/MM/DD).
+func isMVSDate(s string) bool {
+	parts := strings.Split(s, "/")
+	return len(parts) == 3 &&
+		len(parts[0]) == 4 && isDigits(parts[0]) &&
+		len(parts[1]) == 2 && isDigits(parts[1]) &&
+		len(parts[2]) == 2 && isDigits(parts[2])
+}
+
+// isMVSVersion checks if a string looks like a PDS member version (VV.MM).
+func isMVSVersion(s string) bool {
+	vv, mm, ok := strings.Cut(s, ".")
+	return ok && len(vv) == 2 && len(mm) == 2 && isDigits(vv) && isDigits(mm)
+}
+
+// NetWareParser parses Novell NetWare directory entries.
+//
+// Example: "d [RWCEAFMS] supervisor     512 Jan 16 18:53 login"
+type NetWareParser struct{}
+
+func (p *NetWareParser) Parse(line string) (*Entry, bool) {
+	var buf [maxListFields]string
+	fields := listFields(buf[:0], line)
+	if len(fields) < 8 {
+		return nil, false
+	}
+
+	rights := fields[1]
+	if len(rights) < 2 || rights[0] != '[' || rights[len(rights)-1] != ']' {
+		return nil, false
+	}
+
+	entry := &Entry{Raw: line}
+	switch fields[0] {
+	case "d":
+		entry.Type = "dir"
+	case "-":
+		entry.Type = "file"
+	default:
+		return nil, false
+	}
+
+	size, err := parseSize(fields[3])
+	if err != nil {
+		return nil, false
+	}
+	if _, ok := monthNames[strings.ToLower(fields[4])]; !ok {
+		return nil, false
+	}
+
+	entry.Size = size
+	entry.Name = strings.Join(fields[7:], " ")
+	return entry, true
+}
+
+// monthNames holds the lowercase three-letter month abbreviations used in
+// VMS and NetWare listings.
+var monthNames = map[string]struct{}{
+	"jan": {}, "feb": {}, "mar": {}, "apr": {}, "may": {}, "jun": {},
+	"jul": {}, "aug": {}, "sep": {}, "oct": {}, "nov": {}, "dec": {},
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
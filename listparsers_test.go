@@ -0,0 +1,168 @@
+package ftp
+
+import "testing"
+
+func TestParseListLine_LegacyFormats(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name           string
+		line           string
+		expectedName   string
+		expectedType   string
+		expectedSize   int64
+		expectedTarget string
+	}{
+		// Windows "dir"-style listings (IIS, FileZilla Server, Serv-U)
+		{
+			name:         "windows directory with separate AM/PM",
+			line:         "01/15/2024  09:12 AM    <DIR>          Documents",
+			expectedName: "Documents",
+			expectedType: "dir",
+		},
+		{
+			name:         "windows file with thousands separators",
+			line:         "01/15/2024  09:12 PM         1,037,794 report final.pdf",
+			expectedName: "report final.pdf",
+			expectedType: "file",
+			expectedSize: 1037794,
+		},
+		{
+			name:           "windows junction",
+			line:           `01/15/2024  09:12 AM    <JUNCTION>     Application Data [C:\Users\me\AppData\Roaming]`,
+			expectedName:   "Application Data",
+			expectedType:   "link",
+			expectedTarget: `C:\Users\me\AppData\Roaming`,
+		},
+		{
+			name:           "windows directory symlink",
+			line:           `03-02-23  04:05PM       <SYMLINKD>     shared [\\fileserver\share]`,
+			expectedName:   "shared",
+			expectedType:   "link",
+			expectedTarget: `\\fileserver\share`,
+		},
+		{
+			name:         "windows symlink without target",
+			line:         "03-02-23  04:05PM       <SYMLINK>      notes.txt",
+			expectedName: "notes.txt",
+			expectedType: "link",
+		},
+		// OpenVMS (MultiNet, TCPware, HP TCP/IP Services)
+		{
+			name:         "vms file with used/allocated blocks",
+			line:         "CII-MANUAL.TEX;1  213/216  29-JAN-1996 03:33:12  [ANONYMOU,ANONYMOUS]   (RWED,RWED,,)",
+			expectedName: "CII-MANUAL.TEX",
+			expectedType: "file",
+			expectedSize: 213 * 512,
+		},
+		{
+			name:         "vms file with used blocks only",
+			line:         "LOGIN.COM;27               3  12-JUN-2023 10:15:32  [SYSTEM]  (RWED,RWED,RE,)",
+			expectedName: "LOGIN.COM",
+			expectedType: "file",
+			expectedSize: 3 * 512,
+		},
+		{
+			name:         "vms directory",
+			line:         "ARCHIVE.DIR;1        1/3   1-FEB-2022 08:00:00  [GROUP,OWNER]  (RWE,RWE,RE,RE)",
+			expectedName: "ARCHIVE",
+			expectedType: "dir",
+		},
+		// IBM MVS (z/OS)
+		{
+			name:         "mvs partitioned dataset",
+			line:         "WYOSPT 3390   2023/01/12  1   15  FB      80  3120  PO  ADMIN.CNTL",
+			expectedName: "ADMIN.CNTL",
+			expectedType: "dir",
+		},
+		{
+			name:         "mvs sequential dataset",
+			line:         "WYOSP1 3390   2023/01/12  1    1  FB      80 27920  PS  ADMIN.DATA",
+			expectedName: "ADMIN.DATA",
+			expectedType: "file",
+		},
+		{
+			name:         "mvs dataset never referenced",
+			line:         "WYOSP1 3390   **NONE**    1    1  VB     255 27998  PS  ADMIN.LOG",
+			expectedName: "ADMIN.LOG",
+			expectedType: "file",
+		},
+		{
+			name:         "mvs migrated dataset",
+			line:         "Migrated                                                ADMIN.OLD.DATA",
+			expectedName: "ADMIN.OLD.DATA",
+			expectedType: "file",
+		},
+		{
+			name:         "mvs pseudo directory",
+			line:         "Pseudo Directory                                        ADMIN.SUB",
+			expectedName: "ADMIN.SUB",
+			expectedType: "dir",
+		},
+		{
+			name:         "mvs pds member",
+			line:         "MEMBER1   01.01 2023/01/12 2023/01/12 10:15    10    10     0 USER1",
+			expectedName: "MEMBER1",
+			expectedType: "file",
+		},
+		// Novell NetWare
+		{
+			name:         "netware directory",
+			line:         "d [RWCEAFMS] supervisor            512 Jan 16 18:53 login",
+			expectedName: "login",
+			expectedType: "dir",
+			expectedSize: 512,
+		},
+		{
+			name:         "netware file",
+			line:         "- [R----F--] rhesus             214059 Oct 20  2021 cx.exe",
+			expectedName: "cx.exe",
+			expectedType: "file",
+			expectedSize: 214059,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := parseListLine(tt.line, nil)
+			if entry == nil {
+				t.Fatal("parseListLine returned nil")
+			}
+
+			if entry.Name != tt.expectedName {
+				t.Errorf("Name = %q, want %q", entry.Name, tt.expectedName)
+			}
+
+			if entry.Type != tt.expectedType {
+				t.Errorf("Type = %q, want %q", entry.Type, tt.expectedType)
+			}
+
+			if entry.Size != tt.expectedSize {
+				t.Errorf("Size = %d, want %d", entry.Size, tt.expectedSize)
+			}
+
+			if entry.Target != tt.expectedTarget {
+				t.Errorf("Target = %q, want %q", entry.Target, tt.expectedTarget)
+			}
+		})
+	}
+}
+
+func TestLegacyParsers_RejectOtherFormats(t *testing.T) {
+	t.Parallel()
+	lines := []string{
+		"-rw-r--r--   1 user  group     1024 Dec 20 10:30 file.txt",
+		"drwxr-xr-x   2 user  group     4096 Dec 20 10:30 mydir",
+		"09-24-24  10:30AM       <DIR>          logger",
+		"Volume Unit    Referred Ext Used Recfm Lrecl BlkSz Dsorg Dsname",
+		"Total of 3 files, 217/223 blocks.",
+	}
+	parsers := []ListingParser{&VMSParser{}, &MVSParser{}, &NetWareParser{}}
+
+	for _, line := range lines {
+		for _, p := range parsers {
+			if entry, ok := p.Parse(line); ok {
+				t.Errorf("%T matched %q as %+v", p, line, entry)
+			}
+		}
+	}
+}
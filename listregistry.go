@@ -0,0 +1,167 @@
+package ftp
+
+import (
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	registryMu        sync.RWMutex
+	registeredParsers []ListingParser
+)
+
+// RegisterListingParser adds a directory listing parser for all clients.
+// Registered parsers are tried after the parsers passed to WithCustomListParser
+// and before the built-in parsers, in the order they were registered.
+// It is safe to call from multiple goroutines, typically from an init function
+// of a package that supports an unusual server.
+//
+// Example:
+//
+//	func init() {
+//	    ftp.RegisterListingParser(&TandemParser{})
+//	}
+func RegisterListingParser(parser ListingParser) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registeredParsers = append(registeredParsers, parser)
+}
+
+// registeredListingParsers returns a snapshot of the global registry.
+func registeredListingParsers() []ListingParser {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return slices.Clone(registeredParsers)
+}
+
+// serverType identifies the server's operating system family, which tells
+// which LIST format it most likely uses.
+type serverType int
+
+const (
+	serverUnknown serverType = iota
+	serverUnix
+	serverWindows
+	serverVMS
+	serverMVS
+	serverNetWare
+)
+
+func (t serverType) String() string {
+	switch t {
+	case serverUnix:
+		return "unix"
+	case serverWindows:
+		return "windows"
+	case serverVMS:
+		return "vms"
+	case serverMVS:
+		return "mvs"
+	case serverNetWare:
+		return "netware"
+	}
+	return "unknown"
+}
+
+// serverHints maps lowercase substrings found in SYST replies, greetings and
+// FEAT replies to the server type they identify. More specific hints come
+// first: a Windows server may mention "unix" when emulating its listings.
+var serverHints = []struct {
+	hint string
+	typ  serverType
+}{
+	{"windows_nt", serverWindows},
+	{"microsoft ftp", serverWindows},
+	{"openvms", serverVMS},
+	{"vms", serverVMS},
+	{"multinet", serverVMS},
+	{"tcpware", serverVMS},
+	{"mvs", serverMVS},
+	{"z/os", serverMVS},
+	{"ibm ftp cs", serverMVS},
+	{"netware", serverNetWare},
+	{"unix", serverUnix},
+}
+
+// detectServerType fingerprints the server from its SYST reply, greeting and
+// advertised features. The SYST reply is the most reliable and is checked
+// first.
+func detectServerType(syst, greeting string, features map[string]string) serverType {
+	sources := []string{syst, greeting}
+	if len(features) > 0 {
+		var feats []string
+		for name, params := range features {
+			feats = append(feats, name+" "+params)
+		}
+		slices.Sort(feats)
+		sources = append(sources, strings.Join(feats, "\n"))
+	}
+
+	for _, source := range sources {
+		source = strings.ToLower(source)
+		for _, h := range serverHints {
+			if strings.Contains(source, h.hint) {
+				return h.typ
+			}
+		}
+	}
+	return serverUnknown
+}
+
+// builtinParsersFor returns the built-in parsers ordered for the given server
+// type. The parser for the server's native format is moved to the front; the
+// others are kept as fallbacks, since servers can be configured to emulate
+// another system's listings (e.g. IIS with Unix-style listings).
+func builtinParsersFor(typ serverType) []ListingParser {
+	parsers := defaultListingParsers()
+
+	preferred := slices.IndexFunc(parsers, func(p ListingParser) bool {
+		switch p.(type) {
+		case *UnixParser:
+			return typ == serverUnix
+		case *DOSParser:
+			return typ == serverWindows
+		case *VMSParser:
+			return typ == serverVMS
+		case *MVSParser:
+			return typ == serverMVS
+		case *NetWareParser:
+			return typ == serverNetWare
+		}
+		return false
+	})
+	if preferred > 0 {
+		p := parsers[preferred]
+		parsers = slices.Delete(parsers, preferred, preferred+1)
+		parsers = slices.Insert(parsers, 0, p)
+	}
+	return parsers
+}
+
+// fingerprint returns the server type, fingerprinting the server with SYST
+// on first use.
+func (c *Client) fingerprint() serverType {
+	if !c.fingerprinted {
+		syst, _ := c.Syst() // SYST is optional; the greeting may still identify the server
+		var greeting string
+		if g := c.Greeting(); g != nil {
+			greeting = g.Message
+		}
+		c.serverType = detectServerType(syst, greeting, c.features)
+		c.fingerprinted = true
+		if c.debugEnabled() {
+			c.logger.Debug("ftp server fingerprint", "syst", syst, "type", c.serverType)
+		}
+	}
+	return c.serverType
+}
+
+// listingParsers returns the parser chain used by List: the client's custom
+// parsers, then the globally registered ones, then the built-in parsers
+// ordered for the server.
+func (c *Client) listingParsers() []ListingParser {
+	parsers := slices.Clone(c.parsers)
+	parsers = append(parsers, registeredListingParsers()...)
+	return append(parsers, builtinParsersFor(c.fingerprint())...)
+}
@@ -0,0 +1,125 @@
+package ftp
+
+import (
+	"net/textproto"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDetectServerType(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		syst     string
+		greeting string
+		features map[string]string
+		want     serverType
+	}{
+		{"unix syst", "UNIX Type: L8", "ProFTPD Server ready.", nil, serverUnix},
+		{"windows syst", "Windows_NT", "Microsoft FTP Service", nil, serverWindows},
+		{"windows greeting only", "", "Microsoft FTP Service", nil, serverWindows},
+		{"vms syst", "VMS OpenVMS V8.4 is the operating system.", "", nil, serverVMS},
+		{"vms greeting", "", "MultiNet FTP Server Process V5.6(15) at Mon 12-Jun-2023", nil, serverVMS},
+		{"mvs syst", "MVS is the operating system of this server. FTP Server is running on z/OS.", "", nil, serverMVS},
+		{"mvs greeting", "", "IBM FTP CS V2R5 at MVS1.EXAMPLE.COM", nil, serverMVS},
+		{"netware syst", "NETWARE Type: L8", "", nil, serverNetWare},
+		{"syst wins over greeting", "UNIX Type: L8", "Microsoft FTP Service", nil, serverUnix},
+		{"feature hint", "", "FTP server ready.", map[string]string{"SITE": "VMS"}, serverVMS},
+		{"unknown", "", "FTP server ready.", map[string]string{"SIZE": ""}, serverUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectServerType(tt.syst, tt.greeting, tt.features); got != tt.want {
+				t.Errorf("detectServerType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuiltinParsersFor(t *testing.T) {
+	t.Parallel()
+
+	if _, ok := builtinParsersFor(serverVMS)[0].(*VMSParser); !ok {
+		t.Errorf("VMS server: first parser is %T, want *VMSParser", builtinParsersFor(serverVMS)[0])
+	}
+	if _, ok := builtinParsersFor(serverUnix)[0].(*UnixParser); !ok {
+		t.Errorf("Unix server: first parser is %T, want *UnixParser", builtinParsersFor(serverUnix)[0])
+	}
+
+	// Unknown servers keep the default order, and no parser is dropped
+	for _, typ := range []serverType{serverUnknown, serverUnix, serverMVS} {
+		if got, want := len(builtinParsersFor(typ)), len(defaultListingParsers()); got != want {
+			t.Errorf("%v: got %d parsers, want %d", typ, got, want)
+		}
+	}
+	if _, ok := builtinParsersFor(serverUnknown)[0].(*EPLFParser); !ok {
+		t.Errorf("unknown server: first parser is %T, want *EPLFParser", builtinParsersFor(serverUnknown)[0])
+	}
+}
+
+// registryTestParser matches a single magic line
+type registryTestParser struct{}
+
+func (p *registryTestParser) Parse(line string) (*Entry, bool) {
+	if line == "registry-test-entry" {
+		return &Entry{Name: "registered", Type: "file", Raw: line}, true
+	}
+	return nil, false
+}
+
+func TestClient_ListingParsers_FingerprintAndRegistry(t *testing.T) {
+	t.Parallel()
+	ms := newMockServer(t)
+
+	var systCount atomic.Int32
+	ms.handlers["SYST"] = func(c *textproto.Conn, args string) {
+		systCount.Add(1)
+		_ = c.PrintfLine("215 VMS OpenVMS V8.4")
+	}
+
+	ms.start()
+	defer ms.stop()
+
+	RegisterListingParser(&registryTestParser{})
+
+	custom := &CustomParser{}
+	c, err := Dial(ms.addr, WithTimeout(1*time.Second), WithCustomListParser(custom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Quit() }()
+
+	parsers := c.listingParsers()
+	_ = c.listingParsers()
+
+	if n := systCount.Load(); n != 1 {
+		t.Errorf("SYST sent %d times, want 1", n)
+	}
+	if parsers[0] != custom {
+		t.Errorf("first parser is %T, want the custom parser", parsers[0])
+	}
+
+	// Registered parsers come before the built-in ones, the native one first
+	registered := -1
+	for i, p := range parsers {
+		if _, ok := p.(*registryTestParser); ok {
+			registered = i
+			break
+		}
+	}
+	if registered == -1 {
+		t.Fatal("registered parser missing from chain")
+	}
+	if _, ok := parsers[len(parsers)-len(defaultListingParsers())].(*VMSParser); !ok {
+		t.Errorf("first built-in parser is not *VMSParser: %v", parsers)
+	}
+	if registered >= len(parsers)-len(defaultListingParsers()) {
+		t.Errorf("registered parser at %d, after the built-in parsers", registered)
+	}
+
+	if entry := parseListLine("registry-test-entry", parsers); entry.Name != "registered" {
+		t.Errorf("registered parser not used, got %+v", entry)
+	}
+}
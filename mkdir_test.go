@@ -0,0 +1,76 @@
+package ftp_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
+)
+
+func dialTestServer(t *testing.T, s *ftptest.Server, opts ...ftp.Option) *ftp.Client {
+	t.Helper()
+	c, err := ftp.Dial(s.Addr, append([]ftp.Option{ftp.WithTimeout(2 * time.Second)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Quit() })
+	if err := c.Login("user", "pass"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestMkdirAll(t *testing.T) {
+	t.Parallel()
+	s := ftptest.NewServer(t)
+	if err := s.WriteFile("/pub/file.txt", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	c := dialTestServer(t, s)
+
+	for _, dir := range []string{"/a/b/c", "/a/b/c", "/a/b/", "/pub/new", "/", "x/y"} {
+		if err := c.MkdirAll(dir); err != nil {
+			t.Errorf("MkdirAll(%q): %v", dir, err)
+		}
+	}
+	for _, dir := range []string{"/a/b/c", "/pub/new", "/x/y"} {
+		if isDir, err := c.IsDir(dir); err != nil || !isDir {
+			t.Errorf("IsDir(%q) = %v, %v after MkdirAll", dir, isDir, err)
+		}
+	}
+
+	if err := c.MkdirAll("/pub/file.txt/sub"); err == nil {
+		t.Error("MkdirAll through a file succeeded")
+	}
+}
+
+func TestCreateParents(t *testing.T) {
+	t.Parallel()
+	s := ftptest.NewServer(t)
+
+	local := t.TempDir()
+	if err := os.WriteFile(filepath.Join(local, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := dialTestServer(t, s)
+	if err := c.StoreFrom("/missing/a.txt", filepath.Join(local, "a.txt")); err == nil {
+		t.Error("StoreFrom into a missing directory succeeded without WithCreateParents")
+	}
+
+	c = dialTestServer(t, s, ftp.WithCreateParents())
+	if err := c.StoreFrom("/backups/2024/06/a.txt", filepath.Join(local, "a.txt")); err != nil {
+		t.Fatalf("StoreFrom: %v", err)
+	}
+	if err := c.UploadDir(local, "/mirror/deep/tree"); err != nil {
+		t.Fatalf("UploadDir: %v", err)
+	}
+	for _, p := range []string{"/backups/2024/06/a.txt", "/mirror/deep/tree/a.txt"} {
+		if data, err := s.ReadFile(p); err != nil || string(data) != "hello" {
+			t.Errorf("ReadFile(%q) = %q, %v", p, data, err)
+		}
+	}
+}
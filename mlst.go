@@ -3,7 +3,7 @@ package ftp
 import (
 	"bufio"
 	"fmt"
-	"net"
+	"iter"
 	"strconv"
 	"strings"
 	"time"
@@ -58,6 +58,11 @@ func (c *Client) MLStat(path string) (*MLEntry, error) {
 		}
 	}
 
+	return parseMLSTResponse(resp)
+}
+
+// parseMLSTResponse extracts the entry from a successful MLST reply.
+func parseMLSTResponse(resp *Response) (*MLEntry, error) {
 	// MLST returns a multi-line response with the entry on the second line
 	// Format: "250-Listing path\n facts entry-name\n250 End"
 	if len(resp.Lines) < 2 {
@@ -67,13 +72,13 @@ func (c *Client) MLStat(path string) (*MLEntry, error) {
 	// Find the line with the entry (starts with a space)
 	var entryLine string
 	for _, line := range resp.Lines {
-		trimmed := strings.TrimSpace(line)
 		// Skip status lines
 		if len(line) >= 4 && (line[3] == '-' || line[3] == ' ') {
 			continue
 		}
-		// This should be the entry line
-		if trimmed != "" {
+		// This should be the entry line, which starts with a space. Trailing
+		// spaces may belong to the file name.
+		if trimmed := strings.TrimLeft(line, " "); strings.TrimSpace(trimmed) != "" {
 			entryLine = trimmed
 			break
 		}
@@ -104,48 +109,124 @@ func (c *Client) MLStat(path string) (*MLEntry, error) {
 //	    fmt.Printf("%s: %d bytes\n", entry.Name, entry.Size)
 //	}
 func (c *Client) MLList(path string) ([]*MLEntry, error) {
-	// Open data connection and send MLSD command
-	var dataConn net.Conn
-	var err error
-
-	if path == "" {
-		_, dataConn, err = c.cmdDataConnFrom("MLSD")
-	} else {
-		_, dataConn, err = c.cmdDataConnFrom("MLSD", path)
-	}
-	if err != nil {
-		return nil, err
+	var entries []*MLEntry
+	for entry, err := range c.MLListIter(path) {
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
 	}
+	return entries, nil
+}
 
-	// Read the directory listing
-	var entries []*MLEntry
-	scanner := bufio.NewScanner(dataConn)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
+// MLListIter returns an iterator over the entries of an MLSD directory
+// listing. It is the streaming equivalent of MLList, and behaves like
+// ListIter: entries are yielded as they are read, a failure is yielded as a
+// single error, and breaking out of the loop early aborts the listing.
+//
+// Example:
+//
+//	for entry, err := range client.MLListIter("/pub") {
+//	    if err != nil {
+//	        log.Fatal(err)
+//	    }
+//	    fmt.Printf("%s: %d bytes\n", entry.Name, entry.Size)
+//	}
+func (c *Client) MLListIter(path string) iter.Seq2[*MLEntry, error] {
+	return func(yield func(*MLEntry, error) bool) {
+		if c.quirks.DisableMLSD {
+			c.mlListFromLIST(path, yield)
+			return
 		}
 
-		entry, parseErr := parseMLEntry(line)
-		if parseErr != nil {
-			// Skip malformed entries but continue processing
-			continue
+		dataConn, err := c.listDataConn("MLSD", path)
+		if err != nil {
+			if notImplemented(err) {
+				c.quirks.DisableMLSD = true
+				c.detectedQuirk("DisableMLSD")
+				c.mlListFromLIST(path, yield)
+				return
+			}
+			yield(nil, err)
+			return
 		}
 
-		entries = append(entries, entry)
-	}
+		buf := listBufPool.Get().(*[4096]byte)
+		defer listBufPool.Put(buf)
+		scanner := bufio.NewScanner(dataConn)
+		scanner.Buffer(buf[:], bufio.MaxScanTokenSize)
+		for scanner.Scan() {
+			// Trailing spaces may belong to the file name
+			line := strings.TrimLeft(c.decodeName(scanner.Text()), " ")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+
+			entry, parseErr := parseMLEntry(line)
+			if parseErr != nil {
+				// Skip malformed entries but continue processing
+				continue
+			}
+
+			if !yield(entry, nil) {
+				// Stopped early: the server reports the aborted transfer
+				_ = c.finishDataConn(dataConn)
+				return
+			}
+		}
 
-	if err := scanner.Err(); err != nil {
-		dataConn.Close()
-		return nil, fmt.Errorf("failed to read directory listing: %w", err)
+		if err := scanner.Err(); err != nil {
+			dataConn.Close()
+			yield(nil, fmt.Errorf("failed to read directory listing: %w", err))
+			return
+		}
+
+		// Finish the data connection
+		if err := c.finishDataConn(dataConn); err != nil {
+			yield(nil, err)
+		}
 	}
+}
 
-	// Finish the data connection
-	if err := c.finishDataConn(dataConn); err != nil {
-		return nil, err
+// mlListFromLIST yields the entries of a LIST listing as MLEntry values, for
+// servers that cannot list with MLSD. Only the name, type and size are known.
+func (c *Client) mlListFromLIST(path string, yield func(*MLEntry, error) bool) {
+	for entry, err := range c.ListIter(path) {
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		ml := &MLEntry{
+			Name: entry.Name,
+			Type: entry.Type,
+			Size: entry.Size,
+			Facts: map[string]string{
+				"type": entry.Type,
+				"size": strconv.FormatInt(entry.Size, 10),
+			},
+		}
+		if !yield(ml, nil) {
+			return
+		}
 	}
+}
 
-	return entries, nil
+// commonFacts are the fact names of RFC 3659 and common extensions, in
+// lowercase.
+var commonFacts = []string{
+	"type", "size", "modify", "perm", "unique", "create", "lang", "media-type", "charset",
+	"unix.mode", "unix.owner", "unix.group", "unix.uid", "unix.gid",
+}
+
+// lowerFact returns name in lowercase, without allocating for the common
+// facts, which servers often send capitalized ("Type", "Size", "Modify").
+func lowerFact(name string) string {
+	for _, fact := range commonFacts {
+		if strings.EqualFold(name, fact) {
+			return fact
+		}
+	}
+	return strings.ToLower(name)
 }
 
 // parseMLEntry parses a single MLST/MLSD entry line.
@@ -170,14 +251,11 @@ func parseMLEntry(line string) (*MLEntry, error) {
 			continue
 		}
 
-		parts := strings.SplitN(pair, "=", 2)
-		if len(parts) != 2 {
+		factName, factValue, ok := strings.Cut(pair, "=")
+		if !ok {
 			continue
 		}
-
-		factName := strings.ToLower(parts[0])
-		factValue := parts[1]
-		facts[factName] = factValue
+		facts[lowerFact(factName)] = factValue
 	}
 
 	// Build the entry
@@ -200,7 +278,7 @@ func parseMLEntry(line string) (*MLEntry, error) {
 	if modifyVal, ok := facts["modify"]; ok {
 		// Format: YYYYMMDDHHMMSS or YYYYMMDDHHMMSS.sss
 		// Remove fractional seconds if present
-		timestamp := strings.Split(modifyVal, ".")[0]
+		timestamp, _, _ := strings.Cut(modifyVal, ".")
 		if len(timestamp) == 14 {
 			// RFC 3659 Section 2.3: "Time values are always represented in UTC"
 			if modTime, err := time.Parse("20060102150405", timestamp); err == nil {
@@ -103,6 +103,23 @@ func TestParseMLEntry_Facts(t *testing.T) {
 	}
 }
 
+func TestParseMLEntry_CapitalizedFacts(t *testing.T) {
+	t.Parallel()
+	entry, err := parseMLEntry("Type=file;Size=42;Modify=20240102030405.123;UNIX.mode=0600;X.Custom=v; a.txt")
+	if err != nil {
+		t.Fatalf("parseMLEntry() error = %v", err)
+	}
+	if entry.Type != "file" || entry.Size != 42 || entry.UnixMode != "0600" {
+		t.Errorf("parseMLEntry() = %+v", entry)
+	}
+	if want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC); !entry.ModTime.Equal(want) {
+		t.Errorf("ModTime = %v, want %v", entry.ModTime, want)
+	}
+	if entry.Facts["x.custom"] != "v" {
+		t.Errorf("Facts = %v, want x.custom=v", entry.Facts)
+	}
+}
+
 func TestParseFEATResponse(t *testing.T) {
 	t.Parallel()
 	// Simulate FEAT response parsing
@@ -0,0 +1,116 @@
+package ftp_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
+	"github.com/gonzalop/ftp/server"
+)
+
+// hostileNames are file names that are easily mangled by command and
+// listing parsers.
+var hostileNames = []string{
+	" leading.txt",
+	"trailing.txt ",
+	"two  spaces.txt",
+	`quote".txt`,
+	"cr\r.txt",
+	"ÿ.txt", // 0xFF in Latin-1, the Telnet IAC byte
+}
+
+func TestHostileNamesRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	encodings := []struct {
+		name   string
+		client []ftp.Option
+		server []server.Option
+	}{
+		{"UTF-8", nil, nil},
+		{"Latin-1", []ftp.Option{ftp.WithFilenameEncoding(ftp.Latin1)}, []server.Option{server.WithFilenameEncoding(server.Latin1)}},
+	}
+	for _, enc := range encodings {
+		t.Run(enc.name, func(t *testing.T) {
+			t.Parallel()
+			s := ftptest.NewServer(t, enc.server...)
+			c := dialTestServer(t, s, enc.client...)
+
+			for _, name := range hostileNames {
+				data := []byte("data of " + name)
+				if err := c.Store(name, bytes.NewReader(data)); err != nil {
+					t.Errorf("Store(%q): %v", name, err)
+					continue
+				}
+				if got, err := s.ReadFile("/" + name); err != nil || !bytes.Equal(got, data) {
+					t.Errorf("Server file %q = %q, %v", name, got, err)
+				}
+				var buf bytes.Buffer
+				if err := c.Retrieve(name, &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+					t.Errorf("Retrieve(%q) = %q, %v", name, buf.Bytes(), err)
+				}
+			}
+
+			listed := map[string]bool{}
+			entries, err := c.List("")
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			for _, e := range entries {
+				listed[e.Name] = true
+			}
+			mlListed := map[string]bool{}
+			mlEntries, err := c.MLList("")
+			if err != nil {
+				t.Fatalf("MLList: %v", err)
+			}
+			for _, e := range mlEntries {
+				mlListed[e.Name] = true
+			}
+			for _, name := range hostileNames {
+				if !listed[name] {
+					t.Errorf("List is missing %q: %v", name, listed)
+				}
+				if !mlListed[name] {
+					t.Errorf("MLList is missing %q: %v", name, mlListed)
+				}
+				if entry, err := c.Stat(name); err != nil || entry.Name != name {
+					t.Errorf("Stat(%q) = %+v, %v", name, entry, err)
+				}
+			}
+
+			dir := "dir \"quoted\"\r ÿ"
+			if err := c.MakeDir(dir); err != nil {
+				t.Fatalf("MakeDir(%q): %v", dir, err)
+			}
+			if err := c.ChangeDir(dir); err != nil {
+				t.Fatalf("ChangeDir(%q): %v", dir, err)
+			}
+			if cwd, err := c.CurrentDir(); err != nil || cwd != "/"+dir {
+				t.Errorf("CurrentDir = %q, %v; want %q", cwd, err, "/"+dir)
+			}
+		})
+	}
+}
+
+func TestLineFeedInName(t *testing.T) {
+	t.Parallel()
+	s := ftptest.NewServer(t)
+	c := dialTestServer(t, s)
+
+	err := c.Store("evil\r\nDELE victim", strings.NewReader("x"))
+	if !errors.Is(err, ftp.ErrLineFeed) {
+		t.Errorf("Store with a line feed: %v, want ErrLineFeed", err)
+	}
+	if _, err := c.BatchStat([]string{"a", "b\nc"}); !errors.Is(err, ftp.ErrLineFeed) {
+		t.Errorf("BatchStat with a line feed: %v, want ErrLineFeed", err)
+	}
+
+	// Nothing was sent, so the connection is still in sync
+	if err := c.Noop(); err != nil {
+		t.Errorf("Noop after rejected commands: %v", err)
+	}
+}
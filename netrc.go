@@ -0,0 +1,204 @@
+package ftp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// netrcEntry is a machine or default entry of a netrc file.
+type netrcEntry struct {
+	machine  string // Empty for the default entry
+	login    string
+	password string
+	account  string
+}
+
+// parseNetrc parses a netrc file: machine entries with their login,
+// password and account, and the default entry. Tokens are separated by
+// white space and may be quoted; # starts a comment and macdef macros are
+// skipped.
+func parseNetrc(r io.Reader) ([]netrcEntry, error) {
+	var entries []netrcEntry
+	var key string // The keyword waiting for its value
+	inMacro := false
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if inMacro {
+			// A macro ends with an empty line
+			inMacro = strings.TrimSpace(scanner.Text()) != ""
+			continue
+		}
+
+		tokens, err := netrcTokens(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		for _, token := range tokens {
+			switch key {
+			case "":
+				switch token {
+				case "machine", "login", "password", "account", "macdef":
+					key = token
+				case "default":
+					entries = append(entries, netrcEntry{})
+				default:
+					return nil, fmt.Errorf("line %d: unexpected %q", line, token)
+				}
+				continue
+			case "machine":
+				entries = append(entries, netrcEntry{machine: token})
+			case "macdef":
+				// The macro body follows on the next lines
+				inMacro = true
+			default:
+				if len(entries) == 0 {
+					return nil, fmt.Errorf("line %d: %s outside of a machine entry", line, key)
+				}
+				e := &entries[len(entries)-1]
+				switch key {
+				case "login":
+					e.login = token
+				case "password":
+					e.password = token
+				case "account":
+					e.account = token
+				}
+			}
+			key = ""
+			if inMacro {
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if key != "" {
+		return nil, fmt.Errorf("%s without a value", key)
+	}
+	return entries, nil
+}
+
+// netrcTokens splits a line of a netrc file into tokens, removing quotes
+// and comments.
+func netrcTokens(line string) ([]string, error) {
+	var tokens []string
+	for {
+		line = strings.TrimLeft(line, " \t\r")
+		if line == "" || line[0] == '#' {
+			return tokens, nil
+		}
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t\r")
+			if end < 0 {
+				end = len(line)
+			}
+			tokens = append(tokens, line[:end])
+			line = line[end:]
+			continue
+		}
+
+		// A quoted token, with backslash escapes
+		var b strings.Builder
+		i := 1
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+			}
+			b.WriteByte(line[i])
+		}
+		if i == len(line) {
+			return nil, errors.New("unterminated quoted string")
+		}
+		tokens = append(tokens, b.String())
+		line = line[i+1:]
+	}
+}
+
+// lookupNetrc returns the entry for machine, and login if it is not empty,
+// or else the default entry.
+func lookupNetrc(entries []netrcEntry, machine, login string) (netrcEntry, bool) {
+	var def *netrcEntry
+	for i, e := range entries {
+		if login != "" && e.login != login {
+			continue
+		}
+		if e.machine == "" {
+			if def == nil {
+				def = &entries[i]
+			}
+			continue
+		}
+		if strings.EqualFold(e.machine, machine) {
+			return e, true
+		}
+	}
+	if def != nil {
+		return *def, true
+	}
+	return netrcEntry{}, false
+}
+
+// netrcPath returns the path of the netrc file: $NETRC, or .netrc in the
+// home directory (_netrc on Windows).
+func netrcPath() (string, error) {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	name := ".netrc"
+	if runtime.GOOS == "windows" {
+		name = "_netrc"
+	}
+	return filepath.Join(home, name), nil
+}
+
+// netrcCredentials completes the credentials of a login with those of the
+// netrc file for the server (see WithNetrc). Without a username from
+// either, the login is anonymous.
+func (c *Client) netrcCredentials(username, password, account string) (string, string, string, error) {
+	path, err := netrcPath()
+	if err != nil {
+		return "", "", "", fmt.Errorf("netrc: %w", err)
+	}
+	var entries []netrcEntry
+	f, err := os.Open(path)
+	if err == nil {
+		entries, err = parseNetrc(f)
+		f.Close()
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", "", "", fmt.Errorf("netrc %s: %w", path, err)
+	}
+
+	if e, ok := lookupNetrc(entries, c.host, username); ok {
+		c.logger.Debug("using netrc credentials", "path", path, "machine", e.machine, "login", e.login)
+		if username == "" {
+			username = e.login
+		}
+		if password == "" {
+			password = e.password
+		}
+		if account == "" {
+			account = e.account
+		}
+	}
+	if username == "" {
+		username = "anonymous"
+		if password == "" {
+			password = "anonymous@"
+		}
+	}
+	return username, password, account, nil
+}
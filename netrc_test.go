@@ -0,0 +1,162 @@
+package ftp
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp/ftptest"
+)
+
+func TestParseNetrc(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []netrcEntry
+		err   string
+	}{
+		{
+			name:  "one line",
+			input: "machine ftp.example.com login alice password secret\n",
+			want:  []netrcEntry{{machine: "ftp.example.com", login: "alice", password: "secret"}},
+		},
+		{
+			name: "several lines and default",
+			input: `# Work servers
+machine a.example.com
+	login alice
+	password "pass word"
+	account acct
+
+machine b.example.com login bob password "say \"hi\"\\"  # comment
+default login anonymous password me@example.com
+`,
+			want: []netrcEntry{
+				{machine: "a.example.com", login: "alice", password: "pass word", account: "acct"},
+				{machine: "b.example.com", login: "bob", password: `say "hi"\`},
+				{login: "anonymous", password: "me@example.com"},
+			},
+		},
+		{
+			name: "macro skipped",
+			input: `machine a login alice
+macdef init
+cd /pub
+machine b login mallory
+
+machine c login carol
+`,
+			want: []netrcEntry{{machine: "a", login: "alice"}, {machine: "c", login: "carol"}},
+		},
+		{name: "empty", input: "\n# nothing\n"},
+		{name: "login outside entry", input: "login alice", err: "outside of a machine entry"},
+		{name: "unknown keyword", input: "machine a port 21", err: `unexpected "port"`},
+		{name: "missing value", input: "machine a login", err: "login without a value"},
+		{name: "unterminated quote", input: `machine a password "secret`, err: "unterminated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNetrc(strings.NewReader(tt.input))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("parseNetrc error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseNetrc = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupNetrc(t *testing.T) {
+	entries := []netrcEntry{
+		{machine: "ftp.example.com", login: "alice", password: "a"},
+		{machine: "ftp.example.com", login: "bob", password: "b"},
+		{login: "anonymous", password: "guest"},
+	}
+	tests := []struct {
+		machine, login string
+		want           string // Password of the entry
+	}{
+		{"ftp.example.com", "", "a"},
+		{"FTP.Example.com", "bob", "b"},
+		{"ftp.example.com", "carol", ""},
+		{"other.example.com", "", "guest"},
+		{"other.example.com", "anonymous", "guest"},
+	}
+	for _, tt := range tests {
+		e, ok := lookupNetrc(entries, tt.machine, tt.login)
+		if ok != (tt.want != "") || e.password != tt.want {
+			t.Errorf("lookupNetrc(%q, %q) = %+v, %v; want password %q", tt.machine, tt.login, e, ok, tt.want)
+		}
+	}
+}
+
+func TestWithNetrc(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(path, []byte("machine 127.0.0.1 login alice password secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NETRC", path)
+	s := ftptest.NewServer(t)
+
+	login := func(user, pass string, opts ...Option) *Client {
+		t.Helper()
+		c, err := Dial(s.Addr, append([]Option{WithTimeout(5 * time.Second)}, opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = c.Quit() })
+		if err := c.Login(user, pass); err != nil {
+			t.Fatalf("Login(%q, %q) failed: %v", user, pass, err)
+		}
+		return c
+	}
+	tests := []struct {
+		user, pass         string
+		wantUser, wantPass string
+	}{
+		{"", "", "alice", "secret"},
+		{"alice", "", "alice", "secret"},
+		{"alice", "other", "alice", "other"},
+		{"bob", "", "bob", ""},
+	}
+	for _, tt := range tests {
+		c := login(tt.user, tt.pass, WithNetrc())
+		if c.username != tt.wantUser || c.password != tt.wantPass {
+			t.Errorf("Login(%q, %q) with netrc logged in as %q/%q, want %q/%q",
+				tt.user, tt.pass, c.username, c.password, tt.wantUser, tt.wantPass)
+		}
+	}
+	if !login("", "", WithNetrc()).Config().Netrc {
+		t.Error("Config().Netrc = false, want true")
+	}
+
+	// Without an entry, the login is anonymous
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "missing"))
+	if c := login("", "", WithNetrc()); c.username != "anonymous" {
+		t.Errorf("Logged in as %q without netrc entry, want anonymous", c.username)
+	}
+
+	// A malformed file fails the login
+	if err := os.WriteFile(path, []byte("machine"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NETRC", path)
+	c, err := Dial(s.Addr, WithTimeout(5*time.Second), WithNetrc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Quit() }()
+	if err := c.Login("", ""); err == nil || !strings.Contains(err.Error(), "netrc") {
+		t.Errorf("Login with a malformed netrc = %v, want a netrc error", err)
+	}
+}
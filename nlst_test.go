@@ -0,0 +1,67 @@
+package ftp_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
+)
+
+func TestNameListFallback(t *testing.T) {
+	t.Parallel()
+
+	s := ftptest.NewServer(t)
+	for name, data := range map[string]string{
+		"/pub/a.txt":     "hello",
+		"/pub/sub/b.txt": "hi",
+	} {
+		if err := s.WriteFile(name, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.FailCommand("LIST", 502, "Command not implemented.")
+
+	if _, err := dialTestServer(t, s).List("/pub"); err == nil {
+		t.Fatal("List succeeded without LIST or the fallback")
+	}
+
+	c := dialTestServer(t, s, ftp.WithNameListFallback())
+	if err := c.ChangeDir("/pub"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := c.List("")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	got := make(map[string]ftp.Entry)
+	for _, e := range entries {
+		got[e.Name] = *e
+	}
+	if e := got["a.txt"]; e.Type != "file" || e.Size != 5 {
+		t.Errorf("a.txt = %+v, want a 5 byte file", e)
+	}
+	if e := got["sub"]; e.Type != "dir" {
+		t.Errorf("sub = %+v, want a directory", e)
+	}
+	if wd, err := c.CurrentDir(); err != nil || wd != "/pub" {
+		t.Errorf("Working directory after List = %q, %v, want /pub", wd, err)
+	}
+
+	var walked []string
+	err = c.Walk("/pub", func(p string, info *ftp.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	slices.Sort(walked)
+	want := []string{"/pub", "/pub/a.txt", "/pub/sub", "/pub/sub/b.txt"}
+	if !slices.Equal(walked, want) {
+		t.Errorf("Walk visited %v, want %v", walked, want)
+	}
+}
@@ -3,6 +3,7 @@ package ftp
 import (
 	"crypto/tls"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"time"
@@ -13,6 +14,8 @@ type Option func(*Client) error
 
 // WithTimeout sets the timeout for connection and operations.
 // This applies to both the initial connection and subsequent read/write operations.
+// On data connections it is an idle timeout: a transfer fails only if no
+// data moves for this long, however long the whole transfer takes.
 func WithTimeout(timeout time.Duration) Option {
 	return func(c *Client) error {
 		c.timeout = timeout
@@ -39,6 +42,31 @@ func WithIdleTimeout(timeout time.Duration) Option {
 	}
 }
 
+// WithTransferKeepAlive sends a NOOP command on the control connection every
+// interval while a data transfer is in progress. Servers, firewalls and NAT
+// devices may drop a control connection that stays silent during a long
+// transfer. The replies to the NOOPs are read, and discarded, when the
+// transfer completes.
+//
+// WithIdleTimeout does not send NOOP during transfers. To use TCP keep-alive
+// on the control connection instead, set KeepAliveConfig on a net.Dialer
+// passed to WithDialer.
+//
+// Example:
+//
+//	client, _ := ftp.Dial("ftp.example.com:21",
+//	    ftp.WithTransferKeepAlive(time.Minute),
+//	)
+func WithTransferKeepAlive(interval time.Duration) Option {
+	return func(c *Client) error {
+		if interval < 0 {
+			return fmt.Errorf("transfer keep-alive interval cannot be negative")
+		}
+		c.transferKeepAliveInterval = interval
+		return nil
+	}
+}
+
 // WithExplicitTLS enables explicit TLS mode (AUTH TLS).
 // The client connects on the standard FTP port (21) and upgrades to TLS
 // using the AUTH TLS command. This is the recommended mode for FTPS.
@@ -89,6 +117,117 @@ func WithImplicitTLS(config *tls.Config) Option {
 	}
 }
 
+// WithTLSResumptionCache sets the cache of TLS sessions used for the
+// control and data connections, replacing the one of the TLS configuration.
+// Clients sharing a cache resume each other's sessions, which saves a full
+// handshake per connection when many clients talk to the same server, e.g.
+// in a pool of workers. It requires WithExplicitTLS or WithImplicitTLS.
+//
+// Sessions are cached by server name, so the tls.Config should set
+// ServerName when connecting by IP address.
+//
+// Example:
+//
+//	cache := tls.NewLRUClientSessionCache(64)
+//	for range workers {
+//	    c, err := ftp.Dial("ftp.example.com:21",
+//	        ftp.WithExplicitTLS(&tls.Config{ServerName: "ftp.example.com"}),
+//	        ftp.WithTLSResumptionCache(cache),
+//	    )
+//	    // ...
+//	}
+//
+// The cache of an existing client is returned by Client.TLSSessionCache, and
+// Client.TLSResumptionStats reports how often sessions were resumed.
+func WithTLSResumptionCache(cache tls.ClientSessionCache) Option {
+	return func(c *Client) error {
+		if cache == nil {
+			return fmt.Errorf("TLS session cache cannot be nil")
+		}
+		c.tlsSessionCache = cache
+		return nil
+	}
+}
+
+// WithDataProtection sets the protection level of the data connections
+// when TLS is enabled (RFC 4217 Section 9): "P" (private, the default)
+// encrypts them, and "C" (clear) leaves them in plain text while the
+// control connection stays encrypted. Clear data connections help with
+// middleboxes that inspect or rewrite transfers, and allow server-to-server
+// transfers (TransferTo). It requires WithExplicitTLS or WithImplicitTLS.
+//
+// After AUTH TLS, the client sends PBSZ 0 and PROT with the level. With
+// implicit TLS, servers protect data connections by default, so PBSZ and
+// PROT are only sent when this option is set.
+//
+// Example:
+//
+//	client, err := ftp.Dial("ftp.example.com:21",
+//	    ftp.WithExplicitTLS(&tls.Config{ServerName: "ftp.example.com"}),
+//	    ftp.WithDataProtection("C"),
+//	)
+//
+// Client.SetProt changes the level of a connected client.
+func WithDataProtection(level string) Option {
+	return func(c *Client) error {
+		level, err := protLevel(level)
+		if err != nil {
+			return err
+		}
+		c.dataProtection = level
+		return nil
+	}
+}
+
+// WithLegacyTLS makes explicit TLS work with servers written before RFC
+// 4217, such as old appliances and embedded devices. When the server
+// refuses AUTH TLS, the client tries AUTH SSL. PBSZ 0 and PROT are
+// accepted with any 2xx reply, a PBSZ the server does not know is ignored
+// when PROT succeeds, and servers that want PROT before PBSZ get PBSZ
+// again after it. It requires WithExplicitTLS.
+//
+// AUTH SSL is an obsolete mechanism, so only enable this option for the
+// servers that need it. Errors report which commands the server accepted.
+//
+// Example:
+//
+//	client, err := ftp.Dial("printer.local:21",
+//	    ftp.WithExplicitTLS(&tls.Config{ServerName: "printer.local"}),
+//	    ftp.WithLegacyTLS(),
+//	)
+func WithLegacyTLS() Option {
+	return func(c *Client) error {
+		c.legacyTLS = true
+		return nil
+	}
+}
+
+// WithDataConnectionReuse keeps the data connection open between
+// transfers on servers that list DATA-REUSE in their features, such as
+// this package's server with server.WithDataConnectionReuse. Consecutive
+// transfers then skip the PASV or EPSV command and the new connection (and
+// its TLS handshake), which helps with many small files or transports
+// where connections are expensive, like QUIC.
+//
+// The first transfer switches the server to block mode (MODE B, RFC 959
+// Section 3.4.2), where the end of each file is marked in the data instead
+// of by closing the connection. Failed or aborted transfers close the
+// connection, and the next transfer opens a new one. Servers without
+// DATA-REUSE keep using stream mode. FXP transfers (TransferTo) are not
+// supported once block mode is in use.
+//
+// Example:
+//
+//	client, err := ftp.Dial("ftp.example.com:21",
+//	    ftp.WithDataConnectionReuse(),
+//	)
+func WithDataConnectionReuse() Option {
+	return func(c *Client) error {
+		c.dataReuse = true
+		return nil
+	}
+}
+
 // WithLogger enables debug logging using the provided logger.
 // All FTP commands and responses will be logged at debug level.
 //
@@ -105,6 +244,25 @@ func WithLogger(logger *slog.Logger) Option {
 	}
 }
 
+// WithResponseObserver calls observer with every response read from the
+// server, including those of methods that only return an error and the
+// keep-alive NOOPs. Use it to log server messages, such as quota warnings,
+// or to measure response times (see Response.Duration).
+//
+// Example:
+//
+//	client, _ := ftp.Dial("ftp.example.com:21",
+//	    ftp.WithResponseObserver(func(cmd string, resp *ftp.Response) {
+//	        log.Printf("%s: %d %s (%v)", cmd, resp.Code, resp.Message, resp.Duration)
+//	    }),
+//	)
+func WithResponseObserver(observer ResponseObserver) Option {
+	return func(c *Client) error {
+		c.responseObserver = observer
+		return nil
+	}
+}
+
 // WithDialer sets a custom net.Dialer for establishing connections.
 // This can be used to configure source addresses, keep-alive settings, etc.
 func WithDialer(dialer *net.Dialer) Option {
@@ -114,27 +272,118 @@ func WithDialer(dialer *net.Dialer) Option {
 	}
 }
 
+// WithDataConnDialer sets a custom dialer for data connections.
+// This enables alternative transports like QUIC, SSH tunnels or Unix
+// sockets.
+//
+// The dialer is used for passive mode data connections, with the address
+// of the PASV or EPSV reply. Active mode is not supported with custom
+// dialers.
+//
+// Example through an SSH connection (an *ssh.Client of
+// golang.org/x/crypto/ssh implements Dialer):
+//
+//	client, _ := ftp.Dial("ftp.internal:21",
+//	    ftp.WithControlDialer(sshClient),
+//	    ftp.WithDataConnDialer(sshClient),
+//	)
+func WithDataConnDialer(dialer Dialer) Option {
+	return func(c *Client) error {
+		c.customDialer = dialer
+		return nil
+	}
+}
+
 // WithCustomDialer sets a custom dialer for data connections.
-// This enables alternative transports like QUIC or Unix sockets.
 //
-// The dialer is used for passive mode data connections. Active mode
-// is not supported with custom dialers.
+// Deprecated: Use WithDataConnDialer, which it is an alias of.
+func WithCustomDialer(dialer Dialer) Option {
+	return WithDataConnDialer(dialer)
+}
+
+// WithControlDialer sets a custom dialer for the control connection, which
+// is otherwise a TCP connection from the net.Dialer of WithDialer. If the
+// connection it returns implements Dialer, passive data connections are
+// dialed with it too (see Dialer).
 //
-// Example with QUIC:
+// Additional connections, such as those of RetrieveParallel or
+// WithAutoReconnect, are dialed with the same dialer. To use a connection
+// established by the caller instead, see NewClient. The
+// github.com/gonzalop/ftp/ftpquic/client package uses this option to run
+// FTP over QUIC.
+func WithControlDialer(dialer Dialer) Option {
+	return func(c *Client) error {
+		c.controlDialer = dialer
+		return nil
+	}
+}
+
+// WithProxy dials the control and data connections through a proxy: an
+// HTTP proxy supporting the CONNECT method ("http://proxy:3128"), or a
+// SOCKS5 proxy ("socks5://proxy:1080", or "socks5h://..." for the proxy to
+// resolve host names). Credentials in the URL are sent to the proxy. A URL
+// without a scheme is an HTTP proxy.
 //
-//	type QuicDialer struct {
-//	    quicConn quic.Connection
-//	}
+// TLS is negotiated with the server through the tunnel. Active mode is not
+// supported through a proxy. Dialers set with WithControlDialer and
+// WithDataConnDialer take precedence over the proxy.
 //
-//	func (d *QuicDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
-//	    stream, _ := d.quicConn.OpenStreamSync(ctx)
-//	    return quicconn.NewQuicConn(stream, d.quicConn), nil
-//	}
+// Example:
 //
-//	client, _ := ftp.Dial("server:21", ftp.WithCustomDialer(&QuicDialer{quicConn: conn}))
-func WithCustomDialer(dialer Dialer) Option {
+//	client, err := ftp.Dial("ftp.example.com:21", ftp.WithProxy("socks5://localhost:1080"))
+func WithProxy(proxyURL string) Option {
 	return func(c *Client) error {
-		c.customDialer = dialer
+		u, err := parseProxyURL(proxyURL)
+		if err != nil {
+			return err
+		}
+		c.proxyURL, c.proxyFromEnv = u, false
+		return nil
+	}
+}
+
+// WithProxyFromEnvironment dials connections through the proxy of the
+// ftp_proxy environment variable or, if it is not set, all_proxy (in lower
+// or upper case), as curl does; see WithProxy for the URLs accepted. The
+// server is reached directly if neither is set, or if no_proxy lists it:
+// no_proxy is a comma separated list of host names (matching their
+// subdomains too), IP addresses and CIDR ranges, or "*".
+//
+// Example, for a command line tool:
+//
+//	client, err := ftp.Dial(addr, ftp.WithProxyFromEnvironment(), ftp.WithNetrc())
+func WithProxyFromEnvironment() Option {
+	return func(c *Client) error {
+		u, err := proxyFromEnvironment(c.host)
+		if err != nil {
+			return err
+		}
+		c.proxyURL, c.proxyFromEnv = u, true
+		return nil
+	}
+}
+
+// WithNetrc completes the credentials of Login and LoginWithAccount from
+// the netrc file, $NETRC or else ~/.netrc (_netrc on Windows), as curl and
+// ftp(1) do. An empty username is taken from the entry of the server's host
+// name (as passed to Dial) or the default entry; an empty password or
+// account is taken from the entry of the host and username. Without a
+// username from either, the login is anonymous. A missing file is not an
+// error; the file is read at each login.
+//
+// Example:
+//
+//	client, err := ftp.Dial("ftp.example.com:21", ftp.WithNetrc())
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	// machine ftp.example.com login alice password secret
+//	if err := client.Login("", ""); err != nil {
+//	    log.Fatal(err)
+//	}
+func WithNetrc() Option {
+	return func(c *Client) error {
+		c.netrc = true
 		return nil
 	}
 }
@@ -168,13 +417,55 @@ func WithActiveMode() Option {
 // that block EPSV.
 func WithDisableEPSV() Option {
 	return func(c *Client) error {
-		c.disableEPSV = true
+		c.quirks.DisableEPSV = true
+		return nil
+	}
+}
+
+// WithIgnorePASVAddress makes passive mode connect to the host of the control
+// connection instead of the address in the PASV reply, keeping only the port.
+// Use it for servers behind NAT that advertise their private address.
+// EPSV replies carry no address, so they are not affected.
+func WithIgnorePASVAddress() Option {
+	return func(c *Client) error {
+		c.quirks.IgnorePASVAddress = true
+		return nil
+	}
+}
+
+// WithActivePortRange limits the local ports used for active mode data
+// connections to the range min-max (inclusive), so that only those ports
+// need to be opened in a firewall. Used with WithActiveMode.
+func WithActivePortRange(min, max int) Option {
+	return func(c *Client) error {
+		if min < 1 || max > 65535 || min > max {
+			return fmt.Errorf("invalid active port range %d-%d", min, max)
+		}
+		c.activePortMin = min
+		c.activePortMax = max
+		return nil
+	}
+}
+
+// WithActiveAddress sets the IP address sent to the server in PORT/EPRT
+// commands, instead of the local address of the control connection. Use it
+// when the client is behind NAT, with the public address of the router that
+// forwards the active port range (see WithActivePortRange). Used with
+// WithActiveMode.
+func WithActiveAddress(ip string) Option {
+	return func(c *Client) error {
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return fmt.Errorf("invalid active address: %s", ip)
+		}
+		c.activeAddress = addr
 		return nil
 	}
 }
 
 // WithCustomListParser adds a custom directory listing parser.
-// Custom parsers are tried before the built-in parsers (EPLF, DOS, Unix).
+// Custom parsers are tried before the parsers added with RegisterListingParser
+// and the built-in parsers (EPLF, DOS, VMS, MVS, NetWare, Unix).
 // This allows handling non-standard LIST formats.
 func WithCustomListParser(parser ListingParser) Option {
 	return func(c *Client) error {
@@ -184,6 +475,200 @@ func WithCustomListParser(parser ListingParser) Option {
 	}
 }
 
+// WithNameListFallback makes List, ListIter and the functions built on them,
+// such as Walk and DownloadDir, fall back to NLST when the server does not
+// implement LIST, as on some cameras, PLCs and other embedded devices. Each
+// name is then probed with CWD to tell directories from files, and with SIZE
+// to get the size of files, so listings cost a few commands per entry.
+//
+// Example:
+//
+//	client, _ := ftp.Dial("192.168.1.20:21",
+//	    ftp.WithNameListFallback(),
+//	)
+//	err := client.DownloadDir("/DCIM", "./photos")
+func WithNameListFallback() Option {
+	return func(c *Client) error {
+		c.nameListFallback = true
+		return nil
+	}
+}
+
+// WithRemotePathStyle sets the path syntax used by the recursive helpers
+// (Walk, UploadDir, DownloadDir and MkdirAll) to join, split and clean remote
+// paths. The default, PathStyleUnix, suits most servers. PathStyleWindows
+// and PathStyleVMS build native paths for Windows (IIS) and OpenVMS servers,
+// and PathStyleAuto picks one from the server's SYST reply.
+//
+// Example:
+//
+//	client, _ := ftp.Dial("vms.example.com:21",
+//	    ftp.WithRemotePathStyle(ftp.PathStyleAuto),
+//	)
+//	err := client.DownloadDir("DKA0:[USERS.ALICE]", "./alice")
+func WithRemotePathStyle(style RemotePathStyle) Option {
+	return func(c *Client) error {
+		if style < PathStyleUnix || style > PathStyleVMS {
+			return fmt.Errorf("invalid remote path style: %d", style)
+		}
+		c.pathStyle = style
+		return nil
+	}
+}
+
+// WithFilenameEncoding sets the encoding the server uses for file names.
+// Paths sent in commands are encoded to it, and replies and directory
+// listings are decoded to UTF-8, so names from legacy servers that do not use
+// UTF-8 are read correctly instead of producing mojibake.
+//
+// Example:
+//
+//	client, _ := ftp.Dial("legacy.example.com:21",
+//	    ftp.WithFilenameEncoding(ftp.Latin1),
+//	)
+func WithFilenameEncoding(enc FilenameEncoding) Option {
+	return func(c *Client) error {
+		c.filenameEncoding = enc
+		return nil
+	}
+}
+
+// WithVerifyTransfers enables checksum verification of Store and Retrieve
+// (and the helpers built on them, such as UploadFile and DownloadFile).
+// The client computes a digest of the data while it is transferred and
+// compares it with the server's checksum of the file once the transfer has
+// completed, returning a *ChecksumMismatchError if they differ.
+//
+// The server's checksum is obtained with HASH if advertised (preferring
+// SHA-256, and selecting the algorithm with OPTS HASH), or else with the
+// legacy XSHA1, XMD5 or XCRC commands. Transfers to servers that support none
+// of them are not verified. Partial transfers (appends, resumes and ranges)
+// are never verified.
+//
+// Example:
+//
+//	client, _ := ftp.Dial("ftp.example.com:21",
+//	    ftp.WithVerifyTransfers(),
+//	)
+func WithVerifyTransfers() Option {
+	return func(c *Client) error {
+		c.verifyTransfers = true
+		return nil
+	}
+}
+
+// WithAllocate makes Store and StoreFrom send ALLO with the size of the data
+// before uploading, when the size can be determined: for *os.File, readers
+// with a Size method (*bytes.Reader, *strings.Reader, *io.SectionReader) and
+// other io.Seekers. Some mainframe and embedded servers require ALLO to
+// reserve space; others ignore it. Use StoreWithSize to give the size of
+// other readers.
+func WithAllocate() Option {
+	return func(c *Client) error {
+		c.allocate = true
+		return nil
+	}
+}
+
+// WithCreateParents makes Store, StoreWithSize, StoreFrom and UploadDir
+// create the missing parent directories of the remote path before
+// uploading, as MkdirAll does. Without it, uploads into a missing directory
+// fail.
+//
+// Example:
+//
+//	client, _ := ftp.Dial("ftp.example.com:21",
+//	    ftp.WithCreateParents(),
+//	)
+//	err := client.StoreFrom("/backups/2024/06/db.sql", "db.sql")
+func WithCreateParents() Option {
+	return func(c *Client) error {
+		c.createParents = true
+		return nil
+	}
+}
+
+// WithUploadTransform passes the data of Store and StoreWithSize (and the
+// helpers built on them, such as StoreFrom, UploadFile and UploadDir)
+// through a writer returned by fn before it is sent, so that files can be
+// compressed or encrypted at rest. fn is called once per upload with the
+// writer feeding the data connection; the upload completes when the returned
+// writer is closed. ALLO is not sent by Store while a transform is set,
+// since the size of the transformed data is unknown, and progress reports
+// have no Total.
+//
+// Transforms do not apply to partial transfers (Append, StoreAt). Use
+// WithTransformNames to change the remote names of the files uploaded by
+// UploadDir.
+//
+// Example:
+//
+//	client, _ := ftp.Dial("ftp.example.com:21",
+//	    ftp.WithUploadTransform(func(w io.Writer) io.WriteCloser {
+//	        return gzip.NewWriter(w)
+//	    }),
+//	)
+func WithUploadTransform(fn func(io.Writer) io.WriteCloser) Option {
+	return func(c *Client) error {
+		c.uploadTransform = fn
+		return nil
+	}
+}
+
+// WithDownloadTransform passes the data of Retrieve (and the helpers built
+// on it, such as RetrieveTo, DownloadFile and DownloadDir) through a reader
+// returned by fn, reversing WithUploadTransform. fn is called once per
+// download with the reader of the data connection, and the returned reader
+// is closed when the download ends. Errors are reported by its Read method.
+//
+// With WithVerifyTransfers, the checksum is computed over the data as
+// stored on the server, before the transform. Transforms do not apply to
+// partial transfers (RetrieveFrom, RetrieveRange).
+//
+// Example:
+//
+//	client, _ := ftp.Dial("ftp.example.com:21",
+//	    ftp.WithDownloadTransform(func(r io.Reader) io.ReadCloser {
+//	        stream := cipher.NewCTR(block, iv)
+//	        return io.NopCloser(cipher.StreamReader{S: stream, R: r})
+//	    }),
+//	)
+func WithDownloadTransform(fn func(io.Reader) io.ReadCloser) Option {
+	return func(c *Client) error {
+		c.downloadTransform = fn
+		return nil
+	}
+}
+
+// WithTransformNames sets the naming callbacks of UploadDir, DownloadDir
+// and their WithOptions variants, for transforms that change the file
+// type. remote maps the name of a local file to the name it is uploaded
+// as, and local maps the name of a remote file to the name it is downloaded
+// as; either can be nil to keep names unchanged. The callbacks receive and
+// return base names, not paths. Other transfer methods use the paths they
+// are given.
+//
+// Overwrite policies compare the transformed remote files with the local
+// ones, so OverwriteIfSizeDiffers is rarely useful with a transform.
+//
+// Example:
+//
+//	client, _ := ftp.Dial("ftp.example.com:21",
+//	    ftp.WithUploadTransform(gzipWriter),
+//	    ftp.WithDownloadTransform(gzipReader),
+//	    ftp.WithTransformNames(
+//	        func(name string) string { return name + ".gz" },
+//	        func(name string) string { return strings.TrimSuffix(name, ".gz") },
+//	    ),
+//	)
+func WithTransformNames(remote, local func(name string) string) Option {
+	return func(c *Client) error {
+		c.remoteName = remote
+		c.localName = local
+		return nil
+	}
+}
+
 // WithBandwidthLimit sets the maximum bandwidth for transfers in bytes per second.
 // This applies to both uploads and downloads.
 // Set to 0 for unlimited bandwidth (default).
@@ -199,3 +684,54 @@ func WithBandwidthLimit(bytesPerSecond int64) Option {
 		return nil
 	}
 }
+
+// WithAutoReconnect makes the client reconnect transparently when the
+// control connection is lost (EOF, timeout, or a 421 reply). The new
+// connection is logged in with the credentials of the last Login, and the
+// HOST, TLS protection (PROT), transfer type (TYPE) and working directory are
+// restored. The failed command is then retried if it is safe to repeat, such
+// as CWD, SIZE or PASV. Other commands, such as STOR, DELE and RETR, are not
+// retried: they return a *ReconnectedError, matched by ErrReconnected, and the
+// caller decides whether to repeat them.
+//
+// Example:
+//
+//	client, _ := ftp.Dial("ftp.example.com:21",
+//	    ftp.WithAutoReconnect(),
+//	)
+func WithAutoReconnect() Option {
+	return func(c *Client) error {
+		c.autoReconnect = true
+		return nil
+	}
+}
+
+// WithRetryPolicy makes Store and StoreWithSize (and the helpers built on
+// them, such as StoreFrom and UploadDir) retry uploads that fail with a
+// temporary (4xx) reply, a lost connection or a checksum mismatch. Each
+// retry sends the whole file again, so the data is read again: readers that
+// implement io.Seeker are rewound to where the first attempt started, and
+// io.ReaderAt values without Seek are read from offset 0. Other readers are
+// only retried with p.Spool set. Errors reading the data, appends and
+// exclusive uploads (see WithWriteMode) are not retried. Combine it with
+// WithAutoReconnect to also retry after the control connection is lost.
+//
+// Example:
+//
+//	client, _ := ftp.Dial("ftp.example.com:21",
+//	    ftp.WithAutoReconnect(),
+//	    ftp.WithRetryPolicy(ftp.RetryPolicy{
+//	        MaxAttempts: 3,
+//	        Backoff:     time.Second,
+//	        Spool:       true, // Also retry uploads from pipes and sockets
+//	    }),
+//	)
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) error {
+		if p.MaxAttempts < 0 || p.Backoff < 0 || p.MaxBackoff < 0 {
+			return fmt.Errorf("retry policy values cannot be negative")
+		}
+		c.retryPolicy = &p
+		return nil
+	}
+}
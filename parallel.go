@@ -0,0 +1,155 @@
+package ftp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// minParallelSegment is the smallest segment RetrieveParallel splits a file
+// into. Smaller segments cost more in connection setup than they save.
+const minParallelSegment = 64 * 1024
+
+// RetrieveParallel downloads a file over several connections at once,
+// fetching non-overlapping segments concurrently and writing each one at its
+// offset in w. This can greatly speed up large downloads on links where a
+// single TCP connection cannot use the available bandwidth.
+//
+// The file size is obtained with SIZE and split into the given number of
+// segments (fewer for small files); if SIZE fails, or for a client created
+// with NewClient, the file is downloaded over a single connection. This
+// client downloads the first segment; the others use additional connections
+// opened with the same options and the credentials of the last successful
+// Login, which are closed before returning. Segments are requested with RANG
+// when the server supports it, and with REST otherwise (see RetrieveRange).
+//
+// If w also implements io.ReaderAt (such as *os.File) and the server supports
+// HASH with SHA-256, the downloaded data is verified against the server's hash.
+//
+// Note: a bandwidth limit set with WithBandwidthLimit applies to each
+// connection separately.
+//
+// Example:
+//
+//	file, err := os.Create("large.iso")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer file.Close()
+//
+//	err = client.RetrieveParallel("large.iso", file, 4)
+func (c *Client) RetrieveParallel(remotePath string, w io.WriterAt, segments int) error {
+	// Set binary mode so SIZE reports the exact byte count
+	if err := c.Type("I"); err != nil {
+		return fmt.Errorf("failed to set binary mode: %w", err)
+	}
+
+	// Without a size the file cannot be split; fall back to a single stream
+	size, err := c.Size(remotePath)
+	if err != nil {
+		return c.Retrieve(remotePath, io.NewOffsetWriter(w, 0))
+	}
+
+	if maxSegments := int(size / minParallelSegment); segments > maxSegments {
+		segments = maxSegments
+	}
+	if segments <= 1 || c.callerConn {
+		if err := c.Retrieve(remotePath, io.NewOffsetWriter(w, 0)); err != nil {
+			return err
+		}
+		return c.verifyParallel(remotePath, w, size)
+	}
+
+	pool := newConnPool(c)
+	defer pool.close()
+
+	segmentSize := (size + int64(segments) - 1) / int64(segments)
+
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+	setErr := func(err error) {
+		errMu.Lock()
+		defer errMu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for i := range segments {
+		start := int64(i) * segmentSize
+		if start >= size {
+			break
+		}
+		end := min(start+segmentSize, size) - 1
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			conn := c
+			if i > 0 {
+				var err error
+				if conn, err = pool.get(); err != nil {
+					setErr(fmt.Errorf("segment %d: %w", i, err))
+					return
+				}
+				defer pool.put(conn)
+			}
+
+			if err := conn.RetrieveRange(remotePath, io.NewOffsetWriter(w, start), start, end); err != nil {
+				setErr(fmt.Errorf("segment %d (bytes %d-%d): %w", i, start, end, err))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return c.verifyParallel(remotePath, w, size)
+}
+
+// verifyParallel checks the downloaded data against the server's SHA-256
+// hash, when both the destination and the server make that possible.
+func (c *Client) verifyParallel(remotePath string, w io.WriterAt, size int64) error {
+	ra, ok := w.(io.ReaderAt)
+	if !ok || !strings.Contains(strings.ToUpper(c.featureParams("HASH")), "SHA-256") {
+		return nil
+	}
+
+	if err := c.SetHashAlgo("SHA-256"); err != nil {
+		return nil // Verification is best effort
+	}
+	remote, err := c.Hash(remotePath)
+	if err != nil {
+		return nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(ra, 0, size)); err != nil {
+		return fmt.Errorf("failed to read back downloaded data: %w", err)
+	}
+
+	if localHash := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(localHash, remote.Value) {
+		return fmt.Errorf("integrity check failed: local SHA-256 %s does not match server %s", localHash, remote.Value)
+	}
+
+	return nil
+}
+
+// featureParams returns the parameters of an advertised feature, or an empty
+// string if the feature is not supported.
+func (c *Client) featureParams(feature string) string {
+	feats, err := c.Features()
+	if err != nil {
+		return ""
+	}
+	return feats[strings.ToUpper(feature)]
+}
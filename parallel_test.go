@@ -0,0 +1,132 @@
+package ftp_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/server"
+)
+
+func TestClient_RetrieveParallel(t *testing.T) {
+	t.Parallel()
+
+	content := make([]byte, 3*1024*1024+123)
+	for i := range content {
+		content[i] = byte(i % 253)
+	}
+
+	reuse := []server.Option{server.WithDataConnectionReuse(true)}
+	tests := []struct {
+		name       string
+		opts       []server.Option
+		file       string
+		segments   int
+		clientOpts []ftp.Option
+	}{
+		{"RANG", nil, "large.bin", 4, nil},
+		{"REST fallback", []server.Option{server.WithDisableCommands("RANG")}, "large.bin", 3, nil},
+		{"small file", nil, "small.bin", 8, nil},
+		{"data connection reuse", reuse, "large.bin", 4, []ftp.Option{ftp.WithDataConnectionReuse()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			addr, cleanup, rootDir := setupServerWithOptions(t, tt.opts...)
+			defer cleanup()
+
+			data := content
+			if tt.file == "small.bin" {
+				data = content[:1000]
+			}
+			if err := os.WriteFile(filepath.Join(rootDir, tt.file), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := ftp.Dial(addr, append([]ftp.Option{ftp.WithTimeout(5 * time.Second)}, tt.clientOpts...)...)
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer c.Quit()
+			if err := c.Login("test", "test"); err != nil {
+				t.Fatalf("Login failed: %v", err)
+			}
+
+			// *os.File implements io.ReaderAt, so the download is hash-verified
+			out, err := os.Create(filepath.Join(t.TempDir(), "out.bin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer out.Close()
+
+			if err := c.RetrieveParallel(tt.file, out, tt.segments); err != nil {
+				t.Fatalf("RetrieveParallel failed: %v", err)
+			}
+
+			got, err := os.ReadFile(out.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("Content mismatch: got %d bytes, want %d", len(got), len(data))
+			}
+
+			// The main connection must still be usable
+			if err := c.Noop(); err != nil {
+				t.Errorf("Noop failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestClient_RetrieveParallel_Errors(t *testing.T) {
+	t.Parallel()
+	addr, cleanup, rootDir := setupServer(t)
+	defer cleanup()
+
+	c, err := ftp.Dial(addr, ftp.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Quit()
+	if err := c.Login("test", "test"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	if err := c.RetrieveParallel("missing.bin", out, 4); err == nil {
+		t.Error("Expected error for missing file")
+	}
+
+	// Corrupted output must fail hash verification
+	if err := os.WriteFile(filepath.Join(rootDir, "data.bin"), bytes.Repeat([]byte("abc"), 100000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = c.RetrieveParallel("data.bin", &corruptingFile{File: out}, 2)
+	if err == nil || !strings.Contains(err.Error(), "integrity check failed") {
+		t.Errorf("Expected integrity check error, got %v", err)
+	}
+}
+
+// corruptingFile flips the first byte of every write.
+type corruptingFile struct {
+	*os.File
+}
+
+func (f *corruptingFile) WriteAt(p []byte, off int64) (int, error) {
+	q := append([]byte(nil), p...)
+	if len(q) > 0 {
+		q[0] ^= 0xff
+	}
+	return f.File.WriteAt(q, off)
+}
@@ -0,0 +1,318 @@
+package ftp
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// RemotePathStyle is the path syntax of the server, used by the recursive
+// helpers to build remote paths. See WithRemotePathStyle.
+type RemotePathStyle int
+
+const (
+	// PathStyleUnix uses slash-separated paths, like /pub/file.txt. It is
+	// the default, and is understood by most servers, including many
+	// Windows servers.
+	PathStyleUnix RemotePathStyle = iota
+
+	// PathStyleAuto picks the style from the server's SYST reply, falling
+	// back to PathStyleUnix for servers that are not Windows or VMS.
+	PathStyleAuto
+
+	// PathStyleWindows uses backslash-separated paths with an optional
+	// drive letter, like C:\pub\file.txt. Slashes in given paths are
+	// translated to backslashes.
+	PathStyleWindows
+
+	// PathStyleVMS uses OpenVMS file specifications, like
+	// DKA0:[PUB.DOCS]FILE.TXT. Given paths without VMS syntax that contain
+	// slashes are handled as Unix paths.
+	PathStyleVMS
+)
+
+// pathStyleNames are the names of the path styles in configuration files.
+var pathStyleNames = map[RemotePathStyle]string{
+	PathStyleUnix:    "unix",
+	PathStyleAuto:    "auto",
+	PathStyleWindows: "windows",
+	PathStyleVMS:     "vms",
+}
+
+func (s RemotePathStyle) String() string {
+	if name, ok := pathStyleNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("RemotePathStyle(%d)", int(s))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s RemotePathStyle) MarshalText() ([]byte, error) {
+	name, ok := pathStyleNames[s]
+	if !ok {
+		return nil, fmt.Errorf("invalid remote path style: %d", int(s))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, accepting "unix",
+// "auto", "windows" and "vms".
+func (s *RemotePathStyle) UnmarshalText(text []byte) error {
+	for style, name := range pathStyleNames {
+		if strings.EqualFold(string(text), name) {
+			*s = style
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown remote path style: %s", text)
+}
+
+// pathSyntax joins, splits and cleans paths in the syntax of a server.
+type pathSyntax interface {
+	clean(p string) string
+	// join returns the path of name in the directory dir. isDir tells
+	// whether name is a directory, as VMS writes directories and files
+	// differently.
+	join(dir, name string, isDir bool) string
+	// dir returns the parent directory of p. The parent of a root is the
+	// root itself.
+	dir(p string) string
+	base(p string) string
+}
+
+// remotePaths returns the path syntax of the recursive helpers, resolving
+// PathStyleAuto with the server's fingerprint.
+func (c *Client) remotePaths() pathSyntax {
+	style := c.pathStyle
+	if style == PathStyleAuto {
+		switch c.fingerprint() {
+		case serverWindows:
+			style = PathStyleWindows
+		case serverVMS:
+			style = PathStyleVMS
+		}
+	}
+
+	switch style {
+	case PathStyleWindows:
+		return windowsPaths{}
+	case PathStyleVMS:
+		return vmsPaths{}
+	}
+	return unixPaths{}
+}
+
+// joinRel returns the path of the slash-separated relative path rel under
+// dir. All elements of rel but the last are directories.
+func joinRel(paths pathSyntax, dir, rel string, isDir bool) string {
+	if rel == "" {
+		return dir
+	}
+	elems := strings.Split(rel, "/")
+	for i, elem := range elems {
+		dir = paths.join(dir, elem, isDir || i < len(elems)-1)
+	}
+	return dir
+}
+
+// isRoot reports whether p is a root or the current directory.
+func isRoot(paths pathSyntax, p string) bool {
+	return paths.dir(p) == p
+}
+
+type unixPaths struct{}
+
+func (unixPaths) clean(p string) string                { return path.Clean(p) }
+func (unixPaths) join(dir, name string, _ bool) string { return path.Join(dir, name) }
+func (unixPaths) dir(p string) string                  { return path.Dir(p) }
+func (unixPaths) base(p string) string                 { return path.Base(p) }
+
+type windowsPaths struct{}
+
+// splitVolume splits a Windows path into its drive letter, like "C:", and
+// the rest of the path, with slashes translated to backslashes.
+func splitVolume(p string) (vol, rest string) {
+	p = strings.ReplaceAll(p, "/", `\`)
+	if len(p) >= 2 && p[1] == ':' && ('A' <= p[0] && p[0] <= 'Z' || 'a' <= p[0] && p[0] <= 'z') {
+		return p[:2], p[2:]
+	}
+	return "", p
+}
+
+// withSlashes runs a path function on a backslash-separated path.
+func withSlashes(p string, fn func(string) string) string {
+	return strings.ReplaceAll(fn(strings.ReplaceAll(p, `\`, "/")), "/", `\`)
+}
+
+func (windowsPaths) clean(p string) string {
+	vol, rest := splitVolume(p)
+	if rest == "" {
+		if vol == "" {
+			return "."
+		}
+		return vol
+	}
+	rest = withSlashes(rest, path.Clean)
+	if rest == "." && vol != "" {
+		return vol
+	}
+	return vol + rest
+}
+
+func (w windowsPaths) join(dir, name string, _ bool) string {
+	if dir == "" || dir == "." {
+		return w.clean(name)
+	}
+	return w.clean(dir + `\` + name)
+}
+
+func (w windowsPaths) dir(p string) string {
+	vol, rest := splitVolume(w.clean(p))
+	rest = withSlashes(rest, path.Dir)
+	if rest == "." && vol != "" {
+		return vol
+	}
+	return vol + rest
+}
+
+func (w windowsPaths) base(p string) string {
+	vol, rest := splitVolume(w.clean(p))
+	if rest == "" {
+		return vol
+	}
+	return withSlashes(rest, path.Base)
+}
+
+type vmsPaths struct{}
+
+// vmsPath is a parsed VMS file specification, DEV:[DIR.SUB]FILE.EXT;1.
+type vmsPath struct {
+	dev      string   // Node and device, with their colons
+	hasDir   bool     // The directory part is present
+	relative bool     // The directory starts with a dot, as in [.SUB]
+	dirs     []string // Directory names; [000000] is the root, with none
+	file     string   // File name, type and version
+}
+
+// parseVMS parses p, and reports false if p has no VMS syntax.
+func parseVMS(p string) (vmsPath, bool) {
+	var v vmsPath
+	head := p
+	open := strings.IndexAny(p, "[<")
+	if open >= 0 {
+		head = p[:open]
+	}
+	colon := strings.LastIndex(head, ":")
+	if open < 0 && colon < 0 {
+		return v, false
+	}
+	v.dev = p[:colon+1]
+
+	if open >= 0 {
+		spec := p[open+1:]
+		if end := strings.IndexAny(spec, "]>"); end >= 0 {
+			spec, v.file = spec[:end], spec[end+1:]
+		}
+		v.hasDir = true
+		spec, v.relative = strings.CutPrefix(spec, ".")
+		for i, d := range strings.Split(spec, ".") {
+			if d == "" || i == 0 && !v.relative && d == "000000" {
+				continue
+			}
+			v.dirs = append(v.dirs, d)
+		}
+	} else {
+		v.file = p[colon+1:]
+	}
+	return v, true
+}
+
+func (v vmsPath) String() string {
+	s := v.dev
+	if v.hasDir {
+		switch {
+		case v.relative && len(v.dirs) == 0:
+			s += "[]"
+		case v.relative:
+			s += "[." + strings.Join(v.dirs, ".") + "]"
+		case len(v.dirs) == 0:
+			s += "[000000]"
+		default:
+			s += "[" + strings.Join(v.dirs, ".") + "]"
+		}
+	}
+	return s + v.file
+}
+
+func (vmsPaths) clean(p string) string {
+	v, ok := parseVMS(p)
+	if !ok {
+		return path.Clean(p)
+	}
+	return v.String()
+}
+
+func (vmsPaths) join(dir, name string, isDir bool) string {
+	v, ok := parseVMS(dir)
+	if !ok {
+		if strings.Contains(dir, "/") {
+			return path.Join(dir, name)
+		}
+		if dir != "." {
+			v.file = dir
+		}
+	}
+
+	// A file part names a subdirectory, as in [PUB]DOCS.DIR;1
+	if v.file != "" {
+		d, _, _ := strings.Cut(v.file, ";")
+		if base, ok := strings.CutSuffix(strings.ToUpper(d), ".DIR"); ok {
+			d = d[:len(base)]
+		}
+		v.file = ""
+		v.addDir(d)
+	}
+
+	if isDir {
+		v.addDir(name)
+	} else {
+		v.file = name
+	}
+	return v.String()
+}
+
+// addDir appends a subdirectory to the directory of v, which is the current
+// directory if v has none.
+func (v *vmsPath) addDir(name string) {
+	if !v.hasDir {
+		v.hasDir = true
+		v.relative = true
+	}
+	v.dirs = append(v.dirs, name)
+}
+
+func (vmsPaths) dir(p string) string {
+	v, ok := parseVMS(p)
+	switch {
+	case !ok:
+		return path.Dir(p)
+	case v.file != "":
+		v.file = ""
+	case len(v.dirs) > 0:
+		v.dirs = v.dirs[:len(v.dirs)-1]
+	}
+	return v.String()
+}
+
+func (vmsPaths) base(p string) string {
+	v, ok := parseVMS(p)
+	switch {
+	case !ok:
+		return path.Base(p)
+	case v.file != "":
+		return v.file
+	case len(v.dirs) > 0:
+		return v.dirs[len(v.dirs)-1]
+	}
+	return v.String()
+}
@@ -0,0 +1,115 @@
+package ftp
+
+import "testing"
+
+func TestPathSyntax(t *testing.T) {
+	t.Parallel()
+
+	type joinTest struct {
+		dir, name string
+		isDir     bool
+		want      string
+	}
+	tests := []struct {
+		name  string
+		paths pathSyntax
+		clean map[string]string
+		joins []joinTest
+		dirs  map[string]string // Path to parent
+		bases map[string]string
+	}{
+		{
+			name:  "windows",
+			paths: windowsPaths{},
+			clean: map[string]string{
+				"C:/pub//docs/": `C:\pub\docs`,
+				`\pub\..\x`:     `\x`,
+				"":              ".",
+				"d:":            "d:",
+			},
+			joins: []joinTest{
+				{`C:\pub`, "docs", true, `C:\pub\docs`},
+				{"/pub", "a.txt", false, `\pub\a.txt`},
+				{"", "a.txt", false, "a.txt"},
+			},
+			dirs: map[string]string{
+				`C:\pub\a.txt`: `C:\pub`,
+				`C:\pub`:       `C:\`,
+				`C:\`:          `C:\`,
+				"a.txt":        ".",
+			},
+			bases: map[string]string{
+				`C:\pub\a.txt`: "a.txt",
+				`C:\`:          `\`,
+			},
+		},
+		{
+			name:  "vms",
+			paths: vmsPaths{},
+			clean: map[string]string{
+				"DKA0:[000000.PUB]": "DKA0:[PUB]",
+				"[.SUB]":            "[.SUB]",
+				"/pub//docs/":       "/pub/docs",
+			},
+			joins: []joinTest{
+				{"DKA0:[PUB]", "DOCS", true, "DKA0:[PUB.DOCS]"},
+				{"DKA0:[PUB]", "A.TXT", false, "DKA0:[PUB]A.TXT"},
+				{"DKA0:[000000]", "PUB", true, "DKA0:[PUB]"},
+				{"DKA0:[PUB]DOCS.DIR;1", "A.TXT", false, "DKA0:[PUB.DOCS]A.TXT"},
+				{"", "SUB", true, "[.SUB]"},
+				{"SUB", "A.TXT", false, "[.SUB]A.TXT"},
+				{"/pub", "a.txt", false, "/pub/a.txt"},
+			},
+			dirs: map[string]string{
+				"DKA0:[PUB]A.TXT;1": "DKA0:[PUB]",
+				"DKA0:[PUB.DOCS]":   "DKA0:[PUB]",
+				"DKA0:[PUB]":        "DKA0:[000000]",
+				"DKA0:[000000]":     "DKA0:[000000]",
+				"[.SUB]":            "[]",
+			},
+			bases: map[string]string{
+				"DKA0:[PUB]A.TXT;1": "A.TXT;1",
+				"DKA0:[PUB.DOCS]":   "DOCS",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for p, want := range tt.clean {
+				if got := tt.paths.clean(p); got != want {
+					t.Errorf("clean(%q) = %q, want %q", p, got, want)
+				}
+			}
+			for _, j := range tt.joins {
+				if got := tt.paths.join(j.dir, j.name, j.isDir); got != j.want {
+					t.Errorf("join(%q, %q, %v) = %q, want %q", j.dir, j.name, j.isDir, got, j.want)
+				}
+			}
+			for p, want := range tt.dirs {
+				if got := tt.paths.dir(p); got != want {
+					t.Errorf("dir(%q) = %q, want %q", p, got, want)
+				}
+			}
+			for p, want := range tt.bases {
+				if got := tt.paths.base(p); got != want {
+					t.Errorf("base(%q) = %q, want %q", p, got, want)
+				}
+			}
+		})
+	}
+
+	if got := joinRel(vmsPaths{}, "DKA0:[PUB]", "docs/2024/a.txt", false); got != "DKA0:[PUB.docs.2024]a.txt" {
+		t.Errorf("joinRel() = %q", got)
+	}
+
+	// PathStyleAuto follows the fingerprint
+	c := &Client{pathStyle: PathStyleAuto, fingerprinted: true, serverType: serverVMS}
+	if _, ok := c.remotePaths().(vmsPaths); !ok {
+		t.Errorf("VMS server got %T, want vmsPaths", c.remotePaths())
+	}
+	c.serverType = serverMVS
+	if _, ok := c.remotePaths().(unixPaths); !ok {
+		t.Errorf("MVS server got %T, want unixPaths", c.remotePaths())
+	}
+}
@@ -0,0 +1,130 @@
+package ftp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// connPool manages additional control connections to the same server as a
+// parent client. Connections are opened on demand with the parent's
+// configuration and credentials, and reused until the pool is closed.
+type connPool struct {
+	parent *Client
+
+	mu   sync.Mutex
+	idle []*Client
+	all  []*Client
+}
+
+// newConnPool creates an empty pool of connections cloned from parent.
+func newConnPool(parent *Client) *connPool {
+	return &connPool{parent: parent}
+}
+
+// get returns an idle connection, or opens a new one.
+func (p *connPool) get() (*Client, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return c, nil
+	}
+	p.mu.Unlock()
+
+	c, err := p.parent.clone()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.all = append(p.all, c)
+	p.mu.Unlock()
+	return c, nil
+}
+
+// put returns a connection to the pool for reuse.
+func (p *connPool) put(c *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle = append(p.idle, c)
+}
+
+// close closes every connection opened by the pool.
+func (p *connPool) close() {
+	p.mu.Lock()
+	all := p.all
+	p.all, p.idle = nil, nil
+	p.mu.Unlock()
+
+	for _, c := range all {
+		_ = c.Quit()
+	}
+}
+
+// clone opens a new connection to the same server with the same options,
+// logs in with the credentials of the last successful Login and restores
+// the virtual host. Keep-alive is not started on the new connection.
+func (c *Client) clone() (*Client, error) {
+	if c.username == "" {
+		return nil, fmt.Errorf("cannot open additional connection: not logged in")
+	}
+	if c.callerConn {
+		return nil, fmt.Errorf("cannot open additional connection: control connection provided by the caller")
+	}
+
+	dialer := *c.dialer
+	nc := &Client{
+		host:             c.host,
+		port:             c.port,
+		timeout:          c.timeout,
+		tlsConfig:        c.tlsConfig,
+		tlsMode:          c.tlsMode,
+		dataProtection:   c.dataProtection,
+		legacyTLS:        c.legacyTLS,
+		dataReuse:        c.dataReuse,
+		tlsStats:         c.tlsStats,
+		dialer:           &dialer,
+		customDialer:     c.customDialer,
+		controlDialer:    c.controlDialer,
+		proxyURL:         c.proxyURL,
+		proxyFromEnv:     c.proxyFromEnv,
+		netrc:            c.netrc,
+		logger:           c.logger,
+		activeMode:       c.activeMode,
+		quirks:           c.quirks,
+		activePortMin:    c.activePortMin,
+		activePortMax:    c.activePortMax,
+		activeAddress:    c.activeAddress,
+		parsers:          c.parsers,
+		serverType:       c.serverType,
+		fingerprinted:    c.fingerprinted,
+		pathStyle:        c.pathStyle,
+		bandwidthLimit:   c.bandwidthLimit,
+		filenameEncoding: c.filenameEncoding,
+		verifyTransfers:  c.verifyTransfers,
+		allocate:         c.allocate,
+		retryPolicy:      c.retryPolicy,
+
+		transferKeepAliveInterval: c.transferKeepAliveInterval,
+	}
+
+	if err := nc.connect(); err != nil {
+		return nil, err
+	}
+	nc.lastCommand = time.Now()
+
+	if c.virtualHost != "" {
+		if err := nc.Host(c.virtualHost); err != nil {
+			_ = nc.Quit()
+			return nil, err
+		}
+	}
+	if err := nc.LoginWithAccount(c.username, c.password, c.account); err != nil {
+		_ = nc.Quit()
+		return nil, err
+	}
+
+	return nc, nil
+}
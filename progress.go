@@ -1,6 +1,75 @@
 package ftp
 
-import "io"
+import (
+	"context"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Progress describes the state of a transfer.
+type Progress struct {
+	// Transferred is the number of bytes transferred so far
+	Transferred int64
+
+	// Total is the size of the transfer, or 0 if unknown
+	Total int64
+
+	// Elapsed is the time since the transfer started
+	Elapsed time.Duration
+
+	// Rate is the average transfer rate in bytes per second
+	Rate float64
+
+	// ETA is the estimated time remaining, or 0 if unknown
+	ETA time.Duration
+}
+
+// Percent returns the percentage of Total transferred, or -1 if Total is
+// not known.
+func (p Progress) Percent() float64 {
+	if p.Total <= 0 {
+		return -1
+	}
+	return float64(p.Transferred) * 100 / float64(p.Total)
+}
+
+// ProgressFunc receives progress reports of a transfer.
+type ProgressFunc func(Progress)
+
+// progressState tracks the timing of a transfer and throttles callbacks.
+type progressState struct {
+	start    time.Time
+	lastCall time.Time
+}
+
+// due reports whether a callback is due after interval, and marks it made.
+// Final reports (done) are always due.
+func (s *progressState) due(interval time.Duration, done bool) bool {
+	now := time.Now()
+	if s.start.IsZero() {
+		s.start = now
+	}
+	if !done && interval > 0 && !s.lastCall.IsZero() && now.Sub(s.lastCall) < interval {
+		return false
+	}
+	s.lastCall = now
+	return true
+}
+
+// progress computes the rate and ETA of a transfer.
+func (s *progressState) progress(transferred, total int64) Progress {
+	p := Progress{Transferred: transferred, Total: total, Elapsed: time.Since(s.start)}
+	if secs := p.Elapsed.Seconds(); secs > 0 {
+		p.Rate = float64(transferred) / secs
+	}
+	if total > transferred && p.Rate > 0 {
+		p.ETA = time.Duration(float64(total-transferred) / p.Rate * float64(time.Second))
+	}
+	return p
+}
 
 // ProgressReader wraps an io.Reader and reports progress via a callback.
 type ProgressReader struct {
@@ -10,20 +79,54 @@ type ProgressReader struct {
 	// Callback is called after each Read with the total bytes transferred
 	Callback func(bytesTransferred int64)
 
+	// OnProgress, if set, is called after each Read with the total bytes
+	// transferred, the rate and the ETA
+	OnProgress ProgressFunc
+
+	// Total is the size of the data, if known (0 = unknown). StoreWithSize
+	// sets it when it is zero.
+	Total int64
+
+	// Interval is the minimum time between callbacks (0 = every Read). The
+	// end of the data is always reported.
+	Interval time.Duration
+
+	// Context, if set, cancels the transfer: Read fails with its error once
+	// it is done
+	Context context.Context
+
 	// total tracks the total bytes read
 	total int64
+	state progressState
 }
 
 // Read implements io.Reader.
 func (pr *ProgressReader) Read(p []byte) (int, error) {
+	if pr.Context != nil {
+		if err := pr.Context.Err(); err != nil {
+			return 0, err
+		}
+	}
+
 	n, err := pr.Reader.Read(p)
 	pr.total += int64(n)
-	if pr.Callback != nil && n > 0 {
-		pr.Callback(pr.total)
+	if (n > 0 || err == io.EOF) && pr.state.due(pr.Interval, err == io.EOF) {
+		if pr.Callback != nil && n > 0 {
+			pr.Callback(pr.total)
+		}
+		if pr.OnProgress != nil {
+			pr.OnProgress(pr.state.progress(pr.total, pr.Total))
+		}
 	}
 	return n, err
 }
 
+// Percent returns the percentage of Total read so far, or -1 if Total is
+// not known.
+func (pr *ProgressReader) Percent() float64 {
+	return Progress{Transferred: pr.total, Total: pr.Total}.Percent()
+}
+
 // ProgressWriter wraps an io.Writer and reports progress via a callback.
 type ProgressWriter struct {
 	// Writer is the underlying writer
@@ -32,16 +135,151 @@ type ProgressWriter struct {
 	// Callback is called after each Write with the total bytes transferred
 	Callback func(bytesTransferred int64)
 
+	// OnProgress, if set, is called after each Write with the total bytes
+	// transferred, the rate and the ETA
+	OnProgress ProgressFunc
+
+	// Total is the size of the data, if known (0 = unknown)
+	Total int64
+
+	// Interval is the minimum time between callbacks (0 = every Write).
+	// Writing the last byte of Total is always reported.
+	Interval time.Duration
+
+	// Context, if set, cancels the transfer: Write fails with its error once
+	// it is done
+	Context context.Context
+
 	// total tracks the total bytes written
 	total int64
+	state progressState
 }
 
 // Write implements io.Writer.
 func (pw *ProgressWriter) Write(p []byte) (int, error) {
+	if pw.Context != nil {
+		if err := pw.Context.Err(); err != nil {
+			return 0, err
+		}
+	}
+
 	n, err := pw.Writer.Write(p)
 	pw.total += int64(n)
-	if pw.Callback != nil && n > 0 {
-		pw.Callback(pw.total)
+	done := pw.Total > 0 && pw.total >= pw.Total
+	if n > 0 && pw.state.due(pw.Interval, done) {
+		if pw.Callback != nil {
+			pw.Callback(pw.total)
+		}
+		if pw.OnProgress != nil {
+			pw.OnProgress(pw.state.progress(pw.total, pw.Total))
+		}
 	}
 	return n, err
 }
+
+// Percent returns the percentage of Total written so far, or -1 if Total is
+// not known.
+func (pw *ProgressWriter) Percent() float64 {
+	return Progress{Transferred: pw.total, Total: pw.Total}.Percent()
+}
+
+// TransferOption configures a single Store or Retrieve call.
+type TransferOption func(*transferOptions)
+
+// transferOptions holds the settings of a single transfer.
+type transferOptions struct {
+	progress ProgressFunc
+	interval time.Duration
+	ctx      context.Context
+	mode     WriteMode
+}
+
+// WithProgress reports the progress of the transfer to fn, including the
+// rate and, when the size is known, the ETA. Uploads know the size of
+// files and other sized readers (see WithAllocate); downloads take it from
+// the server's 150 reply, when it includes it (e.g. "(1234 bytes)").
+//
+// Example:
+//
+//	err := client.Retrieve("big.iso", file, ftp.WithProgress(func(p ftp.Progress) {
+//	    fmt.Printf("\r%.0f%% %.0f KB/s ETA %s", p.Percent(), p.Rate/1024, p.ETA)
+//	}), ftp.WithProgressInterval(time.Second))
+func WithProgress(fn ProgressFunc) TransferOption {
+	return func(o *transferOptions) {
+		o.progress = fn
+	}
+}
+
+// WithProgressInterval sets the minimum time between progress reports. By
+// default, every read or write of the transfer is reported.
+func WithProgressInterval(d time.Duration) TransferOption {
+	return func(o *transferOptions) {
+		o.interval = d
+	}
+}
+
+// WithTransferContext aborts the transfer when ctx is done. The transfer
+// fails with the context's error.
+func WithTransferContext(ctx context.Context) TransferOption {
+	return func(o *transferOptions) {
+		o.ctx = ctx
+	}
+}
+
+// newTransferOptions applies opts.
+func newTransferOptions(opts []TransferOption) transferOptions {
+	var o transferOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// wrapReader applies the progress and context options to an upload.
+func (o transferOptions) wrapReader(r io.Reader, total int64) io.Reader {
+	if o.progress == nil && o.ctx == nil {
+		return r
+	}
+	return &ProgressReader{Reader: r, OnProgress: o.progress, Total: total, Interval: o.interval, Context: o.ctx}
+}
+
+// wrapWriter applies the progress and context options to a download.
+func (o transferOptions) wrapWriter(w io.Writer, total int64) io.Writer {
+	if o.progress == nil && o.ctx == nil {
+		return w
+	}
+	return &ProgressWriter{Writer: w, OnProgress: o.progress, Total: total, Interval: o.interval, Context: o.ctx}
+}
+
+// cancelOnDone interrupts reads and writes on conn when the transfer's
+// context is done. The returned function stops watching the context.
+func (o transferOptions) cancelOnDone(conn net.Conn) (stop func() bool) {
+	if o.ctx == nil {
+		return func() bool { return false }
+	}
+	return context.AfterFunc(o.ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+}
+
+// canceled returns the error of the transfer's context, if it is done.
+func (o transferOptions) canceled() error {
+	if o.ctx == nil {
+		return nil
+	}
+	return o.ctx.Err()
+}
+
+// transferSizeRegex matches the size many servers include in the 150 reply
+// to RETR, e.g. "Opening BINARY mode data connection for file (1234 bytes)."
+var transferSizeRegex = regexp.MustCompile(`\((\d+) bytes\)`)
+
+// transferSize returns the size announced in a 150 reply, or 0.
+func transferSize(msg string) int64 {
+	m := transferSizeRegex.FindStringSubmatch(msg)
+	if m == nil {
+		return 0
+	}
+	size, _ := strconv.ParseInt(m[1], 10, 64)
+	return size
+}
@@ -0,0 +1,91 @@
+package ftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressReader_Interval(t *testing.T) {
+	t.Parallel()
+	var reports []Progress
+	pr := &ProgressReader{
+		Reader:     io.LimitReader(strings.NewReader(strings.Repeat("x", 100)), 100),
+		Total:      100,
+		Interval:   time.Hour,
+		OnProgress: func(p Progress) { reports = append(reports, p) },
+	}
+
+	buf := make([]byte, 10)
+	for {
+		if _, err := pr.Read(buf); err != nil {
+			break
+		}
+	}
+
+	// The first read and the end of the data are reported
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports, want 2: %+v", len(reports), reports)
+	}
+	if last := reports[1]; last.Transferred != 100 || last.Percent() != 100 || last.ETA != 0 {
+		t.Errorf("final report = %+v", last)
+	}
+}
+
+func TestProgressWriter_RateAndETA(t *testing.T) {
+	t.Parallel()
+	var reports []Progress
+	pw := &ProgressWriter{
+		Writer:     io.Discard,
+		Total:      30,
+		OnProgress: func(p Progress) { reports = append(reports, p) },
+	}
+
+	_, _ = pw.Write(make([]byte, 10))
+	time.Sleep(20 * time.Millisecond)
+	_, _ = pw.Write(make([]byte, 10))
+
+	p := reports[len(reports)-1]
+	if p.Transferred != 20 || p.Elapsed <= 0 || p.Rate <= 0 || p.ETA <= 0 {
+		t.Errorf("report = %+v", p)
+	}
+	if got := pw.Percent(); got < 66 || got > 67 {
+		t.Errorf("Percent() = %v", got)
+	}
+}
+
+func TestProgressContext(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	pr := &ProgressReader{Reader: strings.NewReader("data"), Context: ctx}
+	if _, err := pr.Read(make([]byte, 4)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Read error = %v, want context.Canceled", err)
+	}
+	pw := &ProgressWriter{Writer: &bytes.Buffer{}, Context: ctx}
+	if _, err := pw.Write([]byte("data")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Write error = %v, want context.Canceled", err)
+	}
+}
+
+func TestTransferSize(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		msg  string
+		want int64
+	}{
+		{"Opening BINARY mode data connection for file.bin (1234 bytes).", 1234},
+		{"Opening data connection for RETR.", 0},
+		{"Here comes (a lot of) bytes", 0},
+	}
+	for _, tt := range tests {
+		if got := transferSize(tt.msg); got != tt.want {
+			t.Errorf("transferSize(%q) = %d, want %d", tt.msg, got, tt.want)
+		}
+	}
+}
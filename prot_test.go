@@ -0,0 +1,164 @@
+package ftp_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
+	"github.com/gonzalop/ftp/server"
+)
+
+// tlsTestServer starts a test server accepting explicit TLS.
+func tlsTestServer(t *testing.T, opts ...server.Option) *ftptest.Server {
+	t.Helper()
+	certPath, keyPath, _, _ := generateCert(t, false, nil, nil)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ftptest.NewServer(t, append(opts, server.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))...)
+}
+
+func TestDataProtection(t *testing.T) {
+	t.Parallel()
+	s := tlsTestServer(t)
+	c := dialTestServer(t, s,
+		ftp.WithExplicitTLS(&tls.Config{ServerName: "localhost", InsecureSkipVerify: true}),
+		ftp.WithDataProtection("c"),
+	)
+
+	// Clear data connections need no handshake
+	if err := c.Store("a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.TLSResumptionStats().Handshakes; got != 1 {
+		t.Errorf("PROT C: %d handshakes, want 1 (control only)", got)
+	}
+
+	if err := c.SetProt("P"); err != nil {
+		t.Fatalf("SetProt(P): %v", err)
+	}
+	var buf bytes.Buffer
+	if err := c.Retrieve("a.txt", &buf); err != nil || buf.String() != "hello" {
+		t.Fatalf("Retrieve = %q, %v", buf.String(), err)
+	}
+	if got := c.TLSResumptionStats().Handshakes; got != 2 {
+		t.Errorf("PROT P: %d handshakes, want 2 (control and data)", got)
+	}
+
+	if err := c.SetProt("S"); err == nil {
+		t.Error("SetProt(S) succeeded, want error")
+	}
+	if got := c.Config().TLS.DataProtection; got != "P" {
+		t.Errorf("Config().TLS.DataProtection = %q, want P", got)
+	}
+}
+
+func TestDataProtection_Implicit(t *testing.T) {
+	t.Parallel()
+	certPath, keyPath, _, _ := generateCert(t, false, nil, nil)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	driver, err := server.NewFSDriver(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.NewServer("127.0.0.1:0", server.WithDriver(driver), server.WithTLS(tlsConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = s.Serve(tls.NewListener(ln, tlsConfig))
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+
+	// Implicit TLS protects data connections unless PROT C is sent
+	for level, want := range map[string]int64{"": 2, "P": 2, "C": 1} {
+		opts := []ftp.Option{
+			ftp.WithTimeout(5 * time.Second),
+			ftp.WithImplicitTLS(&tls.Config{InsecureSkipVerify: true}),
+		}
+		if level != "" {
+			opts = append(opts, ftp.WithDataProtection(level))
+		}
+		c, err := ftp.Dial(ln.Addr().String(), opts...)
+		if err != nil {
+			t.Fatalf("Dial with level %q: %v", level, err)
+		}
+		if err := c.Login("anonymous", "anonymous"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.List("/"); err != nil {
+			t.Errorf("List with level %q: %v", level, err)
+		}
+		if got := c.TLSResumptionStats().Handshakes; got != want {
+			t.Errorf("Level %q: %d handshakes, want %d", level, got, want)
+		}
+		_ = c.Quit()
+	}
+}
+
+func TestDataProtection_Invalid(t *testing.T) {
+	t.Parallel()
+	s := ftptest.NewServer(t)
+	if _, err := ftp.Dial(s.Addr, ftp.WithDataProtection("C")); err == nil {
+		t.Error("WithDataProtection without TLS accepted")
+	}
+	if _, err := ftp.Dial(s.Addr,
+		ftp.WithExplicitTLS(&tls.Config{InsecureSkipVerify: true}),
+		ftp.WithDataProtection("E"),
+	); err == nil {
+		t.Error("WithDataProtection(E) accepted")
+	}
+
+	c := dialTestServer(t, s)
+	if err := c.SetProt("C"); err == nil {
+		t.Error("SetProt without TLS succeeded, want error")
+	}
+}
+
+// TestDataProtection_TransferTo switches two TLS clients to clear data
+// connections for a server-to-server transfer, as FXP needs.
+func TestDataProtection_TransferTo(t *testing.T) {
+	t.Parallel()
+	tlsConfig := &tls.Config{ServerName: "localhost", InsecureSkipVerify: true}
+	src := dialTestServer(t, tlsTestServer(t, server.WithAllowFXP(true)), ftp.WithExplicitTLS(tlsConfig))
+	dst := dialTestServer(t, tlsTestServer(t), ftp.WithExplicitTLS(tlsConfig))
+
+	if err := src.Store("a.txt", strings.NewReader("fxp data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.TransferTo(dst, "a.txt", "b.txt"); err == nil {
+		t.Fatal("TransferTo with protected data connections succeeded")
+	}
+
+	for _, c := range []*ftp.Client{src, dst} {
+		if err := c.SetProt("C"); err != nil {
+			t.Fatalf("SetProt(C): %v", err)
+		}
+	}
+	if err := src.TransferTo(dst, "a.txt", "b.txt"); err != nil {
+		t.Fatalf("TransferTo: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := dst.Retrieve("b.txt", &buf); err != nil || buf.String() != "fxp data" {
+		t.Errorf("Retrieve = %q, %v", buf.String(), err)
+	}
+}
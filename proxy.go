@@ -0,0 +1,292 @@
+package ftp
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseProxyURL parses the URL of an HTTP or SOCKS5 proxy. A URL without
+// a scheme is an HTTP proxy, as in the proxy environment variables.
+func parseProxyURL(rawURL string) (*url.URL, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	switch u.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: no host", rawURL)
+	}
+	return u, nil
+}
+
+// proxyFromEnvironment returns the proxy for connections to host set by
+// ftp_proxy or, failing that, all_proxy (in lower or upper case), or nil if
+// none is set or no_proxy excludes host.
+func proxyFromEnvironment(host string) (*url.URL, error) {
+	rawURL := getenvAnyCase("ftp_proxy")
+	if rawURL == "" {
+		rawURL = getenvAnyCase("all_proxy")
+	}
+	if rawURL == "" || noProxy(getenvAnyCase("no_proxy"), host) {
+		return nil, nil
+	}
+	return parseProxyURL(rawURL)
+}
+
+// getenvAnyCase returns the environment variable name, in lower case, or
+// else in upper case.
+func getenvAnyCase(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return os.Getenv(strings.ToUpper(name))
+}
+
+// noProxy reports whether host is excluded by the no_proxy list: a comma
+// separated list of host names, which also match their subdomains, IP
+// addresses and CIDR ranges, or "*" for all hosts. Ports are ignored.
+func noProxy(list, host string) bool {
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	ip := net.ParseIP(host)
+	for entry := range strings.SplitSeq(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.Trim(entry, "[]")
+		switch {
+		case entry == "":
+		case entry == "*":
+			return true
+		case ip != nil:
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(ip) {
+				return true
+			}
+			if eip := net.ParseIP(entry); eip != nil && eip.Equal(ip) {
+				return true
+			}
+		default:
+			entry = strings.TrimPrefix(entry, ".")
+			if host == entry || strings.HasSuffix(host, "."+entry) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dialProxy opens a connection to addr through the client's proxy.
+func (c *Client) dialProxy(ctx context.Context, addr string) (net.Conn, error) {
+	proxyAddr := c.proxyURL.Host
+	if c.proxyURL.Port() == "" {
+		port := "1080"
+		if c.proxyURL.Scheme == "http" {
+			port = "80"
+		}
+		proxyAddr = net.JoinHostPort(c.proxyURL.Hostname(), port)
+	}
+	conn, err := c.dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy: %w", err)
+	}
+
+	// The handshake is bounded by the dial timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	tunnel := conn
+	if c.proxyURL.Scheme == "http" {
+		tunnel, err = httpConnect(conn, c.proxyURL, addr)
+	} else {
+		err = socks5Connect(ctx, conn, c.proxyURL, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxyAddr, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// httpConnect opens a tunnel to addr through an HTTP proxy with the
+// CONNECT method (RFC 9110 Section 9.3.6).
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if user := proxy.User; user != nil {
+		password, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req += "Proxy-Authorization: Basic " + auth + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	status, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	// HTTP/1.1 200 Connection established
+	fields := strings.Fields(status)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/") {
+		return nil, fmt.Errorf("invalid CONNECT response: %q", strings.TrimSpace(status))
+	}
+	if code, err := strconv.Atoi(fields[1]); err != nil || code/100 != 2 {
+		return nil, fmt.Errorf("CONNECT refused: %s", strings.TrimSpace(strings.Join(fields[1:], " ")))
+	}
+	// Skip the headers
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(line) == "" {
+			break
+		}
+	}
+
+	// The server greeting may have been read with the response
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection with data already read into r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// SOCKS5 constants (RFC 1928 and RFC 1929).
+const (
+	socks5Version      = 5
+	socks5AuthNone     = 0
+	socks5AuthPassword = 2
+	socks5CmdConnect   = 1
+	socks5IPv4         = 1
+	socks5Domain       = 3
+	socks5IPv6         = 4
+)
+
+// socks5Connect opens a connection to addr through a SOCKS5 proxy. With
+// the socks5h scheme, host names are resolved by the proxy.
+func socks5Connect(ctx context.Context, conn net.Conn, proxy *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	// Method negotiation, then username and password authentication
+	method := byte(socks5AuthNone)
+	if proxy.User != nil {
+		method = socks5AuthPassword
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version || reply[1] != method {
+		return errors.New("SOCKS5 authentication method refused")
+	}
+	if method == socks5AuthPassword {
+		user := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(user) > 255 || len(password) > 255 {
+			return errors.New("SOCKS5 username or password too long")
+		}
+		req := append([]byte{1, byte(len(user))}, user...)
+		req = append(append(req, byte(len(password))), password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+	}
+
+	// CONNECT request
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	ip := net.ParseIP(host)
+	if ip == nil && proxy.Scheme == "socks5" {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return err
+		}
+		ip = addrs[0].IP
+	}
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %s", host)
+		}
+		req = append(append(req, socks5Domain, byte(len(host))), host...)
+	case ip.To4() != nil:
+		req = append(append(req, socks5IPv4), ip.To4()...)
+	default:
+		req = append(append(req, socks5IPv6), ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// Reply: version, status, reserved, then the bound address
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[1] != 0 {
+		return fmt.Errorf("SOCKS5 connect failed (status %d)", hdr[1])
+	}
+	var skip int
+	switch hdr[3] {
+	case socks5IPv4:
+		skip = net.IPv4len
+	case socks5IPv6:
+		skip = net.IPv6len
+	case socks5Domain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("invalid SOCKS5 address type %d", hdr[3])
+	}
+	_, err = io.CopyN(io.Discard, conn, int64(skip+2))
+	return err
+}
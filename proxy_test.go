@@ -0,0 +1,313 @@
+package ftp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp/ftptest"
+)
+
+// testProxy is an HTTP CONNECT or SOCKS5 proxy, counting the tunnels it
+// opens.
+type testProxy struct {
+	url      string
+	user     string
+	password string
+	tunnels  atomic.Int32
+
+	mu    sync.Mutex
+	hosts []string // Hosts of the tunnels, as requested
+}
+
+// startTestProxy starts a proxy for scheme ("http" or "socks5"), requiring
+// the credentials if user is not empty.
+func startTestProxy(t *testing.T, scheme, user, password string) *testProxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	p := &testProxy{url: scheme + "://" + ln.Addr().String(), user: user, password: password}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+				var target string
+				var r io.Reader
+				if scheme == "http" {
+					target, r = p.httpHandshake(conn)
+				} else {
+					target, r = p.socks5Handshake(conn)
+				}
+				if target == "" {
+					return
+				}
+				up, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer up.Close()
+				p.tunnels.Add(1)
+				_ = conn.SetDeadline(time.Time{})
+				go func() {
+					_, _ = io.Copy(up, r)
+					_ = up.(*net.TCPConn).CloseWrite()
+				}()
+				_, _ = io.Copy(conn, up)
+			}()
+		}
+	}()
+	return p
+}
+
+// tunnelHosts returns the requested hosts of the tunnels.
+func (p *testProxy) tunnelHosts() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.hosts...)
+}
+
+func (p *testProxy) addHost(host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hosts = append(p.hosts, host)
+}
+
+// httpHandshake answers a CONNECT request, returning the target address
+// and the reader of the client's data.
+func (p *testProxy) httpHandshake(conn net.Conn) (string, io.Reader) {
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil || req.Method != http.MethodConnect {
+		return "", nil
+	}
+	if p.user != "" {
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte(p.user+":"+p.password))
+		if req.Header.Get("Proxy-Authorization") != want {
+			fmt.Fprintf(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return "", nil
+		}
+	}
+	host, _, _ := net.SplitHostPort(req.Host)
+	p.addHost(host)
+	// The reply and the FTP greeting may arrive together
+	fmt.Fprintf(conn, "HTTP/1.1 200 Connection established\r\nVia: test\r\n\r\n")
+	return req.Host, br
+}
+
+// socks5Handshake answers the SOCKS5 negotiation and CONNECT request.
+func (p *testProxy) socks5Handshake(conn net.Conn) (string, io.Reader) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return "", nil
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", nil
+	}
+	method := byte(0)
+	if p.user != "" {
+		method = 2
+	}
+	if !bytes.Contains(methods, []byte{method}) {
+		_, _ = conn.Write([]byte{5, 0xff})
+		return "", nil
+	}
+	_, _ = conn.Write([]byte{5, method})
+	if method == 2 {
+		readString := func() string {
+			n := make([]byte, 1)
+			_, _ = io.ReadFull(conn, n)
+			s := make([]byte, n[0])
+			_, _ = io.ReadFull(conn, s)
+			return string(s)
+		}
+		_, _ = io.ReadFull(conn, hdr[:1])
+		if readString() != p.user || readString() != p.password {
+			_, _ = conn.Write([]byte{1, 1})
+			return "", nil
+		}
+		_, _ = conn.Write([]byte{1, 0})
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil || req[1] != 1 {
+		return "", nil
+	}
+	var host string
+	switch req[3] {
+	case 1, 4:
+		ip := make(net.IP, 4)
+		if req[3] == 4 {
+			ip = make(net.IP, 16)
+		}
+		_, _ = io.ReadFull(conn, ip)
+		host = ip.String()
+	case 3:
+		n := make([]byte, 1)
+		_, _ = io.ReadFull(conn, n)
+		name := make([]byte, n[0])
+		_, _ = io.ReadFull(conn, name)
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", nil
+	}
+	p.addHost(host)
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), conn
+}
+
+func TestWithProxy(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		scheme, user, password string
+		host                   string // Host dialed
+		wantHost               string // Host the proxy is asked for
+	}{
+		{scheme: "http", host: "127.0.0.1", wantHost: "127.0.0.1"},
+		{scheme: "http", user: "alice", password: "p@ss:word", host: "localhost", wantHost: "localhost"},
+		{scheme: "socks5", host: "127.0.0.1", wantHost: "127.0.0.1"},
+		{scheme: "socks5", user: "alice", password: "secret", host: "127.0.0.1", wantHost: "127.0.0.1"},
+		{scheme: "socks5h", host: "localhost", wantHost: "localhost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme+"/"+tt.user, func(t *testing.T) {
+			t.Parallel()
+			s := ftptest.NewServer(t)
+			p := startTestProxy(t, strings.TrimSuffix(tt.scheme, "h"), tt.user, tt.password)
+			proxyURL := strings.Replace(p.url, "socks5", tt.scheme, 1)
+			if tt.user != "" {
+				proxyURL = strings.Replace(proxyURL, "://", "://"+tt.user+":"+strings.ReplaceAll(tt.password, "@", "%40")+"@", 1)
+			}
+			_, port, _ := net.SplitHostPort(s.Addr)
+
+			c, err := Dial(net.JoinHostPort(tt.host, port), WithTimeout(5*time.Second), WithProxy(proxyURL))
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer func() { _ = c.Quit() }()
+			if err := c.Login("user", "pass"); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Store("a.txt", strings.NewReader("data")); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := c.Retrieve("a.txt", &buf); err != nil || buf.String() != "data" {
+				t.Fatalf("Retrieve = %q, %v", buf.String(), err)
+			}
+
+			// The control connection and both data connections
+			if n := p.tunnels.Load(); n != 3 {
+				t.Errorf("%d tunnels, want 3", n)
+			}
+			if hosts := p.tunnelHosts(); hosts[0] != tt.wantHost {
+				t.Errorf("Proxy asked for %q, want %q", hosts[0], tt.wantHost)
+			}
+			if got := c.Config().Proxy; strings.Contains(got, "secret") || strings.Contains(got, "word") {
+				t.Errorf("Config().Proxy = %q, includes the password", got)
+			}
+		})
+	}
+}
+
+func TestWithProxy_Errors(t *testing.T) {
+	t.Parallel()
+	s := ftptest.NewServer(t)
+	for _, scheme := range []string{"http", "socks5"} {
+		p := startTestProxy(t, scheme, "alice", "secret")
+		u := strings.Replace(p.url, "://", "://alice:wrong@", 1)
+		if _, err := Dial(s.Addr, WithTimeout(5*time.Second), WithProxy(u)); err == nil || !strings.Contains(err.Error(), "proxy") {
+			t.Errorf("%s: Dial with wrong credentials = %v, want a proxy error", scheme, err)
+		}
+	}
+
+	for _, opts := range [][]Option{
+		{WithProxy("https://proxy:443")},
+		{WithProxy("socks5://:1080")},
+		{WithProxy("http://proxy:3128"), WithActiveMode()},
+	} {
+		if _, err := Dial(s.Addr, opts...); err == nil {
+			t.Errorf("Dial with invalid proxy options succeeded")
+		}
+	}
+}
+
+func TestWithProxyFromEnvironment(t *testing.T) {
+	s := ftptest.NewServer(t)
+	p := startTestProxy(t, "socks5", "", "")
+	for _, name := range []string{"ftp_proxy", "FTP_PROXY", "all_proxy", "ALL_PROXY", "no_proxy", "NO_PROXY"} {
+		t.Setenv(name, "")
+	}
+
+	dial := func() *Client {
+		t.Helper()
+		c, err := Dial(s.Addr, WithTimeout(5*time.Second), WithProxyFromEnvironment())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = c.Quit()
+		return c
+	}
+
+	t.Setenv("ALL_PROXY", p.url)
+	if c := dial(); c.Config().Proxy != "environment" || p.tunnels.Load() != 1 {
+		t.Errorf("ALL_PROXY: %d tunnels, Config().Proxy = %q", p.tunnels.Load(), c.Config().Proxy)
+	}
+	t.Setenv("ALL_PROXY", "")
+	t.Setenv("ftp_proxy", p.url)
+	dial()
+	if n := p.tunnels.Load(); n != 2 {
+		t.Errorf("ftp_proxy: %d tunnels, want 2", n)
+	}
+	t.Setenv("no_proxy", "example.com, 127.0.0.0/8")
+	dial()
+	if n := p.tunnels.Load(); n != 2 {
+		t.Errorf("no_proxy: %d tunnels, want 2", n)
+	}
+}
+
+func TestNoProxy(t *testing.T) {
+	tests := []struct {
+		list, host string
+		want       bool
+	}{
+		{"", "ftp.example.com", false},
+		{"*", "ftp.example.com", true},
+		{"example.com", "ftp.example.com", true},
+		{".example.com", "ftp.example.com", true},
+		{"example.com", "example.com", true},
+		{"example.com", "badexample.com", false},
+		{"other.org, EXAMPLE.com:21", "ftp.example.com", true},
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "192.168.1.1", false},
+		{"::1", "::1", true},
+		{"[::1]:21", "::1", true},
+	}
+	for _, tt := range tests {
+		if got := noProxy(tt.list, tt.host); got != tt.want {
+			t.Errorf("noProxy(%q, %q) = %v, want %v", tt.list, tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package ftp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Quirks lists server behaviors that need a workaround. Quirks are set from a
+// server profile (WithServerProfile), explicitly (WithQuirks), or when the
+// client detects them at runtime. Detected quirks can be read with
+// Client.Quirks and passed to WithQuirks on later connections to the same
+// server, so detection does not have to fail a command first.
+type Quirks struct {
+	// DisableEPSV makes passive mode use PASV only. Detected when EPSV is
+	// not implemented (502) or its data port cannot be reached.
+	DisableEPSV bool `json:"disable_epsv,omitempty" yaml:"disable_epsv,omitempty"`
+
+	// IgnorePASVAddress makes passive mode connect to the control
+	// connection's host, keeping only the port of PASV replies. Detected
+	// when a server reached at a public address advertises a private one.
+	IgnorePASVAddress bool `json:"ignore_pasv_address,omitempty" yaml:"ignore_pasv_address,omitempty"`
+
+	// DisableMLSD makes MLList and MLListIter use LIST, for servers that
+	// advertise MLSD but reject it. Capabilities reports no MLSD support.
+	// Detected when MLSD is rejected as not implemented (500 or 502).
+	DisableMLSD bool `json:"disable_mlsd,omitempty" yaml:"disable_mlsd,omitempty"`
+
+	// SizeNeedsBinary makes Size switch to binary mode (TYPE I) for the SIZE
+	// command, for servers that refuse SIZE in ASCII mode. Detected from a
+	// 550 reply mentioning ASCII.
+	SizeNeedsBinary bool `json:"size_needs_binary,omitempty" yaml:"size_needs_binary,omitempty"`
+}
+
+// merge enables the quirks enabled in o.
+func (q *Quirks) merge(o Quirks) {
+	q.DisableEPSV = q.DisableEPSV || o.DisableEPSV
+	q.IgnorePASVAddress = q.IgnorePASVAddress || o.IgnorePASVAddress
+	q.DisableMLSD = q.DisableMLSD || o.DisableMLSD
+	q.SizeNeedsBinary = q.SizeNeedsBinary || o.SizeNeedsBinary
+}
+
+// serverProfiles maps server names accepted by WithServerProfile to the
+// quirks of their common configurations.
+var serverProfiles = map[string]Quirks{
+	// vsftpd does not implement MLSD and refuses SIZE in ASCII mode
+	"vsftpd": {DisableMLSD: true, SizeNeedsBinary: true},
+
+	// ProFTPD refuses SIZE in ASCII mode
+	"proftpd": {SizeNeedsBinary: true},
+
+	// FileZilla Server behind NAT advertises its private address unless
+	// an external address is configured
+	"filezilla": {IgnorePASVAddress: true},
+}
+
+// WithServerProfile enables the workarounds for a known server: "vsftpd",
+// "proftpd" or "filezilla". Use WithQuirks for other servers. Quirks that are
+// not part of the profile are still detected at runtime.
+//
+// Example:
+//
+//	client, _ := ftp.Dial("ftp.example.com:21",
+//	    ftp.WithServerProfile("vsftpd"),
+//	)
+func WithServerProfile(name string) Option {
+	return func(c *Client) error {
+		q, ok := serverProfiles[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("unknown server profile: %s", name)
+		}
+		c.quirks.merge(q)
+		return nil
+	}
+}
+
+// WithQuirks enables workarounds for server behaviors, e.g. the quirks
+// detected on an earlier connection (see Client.Quirks).
+//
+// Example:
+//
+//	client, _ := ftp.Dial("ftp.example.com:21",
+//	    ftp.WithQuirks(ftp.Quirks{DisableEPSV: true, DisableMLSD: true}),
+//	)
+func WithQuirks(q Quirks) Option {
+	return func(c *Client) error {
+		c.quirks.merge(q)
+		return nil
+	}
+}
+
+// Quirks returns the workarounds in use: those set with options, plus those
+// detected so far on this connection.
+func (c *Client) Quirks() Quirks {
+	return c.quirks
+}
+
+// detectedQuirk logs a quirk detected at runtime.
+func (c *Client) detectedQuirk(name string) {
+	if c.debugEnabled() {
+		c.logger.Debug("ftp server quirk detected", "quirk", name)
+	}
+}
+
+// unreachablePASVAddress reports whether ip, from a PASV reply, is a private
+// address while the control connection goes to a public one: the server is
+// behind NAT and advertises its internal address.
+func (c *Client) unreachablePASVAddress(ip net.IP) bool {
+	if !isLocalIP(ip) {
+		return false
+	}
+	remote, ok := c.conn.RemoteAddr().(*net.TCPAddr)
+	return ok && !isLocalIP(remote.IP)
+}
+
+// isLocalIP reports whether ip is a private, loopback or link-local address.
+func isLocalIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()
+}
@@ -0,0 +1,186 @@
+package ftp
+
+import (
+	"fmt"
+	"net"
+	"net/textproto"
+	"slices"
+	"testing"
+)
+
+func TestWithServerProfile(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{}
+	if err := WithServerProfile("VSFTPD")(c); err != nil {
+		t.Fatalf("WithServerProfile failed: %v", err)
+	}
+	if err := WithQuirks(Quirks{DisableEPSV: true})(c); err != nil {
+		t.Fatalf("WithQuirks failed: %v", err)
+	}
+	want := Quirks{DisableEPSV: true, DisableMLSD: true, SizeNeedsBinary: true}
+	if got := c.Quirks(); got != want {
+		t.Errorf("Quirks = %+v, want %+v", got, want)
+	}
+
+	if err := WithServerProfile("unknown")(c); err == nil {
+		t.Error("Expected error for unknown profile")
+	}
+}
+
+// newQuirksMockServer returns a mock server with a passive data listener that
+// serves listing for LIST.
+func newQuirksMockServer(t *testing.T, listing string) *mockServer {
+	t.Helper()
+	ms := newMockServer(t)
+	ms.serveData(t)
+
+	ms.handlers["LIST"] = ms.transfer(func(dconn net.Conn) {
+		_, _ = fmt.Fprint(dconn, listing)
+	})
+	return ms
+}
+
+func TestQuirks_EPSVUnreachable(t *testing.T) {
+	t.Parallel()
+	ms := newQuirksMockServer(t, "-rw-r--r-- 1 ftp ftp 5 Jan 01 2024 a.txt\r\n")
+
+	// EPSV advertises a port nobody listens on
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+	ms.handlers["EPSV"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("229 Entering Extended Passive Mode (|||%d|)", closedPort)
+	}
+	c := dialMock(t, ms)
+
+	for range 2 {
+		if _, err := c.List(""); err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+	}
+	if !c.Quirks().DisableEPSV {
+		t.Error("DisableEPSV not detected")
+	}
+
+	if n := countCommands(ms.receivedCommands, "EPSV"); n != 1 {
+		t.Errorf("EPSV sent %d times, want 1: %v", n, ms.receivedCommands)
+	}
+}
+
+func TestQuirks_MLSDFallback(t *testing.T) {
+	t.Parallel()
+	ms := newQuirksMockServer(t,
+		"-rw-r--r-- 1 ftp ftp 5 Jan 01 2024 a.txt\r\ndrwxr-xr-x 2 ftp ftp 0 Jan 01 2024 sub\r\n")
+	ms.handlers["EPSV"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("502 Not implemented.")
+	}
+	ms.handlers["MLSD"] = func(c *textproto.Conn, args string) {
+		// Drop the data connection opened for MLSD, as real servers do
+		if dconn, err := ms.dataListener.Accept(); err == nil {
+			dconn.Close()
+		}
+		_ = c.PrintfLine("500 Unknown command.")
+	}
+	ms.handlers["FEAT"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("211-Features:")
+		_ = c.PrintfLine(" MLSD")
+		_ = c.PrintfLine("211 End")
+	}
+	ms.handlers["SYST"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("215 UNIX Type: L8")
+	}
+	c := dialMock(t, ms)
+
+	for range 2 {
+		entries, err := c.MLList("")
+		if err != nil {
+			t.Fatalf("MLList failed: %v", err)
+		}
+		if len(entries) != 2 || entries[0].Name != "a.txt" || entries[0].Size != 5 || entries[1].Type != "dir" {
+			t.Fatalf("Unexpected entries: %+v %+v", entries[0], entries[1])
+		}
+	}
+
+	q := c.Quirks()
+	if !q.DisableMLSD || !q.DisableEPSV {
+		t.Errorf("Quirks = %+v, want DisableMLSD and DisableEPSV", q)
+	}
+	caps, err := c.Capabilities()
+	if err != nil || caps.MLSD {
+		t.Errorf("Capabilities().MLSD = %v, %v; want false", caps.MLSD, err)
+	}
+}
+
+func TestQuirks_SizeNeedsBinary(t *testing.T) {
+	t.Parallel()
+	ms := newMockServer(t)
+	binary := false
+	ms.handlers["TYPE"] = func(c *textproto.Conn, args string) {
+		binary = args == "I"
+		_ = c.PrintfLine("200 Type set to %s.", args)
+	}
+	ms.handlers["SIZE"] = func(c *textproto.Conn, args string) {
+		if !binary {
+			_ = c.PrintfLine("550 SIZE not allowed in ASCII mode")
+			return
+		}
+		_ = c.PrintfLine("213 1234")
+	}
+	c := dialMock(t, ms)
+
+	if err := c.Type("A"); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		size, err := c.Size("file.txt")
+		if err != nil || size != 1234 {
+			t.Fatalf("Size = %d, %v", size, err)
+		}
+	}
+	if !c.Quirks().SizeNeedsBinary {
+		t.Error("SizeNeedsBinary not detected")
+	}
+	if c.currentType != "A" {
+		t.Errorf("transfer type = %q, want A restored", c.currentType)
+	}
+
+	if n := countCommands(ms.receivedCommands, "SIZE"); n != 3 {
+		t.Errorf("SIZE sent %d times, want 3: %v", n, ms.receivedCommands)
+	}
+}
+
+// remoteAddrConn overrides the remote address of a connection.
+type remoteAddrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c remoteAddrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestUnreachablePASVAddress(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		remote, pasv string
+		want         bool
+	}{
+		{"203.0.113.10", "10.0.0.5", true},
+		{"203.0.113.10", "192.168.1.2", true},
+		{"203.0.113.10", "203.0.113.10", false},
+		{"192.168.1.1", "10.0.0.5", false},
+		{"127.0.0.1", "127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		c := &Client{conn: remoteAddrConn{remote: &net.TCPAddr{IP: net.ParseIP(tt.remote), Port: 21}}}
+		if got := c.unreachablePASVAddress(net.ParseIP(tt.pasv)); got != tt.want {
+			t.Errorf("unreachablePASVAddress(%s) from %s = %v, want %v", tt.pasv, tt.remote, got, tt.want)
+		}
+	}
+}
+
+func countCommands(cmds []string, cmd string) int {
+	return len(slices.DeleteFunc(slices.Clone(cmds), func(c string) bool { return c != cmd }))
+}
@@ -0,0 +1,89 @@
+package ftp_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/server"
+)
+
+func TestClient_RetrieveRange(t *testing.T) {
+	t.Parallel()
+
+	content := make([]byte, 1024*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	tests := []struct {
+		name string
+		opts []server.Option
+	}{
+		{"RANG", nil},
+		{"REST fallback", []server.Option{server.WithDisableCommands("RANG")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			addr, cleanup, rootDir := setupServerWithOptions(t, tt.opts...)
+			defer cleanup()
+
+			if err := os.WriteFile(filepath.Join(rootDir, "large.bin"), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := ftp.Dial(addr, ftp.WithTimeout(5*time.Second))
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer c.Quit()
+			if err := c.Login("test", "test"); err != nil {
+				t.Fatalf("Login failed: %v", err)
+			}
+
+			ranges := [][2]int64{
+				{0, 0},
+				{0, 9},
+				{1000, 1999},
+				{512 * 1024, 768*1024 - 1},
+				{int64(len(content)) - 10, int64(len(content)) - 1},
+			}
+			for _, r := range ranges {
+				var buf bytes.Buffer
+				if err := c.RetrieveRange("large.bin", &buf, r[0], r[1]); err != nil {
+					t.Fatalf("RetrieveRange(%d, %d) failed: %v", r[0], r[1], err)
+				}
+				if !bytes.Equal(buf.Bytes(), content[r[0]:r[1]+1]) {
+					t.Errorf("RetrieveRange(%d, %d): content mismatch (got %d bytes)", r[0], r[1], buf.Len())
+				}
+			}
+
+			// A range past the end of the file is short
+			var buf bytes.Buffer
+			if err := c.RetrieveRange("large.bin", &buf, int64(len(content))-5, int64(len(content))+5); err == nil {
+				t.Error("Expected error for range past end of file")
+			}
+
+			if err := c.RetrieveRange("large.bin", &buf, 10, 5); err == nil {
+				t.Error("Expected error for invalid range")
+			}
+
+			// The control connection must still be in sync
+			if err := c.Noop(); err != nil {
+				t.Errorf("Noop failed: %v", err)
+			}
+			var full bytes.Buffer
+			if err := c.Retrieve("large.bin", &full); err != nil {
+				t.Fatalf("Retrieve failed: %v", err)
+			}
+			if !bytes.Equal(full.Bytes(), content) {
+				t.Error("Full retrieve after ranges: content mismatch")
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package ftp
+
+import (
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadDir reads the directory p and returns its entries sorted by name, as
+// os.ReadDir does, so the client can be used wherever a directory reader
+// is expected. The entries' Info method never fails, since all metadata is
+// fetched up front. Their Sys method returns the *MLEntry or *Entry the
+// information came from.
+//
+// ReadDir picks the cheapest way to get names, types, sizes and
+// modification times from the server:
+//
+//   - With MLSD (RFC 3659), a single listing has everything: one data
+//     connection, whatever the number of entries.
+//   - Otherwise, a LIST gives names, types and permissions, then the size
+//     and modification time of each file are fetched with SIZE and MDTM,
+//     pipelined as in BatchStat: one data connection plus about one round
+//     trip for every 16 files.
+//   - With WithNameListFallback, servers without LIST are listed with NLST,
+//     which also costs a CWD or SIZE round trip per entry (see List).
+//
+// Modes have the permission bits of the listing when the server reports
+// them (the unix.mode fact, or Unix-style LIST permissions), and 0 otherwise.
+//
+// Example:
+//
+//	entries, err := client.ReadDir("/pub")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, e := range entries {
+//	    info, _ := e.Info()
+//	    fmt.Printf("%s %8d %s %s\n", info.Mode(), info.Size(), info.ModTime().Format(time.DateTime), e.Name())
+//	}
+func (c *Client) ReadDir(p string) ([]fs.DirEntry, error) {
+	if (c.HasFeature("MLST") || c.HasFeature("MLSD")) && !c.quirks.DisableMLSD {
+		mls, err := c.MLList(p)
+		if err != nil {
+			return nil, err
+		}
+		// MLList lists with LIST when MLSD turns out not to be implemented;
+		// those entries lack modification times, so list again below
+		if !c.quirks.DisableMLSD {
+			return sortedDirEntries(mls, fileInfoFromMLEntry), nil
+		}
+	}
+
+	entries, err := c.List(p)
+	if err != nil {
+		return nil, err
+	}
+	entries = slices.DeleteFunc(entries, func(e *Entry) bool {
+		return e.Name == "." || e.Name == ".."
+	})
+
+	// Refine the sizes and fill in the modification times of files
+	var paths []string
+	var files []*Entry
+	for _, e := range entries {
+		if e.Type == "file" {
+			paths = append(paths, path.Join(p, e.Name))
+			files = append(files, e)
+		}
+	}
+	results, err := c.BatchStat(paths)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range results {
+		if r.Err == nil {
+			files[i].Size = r.Size
+			files[i].ModTime = r.ModTime
+		}
+	}
+
+	return sortedDirEntries(entries, fileInfoFromEntry), nil
+}
+
+// sortedDirEntries converts entries with info and sorts them by name.
+func sortedDirEntries[E any](entries []E, info func(E) *fileInfo) []fs.DirEntry {
+	dirEntries := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		if fi := info(e); fi != nil {
+			dirEntries = append(dirEntries, fi)
+		}
+	}
+	slices.SortFunc(dirEntries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return dirEntries
+}
+
+// fileInfoFromMLEntry returns the fileInfo of an MLSD entry, or nil for the
+// entries of the directory itself and its parent.
+func fileInfoFromMLEntry(ml *MLEntry) *fileInfo {
+	fi := &fileInfo{name: ml.Name, size: ml.Size, modTime: ml.ModTime, sys: ml}
+	switch typ := strings.ToLower(ml.Type); {
+	case typ == "cdir" || typ == "pdir" || ml.Name == "." || ml.Name == "..":
+		return nil
+	case typ == "dir":
+		fi.mode = fs.ModeDir
+	case strings.Contains(typ, "link"):
+		fi.mode = fs.ModeSymlink
+	}
+	if perm, err := strconv.ParseUint(ml.UnixMode, 8, 32); err == nil {
+		fi.mode |= fs.FileMode(perm) & fs.ModePerm
+	}
+	return fi
+}
+
+// fileInfoFromEntry returns the fileInfo of a LIST entry.
+func fileInfoFromEntry(e *Entry) *fileInfo {
+	fi := &fileInfo{name: e.Name, size: e.Size, modTime: e.ModTime, sys: e}
+	switch e.Type {
+	case "dir":
+		fi.mode = fs.ModeDir
+	case "link":
+		fi.mode = fs.ModeSymlink
+	}
+	fi.mode |= listPerm(e.Raw)
+	return fi
+}
+
+// listPerm returns the permission bits of a Unix-style LIST line, such as
+// "-rw-r--r-- 1 owner group 1234 Jan 01 12:00 name", or 0 for other
+// formats.
+func listPerm(raw string) fs.FileMode {
+	perms, _, _ := strings.Cut(raw, " ")
+	if len(perms) < 10 || !isSymbolicPerms(perms) {
+		return 0
+	}
+	var mode fs.FileMode
+	for i, c := range perms[1:10] {
+		// S and T are setuid, setgid or sticky without the execute bit
+		if c != '-' && c != 'S' && c != 'T' {
+			mode |= 1 << (8 - i)
+		}
+	}
+	return mode
+}
+
+// fileInfo describes a remote file. It implements both fs.FileInfo and
+// fs.DirEntry.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	sys     any
+}
+
+func (fi *fileInfo) Name() string               { return fi.name }
+func (fi *fileInfo) Size() int64                { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode          { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time         { return fi.modTime }
+func (fi *fileInfo) IsDir() bool                { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() any                   { return fi.sys }
+func (fi *fileInfo) Type() fs.FileMode          { return fi.mode.Type() }
+func (fi *fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+func (fi *fileInfo) String() string             { return fs.FormatDirEntry(fi) }
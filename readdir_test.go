@@ -0,0 +1,82 @@
+package ftp_test
+
+import (
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
+	"github.com/gonzalop/ftp/server"
+)
+
+func TestReadDir(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		opts []server.Option
+		mlsd bool // Whether the entries must come from MLSD
+	}{
+		{"MLSD", nil, true},
+		{"LIST", []server.Option{server.WithDisableMLSD(true)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := ftptest.NewServer(t, tt.opts...)
+			for name, data := range map[string]string{
+				"/pub/b.txt":     "hello",
+				"/pub/a.bin":     "0123456789",
+				"/pub/sub/c.txt": "hi",
+			} {
+				if err := s.WriteFile(name, []byte(data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := dialTestServer(t, s)
+			entries, err := c.ReadDir("/pub")
+			if err != nil {
+				t.Fatalf("ReadDir: %v", err)
+			}
+
+			want := []struct {
+				name  string
+				isDir bool
+				size  int64
+			}{
+				{"a.bin", false, 10},
+				{"b.txt", false, 5},
+				{"sub", true, 0},
+			}
+			if len(entries) != len(want) {
+				t.Fatalf("ReadDir returned %v, want %d entries", entries, len(want))
+			}
+			for i, w := range want {
+				e := entries[i]
+				info, err := e.Info()
+				if err != nil {
+					t.Fatalf("Info(%s): %v", e.Name(), err)
+				}
+				if e.Name() != w.name || e.IsDir() != w.isDir || (e.Type() == fs.ModeDir) != w.isDir {
+					t.Errorf("Entry %d = %v, want %s (dir=%v)", i, e, w.name, w.isDir)
+				}
+				if !w.isDir && info.Size() != w.size {
+					t.Errorf("%s: size %d, want %d", w.name, info.Size(), w.size)
+				}
+				if !w.isDir && time.Since(info.ModTime()) > time.Hour {
+					t.Errorf("%s: modification time %v", w.name, info.ModTime())
+				}
+				// The server's MLSD facts have no unix.mode
+				if !w.isDir && !tt.mlsd && info.Mode().Perm() == 0 {
+					t.Errorf("%s: no permission bits in %v", w.name, info.Mode())
+				}
+				if _, ok := info.Sys().(*ftp.MLEntry); ok != tt.mlsd {
+					t.Errorf("%s: Sys() = %T", w.name, info.Sys())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gonzalop/ftp/internal/blockmode"
+)
+
+// retryableCommands are the commands repeated after reconnecting (see
+// WithAutoReconnect): they don't change files, and the commands that set
+// up a data connection can be repeated on the new control connection.
+var retryableCommands = map[string]bool{
+	"CWD": true, "CDUP": true, "PWD": true, "TYPE": true, "MODE": true,
+	"STRU": true, "SIZE": true, "MDTM": true, "MLST": true, "STAT": true,
+	"NOOP": true, "FEAT": true, "OPTS": true, "SYST": true, "HELP": true,
+	"PASV": true, "EPSV": true, "PORT": true, "EPRT": true, "REST": true,
+	"HASH": true, "XCRC": true, "XMD5": true, "XSHA1": true,
+	"XSHA256": true, "XSHA512": true, "MD5": true, "AVBL": true,
+	"CLNT": true, "LANG": true, "ALLO": true,
+}
+
+// noReconnectCommands are the commands whose failures are returned as they
+// are: those run while connecting and logging in, and QUIT.
+var noReconnectCommands = map[string]bool{
+	"AUTH": true, "PBSZ": true, "PROT": true, "HOST": true, "USER": true,
+	"PASS": true, "ACCT": true, "REIN": true, "QUIT": true, "ABOR": true,
+}
+
+// connectionLost reports whether the result of a command shows the control
+// connection is no longer usable.
+func connectionLost(resp *Response, err error) bool {
+	if errors.Is(err, ErrLineFeed) {
+		return false // Nothing was sent
+	}
+	return err != nil || resp.Code == 421
+}
+
+// reconnectAndRetry restores a lost control connection after command failed,
+// and retries command if it is safe to repeat. resp and err are the result
+// of the failed attempt, returned if the client cannot reconnect.
+func (c *Client) reconnectAndRetry(command string, args []string, resp *Response, err error) (*Response, error) {
+	name := commandName(command)
+	if noReconnectCommands[name] || c.username == "" {
+		return resp, err
+	}
+	if err == nil {
+		err = &ProtocolError{Command: command, Response: resp.Message, Code: resp.Code}
+	}
+
+	// Only one goroutine reconnects; the others get their own error
+	if !c.reconnecting.CompareAndSwap(false, true) {
+		return resp, err
+	}
+	rerr := c.reconnect()
+	c.reconnecting.Store(false)
+	if rerr != nil {
+		return resp, errors.Join(err, fmt.Errorf("ftp: reconnect failed: %w", rerr))
+	}
+
+	if !retryableCommands[name] {
+		return nil, &ReconnectedError{Command: name, Err: err}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sendCommandLocked(command, args...)
+}
+
+// reconnect opens a new control connection, restores the session state and
+// replaces the lost connection with it.
+func (c *Client) reconnect() error {
+	c.logger.Debug("ftp control connection lost, reconnecting", "host", c.host)
+
+	c.mu.Lock()
+	workDir := c.workDir
+	c.mu.Unlock()
+
+	nc, err := c.clone()
+	if err != nil {
+		return err
+	}
+	if workDir != "" {
+		if _, err := nc.expect2xx("CWD", workDir); err != nil {
+			_ = nc.Quit()
+			return err
+		}
+	}
+	if c.currentType != "" {
+		if err := nc.Type(c.currentType); err != nil {
+			_ = nc.Quit()
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.Close()
+	if c.activeDataConn != nil {
+		_ = blockmode.Interrupt(c.activeDataConn)
+		c.activeDataConn = nil
+	}
+	if c.keptData != nil {
+		_ = c.keptData.Close()
+		c.keptData = nil
+	}
+	c.blockChecked, c.blockMode = false, false
+	c.transferReply = nil
+	c.conn, c.connDialer = nc.conn, nc.connDialer
+	c.reader = nc.reader
+	c.sent = nil
+	c.lastCommand = nc.lastCommand
+	c.greeting, c.loginMessage = nc.greeting, nc.loginMessage
+	c.logger.Debug("ftp control connection restored", "host", c.host)
+	return nil
+}
+
+// rememberDir records the working directory to restore after reconnecting.
+func (c *Client) rememberDir() {
+	if !c.autoReconnect {
+		return
+	}
+	if dir, err := c.CurrentDir(); err == nil {
+		c.mu.Lock()
+		c.workDir = dir
+		c.mu.Unlock()
+	}
+}
+
+// commandName returns the upper-case name of a command, as sent to Quote.
+func commandName(command string) string {
+	name, _, _ := strings.Cut(command, " ")
+	return strings.ToUpper(name)
+}
@@ -0,0 +1,78 @@
+package ftp_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
+)
+
+func TestAutoReconnect(t *testing.T) {
+	t.Parallel()
+	s := ftptest.NewServer(t)
+	if err := s.WriteFile("/pub/a.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ftp.Dial(s.Addr, ftp.WithTimeout(2*time.Second), ftp.WithAutoReconnect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+	if err := c.Login("user", "pass"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ChangeDir("pub"); err != nil { // CWD + PWD
+		t.Fatal(err)
+	}
+
+	// USER, PASS, CWD and PWD were sent: the next command gets 421. On the
+	// new connection, USER, PASS and CWD are sent before SIZE is retried.
+	s.DisconnectAfter(4)
+	size, err := c.Size("a.txt")
+	if err != nil || size != 5 {
+		t.Fatalf("Size = %d, %v; want 5 after reconnecting", size, err)
+	}
+
+	// DELE is not retried
+	err = c.Delete("a.txt")
+	var re *ftp.ReconnectedError
+	if !errors.Is(err, ftp.ErrReconnected) || !errors.As(err, &re) || re.Command != "DELE" {
+		t.Fatalf("Delete error = %v, want a ReconnectedError for DELE", err)
+	}
+	if _, err := s.ReadFile("/pub/a.txt"); err != nil {
+		t.Errorf("file deleted by the failed command: %v", err)
+	}
+
+	s.DisconnectAfter(0)
+	if err := c.Delete("a.txt"); err != nil {
+		t.Fatalf("Delete after reconnecting failed: %v", err)
+	}
+	if dir, err := c.CurrentDir(); err != nil || dir != "/pub" {
+		t.Errorf("CurrentDir = %q, %v; want /pub", dir, err)
+	}
+}
+
+func TestAutoReconnect_Disabled(t *testing.T) {
+	t.Parallel()
+	s := ftptest.NewServer(t)
+
+	c, err := ftp.Dial(s.Addr, ftp.WithTimeout(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+	if err := c.Login("user", "pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.DisconnectAfter(2)
+	if err := c.NoOp(); err == nil {
+		t.Fatal("NoOp succeeded after the server closed the connection")
+	}
+	if err := c.NoOp(); err == nil || errors.Is(err, ftp.ErrReconnected) {
+		t.Errorf("NoOp error = %v, want the connection to stay closed", err)
+	}
+}
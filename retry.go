@@ -0,0 +1,187 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy sets how failed uploads are retried (see WithRetryPolicy).
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, including the first one.
+	// Values below 2 disable retries.
+	MaxAttempts int
+
+	// Backoff is the wait before the first retry. It doubles after each
+	// retry, up to MaxBackoff (0 = no limit).
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Spool makes uploads from readers that cannot be rewound retryable:
+	// the data is copied to a temporary file in SpoolDir (os.TempDir() if
+	// empty) as it is read, and re-sent from there. The file is removed
+	// when the upload returns.
+	Spool    bool
+	SpoolDir string
+}
+
+// uploadSource is the data of an upload, read again for each attempt.
+type uploadSource struct {
+	r        io.Reader
+	seekable bool     // r is an io.Seeker
+	start    int64    // Position of r at the first attempt
+	spool    *os.File // Copy of the data read from r, when spooling
+	err      error    // Error reading the data, which is not retried
+}
+
+// newUploadSource returns the source of an upload from r, or nil if r cannot
+// be read again under policy p.
+func newUploadSource(r io.Reader, p *RetryPolicy) (*uploadSource, error) {
+	src := &uploadSource{r: r}
+	if s, ok := r.(io.Seeker); ok {
+		if start, err := s.Seek(0, io.SeekCurrent); err == nil {
+			src.seekable, src.start = true, start
+			return src, nil
+		}
+	}
+	if _, ok := r.(io.ReaderAt); ok {
+		return src, nil
+	}
+	if !p.Spool {
+		return nil, nil
+	}
+
+	f, err := os.CreateTemp(p.SpoolDir, "ftp-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	src.spool = f
+	return src, nil
+}
+
+// reader returns the data of the next attempt.
+func (s *uploadSource) reader() (io.Reader, error) {
+	var r io.Reader
+	switch {
+	case s.spool != nil:
+		// The data read so far is re-sent from the spool file, and the
+		// rest is spooled as it is read
+		n, err := s.spool.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		r = io.MultiReader(io.NewSectionReader(s.spool, 0, n), io.TeeReader(s.r, s.spool))
+	case s.seekable:
+		if _, err := s.r.(io.Seeker).Seek(s.start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		r = s.r
+	default:
+		// An io.ReaderAt without Seek is read from the start
+		r = io.NewSectionReader(s.r.(io.ReaderAt), 0, math.MaxInt64)
+	}
+	return &sourceReader{src: s, r: r}, nil
+}
+
+// close removes the spool file, if any.
+func (s *uploadSource) close() {
+	if s.spool != nil {
+		_ = s.spool.Close()
+		_ = os.Remove(s.spool.Name())
+	}
+}
+
+// sourceReader records the errors reading the data of an upload.
+type sourceReader struct {
+	src *uploadSource
+	r   io.Reader
+}
+
+func (r *sourceReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.src.err = err
+	}
+	return n, err
+}
+
+// retryableError reports whether an upload that failed with err may succeed
+// if attempted again: temporary (4xx) replies, lost connections and
+// checksum mismatches.
+func retryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrReconnected) {
+		return true
+	}
+	var pe *ProtocolError
+	if errors.As(err, &pe) {
+		return pe.IsTemporary()
+	}
+	var ce *ChecksumMismatchError
+	var ne net.Error
+	return errors.As(err, &ce) || errors.As(err, &ne) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// storeRetrying calls upload with the data of r, retrying it as set with
+// WithRetryPolicy. Appends and exclusive uploads are not retried, since a
+// failed attempt may have left part of the data on the server.
+func (c *Client) storeRetrying(r io.Reader, o transferOptions, upload func(io.Reader) error) error {
+	p := c.retryPolicy
+	if p == nil || p.MaxAttempts < 2 || o.mode != WriteOverwrite {
+		return upload(r)
+	}
+	src, err := newUploadSource(r, p)
+	if err != nil {
+		return err
+	}
+	if src == nil {
+		return upload(r)
+	}
+	defer src.close()
+
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		data, err := src.reader()
+		if err != nil {
+			return fmt.Errorf("upload failed: %w", err)
+		}
+		err = upload(data)
+		if err == nil || attempt >= p.MaxAttempts || src.err != nil || !retryableError(err) {
+			return err
+		}
+		c.logger.Debug("ftp upload failed, retrying", "attempt", attempt, "error", err)
+
+		if err := sleepContext(o.ctx, backoff); err != nil {
+			return fmt.Errorf("upload failed: %w", err)
+		}
+		backoff *= 2
+		if p.MaxBackoff > 0 {
+			backoff = min(backoff, p.MaxBackoff)
+		}
+	}
+}
+
+// sleepContext waits for d, or until ctx (which may be nil) is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,95 @@
+package ftp_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
+)
+
+// readerAt is an io.ReaderAt that cannot Seek.
+type readerAt struct{ *bytes.Reader }
+
+func (r readerAt) Read(p []byte) (int, error)              { return r.Reader.Read(p) }
+func (r readerAt) ReadAt(p []byte, off int64) (int, error) { return r.Reader.ReadAt(p, off) }
+
+// pipe is a reader that can only be read once.
+type pipe struct{ io.Reader }
+
+func TestRetryPolicy(t *testing.T) {
+	t.Parallel()
+	data := bytes.Repeat([]byte("retried upload\n"), 16*1024) // 240 KiB
+
+	for _, tc := range []struct {
+		name     string
+		reader   func() io.Reader
+		spool    bool
+		chaos    ftptest.Chaos
+		fail     func(s *ftptest.Server)
+		wantStor int
+		wantErr  bool
+	}{
+		// Seed 3 drops the first upload
+		{name: "seeker", reader: func() io.Reader { return bytes.NewReader(data) },
+			chaos: ftptest.Chaos{Seed: 3, DropRate: 0.5}, wantStor: 2},
+		{name: "reader at", reader: func() io.Reader { return readerAt{bytes.NewReader(data)} },
+			chaos: ftptest.Chaos{Seed: 3, DropRate: 0.5}, wantStor: 2},
+		{name: "spooled", reader: func() io.Reader { return pipe{bytes.NewReader(data)} }, spool: true,
+			chaos: ftptest.Chaos{Seed: 3, DropRate: 0.5}, wantStor: 2},
+		{name: "not rewindable", reader: func() io.Reader { return pipe{bytes.NewReader(data)} },
+			chaos: ftptest.Chaos{Seed: 3, DropRate: 0.5}, wantStor: 1, wantErr: true},
+		{name: "attempts exhausted", reader: func() io.Reader { return bytes.NewReader(data) },
+			chaos: ftptest.Chaos{Seed: 3, DropRate: 1}, wantStor: 4, wantErr: true},
+		{name: "permanent error", reader: func() io.Reader { return bytes.NewReader(data) },
+			fail: func(s *ftptest.Server) { s.FailCommand("STOR", 553, "Not allowed.") }, wantStor: 1, wantErr: true},
+		{name: "read error", reader: func() io.Reader {
+			return io.MultiReader(bytes.NewReader(data[:1000]), iotestErrReader{})
+		}, spool: true, wantStor: 1, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			s := ftptest.NewServer(t)
+			var stors atomic.Int32
+			spoolDir := t.TempDir()
+			c := dialTestServer(t, s,
+				ftp.WithRetryPolicy(ftp.RetryPolicy{MaxAttempts: 4, Spool: tc.spool, SpoolDir: spoolDir}),
+				ftp.WithResponseObserver(func(cmd string, resp *ftp.Response) {
+					if cmd == "STOR" && resp.Code >= 200 {
+						stors.Add(1)
+					}
+				}),
+			)
+			s.SetChaos(tc.chaos)
+			if tc.fail != nil {
+				tc.fail(s)
+			}
+
+			err := c.Store("/up.bin", tc.reader())
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Store: %v, want error %v", err, tc.wantErr)
+			}
+			if n := stors.Load(); int(n) != tc.wantStor {
+				t.Errorf("Sent STOR %d times, want %d", n, tc.wantStor)
+			}
+			if files, _ := os.ReadDir(spoolDir); len(files) > 0 {
+				t.Errorf("Spool file %s left behind", files[0].Name())
+			}
+			if tc.wantErr {
+				return
+			}
+			if got, err := s.ReadFile("/up.bin"); err != nil || !bytes.Equal(got, data) {
+				t.Errorf("Uploaded %d bytes (%v), want %d", len(got), err, len(data))
+			}
+		})
+	}
+}
+
+// iotestErrReader fails every read.
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read([]byte) (int, error) { return 0, errors.New("disk on fire") }
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+
+package server
+
+import "syscall"
+
+// reuseAddrControl is a no-op on this platform. On Windows, SO_REUSEADDR
+// would let other processes steal the port.
+func reuseAddrControl(_, _ string, _ syscall.RawConn) error {
+	return nil
+}
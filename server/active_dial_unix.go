@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package server
+
+import "syscall"
+
+// reuseAddrControl sets SO_REUSEADDR on active mode data sockets bound to a
+// fixed source port, so that the port can be bound again while earlier
+// connections from it are in TIME_WAIT, or still open for other sessions.
+func reuseAddrControl(_, _ string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
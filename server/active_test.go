@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// freePort returns a TCP port that is free on the loopback interface.
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestActiveDataSource(t *testing.T) {
+	t.Parallel()
+	source := freePort(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	driver, err := NewFSDriver(t.TempDir())
+	fatalIfErr(t, err, "Failed to create FS driver")
+	startServer(t, ln, WithDriver(driver), WithActiveDataSource(fmt.Sprintf("127.0.0.1:%d", source)))
+
+	tc, err := rawLogin(ln.Addr().String(), "anonymous", "anonymous")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	// Several transfers in a row must all come from the source port, even
+	// though earlier connections from it are in TIME_WAIT.
+	for i := range 3 {
+		dataLn, err := net.Listen("tcp", "127.0.0.1:0")
+		fatalIfErr(t, err, "Failed to listen")
+		port := dataLn.Addr().(*net.TCPAddr).Port
+
+		fmt.Fprintf(tc, "EPRT |1|127.0.0.1|%d|\r\n", port)
+		if code, msg, err := rawReadResponse(tc); err != nil || code != 200 {
+			t.Fatalf("EPRT failed: %q %v", msg, err)
+		}
+		fmt.Fprintf(tc, "LIST\r\n")
+
+		conn, err := dataLn.Accept()
+		fatalIfErr(t, err, "Accept failed")
+		if got := conn.RemoteAddr().(*net.TCPAddr).Port; got != source {
+			t.Errorf("transfer %d: data connection from port %d, want %d", i, got, source)
+		}
+		_, _ = bufio.NewReader(conn).ReadString(0)
+		conn.Close()
+		dataLn.Close()
+
+		for {
+			code, msg, err := rawReadResponse(tc)
+			fatalIfErr(t, err, "LIST failed")
+			if code != 150 {
+				if code != 226 {
+					t.Fatalf("LIST: %q", msg)
+				}
+				break
+			}
+		}
+	}
+}
+
+func TestWithActiveDataSourceInvalid(t *testing.T) {
+	t.Parallel()
+	driver, _ := NewFSDriver(t.TempDir())
+	if _, err := NewServer(":0", WithDriver(driver), WithActiveDataSource("no-port")); err == nil {
+		t.Error("Expected error for invalid active data source")
+	}
+}
+
+func TestWithActiveAllowedNetworksInvalid(t *testing.T) {
+	t.Parallel()
+	driver, _ := NewFSDriver(t.TempDir())
+	if _, err := NewServer(":0", WithDriver(driver), WithActiveAllowedNetworks("192.0.2.1")); err == nil {
+		t.Error("Expected error for an address without a prefix length")
+	}
+}
+
+func TestActiveAddressValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		opts []Option
+		cmd  string
+		code int
+	}{
+		{"PORT", nil, "PORT 127,0,0,1,4,1", 200},
+		{"PORT port zero", nil, "PORT 127,0,0,1,0,0", 501},
+		{"EPRT IPv4", nil, "EPRT |1|127.0.0.1|1025|", 200},
+		{"EPRT port zero", nil, "EPRT |1|127.0.0.1|0|", 501},
+		{"EPRT IPv6 address with protocol 1", nil, "EPRT |1|::1|1025|", 501},
+		{"EPRT IPv4 address with protocol 2", nil, "EPRT |2|127.0.0.1|1025|", 501},
+		{"EPRT unknown protocol", nil, "EPRT |3|127.0.0.1|1025|", 522},
+		{"EPRT other family", []Option{WithAllowFXP(true)}, "EPRT |2|2001:db8::1|1025|", 522},
+		{"EPRT other family allowed", []Option{WithAllowFXP(true), WithAllowMixedAddressFamilies(true)}, "EPRT |2|2001:db8::1|1025|", 200},
+		{"EPRT third party", nil, "EPRT |1|192.0.2.1|1025|", 500},
+		{"PORT privileged", nil, "PORT 127,0,0,1,0,21", 200},
+		{"PORT privileged blocked", []Option{WithBlockPrivilegedPorts(true)}, "PORT 127,0,0,1,0,21", 500},
+		{"EPRT privileged blocked", []Option{WithBlockPrivilegedPorts(true)}, "EPRT |1|127.0.0.1|1023|", 500},
+		{"EPRT unprivileged", []Option{WithBlockPrivilegedPorts(true)}, "EPRT |1|127.0.0.1|1024|", 200},
+		{"EPRT allowed network", []Option{WithActiveAllowedNetworks("192.0.2.0/24")}, "EPRT |1|192.0.2.1|1025|", 200},
+		{"EPRT outside allowed network", []Option{WithActiveAllowedNetworks("192.0.2.0/24")}, "EPRT |1|198.51.100.1|1025|", 500},
+		{"PORT privileged in allowed network", []Option{WithActiveAllowedNetworks("192.0.2.0/24"), WithBlockPrivilegedPorts(true)}, "PORT 192,0,2,1,0,25", 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			fatalIfErr(t, err, "Failed to listen")
+			driver, err := NewFSDriver(t.TempDir())
+			fatalIfErr(t, err, "Failed to create FS driver")
+			startServer(t, ln, append([]Option{WithDriver(driver)}, tt.opts...)...)
+
+			tc, err := rawLogin(ln.Addr().String(), "anonymous", "anonymous")
+			fatalIfErr(t, err, "Login failed")
+			defer tc.Close()
+
+			fmt.Fprintf(tc, "%s\r\n", tt.cmd)
+			code, msg, err := rawReadResponse(tc)
+			fatalIfErr(t, err, "%s failed", tt.cmd)
+			if code != tt.code {
+				t.Errorf("%s: expected %d, got %s", tt.cmd, tt.code, strings.TrimSpace(msg))
+			}
+		})
+	}
+}
+
+func TestActivePolicyAudit(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	driver, err := NewFSDriver(t.TempDir())
+	fatalIfErr(t, err, "Failed to create FS driver")
+	rec := &auditRecorder{}
+	startServer(t, ln, WithDriver(driver), WithAuditSink(rec), WithBlockPrivilegedPorts(true))
+
+	tc, err := rawLogin(ln.Addr().String(), "anonymous", "anonymous")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	commands := []string{
+		"PORT 127,0,0,1,0,25",
+		"EPRT |2|2001:db8::1|1025|",
+		"EPRT |1|192.0.2.1|1025|",
+		"PORT 127,0,0,1,4,1",
+	}
+	for _, cmd := range commands {
+		fmt.Fprintf(tc, "%s\r\n", cmd)
+		_, _, err := rawReadResponse(tc)
+		fatalIfErr(t, err, "%s failed", cmd)
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	want := []struct{ command, reason, target string }{
+		{"PORT", "privileged_port", "127.0.0.1:25"},
+		{"EPRT", "address_family", "[2001:db8::1]:1025"},
+		{"EPRT", "address_mismatch", "192.0.2.1:1025"},
+	}
+	if len(rec.events) != 1+len(want) { // Login, then one event per rejection
+		t.Fatalf("Got %d audit events, want %d: %+v", len(rec.events), 1+len(want), rec.events)
+	}
+	for i, w := range want {
+		e := rec.events[i+1]
+		if e.Type != AuditBounceRejected || e.Command != w.command || e.Reason != w.reason ||
+			len(e.Attrs) != 1 || e.Attrs[0].Value.String() != w.target {
+			t.Errorf("Event %d = %+v, want %s %s %s", i, e, w.command, w.reason, w.target)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+// scanningDriver wraps a driver so its contexts reject uploads containing
+// the word "VIRUS".
+type scanningDriver struct {
+	Driver
+}
+
+func (d *scanningDriver) Authenticate(user, pass, host string, remoteIP net.IP) (ClientContext, error) {
+	ctx, err := d.Driver.Authenticate(user, pass, host, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	return &scanningContext{ctx}, nil
+}
+
+type scanningContext struct {
+	ClientContext
+}
+
+func (c *scanningContext) OnUploadFinalize(tmpPath, finalPath string) error {
+	f, err := c.OpenFile(tmpPath, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(string(data), "VIRUS") {
+		return errors.New("malware detected")
+	}
+	return nil
+}
+
+// partFiles returns the temporary upload files left in dir.
+func partFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.part"))
+	fatalIfErr(t, err, "Glob failed")
+	return matches
+}
+
+func TestAtomicUploads(t *testing.T) {
+	t.Parallel()
+
+	t.Run("published on completion", func(t *testing.T) {
+		t.Parallel()
+		c, rootDir := setupWrappedServer(t, func(d Driver) Driver { return &scanningDriver{d} }, WithAtomicUploads(true))
+		finalPath := filepath.Join(rootDir, "data.txt")
+
+		pr, pw := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Store("data.txt", pr)
+		}()
+
+		_, err := pw.Write([]byte("first half, "))
+		fatalIfErr(t, err, "pipe write failed")
+
+		// Wait for the partial data to reach the temporary file
+		deadline := time.Now().Add(2 * time.Second)
+		for len(partFiles(t, rootDir)) == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(partFiles(t, rootDir)) != 1 {
+			t.Fatal("temporary upload file not found")
+		}
+		if _, err := os.Stat(finalPath); !os.IsNotExist(err) {
+			t.Errorf("final file visible during upload: %v", err)
+		}
+
+		_, err = pw.Write([]byte("second half"))
+		fatalIfErr(t, err, "pipe write failed")
+		pw.Close()
+		fatalIfErr(t, <-done, "Store failed")
+
+		data, err := os.ReadFile(finalPath)
+		fatalIfErr(t, err, "ReadFile failed")
+		if string(data) != "first half, second half" {
+			t.Errorf("content = %q", data)
+		}
+		if parts := partFiles(t, rootDir); len(parts) != 0 {
+			t.Errorf("temporary files left behind: %v", parts)
+		}
+	})
+
+	t.Run("rejected by finalizer", func(t *testing.T) {
+		t.Parallel()
+		c, rootDir := setupWrappedServer(t, func(d Driver) Driver { return &scanningDriver{d} }, WithAtomicUploads(true))
+		finalPath := filepath.Join(rootDir, "report.txt")
+		fatalIfErr(t, os.WriteFile(finalPath, []byte("original"), 0644), "WriteFile failed")
+
+		err := c.Store("report.txt", strings.NewReader("contains a VIRUS"))
+		var pe *ftp.ProtocolError
+		if !errors.As(err, &pe) || pe.Code != 451 {
+			t.Fatalf("Expected 451 error, got %v", err)
+		}
+
+		data, err := os.ReadFile(finalPath)
+		fatalIfErr(t, err, "ReadFile failed")
+		if string(data) != "original" {
+			t.Errorf("existing file modified: %q", data)
+		}
+		if parts := partFiles(t, rootDir); len(parts) != 0 {
+			t.Errorf("temporary files left behind: %v", parts)
+		}
+
+		// The session is still usable
+		fatalIfErr(t, c.Store("report.txt", strings.NewReader("clean")), "Store failed")
+		data, err = os.ReadFile(finalPath)
+		fatalIfErr(t, err, "ReadFile failed")
+		if string(data) != "clean" {
+			t.Errorf("content = %q, want %q", data, "clean")
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// AuditEventType identifies a security event reported to an AuditSink.
+type AuditEventType string
+
+const (
+	// AuditLoginSuccess is reported when a user logs in.
+	AuditLoginSuccess AuditEventType = "authentication_success"
+	// AuditLoginFailure is reported when a login is rejected. Reason holds
+	// the authenticator's error.
+	AuditLoginFailure AuditEventType = "authentication_failed"
+	// AuditPermissionDenied is reported when the driver refuses an operation
+	// with a permission error.
+	AuditPermissionDenied AuditEventType = "permission_denied"
+	// AuditPathTraversal is reported when a path argument climbs above the
+	// root with "..". The path is still clamped to the root by the driver.
+	AuditPathTraversal AuditEventType = "path_traversal"
+	// AuditBounceRejected is reported when a PORT or EPRT command targets an
+	// address or port not allowed by the active mode policy, as in FTP bounce
+	// attacks. Reason is "address_mismatch", "address_family" or
+	// "privileged_port", and the "target" attribute holds the address.
+	AuditBounceRejected AuditEventType = "bounce_rejected"
+	// AuditTLSDowngrade is reported when a client on a TLS control
+	// connection asks for clear-text data connections (PROT C) or a
+	// clear-text control connection (CCC), when a client asks for obsolete
+	// SSL with AUTH SSL (Reason "auth_ssl"), and when a client sends USER
+	// on a clear-text control connection while WithRequireTLS is set
+	// (Reason "clear_login").
+	AuditTLSDowngrade AuditEventType = "tls_downgrade"
+	// AuditTLSHandshakeFailed is reported when the TLS handshake of the
+	// control connection fails, for example because the client only
+	// supports versions or cipher suites below the server's minimum.
+	// Reason holds the handshake error.
+	AuditTLSHandshakeFailed AuditEventType = "tls_handshake_failed"
+	// AuditCommandDisabled is reported when a client sends a command
+	// disabled with WithDisableCommands.
+	AuditCommandDisabled AuditEventType = "command_disabled"
+	// AuditConnectionRejected is reported when a connection is refused
+	// because of a connection limit.
+	AuditConnectionRejected AuditEventType = "connection_rejected"
+	// AuditSessionAbuse is reported when a session is disconnected for
+	// flooding the server (see WithMaxCommandRate).
+	AuditSessionAbuse AuditEventType = "session_abuse"
+)
+
+// AuditEvent is a structured security event.
+//
+// Fields that do not apply to an event are left empty. RemoteIP and Path are
+// not redacted; sinks that forward events elsewhere should apply their own
+// privacy rules.
+type AuditEvent struct {
+	Type      AuditEventType
+	Time      time.Time
+	SessionID string // Empty for events outside a session
+	RemoteIP  string
+	User      string // User name sent with USER, if any
+	Command   string // FTP command that triggered the event
+	Path      string // Path argument of the command
+	Reason    string // Why the action was rejected
+	Attrs     []slog.Attr
+}
+
+// AuditSink receives security events. Implementations can forward them to
+// a SIEM, a file or a metrics system.
+//
+// Audit is called synchronously from the session goroutine, so it must not
+// block for long. It may be called concurrently from different sessions.
+type AuditSink interface {
+	Audit(event AuditEvent)
+}
+
+// AuditSinkFunc adapts a function to the AuditSink interface.
+type AuditSinkFunc func(event AuditEvent)
+
+// Audit calls f(event).
+func (f AuditSinkFunc) Audit(event AuditEvent) {
+	f(event)
+}
+
+// logAuditSink writes audit events to the server logger, honoring the
+// redaction options. It is the default sink.
+type logAuditSink struct {
+	server *Server
+}
+
+// Audit implements AuditSink.
+func (l logAuditSink) Audit(e AuditEvent) {
+	s := l.server
+	args := make([]any, 0, 16+2*len(e.Attrs))
+	if e.SessionID != "" {
+		args = append(args, "session_id", e.SessionID)
+	}
+	args = append(args, "remote_ip", s.redactIP(e.RemoteIP))
+	if e.User != "" {
+		args = append(args, "user", e.User)
+	}
+	if e.Command != "" {
+		args = append(args, "cmd", e.Command)
+	}
+	if e.Path != "" {
+		args = append(args, "path", s.redactPath(e.Path))
+	}
+	if e.Reason != "" {
+		args = append(args, "reason", e.Reason)
+	}
+	for _, attr := range e.Attrs {
+		args = append(args, attr)
+	}
+
+	if e.Type == AuditLoginSuccess {
+		s.logger.Info(string(e.Type), args...)
+	} else {
+		s.logger.Warn(string(e.Type), args...)
+	}
+}
+
+// audit reports a security event to the audit sink.
+func (s *Server) audit(e AuditEvent) {
+	if s.auditSink == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.auditSink.Audit(e)
+}
+
+// audit reports a security event for the session, filling in the session
+// details.
+func (s *session) audit(e AuditEvent) {
+	e.SessionID = s.sessionID
+	e.RemoteIP = s.remoteIP
+	s.mu.Lock()
+	e.User = s.user
+	s.mu.Unlock()
+	s.server.audit(e)
+}
+
+// escapesRoot reports whether arg, taken relative to the working directory
+// cwd, climbs above the root with "..".
+func escapesRoot(cwd, arg string) bool {
+	if !strings.Contains(arg, "..") {
+		return false
+	}
+
+	depth := 0
+	if !strings.HasPrefix(arg, "/") && !strings.HasPrefix(arg, "\\") {
+		for part := range strings.SplitSeq(cwd, "/") {
+			if part != "" && part != "." {
+				depth++
+			}
+		}
+	}
+
+	for part := range strings.FieldsFuncSeq(arg, func(r rune) bool { return r == '/' || r == '\\' }) {
+		switch part {
+		case ".":
+		case "..":
+			depth--
+			if depth < 0 {
+				return true
+			}
+		default:
+			depth++
+		}
+	}
+	return false
+}
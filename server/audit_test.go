@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// auditRecorder is an AuditSink collecting events for inspection.
+type auditRecorder struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (r *auditRecorder) Audit(e AuditEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// find returns the first event of type typ.
+func (r *auditRecorder) find(typ AuditEventType) (AuditEvent, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.events {
+		if e.Type == typ {
+			return e, true
+		}
+	}
+	return AuditEvent{}, false
+}
+
+func TestAuditEvents(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		if pass != "secret" {
+			return "", false, errors.New("invalid password")
+		}
+		return rootDir, user == "reader", nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	rec := &auditRecorder{}
+	startServer(t, ln,
+		WithDriver(driver),
+		WithAuditSink(rec),
+		WithDisableCommands("SITE"),
+	)
+	addr := ln.Addr().String()
+
+	if _, err := rawLogin(addr, "reader", "wrong"); err == nil {
+		t.Fatal("Login with a wrong password succeeded")
+	}
+
+	tc, err := rawLogin(addr, "reader", "secret")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	commands := []struct {
+		line string
+		code int
+	}{
+		{"SITE CHMOD 644 x", 502},
+		{"DELE file.txt", 550},
+		{"MKD ../../etc", 550},
+		{"PORT 10,1,2,3,4,5", 500},
+	}
+	for _, c := range commands {
+		fmt.Fprintf(tc, "%s\r\n", c.line)
+		code, msg, err := rawReadResponse(tc)
+		fatalIfErr(t, err, "Failed to read reply to "+c.line)
+		if code != c.code {
+			t.Errorf("%s: got %q, want %d", c.line, msg, c.code)
+		}
+	}
+
+	tests := []struct {
+		typ     AuditEventType
+		user    string
+		command string
+		path    string
+		reason  string
+	}{
+		{AuditLoginFailure, "reader", "", "", "invalid password"},
+		{AuditLoginSuccess, "reader", "", "", ""},
+		{AuditCommandDisabled, "reader", "SITE", "", ""},
+		{AuditPathTraversal, "reader", "MKD", "../../etc", ""},
+		{AuditPermissionDenied, "reader", "DELE", "file.txt", ""},
+		{AuditBounceRejected, "reader", "PORT", "", "address_mismatch"},
+	}
+	for _, tt := range tests {
+		e, ok := rec.find(tt.typ)
+		if !ok {
+			t.Errorf("No %s event", tt.typ)
+			continue
+		}
+		if e.SessionID == "" || e.RemoteIP != "127.0.0.1" || e.Time.IsZero() {
+			t.Errorf("%s: missing session details: %+v", tt.typ, e)
+		}
+		if e.User != tt.user || e.Command != tt.command || e.Path != tt.path {
+			t.Errorf("%s: got user=%q command=%q path=%q, want %q %q %q",
+				tt.typ, e.User, e.Command, e.Path, tt.user, tt.command, tt.path)
+		}
+		if tt.reason != "" && e.Reason != tt.reason {
+			t.Errorf("%s: got reason %q, want %q", tt.typ, e.Reason, tt.reason)
+		}
+	}
+
+	e, _ := rec.find(AuditBounceRejected)
+	if len(e.Attrs) != 1 || e.Attrs[0].Value.String() != "10.1.2.3:1029" {
+		t.Errorf("Bounce event attributes = %v", e.Attrs)
+	}
+}
+
+func TestAuditLogSink(t *testing.T) {
+	t.Parallel()
+
+	var logBuf safeBuffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return "", false, errors.New("invalid password")
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	startServer(t, ln, WithDriver(driver), WithLogger(logger), WithRedactIPs(true))
+
+	conn, err := net.DialTimeout("tcp", ln.Addr().String(), 5*time.Second)
+	fatalIfErr(t, err, "Failed to dial")
+	defer conn.Close()
+	tc := &textConn{Conn: conn, Reader: bufio.NewReader(conn)}
+	_, _, err = rawReadResponse(tc)
+	fatalIfErr(t, err, "Failed to read greeting")
+	fmt.Fprintf(conn, "USER john\r\n")
+	_, _, err = rawReadResponse(tc)
+	fatalIfErr(t, err, "Failed to read USER reply")
+	fmt.Fprintf(conn, "PASS nope\r\n")
+	_, _, err = rawReadResponse(tc)
+	fatalIfErr(t, err, "Failed to read PASS reply")
+
+	out := logBuf.String()
+	want := `level=WARN msg=authentication_failed session_id=`
+	if !strings.Contains(out, want) {
+		t.Fatalf("Log does not contain %q:\n%s", want, out)
+	}
+	if !strings.Contains(out, `remote_ip=127.0.0.xxx user=john reason="invalid password"`) {
+		t.Errorf("Log is missing the event fields:\n%s", out)
+	}
+}
+
+func TestEscapesRoot(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		cwd, arg string
+		want     bool
+	}{
+		{"/", "file.txt", false},
+		{"/", "..", true},
+		{"/", "../etc/passwd", true},
+		{"/", "/../etc", true},
+		{"/a/b", "../../c", false},
+		{"/a/b", "../../../c", true},
+		{"/a/b", "/a/../..", true},
+		{"/a", "x/../../y", false},
+		{"/a", `..\..\windows`, true},
+		{"/", "file..txt", false},
+		{"/", "./.", false},
+	}
+	for _, tt := range tests {
+		if got := escapesRoot(tt.cwd, tt.arg); got != tt.want {
+			t.Errorf("escapesRoot(%q, %q) = %v, want %v", tt.cwd, tt.arg, got, tt.want)
+		}
+	}
+}
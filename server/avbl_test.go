@@ -0,0 +1,49 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestAVBL(t *testing.T) {
+	t.Parallel()
+
+	t.Run("FSDriver", func(t *testing.T) {
+		t.Parallel()
+		c, _, teardown := setupTestServer(t, false)
+		defer teardown()
+
+		fatalIfErr(t, c.MakeDir("incoming"), "MakeDir failed")
+
+		for _, args := range [][]string{{"AVBL"}, {"AVBL", "incoming"}, {"SITE", "FREESPACE"}, {"SITE", "FREESPACE", "/incoming"}} {
+			resp, err := c.Quote(args[0], args[1:]...)
+			fatalIfErr(t, err, "%v failed", args)
+			if resp.Code != 213 {
+				t.Errorf("%v: expected 213, got %d %s", args, resp.Code, resp.Message)
+				continue
+			}
+			if n, err := strconv.ParseInt(resp.Message, 10, 64); err != nil || n <= 0 {
+				t.Errorf("%v: invalid free space %q", args, resp.Message)
+			}
+		}
+
+		resp, err := c.Quote("AVBL", "missing")
+		fatalIfErr(t, err, "AVBL failed")
+		if resp.Code != 550 {
+			t.Errorf("AVBL missing: expected 550, got %d %s", resp.Code, resp.Message)
+		}
+	})
+
+	t.Run("driver without SpaceReporter", func(t *testing.T) {
+		t.Parallel()
+		c, _ := setupWrappedServer(t, func(d Driver) Driver {
+			return &scanningDriver{d}
+		})
+
+		resp, err := c.Quote("AVBL")
+		fatalIfErr(t, err, "AVBL failed")
+		if resp.Code != 502 {
+			t.Errorf("Expected 502, got %d %s", resp.Code, resp.Message)
+		}
+	})
+}
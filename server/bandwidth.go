@@ -0,0 +1,110 @@
+package server
+
+import "github.com/gonzalop/ftp/internal/ratelimit"
+
+// SetUserBandwidth sets the bandwidth of a user in bytes per second (0 =
+// unlimited), overriding their class and the per-user limit of
+// WithBandwidthLimit, to throttle or boost an account without restarting the
+// server. It applies at once to the transfers of the user's sessions that
+// are rate limited, and from the next transfer to the others. The global
+// limit still applies.
+//
+// Example, throttling an account that saturates the link:
+//
+//	s.SetUserBandwidth("bob", 128*1024)
+//	// Later:
+//	s.ClearUserBandwidth("bob")
+func (s *Server) SetUserBandwidth(user string, bytesPerSecond int64) {
+	s.bandwidthMu.Lock()
+	if s.userBandwidth == nil {
+		s.userBandwidth = make(map[string]int64)
+	}
+	s.userBandwidth[user] = max(bytesPerSecond, 0)
+	s.bandwidthMu.Unlock()
+
+	s.updateBandwidth(func(sess *session) bool { return sess.user == user })
+}
+
+// ClearUserBandwidth removes the bandwidth set with SetUserBandwidth, so the
+// user gets the bandwidth of their class again.
+func (s *Server) ClearUserBandwidth(user string) {
+	s.bandwidthMu.Lock()
+	delete(s.userBandwidth, user)
+	s.bandwidthMu.Unlock()
+
+	s.updateBandwidth(func(sess *session) bool { return sess.user == user })
+}
+
+// SetBandwidthClass sets the per-user bandwidth of a class in bytes per
+// second (0 = unlimited), adding the class if needed. Like
+// SetUserBandwidth, it applies to the sessions already logged in.
+func (s *Server) SetBandwidthClass(class string, bytesPerSecond int64) {
+	s.bandwidthMu.Lock()
+	if s.bandwidthClasses == nil {
+		s.bandwidthClasses = make(map[string]int64)
+	}
+	s.bandwidthClasses[class] = max(bytesPerSecond, 0)
+	s.bandwidthMu.Unlock()
+
+	s.updateBandwidth(func(sess *session) bool { return sess.bandwidthClass == class })
+}
+
+// userRate returns the per-user bandwidth of a user of the given class: the
+// one set with SetUserBandwidth, else the one of the class, else the
+// per-user limit.
+func (s *Server) userRate(user, class string) int64 {
+	s.bandwidthMu.Lock()
+	defer s.bandwidthMu.Unlock()
+
+	if rate, ok := s.userBandwidth[user]; ok {
+		return rate
+	}
+	if rate, ok := s.bandwidthClasses[class]; ok && class != "" {
+		return rate
+	}
+	return s.bandwidthLimitPerUser
+}
+
+// updateBandwidth recomputes the bandwidth of the logged-in sessions
+// matching match.
+func (s *Server) updateBandwidth(match func(*session) bool) {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	for sess := range s.sessions {
+		sess.mu.Lock()
+		if sess.bandwidth != nil && match(sess) {
+			sess.bandwidth.SetRate(s.userRate(sess.user, sess.bandwidthClass))
+		}
+		sess.mu.Unlock()
+	}
+}
+
+// startBandwidth sets up the per-user bandwidth limiter of a session that
+// just logged in. The caller must hold s.mu.
+func (s *session) startBandwidth() {
+	if settings := s.fs.GetSettings(); settings != nil {
+		s.bandwidthClass = settings.BandwidthClass
+	} else {
+		s.bandwidthClass = ""
+	}
+	s.bandwidth = ratelimit.NewAdjustable(s.server.userRate(s.user, s.bandwidthClass))
+}
+
+// bandwidthLimited reports whether the session's transfers have a per-user
+// bandwidth limit.
+func (s *session) bandwidthLimited() bool {
+	if s.bandwidth != nil {
+		return s.bandwidth.Limited()
+	}
+	return s.server.bandwidthLimitPerUser > 0
+}
+
+// userLimiter returns the session's per-user limiter, or a new one with the
+// per-user limit for sessions that have none.
+func (s *session) userLimiter() *ratelimit.Limiter {
+	if s.bandwidth != nil {
+		return s.bandwidth
+	}
+	return ratelimit.New(s.server.bandwidthLimitPerUser)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+func TestBandwidthClasses(t *testing.T) {
+	t.Parallel()
+
+	rootDir := t.TempDir()
+	data := bytes.Repeat([]byte("x"), 40*1024)
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "big.bin"), data, 0644), "Failed to write file")
+
+	driver, err := NewFSDriver(rootDir,
+		WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+			return rootDir, false, nil
+		}),
+		WithUserSettings(func(user string) *Settings {
+			switch user {
+			case "gold":
+				return &Settings{BandwidthClass: "premium"}
+			case "bob":
+				return &Settings{BandwidthClass: "free"}
+			}
+			return nil
+		}),
+	)
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	srv := startServer(t, ln,
+		WithDriver(driver),
+		WithBandwidthLimit(0, 1<<20),
+		WithBandwidthClasses(map[string]int64{"premium": 0, "free": 8 * 1024}),
+	)
+
+	for _, tt := range []struct {
+		user, class string
+		want        int64
+	}{
+		{"gold", "premium", 0},
+		{"bob", "free", 8 * 1024},
+		{"alice", "", 1 << 20},
+		{"carol", "unknown", 1 << 20},
+	} {
+		if got := srv.userRate(tt.user, tt.class); got != tt.want {
+			t.Errorf("Rate of %s (%q) = %d, want %d", tt.user, tt.class, got, tt.want)
+		}
+	}
+
+	c, err := ftp.Dial(ln.Addr().String(), ftp.WithTimeout(5*time.Second))
+	fatalIfErr(t, err, "Failed to dial")
+	defer c.Quit()
+	fatalIfErr(t, c.Login("bob", "pass"), "Login failed")
+
+	bobLimited := func() bool {
+		srv.sessionsMu.RLock()
+		defer srv.sessionsMu.RUnlock()
+		for sess := range srv.sessions {
+			sess.mu.Lock()
+			limited := sess.user == "bob" && sess.bandwidth.Limited()
+			sess.mu.Unlock()
+			if limited {
+				return true
+			}
+		}
+		return false
+	}
+	if !bobLimited() {
+		t.Fatal("Session of a free user is not rate limited")
+	}
+
+	// At 8 KB/s, the download takes about 4 seconds, unless boosted
+	start := time.Now()
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		srv.SetUserBandwidth("bob", 0)
+	}()
+	fatalIfErr(t, c.Retrieve("big.bin", io.Discard), "Retrieve failed")
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Boosted download took %v", d)
+	}
+	if bobLimited() {
+		t.Error("Session still limited after SetUserBandwidth(0)")
+	}
+
+	// Back to the class, then change the class at runtime
+	srv.ClearUserBandwidth("bob")
+	if !bobLimited() {
+		t.Error("Session not limited after ClearUserBandwidth")
+	}
+	srv.SetBandwidthClass("free", 0)
+	if bobLimited() {
+		t.Error("Session still limited after SetBandwidthClass(0)")
+	}
+
+	if _, err := NewServer(":0", WithDriver(driver), WithBandwidthClasses(map[string]int64{"bad": -1})); err == nil {
+		t.Error("Negative class bandwidth accepted")
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import "crypto/tls"
+
+// BannerFunc returns the banner of a session, sent in the 220 reply on
+// connection and to HOST. The session's Host is the HOST value, or the SNI
+// name of implicit FTPS connections; it is empty for the banner of other
+// connections. Messages with several lines are sent as a multi-line reply.
+// An empty message keeps the static one.
+type BannerFunc func(info SessionInfo) string
+
+// LoginMessageFunc returns the message of the 230 reply to a successful
+// login, such as the time of the last login, quota usage or policy text.
+// driverCtx is the ClientContext (or ContextClientContext) the driver
+// returned for the user, so drivers can look up per-user data with their own
+// type. Messages with several lines are sent as a multi-line reply. An empty
+// message keeps the default one.
+type LoginMessageFunc func(info SessionInfo, driverCtx any) string
+
+// banner returns the banner of BannerFunc for the session, or "" if there
+// is none.
+func (s *session) banner() string {
+	if s.server.bannerFunc == nil {
+		return ""
+	}
+	info := s.info()
+	if info.Host == "" {
+		if tlsConn, ok := s.conn.(*tls.Conn); ok && tlsConn.Handshake() == nil {
+			info.Host = tlsConn.ConnectionState().ServerName
+		}
+	}
+	return s.server.bannerFunc(info)
+}
+
+// loginMessage returns the message of the 230 reply to a successful login.
+func (s *session) loginMessage() string {
+	if s.server.loginMessageFunc != nil {
+		if message := s.server.loginMessageFunc(s.info(), s.driverFS); message != "" {
+			return message
+		}
+	}
+	return "User logged in, proceed."
+}
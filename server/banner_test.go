@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/textproto"
+	"testing"
+)
+
+func TestBannerAndLoginMessage(t *testing.T) {
+	t.Parallel()
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return rootDir, false, nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	startServer(t, ln,
+		WithDriver(driver),
+		WithBannerFunc(func(info SessionInfo) string {
+			if info.Host != "" {
+				return "Welcome to " + info.Host
+			}
+			return "Hello " + info.RemoteIP + "\nAuthorized use only"
+		}),
+		WithLoginMessageFunc(func(info SessionInfo, driverCtx any) string {
+			return fmt.Sprintf("Hi %s\nDriver: %T\nLogged in.", info.User, driverCtx)
+		}),
+	)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	fatalIfErr(t, err, "Failed to dial")
+	tc := textproto.NewConn(conn)
+	defer tc.Close()
+
+	expect := func(cmd string, code int, want string) {
+		t.Helper()
+		if cmd != "" {
+			fatalIfErr(t, tc.PrintfLine("%s", cmd), "Failed to send command")
+		}
+		// ReadResponse joins the lines of multi-line replies with "\n"
+		_, msg, err := tc.ReadResponse(code)
+		if err != nil || msg != want {
+			t.Errorf("Reply to %q = %q, %v; want %d %q", cmd, msg, err, code, want)
+		}
+	}
+
+	expect("", 220, "Hello 127.0.0.1\nAuthorized use only")
+	expect("HOST ftp.example.com", 220, "Welcome to ftp.example.com")
+	expect("USER alice", 331, "User name okay, need password.")
+	expect("PASS secret", 230, "Hi alice\nDriver: *server.fsContext\nLogged in.")
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net"
+
+	"github.com/gonzalop/ftp/internal/blockmode"
+)
+
+// keptData is the data connection of a block mode transfer, kept for the
+// next transfer once it ends (see WithDataConnectionReuse).
+type keptData struct {
+	released chan struct{} // Closed when the transfer ends
+	conn     net.Conn      // The connection to reuse, or nil if it was closed
+}
+
+// blockConn returns conn as a block mode connection for one transfer,
+// kept for the next transfer when it ends.
+func (s *session) blockConn(conn net.Conn) net.Conn {
+	kd := &keptData{released: make(chan struct{})}
+	s.keptData = kd
+	return blockmode.NewConn(conn, func(reusable net.Conn) {
+		kd.conn = reusable
+		close(kd.released)
+	})
+}
+
+// reusableData returns the data connection kept from the last block mode
+// transfer, if any. The transfer may still be sending its final reply, so
+// this waits for it to end.
+func (s *session) reusableData() net.Conn {
+	kd := s.keptData
+	if kd == nil {
+		return nil
+	}
+	s.keptData = nil
+	<-kd.released
+	return kd.conn
+}
+
+// hasReusableData reports whether the last block mode transfer kept its
+// data connection for the next one.
+func (s *session) hasReusableData() bool {
+	kd := s.keptData
+	if kd == nil {
+		return false
+	}
+	<-kd.released
+	return kd.conn != nil
+}
+
+// closeKeptData closes the data connection kept from the last block mode
+// transfer, if any.
+func (s *session) closeKeptData() {
+	if conn := s.reusableData(); conn != nil {
+		conn.Close()
+	}
+}
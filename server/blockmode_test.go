@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeBlock writes a MODE B block to conn.
+func writeBlock(t *testing.T, conn net.Conn, desc byte, data string) {
+	t.Helper()
+	hdr := []byte{desc, 0, 0}
+	binary.BigEndian.PutUint16(hdr[1:], uint16(len(data)))
+	_, err := conn.Write(append(hdr, data...))
+	fatalIfErr(t, err, "Failed to write block")
+}
+
+// readFile reads MODE B blocks from conn up to the end of file block.
+func readFile(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var b strings.Builder
+	for {
+		hdr := make([]byte, 3)
+		_, err := io.ReadFull(conn, hdr)
+		fatalIfErr(t, err, "Failed to read block header")
+		data := make([]byte, binary.BigEndian.Uint16(hdr[1:]))
+		_, err = io.ReadFull(conn, data)
+		fatalIfErr(t, err, "Failed to read block data")
+		b.Write(data)
+		if hdr[0]&0x40 != 0 {
+			return b.String()
+		}
+	}
+}
+
+// expectReplies reads replies from tc, failing unless their codes are
+// want (125 and 150 are taken as the same).
+func expectReplies(t *testing.T, tc *textConn, cmd string, want ...int) {
+	t.Helper()
+	for _, w := range want {
+		code, msg, err := rawReadResponse(tc)
+		fatalIfErr(t, err, cmd+" failed")
+		if code != w && !(isPreliminary(code) && isPreliminary(w)) {
+			t.Fatalf("%s: got %q, want %d", cmd, msg, w)
+		}
+	}
+}
+
+func TestDataConnectionReuse(t *testing.T) {
+	t.Parallel()
+	rootDir := t.TempDir()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	driver, err := NewFSDriver(rootDir, WithAnonWrite(true))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	startServer(t, ln, WithDriver(driver), WithDataConnectionReuse(true))
+
+	tc, err := rawLogin(ln.Addr().String(), "anonymous", "anonymous")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	fmt.Fprintf(tc, "FEAT\r\n")
+	var feat strings.Builder
+	for {
+		line, err := tc.Reader.ReadString('\n')
+		fatalIfErr(t, err, "FEAT failed")
+		feat.WriteString(line)
+		if strings.HasPrefix(line, "211 ") {
+			break
+		}
+	}
+	if !strings.Contains(feat.String(), " DATA-REUSE\r\n") {
+		t.Errorf("FEAT does not list DATA-REUSE:\n%s", feat.String())
+	}
+
+	fmt.Fprintf(tc, "MODE B\r\n")
+	expectReplies(t, tc, "MODE B", 200)
+	addr, err := rawEnterPasv(tc)
+	fatalIfErr(t, err, "PASV failed")
+	data, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	fatalIfErr(t, err, "Failed to open data connection")
+	defer data.Close()
+
+	// Upload, with a restart marker to skip
+	fmt.Fprintf(tc, "STOR a.txt\r\n")
+	writeBlock(t, data, 0, "hel")
+	writeBlock(t, data, 0x10, "marker")
+	writeBlock(t, data, 0x40, "lo")
+	expectReplies(t, tc, "STOR", 125, 226)
+	if got, _ := os.ReadFile(filepath.Join(rootDir, "a.txt")); string(got) != "hello" {
+		t.Errorf("Uploaded %q, want %q", got, "hello")
+	}
+
+	// The next transfers use the same connection
+	fmt.Fprintf(tc, "RETR a.txt\r\n")
+	expectReplies(t, tc, "RETR", 125)
+	if got := readFile(t, data); got != "hello" {
+		t.Errorf("RETR = %q, want %q", got, "hello")
+	}
+	expectReplies(t, tc, "RETR", 226)
+
+	fmt.Fprintf(tc, "NLST\r\n")
+	expectReplies(t, tc, "NLST", 125)
+	if got := readFile(t, data); got != "a.txt\r\n" {
+		t.Errorf("NLST = %q, want %q", got, "a.txt\r\n")
+	}
+	expectReplies(t, tc, "NLST", 226)
+
+	// An upload cut short closes the connection for good
+	fmt.Fprintf(tc, "STOR b.txt\r\n")
+	writeBlock(t, data, 0, "partial")
+	data.Close()
+	expectReplies(t, tc, "STOR", 125, 426)
+	fmt.Fprintf(tc, "RETR a.txt\r\n")
+	expectReplies(t, tc, "RETR", 425)
+}
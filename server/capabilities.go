@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultHashAlgorithms are the HASH algorithms of drivers that do not
+// implement HashAlgorithmLister, in FEAT order.
+var defaultHashAlgorithms = []string{"SHA-1", "SHA-256", "SHA-512", "MD5", "CRC32"}
+
+// driverCaps holds the optional operations of a session's driver, detected
+// at login. A nil function means the driver does not support the operation.
+type driverCaps struct {
+	hash      func(ctx context.Context, path, algo string) (string, error)
+	hashAlgos []string // Algorithms of hash, upper case
+	setTime   func(ctx context.Context, path string, t time.Time) error
+	setCtime  func(ctx context.Context, path string, t time.Time) error
+	chmod     func(ctx context.Context, path string, mode os.FileMode) error
+	chown     func(ctx context.Context, path, owner, group string) error
+	openRange func(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error)
+	space     SpaceReporter
+}
+
+// detectCaps returns the optional operations implemented by fs, the value
+// returned by the driver.
+func detectCaps(fs any) driverCaps {
+	var caps driverCaps
+
+	switch h := fs.(type) {
+	case Hasher:
+		caps.hash = func(_ context.Context, path, algo string) (string, error) {
+			return h.GetHash(path, algo)
+		}
+	case ContextHasher:
+		caps.hash = h.GetHash
+	}
+	if caps.hash != nil {
+		caps.hashAlgos = defaultHashAlgorithms
+		if l, ok := fs.(HashAlgorithmLister); ok {
+			caps.hashAlgos = nil
+			for _, algo := range l.HashAlgorithms() {
+				caps.hashAlgos = append(caps.hashAlgos, strings.ToUpper(algo))
+			}
+		}
+	}
+
+	switch t := fs.(type) {
+	case TimeSetter:
+		caps.setTime = func(_ context.Context, path string, mtime time.Time) error {
+			return t.SetTime(path, mtime)
+		}
+	case ContextTimeSetter:
+		caps.setTime = t.SetTime
+	}
+
+	switch t := fs.(type) {
+	case CreationTimeSetter:
+		caps.setCtime = func(_ context.Context, path string, ctime time.Time) error {
+			return t.SetCreationTime(path, ctime)
+		}
+	case ContextCreationTimeSetter:
+		caps.setCtime = t.SetCreationTime
+	}
+
+	switch c := fs.(type) {
+	case Chmodder:
+		caps.chmod = func(_ context.Context, path string, mode os.FileMode) error {
+			return c.Chmod(path, mode)
+		}
+	case ContextChmodder:
+		caps.chmod = c.Chmod
+	}
+
+	switch c := fs.(type) {
+	case Chowner:
+		caps.chown = func(_ context.Context, path, owner, group string) error {
+			return c.Chown(path, owner, group)
+		}
+	case ContextChowner:
+		caps.chown = c.Chown
+	}
+
+	switch r := fs.(type) {
+	case Ranger:
+		caps.openRange = func(_ context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+			return r.OpenRange(path, offset, length)
+		}
+	case ContextRanger:
+		caps.openRange = r.OpenRange
+	}
+
+	caps.space, _ = fs.(SpaceReporter)
+	return caps
+}
@@ -0,0 +1,383 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+// minimalDriver returns contexts exposing only the required ClientContext
+// methods.
+type minimalDriver struct {
+	Driver
+	ranger bool
+	hashes []string // If set, contexts are Hashers listing these algorithms
+}
+
+func (d minimalDriver) Authenticate(user, pass, host string, remoteIP net.IP) (ClientContext, error) {
+	c, err := d.Driver.Authenticate(user, pass, host, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	if d.ranger {
+		return rangeContext{minimalContext{c}}, nil
+	}
+	if d.hashes != nil {
+		return hashListContext{minimalContext{c}, d.hashes}, nil
+	}
+	return minimalContext{c}, nil
+}
+
+type minimalContext struct {
+	ClientContext
+}
+
+// OpenFile hides io.Seeker, like object stores do.
+func (c minimalContext) OpenFile(path string, flag int) (io.ReadWriteCloser, error) {
+	f, err := c.ClientContext.OpenFile(path, flag)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ io.ReadWriteCloser }{f}, nil
+}
+
+// rangeContext adds a Ranger to minimalContext.
+type rangeContext struct {
+	minimalContext
+}
+
+func (c rangeContext) OpenRange(path string, offset, length int64) (io.ReadCloser, error) {
+	f, err := c.ClientContext.OpenFile(path, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.(io.Seeker).Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+// hashListContext adds a Hasher listing its algorithms to minimalContext.
+type hashListContext struct {
+	minimalContext
+	algos []string
+}
+
+func (c hashListContext) GetHash(path, algo string) (string, error) {
+	return c.ClientContext.(Hasher).GetHash(path, algo)
+}
+
+func (c hashListContext) HashAlgorithms() []string { return c.algos }
+
+func startMinimalServer(t *testing.T, ranger bool) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	rootDir := t.TempDir()
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "data.txt"), []byte("0123456789"), 0644), "Failed to write file")
+	driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return rootDir, false, nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	startServer(t, ln, WithDriver(minimalDriver{Driver: driver, ranger: ranger}))
+	return ln.Addr().String()
+}
+
+func TestMissingCapabilities(t *testing.T) {
+	t.Parallel()
+
+	c, err := ftp.Dial(startMinimalServer(t, false), ftp.WithTimeout(2*time.Second))
+	fatalIfErr(t, err, "Failed to dial")
+	defer c.Quit()
+
+	// Before login, the driver's capabilities are unknown
+	resp, err := c.Quote("FEAT")
+	fatalIfErr(t, err, "FEAT failed")
+	if !slices.Contains(resp.Lines, " MFMT") || !slices.Contains(resp.Lines, " MFF Modify;Create;UNIX.mode;UNIX.owner;UNIX.group;") {
+		t.Errorf("FEAT before login = %q, want MFMT and MFF", resp.Lines)
+	}
+
+	fatalIfErr(t, c.Login("test", "test"), "Login failed")
+	resp, err = c.Quote("FEAT")
+	fatalIfErr(t, err, "FEAT failed")
+	for _, line := range resp.Lines {
+		for _, feat := range []string{"HASH", "XCRC", "MFMT", "MFCT", "MFF", "AVBL", "RANG"} {
+			if strings.HasPrefix(strings.TrimSpace(line), feat) {
+				t.Errorf("FEAT lists %q without driver support", line)
+			}
+		}
+	}
+	if !slices.Contains(resp.Lines, " SIZE") {
+		t.Errorf("FEAT = %q, want SIZE", resp.Lines)
+	}
+
+	for _, cmd := range []string{
+		"HASH data.txt",
+		"XCRC data.txt",
+		"XMD5 data.txt 0 5",
+		"OPTS HASH MD5",
+		"MFMT 20240101000000 data.txt",
+		"MFCT 20240101000000 data.txt",
+		"SITE CHMOD 600 data.txt",
+		"AVBL",
+		"SITE FREESPACE",
+	} {
+		resp, _ := c.Quote(cmd)
+		if resp == nil || resp.Code != 502 {
+			t.Errorf("%s: got %v, want 502", cmd, resp)
+		}
+	}
+
+	if resp, _ := c.Quote("MFF Modify=20240101000000; data.txt"); resp == nil || resp.Code != 504 {
+		t.Errorf("MFF: got %v, want 504", resp)
+	}
+
+	resp, err = c.Quote("SITE HELP")
+	fatalIfErr(t, err, "SITE HELP failed")
+	if resp.Message != "Available SITE commands: HELP" {
+		t.Errorf("SITE HELP = %q", resp.Message)
+	}
+
+	// Without a Ranger, resumed downloads need a seekable file
+	var buf bytes.Buffer
+	if err := c.RetrieveFrom("data.txt", &buf, 4); err == nil {
+		t.Error("Resumed download of a file that cannot seek succeeded")
+	}
+}
+
+func TestRanger(t *testing.T) {
+	t.Parallel()
+
+	c, err := ftp.Dial(startMinimalServer(t, true), ftp.WithTimeout(2*time.Second))
+	fatalIfErr(t, err, "Failed to dial")
+	defer c.Quit()
+	fatalIfErr(t, c.Login("test", "test"), "Login failed")
+
+	resp, err := c.Quote("FEAT")
+	fatalIfErr(t, err, "FEAT failed")
+	if !slices.Contains(resp.Lines, " RANG STREAM") {
+		t.Errorf("FEAT = %q, want RANG STREAM", resp.Lines)
+	}
+
+	var buf bytes.Buffer
+	fatalIfErr(t, c.RetrieveFrom("data.txt", &buf, 4), "RetrieveFrom failed")
+	if buf.String() != "456789" {
+		t.Errorf("RetrieveFrom = %q, want %q", buf.String(), "456789")
+	}
+
+	buf.Reset()
+	fatalIfErr(t, c.RetrieveRange("data.txt", &buf, 2, 5), "RetrieveRange failed")
+	if buf.String() != "2345" {
+		t.Errorf("RetrieveRange = %q, want %q", buf.String(), "2345")
+	}
+}
+
+// contextTimes implements the context form of TimeSetter.
+type contextTimes struct {
+	ContextClientContext
+}
+
+func (contextTimes) SetTime(context.Context, string, time.Time) error { return nil }
+
+func TestHashAlgorithmLister(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	rootDir := t.TempDir()
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "data.txt"), []byte("0123456789"), 0644), "Failed to write file")
+	driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return rootDir, false, nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	startServer(t, ln, WithDriver(minimalDriver{Driver: driver, hashes: []string{"md5", "CRC32"}}))
+
+	c, err := ftp.Dial(ln.Addr().String(), ftp.WithTimeout(2*time.Second))
+	fatalIfErr(t, err, "Failed to dial")
+	defer c.Quit()
+	fatalIfErr(t, c.Login("test", "test"), "Login failed")
+
+	// SHA-256 is not offered, so the first algorithm is selected
+	tests := []struct {
+		cmd  string
+		code int
+		want string
+	}{
+		{"FEAT", 211, " HASH MD5*;CRC32"},
+		{"OPTS HASH", 200, "MD5"},
+		{"OPTS HASH SHA-256", 504, ""},
+		{"OPTS HASH crc32", 200, "CRC32"},
+		{"FEAT", 211, " HASH MD5;CRC32*"},
+		{"HASH data.txt", 213, "CRC32 a684c7c6 data.txt"},
+	}
+	for _, tt := range tests {
+		resp, err := c.Quote(tt.cmd)
+		fatalIfErr(t, err, tt.cmd+" failed")
+		if resp.Code != tt.code {
+			t.Errorf("%s = %d %q, want %d", tt.cmd, resp.Code, resp.Message, tt.code)
+		}
+		if tt.want != "" && resp.Message != tt.want && !slices.Contains(resp.Lines, tt.want) {
+			t.Errorf("%s = %q, want %q", tt.cmd, resp.Lines, tt.want)
+		}
+	}
+}
+
+// factsDriver returns FSDriver contexts that also record the creation times
+// and owners set, in sets.
+type factsDriver struct {
+	Driver
+	sets chan string
+}
+
+func (d factsDriver) Authenticate(user, pass, host string, remoteIP net.IP) (ClientContext, error) {
+	c, err := d.Driver.Authenticate(user, pass, host, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	return factsContext{c, d.sets}, nil
+}
+
+type factsContext struct {
+	ClientContext
+	sets chan string
+}
+
+func (c factsContext) SetCreationTime(path string, t time.Time) error {
+	if _, err := c.ClientContext.GetFileInfo(path); err != nil {
+		return err
+	}
+	c.sets <- "Create " + t.Format(time.DateTime) + " " + path
+	return nil
+}
+
+func (c factsContext) SetTime(path string, t time.Time) error {
+	return c.ClientContext.(TimeSetter).SetTime(path, t)
+}
+
+func (c factsContext) Chmod(path string, mode os.FileMode) error {
+	return c.ClientContext.(Chmodder).Chmod(path, mode)
+}
+
+func (c factsContext) Chown(path, owner, group string) error {
+	c.sets <- "Owner " + owner + ":" + group + " " + path
+	return nil
+}
+
+func TestModifyFacts(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	rootDir := t.TempDir()
+	path := filepath.Join(rootDir, "my data.txt")
+	fatalIfErr(t, os.WriteFile(path, []byte("0123456789"), 0644), "Failed to write file")
+	driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return rootDir, false, nil
+	}), WithSettings(&Settings{Privileges: PrivilegeChown}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	sets := make(chan string, 10)
+	startServer(t, ln, WithDriver(factsDriver{Driver: driver, sets: sets}))
+
+	c, err := ftp.Dial(ln.Addr().String(), ftp.WithTimeout(2*time.Second))
+	fatalIfErr(t, err, "Failed to dial")
+	defer c.Quit()
+	fatalIfErr(t, c.Login("test", "test"), "Login failed")
+
+	tests := []struct {
+		cmd  string
+		code int
+		want string
+	}{
+		{"FEAT", 211, " MFCT"},
+		{"FEAT", 211, " MFF Modify;Create;UNIX.mode;UNIX.owner;UNIX.group;"},
+		{"MFCT 20200102030405 my data.txt", 213, "Create=20200102030405; my data.txt"},
+		{"MFCT 20200102030405 missing.txt", 550, ""},
+		{"MFCT 2020 my data.txt", 501, ""},
+		{"MFF modify=20210101000000;UNIX.mode=600;UNIX.owner=1000; my data.txt", 213,
+			"Modify=20210101000000;UNIX.mode=600;UNIX.owner=1000; my data.txt"},
+		{"MFF Create=20220101000000;UNIX.group=100; my data.txt", 213, "Create=20220101000000;UNIX.group=100; my data.txt"},
+		{"MFF UNIX.acl=none; my data.txt", 504, ""},
+		{"MFF UNIX.mode=1777;Modify=20230101000000; my data.txt", 501, ""},
+		{"MFF Modify=20230101000000", 501, ""},
+	}
+	for _, tt := range tests {
+		resp, err := c.Quote(tt.cmd)
+		fatalIfErr(t, err, tt.cmd+" failed")
+		if resp.Code != tt.code {
+			t.Errorf("%s = %d %q, want %d", tt.cmd, resp.Code, resp.Message, tt.code)
+		}
+		if tt.want != "" && resp.Message != tt.want && !slices.Contains(resp.Lines, tt.want) {
+			t.Errorf("%s = %q, want %q", tt.cmd, resp.Lines, tt.want)
+		}
+	}
+
+	close(sets)
+	var got []string
+	for s := range sets {
+		got = append(got, s)
+	}
+	want := []string{
+		"Create 2020-01-02 03:04:05 my data.txt",
+		"Owner 1000: my data.txt",
+		"Create 2022-01-01 00:00:00 my data.txt",
+		"Owner :100 my data.txt",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Driver calls = %q, want %q", got, want)
+	}
+
+	// The rejected MFF changed nothing
+	info, err := os.Stat(path)
+	fatalIfErr(t, err, "Failed to stat file")
+	if !info.ModTime().Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)) || info.Mode().Perm() != 0600 {
+		t.Errorf("File mtime %v, mode %v; want 2021-01-01 and 0600", info.ModTime(), info.Mode().Perm())
+	}
+}
+
+func TestDetectCaps(t *testing.T) {
+	t.Parallel()
+
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return rootDir, false, nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	fs, err := driver.Authenticate("test", "test", "", nil)
+	fatalIfErr(t, err, "Failed to authenticate")
+	defer fs.Close()
+
+	caps := detectCaps(fs)
+	if caps.hash == nil || caps.setTime == nil || caps.chmod == nil || caps.space == nil {
+		t.Errorf("FSDriver capabilities not detected: %+v", caps)
+	}
+	if caps.chown == nil {
+		t.Error("FSDriver not detected as a Chowner")
+	}
+	if caps.openRange == nil {
+		t.Error("FSDriver not detected as a Ranger")
+	}
+	if caps.setCtime != nil {
+		t.Errorf("FSDriver detected as a CreationTimeSetter: %+v", caps)
+	}
+
+	caps = detectCaps(contextTimes{AdaptClientContext(fs)})
+	if caps.setTime == nil || caps.hash != nil {
+		t.Errorf("Context capabilities not detected: %+v", caps)
+	}
+}
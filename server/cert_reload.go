@@ -0,0 +1,178 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCertReloadInterval is how often certificate files are checked for
+// changes when no interval is given.
+const defaultCertReloadInterval = time.Minute
+
+// certReloader serves a certificate loaded from files, reloading it when the
+// files change. The files are checked at most once per interval, during a TLS
+// handshake. If the new files cannot be loaded (e.g. the key was replaced
+// but not yet the certificate), the previous certificate is kept and loading
+// is retried at the next check.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	stamp     string // Modification times and sizes of the loaded files
+	lastCheck time.Time
+}
+
+// newCertReloader loads the certificate and returns a reloader for it.
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	if interval < 0 {
+		return nil, fmt.Errorf("certificate reload interval cannot be negative")
+	}
+	if interval == 0 {
+		interval = defaultCertReloadInterval
+	}
+
+	r := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	r.cert, r.stamp, r.lastCheck = &cert, stamp, time.Now()
+	return r, nil
+}
+
+// fileStamp identifies the current version of the certificate files.
+func (r *certReloader) fileStamp() (string, error) {
+	var b strings.Builder
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%d/%d;", info.ModTime().UnixNano(), info.Size())
+	}
+	return b.String(), nil
+}
+
+// certificate returns the current certificate, reloading it first if the
+// interval has passed and the files have changed.
+func (r *certReloader) certificate() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) < r.interval {
+		return r.cert
+	}
+	r.lastCheck = time.Now()
+
+	stamp, err := r.fileStamp()
+	if err != nil || stamp == r.stamp {
+		if err != nil {
+			r.logger.Warn("tls_reload_failed", "cert_file", r.certFile, "error", err)
+		}
+		return r.cert
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		r.logger.Warn("tls_reload_failed", "cert_file", r.certFile, "error", err)
+		return r.cert
+	}
+	r.cert, r.stamp = &cert, stamp
+	r.logger.Info("tls_certificate_reloaded", "cert_file", r.certFile)
+	return r.cert
+}
+
+// certificateFor returns the certificate for a server name, from the SNI
+// extension or the HOST command. Names are matched exactly, then against
+// wildcard entries ("*.example.com"), then the default certificate is used:
+// the reloaded one, or the first one of the WithTLS configuration. It returns
+// nil if there is none.
+func (s *Server) certificateFor(name string) *tls.Certificate {
+	if r, ok := lookupHost(s.hostCerts, name); ok {
+		return r.certificate()
+	}
+	if s.certReloader != nil {
+		return s.certReloader.certificate()
+	}
+	return s.staticCert
+}
+
+// setupCertificates installs the certificate reloaders in the TLS
+// configuration. Called by NewServer once all options are applied.
+func (s *Server) setupCertificates() {
+	if s.certReloader == nil && len(s.hostCerts) == 0 {
+		return
+	}
+
+	for _, r := range s.hostCerts {
+		r.logger = s.logger
+	}
+	if s.certReloader != nil {
+		s.certReloader.logger = s.logger
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.tlsConfig != nil {
+		config = s.tlsConfig.Clone()
+	}
+
+	// crypto/tls only calls GetCertificate without SNI if Certificates is
+	// empty, so the static certificate is served from certificateFor too.
+	if s.certReloader == nil && len(config.Certificates) > 0 {
+		s.staticCert = &config.Certificates[0]
+	}
+	config.Certificates = nil
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return s.certificateFor(hello.ServerName), nil
+	}
+	s.tlsConfig = config
+}
+
+// TLSConfig returns the TLS configuration used for FTPS, including
+// certificates set with WithTLSCertificateReloader and WithTLSHostCertificate,
+// or nil if TLS is not configured. Use it to create the listener for implicit
+// FTPS:
+//
+//	ln, _ := tls.Listen("tcp", ":990", s.TLSConfig())
+//	s.Serve(ln)
+func (s *Server) TLSConfig() *tls.Config {
+	return s.tlsConfig
+}
+
+// sessionTLSConfig returns the TLS configuration for the session's control
+// and data connections. When host certificates are configured, clients that
+// do not send SNI get the certificate of the host selected with HOST
+// (RFC 7151 asks clients to send HOST before AUTH). The configuration is
+// created once per session, so data connections can resume the control
+// connection's TLS session.
+func (s *session) sessionTLSConfig() *tls.Config {
+	if len(s.server.hostCerts) == 0 {
+		return s.server.tlsConfig
+	}
+
+	s.tlsConfigOnce.Do(func() {
+		config := s.server.tlsConfig.Clone()
+		config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = s.host
+			}
+			return s.server.certificateFor(name), nil
+		}
+		s.tlsConfig = config
+	})
+	return s.tlsConfig
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// copyCert copies a certificate and key to dir/cert.pem and dir/key.pem,
+// with a modification time in the future so a reload sees a change.
+func copyCert(t *testing.T, dir, certFile, keyFile string) (string, string) {
+	t.Helper()
+	future := time.Now().Add(time.Hour)
+	var paths []string
+	for _, src := range []string{certFile, keyFile} {
+		data, err := os.ReadFile(src)
+		fatalIfErr(t, err, "ReadFile failed")
+		dst := filepath.Join(dir, filepath.Base(src))
+		fatalIfErr(t, os.WriteFile(dst, data, 0600), "WriteFile failed")
+		fatalIfErr(t, os.Chtimes(dst, future, future), "Chtimes failed")
+		paths = append(paths, dst)
+	}
+	return paths[0], paths[1]
+}
+
+// servedCert returns the certificate the server presents for a server name.
+func servedCert(t *testing.T, s *Server, name string) []byte {
+	t.Helper()
+	cert, err := s.TLSConfig().GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+	fatalIfErr(t, err, "GetCertificate failed")
+	if cert == nil {
+		t.Fatalf("no certificate for %q", name)
+	}
+	return cert.Certificate[0]
+}
+
+func TestTLSCertificateReloader(t *testing.T) {
+	t.Parallel()
+
+	certA, keyA, a, _ := generateCert(t, true, nil, nil)
+	certB, keyB, b, _ := generateCert(t, true, nil, nil)
+	dir := t.TempDir()
+	certFile, keyFile := copyCert(t, dir, certA, keyA)
+
+	driver, err := NewFSDriver(t.TempDir())
+	fatalIfErr(t, err, "Failed to create FS driver")
+	s, err := NewServer(":0", WithDriver(driver),
+		WithTLSCertificateReloader(certFile, keyFile, time.Millisecond))
+	fatalIfErr(t, err, "NewServer failed")
+
+	if !bytes.Equal(servedCert(t, s, ""), a.Raw) {
+		t.Fatal("initial certificate not served")
+	}
+
+	// Replace the files: the new certificate is picked up
+	copyCert(t, dir, certB, keyB)
+	time.Sleep(5 * time.Millisecond)
+	if !bytes.Equal(servedCert(t, s, ""), b.Raw) {
+		t.Fatal("certificate not reloaded")
+	}
+
+	// A broken key keeps the current certificate
+	fatalIfErr(t, os.WriteFile(keyFile, []byte("garbage"), 0600), "WriteFile failed")
+	time.Sleep(5 * time.Millisecond)
+	if !bytes.Equal(servedCert(t, s, ""), b.Raw) {
+		t.Fatal("certificate dropped after a failed reload")
+	}
+
+	if _, err := NewServer(":0", WithDriver(driver),
+		WithTLSCertificateReloader(filepath.Join(dir, "missing.pem"), keyFile, 0)); err == nil {
+		t.Error("expected an error for a missing certificate")
+	}
+}
+
+func TestTLSHostCertificate(t *testing.T) {
+	t.Parallel()
+
+	certDefault, keyDefault, def, _ := generateCert(t, true, nil, nil)
+	certHost, keyHost, host, _ := generateCert(t, true, nil, nil)
+	certWild, keyWild, wild, _ := generateCert(t, true, nil, nil)
+
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir)
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	defaultPair, err := tls.LoadX509KeyPair(certDefault, keyDefault)
+	fatalIfErr(t, err, "LoadX509KeyPair failed")
+	s, err := NewServer("127.0.0.1:0", WithDriver(driver),
+		WithTLS(&tls.Config{Certificates: []tls.Certificate{defaultPair}}),
+		WithTLSHostCertificate("ftp.example.com", certHost, keyHost, 0),
+		WithTLSHostCertificate("*.example.org", certWild, keyWild, 0),
+	)
+	fatalIfErr(t, err, "NewServer failed")
+
+	// Selection by SNI
+	tests := []struct {
+		name string
+		want []byte
+	}{
+		{"ftp.example.com", host.Raw},
+		{"FTP.Example.COM.", host.Raw},
+		{"files.example.org", wild.Raw},
+		{"example.org", def.Raw},
+		{"", def.Raw},
+	}
+	for _, tt := range tests {
+		if !bytes.Equal(servedCert(t, s, tt.name), tt.want) {
+			t.Errorf("wrong certificate for %q", tt.name)
+		}
+	}
+
+	// Selection by HOST, for clients that don't send SNI
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	go func() {
+		_ = s.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+
+	handshake := func(hostName string) []byte {
+		conn, err := net.DialTimeout("tcp", ln.Addr().String(), 5*time.Second)
+		fatalIfErr(t, err, "Dial failed")
+		defer conn.Close()
+		tc := &textConn{Conn: conn, Reader: bufio.NewReader(conn)}
+		_, _, err = rawReadResponse(tc)
+		fatalIfErr(t, err, "greeting failed")
+
+		if hostName != "" {
+			fmt.Fprintf(conn, "HOST %s\r\n", hostName)
+			if code, msg, err := rawReadResponse(tc); err != nil || code != 220 {
+				t.Fatalf("HOST: %d %q (%v)", code, msg, err)
+			}
+		}
+		fmt.Fprintf(conn, "AUTH TLS\r\n")
+		if code, msg, err := rawReadResponse(tc); err != nil || code != 234 {
+			t.Fatalf("AUTH TLS: %d %q (%v)", code, msg, err)
+		}
+
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		fatalIfErr(t, tlsConn.Handshake(), "Handshake failed")
+		return tlsConn.ConnectionState().PeerCertificates[0].Raw
+	}
+
+	if !bytes.Equal(handshake("ftp.example.com"), host.Raw) {
+		t.Error("HOST did not select the host certificate")
+	}
+	if !bytes.Equal(handshake(""), def.Raw) {
+		t.Error("default certificate not served without HOST")
+	}
+}
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
@@ -58,7 +61,10 @@ func TestClientExtensions(t *testing.T) {
 
 	t.Run("SetModTime", func(t *testing.T) { testSetModTime(t, c, rootDir) })
 	t.Run("Chmod", func(t *testing.T) { testChmod(t, c, rootDir) })
+	t.Run("SetEntryFacts", func(t *testing.T) { testSetEntryFacts(t, c, rootDir) })
 	t.Run("Hash", func(t *testing.T) { testHash(t, c, rootDir) })
+	t.Run("HashRange", func(t *testing.T) { testHashRange(t, c, rootDir) })
+	t.Run("LegacyHash", func(t *testing.T) { testLegacyHash(t, c, rootDir) })
 	t.Run("Quote", func(t *testing.T) { testQuote(t, c) })
 }
 
@@ -115,6 +121,43 @@ func testChmod(t *testing.T, c *ftp.Client, rootDir string) {
 	}
 }
 
+func testSetEntryFacts(t *testing.T, c *ftp.Client, rootDir string) {
+	filename := "test mff.txt"
+	path := filepath.Join(rootDir, filename)
+	if err := os.WriteFile(path, []byte("facts"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	caps, err := c.Capabilities()
+	if err != nil {
+		t.Fatalf("Capabilities failed: %v", err)
+	}
+	if !slices.Equal(caps.MFFFacts, []string{"Modify", "UNIX.mode"}) {
+		t.Errorf("MFFFacts = %q, want Modify and UNIX.mode", caps.MFFFacts)
+	}
+
+	newTime := time.Date(2022, 6, 1, 8, 30, 0, 0, time.UTC)
+	if err := c.SetEntryFacts(filename, ftp.EntryFacts{ModTime: newTime, Mode: 0600}); err != nil {
+		t.Fatalf("SetEntryFacts failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if !info.ModTime().Equal(newTime) || info.Mode().Perm() != 0600 {
+		t.Errorf("File mtime %v, mode %v; want %v and 0600", info.ModTime(), info.Mode().Perm(), newTime)
+	}
+
+	// Without PrivilegeChown, MFF does not list UNIX.owner, so SITE CHOWN is
+	// tried and rejected
+	if err := c.Chown(filename, "nobody"); err == nil {
+		t.Error("Chown succeeded without PrivilegeChown")
+	}
+	if err := c.SetEntryFacts(filename, ftp.EntryFacts{Owner: "nobody"}); err == nil {
+		t.Error("SetEntryFacts with UNIX.owner succeeded without PrivilegeChown")
+	}
+}
+
 func testHash(t *testing.T, c *ftp.Client, rootDir string) {
 	filename := "test_hash.txt"
 	path := filepath.Join(rootDir, filename)
@@ -127,14 +170,148 @@ func testHash(t *testing.T, c *ftp.Client, rootDir string) {
 		t.Fatalf("SetHashAlgo failed: %v", err)
 	}
 
-	hash, err := c.Hash(filename)
+	res, err := c.Hash(filename)
 	if err != nil {
 		t.Fatalf("Hash failed: %v", err)
 	}
 
-	expected := "43f932e4f7c6ecd136a695b7008694bb69d517bd"
-	if hash != expected {
-		t.Errorf("Hash mismatch. Expected %s, got %s", expected, hash)
+	expected := ftp.HashResult{Algo: "SHA-1", End: -1, Value: "43f932e4f7c6ecd136a695b7008694bb69d517bd", Path: filename}
+	if *res != expected {
+		t.Errorf("Hash mismatch. Expected %+v, got %+v", expected, *res)
+	}
+}
+
+func testHashRange(t *testing.T, c *ftp.Client, rootDir string) {
+	content := []byte("hash me please")
+	for _, name := range []string{"range.txt", "backup 1-2"} {
+		if err := os.WriteFile(filepath.Join(rootDir, name), content, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	sha := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+
+	tests := []struct {
+		cmd  string
+		code int
+		want string
+	}{
+		{"OPTS HASH SHA-256", 200, "SHA-256"},
+		{"OPTS HASH", 200, "SHA-256"},
+		{"OPTS HASH SHA-3", 504, ""},
+		{"HASH range.txt 0-4", 213, "SHA-256 0-4 " + sha(content[:5]) + " range.txt"},
+		{"HASH range.txt 5-", 213, "SHA-256 5-13 " + sha(content[5:]) + " range.txt"},
+		{"HASH range.txt 10-99", 213, "SHA-256 10-13 " + sha(content[10:]) + " range.txt"},
+		{"HASH range.txt 4-2", 501, ""},
+		{"HASH range.txt 14-", 501, ""},
+		{"HASH backup 1-2", 213, "SHA-256 " + sha(content) + " backup 1-2"}, // A name, not a range
+	}
+	for _, tt := range tests {
+		resp, err := c.Quote(tt.cmd)
+		if err != nil {
+			t.Fatalf("%s failed: %v", tt.cmd, err)
+		}
+		if resp.Code != tt.code || (tt.want != "" && resp.Message != tt.want) {
+			t.Errorf("%s = %d %q, want %d %q", tt.cmd, resp.Code, resp.Message, tt.code, tt.want)
+		}
+	}
+
+	resp, err := c.Quote("FEAT")
+	if err != nil {
+		t.Fatalf("FEAT failed: %v", err)
+	}
+	if !slices.Contains(resp.Lines, " HASH SHA-1;SHA-256*;SHA-512;MD5;CRC32") {
+		t.Errorf("FEAT = %q, want the HASH algorithms with SHA-256 selected", resp.Lines)
+	}
+
+	algos, err := c.HashAlgorithms()
+	if err != nil || !slices.Equal(algos, []string{"SHA-1", "SHA-256", "SHA-512", "MD5", "CRC32"}) {
+		t.Errorf("HashAlgorithms() = %q, %v", algos, err)
+	}
+
+	res, err := c.HashRange("backup 1-2", 2, 6)
+	want := ftp.HashResult{Algo: "SHA-256", Start: 2, End: 6, Value: sha(content[2:7]), Path: "backup 1-2"}
+	if err != nil || *res != want {
+		t.Errorf("HashRange(2, 6) = %+v, %v; want %+v", res, err, want)
+	}
+	res, err = c.HashRange("range.txt", 5, -1)
+	if err != nil || res.Start != 5 || res.End != 13 || res.Value != sha(content[5:]) {
+		t.Errorf("HashRange(5, -1) = %+v, %v", res, err)
+	}
+	if _, err := c.HashRange("range.txt", 14, -1); err == nil {
+		t.Error("HashRange past the end of the file succeeded")
+	}
+}
+
+func testLegacyHash(t *testing.T, c *ftp.Client, rootDir string) {
+	if err := os.WriteFile(filepath.Join(rootDir, "legacy hash.txt"), []byte("hash me"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	crc, err := c.XCRC("legacy hash.txt")
+	if err != nil || crc != "73BB822D" {
+		t.Errorf("XCRC = %q, %v; want 73BB822D", crc, err)
+	}
+	md5sum, err := c.XMD5("legacy hash.txt")
+	if err != nil || md5sum != "17b31dce96b9d6c6d0a6ba95f47796fb" {
+		t.Errorf("XMD5 = %q, %v", md5sum, err)
+	}
+	sha1sum, err := c.XSHA1("legacy hash.txt")
+	if err != nil || sha1sum != "43f932e4f7c6ecd136a695b7008694bb69d517bd" {
+		t.Errorf("XSHA1 = %q, %v", sha1sum, err)
+	}
+
+	tests := []struct {
+		args []string
+		code int
+		want string
+	}{
+		// SHA-256 of "hash me"
+		{[]string{"XSHA256", "legacy hash.txt"}, 250, "eb201af5aaf0d60629d3d2a61e466cfc0fedb517add831ecac5235e1daa963d6"},
+		// MD5 of "hash" (bytes 0-3)
+		{[]string{"XMD5", `"legacy hash.txt"`, "0", "4"}, 250, "0800fc577294c34e0b28ad2839435945"},
+		// MD5 of "me" (bytes 5 to the end)
+		{[]string{"XMD5", "legacy hash.txt", "5"}, 250, "ab86a1e1ef70dff97959067b723c5c24"},
+		{[]string{"XMD5", "legacy hash.txt", "4", "2"}, 501, ""},
+		{[]string{"XCRC", "missing.txt"}, 550, ""},
+	}
+	for _, tt := range tests {
+		resp, err := c.Quote(tt.args[0], tt.args[1:]...)
+		if err != nil {
+			t.Fatalf("%v failed: %v", tt.args, err)
+		}
+		if resp.Code != tt.code || (tt.want != "" && resp.Message != tt.want) {
+			t.Errorf("%v = %d %q, want %d %q", tt.args, resp.Code, resp.Message, tt.code, tt.want)
+		}
+	}
+}
+
+func TestParseChecksumArgs(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		arg        string
+		path       string
+		start, end int64
+		ok         bool
+	}{
+		{"file.txt", "file.txt", 0, -1, true},
+		{"file.txt 100", "file.txt", 100, -1, true},
+		{"my file.txt 100 200", "my file.txt", 100, 200, true},
+		{`"my file 1 2" 3 4`, "my file 1 2", 3, 4, true},
+		{"1234", "1234", 0, -1, true},
+		{"file.txt 200 100", "", 0, 0, false},
+		{`"unterminated 1 2`, "", 0, 0, false},
+		{`"file.txt" x`, "", 0, 0, false},
+		{"", "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		path, start, end, ok := parseChecksumArgs(tt.arg)
+		if ok != tt.ok || path != tt.path || start != tt.start || end != tt.end {
+			t.Errorf("parseChecksumArgs(%q) = %q, %d, %d, %v; want %q, %d, %d, %v",
+				tt.arg, path, start, end, ok, tt.path, tt.start, tt.end, tt.ok)
+		}
 	}
 }
 
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+// combiningDriver wraps a driver so its contexts implement FileCombiner and
+// record the calls.
+type combiningDriver struct {
+	Driver
+	calls chan []string
+}
+
+func (d *combiningDriver) Authenticate(user, pass, host string, remoteIP net.IP) (ClientContext, error) {
+	ctx, err := d.Driver.Authenticate(user, pass, host, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	return &combiningContext{ctx, d.calls}, nil
+}
+
+type combiningContext struct {
+	ClientContext
+	calls chan []string
+}
+
+func (c *combiningContext) CombineFiles(target string, parts []string) error {
+	c.calls <- append([]string{target}, parts...)
+	f, err := c.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte("native"))
+	f.Close()
+	return err
+}
+
+func setupWrappedServer(t testing.TB, wrap func(Driver) Driver, opts ...Option) (*ftp.Client, string) {
+	t.Helper()
+	fsDriver, rootDir := newTestFSDriver(t)
+
+	var driver Driver = fsDriver
+	if wrap != nil {
+		driver = wrap(driver)
+	}
+
+	return dialDriver(t, driver, opts...), rootDir
+}
+
+// newTestFSDriver returns an FSDriver for a new temporary directory, where
+// any user can log in, and the directory.
+func newTestFSDriver(t testing.TB, opts ...FSDriverOption) (*FSDriver, string) {
+	t.Helper()
+	// Resolved, as symbolic link targets are compared to it
+	rootDir, err := filepath.EvalSymlinks(t.TempDir())
+	fatalIfErr(t, err, "EvalSymlinks failed")
+
+	driver, err := NewFSDriver(rootDir, append([]FSDriverOption{
+		WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+			return rootDir, false, nil
+		}),
+	}, opts...)...)
+	fatalIfErr(t, err, "Failed to create FS driver")
+	return driver, rootDir
+}
+
+// serveDriver starts a server for driver and returns its address.
+func serveDriver(t testing.TB, driver Driver, opts ...Option) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+
+	s, err := NewServer(ln.Addr().String(), append([]Option{WithDriver(driver)}, opts...)...)
+	fatalIfErr(t, err, "Failed to create server")
+	go func() {
+		_ = s.Serve(ln)
+	}()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+
+	return ln.Addr().String()
+}
+
+// dialDriver starts a server for driver and returns a client logged in to it.
+func dialDriver(t testing.TB, driver Driver, opts ...Option) *ftp.Client {
+	t.Helper()
+	addr := serveDriver(t, driver, opts...)
+
+	c, err := ftp.Dial(addr, ftp.WithTimeout(2*time.Second))
+	fatalIfErr(t, err, "Failed to dial")
+	fatalIfErr(t, c.Login("test", "test"), "Login failed")
+	t.Cleanup(func() { _ = c.Quit() })
+
+	return c
+}
+
+// writeParts creates the named files in dir with the given contents.
+func writeParts(t *testing.T, dir string, parts map[string]string) {
+	t.Helper()
+	for name, content := range parts {
+		fatalIfErr(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644), "WriteFile failed")
+	}
+}
+
+func TestCOMB(t *testing.T) {
+	t.Parallel()
+
+	t.Run("combines and removes parts", func(t *testing.T) {
+		t.Parallel()
+		c, rootDir := setupWrappedServer(t, nil)
+		writeParts(t, rootDir, map[string]string{"big.1": "first ", "big.2": "second ", "big 3": "third"})
+
+		resp, err := c.Quote("COMB", "big.bin", "big.1", "big.2", `"big 3"`)
+		fatalIfErr(t, err, "COMB failed")
+		if resp.Code != 250 {
+			t.Fatalf("Expected 250, got %d %s", resp.Code, resp.Message)
+		}
+
+		data, err := os.ReadFile(filepath.Join(rootDir, "big.bin"))
+		fatalIfErr(t, err, "ReadFile failed")
+		if string(data) != "first second third" {
+			t.Errorf("content = %q", data)
+		}
+		for _, part := range []string{"big.1", "big.2", "big 3"} {
+			if _, err := os.Stat(filepath.Join(rootDir, part)); !os.IsNotExist(err) {
+				t.Errorf("part %s not removed: %v", part, err)
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		t.Parallel()
+		c, rootDir := setupWrappedServer(t, nil,
+			WithUploadPolicy(UploadPolicy{MaxFileSize: 10, BannedChars: "*"}))
+		writeParts(t, rootDir, map[string]string{"a": "12345", "b": "67890", "c": "x", "target": "original"})
+
+		tests := []struct {
+			args []string
+			code int
+		}{
+			{[]string{"target"}, 501},
+			{[]string{"target", "a", "target"}, 501},
+			{[]string{"target", `"a`}, 501},
+			{[]string{"target", "a", "missing"}, 550},
+			{[]string{"target", "a", "b", "c"}, 552},
+			{[]string{"bad*name", "a"}, 553},
+		}
+		for _, tt := range tests {
+			resp, err := c.Quote("COMB", tt.args...)
+			fatalIfErr(t, err, "COMB failed")
+			if resp.Code != tt.code {
+				t.Errorf("COMB %v: expected %d, got %d %s", tt.args, tt.code, resp.Code, resp.Message)
+			}
+		}
+
+		// Rejected commands leave the target and the parts untouched
+		data, err := os.ReadFile(filepath.Join(rootDir, "target"))
+		fatalIfErr(t, err, "ReadFile failed")
+		if string(data) != "original" {
+			t.Errorf("target modified: %q", data)
+		}
+		for _, part := range []string{"a", "b", "c"} {
+			if _, err := os.Stat(filepath.Join(rootDir, part)); err != nil {
+				t.Errorf("part %s removed: %v", part, err)
+			}
+		}
+	})
+
+	t.Run("atomic", func(t *testing.T) {
+		t.Parallel()
+		c, rootDir := setupWrappedServer(t, nil, WithAtomicUploads(true))
+		writeParts(t, rootDir, map[string]string{"p1": "abc", "p2": "def"})
+
+		resp, err := c.Quote("COMB", "out", "p1", "p2")
+		fatalIfErr(t, err, "COMB failed")
+		if resp.Code != 250 {
+			t.Fatalf("Expected 250, got %d %s", resp.Code, resp.Message)
+		}
+		data, err := os.ReadFile(filepath.Join(rootDir, "out"))
+		fatalIfErr(t, err, "ReadFile failed")
+		if string(data) != "abcdef" {
+			t.Errorf("content = %q", data)
+		}
+		if parts := partFiles(t, rootDir); len(parts) != 0 {
+			t.Errorf("temporary files left behind: %v", parts)
+		}
+	})
+
+	t.Run("driver combiner", func(t *testing.T) {
+		t.Parallel()
+		calls := make(chan []string, 1)
+		c, rootDir := setupWrappedServer(t, func(d Driver) Driver {
+			return &combiningDriver{d, calls}
+		})
+		writeParts(t, rootDir, map[string]string{"p1": "abc", "p2": "def"})
+
+		resp, err := c.Quote("COMB", "out", "p1", "p2")
+		fatalIfErr(t, err, "COMB failed")
+		if resp.Code != 250 {
+			t.Fatalf("Expected 250, got %d %s", resp.Code, resp.Message)
+		}
+		if call := <-calls; !slices.Equal(call, []string{"out", "p1", "p2"}) {
+			t.Errorf("CombineFiles called with %v", call)
+		}
+		data, err := os.ReadFile(filepath.Join(rootDir, "out"))
+		fatalIfErr(t, err, "ReadFile failed")
+		if string(data) != "native" {
+			t.Errorf("content = %q, want the driver's output", data)
+		}
+	})
+}
+
+func TestSplitQuotedArgs(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		arg  string
+		want []string
+		ok   bool
+	}{
+		{"a b  c", []string{"a", "b", "c"}, true},
+		{`"my file" part.1 "part 2"`, []string{"my file", "part.1", "part 2"}, true},
+		{"", nil, true},
+		{`"unterminated`, nil, false},
+		{`"a"b`, nil, false},
+		{`""`, nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := splitQuotedArgs(tt.arg)
+		if ok != tt.ok || strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("splitQuotedArgs(%q) = %q, %v; want %q, %v", tt.arg, got, ok, tt.want, tt.ok)
+		}
+	}
+}
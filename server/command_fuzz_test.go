@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func FuzzParseCommand(f *testing.F) {
+	f.Add("RETR file.txt\r\n")
+	f.Add("stor dir/with spaces.txt")
+	f.Add("NOOP")
+	f.Add(" leading space\r\n")
+	f.Add("\r\n")
+	f.Add("SITE CHMOD 755 file\n")
+	f.Add("STOR cr\r\x00name\r\n")
+
+	f.Fuzz(func(t *testing.T, line string) {
+		cmd, arg := parseCommand(line)
+		if strings.Contains(cmd, " ") {
+			t.Errorf("command %q contains a space", cmd)
+		}
+		if cmd != strings.ToUpper(cmd) {
+			t.Errorf("command %q is not upper case", cmd)
+		}
+		if strings.HasSuffix(arg, "\n") {
+			t.Errorf("argument %q ends with a line break", arg)
+		}
+	})
+}
+
+func FuzzCommandReader(f *testing.F) {
+	f.Add([]byte("USER anonymous\r\nPASS x\r\n"))
+	f.Add([]byte("\xff\xf4\xff\xf2ABOR\r\n"))       // Telnet IP and Synch before ABOR
+	f.Add([]byte("STOR \xff\xffname\r\n"))          // Escaped 0xFF
+	f.Add([]byte("\xff\xfb\x01\xff\xfd\x03NOOP\n")) // Option negotiation
+	f.Add([]byte("\xff"))
+	f.Add(bytes.Repeat([]byte("A"), MaxCommandLength+1))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tr := newTelnetReader(bytes.NewReader(data))
+		r := bufio.NewReader(tr)
+		s := &session{reader: r}
+
+		// Each command consumes input, so the loop ends
+		for range len(data) + 1 {
+			line, err := s.readCommand()
+			if len(line) > MaxCommandLength {
+				t.Fatalf("read a %d byte command line", len(line))
+			}
+			if err != nil {
+				return
+			}
+			if cmd, _ := parseCommand(line); strings.ContainsRune(cmd, '\xff') {
+				t.Fatalf("telnet command in %q", cmd)
+			}
+		}
+		t.Fatal("reader did not reach the end of the input")
+	})
+}
+
+func FuzzParsePORT(f *testing.F) {
+	f.Add("192,168,1,100,195,80")
+	f.Add("127,0,0,1,0,0")
+	f.Add("fe80::1,2,3,4,5,6") // Must not give an IPv6 address
+	f.Add("+1,2,3,4,5,6")
+	f.Add("1,2,3,4,256,1")
+
+	f.Fuzz(func(t *testing.T, arg string) {
+		ip, port, err := parsePORT(arg)
+		if err != nil {
+			return
+		}
+		if ip.To4() == nil {
+			t.Errorf("parsePORT(%q) = %v: not an IPv4 address", arg, ip)
+		}
+		if port < 1 || port > 65535 {
+			t.Errorf("parsePORT(%q): port %d", arg, port)
+		}
+	})
+}
+
+func FuzzParseEPRT(f *testing.F) {
+	f.Add("|1|132.235.1.2|6275|")
+	f.Add("|2|1080::8:800:200C:417A|5282|")
+	f.Add("!1!10.0.0.1!21!")
+	f.Add("|1|::ffff:10.0.0.1|21|")
+	f.Add("|1|10.0.0.1|21|extra")
+	f.Add("\x001\x0010.0.0.1\x0021\x00")
+
+	f.Fuzz(func(t *testing.T, arg string) {
+		ip, port, err := parseEPRT(arg)
+		if err != nil {
+			return
+		}
+		if port < 1 || port > 65535 {
+			t.Errorf("parseEPRT(%q): port %d", arg, port)
+		}
+		if proto := arg[1]; proto == '1' && ip.To4() == nil {
+			t.Errorf("parseEPRT(%q) = %v: not an IPv4 address", arg, ip)
+		}
+	})
+}
@@ -3,10 +3,15 @@ package server
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -74,6 +79,111 @@ func TestAdminCommands(t *testing.T) {
 	}
 }
 
+func TestPrivilegedSiteCommands(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("chown is not supported on Windows")
+	}
+
+	rootDir := t.TempDir()
+	path := filepath.Join(rootDir, "my file.txt")
+	fatalIfErr(t, os.WriteFile(path, []byte("data"), 0644), "Failed to write file")
+	driver, err := NewFSDriver(rootDir,
+		WithUserProfiles(func(user, pass, host string, _ net.IP) (*UserProfile, error) {
+			p := &UserProfile{HomeDir: rootDir}
+			if user == "admin" {
+				p.Privileges = PrivilegeChown | PrivilegeUtime
+			}
+			return p, nil
+		}),
+	)
+	fatalIfErr(t, err, "Failed to create FS driver")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	startServer(t, ln, WithDriver(driver))
+
+	login := func(user string) *ftp.Client {
+		c, err := ftp.Dial(ln.Addr().String(), ftp.WithTimeout(2*time.Second))
+		fatalIfErr(t, err, "Failed to dial")
+		t.Cleanup(func() { _ = c.Quit() })
+		fatalIfErr(t, c.Login(user, "secret"), "Login failed")
+		return c
+	}
+
+	// Changing to the user's own IDs works without root
+	uid, gid := strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())
+	tests := []struct {
+		user string
+		cmd  string
+		code int
+	}{
+		{"admin", "SITE CHOWN " + uid + " my file.txt", 200},
+		{"admin", "SITE CHOWN " + uid + ":" + gid + " my file.txt", 200},
+		{"admin", "SITE CHGRP " + gid + " my file.txt", 200},
+		{"admin", "SITE CHOWN no-such-user-xyz my file.txt", 550},
+		{"admin", "SITE CHGRP " + gid + " missing.txt", 550},
+		{"admin", "SITE CHOWN " + uid, 501},
+		{"admin", "MFF UNIX.owner=" + uid + ";UNIX.group=" + gid + "; my file.txt", 213},
+		{"admin", "SITE UTIME 202001020304 my file.txt", 200},
+		{"admin", "SITE UTIME my file.txt 20210101000000 20210102030405 20210101000000 UTC", 200},
+		{"admin", "SITE UTIME 2021 my file.txt", 501},
+		{"user", "SITE CHOWN " + uid + " my file.txt", 550},
+		{"user", "SITE CHGRP " + gid + " my file.txt", 550},
+		{"user", "SITE UTIME 202001020304 my file.txt", 550},
+		{"user", "MFF UNIX.owner=" + uid + "; my file.txt", 550},
+	}
+	clients := map[string]*ftp.Client{"admin": login("admin"), "user": login("user")}
+	for _, tt := range tests {
+		resp, err := clients[tt.user].Quote(tt.cmd)
+		if err != nil {
+			t.Fatalf("%s: %s failed: %v", tt.user, tt.cmd, err)
+		}
+		if resp.Code != tt.code {
+			t.Errorf("%s: %s = %d %q, want %d", tt.user, tt.cmd, resp.Code, resp.Message, tt.code)
+		}
+	}
+
+	info, err := os.Stat(path)
+	fatalIfErr(t, err, "Failed to stat file")
+	if want := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC); !info.ModTime().Equal(want) {
+		t.Errorf("mtime = %v, want %v", info.ModTime(), want)
+	}
+
+	for user, want := range map[string]string{
+		"admin": "Available SITE commands: HELP, CHMOD, CHOWN, CHGRP, UTIME, FREESPACE",
+		"user":  "Available SITE commands: HELP, CHMOD, FREESPACE",
+	} {
+		resp, err := clients[user].Quote("SITE HELP")
+		fatalIfErr(t, err, "SITE HELP failed")
+		if resp.Message != want {
+			t.Errorf("%s: SITE HELP = %q, want %q", user, resp.Message, want)
+		}
+	}
+}
+
+func TestParseSiteUtime(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		arg  string
+		path string
+		want time.Time
+		ok   bool
+	}{
+		{"202001020304 a.txt", "a.txt", time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC), true},
+		{"20200102030405 my file.txt", "my file.txt", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), true},
+		{"my file.txt 20200101000000 20200102030405 20200101000000 UTC", "my file.txt", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), true},
+		{"20200102030405 UTC", "UTC", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), true},
+		{"a.txt", "", time.Time{}, false},
+		{"20200102030405", "", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		path, mtime, ok := parseSiteUtime(tt.arg)
+		if path != tt.path || !mtime.Equal(tt.want) || ok != tt.ok {
+			t.Errorf("parseSiteUtime(%q) = %q, %v, %v; want %q, %v, %v", tt.arg, path, mtime, ok, tt.path, tt.want, tt.ok)
+		}
+	}
+}
+
 func TestReadOnlyCommands(t *testing.T) {
 	t.Parallel()
 	c, _, teardown := setupTestServer(t, true)
@@ -227,3 +337,401 @@ func setupTestServer(t *testing.T, readOnly bool) (*ftp.Client, string, func())
 
 	return c, rootDir, teardown
 }
+
+func TestRESTSemantics(t *testing.T) {
+	t.Parallel()
+	c, rootDir, teardown := setupTestServer(t, false)
+	defer teardown()
+
+	const content = "abcdefghij"
+	filename := "rest.txt"
+	filePath := filepath.Join(rootDir, filename)
+	reset := func() {
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	retrieve := func() string {
+		var buf bytes.Buffer
+		if err := c.Retrieve(filename, &buf); err != nil {
+			t.Fatalf("Retrieve failed: %v", err)
+		}
+		return buf.String()
+	}
+
+	t.Run("APPE with REST", func(t *testing.T) {
+		reset()
+		if err := c.RestartAt(3); err != nil {
+			t.Fatalf("RestartAt failed: %v", err)
+		}
+		if err := c.Append(filename, strings.NewReader("XYZ")); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		got, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "abcXYZghij" {
+			t.Errorf("Expected %q, got %q", "abcXYZghij", got)
+		}
+	})
+
+	t.Run("APPE without REST", func(t *testing.T) {
+		reset()
+		if err := c.Append(filename, strings.NewReader("XYZ")); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		got, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content+"XYZ" {
+			t.Errorf("Expected %q, got %q", content+"XYZ", got)
+		}
+	})
+
+	t.Run("cleared by intervening command", func(t *testing.T) {
+		reset()
+		if err := c.RestartAt(3); err != nil {
+			t.Fatalf("RestartAt failed: %v", err)
+		}
+		if _, err := c.CurrentDir(); err != nil {
+			t.Fatalf("CurrentDir failed: %v", err)
+		}
+		if got := retrieve(); got != content {
+			t.Errorf("Expected full content %q, got %q", content, got)
+		}
+	})
+
+	t.Run("cleared after failed transfer", func(t *testing.T) {
+		reset()
+		if err := c.RestartAt(3); err != nil {
+			t.Fatalf("RestartAt failed: %v", err)
+		}
+		if err := c.Retrieve("missing.txt", io.Discard); err == nil {
+			t.Fatal("Retrieve of missing file succeeded")
+		}
+		if got := retrieve(); got != content {
+			t.Errorf("Expected full content %q, got %q", content, got)
+		}
+	})
+
+	t.Run("applies to next transfer only", func(t *testing.T) {
+		reset()
+		if err := c.RestartAt(3); err != nil {
+			t.Fatalf("RestartAt failed: %v", err)
+		}
+		if got := retrieve(); got != content[3:] {
+			t.Errorf("Expected %q, got %q", content[3:], got)
+		}
+		if got := retrieve(); got != content {
+			t.Errorf("Expected full content %q, got %q", content, got)
+		}
+	})
+
+	t.Run("negative offset", func(t *testing.T) {
+		resp, err := c.Quote("REST", "-1")
+		if err != nil {
+			t.Fatalf("Quote failed: %v", err)
+		}
+		if resp.Code != 501 {
+			t.Errorf("Expected 501 for negative offset, got %d", resp.Code)
+		}
+	})
+
+	t.Run("FEAT advertises REST STREAM", func(t *testing.T) {
+		feats, err := c.Features()
+		if err != nil {
+			t.Fatalf("Features failed: %v", err)
+		}
+		if feats["REST"] != "STREAM" {
+			t.Errorf("Expected REST STREAM in FEAT, got %q", feats["REST"])
+		}
+	})
+}
+
+func TestRANG(t *testing.T) {
+	t.Parallel()
+	c, rootDir, teardown := setupTestServer(t, false)
+	defer teardown()
+
+	filename := "rang.txt"
+	if err := os.WriteFile(filepath.Join(rootDir, filename), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	codes := []struct {
+		arg  string
+		want int
+	}{
+		{"2 5", 350},
+		{"1 0", 350}, // reset
+		{"5 2", 501},
+		{"-1 2", 501},
+		{"5", 501},
+		{"a b", 501},
+	}
+	for _, tc := range codes {
+		resp, err := c.Quote("RANG", tc.arg)
+		if err != nil {
+			t.Fatalf("RANG %s failed: %v", tc.arg, err)
+		}
+		if resp.Code != tc.want {
+			t.Errorf("RANG %s: expected %d, got %d", tc.arg, tc.want, resp.Code)
+		}
+	}
+
+	// After "RANG 1 0" the range is reset and the whole file is sent
+	if _, err := c.Quote("RANG", "2 5"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Quote("RANG", "1 0"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := c.Retrieve(filename, &buf); err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	if buf.String() != "0123456789" {
+		t.Errorf("Expected full content after reset, got %q", buf.String())
+	}
+
+	// Ranges are inclusive
+	buf.Reset()
+	if err := c.RetrieveRange(filename, &buf, 2, 5); err != nil {
+		t.Fatalf("RetrieveRange failed: %v", err)
+	}
+	if buf.String() != "2345" {
+		t.Errorf("Expected %q, got %q", "2345", buf.String())
+	}
+
+	// Uploads with a byte range are rejected
+	if _, err := c.Quote("RANG", "0 3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Store(filename, strings.NewReader("data")); err == nil {
+		t.Error("Expected STOR with a byte range to fail")
+	}
+}
+
+func TestLISTFlags(t *testing.T) {
+	t.Parallel()
+	c, rootDir, teardown := setupTestServer(t, true)
+	defer teardown()
+
+	now := time.Now()
+	files := []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"b-small.txt", 10000, 3 * time.Hour},
+		{"a-big.txt", 30000, 2 * time.Hour},
+		{"c-mid.txt", 20000, time.Hour},
+	}
+	for _, f := range files {
+		path := filepath.Join(rootDir, f.name)
+		fatalIfErr(t, os.WriteFile(path, bytes.Repeat([]byte("x"), f.size), 0644), "WriteFile failed")
+		mtime := now.Add(-f.age)
+		fatalIfErr(t, os.Chtimes(path, mtime, mtime), "Chtimes failed")
+	}
+	// Files are larger than any directory entry, so -S puts "my dir" last
+	fatalIfErr(t, os.Mkdir(filepath.Join(rootDir, "my dir"), 0755), "Mkdir failed")
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "my dir", "inner.txt"), nil, 0644), "WriteFile failed")
+	mtime := now.Add(-4 * time.Hour)
+	fatalIfErr(t, os.Chtimes(filepath.Join(rootDir, "my dir"), mtime, mtime), "Chtimes failed")
+
+	names := func(arg string) []string {
+		t.Helper()
+		entries, err := c.List(arg)
+		if err != nil {
+			t.Fatalf("LIST %s failed: %v", arg, err)
+		}
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.Name)
+		}
+		return out
+	}
+
+	tests := []struct {
+		arg  string
+		want []string
+	}{
+		{"-t", []string{"c-mid.txt", "a-big.txt", "b-small.txt", "my dir"}},
+		{"-S", []string{"a-big.txt", "c-mid.txt", "b-small.txt", "my dir"}},
+		{"-lrS", []string{"my dir", "b-small.txt", "c-mid.txt", "a-big.txt"}},
+		{"-r", []string{"my dir", "c-mid.txt", "b-small.txt", "a-big.txt"}},
+		{"-la -r", []string{".", "..", "my dir", "c-mid.txt", "b-small.txt", "a-big.txt"}},
+		{"-l my dir", []string{"inner.txt"}},
+	}
+	for _, tt := range tests {
+		if got := names(tt.arg); !slices.Equal(got, tt.want) {
+			t.Errorf("LIST %s = %v, want %v", tt.arg, got, tt.want)
+		}
+	}
+
+	// Unsorted listings are streamed in directory order; check the contents
+	got := names("-l")
+	slices.Sort(got)
+	if want := []string{"a-big.txt", "b-small.txt", "c-mid.txt", "my dir"}; !slices.Equal(got, want) {
+		t.Errorf("LIST -l = %v, want %v", got, want)
+	}
+}
+
+func TestLISTFileAndWildcards(t *testing.T) {
+	t.Parallel()
+	c, rootDir, teardown := setupTestServer(t, true)
+	defer teardown()
+
+	for name, size := range map[string]int{
+		"b.txt": 100, "a.txt": 200, "c.log": 300, ".hidden.txt": 1, "sub/d.txt": 1, "sub/e.txt": 1, "[x].txt": 1,
+	} {
+		path := filepath.Join(rootDir, filepath.FromSlash(name))
+		fatalIfErr(t, os.MkdirAll(filepath.Dir(path), 0755), "MkdirAll failed")
+		fatalIfErr(t, os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644), "WriteFile failed")
+	}
+
+	listNames := func(arg string) []string {
+		t.Helper()
+		entries, err := c.List(arg)
+		if err != nil {
+			t.Fatalf("LIST %s failed: %v", arg, err)
+		}
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.Name)
+		}
+		return out
+	}
+
+	tests := []struct {
+		arg  string
+		want []string
+	}{
+		{"a.txt", []string{"a.txt"}},
+		{"sub/d.txt", []string{"sub/d.txt"}},
+		{"*.txt", []string{"[x].txt", "a.txt", "b.txt"}},
+		{"-S *.txt", []string{"a.txt", "b.txt", "[x].txt"}},
+		{"?.*", []string{"a.txt", "b.txt", "c.log"}},
+		{"sub/*", []string{"sub/d.txt", "sub/e.txt"}},
+		{".*.txt", []string{".hidden.txt"}},
+		{"[x].txt", []string{"[x].txt"}}, // An existing name is not a pattern
+		{"*.none", nil},
+	}
+	for _, tt := range tests {
+		if got := listNames(tt.arg); !slices.Equal(got, tt.want) {
+			t.Errorf("LIST %s = %v, want %v", tt.arg, got, tt.want)
+		}
+		if strings.HasPrefix(tt.arg, "-") {
+			continue
+		}
+		got, err := c.NameList(tt.arg)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("NLST %s = %v, %v, want %v", tt.arg, got, err, tt.want)
+		}
+	}
+
+	if _, err := c.NameList("missing.txt"); err == nil {
+		t.Error("NLST of a missing file succeeded")
+	}
+	if _, err := c.NameList("a[.txt"); err == nil {
+		t.Error("NLST of a malformed pattern succeeded")
+	}
+}
+
+func TestMLSDAndNLSTStreaming(t *testing.T) {
+	t.Parallel()
+	c, rootDir, teardown := setupTestServer(t, true)
+	defer teardown()
+
+	const numFiles = 1000
+	for i := range numFiles {
+		name := filepath.Join(rootDir, fmt.Sprintf("file%04d", i))
+		fatalIfErr(t, os.WriteFile(name, nil, 0644), "WriteFile failed")
+	}
+
+	entries, err := c.MLList("")
+	if err != nil {
+		t.Fatalf("MLList failed: %v", err)
+	}
+	if len(entries) != numFiles {
+		t.Errorf("MLSD returned %d entries, want %d", len(entries), numFiles)
+	}
+
+	names, err := c.NameList("")
+	if err != nil {
+		t.Fatalf("NameList failed: %v", err)
+	}
+	if len(names) != numFiles {
+		t.Errorf("NLST returned %d names, want %d", len(names), numFiles)
+	}
+
+	// Errors are still reported before the data connection is used
+	for _, list := range []func() error{
+		func() error { _, err := c.MLList("missing"); return err },
+		func() error { _, err := c.NameList("missing"); return err },
+	} {
+		var pe *ftp.ProtocolError
+		if err := list(); !errors.As(err, &pe) || pe.Code != 550 {
+			t.Errorf("Expected 550 for missing directory, got %v", err)
+		}
+	}
+
+	// The connection is still usable
+	if err := c.Noop(); err != nil {
+		t.Errorf("NOOP after listings failed: %v", err)
+	}
+}
+
+// TestPathnameQuoting checks that 257 replies to MKD and PWD give the
+// absolute pathname with embedded quotes doubled (RFC 959 Appendix II).
+func TestPathnameQuoting(t *testing.T) {
+	t.Parallel()
+	driver, _ := newTestFSDriver(t)
+	addr := serveDriver(t, driver)
+	tc, err := rawLogin(addr, "user", "pass")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	commands := []struct{ line, want string }{
+		{`MKD say "hi"`, `257 "/say ""hi""" created.`},
+		{`CWD say "hi"`, `250`},
+		{`PWD`, `257 "/say ""hi""" is the current directory.`},
+		{`MKD sub`, `257 "/say ""hi""/sub" created.`},
+		{`MKD /abs/../top`, `257 "/top" created.`},
+	}
+	for _, c := range commands {
+		fmt.Fprintf(tc, "%s\r\n", c.line)
+		_, msg, err := rawReadResponse(tc)
+		fatalIfErr(t, err, "Failed to read reply to "+c.line)
+		if !strings.HasPrefix(strings.TrimSpace(msg), c.want) {
+			t.Errorf("%s: got %q, want %q", c.line, strings.TrimSpace(msg), c.want)
+		}
+	}
+}
+
+func TestRecursiveMKD(t *testing.T) {
+	t.Parallel()
+
+	for _, recursive := range []bool{false, true} {
+		driver, _ := newTestFSDriver(t)
+		addr := serveDriver(t, driver, WithRecursiveMKD(recursive))
+		tc, err := rawLogin(addr, "user", "pass")
+		fatalIfErr(t, err, "Login failed")
+		defer tc.Close()
+
+		want := 550
+		if recursive {
+			want = 257
+		}
+		for _, line := range []string{"MKD a/b/c", "MKD /a/b/d"} {
+			fmt.Fprintf(tc, "%s\r\n", line)
+			code, msg, err := rawReadResponse(tc)
+			fatalIfErr(t, err, "Failed to read MKD reply")
+			if code != want {
+				t.Errorf("%s with recursive=%v: got %q, want %d", line, recursive, msg, want)
+			}
+		}
+	}
+}
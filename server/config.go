@@ -0,0 +1,319 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Config holds server settings in a form that can be loaded from a
+// configuration file, for deployments configured without code. The zero
+// value of each field keeps the default behavior.
+//
+// Example, a complete server from a JSON file:
+//
+//	var cfg server.Config
+//	if err := json.Unmarshal(data, &cfg); err != nil {
+//	    log.Fatal(err)
+//	}
+//	s, err := server.NewServerFromConfig(cfg)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	log.Fatal(s.ListenAndServe())
+//
+// with a file such as:
+//
+//	{
+//	    "addr": ":21",
+//	    "root_path": "/srv/ftp",
+//	    "welcome_message": "Welcome to the mirror",
+//	    "tls": {"cert_file": "/etc/ftp/cert.pem", "key_file": "/etc/ftp/key.pem"},
+//	    "passive": {"public_host": "ftp.example.com", "min_port": 30000, "max_port": 30100},
+//	    "timeouts": {"idle": "5m", "transfer_stall": "1m"},
+//	    "limits": {"max_connections": 200, "max_connections_per_ip": 5},
+//	    "disabled_commands": ["SITE"]
+//	}
+type Config struct {
+	// Addr is the address ListenAndServe listens on, like ":21".
+	Addr string `json:"addr" yaml:"addr"`
+
+	// RootPath is the directory served by an FSDriver with the default
+	// anonymous-only access, created by NewServerFromConfig unless a driver
+	// is given with WithDriver. AnonWrite sets WithAnonWrite on it.
+	RootPath  string `json:"root_path,omitempty" yaml:"root_path,omitempty"`
+	AnonWrite bool   `json:"anon_write,omitempty" yaml:"anon_write,omitempty"`
+
+	// WelcomeMessage and ServerName set WithWelcomeMessage and
+	// WithServerName.
+	WelcomeMessage string `json:"welcome_message,omitempty" yaml:"welcome_message,omitempty"`
+	ServerName     string `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+
+	// TLS enables explicit FTPS (AUTH TLS).
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+
+	// Passive sets the passive mode settings of the FSDriver created from
+	// RootPath. Drivers given with WithDriver use their own Settings.
+	Passive PassiveConfig `json:"passive,omitzero" yaml:"passive,omitempty"`
+
+	Timeouts TimeoutConfig `json:"timeouts,omitzero" yaml:"timeouts,omitempty"`
+	Limits   LimitConfig   `json:"limits,omitzero" yaml:"limits,omitempty"`
+
+	// DisabledCommands sets WithDisableCommands.
+	DisabledCommands []string `json:"disabled_commands,omitempty" yaml:"disabled_commands,omitempty"`
+
+	// AllowFXP, BlockPrivilegedPorts and ActiveAllowedNetworks set
+	// WithAllowFXP, WithBlockPrivilegedPorts and WithActiveAllowedNetworks.
+	AllowFXP              bool     `json:"allow_fxp,omitempty" yaml:"allow_fxp,omitempty"`
+	BlockPrivilegedPorts  bool     `json:"block_privileged_ports,omitempty" yaml:"block_privileged_ports,omitempty"`
+	ActiveAllowedNetworks []string `json:"active_allowed_networks,omitempty" yaml:"active_allowed_networks,omitempty"`
+}
+
+// TLSConfig is the certificate of a Config, loaded and reloaded as with
+// WithTLSCertificateReloader.
+type TLSConfig struct {
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+
+	// ReloadInterval is how often the files are checked for changes (one
+	// minute if zero).
+	ReloadInterval Duration `json:"reload_interval,omitempty" yaml:"reload_interval,omitempty"`
+
+	// MinVersion sets WithTLSMinVersion, as "1.0" to "1.3". RequireTLS
+	// sets WithRequireTLS.
+	MinVersion string `json:"min_version,omitempty" yaml:"min_version,omitempty"`
+	RequireTLS bool   `json:"require_tls,omitempty" yaml:"require_tls,omitempty"`
+}
+
+// tlsVersions maps the TLS versions of a TLSConfig to their identifiers.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// PassiveConfig is the passive mode part of a Config. See Settings.
+type PassiveConfig struct {
+	PublicHost string `json:"public_host,omitempty" yaml:"public_host,omitempty"`
+	MinPort    int    `json:"min_port,omitempty" yaml:"min_port,omitempty"`
+	MaxPort    int    `json:"max_port,omitempty" yaml:"max_port,omitempty"`
+}
+
+// TimeoutConfig is the timeout part of a Config.
+type TimeoutConfig struct {
+	Idle          Duration `json:"idle,omitempty" yaml:"idle,omitempty"`                     // WithMaxIdleTime
+	Read          Duration `json:"read,omitempty" yaml:"read,omitempty"`                     // WithReadTimeout
+	Write         Duration `json:"write,omitempty" yaml:"write,omitempty"`                   // WithWriteTimeout
+	TransferStall Duration `json:"transfer_stall,omitempty" yaml:"transfer_stall,omitempty"` // WithTransferStallTimeout
+	MaxSession    Duration `json:"max_session,omitempty" yaml:"max_session,omitempty"`       // WithMaxSessionDuration
+}
+
+// LimitConfig is the limit part of a Config.
+type LimitConfig struct {
+	MaxConnections         int   `json:"max_connections,omitempty" yaml:"max_connections,omitempty"`                     // WithMaxConnections
+	MaxConnectionsPerIP    int   `json:"max_connections_per_ip,omitempty" yaml:"max_connections_per_ip,omitempty"`       // WithMaxConnections
+	MaxCommandRate         int   `json:"max_command_rate,omitempty" yaml:"max_command_rate,omitempty"`                   // WithMaxCommandRate
+	MaxSessionsPerListener int   `json:"max_sessions_per_listener,omitempty" yaml:"max_sessions_per_listener,omitempty"` // WithMaxSessionsPerListener
+	BandwidthGlobal        int64 `json:"bandwidth_global,omitempty" yaml:"bandwidth_global,omitempty"`                   // WithBandwidthLimit
+	BandwidthPerUser       int64 `json:"bandwidth_per_user,omitempty" yaml:"bandwidth_per_user,omitempty"`               // WithBandwidthLimit
+}
+
+// Duration is a time.Duration written as a string, like "30s" or "5m", in
+// configuration files.
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Validate checks the configuration without touching the filesystem, and
+// returns all the problems found, joined with errors.Join.
+func (cfg *Config) Validate() error {
+	var errs []error
+	if cfg.TLS != nil && (cfg.TLS.CertFile == "" || cfg.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls: cert_file and key_file are required"))
+	}
+	if cfg.TLS != nil && cfg.TLS.MinVersion != "" {
+		if _, ok := tlsVersions[cfg.TLS.MinVersion]; !ok {
+			errs = append(errs, fmt.Errorf("tls: unsupported min_version %q", cfg.TLS.MinVersion))
+		}
+	}
+
+	p := cfg.Passive
+	if p != (PassiveConfig{}) && cfg.RootPath == "" {
+		errs = append(errs, errors.New("passive: settings require root_path; drivers set them in their Settings"))
+	}
+	if p.MinPort != 0 || p.MaxPort != 0 {
+		if p.MinPort < 1 || p.MaxPort > 65535 || p.MinPort > p.MaxPort {
+			errs = append(errs, fmt.Errorf("passive: invalid port range %d-%d", p.MinPort, p.MaxPort))
+		}
+	}
+
+	t := cfg.Timeouts
+	for _, d := range []struct {
+		name  string
+		value Duration
+	}{
+		{"idle", t.Idle},
+		{"read", t.Read},
+		{"write", t.Write},
+		{"transfer_stall", t.TransferStall},
+		{"max_session", t.MaxSession},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("timeouts: %s cannot be negative", d.name))
+		}
+	}
+
+	l := cfg.Limits
+	for _, v := range []struct {
+		name  string
+		value int64
+	}{
+		{"max_connections", int64(l.MaxConnections)},
+		{"max_connections_per_ip", int64(l.MaxConnectionsPerIP)},
+		{"max_command_rate", int64(l.MaxCommandRate)},
+		{"max_sessions_per_listener", int64(l.MaxSessionsPerListener)},
+		{"bandwidth_global", l.BandwidthGlobal},
+		{"bandwidth_per_user", l.BandwidthPerUser},
+	} {
+		if v.value < 0 {
+			errs = append(errs, fmt.Errorf("limits: %s cannot be negative", v.name))
+		}
+	}
+
+	for _, cmd := range cfg.DisabledCommands {
+		if strings.TrimSpace(cmd) == "" {
+			errs = append(errs, errors.New("disabled_commands: empty command name"))
+		}
+	}
+	for _, cidr := range cfg.ActiveAllowedNetworks {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, fmt.Errorf("active_allowed_networks: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Options returns the options for the settings of the configuration. The
+// driver is not included: NewServerFromConfig creates it from RootPath.
+func (cfg *Config) Options() []Option {
+	var opts []Option
+	if cfg.WelcomeMessage != "" {
+		opts = append(opts, WithWelcomeMessage(cfg.WelcomeMessage))
+	}
+	if cfg.ServerName != "" {
+		opts = append(opts, WithServerName(cfg.ServerName))
+	}
+	if cfg.TLS != nil {
+		opts = append(opts, WithTLSCertificateReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, time.Duration(cfg.TLS.ReloadInterval)))
+		if v, ok := tlsVersions[cfg.TLS.MinVersion]; ok {
+			opts = append(opts, WithTLSMinVersion(v))
+		}
+		if cfg.TLS.RequireTLS {
+			opts = append(opts, WithRequireTLS(true))
+		}
+	}
+
+	t := cfg.Timeouts
+	if t.Idle != 0 {
+		opts = append(opts, WithMaxIdleTime(time.Duration(t.Idle)))
+	}
+	if t.Read != 0 {
+		opts = append(opts, WithReadTimeout(time.Duration(t.Read)))
+	}
+	if t.Write != 0 {
+		opts = append(opts, WithWriteTimeout(time.Duration(t.Write)))
+	}
+	if t.TransferStall != 0 {
+		opts = append(opts, WithTransferStallTimeout(time.Duration(t.TransferStall)))
+	}
+	if t.MaxSession != 0 {
+		opts = append(opts, WithMaxSessionDuration(time.Duration(t.MaxSession)))
+	}
+
+	l := cfg.Limits
+	if l.MaxConnections != 0 || l.MaxConnectionsPerIP != 0 {
+		opts = append(opts, WithMaxConnections(l.MaxConnections, l.MaxConnectionsPerIP))
+	}
+	if l.MaxCommandRate != 0 {
+		opts = append(opts, WithMaxCommandRate(l.MaxCommandRate))
+	}
+	if l.MaxSessionsPerListener != 0 {
+		opts = append(opts, WithMaxSessionsPerListener(l.MaxSessionsPerListener))
+	}
+	if l.BandwidthGlobal != 0 || l.BandwidthPerUser != 0 {
+		opts = append(opts, WithBandwidthLimit(l.BandwidthGlobal, l.BandwidthPerUser))
+	}
+
+	if len(cfg.DisabledCommands) > 0 {
+		opts = append(opts, WithDisableCommands(cfg.DisabledCommands...))
+	}
+	if cfg.AllowFXP {
+		opts = append(opts, WithAllowFXP(true))
+	}
+	if cfg.BlockPrivilegedPorts {
+		opts = append(opts, WithBlockPrivilegedPorts(true))
+	}
+	if len(cfg.ActiveAllowedNetworks) > 0 {
+		opts = append(opts, WithActiveAllowedNetworks(cfg.ActiveAllowedNetworks...))
+	}
+	return opts
+}
+
+// NewServerFromConfig validates the configuration and creates a server
+// listening on cfg.Addr. Unless options include WithDriver, the files of
+// cfg.RootPath are served with an FSDriver. Options are applied after those
+// of the configuration, so they can add settings that cannot be written in
+// a file, such as a logger or an authenticator-backed driver.
+//
+// Example:
+//
+//	s, err := server.NewServerFromConfig(cfg,
+//	    server.WithDriver(driver),
+//	    server.WithLogger(logger),
+//	)
+func NewServerFromConfig(cfg Config, options ...Option) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	opts := append(cfg.Options(), options...)
+	if cfg.RootPath != "" {
+		fsOpts := []FSDriverOption{WithAnonWrite(cfg.AnonWrite)}
+		if cfg.Passive != (PassiveConfig{}) {
+			fsOpts = append(fsOpts, WithSettings(&Settings{
+				PublicHost:  cfg.Passive.PublicHost,
+				PasvMinPort: cfg.Passive.MinPort,
+				PasvMaxPort: cfg.Passive.MaxPort,
+			}))
+		}
+		driver, err := NewFSDriver(cfg.RootPath, fsOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create driver: %w", err)
+		}
+		// Applied last, so that a driver set with WithDriver wins
+		opts = append(opts, func(s *Server) error {
+			if s.driver == nil {
+				s.driver = driver
+			}
+			return nil
+		})
+	}
+	return NewServer(cfg.Addr, opts...)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+func TestConfig(t *testing.T) {
+	t.Parallel()
+
+	rootDir := t.TempDir()
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "hello.txt"), []byte("hello"), 0644), "Failed to write file")
+
+	var cfg Config
+	data := `{
+		"root_path": "` + rootDir + `",
+		"welcome_message": "220 Configured",
+		"passive": {"min_port": 45200, "max_port": 45299},
+		"timeouts": {"idle": "1m", "transfer_stall": "30s"},
+		"limits": {"max_connections": 10, "max_connections_per_ip": 5},
+		"disabled_commands": ["mkd"]
+	}`
+	fatalIfErr(t, json.Unmarshal([]byte(data), &cfg), "Failed to parse config")
+	if cfg.Timeouts.Idle != Duration(time.Minute) {
+		t.Errorf("Idle timeout = %v, want 1m", time.Duration(cfg.Timeouts.Idle))
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	cfg.Addr = ln.Addr().String()
+	s, err := NewServerFromConfig(cfg)
+	fatalIfErr(t, err, "NewServerFromConfig failed")
+	go func() {
+		_ = s.Serve(ln)
+	}()
+	defer s.Shutdown(t.Context())
+
+	if s.maxIdleTime != time.Minute || s.maxConnections != 10 || !s.disabledCommands["MKD"] {
+		t.Errorf("Options not applied: idle %v, max connections %d, disabled %v", s.maxIdleTime, s.maxConnections, s.disabledCommands)
+	}
+
+	conn, err := net.Dial("tcp", cfg.Addr)
+	fatalIfErr(t, err, "Failed to dial")
+	_, greeting, err := rawReadResponse(&textConn{Conn: conn, Reader: bufio.NewReader(conn)})
+	conn.Close()
+	if err != nil || !strings.Contains(greeting, "Configured") {
+		t.Errorf("Greeting %q (%v), want the configured message", greeting, err)
+	}
+
+	c, err := ftp.Dial(cfg.Addr, ftp.WithTimeout(2*time.Second))
+	fatalIfErr(t, err, "Failed to dial")
+	defer c.Quit()
+	fatalIfErr(t, c.Login("anonymous", "guest"), "Login failed")
+	fatalIfErr(t, c.Retrieve("hello.txt", io.Discard), "Retrieve failed")
+
+	bad := Config{
+		TLS:     &TLSConfig{CertFile: "cert.pem", MinVersion: "1.4"},
+		Passive: PassiveConfig{MinPort: 2000, MaxPort: 1000},
+		Limits:  LimitConfig{MaxCommandRate: -1},
+
+		ActiveAllowedNetworks: []string{"10.0.0.0/33"},
+	}
+	err = bad.Validate()
+	for _, want := range []string{"tls:", "min_version", "require root_path", "invalid port range", "max_command_rate", "active_allowed_networks"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want an error mentioning %q", err, want)
+		}
+	}
+	if _, err := NewServerFromConfig(bad); err == nil {
+		t.Error("NewServerFromConfig accepted an invalid config")
+	}
+}
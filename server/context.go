@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+)
+
+// sessionKey is the context key for the SessionInfo of a driver operation.
+type sessionKey struct{}
+
+// SessionFromContext returns the session a ContextClientContext or
+// ContextDriver operation is done for: its ID (as logged), remote IP, user and
+// host. The Transfer field is not set.
+func SessionFromContext(ctx context.Context) (SessionInfo, bool) {
+	info, ok := ctx.Value(sessionKey{}).(SessionInfo)
+	return info, ok
+}
+
+// context returns a context for a driver operation. It carries the session
+// and is canceled when the control connection closes.
+func (s *session) context() context.Context {
+	s.mu.Lock()
+	info := SessionInfo{
+		ID:       s.sessionID,
+		RemoteIP: s.remoteIP,
+		LocalIP:  s.localIP,
+		User:     s.user,
+		Host:     s.host,
+		LoggedIn: s.isLoggedIn,
+	}
+	s.mu.Unlock()
+	return context.WithValue(s.ctx, sessionKey{}, info)
+}
+
+// authenticate authenticates the user with the session's driver, through
+// AuthenticateContext if the driver implements ContextDriver. It returns the
+// driver's value, for the optional interfaces, and its context-aware view.
+// The value of a ClientContext wrapped with AdaptClientContext is the
+// ClientContext, so that its optional interfaces are found.
+func (s *session) authenticate(pass string, remoteIP net.IP) (any, ContextClientContext, error) {
+	driver := s.driver()
+	if cd, ok := driver.(ContextDriver); ok {
+		fs, err := cd.AuthenticateContext(s.context(), s.user, pass, s.host, remoteIP)
+		if a, ok := fs.(clientContextAdapter); ok {
+			return a.c, fs, err
+		}
+		return fs, fs, err
+	}
+
+	fs, err := driver.Authenticate(s.user, pass, s.host, remoteIP)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fs, AdaptClientContext(fs), nil
+}
+
+// AdaptClientContext returns a ContextClientContext calling c's methods,
+// ignoring the contexts. ContextDriver implementations can use it for
+// backends without context support. The optional interfaces implemented by
+// c are used as if c had been returned.
+func AdaptClientContext(c ClientContext) ContextClientContext {
+	return clientContextAdapter{c}
+}
+
+// clientContextAdapter is the ContextClientContext of AdaptClientContext.
+type clientContextAdapter struct {
+	c ClientContext
+}
+
+func (a clientContextAdapter) ChangeDir(_ context.Context, path string) error {
+	return a.c.ChangeDir(path)
+}
+
+func (a clientContextAdapter) GetWd(_ context.Context) (string, error) {
+	return a.c.GetWd()
+}
+
+func (a clientContextAdapter) MakeDir(_ context.Context, path string) error {
+	return a.c.MakeDir(path)
+}
+
+func (a clientContextAdapter) RemoveDir(_ context.Context, path string) error {
+	return a.c.RemoveDir(path)
+}
+
+func (a clientContextAdapter) DeleteFile(_ context.Context, path string) error {
+	return a.c.DeleteFile(path)
+}
+
+func (a clientContextAdapter) Rename(_ context.Context, fromPath, toPath string) error {
+	return a.c.Rename(fromPath, toPath)
+}
+
+func (a clientContextAdapter) ListDir(_ context.Context, path string) ([]os.FileInfo, error) {
+	return a.c.ListDir(path)
+}
+
+func (a clientContextAdapter) OpenFile(_ context.Context, path string, flag int) (io.ReadWriteCloser, error) {
+	return a.c.OpenFile(path, flag)
+}
+
+func (a clientContextAdapter) GetFileInfo(_ context.Context, path string) (os.FileInfo, error) {
+	return a.c.GetFileInfo(path)
+}
+
+func (a clientContextAdapter) Close() error {
+	return a.c.Close()
+}
+
+func (a clientContextAdapter) GetSettings() *Settings {
+	return a.c.GetSettings()
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// contextDriver is a ContextDriver recording the contexts of MKD and RETR.
+type contextDriver struct {
+	Driver
+
+	mu       sync.Mutex
+	sessions []SessionInfo
+	openCtx  context.Context
+}
+
+func (d *contextDriver) AuthenticateContext(ctx context.Context, user, pass, host string, remoteIP net.IP) (ContextClientContext, error) {
+	c, err := d.Authenticate(user, pass, host, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	d.record(ctx)
+	return &contextClient{ContextClientContext: AdaptClientContext(c), d: d}, nil
+}
+
+func (d *contextDriver) record(ctx context.Context) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if info, ok := SessionFromContext(ctx); ok {
+		d.sessions = append(d.sessions, info)
+	}
+}
+
+type contextClient struct {
+	ContextClientContext
+	d *contextDriver
+}
+
+func (c *contextClient) MakeDir(ctx context.Context, path string) error {
+	c.d.record(ctx)
+	return c.ContextClientContext.MakeDir(ctx, path)
+}
+
+// OpenFile returns a file whose reads block until ctx is canceled.
+func (c *contextClient) OpenFile(ctx context.Context, path string, flag int) (io.ReadWriteCloser, error) {
+	if flag != os.O_RDONLY {
+		return c.ContextClientContext.OpenFile(ctx, path, flag)
+	}
+	c.d.mu.Lock()
+	c.d.openCtx = ctx
+	c.d.mu.Unlock()
+	return blockingFile{ctx}, nil
+}
+
+type blockingFile struct {
+	ctx context.Context
+}
+
+func (f blockingFile) Read([]byte) (int, error) {
+	<-f.ctx.Done()
+	return 0, f.ctx.Err()
+}
+
+func (f blockingFile) Write(b []byte) (int, error) { return len(b), nil }
+func (f blockingFile) Close() error                { return nil }
+
+func TestContextDriver(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	rootDir := t.TempDir()
+	fsDriver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return rootDir, false, nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	driver := &contextDriver{Driver: fsDriver}
+	startServer(t, ln, WithDriver(driver))
+
+	tc, err := rawLogin(ln.Addr().String(), "alice", "secret")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	fmt.Fprintf(tc, "MKD incoming\r\n")
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 257 {
+		t.Fatalf("MKD failed: %q %v", msg, err)
+	}
+
+	driver.mu.Lock()
+	sessions := driver.sessions
+	driver.mu.Unlock()
+	if len(sessions) != 2 {
+		t.Fatalf("recorded %d sessions, want 2", len(sessions))
+	}
+	if s := sessions[0]; s.User != "alice" || s.RemoteIP != "127.0.0.1" || s.ID == "" || s.LoggedIn {
+		t.Errorf("AuthenticateContext session = %+v", s)
+	}
+	if s := sessions[1]; s.User != "alice" || s.ID != sessions[0].ID || !s.LoggedIn {
+		t.Errorf("MakeDir session = %+v", s)
+	}
+
+	// ABOR cancels the context of the file being downloaded
+	dataAddr, err := rawEnterPasv(tc)
+	fatalIfErr(t, err, "PASV failed")
+	fmt.Fprintf(tc, "RETR blocking.bin\r\n")
+	dataConn, err := net.Dial("tcp", dataAddr)
+	fatalIfErr(t, err, "Failed to dial data connection")
+	defer dataConn.Close()
+	if code, msg, err := rawReadResponse(tc); err != nil || !isPreliminary(code) {
+		t.Fatalf("RETR failed: %q %v", msg, err)
+	}
+
+	fmt.Fprintf(tc, "ABOR\r\n")
+	codes := make(map[int]bool)
+	for range 2 {
+		code, _, err := rawReadResponse(tc)
+		fatalIfErr(t, err, "Failed to read reply")
+		codes[code] = true
+	}
+	if !codes[226] || !codes[426] {
+		t.Errorf("ABOR replies = %v, want 226 and 426", codes)
+	}
+
+	driver.mu.Lock()
+	openCtx := driver.openCtx
+	driver.mu.Unlock()
+	select {
+	case <-openCtx.Done():
+	case <-time.After(time.Second):
+		t.Error("OpenFile context not canceled by ABOR")
+	}
+}
+
+// adaptedDriver is a ContextDriver returning its ClientContext with
+// AdaptClientContext.
+type adaptedDriver struct {
+	Driver
+}
+
+func (d *adaptedDriver) AuthenticateContext(ctx context.Context, user, pass, host string, remoteIP net.IP) (ContextClientContext, error) {
+	c, err := d.Authenticate(user, pass, host, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	return AdaptClientContext(c), nil
+}
+
+func TestAdaptClientContext_OptionalInterfaces(t *testing.T) {
+	t.Parallel()
+
+	// FSDriver's Hasher must be found through the adapter
+	c, rootDir := setupWrappedServer(t, func(d Driver) Driver { return &adaptedDriver{d} })
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "data.txt"), []byte("hello"), 0644), "Failed to create file")
+
+	if !c.HasFeature("HASH") {
+		t.Error("FEAT does not list HASH")
+	}
+	result, err := c.Hash("data.txt")
+	fatalIfErr(t, err, "HASH failed")
+	if want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"; result.Value != want {
+		t.Errorf("HASH = %q, want %q", result.Value, want)
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// dataConnOpen is the 125 reply text of transfers whose passive data
+// connection the client opened before sending the command.
+const dataConnOpen = "Data connection already open; transfer starting."
+
+// passiveTimeout is how long a transfer waits for the client to open its
+// passive data connection.
+const passiveTimeout = 10 * time.Second
+
+// passiveListener is the listener set up by PASV or EPSV. It accepts the
+// data connection as soon as the client opens it, so that the transfer
+// command can tell whether the connection is already open (125) or about to
+// be opened (150).
+type passiveListener struct {
+	net.Listener
+	accepted chan acceptResult // Unbuffered: the acceptor waits for a taker
+	closed   chan struct{}
+
+	mu        sync.Mutex
+	conn      net.Conn // Accepted but not yet used by a transfer
+	err       error
+	done      bool // The accept result has been received
+	closeOnce sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newPassiveListener(ln net.Listener) *passiveListener {
+	p := &passiveListener{
+		Listener: ln,
+		accepted: make(chan acceptResult),
+		closed:   make(chan struct{}),
+	}
+	go func() {
+		conn, err := ln.Accept()
+		select {
+		case p.accepted <- acceptResult{conn, err}:
+		case <-p.closed:
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}()
+	return p
+}
+
+// receive stores r as the accept result.
+func (p *passiveListener) receive(r acceptResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conn, p.err, p.done = r.conn, r.err, true
+}
+
+// connected reports whether the client has already opened the data
+// connection.
+func (p *passiveListener) connected() bool {
+	p.mu.Lock()
+	done, conn := p.done, p.conn
+	p.mu.Unlock()
+	if done {
+		return conn != nil
+	}
+
+	select {
+	case r := <-p.accepted:
+		p.receive(r)
+		return r.conn != nil
+	default:
+		return false
+	}
+}
+
+// wait returns the data connection, waiting up to timeout for the client to
+// open it. The connection is handed over once; Close no longer closes it.
+func (p *passiveListener) wait(timeout time.Duration) (net.Conn, error) {
+	p.mu.Lock()
+	done := p.done
+	p.mu.Unlock()
+	if !done {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case r := <-p.accepted:
+			p.receive(r)
+		case <-timer.C:
+			return nil, os.ErrDeadlineExceeded
+		case <-p.closed:
+			return nil, net.ErrClosed
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn, err := p.conn, p.err
+	p.conn = nil
+	if conn == nil && err == nil {
+		err = net.ErrClosed // Already handed over
+	}
+	return conn, err
+}
+
+// Close closes the listener, and the data connection if no transfer used
+// it.
+func (p *passiveListener) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	err := p.Listener.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return err
+}
+
+// openDataConn sends the preliminary reply of a transfer and opens its
+// data connection (RFC 959 Section 5.4): 125 with open if the client has
+// already opened the passive connection or a block mode connection was
+// kept from the last transfer, or 150 with opening before the connection
+// is accepted or dialed. If the connection fails, it replies 425
+// and returns false; the caller then only cleans up.
+func (s *session) openDataConn(opening, open string) (net.Conn, bool) {
+	if conn := s.reusableData(); conn != nil {
+		s.setDataDeadlines(conn)
+		s.reply(125, open)
+		return s.blockConn(conn), true
+	}
+
+	if s.pasvList != nil && s.pasvList.connected() {
+		s.reply(125, open)
+	} else {
+		s.reply(150, opening)
+	}
+
+	conn, err := s.connData()
+	if err != nil {
+		s.reply(425, "Can't open data connection.")
+		return nil, false
+	}
+	if s.blockMode {
+		conn = s.blockConn(conn)
+	}
+	return conn, true
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPassiveListener(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	p := newPassiveListener(ln)
+	defer p.Close()
+
+	if p.connected() {
+		t.Fatal("connected() = true before the client connected")
+	}
+	if _, err := p.wait(10 * time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("wait() error = %v, want deadline exceeded", err)
+	}
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	fatalIfErr(t, err, "Dial failed")
+	defer client.Close()
+	waitConnected(t, p)
+
+	conn, err := p.wait(time.Second)
+	fatalIfErr(t, err, "wait failed")
+	defer conn.Close()
+	if _, err := p.wait(time.Second); err == nil {
+		t.Error("second wait() succeeded, want error")
+	}
+
+	// Closing the listener leaves the handed over connection open.
+	p.Close()
+	fmt.Fprint(client, "x")
+	buf := make([]byte, 1)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(buf); err != nil {
+		t.Errorf("Read after Close: %v", err)
+	}
+}
+
+func TestPassiveListener_CloseUnused(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	p := newPassiveListener(ln)
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	fatalIfErr(t, err, "Dial failed")
+	defer client.Close()
+	waitConnected(t, p)
+
+	// A connection no transfer used is closed with the listener.
+	p.Close()
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read error = %v, want EOF", err)
+	}
+	if _, err := p.wait(time.Second); err == nil {
+		t.Error("wait() after Close succeeded, want error")
+	}
+}
+
+func waitConnected(t *testing.T, p *passiveListener) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !p.connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("connection never accepted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestTransferReplies checks the preliminary and completion replies of each
+// transfer command (RFC 959 Section 5.4).
+func TestTransferReplies(t *testing.T) {
+	t.Parallel()
+	rootDir := t.TempDir()
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "a.txt"), []byte("hello"), 0644), "Failed to write file")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	driver, err := NewFSDriver(rootDir,
+		WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+			return rootDir, false, nil
+		}),
+	)
+	fatalIfErr(t, err, "Failed to create FS driver")
+	startServer(t, ln, WithDriver(driver))
+
+	tc, err := rawLogin(ln.Addr().String(), "test", "test")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	commands := []struct {
+		cmd    string
+		upload bool
+	}{
+		{"RETR a.txt", false},
+		{"LIST", false},
+		{"NLST", false},
+		{"MLSD", false},
+		{"STOR b.txt", true},
+		{"APPE b.txt", true},
+		{"STOU", true},
+	}
+
+	// transfer moves the data of cmd over conn and checks the 226 reply.
+	transfer := func(cmd string, upload bool, conn net.Conn) {
+		t.Helper()
+		if upload {
+			fmt.Fprint(conn, "data")
+		} else {
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _ = io.Copy(io.Discard, conn)
+		}
+		conn.Close()
+		if code, msg, err := rawReadResponse(tc); err != nil || code != 226 {
+			t.Errorf("%s: got %d %q %v, want 226", cmd, code, msg, err)
+		}
+	}
+
+	for _, tt := range commands {
+		// The client connects only after the 150 reply, as strict clients
+		// do: the server must not wait for the connection before replying.
+		addr, err := rawEnterPasv(tc)
+		fatalIfErr(t, err, "PASV failed")
+		fmt.Fprintf(tc, "%s\r\n", tt.cmd)
+		code, msg, err := rawReadResponse(tc)
+		if err != nil || code != 150 {
+			t.Fatalf("%s before connecting: got %d %q %v, want 150", tt.cmd, code, msg, err)
+		}
+		if tt.cmd == "STOU" && !strings.HasPrefix(msg, "150 FILE: ") {
+			t.Errorf("STOU reply %q does not name the file", msg)
+		}
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		fatalIfErr(t, err, "Data connection failed")
+		transfer(tt.cmd, tt.upload, conn)
+
+		// The client connects first: the server replies 125.
+		addr, err = rawEnterPasv(tc)
+		fatalIfErr(t, err, "PASV failed")
+		conn, err = net.DialTimeout("tcp", addr, 5*time.Second)
+		fatalIfErr(t, err, "Data connection failed")
+		waitDataConnOpen(t, tc)
+		fmt.Fprintf(tc, "%s\r\n", tt.cmd)
+		code, msg, err = rawReadResponse(tc)
+		if err != nil || code != 125 {
+			t.Fatalf("%s after connecting: got %d %q %v, want 125", tt.cmd, code, msg, err)
+		}
+		transfer(tt.cmd, tt.upload, conn)
+	}
+
+	// Errors found before the data connection is opened have no 1xx reply.
+	_, err = rawEnterPasv(tc)
+	fatalIfErr(t, err, "PASV failed")
+	fmt.Fprintf(tc, "RETR missing.txt\r\n")
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 550 {
+		t.Errorf("RETR missing.txt: got %d %q %v, want 550", code, msg, err)
+	}
+
+	// Errors found after it follow the 1xx reply.
+	addr, err := rawEnterPasv(tc)
+	fatalIfErr(t, err, "PASV failed")
+	fmt.Fprintf(tc, "LIST missing\r\n")
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 150 {
+		t.Fatalf("LIST missing: got %d %q %v, want 150", code, msg, err)
+	}
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	fatalIfErr(t, err, "Data connection failed")
+	defer conn.Close()
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 550 {
+		t.Errorf("LIST missing: got %d %q %v, want 550", code, msg, err)
+	}
+
+	// An active data connection that cannot be opened is 425, after the
+	// 150 reply.
+	port := freePort(t)
+	fmt.Fprintf(tc, "EPRT |1|127.0.0.1|%d|\r\n", port)
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 200 {
+		t.Fatalf("EPRT failed: %d %q %v", code, msg, err)
+	}
+	fmt.Fprintf(tc, "RETR a.txt\r\n")
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 150 {
+		t.Fatalf("RETR: got %d %q %v, want 150", code, msg, err)
+	}
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 425 {
+		t.Errorf("RETR: got %d %q %v, want 425", code, msg, err)
+	}
+}
+
+// waitDataConnOpen polls STAT until the server has accepted the passive
+// data connection.
+func waitDataConnOpen(t *testing.T, tc *textConn) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fmt.Fprintf(tc, "STAT\r\n")
+		open := false
+		for {
+			_, line, err := rawReadResponse(tc)
+			fatalIfErr(t, err, "STAT failed")
+			open = open || strings.Contains(line, "data connection open")
+			if strings.HasPrefix(line, "211 ") {
+				break
+			}
+		}
+		if open {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("data connection never accepted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -149,7 +149,8 @@
 //	)
 //
 // The PublicHost is advertised to clients in PASV responses. If not set,
-// the server uses the control connection's local address.
+// the server uses the control connection's local address. To choose the
+// address per connection, e.g. behind several NATs, use WithPassiveAddress.
 //
 // Port range configuration is essential for firewall rules:
 //   - Ensure the range is large enough for concurrent transfers
@@ -218,6 +219,19 @@
 //WARN connection_rejected remote_ip=192.168.1.100 reason=global_limit_reached limit=100
 //WARN connection_rejected remote_ip=192.168.1.100 reason=per_ip_limit_reached limit=10
 //
+// Security Events (WARN level):
+//
+//WARN permission_denied session_id=abc123 remote_ip=192.168.1.100 user=john cmd=DELE path=/file.txt reason="permission denied"
+//WARN path_traversal session_id=abc123 remote_ip=192.168.1.100 user=john cmd=RETR path=../../etc/passwd
+//WARN bounce_rejected session_id=abc123 remote_ip=192.168.1.100 user=john cmd=PORT reason=address_mismatch target=10.0.0.5:25
+//WARN tls_downgrade session_id=abc123 remote_ip=192.168.1.100 user=john cmd=PROT reason=clear_data_channel
+//WARN command_disabled session_id=abc123 remote_ip=192.168.1.100 user=john cmd=SITE
+//WARN session_abuse session_id=abc123 remote_ip=192.168.1.100 user=john reason=command_rate
+//
+// Authentication, connection and security events are audit events (see
+// AuditEvent). WithAuditSink sends them to another destination, such as a
+// SIEM, instead of the logger.
+//
 // Using session_id for correlation:
 //
 // All logs from a single client session share the same session_id, making it
@@ -344,7 +358,8 @@
 //   - RFC 3659 (Extensions: SIZE, MDTM, MLSD, MLST, REST)
 //   - RFC 4217 (Securing FTP with TLS)
 //   - RFC 7151 (HOST Command)
-//   - draft-somers-ftp-mfxx (MFMT Command)
+//   - draft-somers-ftp-mfxx (MFMT, MFCT and MFF Commands)
 //   - draft-bryan-ftp-hash (HASH Command)
+//   - draft-bryan-ftp-range (RANG Command)
 
 package server
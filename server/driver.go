@@ -1,7 +1,9 @@
 package server
 
 import (
+	"context"
 	"io"
+	"iter"
 	"net"
 	"os"
 	"time"
@@ -47,6 +49,13 @@ type Driver interface {
 // It isolates the operations to the user's view of the filesystem (e.g., handling chroots).
 // All paths are relative to the user's root directory and use forward slashes.
 //
+// Only the operations every backend can support are required. Checksums,
+// timestamps, permissions, ownership, free space and ranged reads are
+// provided by the optional interfaces Hasher, TimeSetter, CreationTimeSetter,
+// Chmodder, Chowner, SpaceReporter and Ranger.
+// The server detects them at login, leaves the matching features out of FEAT
+// and replies 502 to the commands that need a missing one.
+//
 // Error handling:
 //   - Return os.ErrNotExist when files/directories don't exist
 //   - Return os.ErrPermission for permission denied errors
@@ -91,28 +100,252 @@ type ClientContext interface {
 	// Returns os.ErrNotExist if the path doesn't exist.
 	GetFileInfo(path string) (os.FileInfo, error)
 
+	// Close releases any resources associated with this context.
+	// Called when the client disconnects.
+	Close() error
+
+	// GetSettings returns the session settings for passive mode configuration.
+	// May return nil if no special settings are needed.
+	GetSettings() *Settings
+}
+
+// ContextDriver is an optional interface a Driver can implement to receive a
+// context.Context with authentication and every file operation, for backends
+// that need cancellation or request-scoped tracing, such as object stores.
+// When it is implemented, the server calls AuthenticateContext instead of
+// Authenticate. See ContextClientContext for the contexts passed.
+type ContextDriver interface {
+	// AuthenticateContext is like Driver.Authenticate, returning a
+	// ContextClientContext. Use AdaptClientContext to return a ClientContext.
+	AuthenticateContext(ctx context.Context, user, pass, host string, remoteIP net.IP) (ContextClientContext, error)
+}
+
+// ContextClientContext is the counterpart of ClientContext for drivers
+// implementing ContextDriver. Each method receives a context carrying the
+// session (see SessionFromContext). The contexts of the operations done by a
+// transfer after its data connection is open are canceled by ABOR; all of
+// them are canceled when the control connection closes.
+//
+// The optional interfaces (DirStreamer, StatExtended, etc.) can be
+// implemented by a ContextClientContext too; their methods receive no
+// context, except for Hasher, TimeSetter, CreationTimeSetter, Chmodder,
+// Chowner and Ranger, which can be implemented with a context as
+// ContextHasher, ContextTimeSetter, ContextCreationTimeSetter,
+// ContextChmodder, ContextChowner and ContextRanger instead.
+type ContextClientContext interface {
+	ChangeDir(ctx context.Context, path string) error
+	GetWd(ctx context.Context) (string, error)
+	MakeDir(ctx context.Context, path string) error
+	RemoveDir(ctx context.Context, path string) error
+	DeleteFile(ctx context.Context, path string) error
+	Rename(ctx context.Context, fromPath, toPath string) error
+	ListDir(ctx context.Context, path string) ([]os.FileInfo, error)
+	OpenFile(ctx context.Context, path string, flag int) (io.ReadWriteCloser, error)
+	GetFileInfo(ctx context.Context, path string) (os.FileInfo, error)
+	Close() error
+	GetSettings() *Settings
+}
+
+// DirStreamer is an optional interface a ClientContext can implement to list
+// directories without loading every entry in memory. When it is implemented,
+// LIST, NLST and MLSD use ListDirStream instead of ListDir and write each entry
+// to the data connection as it is read, so listing directories with millions
+// of entries uses constant memory. Sorted listings (LIST -t, -S or -r) still
+// need all entries and use ListDir.
+type DirStreamer interface {
+	// ListDirStream returns an iterator over the entries of the specified
+	// directory, in directory order. Errors opening the directory are returned
+	// immediately (os.ErrNotExist if it doesn't exist); an error while reading
+	// is yielded with a nil entry and ends the iteration, so the listing is
+	// reported as incomplete. Resources must not be held until the iterator
+	// is used, as it may never be.
+	ListDirStream(path string) (iter.Seq2[os.FileInfo, error], error)
+}
+
+// StatInfo is the metadata returned by StatExtended.
+type StatInfo struct {
+	// Name is the base name of the file or directory
+	Name string
+
+	// Size is the size in bytes
+	Size int64
+
+	// ModTime is the modification time
+	ModTime time.Time
+
+	// IsDir reports whether the path is a directory
+	IsDir bool
+
+	// Symlink reports whether the path is a symbolic link, which MLST
+	// reports as type=OS.unix=slink
+	Symlink bool
+}
+
+// StatExtended is an optional interface a ClientContext can implement to
+// answer SIZE, MDTM and MLST without building a full os.FileInfo. This suits
+// backends such as object stores, where a HEAD request is cheap but Stat
+// semantics (modes, directories) differ. When it is not implemented,
+// GetFileInfo is used.
+type StatExtended interface {
+	// StatObject returns the metadata of a file or directory.
+	// Returns os.ErrNotExist if the path doesn't exist.
+	StatObject(path string) (StatInfo, error)
+}
+
+// UploadFinalizer is an optional interface a ClientContext can implement to
+// process uploads before they are published, when atomic uploads are enabled
+// with WithAtomicUploads. Typical uses are virus scanning and transformation.
+type UploadFinalizer interface {
+	// OnUploadFinalize is called once an upload has been fully written to
+	// tmpPath, before it is renamed to finalPath. Both paths are in the same
+	// form as those passed to the other ClientContext methods. Returning an
+	// error discards the upload, and the client receives a 451 reply.
+	OnUploadFinalize(tmpPath, finalPath string) error
+}
+
+// FileCombiner is an optional interface a ClientContext can implement to
+// concatenate files natively for the COMB command, e.g. with a multipart copy
+// on an object store. When it is not implemented, the server copies the parts
+// into the target with OpenFile.
+type FileCombiner interface {
+	// CombineFiles writes target as the concatenation of parts, in order,
+	// replacing target if it exists. The server deletes the parts afterwards.
+	// Returns os.ErrNotExist if a part doesn't exist.
+	CombineFiles(target string, parts []string) error
+}
+
+// Hasher is an optional interface a ClientContext can implement to compute
+// file checksums for the HASH, XCRC, XMD5, XSHA1 and XSHA256 commands. When
+// it is not implemented, those commands reply 502 and are not listed in FEAT.
+type Hasher interface {
 	// GetHash calculates the hash of a file using the specified algorithm.
 	// Supported algorithms: "SHA-256", "SHA-512", "SHA-1", "MD5", "CRC32".
 	// Returns an error if the algorithm is unsupported or the file doesn't exist.
 	GetHash(path string, algo string) (string, error)
+}
 
+// HashAlgorithmLister is an optional interface a Hasher can implement to
+// list the algorithms it supports, which FEAT advertises and OPTS HASH
+// accepts. SHA-256 stays selected if listed; otherwise the first algorithm
+// is. When it is not implemented, SHA-1, SHA-256, SHA-512, MD5 and CRC32
+// are offered.
+type HashAlgorithmLister interface {
+	// HashAlgorithms returns the names of the algorithms, such as
+	// "SHA-256", in the order FEAT lists them.
+	HashAlgorithms() []string
+}
+
+// TimeSetter is an optional interface a ClientContext can implement to set
+// modification times with the MFMT command and the Modify fact of MFF. When
+// it is not implemented, MFMT replies 502 and is not listed in FEAT.
+type TimeSetter interface {
 	// SetTime sets the modification time of a file.
-	// Used by the MFMT command.
 	// Returns os.ErrNotExist if the file doesn't exist.
 	SetTime(path string, t time.Time) error
+}
 
+// CreationTimeSetter is an optional interface a ClientContext can implement
+// to set creation times with the MFCT command and the Create fact of MFF.
+// When it is not implemented, MFCT replies 502 and is not listed in FEAT.
+type CreationTimeSetter interface {
+	// SetCreationTime sets the creation time of a file.
+	// Returns os.ErrNotExist if the file doesn't exist.
+	SetCreationTime(path string, t time.Time) error
+}
+
+// Chmodder is an optional interface a ClientContext can implement to change
+// permissions with the SITE CHMOD command and the UNIX.mode fact of MFF.
+// When it is not implemented, SITE CHMOD replies 502.
+type Chmodder interface {
 	// Chmod changes the mode of the file.
-	// Used by the SITE CHMOD command.
 	// Returns os.ErrNotExist if the file doesn't exist.
 	Chmod(path string, mode os.FileMode) error
+}
 
-	// Close releases any resources associated with this context.
-	// Called when the client disconnects.
-	Close() error
+// Chowner is an optional interface a ClientContext can implement to change
+// the owner and group of files with SITE CHOWN, SITE CHGRP and the
+// UNIX.owner and UNIX.group facts of MFF, for users with PrivilegeChown.
+// When it is not implemented, these commands reply 502 (504 for MFF).
+type Chowner interface {
+	// Chown changes the owner and group of the file. Each is a name or a
+	// numeric ID, as sent by the client; an empty one is left unchanged.
+	// Returns os.ErrNotExist if the file doesn't exist, and os.ErrInvalid
+	// if the user or group is unknown.
+	Chown(path, owner, group string) error
+}
 
-	// GetSettings returns the session settings for passive mode configuration.
-	// May return nil if no special settings are needed.
-	GetSettings() *Settings
+// Ranger is an optional interface a ClientContext can implement to read part
+// of a file, for resumed downloads (REST) and byte ranges (RANG), on backends
+// whose files cannot seek, such as object stores supporting ranged reads.
+// When it is not implemented, the file returned by OpenFile is positioned
+// with io.Seeker, the transfer fails with 550 if it cannot seek, and RANG is
+// not listed in FEAT.
+type Ranger interface {
+	// OpenRange opens a file for reading length bytes from offset, or up to
+	// the end of the file if length is negative.
+	// Returns os.ErrNotExist if the file doesn't exist.
+	OpenRange(path string, offset, length int64) (io.ReadCloser, error)
+}
+
+// SpaceReporter is an optional interface a ClientContext can implement to
+// report free space with the AVBL and SITE FREESPACE commands. When it is not
+// implemented, those commands reply 502.
+type SpaceReporter interface {
+	// GetAvailableSpace returns the number of bytes the user can still write
+	// in the directory containing path (or in path, if it is a directory).
+	// Returns os.ErrNotExist if the path doesn't exist, and
+	// errors.ErrUnsupported if the space cannot be determined.
+	GetAvailableSpace(path string) (int64, error)
+}
+
+// Symlinker is an optional interface a ClientContext can implement to expose
+// symbolic links. When it is implemented, LIST shows links as "name -> target"
+// and SITE SYMLINK can create them, if enabled with WithSiteSymlink. Listed
+// entries are reported as links when their mode has os.ModeSymlink set.
+type Symlinker interface {
+	// ReadLink returns the target of the symbolic link at path, as a path
+	// the client can use. Returns os.ErrNotExist if the path doesn't exist.
+	ReadLink(path string) (string, error)
+
+	// Symlink creates a symbolic link at linkPath pointing to target.
+	// Returns os.ErrExist if linkPath already exists.
+	Symlink(target, linkPath string) error
+}
+
+// ContextHasher is the form of Hasher taking a context, for
+// ContextClientContext implementations.
+type ContextHasher interface {
+	GetHash(ctx context.Context, path string, algo string) (string, error)
+}
+
+// ContextTimeSetter is the form of TimeSetter taking a context, for
+// ContextClientContext implementations.
+type ContextTimeSetter interface {
+	SetTime(ctx context.Context, path string, t time.Time) error
+}
+
+// ContextCreationTimeSetter is the form of CreationTimeSetter taking a
+// context, for ContextClientContext implementations.
+type ContextCreationTimeSetter interface {
+	SetCreationTime(ctx context.Context, path string, t time.Time) error
+}
+
+// ContextChmodder is the form of Chmodder taking a context, for
+// ContextClientContext implementations.
+type ContextChmodder interface {
+	Chmod(ctx context.Context, path string, mode os.FileMode) error
+}
+
+// ContextChowner is the form of Chowner taking a context, for
+// ContextClientContext implementations.
+type ContextChowner interface {
+	Chown(ctx context.Context, path, owner, group string) error
+}
+
+// ContextRanger is the form of Ranger taking a context, for
+// ContextClientContext implementations.
+type ContextRanger interface {
+	OpenRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error)
 }
 
 // Settings defines server configuration for passive mode and other features.
@@ -141,4 +374,54 @@ type Settings struct {
 	// Default (if 0) depends on implementation (often 0).
 	// It is subtracted from the default permissions (0666 for files, 0777 for dirs).
 	Umask int
+
+	// UploadPolicy, if set, overrides the server-wide policy set with
+	// WithUploadPolicy for this session. Drivers can return per-user settings
+	// to give users different limits.
+	UploadPolicy *UploadPolicy
+
+	// BandwidthClass, if set, names the bandwidth class of this session's
+	// user, one of those given with WithBandwidthClasses. Drivers can
+	// return per-user settings to give users different transfer rates.
+	BandwidthClass string
+
+	// UploadBufferSize, if positive, makes the FSDriver buffer uploaded
+	// data in memory and write it to files in blocks of this many bytes,
+	// saving system calls when clients send data in small pieces.
+	UploadBufferSize int
+
+	// UploadSync makes the FSDriver flush uploaded files to stable storage
+	// (fsync) before closing them, so that completed uploads survive a
+	// crash or power failure. It slows down uploads, markedly so for many
+	// small files.
+	UploadSync bool
+
+	// UploadDirectIO asks the FSDriver to write uploads with O_DIRECT,
+	// bypassing the page cache so that very large streaming uploads do
+	// not evict other cached files. Uploads are then buffered, in 1 MiB
+	// blocks if UploadBufferSize is not set, rounded up to a multiple of
+	// 4 KiB. It is a hint, ignored on systems other than Linux, on
+	// filesystems without O_DIRECT (such as tmpfs), and for appends and
+	// resumed uploads.
+	UploadDirectIO bool
+
+	// Privileges are the administrative commands the user may run. None
+	// are granted by default.
+	Privileges Privileges
 }
+
+// Privileges are administrative rights granted to a user through
+// Settings, beyond reading and writing files. Admin users migrating from
+// servers such as ProFTPD expect them.
+type Privileges uint
+
+const (
+	// PrivilegeChown allows changing the owner and group of files with
+	// SITE CHOWN, SITE CHGRP and the UNIX.owner and UNIX.group facts of
+	// MFF. The driver must implement Chowner.
+	PrivilegeChown Privileges = 1 << iota
+
+	// PrivilegeUtime allows setting file times with SITE UTIME. The
+	// driver must implement TimeSetter.
+	PrivilegeUtime
+)
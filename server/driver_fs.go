@@ -8,12 +8,19 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"hash/crc32"
 	"io"
+	"iter"
+	"maps"
 	"net"
 	"os"
+	"os/user"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -43,6 +50,10 @@ type FSDriver struct {
 	// Returns: rootPath, readOnly, error
 	authenticator func(user, pass, host string, remoteIP net.IP) (string, bool, error)
 
+	// profiles is an optional hook like authenticator, returning a profile
+	// with mount points. It takes precedence over authenticator.
+	profiles func(user, pass, host string, remoteIP net.IP) (*UserProfile, error)
+
 	// disableAnonymous, if true, prevents the default behavior of allowing anonymous
 	// logins when no authenticator is provided.
 	//
@@ -56,7 +67,68 @@ type FSDriver struct {
 	// Default is false (read-only).
 	enableAnonWrite bool
 
+	// anonDropbox is the upload-only directory for anonymous users, relative
+	// to the root ("" = none). See WithAnonDropbox.
+	anonDropbox string
+
 	settings *Settings // Optional server settings
+
+	// userSettings optionally returns per-user settings, overriding settings
+	userSettings func(user string) *Settings
+
+	// symlinks controls whether symbolic links are followed.
+	// Default is SymlinksWithinRoot.
+	symlinks SymlinkPolicy
+
+	// homeTemplate is the home directory of users whose authenticator
+	// returns none, with {user} replaced by the user name (see
+	// WithHomeTemplate)
+	homeTemplate string
+
+	// autoCreateHome creates missing home directories at login with
+	// homeMode permissions, copying homeSkeleton into them if set (see
+	// WithAutoCreateHome)
+	autoCreateHome bool
+	homeMode       os.FileMode
+	homeSkeleton   string
+}
+
+// SymlinkPolicy controls how FSDriver follows symbolic links.
+// See WithFollowSymlinks.
+type SymlinkPolicy int
+
+const (
+	// SymlinksWithinRoot follows relative links whose target is inside the
+	// user's root. Links that escape the root cannot be followed. This is the
+	// default.
+	SymlinksWithinRoot SymlinkPolicy = iota
+
+	// SymlinksNever never follows links. Links are still listed, and can be
+	// deleted or renamed, but cannot be opened, entered or created.
+	SymlinksNever
+)
+
+// UserProfile describes the virtual tree of a user, as returned by the
+// function set with WithUserProfiles: a home directory shown as "/", plus
+// directories mounted on top of it.
+type UserProfile struct {
+	HomeDir    string     // Directory shown as the root of the tree (must exist)
+	ReadOnly   bool       // Restricts the home directory to read-only operations
+	Mounts     []Mount    // Additional directories, such as shared areas
+	Privileges Privileges // Administrative commands allowed, added to the settings
+}
+
+// Mount grafts a local directory into a user's virtual tree.
+type Mount struct {
+	// Path is the mount point, a top-level directory such as "/pub". It
+	// hides any entry with the same name in the home directory.
+	Path string
+
+	// Dir is the local directory mounted there (must exist).
+	Dir string
+
+	// ReadOnly restricts the mount to read-only operations.
+	ReadOnly bool
 }
 
 // FSDriverOption is a functional option for configuring an FSDriver.
@@ -138,7 +210,8 @@ func NewFSDriver(rootPath string, options ...FSDriverOption) (*FSDriver, error)
 //   - remoteIP: client IP address (net.IP) for IP-based access control
 //
 // The function should return:
-//   - rootPath: the root directory for this user (must exist)
+//   - rootPath: the root directory for this user (must exist, unless
+//     WithAutoCreateHome is set; if empty, WithHomeTemplate sets it)
 //   - readOnly: true to restrict user to read-only operations
 //   - error: authentication error (use os.ErrPermission for invalid credentials)
 //
@@ -157,6 +230,35 @@ func WithAuthenticator(fn func(user, pass, host string, remoteIP net.IP) (string
 	}
 }
 
+// WithUserProfiles sets an authentication function returning a UserProfile,
+// for users whose tree is made of several directories: for example a private
+// home, a read-only shared /pub and a group-writable /team. It replaces
+// WithAuthenticator, taking the same arguments.
+//
+// Each mount point is a separate os.Root, so paths are confined to the mount
+// they start in, and files cannot be renamed from one mount to another.
+//
+// Example:
+//
+//	server.WithUserProfiles(func(user, pass, host string, remoteIP net.IP) (*server.UserProfile, error) {
+//	    dbUser, err := db.ValidateUser(user, pass)
+//	    if err != nil {
+//	        return nil, os.ErrPermission
+//	    }
+//	    return &server.UserProfile{
+//	        HomeDir: filepath.Join("/srv/home", user),
+//	        Mounts: []server.Mount{
+//	            {Path: "/pub", Dir: "/srv/pub", ReadOnly: true},
+//	            {Path: "/team", Dir: filepath.Join("/srv/teams", dbUser.Team)},
+//	        },
+//	    }, nil
+//	})
+func WithUserProfiles(fn func(user, pass, host string, remoteIP net.IP) (*UserProfile, error)) FSDriverOption {
+	return func(d *FSDriver) {
+		d.profiles = fn
+	}
+}
+
 // WithDisableAnonymous disables anonymous login.
 // When enabled, only users authenticated via a custom Authenticator are allowed.
 //
@@ -184,6 +286,33 @@ func WithAnonWrite(enable bool) FSDriverOption {
 	}
 }
 
+// WithAnonDropbox makes dir, a path from the root, a write-only "dropbox"
+// for anonymous users: they may upload new files into it, but cannot
+// download, list, overwrite, rename or delete anything there. The rest of the
+// tree stays read-only unless WithAnonWrite is also used. The directory must
+// exist.
+//
+// Files uploaded during a session may still be removed by that session, so
+// that failed and atomic uploads can be cleaned up. Clients that need a name
+// chosen by the server should use STOU.
+//
+// This option only affects the default authentication behavior, like
+// WithAnonWrite.
+//
+// Example:
+//
+//	driver, _ := server.NewFSDriver("/srv/ftp",
+//	    server.WithAnonDropbox("/incoming"),
+//	)
+func WithAnonDropbox(dir string) FSDriverOption {
+	return func(d *FSDriver) {
+		d.anonDropbox = strings.TrimPrefix(filepath.Clean("/"+dir), "/")
+		if d.anonDropbox == "" {
+			d.anonDropbox = "."
+		}
+	}
+}
+
 // WithSettings sets server-specific settings for the driver.
 // These settings configure passive mode behavior and other server features.
 //
@@ -203,16 +332,107 @@ func WithSettings(settings *Settings) FSDriverOption {
 	}
 }
 
+// WithUserSettings sets a function returning the settings for each user,
+// such as a per-user UploadPolicy. It is called after successful
+// authentication; if it returns nil, the settings from WithSettings are used.
+//
+// Example:
+//
+//	driver, _ := server.NewFSDriver("/tmp/ftp",
+//	    server.WithAuthenticator(validateUser),
+//	    server.WithUserSettings(func(user string) *server.Settings {
+//	        if user == "uploader" {
+//	            return &server.Settings{UploadPolicy: &server.UploadPolicy{MaxFileSize: 1 << 30}}
+//	        }
+//	        return nil
+//	    }),
+//	)
+func WithUserSettings(fn func(user string) *Settings) FSDriverOption {
+	return func(d *FSDriver) {
+		d.userSettings = fn
+	}
+}
+
+// WithFollowSymlinks sets how symbolic links are followed.
+// By default (SymlinksWithinRoot), links are followed as long as they stay
+// inside the user's root, which suits mirrors backed by link farms. Only
+// relative links are followed: os.Root rejects absolute targets. With
+// SymlinksNever, any operation that would go through a link fails with
+// os.ErrPermission.
+//
+// Example:
+//
+//	driver, _ := server.NewFSDriver("/srv/ftp",
+//	    server.WithFollowSymlinks(server.SymlinksNever),
+//	)
+func WithFollowSymlinks(policy SymlinkPolicy) FSDriverOption {
+	return func(d *FSDriver) {
+		d.symlinks = policy
+	}
+}
+
+// WithHomeTemplate sets the home directory of users whose authenticator, or
+// profile function, returns an empty root path. Each "{user}" in template is
+// replaced by the user name; a relative template is taken relative to the
+// driver's root path. User names that are not a valid single path element,
+// such as "../x", are refused.
+//
+// Combined with WithAutoCreateHome, authenticators only have to validate
+// credentials.
+//
+// Example:
+//
+//	driver, _ := server.NewFSDriver("/srv/ftp",
+//	    server.WithAuthenticator(func(user, pass, host string, remoteIP net.IP) (string, bool, error) {
+//	        if !validateUser(user, pass) {
+//	            return "", false, os.ErrPermission
+//	        }
+//	        return "", false, nil // /srv/ftp/home/<user>
+//	    }),
+//	    server.WithHomeTemplate("/srv/ftp/home/{user}"),
+//	    server.WithAutoCreateHome(0750, "/etc/ftp/skel"),
+//	)
+func WithHomeTemplate(template string) FSDriverOption {
+	return func(d *FSDriver) {
+		d.homeTemplate = template
+	}
+}
+
+// WithAutoCreateHome creates the home directory of a user at login if it
+// does not exist yet, instead of failing the login. The directory gets the
+// permissions in mode and, if skeleton is not empty, a copy of the contents
+// of the skeleton directory. Its parent directory must exist.
+//
+// The home directory is prepared under a temporary name and renamed into
+// place, so concurrent logins of a new user never see a partial copy of the
+// skeleton.
+func WithAutoCreateHome(mode os.FileMode, skeleton string) FSDriverOption {
+	return func(d *FSDriver) {
+		d.autoCreateHome = true
+		d.homeMode = mode.Perm()
+		d.homeSkeleton = skeleton
+	}
+}
+
 // Authenticate returns a new FSContext for the user.
-// It uses the authenticator hook if provided. Otherwise, it enforces strict
-// anonymous-only, read-only access rooted at the root path.
+// It uses the profile or authenticator hook if provided. Otherwise, it
+// enforces strict anonymous-only, read-only access rooted at the root path.
 func (d *FSDriver) Authenticate(user, pass, host string, remoteIP net.IP) (ClientContext, error) {
-	rootPath := d.rootPath
-	readOnly := false
+	profile := &UserProfile{HomeDir: d.rootPath}
+	dropbox := ""
 
-	if d.authenticator != nil {
+	if d.profiles != nil {
+		var err error
+		profile, err = d.profiles(user, pass, host, remoteIP)
+		if err != nil {
+			return nil, err
+		}
+		if profile == nil {
+			return nil, os.ErrPermission
+		}
+	} else if d.authenticator != nil {
 		var err error
-		rootPath, readOnly, err = d.authenticator(user, pass, host, remoteIP)
+		profile.HomeDir, profile.ReadOnly, err = d.authenticator(user, pass, host, remoteIP)
 		if err != nil {
 			return nil, err
 		}
@@ -225,44 +445,134 @@ func (d *FSDriver) Authenticate(user, pass, host string, remoteIP net.IP) (Clien
 			return nil, errors.New("only anonymous login allowed")
 		}
 		// Anonymous access: read-only unless explicitly enabled
-		readOnly = !d.enableAnonWrite
+		profile.ReadOnly = !d.enableAnonWrite
+		dropbox = d.anonDropbox
 	}
 
-	// Open the root directory safely
-	root, err := os.OpenRoot(rootPath)
-	if err != nil {
+	if profile.HomeDir == "" && d.homeTemplate != "" {
+		var err error
+		if profile.HomeDir, err = d.expandHome(user); err != nil {
+			return nil, err
+		}
+	}
+	if d.autoCreateHome {
+		if err := d.createHome(profile.HomeDir); err != nil {
+			return nil, err
+		}
+	}
+
+	settings := d.settings
+	if d.userSettings != nil {
+		if s := d.userSettings(user); s != nil {
+			settings = s
+		}
+	}
+	if profile.Privileges != 0 {
+		s := Settings{}
+		if settings != nil {
+			s = *settings
+		}
+		s.Privileges |= profile.Privileges
+		settings = &s
+	}
+
+	c := &fsContext{
+		cwd:      "/",
+		settings: settings,
+		symlinks: d.symlinks,
+		dropbox:  dropbox,
+	}
+
+	// Open the root directories safely
+	var err error
+	if c.home, err = openMount(profile.HomeDir, "", profile.ReadOnly); err != nil {
 		return nil, err
 	}
+	for _, mount := range profile.Mounts {
+		name := strings.Trim(filepath.Clean("/"+mount.Path), "/")
+		if name == "" || strings.Contains(name, "/") || c.mounts[name] != nil {
+			c.Close()
+			return nil, fmt.Errorf("invalid mount point: %q", mount.Path)
+		}
+		m, err := openMount(mount.Dir, "/"+name, mount.ReadOnly)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		if c.mounts == nil {
+			c.mounts = make(map[string]*fsMount)
+		}
+		c.mounts[name] = m
+	}
 
-	return &fsContext{
-		rootHandle: root,
-		rootPath:   rootPath,
-		cwd:        "/",
-		readOnly:   readOnly,
-		settings:   d.settings,
-	}, nil
+	return c, nil
+}
+
+// fsMount is a directory of the user's virtual tree: the home directory or
+// a mount point.
+type fsMount struct {
+	root     *os.Root
+	path     string // Local directory
+	prefix   string // Virtual path of the mount point ("" for the home)
+	readOnly bool
+}
+
+// openMount opens the directory at path as the mount at prefix.
+func openMount(path, prefix string, readOnly bool) (*fsMount, error) {
+	root, err := os.OpenRoot(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fsMount{root: root, path: path, prefix: prefix, readOnly: readOnly}, nil
 }
 
 // fsContext implements ClientContext for the local filesystem.
 // It tracks the current working directory and ensures all operations
-// are jailed within the root handle.
+// are jailed within the root handles.
 type fsContext struct {
-	rootHandle *os.Root
-	rootPath   string
-	cwd        string
-	readOnly   bool
-	settings   *Settings
+	home     *fsMount
+	mounts   map[string]*fsMount // Top-level mount points, by name
+	cwd      string
+	settings *Settings
+	symlinks SymlinkPolicy
+
+	// dropbox is the write-only directory relative to the root ("" = none),
+	// and created holds the files this session uploaded into it.
+	dropbox string
+	created map[string]bool
 }
 
-// Close closes the underlying root directory handle.
+// Close closes the underlying root directory handles.
 // This is essential to release file descriptors.
 func (c *fsContext) Close() error {
-	return c.rootHandle.Close()
+	err := c.home.root.Close()
+	for _, m := range c.mounts {
+		if closeErr := m.root.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// resolve returns the mount holding path and the path relative to its root
+// handle, for operations that follow symbolic links.
+func (c *fsContext) resolve(path string) (*fsMount, string, error) {
+	return c.resolveLinks(path, true)
 }
 
-// resolve returns the path relative to the root handle.
-// It ensures the path does not escape the root.
-func (c *fsContext) resolve(path string) (string, error) {
+// resolveNoFollow returns the mount holding path and the path relative to
+// its root handle, for operations that act on a symbolic link itself rather
+// than its target, such as deleting or renaming it.
+func (c *fsContext) resolveNoFollow(path string) (*fsMount, string, error) {
+	return c.resolveLinks(path, false)
+}
+
+// resolveLinks returns the mount holding path and the path relative to its
+// root handle.
+// It ensures the path does not escape the root. With SymlinksNever, it also
+// fails if a parent directory is a symbolic link, or if the path itself is one
+// and follow is true.
+func (c *fsContext) resolveLinks(path string, follow bool) (*fsMount, string, error) {
 	// 1. Handle absolute paths (virtual root /)
 	if strings.HasPrefix(path, "/") {
 		// path is absolute in virtual fs
@@ -276,7 +586,7 @@ func (c *fsContext) resolve(path string) (string, error) {
 
 	// 3. Ensure it starts with / and strip it for relative usage
 	if !strings.HasPrefix(path, "/") {
-		return "", errors.New("invalid path")
+		return nil, "", errors.New("invalid path")
 	}
 
 	// 4. Strip leading slash to get path relative to root handle
@@ -287,19 +597,84 @@ func (c *fsContext) resolve(path string) (string, error) {
 		rel = "."
 	}
 
-	return rel, nil
+	// 5. Paths under a mount point are relative to the mount
+	m := c.home
+	if len(c.mounts) > 0 {
+		name, rest, _ := strings.Cut(rel, "/")
+		if mount := c.mounts[name]; mount != nil {
+			m, rel = mount, rest
+			if rel == "" {
+				rel = "."
+			}
+		}
+	}
+
+	if c.symlinks == SymlinksNever && rel != "." {
+		if err := checkNoSymlinks(m, rel, follow); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return m, rel, nil
+}
+
+// checkNoSymlinks fails with os.ErrPermission if a component of rel is a
+// symbolic link. The last component is only checked if follow is true.
+// Checking stops at the first component that doesn't exist.
+func checkNoSymlinks(m *fsMount, rel string, follow bool) error {
+	parts := strings.Split(rel, "/")
+	if !follow {
+		parts = parts[:len(parts)-1]
+	}
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		info, err := m.root.Lstat(prefix)
+		if err != nil {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return &os.PathError{Op: "lstat", Path: "/" + prefix, Err: os.ErrPermission}
+		}
+	}
+	return nil
+}
+
+// isMountPoint reports whether rel in m is the root of a mount point, which
+// cannot be removed or renamed.
+func (c *fsContext) isMountPoint(m *fsMount, rel string) bool {
+	return m != c.home && rel == "."
+}
+
+// inDropbox reports whether rel in m is inside the write-only dropbox
+// directory.
+func (c *fsContext) inDropbox(m *fsMount, rel string) bool {
+	if m != c.home {
+		return false
+	}
+	switch c.dropbox {
+	case "":
+		return false
+	case ".":
+		return true
+	}
+	return rel == c.dropbox || strings.HasPrefix(rel, c.dropbox+"/")
+}
+
+// ownUpload reports whether rel was uploaded to the dropbox by this session.
+func (c *fsContext) ownUpload(rel string) bool {
+	return c.created[rel]
 }
 
 // ChangeDir changes the current working directory.
 // It verifies the destination exists and is a directory.
 func (c *fsContext) ChangeDir(path string) error {
-	rel, err := c.resolve(path)
+	m, rel, err := c.resolve(path)
 	if err != nil {
 		return err
 	}
 
 	// Validate it exists and is a directory
-	info, err := c.rootHandle.Stat(rel)
+	info, err := m.root.Stat(rel)
 	if err != nil {
 		return err
 	}
@@ -327,70 +702,103 @@ func (c *fsContext) GetWd() (string, error) {
 
 // MakeDir creates a new directory with 0755 permissions.
 func (c *fsContext) MakeDir(path string) error {
-	if c.readOnly {
-		return os.ErrPermission
-	}
-	rel, err := c.resolve(path)
+	m, rel, err := c.resolve(path)
 	if err != nil {
 		return err
 	}
+	if m.readOnly || c.inDropbox(m, rel) {
+		return os.ErrPermission
+	}
 	settings := c.GetSettings()
 	mode := os.FileMode(0755)
 	if settings != nil && settings.Umask > 0 {
 		mode = os.FileMode(0777 &^ settings.Umask)
 	}
-	return c.rootHandle.Mkdir(rel, mode)
+	return m.root.Mkdir(rel, mode)
 }
 
 // RemoveDir removes a directory and its contents.
 func (c *fsContext) RemoveDir(path string) error {
-	if c.readOnly {
-		return os.ErrPermission
-	}
-	rel, err := c.resolve(path)
+	m, rel, err := c.resolveNoFollow(path)
 	if err != nil {
 		return err
 	}
-	return c.rootHandle.Remove(rel)
+	if m.readOnly || c.inDropbox(m, rel) || c.isMountPoint(m, rel) {
+		return os.ErrPermission
+	}
+	return m.root.Remove(rel)
 }
 
 // DeleteFile removes a file.
+// In the dropbox, only files uploaded by this session can be removed.
 func (c *fsContext) DeleteFile(path string) error {
-	if c.readOnly {
-		return os.ErrPermission
-	}
-	rel, err := c.resolve(path)
+	m, rel, err := c.resolveNoFollow(path)
 	if err != nil {
 		return err
 	}
-	return c.rootHandle.Remove(rel)
+	if c.inDropbox(m, rel) {
+		if !c.ownUpload(rel) {
+			return os.ErrPermission
+		}
+		delete(c.created, rel)
+	} else if m.readOnly || c.isMountPoint(m, rel) {
+		return os.ErrPermission
+	}
+	return m.root.Remove(rel)
 }
 
 // Rename moves or renames a file or directory.
+// In the dropbox, only files uploaded by this session can be renamed, and
+// only to a new name in the dropbox, as atomic uploads do. Files cannot be
+// moved from one mount point to another.
 func (c *fsContext) Rename(fromPath, toPath string) error {
-	if c.readOnly {
-		return os.ErrPermission
-	}
-	srcRel, err := c.resolve(fromPath)
+	m, srcRel, err := c.resolveNoFollow(fromPath)
 	if err != nil {
 		return err
 	}
-	dstRel, err := c.resolve(toPath)
+	dm, dstRel, err := c.resolveNoFollow(toPath)
 	if err != nil {
 		return err
 	}
+	if c.isMountPoint(m, srcRel) || c.isMountPoint(dm, dstRel) {
+		return os.ErrPermission
+	}
+	if dm != m {
+		return &os.LinkError{Op: "rename", Old: fromPath, New: toPath, Err: syscall.EXDEV}
+	}
 
-	return c.rootHandle.Rename(srcRel, dstRel)
+	if c.inDropbox(m, srcRel) || c.inDropbox(m, dstRel) {
+		if !c.ownUpload(srcRel) || !c.inDropbox(m, dstRel) {
+			return os.ErrPermission
+		}
+		if _, err := m.root.Lstat(dstRel); err == nil {
+			return &os.PathError{Op: "rename", Path: toPath, Err: os.ErrExist}
+		}
+		if err := m.root.Rename(srcRel, dstRel); err != nil {
+			return err
+		}
+		delete(c.created, srcRel)
+		c.created[dstRel] = true
+		return nil
+	}
+	if m.readOnly {
+		return os.ErrPermission
+	}
+
+	return m.root.Rename(srcRel, dstRel)
 }
 
 // ListDir returns a list of files in the specified directory.
 func (c *fsContext) ListDir(path string) ([]os.FileInfo, error) {
-	rel, err := c.resolve(path)
+	m, rel, err := c.resolve(path)
 	if err != nil {
 		return nil, err
 	}
+	if c.inDropbox(m, rel) {
+		return nil, os.ErrPermission
+	}
 
-	f, err := c.rootHandle.Open(rel)
+	f, err := m.root.Open(rel)
 	if err != nil {
 		return nil, err
 	}
@@ -401,29 +809,119 @@ func (c *fsContext) ListDir(path string) ([]os.FileInfo, error) {
 		return nil, err
 	}
 
-	infos := make([]os.FileInfo, 0, len(entries))
+	mounts := c.mountEntries(m, rel)
+	infos := make([]os.FileInfo, 0, len(entries)+len(mounts))
 	for _, entry := range entries {
+		if mounts != nil && c.mounts[entry.Name()] != nil {
+			continue
+		}
 		info, err := entry.Info()
 		if err == nil {
 			infos = append(infos, info)
 		}
 	}
-	return infos, nil
+	return append(infos, mounts...), nil
 }
 
-// OpenFile opens a file for transfer (reading or writing).
-func (c *fsContext) OpenFile(path string, flag int) (io.ReadWriteCloser, error) {
-	if c.readOnly {
-		// Check if any write flags are set
-		if flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_APPEND != 0 {
-			return nil, os.ErrPermission
+// mountEntries returns the mount points to add to a listing of rel in m:
+// those of the root directory, or nil.
+func (c *fsContext) mountEntries(m *fsMount, rel string) []os.FileInfo {
+	if m != c.home || rel != "." || len(c.mounts) == 0 {
+		return nil
+	}
+	infos := make([]os.FileInfo, 0, len(c.mounts))
+	for _, name := range slices.Sorted(maps.Keys(c.mounts)) {
+		if info, err := c.mounts[name].root.Stat("."); err == nil {
+			infos = append(infos, renamedFileInfo{FileInfo: info, name: name})
 		}
 	}
-	rel, err := c.resolve(path)
+	return infos
+}
+
+// streamDirBatch is the number of entries ListDirStream reads at a time.
+const streamDirBatch = 256
+
+// ListDirStream returns an iterator over the files in the specified
+// directory. The directory is read in batches while iterating, rather than
+// all at once.
+func (c *fsContext) ListDirStream(path string) (iter.Seq2[os.FileInfo, error], error) {
+	m, rel, err := c.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	if c.inDropbox(m, rel) {
+		return nil, os.ErrPermission
+	}
+
+	// Validate now so errors are reported before the listing starts; the
+	// directory is only opened when iterating.
+	info, err := m.root.Stat(rel)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: syscall.ENOTDIR}
+	}
+
+	mounts := c.mountEntries(m, rel)
+	return func(yield func(os.FileInfo, error) bool) {
+		f, err := m.root.Open(rel)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer f.Close()
+
+		for {
+			entries, err := f.ReadDir(streamDirBatch)
+			for _, entry := range entries {
+				if mounts != nil && c.mounts[entry.Name()] != nil {
+					continue
+				}
+				info, err := entry.Info()
+				if os.IsNotExist(err) {
+					continue // Removed since it was read
+				}
+				if !yield(info, err) || err != nil {
+					return
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+		for _, info := range mounts {
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}, nil
+}
+
+// OpenFile opens a file for transfer (reading or writing).
+// In the dropbox, files can only be created, never read or overwritten.
+func (c *fsContext) OpenFile(path string, flag int) (io.ReadWriteCloser, error) {
+	m, rel, err := c.resolve(path)
 	if err != nil {
 		return nil, err
 	}
 
+	// Check if any write flags are set
+	write := flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_APPEND != 0
+	dropbox := c.inDropbox(m, rel)
+	if dropbox {
+		if flag&os.O_WRONLY == 0 || flag&os.O_CREATE == 0 {
+			return nil, os.ErrPermission
+		}
+		flag |= os.O_EXCL
+	} else if m.readOnly && write {
+		return nil, os.ErrPermission
+	}
+
 	// Calculate mode with umask
 	var mode os.FileMode
 	settings := c.GetSettings()
@@ -439,91 +937,261 @@ func (c *fsContext) OpenFile(path string, flag int) (io.ReadWriteCloser, error)
 	}
 
 	// os.Root.OpenFile(name, flag, perm)
-	return c.rootHandle.OpenFile(rel, flag, mode)
+	f, err := m.root.OpenFile(rel, flag, mode)
+	if err != nil {
+		return f, err
+	}
+	if dropbox {
+		if c.created == nil {
+			c.created = make(map[string]bool)
+		}
+		c.created[rel] = true
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return newUploadFile(f, flag, settings), nil
+	}
+	return f, nil
 }
 
 // GetFileInfo returns status information for a file or directory.
+// In the dropbox, only the directory itself and this session's uploads can
+// be examined.
 func (c *fsContext) GetFileInfo(path string) (os.FileInfo, error) {
-	rel, err := c.resolve(path)
+	m, rel, err := c.resolve(path)
 	if err != nil {
 		return nil, err
 	}
-	return c.rootHandle.Stat(rel)
+	if c.inDropbox(m, rel) && rel != c.dropbox && !c.ownUpload(rel) {
+		return nil, os.ErrPermission
+	}
+	return m.root.Stat(rel)
 }
 
 // GetHash calculates the hash of the file using the specified algorithm.
 // Supported algorithms: SHA-256, SHA-512, SHA-1, MD5, CRC32
 func (c *fsContext) GetHash(path string, algo string) (string, error) {
-	rel, err := c.resolve(path)
+	m, rel, err := c.resolve(path)
 	if err != nil {
 		return "", err
 	}
+	if c.inDropbox(m, rel) {
+		return "", os.ErrPermission
+	}
 
-	f, err := c.rootHandle.Open(rel)
+	f, err := m.root.Open(rel)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
 
-	var h interface {
-		io.Writer
-		Sum(b []byte) []byte
+	h, err := newHash(algo)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
 	}
 
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newHash returns a hash for one of the algorithms advertised with HASH.
+func newHash(algo string) (hash.Hash, error) {
 	switch strings.ToUpper(algo) {
 	case "SHA-256", "SHA256":
-		h = sha256.New()
+		return sha256.New(), nil
 	case "SHA-512", "SHA512":
-		h = sha512.New()
+		return sha512.New(), nil
 	case "SHA-1", "SHA1":
-		h = sha1.New()
+		return sha1.New(), nil
 	case "MD5":
-		h = md5.New()
+		return md5.New(), nil
 	case "CRC32":
-		h = crc32.NewIEEE()
-	default:
-		return "", errors.New("unsupported algorithm")
+		return crc32.NewIEEE(), nil
 	}
+	return nil, errors.New("unsupported algorithm")
+}
 
-	if _, err := io.Copy(h, f); err != nil {
+// OpenRange opens a file for reading from offset, for REST and RANG. The
+// file is returned as is, so downloads can still use sendfile; the server
+// stops reading after length bytes.
+func (c *fsContext) OpenRange(path string, offset, _ int64) (io.ReadCloser, error) {
+	f, err := c.OpenFile(path, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.(io.Seeker).Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// GetAvailableSpace returns the space available to unprivileged users on the
+// filesystem containing path. Used by the AVBL command.
+func (c *fsContext) GetAvailableSpace(path string) (int64, error) {
+	m, rel, err := c.resolve(path)
+	if err != nil {
+		return 0, err
+	}
+	return availableSpace(m.root, rel)
+}
+
+// ReadLink returns the target of a symbolic link. Absolute targets are
+// returned as paths from the user's root. Targets outside the root, or the
+// mount point holding the link, are not revealed: os.ErrPermission is
+// returned instead.
+func (c *fsContext) ReadLink(path string) (string, error) {
+	m, rel, err := c.resolveNoFollow(path)
+	if err != nil {
+		return "", err
+	}
+	if c.inDropbox(m, rel) {
+		return "", os.ErrPermission
+	}
+	target, err := m.root.Readlink(rel)
+	if err != nil {
 		return "", err
 	}
 
-	return hex.EncodeToString(h.Sum(nil)), nil
+	abs := target
+	if !filepath.IsAbs(target) {
+		abs = filepath.Join(m.path, filepath.Dir(rel), target)
+	}
+	inRoot, err := filepath.Rel(m.path, abs)
+	if err != nil || inRoot == ".." || strings.HasPrefix(inRoot, ".."+string(filepath.Separator)) {
+		return "", &os.PathError{Op: "readlink", Path: path, Err: os.ErrPermission}
+	}
+
+	if filepath.IsAbs(target) {
+		return filepath.ToSlash(filepath.Join("/", m.prefix, inRoot)), nil
+	}
+	return filepath.ToSlash(target), nil
+}
+
+// Symlink creates a symbolic link at linkPath pointing to target, which may
+// be absolute (from the user's root) or relative to the link's directory.
+// The link is stored with a relative target, so it stays valid when the root
+// is moved. Targets outside the root, or the mount point holding the link,
+// are rejected with os.ErrPermission.
+func (c *fsContext) Symlink(target, linkPath string) error {
+	if c.symlinks == SymlinksNever {
+		return os.ErrPermission
+	}
+	m, rel, err := c.resolveNoFollow(linkPath)
+	if err != nil {
+		return err
+	}
+	if m.readOnly || c.inDropbox(m, rel) {
+		return os.ErrPermission
+	}
+
+	dir := filepath.Dir(rel)
+	if strings.HasPrefix(target, "/") {
+		target, err = filepath.Rel(filepath.Join("/", m.prefix, dir), filepath.Clean(target))
+		if err != nil {
+			return err
+		}
+	}
+	if t := filepath.Join(dir, target); t == ".." || strings.HasPrefix(t, "../") {
+		return &os.PathError{Op: "symlink", Path: linkPath, Err: os.ErrPermission}
+	}
+
+	return m.root.Symlink(target, rel)
 }
 
 // SetTime sets the modification time of a file.
 // Used by the MFMT command.
 func (c *fsContext) SetTime(path string, t time.Time) error {
-	if c.readOnly {
-		return os.ErrPermission
-	}
-	rel, err := c.resolve(path)
+	m, rel, err := c.resolve(path)
 	if err != nil {
 		return err
 	}
+	if m.readOnly || c.inDropbox(m, rel) {
+		return os.ErrPermission
+	}
 
-	return c.rootHandle.Chtimes(rel, t, t)
+	return m.root.Chtimes(rel, t, t)
 }
 
 // Chmod changes the mode of the file.
 // Used by the SITE CHMOD command.
 func (c *fsContext) Chmod(path string, mode os.FileMode) error {
-	if c.readOnly {
-		return os.ErrPermission
-	}
-
 	// Validate mode: only allow standard permission bits (0-777)
 	if mode > 0777 {
 		return os.ErrInvalid
 	}
 
-	rel, err := c.resolve(path)
+	m, rel, err := c.resolve(path)
 	if err != nil {
 		return err
 	}
+	if m.readOnly || c.inDropbox(m, rel) {
+		return os.ErrPermission
+	}
+
+	return m.root.Chmod(rel, mode)
+}
 
-	return c.rootHandle.Chmod(rel, mode)
+// Chown changes the owner and group of a file, given as names or numeric
+// IDs. Used by the SITE CHOWN and SITE CHGRP commands and MFF. Giving files
+// away to other users usually needs the server to run as root.
+func (c *fsContext) Chown(path, owner, group string) error {
+	uid, gid := -1, -1
+	var err error
+	if owner != "" {
+		if uid, err = lookupID(owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return fmt.Errorf("unknown user %q: %w", owner, os.ErrInvalid)
+		}
+	}
+	if group != "" {
+		if gid, err = lookupID(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return fmt.Errorf("unknown group %q: %w", group, os.ErrInvalid)
+		}
+	}
+
+	m, rel, err := c.resolve(path)
+	if err != nil {
+		return err
+	}
+	if m.readOnly || c.inDropbox(m, rel) {
+		return os.ErrPermission
+	}
+
+	return m.root.Chown(rel, uid, gid)
+}
+
+// lookupID returns the numeric ID of a user or group given by name or ID,
+// looking names up with lookup.
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	id, err := strconv.Atoi(name)
+	if err != nil {
+		s, lookupErr := lookup(name)
+		if lookupErr != nil {
+			return 0, lookupErr
+		}
+		// Windows IDs are SIDs, which Chown does not take anyway
+		if id, err = strconv.Atoi(s); err != nil {
+			return 0, err
+		}
+	}
+	if id < 0 {
+		return 0, os.ErrInvalid
+	}
+	return id, nil
 }
 
 func (c *fsContext) GetSettings() *Settings {
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// setDirectIO sets or clears O_DIRECT on f. Filesystems that do not support
+// it, such as tmpfs, fail with EINVAL.
+func setDirectIO(f *os.File, on bool) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	err = rc.Control(func(fd uintptr) {
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+		if errno != 0 {
+			opErr = errno
+			return
+		}
+		if on {
+			flags |= syscall.O_DIRECT
+		} else {
+			flags &^= syscall.O_DIRECT
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_SETFL, flags); errno != 0 {
+			opErr = errno
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if opErr != nil {
+		return &os.PathError{Op: "fcntl", Path: f.Name(), Err: opErr}
+	}
+	return nil
+}
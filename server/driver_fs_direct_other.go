@@ -0,0 +1,13 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"os"
+)
+
+// setDirectIO is only supported on Linux.
+func setDirectIO(f *os.File, on bool) error {
+	return errors.ErrUnsupported
+}
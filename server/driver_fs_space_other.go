@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package server
+
+import (
+	"errors"
+	"os"
+)
+
+// availableSpace is not implemented on this platform.
+func availableSpace(_ *os.Root, _ string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// availableSpace returns the bytes available to unprivileged users on the
+// filesystem containing rel. The file is opened through root, so the check
+// cannot escape it.
+func availableSpace(root *os.Root, rel string) (int64, error) {
+	f, err := root.Open(rel)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var st syscall.Statfs_t
+	if err := syscall.Fstatfs(int(f.Fd()), &st); err != nil {
+		return 0, &os.PathError{Op: "fstatfs", Path: rel, Err: err}
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// availableSpace returns the bytes available to the user on the volume
+// containing rel. The path is checked through root first, so the check
+// cannot escape it.
+func availableSpace(root *os.Root, rel string) (int64, error) {
+	if _, err := root.Stat(rel); err != nil {
+		return 0, err
+	}
+
+	path, err := syscall.UTF16PtrFromString(filepath.Join(root.Name(), rel))
+	if err != nil {
+		return 0, err
+	}
+
+	var free uint64
+	r, _, err := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&free)), 0, 0)
+	if r == 0 {
+		return 0, &os.PathError{Op: "GetDiskFreeSpaceEx", Path: rel, Err: err}
+	}
+	return int64(free), nil
+}
@@ -1,13 +1,21 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
 	"net"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/gonzalop/ftp"
 )
 
 func TestFSDriver_DisableAnonymous(t *testing.T) {
@@ -323,7 +331,7 @@ func TestFSContext_SetTime(t *testing.T) {
 
 	// Valid time
 	newTime := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
-	if err := ctx.SetTime("/test.txt", newTime); err != nil {
+	if err := ctx.(TimeSetter).SetTime("/test.txt", newTime); err != nil {
 		t.Errorf("SetTime failed: %v", err)
 	}
 
@@ -335,7 +343,7 @@ func TestFSContext_SetTime(t *testing.T) {
 	}
 
 	// Invalid path
-	if err := ctx.SetTime("/nonexistent", newTime); err == nil {
+	if err := ctx.(TimeSetter).SetTime("/nonexistent", newTime); err == nil {
 		t.Error("Expected error for non-existent file")
 	}
 }
@@ -363,7 +371,7 @@ func TestFSContext_Chmod(t *testing.T) {
 	defer ctx.Close()
 
 	// Change to 0600
-	if err := ctx.Chmod("/test.txt", 0600); err != nil {
+	if err := ctx.(Chmodder).Chmod("/test.txt", 0600); err != nil {
 		t.Errorf("Chmod failed: %v", err)
 	}
 
@@ -376,13 +384,13 @@ func TestFSContext_Chmod(t *testing.T) {
 	}
 
 	// Invalid path
-	if err := ctx.Chmod("/nonexistent", 0600); err == nil {
+	if err := ctx.(Chmodder).Chmod("/nonexistent", 0600); err == nil {
 		t.Error("Expected error for non-existent file")
 	}
 
 	// Test that modes > 0777 are rejected at the driver level
 	// (Note: session layer also validates, but driver should be safe)
-	if err := ctx.Chmod("/test.txt", 04755); err == nil {
+	if err := ctx.(Chmodder).Chmod("/test.txt", 04755); err == nil {
 		t.Error("Expected error for setuid bit (mode > 0777)")
 	}
 }
@@ -415,7 +423,7 @@ func TestFSContext_GetHash(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.algo, func(t *testing.T) {
-			hash, err := ctx.GetHash("/test.txt", tt.algo)
+			hash, err := ctx.(Hasher).GetHash("/test.txt", tt.algo)
 			if tt.expectError {
 				if err == nil {
 					t.Error("Expected error for invalid algorithm")
@@ -444,3 +452,458 @@ func isHex(s string) bool {
 	}
 	return len(s) > 0
 }
+
+func TestFSContext_ListDirStream(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+
+	// More entries than a single ReadDir batch
+	const numFiles = streamDirBatch*2 + 10
+	for i := range numFiles {
+		fatalIfErr(t, os.WriteFile(filepath.Join(tempDir, fmt.Sprintf("f%04d", i)), nil, 0644), "WriteFile failed")
+	}
+
+	driver, err := NewFSDriver(tempDir,
+		WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+			return tempDir, true, nil
+		}),
+	)
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	ctx, err := driver.Authenticate("user", "pass", "", nil)
+	fatalIfErr(t, err, "Failed to authenticate")
+	defer ctx.Close()
+
+	streamer, ok := ctx.(DirStreamer)
+	if !ok {
+		t.Fatal("fsContext does not implement DirStreamer")
+	}
+
+	entries, err := streamer.ListDirStream("/")
+	fatalIfErr(t, err, "ListDirStream failed")
+	seen := make(map[string]bool)
+	for info, err := range entries {
+		fatalIfErr(t, err, "ListDirStream iteration failed")
+		seen[info.Name()] = true
+	}
+	if len(seen) != numFiles {
+		t.Errorf("ListDirStream returned %d entries, want %d", len(seen), numFiles)
+	}
+
+	// Stopping early is allowed
+	count := 0
+	for range entries {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Errorf("Iterated %d entries before break, want 1", count)
+	}
+
+	if _, err := streamer.ListDirStream("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ListDirStream on missing dir = %v, want os.ErrNotExist", err)
+	}
+	if _, err := streamer.ListDirStream("/f0000"); err == nil {
+		t.Error("ListDirStream on a file should fail")
+	}
+}
+
+// listDirOnly hides the DirStreamer implementation of a ClientContext.
+type listDirOnly struct {
+	ClientContext
+}
+
+func TestSession_ListDirStreamFallback(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		fatalIfErr(t, os.WriteFile(filepath.Join(tempDir, name), nil, 0644), "WriteFile failed")
+	}
+
+	driver, err := NewFSDriver(tempDir,
+		WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+			return tempDir, true, nil
+		}),
+	)
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	ctx, err := driver.Authenticate("user", "pass", "", nil)
+	fatalIfErr(t, err, "Failed to authenticate")
+	defer ctx.Close()
+
+	s := &session{fs: AdaptClientContext(ctx), driverFS: listDirOnly{ctx}, ctx: context.Background()}
+	entries, err := s.listDirStream("/")
+	fatalIfErr(t, err, "listDirStream failed")
+
+	var names []string
+	for info, err := range entries {
+		fatalIfErr(t, err, "listDirStream iteration failed")
+		names = append(names, info.Name())
+	}
+	slices.Sort(names)
+	if want := []string{"a", "b", "c"}; !slices.Equal(names, want) {
+		t.Errorf("listDirStream = %v, want %v", names, want)
+	}
+
+	if _, err := s.listDirStream("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("listDirStream on missing dir = %v, want os.ErrNotExist", err)
+	}
+}
+
+// failingStreamDriver lists the first two entries of a directory, then
+// fails to read the rest.
+type failingStreamDriver struct {
+	Driver
+}
+
+func (d *failingStreamDriver) Authenticate(user, pass, host string, remoteIP net.IP) (ClientContext, error) {
+	ctx, err := d.Driver.Authenticate(user, pass, host, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	return &failingStreamContext{ctx}, nil
+}
+
+type failingStreamContext struct {
+	ClientContext
+}
+
+func (c *failingStreamContext) ListDirStream(path string) (iter.Seq2[os.FileInfo, error], error) {
+	entries, err := c.ListDir(path)
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func(os.FileInfo, error) bool) {
+		for _, entry := range entries[:2] {
+			if !yield(entry, nil) {
+				return
+			}
+		}
+		yield(nil, errors.New("disk read error"))
+	}, nil
+}
+
+func TestListDirStream_ReadError(t *testing.T) {
+	t.Parallel()
+	c, rootDir := setupWrappedServer(t, func(d Driver) Driver { return &failingStreamDriver{d} })
+	for _, name := range []string{"a", "b", "c", "d"} {
+		fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, name), nil, 0644), "WriteFile failed")
+	}
+
+	// The truncated listings end with 451, not 226
+	expect451 := func(err error, what string) {
+		t.Helper()
+		var pe *ftp.ProtocolError
+		if !errors.As(err, &pe) || pe.Code != 451 {
+			t.Errorf("%s of a failing directory: got %v, want 451", what, err)
+		}
+	}
+	_, err := c.List("/")
+	expect451(err, "LIST")
+	_, err = c.NameList("/")
+	expect451(err, "NLST")
+	_, err = c.MLList("/")
+	expect451(err, "MLSD")
+
+	// The session is still usable
+	fatalIfErr(t, c.Noop(), "NOOP after incomplete listing failed")
+}
+
+// TestFSContext_AnonDropbox tests the write-only upload directory
+func TestFSContext_AnonDropbox(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	fatalIfErr(t, os.MkdirAll(filepath.Join(tempDir, "incoming"), 0755), "Failed to create dropbox")
+	fatalIfErr(t, os.WriteFile(filepath.Join(tempDir, "incoming", "other.txt"), []byte("secret"), 0644), "Failed to create file")
+	fatalIfErr(t, os.WriteFile(filepath.Join(tempDir, "pub.txt"), []byte("public"), 0644), "Failed to create file")
+
+	driver, err := NewFSDriver(tempDir, WithAnonDropbox("/incoming"))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	ctx, err := driver.Authenticate("anonymous", "guest", "", nil)
+	fatalIfErr(t, err, "Failed to authenticate")
+	defer ctx.Close()
+
+	// Uploading a new file works; overwriting does not
+	f, err := ctx.OpenFile("/incoming/new.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	fatalIfErr(t, err, "Upload into the dropbox failed")
+	f.Close()
+	if _, err := ctx.OpenFile("/incoming/other.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC); !os.IsExist(err) {
+		t.Errorf("Overwrite: got %v, want ErrExist", err)
+	}
+	if _, err := ctx.OpenFile("/incoming/other.txt", os.O_WRONLY|os.O_APPEND); !os.IsPermission(err) {
+		t.Errorf("Append: got %v, want ErrPermission", err)
+	}
+
+	// Nothing in the dropbox can be read or changed
+	denied := map[string]error{}
+	_, denied["read"] = ctx.OpenFile("/incoming/other.txt", os.O_RDONLY)
+	_, denied["list"] = ctx.ListDir("/incoming")
+	_, denied["stream"] = ctx.(DirStreamer).ListDirStream("/incoming")
+	_, denied["stat"] = ctx.GetFileInfo("/incoming/other.txt")
+	_, denied["hash"] = ctx.(*fsContext).GetHash("/incoming/new.txt", "SHA-256")
+	denied["delete"] = ctx.DeleteFile("/incoming/other.txt")
+	denied["rename"] = ctx.Rename("/incoming/other.txt", "/incoming/mine.txt")
+	denied["rename over"] = ctx.Rename("/incoming/new.txt", "/incoming/other.txt")
+	denied["rename out"] = ctx.Rename("/incoming/new.txt", "/new.txt")
+	denied["mkdir"] = ctx.MakeDir("/incoming/sub")
+	for op, err := range denied {
+		if err == nil {
+			t.Errorf("%s in the dropbox succeeded", op)
+		}
+	}
+
+	// The rest of the tree stays read-only
+	if _, err := ctx.OpenFile("/new.txt", os.O_WRONLY|os.O_CREATE); !os.IsPermission(err) {
+		t.Errorf("Upload outside the dropbox: got %v, want ErrPermission", err)
+	}
+	if _, err := ctx.ListDir("/"); err != nil {
+		t.Errorf("Listing the root failed: %v", err)
+	}
+	if err := ctx.ChangeDir("/incoming"); err != nil {
+		t.Errorf("Entering the dropbox failed: %v", err)
+	}
+
+	// The session's own uploads can be examined, renamed and removed
+	if _, err := ctx.GetFileInfo("/incoming/new.txt"); err != nil {
+		t.Errorf("Stat of own upload failed: %v", err)
+	}
+	fatalIfErr(t, ctx.Rename("/incoming/new.txt", "/incoming/renamed.txt"), "Rename of own upload failed")
+	fatalIfErr(t, ctx.DeleteFile("/incoming/renamed.txt"), "Delete of own upload failed")
+
+	// Other users are not affected
+	rw, err := NewFSDriver(tempDir, WithAnonDropbox("/incoming"), WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return tempDir, false, nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	ctx2, err := rw.Authenticate("admin", "pass", "", nil)
+	fatalIfErr(t, err, "Failed to authenticate")
+	defer ctx2.Close()
+	if _, err := ctx2.ListDir("/incoming"); err != nil {
+		t.Errorf("Authenticated user cannot list the dropbox: %v", err)
+	}
+}
+
+// TestFSDriver_UserProfiles tests trees made of several mount points
+func TestFSDriver_UserProfiles(t *testing.T) {
+	t.Parallel()
+	base := t.TempDir()
+	home := filepath.Join(base, "home")
+	pub := filepath.Join(base, "pub")
+	team := filepath.Join(base, "team")
+	for _, dir := range []string{home, pub, team, filepath.Join(home, "pub")} {
+		fatalIfErr(t, os.MkdirAll(dir, 0755), "Failed to create directory")
+	}
+	fatalIfErr(t, os.WriteFile(filepath.Join(home, "notes.txt"), []byte("mine"), 0644), "Failed to create file")
+	fatalIfErr(t, os.WriteFile(filepath.Join(pub, "readme.txt"), []byte("shared"), 0644), "Failed to create file")
+
+	driver, err := NewFSDriver(base, WithUserProfiles(func(user, pass, host string, _ net.IP) (*UserProfile, error) {
+		if user == "bad" {
+			return &UserProfile{HomeDir: home, Mounts: []Mount{{Path: "/a/b", Dir: pub}}}, nil
+		}
+		return &UserProfile{
+			HomeDir: home,
+			Mounts: []Mount{
+				{Path: "/pub", Dir: pub, ReadOnly: true},
+				{Path: "team", Dir: team},
+			},
+		}, nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	if _, err := driver.Authenticate("bad", "pass", "", nil); err == nil {
+		t.Error("Nested mount point was accepted")
+	}
+
+	ctx, err := driver.Authenticate("alice", "pass", "", nil)
+	fatalIfErr(t, err, "Failed to authenticate")
+	defer ctx.Close()
+
+	// The root lists the home directory and the mount points, which hide
+	// home entries with the same name
+	infos, err := ctx.ListDir("/")
+	fatalIfErr(t, err, "ListDir failed")
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+		if info.Name() != "notes.txt" && !info.IsDir() {
+			t.Errorf("Mount point %s is not a directory", info.Name())
+		}
+	}
+	if !slices.Equal(names, []string{"notes.txt", "pub", "team"}) {
+		t.Errorf("Root entries = %v, want [notes.txt pub team]", names)
+	}
+
+	fatalIfErr(t, ctx.ChangeDir("/pub"), "ChangeDir into mount failed")
+	f, err := ctx.OpenFile("readme.txt", os.O_RDONLY)
+	fatalIfErr(t, err, "Reading from the shared mount failed")
+	f.Close()
+	if _, err := ctx.OpenFile("new.txt", os.O_WRONLY|os.O_CREATE); !os.IsPermission(err) {
+		t.Errorf("Write to read-only mount: got %v, want ErrPermission", err)
+	}
+	if _, err := ctx.GetFileInfo("../notes.txt"); err != nil {
+		t.Errorf("Parent of mount point is not the home: %v", err)
+	}
+
+	f, err = ctx.OpenFile("/team/plan.txt", os.O_WRONLY|os.O_CREATE)
+	fatalIfErr(t, err, "Writing to the team mount failed")
+	f.Close()
+	if _, err := os.Stat(filepath.Join(team, "plan.txt")); err != nil {
+		t.Errorf("File was not written to the team directory: %v", err)
+	}
+
+	if err := ctx.Rename("/team/plan.txt", "/plan.txt"); err == nil {
+		t.Error("Rename across mount points succeeded")
+	}
+	if err := ctx.RemoveDir("/team"); !os.IsPermission(err) {
+		t.Errorf("RemoveDir of mount point: got %v, want ErrPermission", err)
+	}
+	if err := ctx.Rename("/team", "/team2"); !os.IsPermission(err) {
+		t.Errorf("Rename of mount point: got %v, want ErrPermission", err)
+	}
+	fatalIfErr(t, ctx.Rename("/team/plan.txt", "/team/final.txt"), "Rename within mount failed")
+
+	if runtime.GOOS != "windows" {
+		fatalIfErr(t, ctx.(*fsContext).Symlink("/team/final.txt", "/team/link"), "Symlink failed")
+		if target, err := ctx.(*fsContext).ReadLink("/team/link"); err != nil || target != "final.txt" {
+			t.Errorf("ReadLink = %q, %v, want final.txt", target, err)
+		}
+		if err := ctx.(*fsContext).Symlink("/notes.txt", "/team/escape"); !os.IsPermission(err) {
+			t.Errorf("Symlink out of mount: got %v, want ErrPermission", err)
+		}
+	}
+}
+
+func TestFSDriver_AutoCreateHome(t *testing.T) {
+	t.Parallel()
+	base := t.TempDir()
+	skel := filepath.Join(base, "skel")
+	fatalIfErr(t, os.MkdirAll(filepath.Join(skel, "public"), 0755), "Failed to create skeleton")
+	fatalIfErr(t, os.WriteFile(filepath.Join(skel, "welcome.txt"), []byte("hi"), 0644), "Failed to create file")
+	fatalIfErr(t, os.Mkdir(filepath.Join(base, "home"), 0755), "Failed to create home parent")
+
+	driver, err := NewFSDriver(base,
+		WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+			return "", false, nil
+		}),
+		WithHomeTemplate("home/{user}"),
+		WithAutoCreateHome(0750, skel),
+	)
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	ctx, err := driver.Authenticate("alice", "pass", "", nil)
+	fatalIfErr(t, err, "Login of a new user failed")
+	defer ctx.Close()
+	if _, err := ctx.GetFileInfo("/public"); err != nil {
+		t.Errorf("Skeleton directory not copied: %v", err)
+	}
+	f, err := ctx.OpenFile("/welcome.txt", os.O_RDONLY)
+	fatalIfErr(t, err, "Skeleton file not copied")
+	f.Close()
+
+	home := filepath.Join(base, "home", "alice")
+	info, err := os.Stat(home)
+	fatalIfErr(t, err, "Home directory not created")
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0750 {
+		t.Errorf("Home mode = %v, want 0750", info.Mode().Perm())
+	}
+
+	// Existing homes are left alone
+	fatalIfErr(t, os.Remove(filepath.Join(home, "welcome.txt")), "Failed to remove file")
+	ctx2, err := driver.Authenticate("alice", "pass", "", nil)
+	fatalIfErr(t, err, "Second login failed")
+	ctx2.Close()
+	if _, err := os.Stat(filepath.Join(home, "welcome.txt")); !os.IsNotExist(err) {
+		t.Error("Existing home directory was filled again")
+	}
+
+	for _, user := range []string{"..", "a/b", ""} {
+		if _, err := driver.Authenticate(user, "pass", "", nil); !os.IsPermission(err) {
+			t.Errorf("User %q: got %v, want ErrPermission", user, err)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(base, "home"))
+	fatalIfErr(t, err, "Failed to read home parent")
+	if len(entries) != 1 {
+		t.Errorf("Home parent has %d entries, want only alice", len(entries))
+	}
+}
+
+func TestFSContext_UploadSettings(t *testing.T) {
+	t.Parallel()
+	data := make([]byte, 3*directAlign+123)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		settings Settings
+	}{
+		{"default", Settings{}},
+		{"buffered", Settings{UploadBufferSize: 1000}},
+		{"sync", Settings{UploadSync: true}},
+		{"direct", Settings{UploadDirectIO: true, UploadSync: true}},
+		{"direct small buffer", Settings{UploadDirectIO: true, UploadBufferSize: 100}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tempDir := t.TempDir()
+			driver, err := NewFSDriver(tempDir,
+				WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+					return tempDir, false, nil
+				}),
+				WithSettings(&tc.settings),
+			)
+			fatalIfErr(t, err, "Failed to create FS driver")
+			ctx, err := driver.Authenticate("user", "pass", "", nil)
+			fatalIfErr(t, err, "Failed to authenticate")
+			defer ctx.Close()
+
+			// Written in small pieces, as they arrive from the network
+			f, err := ctx.OpenFile("/up.bin", os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+			fatalIfErr(t, err, "OpenFile failed")
+			for p := data; len(p) > 0; {
+				n := min(len(p), 777)
+				if _, err := f.Write(p[:n]); err != nil {
+					t.Fatalf("Write failed: %v", err)
+				}
+				p = p[n:]
+			}
+			fatalIfErr(t, f.Close(), "Close failed")
+
+			got, err := os.ReadFile(filepath.Join(tempDir, "up.bin"))
+			fatalIfErr(t, err, "ReadFile failed")
+			if !slices.Equal(got, data) {
+				t.Fatalf("Got %d bytes, want %d", len(got), len(data))
+			}
+
+			// A resumed upload continues at an unaligned offset
+			f, err = ctx.OpenFile("/up.bin", os.O_WRONLY|os.O_CREATE)
+			fatalIfErr(t, err, "OpenFile for resume failed")
+			_, err = f.Write([]byte("pending"))
+			fatalIfErr(t, err, "Write failed")
+			_, err = f.(io.Seeker).Seek(1001, io.SeekStart)
+			fatalIfErr(t, err, "Seek failed")
+			_, err = f.Write([]byte("resumed"))
+			fatalIfErr(t, err, "Write failed")
+			fatalIfErr(t, f.Close(), "Close failed")
+
+			want := slices.Clone(data)
+			copy(want, "pending")
+			copy(want[1001:], "resumed")
+			got, err = os.ReadFile(filepath.Join(tempDir, "up.bin"))
+			fatalIfErr(t, err, "ReadFile failed")
+			if !slices.Equal(got, want) {
+				t.Errorf("Resumed upload: got %q..., want %q...", got[1001:1008], want[1001:1008])
+			}
+
+			// Downloads still get the file itself, for sendfile
+			f, err = ctx.OpenFile("/up.bin", os.O_RDONLY)
+			fatalIfErr(t, err, "OpenFile for reading failed")
+			defer f.Close()
+			if _, ok := f.(*os.File); !ok {
+				t.Errorf("OpenFile(O_RDONLY) returned %T, want *os.File", f)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// directAlign is the alignment of buffers, lengths and file offsets of
+// O_DIRECT writes.
+const directAlign = 4096
+
+// defaultDirectBufferSize is the upload buffer size used for
+// Settings.UploadDirectIO when Settings.UploadBufferSize is not set.
+const defaultDirectBufferSize = 1 << 20
+
+// uploadFile is a file opened for an upload, written as set by the
+// UploadBufferSize, UploadSync and UploadDirectIO settings.
+type uploadFile struct {
+	f      *os.File
+	buf    []byte // Pending data, written out when it reaches cap(buf)
+	sync   bool   // Sync f before closing it
+	direct bool   // O_DIRECT is set on f
+}
+
+// newUploadFile wraps f, opened with flag, as set by settings. It returns f
+// itself if no setting applies.
+func newUploadFile(f *os.File, flag int, settings *Settings) io.ReadWriteCloser {
+	size := max(settings.UploadBufferSize, 0)
+	// Only for uploads starting at offset 0, which O_DIRECT needs aligned
+	direct := settings.UploadDirectIO && flag&os.O_APPEND == 0 &&
+		flag&(os.O_TRUNC|os.O_EXCL) != 0
+	if size == 0 && !settings.UploadSync && !direct {
+		return f
+	}
+
+	u := &uploadFile{f: f, sync: settings.UploadSync}
+	if direct && setDirectIO(f, true) == nil {
+		u.direct = true
+		if size == 0 {
+			size = defaultDirectBufferSize
+		}
+		size = (size + directAlign - 1) &^ (directAlign - 1)
+		u.buf = alignedBuffer(size)
+	} else if size > 0 {
+		u.buf = make([]byte, 0, size)
+	}
+	return u
+}
+
+// alignedBuffer returns an empty buffer of capacity size whose memory is
+// aligned as O_DIRECT needs.
+func alignedBuffer(size int) []byte {
+	b := make([]byte, size+directAlign)
+	off := int(uintptr(unsafe.Pointer(&b[0])) & (directAlign - 1))
+	if off != 0 {
+		off = directAlign - off
+	}
+	return b[off : off : off+size]
+}
+
+func (u *uploadFile) Write(p []byte) (int, error) {
+	if u.buf == nil {
+		return u.f.Write(p)
+	}
+
+	var n int
+	for len(p) > 0 {
+		m := copy(u.buf[len(u.buf):cap(u.buf)], p)
+		u.buf = u.buf[:len(u.buf)+m]
+		n += m
+		p = p[m:]
+		if len(u.buf) == cap(u.buf) {
+			if err := u.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush writes out the buffered data.
+func (u *uploadFile) flush() error {
+	if len(u.buf) == 0 {
+		return nil
+	}
+	defer func() { u.buf = u.buf[:0] }()
+
+	// O_DIRECT only writes whole blocks; the tail of the file goes through
+	// the page cache
+	if u.direct && len(u.buf)%directAlign != 0 {
+		if err := u.stopDirect(); err != nil {
+			return err
+		}
+	}
+
+	n, err := u.f.Write(u.buf)
+	if err != nil && u.direct && errors.Is(err, syscall.EINVAL) {
+		// The filesystem accepted O_DIRECT but cannot write with it
+		if err := u.stopDirect(); err != nil {
+			return err
+		}
+		_, err = u.f.Write(u.buf[n:])
+	}
+	return err
+}
+
+// stopDirect clears O_DIRECT, writing the rest of the upload normally.
+func (u *uploadFile) stopDirect() error {
+	u.direct = false
+	return setDirectIO(u.f, false)
+}
+
+func (u *uploadFile) Read(p []byte) (int, error) {
+	if err := u.flush(); err != nil {
+		return 0, err
+	}
+	return u.f.Read(p)
+}
+
+func (u *uploadFile) Seek(offset int64, whence int) (int64, error) {
+	if err := u.flush(); err != nil {
+		return 0, err
+	}
+	off, err := u.f.Seek(offset, whence)
+	if err == nil && u.direct && off%directAlign != 0 {
+		err = u.stopDirect()
+	}
+	return off, err
+}
+
+// Close writes out the buffered data, syncs the file if UploadSync is set,
+// and closes it.
+func (u *uploadFile) Close() error {
+	err := u.flush()
+	if err == nil && u.sync {
+		err = u.f.Sync()
+	}
+	if closeErr := u.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawUpload sends data with cmd, such as "STOR name", and returns the final
+// reply code and the 1xx reply text.
+func rawUpload(t *testing.T, tc *textConn, cmd, data string) (int, string) {
+	t.Helper()
+	dataAddr, err := rawEnterPasv(tc)
+	fatalIfErr(t, err, "PASV failed")
+	dataConn, err := net.DialTimeout("tcp", dataAddr, 5*time.Second)
+	fatalIfErr(t, err, "Failed to dial data port")
+	defer dataConn.Close()
+
+	fmt.Fprintf(tc, "%s\r\n", cmd)
+	code, msg, err := rawReadResponse(tc)
+	fatalIfErr(t, err, "Failed to read reply to "+cmd)
+	if !isPreliminary(code) {
+		return code, msg
+	}
+	_, err = io.WriteString(dataConn, data)
+	fatalIfErr(t, err, "Failed to write data")
+	dataConn.Close()
+	code, _, err = rawReadResponse(tc)
+	fatalIfErr(t, err, "Failed to read transfer reply")
+	return code, msg
+}
+
+func TestAnonDropbox(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	rootDir := t.TempDir()
+	fatalIfErr(t, os.Mkdir(filepath.Join(rootDir, "incoming"), 0755), "Failed to create dropbox")
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "incoming", "other.txt"), []byte("secret"), 0644), "Failed to create file")
+	driver, err := NewFSDriver(rootDir, WithAnonDropbox("incoming"))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	startServer(t, ln, WithDriver(driver), WithAtomicUploads(true))
+
+	tc, err := rawLogin(ln.Addr().String(), "anonymous", "guest@example.com")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	upload := func(cmd, data string) (int, string) {
+		t.Helper()
+		return rawUpload(t, tc, cmd, data)
+	}
+
+	fmt.Fprintf(tc, "CWD incoming\r\n")
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 250 {
+		t.Fatalf("CWD: got %d %q (%v)", code, msg, err)
+	}
+	if code, _ := upload("STOR report.txt", "first"); code != 226 {
+		t.Fatalf("STOR: got %d, want 226", code)
+	}
+	// With atomic uploads, the data is written to a temporary file and
+	// refused when it would replace the existing one.
+	if code, _ := upload("STOR report.txt", "second"); code != 451 {
+		t.Errorf("STOR over an existing file: got %d, want 451", code)
+	}
+	code, msg := upload("STOU", "unique")
+	if code != 226 {
+		t.Fatalf("STOU: got %d, want 226", code)
+	}
+	name := strings.TrimSpace(strings.TrimPrefix(msg[4:], "FILE: "))
+
+	for _, line := range []string{"RETR report.txt", "LIST", "NLST", "DELE other.txt", "RNFR other.txt", "SIZE other.txt", "APPE other.txt"} {
+		fmt.Fprintf(tc, "%s\r\n", line)
+		code, msg, err := rawReadResponse(tc)
+		fatalIfErr(t, err, "Failed to read reply to "+line)
+		if code < 400 {
+			t.Errorf("%s: got %q, want an error", line, msg)
+		}
+	}
+
+	got, err := os.ReadFile(filepath.Join(rootDir, "incoming", "report.txt"))
+	fatalIfErr(t, err, "Failed to read upload")
+	if string(got) != "first" {
+		t.Errorf("report.txt = %q, want %q", got, "first")
+	}
+	got, err = os.ReadFile(filepath.Join(rootDir, "incoming", name))
+	fatalIfErr(t, err, "Failed to read STOU upload")
+	if string(got) != "unique" {
+		t.Errorf("%s = %q, want %q", name, got, "unique")
+	}
+	entries, err := os.ReadDir(filepath.Join(rootDir, "incoming"))
+	fatalIfErr(t, err, "Failed to read dropbox")
+	if len(entries) != 3 {
+		t.Errorf("Dropbox has %d entries, want 3 (temporary files left behind?)", len(entries))
+	}
+}
+
+func TestAnonDropbox_OverwritePolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, overwrite := range []OverwritePolicy{OverwriteDeny, OverwriteVersion} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		fatalIfErr(t, err, "Failed to listen")
+		rootDir := t.TempDir()
+		fatalIfErr(t, os.Mkdir(filepath.Join(rootDir, "incoming"), 0755), "Failed to create dropbox")
+		fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "incoming", "other.txt"), []byte("secret"), 0644), "Failed to create file")
+		driver, err := NewFSDriver(rootDir, WithAnonDropbox("incoming"))
+		fatalIfErr(t, err, "Failed to create FS driver")
+		startServer(t, ln, WithDriver(driver), WithUploadPolicy(UploadPolicy{Overwrite: overwrite}))
+
+		tc, err := rawLogin(ln.Addr().String(), "anonymous", "guest@example.com")
+		fatalIfErr(t, err, "Login failed")
+		defer tc.Close()
+
+		// Files in the dropbox cannot be examined, so the exclusive open
+		// decides whether the name is free.
+		if code, msg := rawUpload(t, tc, "STOR incoming/report.txt", "first"); code != 226 {
+			t.Errorf("Overwrite %d: STOR of a new file: got %d %q, want 226", overwrite, code, msg)
+		}
+		if code, _ := rawUpload(t, tc, "STOR incoming/other.txt", "second"); code != 553 {
+			t.Errorf("Overwrite %d: STOR over another file: got %d, want 553", overwrite, code)
+		}
+		// Anonymous users cannot rename, so their own files cannot be
+		// versioned either
+		if code, _ := rawUpload(t, tc, "STOR incoming/report.txt", "second"); code < 400 {
+			t.Errorf("Overwrite %d: STOR over an own upload: got %d, want an error", overwrite, code)
+		}
+
+		for name, want := range map[string]string{"report.txt": "first", "other.txt": "secret"} {
+			got, err := os.ReadFile(filepath.Join(rootDir, "incoming", name))
+			fatalIfErr(t, err, "Failed to read "+name)
+			if string(got) != want {
+				t.Errorf("Overwrite %d: %s = %q, want %q", overwrite, name, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// FilenameEncoding converts file names between UTF-8, which drivers use, and
+// the encoding a legacy client uses on the control and data connections.
+//
+// Encodings from golang.org/x/text can be adapted in a few lines, since their
+// decoders and encoders have matching String methods:
+//
+//	type textEncoding struct{ enc encoding.Encoding }
+//
+//	func (e textEncoding) Decode(s string) (string, error) { return e.enc.NewDecoder().String(s) }
+//	func (e textEncoding) Encode(s string) (string, error) { return e.enc.NewEncoder().String(s) }
+//
+//	server.WithFilenameEncoding(textEncoding{japanese.ShiftJIS})
+type FilenameEncoding interface {
+	// Decode converts a name received from the client to UTF-8.
+	Decode(name string) (string, error)
+
+	// Encode converts a UTF-8 name to the client's encoding.
+	Encode(name string) (string, error)
+}
+
+// Latin1 is the ISO-8859-1 filename encoding, common on legacy Western
+// European systems.
+var Latin1 FilenameEncoding = latin1Encoding{}
+
+type latin1Encoding struct{}
+
+func (latin1Encoding) Decode(name string) (string, error) {
+	var b strings.Builder
+	b.Grow(len(name))
+	for i := 0; i < len(name); i++ {
+		b.WriteRune(rune(name[i]))
+	}
+	return b.String(), nil
+}
+
+func (latin1Encoding) Encode(name string) (string, error) {
+	b := make([]byte, 0, len(name))
+	for _, r := range name {
+		if r > 0xff || r == utf8.RuneError {
+			return "", fmt.Errorf("character %q cannot be represented in ISO-8859-1", r)
+		}
+		b = append(b, byte(r))
+	}
+	return string(b), nil
+}
+
+// setUTF8Mode records the client's OPTS UTF8 choice. It takes s.mu because
+// replies from transfer goroutines read it.
+func (s *session) setUTF8Mode(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.utf8Mode = on
+}
+
+// transcoding reports whether names must be converted for this session.
+// Clients that send "OPTS UTF8 ON" get UTF-8 names unchanged.
+func (s *session) transcoding() bool {
+	return s.server.filenameEncoding != nil && !s.utf8Mode
+}
+
+// decodeName converts a name received from the client to UTF-8.
+// If the name cannot be decoded, it is returned unchanged.
+func (s *session) decodeName(name string) string {
+	if !s.transcoding() {
+		return name
+	}
+	decoded, err := s.server.filenameEncoding.Decode(name)
+	if err != nil {
+		return name
+	}
+	return decoded
+}
+
+// encodeName converts a UTF-8 name to the client's encoding.
+// If the name cannot be encoded, it is returned unchanged.
+func (s *session) encodeName(name string) string {
+	if !s.transcoding() {
+		return name
+	}
+	encoded, err := s.server.filenameEncoding.Encode(name)
+	if err != nil {
+		return name
+	}
+	return encoded
+}
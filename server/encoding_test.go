@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatin1(t *testing.T) {
+	t.Parallel()
+
+	decoded, err := Latin1.Decode("caf\xe9.txt")
+	fatalIfErr(t, err, "Decode failed")
+	if decoded != "café.txt" {
+		t.Errorf("Decode: got %q, want %q", decoded, "café.txt")
+	}
+
+	encoded, err := Latin1.Encode("café.txt")
+	fatalIfErr(t, err, "Encode failed")
+	if encoded != "caf\xe9.txt" {
+		t.Errorf("Encode: got %q, want %q", encoded, "caf\xe9.txt")
+	}
+
+	if _, err := Latin1.Encode("日本.txt"); err == nil {
+		t.Error("Expected error encoding characters outside ISO-8859-1")
+	}
+}
+
+func TestFilenameEncoding(t *testing.T) {
+	t.Parallel()
+	rootDir := t.TempDir()
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "café.txt"), []byte("coffee"), 0644), "WriteFile failed")
+
+	driver, err := NewFSDriver(rootDir)
+	fatalIfErr(t, err, "NewFSDriver failed")
+	s, err := NewServer(":0", WithDriver(driver), WithFilenameEncoding(Latin1))
+	fatalIfErr(t, err, "NewServer failed")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Listen failed")
+	go func() { _ = s.Serve(ln) }()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	}()
+
+	conn, err := rawLogin(ln.Addr().String(), "anonymous", "test@example.com")
+	fatalIfErr(t, err, "rawLogin failed")
+	defer conn.Close()
+
+	nlst := func() string {
+		dataAddr, err := rawEnterPasv(conn)
+		fatalIfErr(t, err, "rawEnterPasv failed")
+		dataConn, err := net.DialTimeout("tcp", dataAddr, 5*time.Second)
+		fatalIfErr(t, err, "Dial data port failed")
+		defer dataConn.Close()
+
+		fmt.Fprintf(conn, "NLST\r\n")
+		var buf bytes.Buffer
+		fatalIfErr(t, dataConn.SetReadDeadline(time.Now().Add(5*time.Second)), "SetReadDeadline failed")
+		_, err = buf.ReadFrom(dataConn)
+		fatalIfErr(t, err, "ReadFrom failed")
+		_, _, _ = rawReadResponse(conn) // 150
+		_, _, _ = rawReadResponse(conn) // 226
+		return strings.TrimSpace(buf.String())
+	}
+
+	// Without OPTS UTF8 ON, names are transcoded in both directions
+	if got := nlst(); got != "caf\xe9.txt" {
+		t.Errorf("NLST: got %q, want Latin-1 name", got)
+	}
+	fmt.Fprintf(conn, "SIZE caf\xe9.txt\r\n")
+	code, msg, err := rawReadResponse(conn)
+	fatalIfErr(t, err, "SIZE failed")
+	if code != 213 {
+		t.Errorf("SIZE with Latin-1 name: got %s", msg)
+	}
+
+	// OPTS UTF8 ON switches the session to UTF-8
+	fmt.Fprintf(conn, "OPTS UTF8 ON\r\n")
+	code, _, err = rawReadResponse(conn)
+	fatalIfErr(t, err, "OPTS failed")
+	if code != 200 {
+		t.Errorf("OPTS UTF8 ON: expected 200, got %d", code)
+	}
+	if got := nlst(); got != "café.txt" {
+		t.Errorf("NLST in UTF8 mode: got %q, want %q", got, "café.txt")
+	}
+	fmt.Fprintf(conn, "SIZE café.txt\r\n")
+	code, msg, err = rawReadResponse(conn)
+	fatalIfErr(t, err, "SIZE failed")
+	if code != 213 {
+		t.Errorf("SIZE with UTF-8 name: got %s", msg)
+	}
+
+	// And OPTS UTF8 OFF back to the legacy encoding
+	fmt.Fprintf(conn, "OPTS UTF8 OFF\r\n")
+	code, _, err = rawReadResponse(conn)
+	fatalIfErr(t, err, "OPTS failed")
+	if code != 200 {
+		t.Errorf("OPTS UTF8 OFF: expected 200, got %d", code)
+	}
+	if got := nlst(); got != "caf\xe9.txt" {
+		t.Errorf("NLST after UTF8 OFF: got %q, want Latin-1 name", got)
+	}
+}
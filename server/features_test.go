@@ -393,7 +393,7 @@ func testAnonWriteAndTransferLog(t *testing.T, addr string, logBuf *bytes.Buffer
 
 	code, _, err := rawReadResponse(conn)
 	fatalIfErr(t, err, "rawReadResponse failed")
-	if code == 150 {
+	if isPreliminary(code) {
 		code, _, err = rawReadResponse(conn)
 		fatalIfErr(t, err, "rawReadResponse (226) failed")
 	}
@@ -554,6 +554,13 @@ type textConn struct {
 	Reader *bufio.Reader
 }
 
+// isPreliminary reports whether code is the 1xx reply of a transfer: 125 if
+// the server saw the passive data connection open before the command, 150
+// otherwise. Tests that connect first may get either.
+func isPreliminary(code int) bool {
+	return code == 125 || code == 150
+}
+
 func rawReadResponse(c *textConn) (int, string, error) {
 	if err := c.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
 		return 0, "", err
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// expandHome returns the home directory of user from the home template.
+func (d *FSDriver) expandHome(user string) (string, error) {
+	if user == "" || user == "." || user == ".." || strings.ContainsAny(user, "/\\\x00") {
+		return "", os.ErrPermission
+	}
+	home := strings.ReplaceAll(d.homeTemplate, "{user}", user)
+	if !filepath.IsAbs(home) {
+		home = filepath.Join(d.rootPath, home)
+	}
+	return home, nil
+}
+
+// createHome creates the home directory if it does not exist. It is filled
+// under a temporary name in the same parent, then renamed into place.
+func (d *FSDriver) createHome(home string) error {
+	if _, err := os.Stat(home); !errors.Is(err, fs.ErrNotExist) {
+		return err // Exists, or cannot be checked
+	}
+
+	home = filepath.Clean(home)
+	tmp, err := os.MkdirTemp(filepath.Dir(home), "."+filepath.Base(home)+".")
+	if err != nil {
+		return fmt.Errorf("failed to create home directory: %w", err)
+	}
+	if err := d.fillHome(tmp); err != nil {
+		_ = os.RemoveAll(tmp)
+		return fmt.Errorf("failed to create home directory: %w", err)
+	}
+	if err := os.Rename(tmp, home); err != nil {
+		_ = os.RemoveAll(tmp)
+		if _, statErr := os.Stat(home); statErr == nil {
+			return nil // Created by a concurrent login
+		}
+		return fmt.Errorf("failed to create home directory: %w", err)
+	}
+	return nil
+}
+
+// fillHome copies the skeleton into dir and sets its permissions.
+func (d *FSDriver) fillHome(dir string) error {
+	if d.homeSkeleton != "" {
+		if err := os.CopyFS(dir, os.DirFS(d.homeSkeleton)); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(dir, d.homeMode)
+}
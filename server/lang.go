@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// defaultLanguage is the language of the built-in reply texts.
+const defaultLanguage = "EN"
+
+// MessageCatalog translates reply texts for the LANG command (RFC 2640).
+// Replies are looked up by their English text, as the server sends it.
+// Texts without a translation, including those containing file names or
+// error details, are sent in English.
+type MessageCatalog interface {
+	// Translate returns the translation of an English reply text, and false
+	// if there is none.
+	Translate(text string) (string, bool)
+}
+
+// Catalog is a MessageCatalog backed by a map from English reply texts to
+// their translations.
+//
+// Example:
+//
+//	server.Catalog{
+//	    "Not logged in.":      "Non connecté.",
+//	    "File not found.":     "Fichier introuvable.",
+//	    "Language changed.":   "Langue modifiée.",
+//	}
+type Catalog map[string]string
+
+// Translate implements MessageCatalog.
+func (c Catalog) Translate(text string) (string, bool) {
+	t, ok := c[text]
+	return t, ok
+}
+
+// language is a registered message catalog.
+type language struct {
+	tag     string
+	catalog MessageCatalog
+}
+
+// RegisterCatalog adds a language for the LANG command, or replaces the
+// catalog of a registered one. The tag is a language tag such as "FR" or
+// "pt-BR" (RFC 5646), matched without regard to case. English ("EN") is
+// always available and is the default. Catalogs can be registered while the
+// server is running; sessions that already selected a language keep using
+// the catalog they selected.
+func (s *Server) RegisterCatalog(tag string, catalog MessageCatalog) error {
+	if !validLanguageTag(tag) {
+		return fmt.Errorf("invalid language tag: %q", tag)
+	}
+	if catalog == nil {
+		return fmt.Errorf("catalog for %s is nil", tag)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.languages == nil {
+		s.languages = make(map[string]language)
+	}
+	s.languages[strings.ToLower(tag)] = language{tag: tag, catalog: catalog}
+	return nil
+}
+
+// validLanguageTag reports whether tag looks like a language tag: subtags of
+// 1 to 8 letters or digits separated by hyphens, the first one letters only.
+func validLanguageTag(tag string) bool {
+	for i, sub := range strings.Split(tag, "-") {
+		if sub == "" || len(sub) > 8 {
+			return false
+		}
+		for _, r := range sub {
+			isLetter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+			if !isLetter && (i == 0 || r < '0' || r > '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// findLanguage returns the registered language for tag. If there is none,
+// the language of its primary subtag is used (e.g. "FR" for "fr-CA"), as
+// RFC 2640 suggests.
+func (s *Server) findLanguage(tag string) (language, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tag = strings.ToLower(tag)
+	if l, ok := s.languages[tag]; ok {
+		return l, true
+	}
+	primary, _, _ := strings.Cut(tag, "-")
+	l, ok := s.languages[primary]
+	return l, ok
+}
+
+// languageTags returns the tags for the FEAT LANG line: the default language
+// and the registered ones, with the session's language marked with "*".
+func (s *session) languageTags() string {
+	s.server.mu.Lock()
+	tags := []string{defaultLanguage}
+	for _, l := range s.server.languages {
+		if !strings.EqualFold(l.tag, defaultLanguage) {
+			tags = append(tags, l.tag)
+		}
+	}
+	s.server.mu.Unlock()
+	slices.Sort(tags[1:])
+
+	s.mu.Lock()
+	current := s.lang
+	s.mu.Unlock()
+	for i, tag := range tags {
+		if strings.EqualFold(tag, current) {
+			tags[i] += "*"
+		}
+	}
+	return strings.Join(tags, ";")
+}
+
+// handleLANG selects the language of the session's replies (RFC 2640).
+// Without an argument it returns to the default language.
+func (s *session) handleLANG(arg string) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" || strings.EqualFold(arg, defaultLanguage) {
+		s.setLanguage(defaultLanguage, nil)
+		s.reply(200, "Language changed.")
+		return
+	}
+
+	if !validLanguageTag(arg) {
+		s.reply(501, "Invalid language tag.")
+		return
+	}
+	l, ok := s.server.findLanguage(arg)
+	if !ok {
+		s.reply(504, "Language not supported.")
+		return
+	}
+	s.setLanguage(l.tag, l.catalog)
+	s.reply(200, "Language changed.")
+}
+
+// setLanguage sets the session's language. It takes s.mu because replies
+// from transfer goroutines read the catalog.
+func (s *session) setLanguage(tag string, catalog MessageCatalog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lang = tag
+	s.catalog = catalog
+}
+
+// translate returns the session's translation of a reply text.
+// s.mu must be held.
+func (s *session) translate(text string) string {
+	if s.catalog == nil {
+		return text
+	}
+	if t, ok := s.catalog.Translate(text); ok {
+		return t
+	}
+	return text
+}
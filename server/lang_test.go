@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+func TestLANG(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return rootDir, false, nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	s := startServer(t, ln, WithDriver(driver))
+
+	fatalIfErr(t, s.RegisterCatalog("FR", Catalog{
+		"Language changed.": "Langue modifiée.",
+		"File not found.":   "Fichier introuvable.",
+	}), "RegisterCatalog failed")
+	for _, tag := range []string{"", "fr_FR", "1x", "toolongtag"} {
+		if err := s.RegisterCatalog(tag, Catalog{}); err == nil {
+			t.Errorf("RegisterCatalog(%q) succeeded", tag)
+		}
+	}
+
+	t.Run("FEAT", func(t *testing.T) {
+		c, err := ftp.Dial(ln.Addr().String(), ftp.WithTimeout(2*time.Second))
+		fatalIfErr(t, err, "Failed to dial")
+		defer c.Quit()
+		fatalIfErr(t, c.Login("test", "test"), "Login failed")
+
+		for _, want := range []string{"EN*;FR", "EN;FR*"} {
+			resp, err := c.Quote("FEAT")
+			fatalIfErr(t, err, "FEAT failed")
+			if !slices.Contains(resp.Lines, " LANG "+want) {
+				t.Errorf("FEAT = %q, want LANG %s", resp.Lines, want)
+			}
+
+			_, err = c.Quote("LANG", "fr")
+			fatalIfErr(t, err, "LANG failed")
+		}
+	})
+
+	t.Run("replies", func(t *testing.T) {
+		tc, err := rawLogin(ln.Addr().String(), "test", "test")
+		fatalIfErr(t, err, "Login failed")
+		defer tc.Close()
+
+		tests := []struct {
+			cmd  string
+			code int
+			text string
+		}{
+			{"LANG de", 504, "Language not supported."},
+			{"LANG fr_FR", 501, "Invalid language tag."},
+			{"LANG fr-CA", 200, "Langue modifiée."},
+			{"RNFR missing.txt", 550, "Fichier introuvable."},
+			{"NOOP", 200, ""}, // untranslated texts stay in English
+			{"LANG", 200, "Language changed."},
+			{"RNFR missing.txt", 550, "File not found."},
+		}
+		for _, tt := range tests {
+			fmt.Fprintf(tc, "%s\r\n", tt.cmd)
+			code, msg, err := rawReadResponse(tc)
+			fatalIfErr(t, err, "%s failed", tt.cmd)
+			if code != tt.code || !strings.Contains(msg, tt.text) {
+				t.Errorf("%s: got %q, want %d %s", tt.cmd, strings.TrimSpace(msg), tt.code, tt.text)
+			}
+		}
+	})
+}
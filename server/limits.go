@@ -0,0 +1,91 @@
+package server
+
+import (
+	"strings"
+	"time"
+)
+
+// MaxPathLength is the default maximum length of a path argument, in bytes
+// (see WithMaxPathLength).
+const MaxPathLength = 1024
+
+// maxUnusedPassive is the maximum number of PASV and EPSV commands in a row
+// that a session can send without a data transfer command. Each opens a
+// listener, so clients creating them in a loop are disconnected.
+const maxUnusedPassive = 10
+
+// maxEmptyLines is the maximum number of empty command lines in a row. They
+// get no reply, so clients flooding them are disconnected.
+const maxEmptyLines = 64
+
+// pathCommands are the commands whose argument is a path, checked against
+// the maximum path length before reaching the driver.
+var pathCommands = map[string]bool{
+	"CWD": true, "XCWD": true, "MKD": true, "XMKD": true, "RMD": true,
+	"XRMD": true, "DELE": true, "RNFR": true, "RNTO": true, "RETR": true,
+	"STOR": true, "APPE": true, "SIZE": true, "MDTM": true, "MLST": true,
+	"MLSD": true, "LIST": true, "NLST": true, "MFMT": true, "HASH": true,
+	"XCRC": true, "XMD5": true, "XSHA1": true, "XSHA256": true,
+	"XSHA512": true, "MD5": true, "COMB": true, "AVBL": true, "STAT": true,
+	"SITE": true, "MFCT": true, "MFF": true,
+}
+
+// dataCommands are the commands that transfer data, over the connection set
+// up with a preceding PASV, EPSV, PORT or EPRT.
+var dataCommands = map[string]bool{
+	"RETR": true, "STOR": true, "APPE": true, "STOU": true,
+	"LIST": true, "NLST": true, "MLSD": true,
+}
+
+// commandLimiter enforces the limits on the command lines of a session.
+type commandLimiter struct {
+	window        time.Time // Start of the current one-second window
+	commands      int       // Commands in the current window
+	emptyLines    int       // Empty lines in a row
+	unusedPassive int       // PASV and EPSV commands since the last transfer
+}
+
+// checkLimits returns why the session must be disconnected for sending
+// line, or "" if the line is acceptable.
+func (s *session) checkLimits(line string) string {
+	l := &s.limiter
+
+	if rate := s.server.maxCommandRate; rate > 0 {
+		now := time.Now()
+		if now.Sub(l.window) >= time.Second {
+			l.window, l.commands = now, 0
+		}
+		l.commands++
+		if l.commands > rate {
+			return "command_rate"
+		}
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		l.emptyLines++
+		if l.emptyLines > maxEmptyLines {
+			return "empty_lines"
+		}
+		return ""
+	}
+	l.emptyLines = 0
+
+	cmd, _ := parseCommand(line)
+	switch {
+	case cmd == "PASV" || cmd == "EPSV":
+		l.unusedPassive++
+		if l.unusedPassive > maxUnusedPassive {
+			return "unused_passive"
+		}
+	case dataCommands[cmd]:
+		l.unusedPassive = 0
+	}
+	return ""
+}
+
+// pathTooLong reports whether the argument of cmd is longer than the
+// maximum path length.
+func (s *session) pathTooLong(cmd, arg string) bool {
+	return pathCommands[cmd] && s.server.maxPathLength > 0 && len(arg) > s.server.maxPathLength
+}
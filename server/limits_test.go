@@ -3,7 +3,11 @@ package server
 import (
 	"bufio"
 	"context"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -242,3 +246,275 @@ func TestMaxCommandLength(t *testing.T) {
 		t.Error("Expected connection to be closed after oversized command, but it remains open")
 	}
 }
+
+func TestTransferStallTimeout(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return rootDir, false, nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	startServer(t, ln, WithDriver(driver), WithTransferStallTimeout(200*time.Millisecond))
+
+	tc, err := rawLogin(ln.Addr().String(), "test", "test")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	dataAddr, err := rawEnterPasv(tc)
+	fatalIfErr(t, err, "PASV failed")
+	fmt.Fprintf(tc, "STOR stalled.bin\r\n")
+	dataConn, err := net.Dial("tcp", dataAddr)
+	fatalIfErr(t, err, "Failed to dial data connection")
+	defer dataConn.Close()
+	if code, msg, err := rawReadResponse(tc); err != nil || !isPreliminary(code) {
+		t.Fatalf("STOR failed: %q %v", msg, err)
+	}
+
+	// Data keeps the transfer alive
+	for range 4 {
+		_, err = dataConn.Write(make([]byte, 100))
+		fatalIfErr(t, err, "Write failed")
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Then the client stops sending without closing the connection
+	start := time.Now()
+	code, msg, err := rawReadResponse(tc)
+	fatalIfErr(t, err, "Failed to read reply")
+	if code != 426 {
+		t.Errorf("Expected 426, got %q", msg)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Transfer aborted after %v", elapsed)
+	}
+
+	// The session is still usable
+	fmt.Fprintf(tc, "NOOP\r\n")
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 200 {
+		t.Errorf("NOOP failed: %q %v", msg, err)
+	}
+}
+
+func TestMaxSessionDuration(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return rootDir, false, nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	startServer(t, ln, WithDriver(driver), WithMaxSessionDuration(300*time.Millisecond))
+
+	tc, err := rawLogin(ln.Addr().String(), "test", "test")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	code, msg, err := rawReadResponse(tc)
+	fatalIfErr(t, err, "Failed to read reply")
+	if code != 421 {
+		t.Errorf("Expected 421, got %q", msg)
+	}
+	if _, _, err := rawReadResponse(tc); err == nil {
+		t.Error("Connection still open after 421")
+	}
+}
+
+func TestMaxIdleTimeDuringTransfer(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return rootDir, false, nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	startServer(t, ln, WithDriver(driver), WithMaxIdleTime(300*time.Millisecond))
+
+	tc, err := rawLogin(ln.Addr().String(), "test", "test")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	dataAddr, err := rawEnterPasv(tc)
+	fatalIfErr(t, err, "PASV failed")
+	fmt.Fprintf(tc, "STOR slow.bin\r\n")
+	dataConn, err := net.Dial("tcp", dataAddr)
+	fatalIfErr(t, err, "Failed to dial data connection")
+	if code, msg, err := rawReadResponse(tc); err != nil || !isPreliminary(code) {
+		t.Fatalf("STOR failed: %q %v", msg, err)
+	}
+
+	// The control connection stays quiet for three times the idle time
+	// while data flows
+	for range 9 {
+		_, err = dataConn.Write(make([]byte, 100))
+		fatalIfErr(t, err, "Write failed")
+		time.Sleep(100 * time.Millisecond)
+	}
+	dataConn.Close()
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 226 {
+		t.Fatalf("Transfer failed: %q %v", msg, err)
+	}
+	if info, err := os.Stat(filepath.Join(rootDir, "slow.bin")); err != nil || info.Size() != 900 {
+		t.Errorf("slow.bin = %v, %v; want 900 bytes", info, err)
+	}
+
+	// A genuinely idle session is told why it is closed
+	code, msg, err := rawReadResponse(tc)
+	fatalIfErr(t, err, "Failed to read reply")
+	if code != 421 {
+		t.Errorf("Expected 421, got %q", msg)
+	}
+	if _, _, err := rawReadResponse(tc); err == nil {
+		t.Error("Connection still open after 421")
+	}
+}
+
+func TestMaxSessionsPerListener(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	driver, err := NewFSDriver(t.TempDir())
+	fatalIfErr(t, err, "Failed to create FS driver")
+	startServer(t, ln, WithDriver(driver), WithMaxSessionsPerListener(1))
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	fatalIfErr(t, err, "Failed to connect")
+	defer first.Close()
+	if _, err := bufio.NewReader(first).ReadString('\n'); err != nil {
+		t.Fatalf("Failed to read greeting: %v", err)
+	}
+
+	// The second connection waits in the backlog until the first one ends
+	second, err := net.Dial("tcp", ln.Addr().String())
+	fatalIfErr(t, err, "Failed to connect")
+	defer second.Close()
+	r := bufio.NewReader(second)
+	_ = second.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if line, err := r.ReadString('\n'); err == nil {
+		t.Fatalf("Second session served while the first is open: %q", line)
+	}
+
+	first.Close()
+	_ = second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if line, err := r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "220") {
+		t.Errorf("Expected greeting after the first session ended, got %q, %v", line, err)
+	}
+}
+
+// expectDisconnect reads replies until the 421 closing the connection.
+func expectDisconnect(t *testing.T, tc *textConn) {
+	t.Helper()
+	for {
+		code, msg, err := rawReadResponse(tc)
+		if err != nil {
+			t.Fatalf("Connection closed without 421: %v", err)
+		}
+		if code == 421 {
+			break
+		}
+		if code >= 400 {
+			t.Fatalf("Unexpected reply %q", msg)
+		}
+	}
+	if _, _, err := rawReadResponse(tc); err == nil {
+		t.Error("Connection still open after 421")
+	}
+}
+
+func TestMaxCommandRate(t *testing.T) {
+	t.Parallel()
+	driver, _ := newTestFSDriver(t)
+	addr := serveDriver(t, driver, WithMaxCommandRate(20))
+
+	tc, err := rawLogin(addr, "test", "test")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	// Pipelined commands count individually
+	fmt.Fprint(tc.Conn, strings.Repeat("NOOP\r\n", 30))
+	expectDisconnect(t, tc)
+}
+
+func TestEmptyLineFlood(t *testing.T) {
+	t.Parallel()
+	driver, _ := newTestFSDriver(t)
+	addr := serveDriver(t, driver)
+
+	tc, err := rawLogin(addr, "test", "test")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	// A few empty lines are ignored
+	fmt.Fprint(tc.Conn, strings.Repeat("\r\n", maxEmptyLines)+"NOOP\r\n")
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 200 {
+		t.Fatalf("NOOP after empty lines: %q, %v", msg, err)
+	}
+
+	fmt.Fprint(tc.Conn, strings.Repeat("\r\n", maxEmptyLines+1))
+	expectDisconnect(t, tc)
+}
+
+func TestUnusedPassiveLimit(t *testing.T) {
+	t.Parallel()
+	driver, _ := newTestFSDriver(t)
+	addr := serveDriver(t, driver)
+
+	tc, err := rawLogin(addr, "test", "test")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	for range maxUnusedPassive {
+		if _, err := rawEnterPasv(tc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fmt.Fprintf(tc.Conn, "PASV\r\n")
+	expectDisconnect(t, tc)
+
+	// Transfers reset the count
+	tc, err = rawLogin(addr, "test", "test")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+	for range 2 * maxUnusedPassive {
+		dataAddr, err := rawEnterPasv(tc)
+		fatalIfErr(t, err, "PASV failed")
+		data, err := net.Dial("tcp", dataAddr)
+		fatalIfErr(t, err, "Failed to open data connection")
+		fmt.Fprintf(tc.Conn, "NLST\r\n")
+		_, _ = io.Copy(io.Discard, data)
+		data.Close()
+		if code, msg, err := rawReadResponse(tc); err != nil || !isPreliminary(code) {
+			t.Fatalf("NLST: got %q, %v; want 125 or 150", msg, err)
+		}
+		if code, msg, err := rawReadResponse(tc); err != nil || code != 226 {
+			t.Fatalf("NLST: got %q, %v; want 226", msg, err)
+		}
+	}
+}
+
+func TestMaxPathLength(t *testing.T) {
+	t.Parallel()
+	driver, _ := newTestFSDriver(t)
+	addr := serveDriver(t, driver, WithMaxPathLength(64))
+
+	tc, err := rawLogin(addr, "test", "test")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	fmt.Fprintf(tc.Conn, "MKD %s\r\n", strings.Repeat("a", 65))
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 553 {
+		t.Errorf("MKD with a long path: got %q, %v; want 553", msg, err)
+	}
+	fmt.Fprintf(tc.Conn, "MKD %s\r\n", strings.Repeat("a", 64))
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 257 {
+		t.Errorf("MKD: got %q, %v; want 257", msg, err)
+	}
+}
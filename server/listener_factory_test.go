@@ -2,6 +2,7 @@ package server
 
 import (
 	"net"
+	"sync/atomic"
 	"testing"
 )
 
@@ -186,3 +187,59 @@ func TestWithDisableCommandsUsingGroups(t *testing.T) {
 		t.Error("XPWD should be disabled")
 	}
 }
+
+// factoryConn is a control connection creating its session's passive
+// listeners.
+type factoryConn struct {
+	net.Conn
+	mockListenerFactory
+	calls atomic.Int32
+}
+
+func (c *factoryConn) Listen(network, address string) (net.Listener, error) {
+	c.calls.Add(1)
+	return c.mockListenerFactory.Listen(network, address)
+}
+
+// factoryConnListener wraps accepted connections in factoryConn.
+type factoryConnListener struct {
+	net.Listener
+	conns chan *factoryConn
+}
+
+func (l *factoryConnListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	fc := &factoryConn{Conn: conn}
+	l.conns <- fc
+	return fc, nil
+}
+
+func TestControlConnListenerFactory(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	serverFactory := &mockListenerFactory{}
+	var serverCalls atomic.Int32
+	serverFactory.listenFunc = func(network, address string) (net.Listener, error) {
+		serverCalls.Add(1)
+		return net.Listen(network, address)
+	}
+	driver, err := NewFSDriver(t.TempDir())
+	fatalIfErr(t, err, "Failed to create FS driver")
+	fl := &factoryConnListener{Listener: ln, conns: make(chan *factoryConn, 1)}
+	startServer(t, fl, WithDriver(driver), WithListenerFactory(serverFactory))
+
+	tc, err := rawLogin(ln.Addr().String(), "anonymous", "anonymous")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+	_, err = rawEnterPasv(tc)
+	fatalIfErr(t, err, "PASV failed")
+
+	if fc := <-fl.conns; fc.calls.Load() != 1 || serverCalls.Load() != 0 {
+		t.Errorf("Listen calls: control connection %d, server factory %d; want 1 and 0",
+			fc.calls.Load(), serverCalls.Load())
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp/internal/loadgen"
+)
+
+// BenchmarkLoad runs concurrent clients doing logins, listings, NOOPs and
+// transfers of mixed sizes, to catch regressions in the session loop and
+// the copy paths. Besides the time per operation, it reports the p99
+// latency of NOOP (the command round trip) and of the transfers. Use
+// -benchmem, -cpuprofile and -memprofile for allocation profiles, or
+// cmd/ftpbench for longer runs.
+func BenchmarkLoad(b *testing.B) {
+	for _, clients := range []int{1, 16, 64} {
+		b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+			rootDir := b.TempDir()
+			driver, err := NewFSDriver(rootDir,
+				WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+					return rootDir, false, nil
+				}),
+			)
+			fatalIfErr(b, err, "Failed to create FS driver")
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			fatalIfErr(b, err, "Failed to listen")
+			s, err := NewServer(ln.Addr().String(),
+				WithDriver(driver),
+				WithLogger(slog.New(slog.DiscardHandler)),
+			)
+			fatalIfErr(b, err, "Failed to create server")
+			go func() {
+				_ = s.Serve(ln)
+			}()
+			b.Cleanup(func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				_ = s.Shutdown(ctx)
+			})
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			res, err := loadgen.Run(context.Background(), loadgen.Config{
+				Addr:     ln.Addr().String(),
+				User:     "bench",
+				Password: "bench",
+				Clients:  clients,
+				Ops:      b.N,
+				Sizes:    []int{1 << 10, 64 << 10, 1 << 20},
+			})
+			b.StopTimer()
+			fatalIfErr(b, err, "Load run failed")
+			if res.Errors > 0 {
+				b.Fatalf("%d errors, first: %v", res.Errors, res.Err)
+			}
+
+			b.ReportMetric(res.Throughput()/1e6, "MB/s")
+			for _, op := range []string{loadgen.OpNoop, loadgen.OpStore, loadgen.OpRetr} {
+				if l, ok := res.Latency[op]; ok {
+					b.ReportMetric(float64(l.P99.Microseconds()), "p99-"+op+"-us")
+				}
+			}
+		})
+	}
+}
@@ -36,13 +36,16 @@ type PathRedactor func(path string) string
 // so implementations don't need to handle nil receivers.
 type MetricsCollector interface {
 	// RecordCommand records metrics for an FTP command execution.
-	// cmd is the command name (e.g., "RETR", "STOR", "LIST").
-	// success indicates whether the command completed successfully.
-	// duration is how long the command took to execute.
+	// cmd is the command name (e.g., "RETR", "STOR", "LIST"). Commands the
+	// server does not know are reported as "UNKNOWN", so cmd can be used as
+	// a metric label without letting clients create arbitrary values.
+	// success indicates whether the command's reply was not an error (4xx
+	// or 5xx). duration is how long the command took to execute; for
+	// transfers, it ends when the transfer starts.
 	RecordCommand(cmd string, success bool, duration time.Duration)
 
 	// RecordTransfer records metrics for a file transfer operation.
-	// operation is either "RETR" (download) or "STOR" (upload).
+	// operation is "RETR" (download), or "STOR", "APPE" or "STOU" (upload).
 	// bytes is the number of bytes transferred.
 	// duration is how long the transfer took.
 	RecordTransfer(operation string, bytes int64, duration time.Duration)
@@ -57,3 +60,23 @@ type MetricsCollector interface {
 	// user is the username that attempted to authenticate.
 	RecordAuthentication(success bool, user string)
 }
+
+// metricsCommand returns the name cmd is reported as to RecordCommand.
+func metricsCommand(cmd string) string {
+	switch cmd {
+	case "USER", "PASS", "QUIT", "NOOP":
+		return cmd
+	}
+	if _, ok := commandHandlers[cmd]; ok {
+		return cmd
+	}
+	return "UNKNOWN"
+}
+
+// SessionMetricsCollector is an optional interface a MetricsCollector can
+// implement to track the number of connected sessions.
+type SessionMetricsCollector interface {
+	// RecordSessions is called with the number of connected sessions each
+	// time a session starts or ends.
+	RecordSessions(active int)
+}
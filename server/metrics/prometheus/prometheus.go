@@ -0,0 +1,200 @@
+// Package prometheus provides a server.MetricsCollector that exports the
+// metrics of an FTP server to Prometheus.
+//
+// It is a separate module, so that the ftp module does not depend on the
+// Prometheus client library.
+//
+// Example:
+//
+//	collector, err := ftpprom.New(prometheus.DefaultRegisterer)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithMetricsCollector(collector),
+//	)
+//	http.Handle("/metrics", promhttp.Handler())
+//
+// The following metrics are exported, with the "ftp" namespace by default:
+//
+//	ftp_connections_total{result, reason}          Connections accepted or rejected
+//	ftp_authentications_total{result}              Logins that succeeded or failed
+//	ftp_commands_total{command, result}            Commands handled
+//	ftp_command_duration_seconds{command}          Command latency
+//	ftp_transfer_bytes_total{direction}            Bytes uploaded or downloaded
+//	ftp_transfer_duration_seconds{direction}       Duration of completed transfers
+//	ftp_active_sessions                            Connected sessions
+//
+// result is "success" or "failure" ("accepted" or "rejected" for
+// connections), and direction is "upload" or "download". Unknown commands
+// are counted as "UNKNOWN".
+package prometheus
+
+import (
+	"time"
+
+	"github.com/gonzalop/ftp/server"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a server.MetricsCollector and server.SessionMetricsCollector
+// exporting Prometheus metrics. It is safe for concurrent use.
+type Collector struct {
+	connections      *prom.CounterVec
+	authentications  *prom.CounterVec
+	commands         *prom.CounterVec
+	commandDuration  *prom.HistogramVec
+	transferBytes    *prom.CounterVec
+	transferDuration *prom.HistogramVec
+	activeSessions   prom.Gauge
+}
+
+var (
+	_ server.MetricsCollector        = (*Collector)(nil)
+	_ server.SessionMetricsCollector = (*Collector)(nil)
+)
+
+// config holds the settings of a Collector.
+type config struct {
+	namespace       string
+	constLabels     prom.Labels
+	commandBuckets  []float64
+	transferBuckets []float64
+}
+
+// Option configures a Collector.
+type Option func(*config)
+
+// WithNamespace sets the namespace prefixed to the metric names. Default is
+// "ftp".
+func WithNamespace(namespace string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+// WithConstLabels adds labels with fixed values to all metrics, e.g. to tell
+// several servers in one process apart.
+func WithConstLabels(labels prom.Labels) Option {
+	return func(c *config) {
+		c.constLabels = labels
+	}
+}
+
+// WithCommandBuckets sets the buckets of the command duration histogram, in
+// seconds. Default is prometheus.DefBuckets.
+func WithCommandBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.commandBuckets = buckets
+	}
+}
+
+// WithTransferBuckets sets the buckets of the transfer duration histogram,
+// in seconds. Default is exponential from 0.1 s to about 27 minutes.
+func WithTransferBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.transferBuckets = buckets
+	}
+}
+
+// New creates a Collector and registers its metrics on reg. It fails if the
+// metrics are already registered, e.g. by another Collector with the same
+// namespace and labels.
+func New(reg prom.Registerer, opts ...Option) (*Collector, error) {
+	cfg := config{
+		namespace:       "ftp",
+		commandBuckets:  prom.DefBuckets,
+		transferBuckets: prom.ExponentialBuckets(0.1, 2, 15),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	counter := func(name, help string, labels ...string) *prom.CounterVec {
+		return prom.NewCounterVec(prom.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: cfg.constLabels,
+		}, labels)
+	}
+	histogram := func(name, help string, buckets []float64, labels ...string) *prom.HistogramVec {
+		return prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace:   cfg.namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: cfg.constLabels,
+			Buckets:     buckets,
+		}, labels)
+	}
+
+	c := &Collector{
+		connections:      counter("connections_total", "Connections accepted or rejected.", "result", "reason"),
+		authentications:  counter("authentications_total", "Login attempts.", "result"),
+		commands:         counter("commands_total", "Commands handled.", "command", "result"),
+		commandDuration:  histogram("command_duration_seconds", "Time to handle a command.", cfg.commandBuckets, "command"),
+		transferBytes:    counter("transfer_bytes_total", "Bytes transferred by completed transfers.", "direction"),
+		transferDuration: histogram("transfer_duration_seconds", "Duration of completed transfers.", cfg.transferBuckets, "direction"),
+		activeSessions: prom.NewGauge(prom.GaugeOpts{
+			Namespace:   cfg.namespace,
+			Name:        "active_sessions",
+			Help:        "Connected sessions.",
+			ConstLabels: cfg.constLabels,
+		}),
+	}
+
+	for _, col := range []prom.Collector{
+		c.connections, c.authentications, c.commands, c.commandDuration,
+		c.transferBytes, c.transferDuration, c.activeSessions,
+	} {
+		if err := reg.Register(col); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// RecordCommand implements server.MetricsCollector.
+func (c *Collector) RecordCommand(cmd string, success bool, duration time.Duration) {
+	c.commands.WithLabelValues(cmd, result(success)).Inc()
+	c.commandDuration.WithLabelValues(cmd).Observe(duration.Seconds())
+}
+
+// RecordTransfer implements server.MetricsCollector.
+func (c *Collector) RecordTransfer(operation string, bytes int64, duration time.Duration) {
+	direction := "upload"
+	if operation == "RETR" {
+		direction = "download"
+	}
+	c.transferBytes.WithLabelValues(direction).Add(float64(bytes))
+	c.transferDuration.WithLabelValues(direction).Observe(duration.Seconds())
+}
+
+// RecordConnection implements server.MetricsCollector.
+func (c *Collector) RecordConnection(accepted bool, reason string) {
+	res := "rejected"
+	if accepted {
+		res = "accepted"
+	}
+	c.connections.WithLabelValues(res, reason).Inc()
+}
+
+// RecordAuthentication implements server.MetricsCollector. The user name is
+// not used as a label, to keep the number of series bounded.
+func (c *Collector) RecordAuthentication(success bool, _ string) {
+	c.authentications.WithLabelValues(result(success)).Inc()
+}
+
+// RecordSessions implements server.SessionMetricsCollector.
+func (c *Collector) RecordSessions(active int) {
+	c.activeSessions.Set(float64(active))
+}
+
+// result returns the result label of a success flag.
+func result(success bool) string {
+	if success {
+		return "success"
+	}
+	return "failure"
+}
@@ -0,0 +1,146 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/server"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	t.Parallel()
+
+	reg := prom.NewPedanticRegistry()
+	c, err := New(reg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	c.RecordConnection(true, "accepted")
+	c.RecordConnection(false, "per_ip_limit_reached")
+	c.RecordAuthentication(false, "mallory")
+	c.RecordAuthentication(true, "alice")
+	c.RecordCommand("RETR", true, 10*time.Millisecond)
+	c.RecordCommand("DELE", false, time.Millisecond)
+	c.RecordTransfer("RETR", 100, time.Second)
+	c.RecordTransfer("STOR", 40, time.Second)
+	c.RecordTransfer("APPE", 2, time.Second)
+	c.RecordSessions(3)
+
+	want := `
+# HELP ftp_active_sessions Connected sessions.
+# TYPE ftp_active_sessions gauge
+ftp_active_sessions 3
+# HELP ftp_authentications_total Login attempts.
+# TYPE ftp_authentications_total counter
+ftp_authentications_total{result="failure"} 1
+ftp_authentications_total{result="success"} 1
+# HELP ftp_commands_total Commands handled.
+# TYPE ftp_commands_total counter
+ftp_commands_total{command="DELE",result="failure"} 1
+ftp_commands_total{command="RETR",result="success"} 1
+# HELP ftp_connections_total Connections accepted or rejected.
+# TYPE ftp_connections_total counter
+ftp_connections_total{reason="accepted",result="accepted"} 1
+ftp_connections_total{reason="per_ip_limit_reached",result="rejected"} 1
+# HELP ftp_transfer_bytes_total Bytes transferred by completed transfers.
+# TYPE ftp_transfer_bytes_total counter
+ftp_transfer_bytes_total{direction="download"} 100
+ftp_transfer_bytes_total{direction="upload"} 42
+`
+	err = testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"ftp_active_sessions", "ftp_authentications_total", "ftp_commands_total",
+		"ftp_connections_total", "ftp_transfer_bytes_total")
+	if err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(c.transferDuration); n != 2 {
+		t.Errorf("Transfer duration series = %d, want 2", n)
+	}
+
+	// A second collector with the same names cannot be registered
+	if _, err := New(reg); err == nil {
+		t.Error("Registering the metrics twice succeeded")
+	}
+	if _, err := New(reg, WithNamespace("ftp2")); err != nil {
+		t.Errorf("New with another namespace: %v", err)
+	}
+}
+
+func TestCollectorWithServer(t *testing.T) {
+	t.Parallel()
+
+	reg := prom.NewRegistry()
+	collector, err := New(reg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver, err := server.NewFSDriver(t.TempDir(), server.WithAnonWrite(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.NewServer(ln.Addr().String(),
+		server.WithDriver(driver),
+		server.WithMetricsCollector(collector),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = s.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+
+	c, err := ftp.Dial(ln.Addr().String(), ftp.WithTimeout(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("anonymous", "guest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Store("data.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := c.Retrieve("data.txt", &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(collector.activeSessions); got != 1 {
+		t.Errorf("Active sessions = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(collector.transferBytes.WithLabelValues("upload")); got != 5 {
+		t.Errorf("Uploaded bytes = %v, want 5", got)
+	}
+	if got := testutil.ToFloat64(collector.authentications.WithLabelValues("success")); got != 1 {
+		t.Errorf("Successful logins = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(collector.commands.WithLabelValues("PASS", "success")); got != 1 {
+		t.Errorf("PASS commands = %v, want 1", got)
+	}
+
+	_ = c.Quit()
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(collector.activeSessions) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Session end not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,6 +1,9 @@
 package server
 
 import (
+	"fmt"
+	"net"
+	"sync"
 	"testing"
 	"time"
 )
@@ -70,3 +73,69 @@ func TestMetricsCollectorNilSafe(t *testing.T) {
 		s.metricsCollector.RecordConnection(true, "accepted")
 	}
 }
+
+// sessionCollector records the commands and session counts it receives.
+type sessionCollector struct {
+	mockMetricsCollector
+
+	mu       sync.Mutex
+	results  map[string][]bool
+	sessions []int
+}
+
+func (c *sessionCollector) RecordCommand(cmd string, success bool, _ time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results[cmd] = append(c.results[cmd], success)
+}
+
+func (c *sessionCollector) RecordSessions(active int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions = append(c.sessions, active)
+}
+
+func TestMetricsCommandsAndSessions(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	driver, err := NewFSDriver(t.TempDir())
+	fatalIfErr(t, err, "Failed to create FS driver")
+	mc := &sessionCollector{results: make(map[string][]bool)}
+	startServer(t, ln, WithDriver(driver), WithMetricsCollector(mc))
+
+	tc, err := rawLogin(ln.Addr().String(), "anonymous", "guest")
+	fatalIfErr(t, err, "Login failed")
+	for _, line := range []string{"PWD", "DELE missing.txt", "FEAT", "XYZZY"} {
+		fmt.Fprintf(tc, "%s\r\n", line)
+		_, _, err := rawReadResponse(tc)
+		fatalIfErr(t, err, "Failed to read reply to "+line)
+	}
+	tc.Close()
+
+	waitFor(t, func() bool {
+		mc.mu.Lock()
+		defer mc.mu.Unlock()
+		return len(mc.sessions) == 2
+	}, "session end not recorded")
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.sessions[0] != 1 || mc.sessions[1] != 0 {
+		t.Errorf("Session counts = %v, want [1 0]", mc.sessions)
+	}
+	want := map[string][]bool{
+		"USER":    {true},
+		"PASS":    {true},
+		"PWD":     {true},
+		"DELE":    {false},
+		"FEAT":    {true},
+		"UNKNOWN": {false},
+	}
+	for cmd, results := range want {
+		if fmt.Sprint(mc.results[cmd]) != fmt.Sprint(results) {
+			t.Errorf("%s: results %v, want %v", cmd, mc.results[cmd], results)
+		}
+	}
+}
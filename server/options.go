@@ -56,6 +56,183 @@ func WithTLS(config *tls.Config) Option {
 	}
 }
 
+// WithTLSCertificateReloader loads the server certificate from certFile and
+// keyFile, and reloads it when the files change, so renewed certificates
+// (e.g. from Let's Encrypt) are used without restarting the server. The files
+// are checked at most once per interval (one minute if zero), when a TLS
+// handshake needs the certificate. Existing connections keep their
+// certificate. If the new files cannot be loaded, the previous certificate is
+// kept and a warning is logged.
+//
+// It enables TLS if WithTLS is not used; otherwise the certificate replaces
+// the Certificates of that configuration.
+//
+// Example:
+//
+//	s, err := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithTLSCertificateReloader("/etc/ftp/cert.pem", "/etc/ftp/key.pem", time.Minute),
+//	)
+func WithTLSCertificateReloader(certFile, keyFile string, interval time.Duration) Option {
+	return func(s *Server) error {
+		r, err := newCertReloader(certFile, keyFile, interval)
+		if err != nil {
+			return err
+		}
+		s.certReloader = r
+		return nil
+	}
+}
+
+// WithTLSHostCertificate sets the certificate for a host name, for serving
+// several domains over FTPS. The certificate is selected by the name the
+// client sends with SNI, or, for clients that don't, by the name given with
+// the HOST command (RFC 7151) before AUTH TLS. host may be a wildcard such as
+// "*.example.com". Other names get the default certificate, from
+// WithTLSCertificateReloader or WithTLS. The files are reloaded when they
+// change, as with WithTLSCertificateReloader.
+//
+// Example:
+//
+//	s, err := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithTLSCertificateReloader("default.pem", "default.key", 0),
+//	    server.WithTLSHostCertificate("ftp.example.com", "example.pem", "example.key", 0),
+//	    server.WithTLSHostCertificate("*.example.org", "org.pem", "org.key", 0),
+//	)
+func WithTLSHostCertificate(host, certFile, keyFile string, interval time.Duration) Option {
+	return func(s *Server) error {
+		r, err := newCertReloader(certFile, keyFile, interval)
+		if err != nil {
+			return err
+		}
+		if s.hostCerts == nil {
+			s.hostCerts = make(map[string]*certReloader)
+		}
+		s.hostCerts[normalizeHost(host)] = r
+		return nil
+	}
+}
+
+// WithTLSMinVersion sets the lowest TLS version accepted on control and
+// data connections, such as tls.VersionTLS13, overriding the MinVersion of
+// the WithTLS configuration. Clients that only support older versions fail
+// the handshake, which is reported as an AuditTLSHandshakeFailed event.
+//
+// For implicit FTPS, create the listener with Server.TLSConfig, which
+// includes this setting.
+//
+// Example:
+//
+//	s, err := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithTLS(tlsConfig),
+//	    server.WithTLSMinVersion(tls.VersionTLS13),
+//	)
+func WithTLSMinVersion(version uint16) Option {
+	return func(s *Server) error {
+		if !validTLSMinVersion(version) {
+			return fmt.Errorf("unsupported TLS version 0x%04x", version)
+		}
+		s.tlsMinVersion = version
+		return nil
+	}
+}
+
+// WithTLSCipherSuites restricts the cipher suites of TLS 1.0 to 1.2
+// connections, overriding the CipherSuites of the WithTLS configuration.
+// Only the secure suites of tls.CipherSuites are accepted; the TLS 1.3
+// suites are always enabled by crypto/tls and cannot be listed.
+//
+// Example:
+//
+//	server.WithTLSCipherSuites(
+//	    tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+//	    tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+//	)
+func WithTLSCipherSuites(suites ...uint16) Option {
+	return func(s *Server) error {
+		if len(suites) == 0 {
+			return fmt.Errorf("no cipher suites given")
+		}
+		for _, id := range suites {
+			if err := checkCipherSuite(id); err != nil {
+				return err
+			}
+		}
+		s.tlsCipherSuites = suites
+		return nil
+	}
+}
+
+// WithRequireTLS refuses logins on clear-text control connections: USER
+// is rejected with 530 until the client has sent AUTH TLS (or connected
+// with implicit FTPS), so that passwords are never sent in the clear. The
+// attempts are reported as AuditTLSDowngrade events with Reason
+// "clear_login". It requires TLS to be configured.
+//
+// Example:
+//
+//	s, err := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithTLS(tlsConfig),
+//	    server.WithRequireTLS(true),
+//	)
+func WithRequireTLS(required bool) Option {
+	return func(s *Server) error {
+		s.requireTLS = required
+		return nil
+	}
+}
+
+// WithVirtualHosts serves several isolated FTP sites from one listener. The
+// site is selected by the HOST command (RFC 7151) or, for clients that don't
+// send it, by the SNI name of the TLS connection. Each site has its own
+// driver, and optionally its own welcome message and TLS certificate. Names
+// may be wildcards such as "*.example.com".
+//
+// Clients that select no site use the driver set with WithDriver. HOST
+// commands naming an unknown site are rejected with 504.
+//
+// Example:
+//
+//	s, err := server.NewServer(":21",
+//	    server.WithDriver(defaultDriver),
+//	    server.WithVirtualHosts(map[string]server.VirtualHost{
+//	        "ftp.example.com": {
+//	            Driver:         exampleDriver,
+//	            WelcomeMessage: "Welcome to example.com",
+//	            CertFile:       "example.pem",
+//	            KeyFile:        "example.key",
+//	        },
+//	        "ftp.example.org": {Driver: orgDriver},
+//	    }),
+//	)
+func WithVirtualHosts(hosts map[string]VirtualHost) Option {
+	return func(s *Server) error {
+		if s.virtualHosts == nil {
+			s.virtualHosts = make(map[string]VirtualHost)
+		}
+		for name, vh := range hosts {
+			if vh.Driver == nil {
+				return fmt.Errorf("virtual host %s: driver is required", name)
+			}
+			if vh.CertFile != "" || vh.KeyFile != "" {
+				r, err := newCertReloader(vh.CertFile, vh.KeyFile, 0)
+				if err != nil {
+					return fmt.Errorf("virtual host %s: %w", name, err)
+				}
+				if s.hostCerts == nil {
+					s.hostCerts = make(map[string]*certReloader)
+				}
+				s.hostCerts[normalizeHost(name)] = r
+			}
+			s.virtualHosts[normalizeHost(name)] = vh
+		}
+		return nil
+	}
+}
+
 // WithLogger sets a custom logger for the server.
 // If not specified, slog.Default() is used.
 //
@@ -78,6 +255,10 @@ func WithLogger(logger *slog.Logger) Option {
 // WithMaxIdleTime sets the maximum time a connection can be idle before being closed.
 // If not specified, defaults to 5 minutes.
 //
+// A session is idle while it sends no commands and no transfer data moves,
+// so long transfers are not interrupted. Idle sessions get a 421 reply
+// before the connection is closed. It is not used if WithReadTimeout is set.
+//
 // Example:
 //
 //	s, _ := server.NewServer(":21",
@@ -113,6 +294,68 @@ func WithMaxConnections(max, maxPerIP int) Option {
 	}
 }
 
+// WithMaxCommandRate sets the maximum number of commands per second a
+// session can send. Sessions exceeding it get a 421 reply and are
+// disconnected. Commands pipelined in a single burst count individually, so
+// the limit must leave room for clients that pipeline metadata commands.
+// If 0, there is no limit (default).
+//
+// Independently of this option, sessions are disconnected after
+// 64 empty command lines in a row, or 10 PASV/EPSV commands in a row without
+// a data transfer command.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithMaxCommandRate(200),
+//	)
+func WithMaxCommandRate(perSecond int) Option {
+	return func(s *Server) error {
+		s.maxCommandRate = perSecond
+		return nil
+	}
+}
+
+// WithMaxPathLength sets the maximum length in bytes of path arguments.
+// Commands with longer paths get a 553 reply without calling the driver.
+// The default is MaxPathLength; 0 disables the check.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithMaxPathLength(4096),
+//	)
+func WithMaxPathLength(length int) Option {
+	return func(s *Server) error {
+		s.maxPathLength = length
+		return nil
+	}
+}
+
+// WithMaxSessionsPerListener sets the maximum number of sessions served at
+// once from each listener passed to Serve. If 0, there is no limit.
+//
+// Unlike WithMaxConnections, which accepts connections over the limit and
+// replies 421, the server stops accepting connections while a listener has
+// its maximum number of sessions. New clients wait in the kernel's listen
+// backlog until a session ends, which gives backpressure under high
+// connection churn instead of failures.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithMaxSessionsPerListener(10000),
+//	)
+func WithMaxSessionsPerListener(max int) Option {
+	return func(s *Server) error {
+		s.maxSessionsPerListener = max
+		return nil
+	}
+}
+
 // WithDisableMLSD disables the MLSD command.
 // This is primarily useful for compatibility testing with legacy clients.
 //
@@ -145,6 +388,50 @@ func WithWelcomeMessage(message string) Option {
 	}
 }
 
+// WithBannerFunc sets a function returning the banner of each session,
+// instead of the static message of WithWelcomeMessage. It receives the
+// client's address, and the host selected by SNI or HOST, so the banner can
+// depend on the network or site. It is called on connection and for the
+// reply to HOST. Messages with several lines are sent as a multi-line reply.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithBannerFunc(func(info server.SessionInfo) string {
+//	        if strings.HasPrefix(info.RemoteIP, "10.") {
+//	            return "Internal mirror\nAuthorized use only"
+//	        }
+//	        return ""
+//	    }),
+//	)
+func WithBannerFunc(fn BannerFunc) Option {
+	return func(s *Server) error {
+		s.bannerFunc = fn
+		return nil
+	}
+}
+
+// WithLoginMessageFunc sets a function returning the message of the 230
+// reply to a successful login, such as the time of the last login or quota
+// usage. It receives the session and the driver's context for the user.
+// Messages with several lines are sent as a multi-line reply.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithLoginMessageFunc(func(info server.SessionInfo, _ any) string {
+//	        return fmt.Sprintf("Welcome, %s\nLast login: %s", info.User, lastLogin(info.User))
+//	    }),
+//	)
+func WithLoginMessageFunc(fn LoginMessageFunc) Option {
+	return func(s *Server) error {
+		s.loginMessageFunc = fn
+		return nil
+	}
+}
+
 // WithServerName sets the system type returned by the SYST command.
 // If not specified, defaults to "UNIX Type: L8".
 //
@@ -204,6 +491,44 @@ func WithWriteTimeout(duration time.Duration) Option {
 	}
 }
 
+// WithTransferStallTimeout aborts data transfers that move no data for the
+// given duration with a 426 reply. Unlike WithReadTimeout and
+// WithWriteTimeout, it does not bound how long a transfer can take, only how
+// long it can make no progress, so slow but steady transfers complete.
+// If 0 (default), stalled transfers are not aborted.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithTransferStallTimeout(time.Minute),
+//	)
+func WithTransferStallTimeout(duration time.Duration) Option {
+	return func(s *Server) error {
+		s.transferStallTimeout = duration
+		return nil
+	}
+}
+
+// WithMaxSessionDuration limits how long a session can stay connected,
+// whether busy or idle. When the limit is reached, the client gets a 421
+// reply, any transfer in progress is aborted and the connection is closed.
+// This keeps public anonymous servers from being monopolized by a few
+// clients. If 0 (default), there is no limit.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithMaxSessionDuration(2*time.Hour),
+//	)
+func WithMaxSessionDuration(duration time.Duration) Option {
+	return func(s *Server) error {
+		s.maxSessionDuration = duration
+		return nil
+	}
+}
+
 // WithPathRedactor sets a custom path redaction function for privacy compliance.
 // The function will be called for every path logged, allowing custom redaction logic.
 //
@@ -231,6 +556,29 @@ func WithPathRedactor(redactor PathRedactor) Option {
 	}
 }
 
+// WithAuditSink sets the destination of security events: logins, permission
+// denials, path traversal attempts, bounce attacks, TLS downgrades, disabled
+// commands, rejected connections and abusive sessions (see AuditEventType).
+//
+// By default, these events are written to the server logger (see
+// WithLogger) as WARN records, or INFO for successful logins. A custom sink
+// replaces that output. If sink is nil, security events are discarded.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithAuditSink(server.AuditSinkFunc(func(e server.AuditEvent) {
+//	        siem.Send(string(e.Type), e.RemoteIP, e.User, e.Reason)
+//	    })),
+//	)
+func WithAuditSink(sink AuditSink) Option {
+	return func(s *Server) error {
+		s.auditSink = sink
+		return nil
+	}
+}
+
 // WithRedactIPs enables IP address redaction in logs for privacy compliance.
 // When enabled, the last octet of IPv4 addresses is replaced with "xxx".
 //
@@ -255,6 +603,425 @@ func WithEnableDirMessage(enabled bool) Option {
 	}
 }
 
+// WithAllowFXP allows server-to-server (FXP) transfers.
+// By default, PORT and EPRT are rejected unless they target the client's own
+// IP address, which prevents FTP bounce attacks. When enabled, authenticated
+// users may direct data connections to any host, so a client can have this
+// server send files directly to (or receive files from) another FTP server.
+//
+// Only enable this for trusted users, as it allows the server to be used to
+// open connections to arbitrary hosts.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithAllowFXP(true),
+//	)
+func WithAllowFXP(allow bool) Option {
+	return func(s *Server) error {
+		s.allowFXP = allow
+		return nil
+	}
+}
+
+// WithActiveAllowedNetworks allows PORT and EPRT to target addresses in the
+// given networks, in CIDR notation, besides the client's own address. Use it
+// for FXP with known servers, or when the client's data connections come
+// from another address than its control connection (e.g. split NAT paths).
+// Unlike WithAllowFXP, other third-party addresses are still rejected.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithActiveAllowedNetworks("192.0.2.0/24", "2001:db8::/32"),
+//	)
+func WithActiveAllowedNetworks(cidrs ...string) Option {
+	return func(s *Server) error {
+		for _, cidr := range cidrs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("invalid active network: %w", err)
+			}
+			s.activeNetworks = append(s.activeNetworks, network)
+		}
+		return nil
+	}
+}
+
+// WithBlockPrivilegedPorts rejects PORT and EPRT commands targeting ports
+// below 1024, even on the client's own address or an allowed network. This
+// keeps the server from being used to send data to services such as SMTP.
+// FTP clients listen on unprivileged ports, so normal active mode transfers
+// are not affected.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithBlockPrivilegedPorts(true),
+//	)
+func WithBlockPrivilegedPorts(block bool) Option {
+	return func(s *Server) error {
+		s.blockPrivileged = block
+		return nil
+	}
+}
+
+// WithAllowMixedAddressFamilies allows PORT and EPRT to name an address of a
+// different family (IPv4 or IPv6) than the client's control connection. By
+// default, such commands are rejected with 522. Without WithAllowFXP, the
+// address must be the client's own, so this option only matters for FXP
+// transfers between an IPv4 and an IPv6 server.
+func WithAllowMixedAddressFamilies(allow bool) Option {
+	return func(s *Server) error {
+		s.mixedFamilies = allow
+		return nil
+	}
+}
+
+// WithActiveDataSource sets the local address that active mode (PORT/EPRT)
+// data connections are opened from, as "host:port". Strict firewalls often
+// only allow data connections from port 20, the FTP data port of RFC 959:
+//
+//	server.WithActiveDataSource(":20")
+//
+// The host may be empty to let the system choose the source address. Binding
+// to ports below 1024 usually requires privileges (e.g. CAP_NET_BIND_SERVICE
+// on Linux). By default, the system chooses both the address and the port.
+func WithActiveDataSource(addr string) Option {
+	return func(s *Server) error {
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("invalid active data source: %w", err)
+		}
+		s.activeSource = tcpAddr
+		return nil
+	}
+}
+
+// WithPassiveAddress sets a function choosing the address advertised in
+// PASV replies, per session. It takes precedence over the static PublicHost
+// setting of the driver, for servers reachable through several NATs or
+// dual-stack load balancers, where the right address depends on the
+// interface the client connected to. LocalAddressForPrivateClients is a
+// ready-made function for clients on the internal network.
+//
+// Example:
+//
+//	server.WithPassiveAddress(func(sess server.SessionInfo) (net.IP, error) {
+//	    switch sess.LocalIP {
+//	    case "10.0.1.5": // Behind the first load balancer
+//	        return net.ParseIP("203.0.113.10"), nil
+//	    case "10.0.2.5": // Behind the second one
+//	        return net.ParseIP("198.51.100.20"), nil
+//	    }
+//	    return nil, nil // Default: PublicHost or the local address
+//	})
+//
+// EPSV replies carry no address, so they are not affected.
+func WithPassiveAddress(fn PassiveAddressFunc) Option {
+	return func(s *Server) error {
+		s.passiveAddress = fn
+		return nil
+	}
+}
+
+// WithZeroCopy enables or disables zero-copy downloads. By default, plaintext
+// binary RETR transfers of files without bandwidth limits are sent with the
+// kernel's zero-copy path (sendfile(2) on Linux), which cuts the CPU cost of
+// serving large files. Transfers over TLS, in ASCII mode or rate limited
+// always go through a buffer. Disable it if a driver's files misbehave with
+// sendfile, e.g. on some network filesystems.
+func WithZeroCopy(enabled bool) Option {
+	return func(s *Server) error {
+		s.zeroCopy = enabled
+		return nil
+	}
+}
+
+// WithTransferBufferSize sets the size of the buffers used to copy data
+// between files and data connections (default 32 KiB). Larger buffers mean
+// fewer system calls per transfer, at the cost of memory per transfer in
+// progress.
+func WithTransferBufferSize(size int) Option {
+	return func(s *Server) error {
+		if size < 512 {
+			return fmt.Errorf("transfer buffer size must be at least 512 bytes")
+		}
+		s.bufferPool = newBufferPool(size)
+		return nil
+	}
+}
+
+// WithDataSocketOptions tunes the TCP sockets of data connections: buffer
+// sizes, TCP_NODELAY and keep-alives. Raise the buffer sizes for links with a
+// high bandwidth-delay product, where the system defaults cap the
+// throughput.
+//
+// Example:
+//
+//	server.WithDataSocketOptions(server.SocketOptions{
+//	    ReadBuffer:  4 << 20,
+//	    WriteBuffer: 4 << 20,
+//	    KeepAlive:   30 * time.Second,
+//	})
+func WithDataSocketOptions(opts SocketOptions) Option {
+	return func(s *Server) error {
+		if opts.ReadBuffer < 0 || opts.WriteBuffer < 0 {
+			return fmt.Errorf("socket buffer sizes cannot be negative")
+		}
+		s.socketOptions = &opts
+		return nil
+	}
+}
+
+// WithSiteSymlink enables the SITE SYMLINK command, which lets users create
+// symbolic links with "SITE SYMLINK <target> <link>". The driver must
+// implement Symlinker; otherwise the command replies 502, as it does when
+// this option is not set. FSDriver refuses links whose target is outside the
+// user's root.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithSiteSymlink(true),
+//	)
+func WithSiteSymlink(enabled bool) Option {
+	return func(s *Server) error {
+		s.siteSymlink = enabled
+		return nil
+	}
+}
+
+// WithSiteCommand adds a custom SITE command, such as "SITE QUOTA" or
+// "SITE WHO". The name is case-insensitive and is listed by SITE HELP; it
+// cannot be one of the built-in SITE commands. The command requires a
+// logged-in user. Build multi-line replies with NewReply.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithSiteCommand("WHOAMI", func(info server.SessionInfo, args string) *server.Reply {
+//	        return server.NewReply(200, "You are "+info.User+".")
+//	    }),
+//	)
+func WithSiteCommand(name string, fn SiteCommandFunc) Option {
+	return func(s *Server) error {
+		name = strings.ToUpper(name)
+		if name == "" || strings.ContainsAny(name, " \t\r\n") {
+			return fmt.Errorf("invalid SITE command name %q", name)
+		}
+		if siteBuiltins[name] {
+			return fmt.Errorf("SITE %s is a built-in command", name)
+		}
+		if fn == nil {
+			return fmt.Errorf("SITE %s handler cannot be nil", name)
+		}
+		if s.siteCommands == nil {
+			s.siteCommands = make(map[string]SiteCommandFunc)
+		}
+		s.siteCommands[name] = fn
+		return nil
+	}
+}
+
+// WithRecursiveMKD makes MKD create missing parent directories, so that a
+// client can create a nested path such as "a/b/c" in one command. This is a
+// vendor extension offered by some servers; standard clients create each
+// level with its own MKD and are not affected.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithRecursiveMKD(true),
+//	)
+func WithRecursiveMKD(enabled bool) Option {
+	return func(s *Server) error {
+		s.recursiveMKD = enabled
+		return nil
+	}
+}
+
+// WithFilenameEncoding sets the encoding used for file names by clients that
+// do not opt in to UTF-8. Paths in commands are decoded to UTF-8 before being
+// passed to the driver, and names in replies and listings are encoded back.
+// Clients that send "OPTS UTF8 ON" receive UTF-8 names unchanged.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithFilenameEncoding(server.Latin1),
+//	)
+func WithFilenameEncoding(enc FilenameEncoding) Option {
+	return func(s *Server) error {
+		s.filenameEncoding = enc
+		return nil
+	}
+}
+
+// WithUploadPolicy sets the server-wide upload policy: maximum and minimum
+// file sizes, allowed file names, banned path characters and what happens
+// to existing files. It is enforced for STOR, APPE and STOU before the
+// driver is called. Names that are not allowed and refused overwrites are
+// rejected with 553; size violations with 552. A policy in the
+// session's Settings (see Settings.UploadPolicy) takes precedence, so
+// authenticators can apply per-user limits.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithUploadPolicy(server.UploadPolicy{
+//	        MaxFileSize:       100 << 20, // 100 MiB
+//	        AllowedExtensions: []string{".jpg", ".png"},
+//	        BannedChars:       "\\:*?\"<>|",
+//	    }),
+//	)
+func WithUploadPolicy(policy UploadPolicy) Option {
+	return func(s *Server) error {
+		if policy.MaxFileSize < 0 || policy.MinFileSize < 0 {
+			return fmt.Errorf("upload policy sizes cannot be negative")
+		}
+		if policy.MaxFileSize > 0 && policy.MinFileSize > policy.MaxFileSize {
+			return fmt.Errorf("upload policy minimum size exceeds maximum size")
+		}
+		if policy.Overwrite < OverwriteAllow || policy.Overwrite > OverwriteVersion {
+			return fmt.Errorf("invalid overwrite policy: %d", policy.Overwrite)
+		}
+		s.uploadPolicy = &policy
+		return nil
+	}
+}
+
+// WithUniqueNames sets the function naming the files stored with STOU,
+// instead of the default "ftp-<number>" names. UniqueNameTemplate builds one
+// from a prefix and a suffix, and NumberedUniqueName honors the name the
+// client passes to STOU, numbering it if the file exists. Names derived from
+// the client's argument are subject to the upload policy.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithUniqueNames(server.UniqueNameTemplate("scan-", ".pdf")),
+//	)
+func WithUniqueNames(fn UniqueNameFunc) Option {
+	return func(s *Server) error {
+		if fn == nil {
+			return fmt.Errorf("unique name function cannot be nil")
+		}
+		s.uniqueName = fn
+		return nil
+	}
+}
+
+// WithStreamHooks wraps the data of every transfer with hooks, in order:
+// the first hook is the closest to the client. Hooks can process the data
+// on the fly and fail the transfer with a specific reply (see StreamHook).
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithStreamHooks(virusScanner, checksummer),
+//	)
+func WithStreamHooks(hooks ...StreamHook) Option {
+	return func(s *Server) error {
+		for _, h := range hooks {
+			if h == nil {
+				return fmt.Errorf("stream hook cannot be nil")
+			}
+		}
+		s.streamHooks = hooks
+		return nil
+	}
+}
+
+// WithAtomicUploads makes STOR write uploads to a temporary file in the same
+// directory and rename it into place only once the transfer has completed,
+// so other clients never read half-written files. Failed or aborted uploads
+// are deleted and leave any existing file untouched.
+//
+// If the driver's ClientContext implements UploadFinalizer, it is called
+// before the rename and can reject the upload, which is reported with a 451
+// reply. Uploads resumed with REST are written in place. Default is false.
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithAtomicUploads(true),
+//	)
+func WithAtomicUploads(enabled bool) Option {
+	return func(s *Server) error {
+		s.atomicUploads = enabled
+		return nil
+	}
+}
+
+// WithPartialUploads selects what happens to the file of an upload that did
+// not complete because the connection dropped or the client aborted it before
+// the 226 reply. With PartialUploadKeep (the default) the file stays in place,
+// so the client can resume it with REST and STOR, or APPE.
+// PartialUploadDelete removes it, and PartialUploadQuarantine moves it into
+// quarantineDir, a directory in the driver's namespace that must exist.
+//
+// The policy only applies to files created by the transfer: appends and
+// resumed uploads always keep the data that was written. Incomplete
+// transfers are logged, and recorded in the transfer log with status "i".
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithPartialUploads(server.PartialUploadQuarantine, "/.quarantine"),
+//	)
+func WithPartialUploads(policy PartialUploadPolicy, quarantineDir string) Option {
+	return func(s *Server) error {
+		switch policy {
+		case PartialUploadKeep, PartialUploadDelete:
+		case PartialUploadQuarantine:
+			if !strings.HasPrefix(quarantineDir, "/") {
+				return fmt.Errorf("quarantine directory must be an absolute path")
+			}
+		default:
+			return fmt.Errorf("invalid partial upload policy: %d", policy)
+		}
+		s.partialUploads = policy
+		s.quarantineDir = strings.TrimSuffix(quarantineDir, "/")
+		return nil
+	}
+}
+
+// WithStatCacheTTL enables a per-session cache of file metadata, so that
+// SIZE, MDTM and MLST storms (typically after a LIST) do not hit the driver
+// for every command. Entries listed by LIST and MLSD are cached too.
+// Cached entries expire after ttl, and the whole cache is cleared by any
+// command that modifies files in the session. Changes made by other sessions
+// may be seen up to ttl late. Default is 0 (disabled).
+//
+// Example:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(s3Driver),
+//	    server.WithStatCacheTTL(5*time.Second),
+//	)
+func WithStatCacheTTL(ttl time.Duration) Option {
+	return func(s *Server) error {
+		if ttl < 0 {
+			return fmt.Errorf("stat cache TTL cannot be negative")
+		}
+		s.statCacheTTL = ttl
+		return nil
+	}
+}
+
 // WithMetricsCollector sets an optional metrics collector for monitoring.
 // The collector will receive metrics about commands, transfers, connections,
 // and authentication attempts.
@@ -282,9 +1049,33 @@ func WithMetricsCollector(collector MetricsCollector) Option {
 //	    server.WithDriver(driver),
 //	    server.WithTransferLog(logFile),
 //	)
+//
+// It is a shorthand for WithTransferLogger(NewXferLogger(w)). Use that form
+// to keep the XferLogger and reopen the file on log rotation.
 func WithTransferLog(w io.Writer) Option {
+	return WithTransferLogger(NewXferLogger(w))
+}
+
+// WithTransferLogger adds a logger for file transfers, such as an XferLogger
+// or a JSONTransferLogger. It can be given several times, e.g. to write both
+// an xferlog file and JSON lines for a log pipeline.
+//
+// Example:
+//
+//	jsonLog := server.NewJSONTransferLogger(logFile)
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithTransferLogger(jsonLog),
+//	)
+//	// On SIGHUP, after logrotate moved the file:
+//	newFile, _ := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+//	jsonLog.Swap(newFile).(*os.File).Close()
+func WithTransferLogger(l TransferLogger) Option {
 	return func(s *Server) error {
-		s.transferLog = w
+		if l == nil {
+			return fmt.Errorf("transfer logger cannot be nil")
+		}
+		s.transferLoggers = append(s.transferLoggers, l)
 		return nil
 	}
 }
@@ -301,6 +1092,9 @@ func WithTransferLog(w io.Writer) Option {
 //	    server.WithDriver(driver),
 //	    server.WithBandwidthLimit(10*1024*1024, 1024*1024), // 10 MB/s global, 1 MB/s per user
 //	)
+//
+// The per-user limit applies to users without a bandwidth class (see
+// WithBandwidthClasses) or a limit set with Server.SetUserBandwidth.
 func WithBandwidthLimit(global, perUser int64) Option {
 	return func(s *Server) error {
 		s.bandwidthLimitGlobal = global
@@ -309,8 +1103,53 @@ func WithBandwidthLimit(global, perUser int64) Option {
 	}
 }
 
+// WithBandwidthClasses sets the per-user bandwidth (bytes/sec, 0 = unlimited)
+// of named classes of users, such as "premium" and "free". The class of a
+// user is the BandwidthClass of the Settings returned by the driver for the
+// session, e.g. with WithUserSettings for an FSDriver. Users without a class,
+// or with an unknown one, get the per-user limit of WithBandwidthLimit. The
+// global limit still applies to everyone.
+//
+// Classes can be changed at runtime with Server.SetBandwidthClass.
+//
+// Example:
+//
+//	driver, _ := server.NewFSDriver("/srv/ftp",
+//	    server.WithAuthenticator(auth),
+//	    server.WithUserSettings(func(user string) *server.Settings {
+//	        return &server.Settings{BandwidthClass: accounts.Plan(user)}
+//	    }),
+//	)
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithBandwidthClasses(map[string]int64{
+//	        "premium": 10 * 1024 * 1024, // 10 MB/s
+//	        "free":    512 * 1024,       // 512 KB/s
+//	    }),
+//	)
+func WithBandwidthClasses(classes map[string]int64) Option {
+	return func(s *Server) error {
+		if s.bandwidthClasses == nil {
+			s.bandwidthClasses = make(map[string]int64, len(classes))
+		}
+		for class, rate := range classes {
+			if rate < 0 {
+				return fmt.Errorf("bandwidth of class %q cannot be negative", class)
+			}
+			s.bandwidthClasses[class] = rate
+		}
+		return nil
+	}
+}
+
 // ListenerFactory creates listeners for passive mode data connections.
 // This allows custom transport implementations (e.g., QUIC).
+//
+// A control connection (the net.Conn returned by the listener given to
+// Serve) may implement ListenerFactory itself. Its session then creates
+// passive listeners with it instead of the server's factory, which lets
+// transports such as QUIC carry data connections as streams of the control
+// connection's transport connection.
 type ListenerFactory interface {
 	Listen(network, address string) (net.Listener, error)
 }
@@ -338,6 +1177,33 @@ func WithListenerFactory(factory ListenerFactory) Option {
 	}
 }
 
+// WithDataConnectionReuse lets clients keep the data connection open
+// between transfers, saving a PASV or EPSV and a new connection per file.
+// This matters most for transports where opening a data connection is
+// expensive, such as QUIC streams from a custom ListenerFactory, or for
+// many small files over TLS.
+//
+// When enabled, FEAT lists DATA-REUSE and MODE B (block mode, RFC 959
+// Section 3.4.2) is accepted. In block mode each transfer ends with an
+// end of file block rather than by closing the connection; the server then
+// keeps the connection, and the next transfer command uses it with a 125
+// reply. A failed or aborted transfer closes it, as do PASV, EPSV, PORT,
+// EPRT and MODE S. Clients using Stream mode are not affected.
+//
+// Example:
+//
+//	srv, _ := server.NewServer(":21",
+//	    server.WithDriver(driver),
+//	    server.WithListenerFactory(&QuicListenerFactory{...}),
+//	    server.WithDataConnectionReuse(true),
+//	)
+func WithDataConnectionReuse(enabled bool) Option {
+	return func(s *Server) error {
+		s.dataReuse = enabled
+		return nil
+	}
+}
+
 // WithDisableCommands disables specific FTP commands.
 // The server responds with "502 Command not implemented" for disabled commands.
 //
@@ -3,6 +3,7 @@ package server
 import (
 	"crypto/tls"
 	"log/slog"
+	"net"
 	"os"
 	"testing"
 	"time"
@@ -298,3 +299,40 @@ func TestWithWriteTimeout(t *testing.T) {
 		t.Errorf("Expected write timeout %v, got %v", customTimeout, s.writeTimeout)
 	}
 }
+
+// TestWithAllowFXP tests that WithAllowFXP relaxes the active-IP check for
+// authenticated sessions only.
+func TestWithAllowFXP(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	driver, _ := NewFSDriver(tempDir)
+
+	s, err := NewServer(":0", WithDriver(driver), WithAllowFXP(true))
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	if !s.allowFXP {
+		t.Fatal("allowFXP not set")
+	}
+
+	client, srv := net.Pipe()
+	defer client.Close()
+	defer srv.Close()
+
+	sess := &session{server: s, conn: srv}
+	thirdParty := net.ParseIP("192.0.2.10")
+
+	if sess.validateActiveIP(thirdParty) {
+		t.Error("Expected third-party IP to be rejected before login")
+	}
+
+	sess.isLoggedIn = true
+	if !sess.validateActiveIP(thirdParty) {
+		t.Error("Expected third-party IP to be allowed after login with FXP enabled")
+	}
+
+	s.allowFXP = false
+	if sess.validateActiveIP(thirdParty) {
+		t.Error("Expected third-party IP to be rejected with FXP disabled")
+	}
+}
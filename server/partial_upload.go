@@ -0,0 +1,75 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PartialUploadPolicy selects what happens to the file of an upload that did
+// not complete. See WithPartialUploads.
+type PartialUploadPolicy int
+
+const (
+	// PartialUploadKeep leaves the partial file in place so it can be resumed.
+	PartialUploadKeep PartialUploadPolicy = iota
+
+	// PartialUploadDelete removes the partial file.
+	PartialUploadDelete
+
+	// PartialUploadQuarantine moves the partial file to the quarantine directory.
+	PartialUploadQuarantine
+)
+
+// logIncompleteTransfer records a transfer that ended before its 226 reply.
+func (s *session) logIncompleteTransfer(cmd, path string, bytes int64, duration time.Duration) {
+	s.server.logger.Warn("transfer_incomplete",
+		"session_id", s.sessionID,
+		"remote_ip", s.redactIP(s.remoteIP),
+		"user", s.user,
+		"host", s.host,
+		"operation", cmd,
+		"path", s.redactPath(path),
+		"bytes", bytes,
+		"duration_ms", duration.Milliseconds(),
+	)
+	s.logTransfer(cmd, path, bytes, duration, false)
+}
+
+// abortUpload handles an upload to path that did not complete: it logs the
+// transfer as incomplete and applies the server's PartialUploadPolicy to
+// target, the file that was written. created tells whether the transfer
+// created target; if not (appends and resumed uploads), the file is kept.
+// Temporary files of atomic uploads cannot be resumed and are never kept.
+func (s *session) abortUpload(cmd, path, target string, created bool, bytes int64, duration time.Duration) {
+	s.logIncompleteTransfer(cmd, path, bytes, duration)
+	if !created {
+		return
+	}
+
+	switch s.server.partialUploads {
+	case PartialUploadDelete:
+		s.discardUpload(true, target)
+	case PartialUploadQuarantine:
+		s.quarantineUpload(path, target)
+	default:
+		s.discardUpload(target != path, target)
+	}
+}
+
+// quarantineUpload moves target, the partial file of an upload to path, into
+// the quarantine directory. The name includes the session ID and time so
+// repeated attempts do not overwrite each other. If the move fails, the file
+// is left in place, unless it is the temporary file of an atomic upload.
+func (s *session) quarantineUpload(path, target string) {
+	name := path[strings.LastIndex(path, "/")+1:]
+	dest := fmt.Sprintf("%s/%s.%s.%s", s.server.quarantineDir, name, s.sessionID, time.Now().Format("20060102150405"))
+	if err := s.fs.Rename(s.context(), target, dest); err != nil {
+		s.server.logger.Warn("upload_quarantine_failed",
+			"session_id", s.sessionID,
+			"path", s.redactPath(target),
+			"error", err,
+		)
+		s.discardUpload(target != path, target)
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// interruptUpload starts an upload with cmd, sends data, waits until the
+// server has written it to written (a local path), then drops the control
+// connection without finishing the transfer. The data connection is left
+// open until the end of the test, so the server cannot mistake the drop for
+// the end of the file.
+func interruptUpload(t *testing.T, addr, cmd, name, data, written string) {
+	t.Helper()
+
+	conn, err := rawLogin(addr, "test", "test")
+	fatalIfErr(t, err, "rawLogin failed")
+	defer conn.Close()
+
+	dataAddr, err := rawEnterPasv(conn)
+	fatalIfErr(t, err, "rawEnterPasv failed")
+	dataConn, err := net.DialTimeout("tcp", dataAddr, 5*time.Second)
+	fatalIfErr(t, err, "Dial data port failed")
+	t.Cleanup(func() { dataConn.Close() })
+
+	fmt.Fprintf(conn, "%s %s\r\n", cmd, name)
+	if code, msg, err := rawReadResponse(conn); err != nil || !isPreliminary(code) {
+		t.Fatalf("%s: expected 125 or 150, got %d %q (%v)", cmd, code, msg, err)
+	}
+	_, err = dataConn.Write([]byte(data))
+	fatalIfErr(t, err, "data write failed")
+
+	waitFor(t, func() bool {
+		b, err := os.ReadFile(written)
+		return err == nil && strings.HasSuffix(string(b), data)
+	}, "upload data was not written")
+}
+
+// waitFor polls cond until it is true, failing the test after two seconds.
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForIncomplete waits until an incomplete transfer of name has been
+// recorded in the transfer log.
+func waitForIncomplete(t *testing.T, xferlog *safeBuffer, name string) {
+	t.Helper()
+	waitFor(t, func() bool {
+		for _, line := range strings.Split(xferlog.String(), "\n") {
+			if strings.Contains(line, " "+name+" ") && strings.HasSuffix(line, " i") {
+				return true
+			}
+		}
+		return false
+	}, "incomplete transfer not logged")
+}
+
+func TestPartialUploads(t *testing.T) {
+	t.Parallel()
+
+	t.Run("keep", func(t *testing.T) {
+		t.Parallel()
+		driver, rootDir := newTestFSDriver(t)
+		var xferlog safeBuffer
+		addr := serveDriver(t, driver, WithTransferLog(&xferlog))
+		path := filepath.Join(rootDir, "big.bin")
+
+		interruptUpload(t, addr, "STOR", "big.bin", "first chunk", path)
+		waitForIncomplete(t, &xferlog, "big.bin")
+
+		data, err := os.ReadFile(path)
+		fatalIfErr(t, err, "partial file not kept")
+		if string(data) != "first chunk" {
+			t.Errorf("content = %q, want %q", data, "first chunk")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		t.Parallel()
+		driver, rootDir := newTestFSDriver(t)
+		var xferlog safeBuffer
+		addr := serveDriver(t, driver, WithTransferLog(&xferlog), WithPartialUploads(PartialUploadDelete, ""))
+		path := filepath.Join(rootDir, "big.bin")
+
+		interruptUpload(t, addr, "STOR", "big.bin", "first chunk", path)
+		waitForIncomplete(t, &xferlog, "big.bin")
+		waitFor(t, func() bool {
+			_, err := os.Stat(path)
+			return os.IsNotExist(err)
+		}, "partial file not deleted")
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		t.Parallel()
+		driver, rootDir := newTestFSDriver(t)
+		var xferlog safeBuffer
+		addr := serveDriver(t, driver, WithTransferLog(&xferlog), WithPartialUploads(PartialUploadQuarantine, "/.quarantine"))
+		fatalIfErr(t, os.Mkdir(filepath.Join(rootDir, ".quarantine"), 0755), "Mkdir failed")
+		path := filepath.Join(rootDir, "big.bin")
+
+		interruptUpload(t, addr, "STOR", "big.bin", "first chunk", path)
+		waitForIncomplete(t, &xferlog, "big.bin")
+
+		var moved []string
+		waitFor(t, func() bool {
+			moved, _ = filepath.Glob(filepath.Join(rootDir, ".quarantine", "big.bin.*"))
+			return len(moved) == 1
+		}, "partial file not quarantined")
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("partial file left in place: %v", err)
+		}
+		data, err := os.ReadFile(moved[0])
+		fatalIfErr(t, err, "ReadFile failed")
+		if string(data) != "first chunk" {
+			t.Errorf("content = %q, want %q", data, "first chunk")
+		}
+	})
+
+	t.Run("append is kept", func(t *testing.T) {
+		t.Parallel()
+		driver, rootDir := newTestFSDriver(t)
+		var xferlog safeBuffer
+		addr := serveDriver(t, driver, WithTransferLog(&xferlog), WithPartialUploads(PartialUploadDelete, ""))
+		path := filepath.Join(rootDir, "log.txt")
+		fatalIfErr(t, os.WriteFile(path, []byte("old "), 0644), "WriteFile failed")
+
+		interruptUpload(t, addr, "APPE", "log.txt", "new", path)
+		waitForIncomplete(t, &xferlog, "log.txt")
+
+		data, err := os.ReadFile(path)
+		fatalIfErr(t, err, "appended file deleted")
+		if string(data) != "old new" {
+			t.Errorf("content = %q, want %q", data, "old new")
+		}
+	})
+}
+
+func TestWithPartialUploads_Invalid(t *testing.T) {
+	t.Parallel()
+
+	driver, err := NewFSDriver(t.TempDir())
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{"quarantine without directory", WithPartialUploads(PartialUploadQuarantine, "")},
+		{"relative quarantine directory", WithPartialUploads(PartialUploadQuarantine, "quarantine")},
+		{"unknown policy", WithPartialUploads(PartialUploadPolicy(42), "")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewServer(":0", WithDriver(driver), tt.opt); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net"
+)
+
+// PassiveAddressFunc returns the IPv4 address advertised in the PASV reply
+// of a session. It is called for every PASV command. Returning a nil address
+// and no error falls back to the default: the PublicHost setting of the
+// driver, or the address the control connection arrived on. Returning an
+// error fails the command with 425.
+//
+// The session's LocalIP tells which interface the control connection
+// arrived on, and its RemoteIP where the client is. The Transfer field is
+// not set.
+type PassiveAddressFunc func(session SessionInfo) (net.IP, error)
+
+// LocalAddressForPrivateClients is a PassiveAddressFunc that advertises the
+// address the control connection arrived on to clients with a private
+// (RFC 1918 or RFC 4193) or loopback address, and falls back to the default
+// for the others and for IPv6 control connections. Use it with PublicHost
+// set to the NAT address, so that clients on the internal network do not
+// have to hairpin through the NAT:
+//
+//	s, _ := server.NewServer(":21",
+//	    server.WithDriver(driver), // Settings.PublicHost: "203.0.113.10"
+//	    server.WithPassiveAddress(server.LocalAddressForPrivateClients),
+//	)
+func LocalAddressForPrivateClients(session SessionInfo) (net.IP, error) {
+	remote := net.ParseIP(session.RemoteIP)
+	if remote == nil || !(remote.IsPrivate() || remote.IsLoopback()) {
+		return nil, nil
+	}
+	local := net.ParseIP(session.LocalIP)
+	if local.To4() == nil {
+		return nil, nil // IPv6 control connection, PASV needs IPv4
+	}
+	return local, nil
+}
+
+// passiveIP returns the address to advertise in a PASV reply: the one of the
+// server's PassiveAddressFunc, else the driver's PublicHost, else the local
+// address of the control connection (loopback for Unix domain sockets). It
+// returns nil when no IPv4 address is found.
+func (s *session) passiveIP() (net.IP, error) {
+	if fn := s.server.passiveAddress; fn != nil {
+		ip, err := fn(s.info())
+		if err != nil || ip != nil {
+			return ip, err
+		}
+	}
+
+	host := s.localIP
+	settings := s.fs.GetSettings()
+	if settings != nil && settings.PublicHost != "" {
+		host = settings.PublicHost
+	} else if s.conn.LocalAddr().Network() == "unix" {
+		// Clients of Unix domain sockets are on this host
+		return net.IPv4(127, 0, 0, 1), nil
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		// Use cached resolution if available
+		if host == s.lastPublicHost && s.resolvedIP != nil {
+			return s.resolvedIP, nil
+		}
+		// Try resolving hostname
+		addrs, err := net.LookupIP(host)
+		if err == nil {
+			for _, resolvedIP := range addrs {
+				if ipv4 := resolvedIP.To4(); ipv4 != nil {
+					s.lastPublicHost = host
+					s.resolvedIP = ipv4
+					return ipv4, nil
+				}
+			}
+		}
+	}
+	return ip, nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestPassiveAddress(t *testing.T) {
+	t.Parallel()
+
+	rootDir := t.TempDir()
+	newDriver := func() Driver {
+		driver, err := NewFSDriver(rootDir,
+			WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+				return rootDir, false, nil
+			}),
+			WithSettings(&Settings{PublicHost: "203.0.113.10"}),
+		)
+		fatalIfErr(t, err, "Failed to create FS driver")
+		return driver
+	}
+
+	tests := []struct {
+		name string
+		fn   PassiveAddressFunc
+		want string // Address in the PASV reply, or "" for a 425 reply
+	}{
+		{"PublicHost", nil, "203.0.113.10"},
+		{"Callback", func(sess SessionInfo) (net.IP, error) {
+			if sess.LocalIP != "127.0.0.1" || sess.RemoteIP != "127.0.0.1" || sess.User != "alice" {
+				return nil, fmt.Errorf("unexpected session %+v", sess)
+			}
+			return net.ParseIP("198.51.100.20"), nil
+		}, "198.51.100.20"},
+		{"Fallback", func(SessionInfo) (net.IP, error) { return nil, nil }, "203.0.113.10"},
+		{"Error", func(SessionInfo) (net.IP, error) { return nil, errors.New("no route") }, ""},
+		// Loopback clients get the address they connected to
+		{"PrivateClient", LocalAddressForPrivateClients, "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			fatalIfErr(t, err, "Failed to listen")
+			startServer(t, ln, WithDriver(newDriver()), WithPassiveAddress(tt.fn))
+
+			conn, err := rawLogin(ln.Addr().String(), "alice", "pass")
+			fatalIfErr(t, err, "rawLogin failed")
+			defer conn.Close()
+
+			addr, err := rawEnterPasv(conn)
+			if tt.want == "" {
+				if err == nil || !strings.Contains(err.Error(), "425") {
+					t.Errorf("PASV returned %s (%v), want 425", addr, err)
+				}
+				return
+			}
+			fatalIfErr(t, err, "PASV failed")
+			if host, _, _ := net.SplitHostPort(addr); host != tt.want {
+				t.Errorf("PASV address %s, want %s", host, tt.want)
+			}
+		})
+	}
+
+	public := SessionInfo{RemoteIP: "192.0.2.7", LocalIP: "10.0.0.5"}
+	if ip, err := LocalAddressForPrivateClients(public); ip != nil || err != nil {
+		t.Errorf("Public client got %v, %v; want the default", ip, err)
+	}
+	private := SessionInfo{RemoteIP: "10.1.2.3", LocalIP: "10.0.0.5"}
+	if ip, _ := LocalAddressForPrivateClients(private); !ip.Equal(net.ParseIP("10.0.0.5")) {
+		t.Errorf("Private client got %v, want 10.0.0.5", ip)
+	}
+}
@@ -0,0 +1,288 @@
+// Package quiclistener serves FTP over QUIC: its Listener accepts QUIC
+// connections and returns their control streams, for server.Serve.
+//
+// It is a separate module, so that the ftp module does not depend on
+// quic-go.
+//
+// Example:
+//
+//	ln, err := quiclistener.Listen(":4242", tlsConfig, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	s, _ := server.NewServer(":4242",
+//	    server.WithDriver(driver),
+//	    server.WithDisableCommands(server.ActiveModeCommands...),
+//	)
+//	log.Fatal(s.Serve(ln))
+//
+// Control streams create the passive listeners of their session (see
+// server.ListenerFactory), whose connections are data streams of the same
+// QUIC connection, so WithListenerFactory is not needed. Active mode is not
+// available over QUIC; disabling PORT and EPRT tells clients so. See the
+// ftpquic package for how the session maps to QUIC streams.
+package quiclistener
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gonzalop/ftp/ftpquic"
+	"github.com/gonzalop/ftp/server"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// headerTimeout bounds the wait for the header of a data stream.
+	headerTimeout = 10 * time.Second
+
+	// closeTimeout bounds the wait for the client to close the QUIC
+	// connection after the server closed the control stream, so that the
+	// last replies are delivered.
+	closeTimeout = 5 * time.Second
+)
+
+// Listener accepts FTP over QUIC sessions. It is a net.Listener whose
+// connections are control streams.
+type Listener struct {
+	ln *quic.EarlyListener
+}
+
+var _ net.Listener = (*Listener)(nil)
+
+// Listen listens for QUIC connections on the UDP address addr.
+// tlsConfig must hold the server's certificates; NextProtos defaults to
+// ftpquic.NextProto. config may be nil for the defaults.
+//
+// 0-RTT is accepted, so that clients resuming a TLS session get the
+// greeting without waiting for the handshake. Commands sent as 0-RTT data
+// are not read before the handshake completes, so they cannot be replayed.
+func Listen(addr string, tlsConfig *tls.Config, config *quic.Config) (*Listener, error) {
+	tlsConfig = tlsConfig.Clone()
+	if len(tlsConfig.NextProtos) == 0 {
+		tlsConfig.NextProtos = []string{ftpquic.NextProto}
+	}
+	if config == nil {
+		config = &quic.Config{}
+	} else {
+		config = config.Clone()
+	}
+	config.Allow0RTT = true
+	ln, err := quic.ListenAddrEarly(addr, tlsConfig, config)
+	if err != nil {
+		return nil, err
+	}
+	return New(ln), nil
+}
+
+// New returns a Listener accepting connections from ln.
+func New(ln *quic.EarlyListener) *Listener {
+	return &Listener{ln: ln}
+}
+
+// Accept waits for a QUIC connection and returns its control stream, which
+// the Listener opens.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.ln.Accept(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		stream, err := conn.OpenStream()
+		if err != nil {
+			_ = conn.CloseWithError(0, "")
+			continue
+		}
+		c := &controlConn{
+			Conn:      ftpquic.NewConn(stream, conn),
+			listeners: make(map[int]*dataListener),
+		}
+		go c.acceptData()
+		return c, nil
+	}
+}
+
+// Close stops listening. Accepted sessions are not closed.
+func (l *Listener) Close() error {
+	return l.ln.Close()
+}
+
+// Addr returns the UDP address of the listener.
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// controlConn is the control stream of a session. It is the
+// server.ListenerFactory of the session's passive listeners.
+type controlConn struct {
+	*ftpquic.Conn
+
+	mu        sync.Mutex
+	listeners map[int]*dataListener // By port
+	lastPort  int
+	closeOnce sync.Once
+}
+
+var _ server.ListenerFactory = (*controlConn)(nil)
+
+// Read reads commands once the handshake has completed, so that commands
+// in 0-RTT data, which can be replayed, are only handled for clients that
+// complete it.
+func (c *controlConn) Read(b []byte) (int, error) {
+	conn := c.QUICConn()
+	select {
+	case <-conn.HandshakeComplete():
+	case <-conn.Context().Done():
+	}
+	return c.Conn.Read(b)
+}
+
+// Close closes the control stream, and the QUIC connection once the client
+// has closed it or closeTimeout has elapsed.
+func (c *controlConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		conn := c.QUICConn()
+		go func() {
+			timer := time.NewTimer(closeTimeout)
+			defer timer.Stop()
+			select {
+			case <-conn.Context().Done():
+			case <-timer.C:
+			}
+			_ = conn.CloseWithError(0, "")
+		}()
+	})
+	return err
+}
+
+// Listen returns a passive listener for data streams. address holds the
+// port asked for, or 0 for any free port; ports only identify listeners,
+// and are not UDP ports.
+func (c *controlConn) Listen(network, address string) (net.Listener, error) {
+	_, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xffff {
+		return nil, fmt.Errorf("invalid port: %s", portStr)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if port == 0 {
+		// Next free port after the last one, for a new port per listener
+		for i := 0; i < 0xffff; i++ {
+			c.lastPort = c.lastPort%0xffff + 1
+			if c.listeners[c.lastPort] == nil {
+				port = c.lastPort
+				break
+			}
+		}
+		if port == 0 {
+			return nil, fmt.Errorf("no free data ports")
+		}
+	} else if c.listeners[port] != nil {
+		return nil, fmt.Errorf("port %d in use", port)
+	}
+
+	addr := &net.UDPAddr{Port: port}
+	if local, ok := c.LocalAddr().(*net.UDPAddr); ok {
+		addr.IP = local.IP
+	}
+	l := &dataListener{
+		owner: c,
+		port:  port,
+		addr:  addr,
+		conns: make(chan net.Conn, 1),
+		done:  make(chan struct{}),
+	}
+	c.listeners[port] = l
+	return l, nil
+}
+
+// acceptData accepts the data streams of the session, handing each to the
+// passive listener on the port in its header.
+func (c *controlConn) acceptData() {
+	conn := c.QUICConn()
+	for {
+		stream, err := conn.AcceptStream(conn.Context())
+		if err != nil {
+			return
+		}
+		go c.route(stream)
+	}
+}
+
+// route reads the header of stream and hands it to its listener, or
+// refuses it if there is none.
+func (c *controlConn) route(stream *quic.Stream) {
+	_ = stream.SetReadDeadline(time.Now().Add(headerTimeout))
+	port, err := ftpquic.ReadDataHeader(stream)
+	_ = stream.SetReadDeadline(time.Time{})
+	if err == nil {
+		c.mu.Lock()
+		l := c.listeners[port]
+		delivered := false
+		if l != nil {
+			select {
+			case l.conns <- ftpquic.NewConn(stream, c.QUICConn()):
+				delivered = true
+			default:
+			}
+		}
+		c.mu.Unlock()
+		if delivered {
+			return
+		}
+	}
+	stream.CancelRead(ftpquic.ErrorCodeNoListener)
+	stream.CancelWrite(ftpquic.ErrorCodeNoListener)
+}
+
+// dataListener is a passive listener accepting data streams.
+type dataListener struct {
+	owner *controlConn
+	port  int
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *dataListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	case <-l.owner.QUICConn().Context().Done():
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *dataListener) Close() error {
+	l.once.Do(func() {
+		l.owner.mu.Lock()
+		delete(l.owner.listeners, l.port)
+		l.owner.mu.Unlock()
+		close(l.done)
+		// A stream that arrived but was not accepted
+		select {
+		case conn := <-l.conns:
+			conn.Close()
+		default:
+		}
+	})
+	return nil
+}
+
+func (l *dataListener) Addr() net.Addr {
+	return l.addr
+}
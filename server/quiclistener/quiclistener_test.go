@@ -0,0 +1,235 @@
+package quiclistener
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftpquic"
+	"github.com/gonzalop/ftp/ftpquic/client"
+	"github.com/gonzalop/ftp/server"
+	"github.com/quic-go/quic-go"
+)
+
+// testTLSConfig returns a TLS configuration with a self-signed certificate.
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// startServer starts an FTP over QUIC server, returning its address.
+func startServer(t *testing.T, opts ...server.Option) string {
+	t.Helper()
+	ln, err := Listen("127.0.0.1:0", testTLSConfig(t), nil)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	driver, err := server.NewFSDriver(t.TempDir(), server.WithAnonWrite(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]server.Option{
+		server.WithDriver(driver),
+		server.WithDisableCommands(server.ActiveModeCommands...),
+		server.WithLogger(slog.New(slog.DiscardHandler)),
+	}, opts...)
+	s, err := server.NewServer(ln.Addr().String(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = s.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+	return ln.Addr().String()
+}
+
+func TestTransfers(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		reuse bool
+	}{
+		{"stream mode", false},
+		{"data connection reuse", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var serverOpts []server.Option
+			clientOpts := []ftp.Option{ftp.WithTimeout(5 * time.Second)}
+			if tt.reuse {
+				serverOpts = append(serverOpts, server.WithDataConnectionReuse(true))
+				clientOpts = append(clientOpts, ftp.WithDataConnectionReuse())
+			}
+			addr := startServer(t, serverOpts...)
+			c, err := client.Dial(addr, &tls.Config{InsecureSkipVerify: true}, clientOpts...)
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer c.Quit()
+			if err := c.Login("anonymous", "anonymous"); err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+
+			files := map[string]string{"a.txt": "hello", "empty.txt": "", "big.bin": strings.Repeat("x", 1<<20)}
+			for name, data := range files {
+				if err := c.Store(name, strings.NewReader(data)); err != nil {
+					t.Fatalf("Store(%s): %v", name, err)
+				}
+			}
+			for name, want := range files {
+				var buf bytes.Buffer
+				if err := c.Retrieve(name, &buf); err != nil || buf.String() != want {
+					t.Fatalf("Retrieve(%s) = %d bytes, %v; want %d bytes", name, buf.Len(), err, len(want))
+				}
+			}
+			entries, err := c.List("/")
+			if err != nil || len(entries) != len(files) {
+				t.Fatalf("List = %d entries, %v; want %d", len(entries), err, len(files))
+			}
+
+			// A range download and a segmented one, over more QUIC
+			// connections
+			var buf bytes.Buffer
+			if err := c.RetrieveRange("big.bin", &buf, 10, 19); err != nil || buf.String() != "xxxxxxxxxx" {
+				t.Fatalf("RetrieveRange = %q, %v", buf.String(), err)
+			}
+			out := &writerAt{}
+			if err := c.RetrieveParallel("big.bin", out, 3); err != nil || out.String() != files["big.bin"] {
+				t.Fatalf("RetrieveParallel = %d bytes, %v", out.Len(), err)
+			}
+			if _, err := c.CurrentDir(); err != nil {
+				t.Errorf("CurrentDir: %v", err)
+			}
+		})
+	}
+}
+
+// writerAt is an in-memory io.WriterAt.
+type writerAt struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if end := int(off) + len(p); end > len(w.buf) {
+		w.buf = append(w.buf, make([]byte, end-len(w.buf))...)
+	}
+	copy(w.buf[off:], p)
+	return len(p), nil
+}
+
+func (w *writerAt) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return string(w.buf)
+}
+
+func (w *writerAt) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.buf)
+}
+
+func TestResumption(t *testing.T) {
+	t.Parallel()
+	addr := startServer(t)
+	var resumed atomic.Int32
+	d := &client.Dialer{TLSConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if cs.DidResume {
+				resumed.Add(1)
+			}
+			return nil
+		},
+	}}
+	for i := range 2 {
+		c, err := d.Dial(addr, ftp.WithTimeout(5*time.Second))
+		if err != nil {
+			t.Fatalf("Dial %d: %v", i, err)
+		}
+		if err := c.Login("anonymous", "anonymous"); err != nil {
+			t.Fatalf("Login %d: %v", i, err)
+		}
+		if err := c.Quit(); err != nil {
+			t.Errorf("Quit %d: %v", i, err)
+		}
+	}
+	if resumed.Load() != 1 {
+		t.Errorf("%d resumed connections, want 1", resumed.Load())
+	}
+}
+
+// TestUnknownDataStream checks that a data stream for a port without a
+// passive listener is refused.
+func TestUnknownDataStream(t *testing.T) {
+	t.Parallel()
+	addr := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{ftpquic.NextProto}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseWithError(0, "")
+	control, err := conn.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("No control stream: %v", err)
+	}
+	greeting := make([]byte, 3)
+	if _, err := io.ReadFull(control, greeting); err != nil || string(greeting) != "220" {
+		t.Fatalf("Greeting = %q, %v", greeting, err)
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ftpquic.WriteDataHeader(stream, 1234); err != nil {
+		t.Fatal(err)
+	}
+	_ = stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = stream.Read(make([]byte, 1))
+	var serr *quic.StreamError
+	if !errors.As(err, &serr) || serr.ErrorCode != ftpquic.ErrorCodeNoListener {
+		t.Errorf("Read = %v, want stream error %d", err, ftpquic.ErrorCodeNoListener)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Reply is a server reply, built line by line. A reply with one line is
+// sent as "code text"; longer replies use the multi-line format of RFC 959
+// Section 4.2, where the first line is sent as "code-text" and the last as
+// "code text". Lines added with Line in between are sent as "code-text",
+// and lines added with Indent as " text", the style of FEAT and STAT
+// replies (RFC 2389). Neither can be taken for the last line.
+//
+// Line breaks in the text start new lines, and lone CR characters are sent
+// as CR NUL (RFC 2640), so text from files or users cannot end the reply
+// early or inject one. When the reply is sent, each line is translated to
+// the session's language and encoded with its filename encoding.
+//
+//	r := server.NewReply(211, "Quota:").
+//		Indentf("Used: %d bytes", used).
+//		Indentf("Limit: %d bytes", limit).
+//		Line("End of quota")
+type Reply struct {
+	code  int
+	lines []replyLine
+}
+
+type replyLine struct {
+	text   string
+	indent bool
+}
+
+// NewReply returns a reply with the code and a first line of text.
+func NewReply(code int, text string) *Reply {
+	r := &Reply{code: code}
+	return r.Line(text)
+}
+
+// Code returns the reply code.
+func (r *Reply) Code() int {
+	return r.code
+}
+
+// Line appends text, sent as "code-text" or, as the last line,
+// "code text". Text with several lines, separated by "\n" or "\r\n",
+// appends each of them.
+func (r *Reply) Line(text string) *Reply {
+	return r.add(text, false)
+}
+
+// Linef appends a line formatted as with fmt.Sprintf.
+func (r *Reply) Linef(format string, args ...any) *Reply {
+	return r.add(fmt.Sprintf(format, args...), false)
+}
+
+// Indent appends text, sent as " text" unless it ends up first or last in
+// the reply. Text with several lines appends each of them.
+func (r *Reply) Indent(text string) *Reply {
+	return r.add(text, true)
+}
+
+// Indentf appends an indented line formatted as with fmt.Sprintf.
+func (r *Reply) Indentf(format string, args ...any) *Reply {
+	return r.add(fmt.Sprintf(format, args...), true)
+}
+
+func (r *Reply) add(text string, indent bool) *Reply {
+	text = strings.TrimRight(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for line := range strings.SplitSeq(text, "\n") {
+		line = strings.ReplaceAll(line, "\r", "\r\x00")
+		r.lines = append(r.lines, replyLine{text: line, indent: indent})
+	}
+	return r
+}
+
+// String returns the reply as sent, before translation and encoding, with
+// CRLF line endings.
+func (r *Reply) String() string {
+	var b strings.Builder
+	r.write(&b, func(text string) string { return text })
+	return b.String()
+}
+
+// write writes the reply to w, passing the text of each line through
+// convert.
+func (r *Reply) write(w interface{ WriteString(string) (int, error) }, convert func(string) string) {
+	code := strconv.Itoa(r.code)
+	last := len(r.lines) - 1
+	for i, l := range r.lines {
+		switch {
+		case i == last:
+			_, _ = w.WriteString(code + " ")
+		case l.indent && i > 0:
+			_, _ = w.WriteString(" ")
+		default:
+			_, _ = w.WriteString(code + "-")
+		}
+		_, _ = w.WriteString(convert(l.text))
+		_, _ = w.WriteString("\r\n")
+	}
+}
+
+// send sends r to the client.
+func (s *session) send(r *Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendLocked(r)
+}
+
+// sendLocked sends r to the client. s.mu must be held.
+func (s *session) sendLocked(r *Reply) {
+	r.write(s.writer, func(text string) string {
+		return s.encodeName(s.translate(text))
+	})
+	_ = s.writer.Flush()
+	s.lastReply = r.code
+}
+
+// SiteCommandFunc handles a custom SITE command, registered with
+// WithSiteCommand. args is the text after the command name, with leading
+// spaces removed. The returned reply is sent to the client; nil sends
+// "200 Command okay.".
+type SiteCommandFunc func(info SessionInfo, args string) *Reply
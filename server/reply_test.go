@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReply(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		reply *Reply
+		want  string
+	}{
+		{
+			name:  "single line",
+			reply: NewReply(200, "Command okay."),
+			want:  "200 Command okay.\r\n",
+		},
+		{
+			name:  "empty",
+			reply: NewReply(200, ""),
+			want:  "200 \r\n",
+		},
+		{
+			name:  "lines",
+			reply: NewReply(230, "Welcome.").Linef("You have %d files.", 3).Line("Logged in."),
+			want:  "230-Welcome.\r\n230-You have 3 files.\r\n230 Logged in.\r\n",
+		},
+		{
+			name:  "indented",
+			reply: NewReply(211, "Features:").Indent("SIZE").Indentf("HASH %s", "SHA-256*").Line("End"),
+			want:  "211-Features:\r\n SIZE\r\n HASH SHA-256*\r\n211 End\r\n",
+		},
+		{
+			name:  "indented first and last",
+			reply: NewReply(250, " Listing follows").Indent("type=file; a"),
+			want:  "250- Listing follows\r\n250 type=file; a\r\n",
+		},
+		{
+			name:  "line breaks",
+			reply: NewReply(220, "one\r\ntwo\nthree\n\n"),
+			want:  "220-one\r\n220-two\r\n220 three\r\n",
+		},
+		{
+			name:  "indented line breaks",
+			reply: NewReply(211, "Status:").Indent("a\nb").Line("End"),
+			want:  "211-Status:\r\n a\r\n b\r\n211 End\r\n",
+		},
+		{
+			name:  "lone CR",
+			reply: NewReply(257, "\"a\rb\" created."),
+			want:  "257 \"a\r\x00b\" created.\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.reply.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSiteCommand(t *testing.T) {
+	t.Parallel()
+	args := make(chan string, 1)
+	c, _ := setupWrappedServer(t, nil,
+		WithSiteCommand("whoami", func(info SessionInfo, a string) *Reply {
+			args <- a
+			return NewReply(200, "User: "+info.User).Line("Logged in: yes")
+		}),
+		WithSiteCommand("NOP", func(SessionInfo, string) *Reply { return nil }),
+	)
+
+	resp, err := c.Quote("SITE", "WHOAMI", "  a  b")
+	fatalIfErr(t, err, "SITE WHOAMI failed")
+	if resp.Code != 200 || !slices.Equal(resp.Lines, []string{"200-User: test", "200 Logged in: yes"}) {
+		t.Errorf("SITE WHOAMI = %d %q", resp.Code, resp.Lines)
+	}
+	if got := <-args; got != "a  b" {
+		t.Errorf("args = %q, want %q", got, "a  b")
+	}
+
+	resp, err = c.Quote("SITE", "nop")
+	fatalIfErr(t, err, "SITE NOP failed")
+	if resp.Code != 200 {
+		t.Errorf("SITE NOP = %d %q, want 200", resp.Code, resp.Message)
+	}
+
+	resp, err = c.Quote("SITE", "HELP")
+	fatalIfErr(t, err, "SITE HELP failed")
+	if !strings.Contains(resp.Message, "NOP, WHOAMI") {
+		t.Errorf("SITE HELP does not list the custom commands: %s", resp.Message)
+	}
+
+	resp, err = c.Quote("SITE", "OTHER")
+	fatalIfErr(t, err, "SITE OTHER failed")
+	if resp.Code != 502 {
+		t.Errorf("SITE OTHER = %d %q, want 502", resp.Code, resp.Message)
+	}
+}
+
+func TestSiteCommand_NotLoggedIn(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	driver, err := NewFSDriver(t.TempDir())
+	fatalIfErr(t, err, "Failed to create FS driver")
+	startServer(t, ln, WithDriver(driver),
+		WithSiteCommand("WHOAMI", func(SessionInfo, string) *Reply {
+			t.Error("SITE WHOAMI ran before login")
+			return nil
+		}),
+	)
+
+	conn, err := net.DialTimeout("tcp", ln.Addr().String(), 5*time.Second)
+	fatalIfErr(t, err, "Dial failed")
+	defer conn.Close()
+	tc := &textConn{Conn: conn, Reader: bufio.NewReader(conn)}
+	_, _, err = rawReadResponse(tc)
+	fatalIfErr(t, err, "Failed to read greeting")
+	fmt.Fprintf(tc, "SITE WHOAMI\r\n")
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 530 {
+		t.Errorf("SITE WHOAMI = %d %q %v, want 530", code, msg, err)
+	}
+}
+
+func TestWithSiteCommand_Invalid(t *testing.T) {
+	t.Parallel()
+	driver, _ := NewFSDriver(t.TempDir())
+	fn := func(SessionInfo, string) *Reply { return nil }
+	tests := []struct {
+		name string
+		cmd  string
+		fn   SiteCommandFunc
+	}{
+		{"empty name", "", fn},
+		{"name with space", "A B", fn},
+		{"built-in", "chmod", fn},
+		{"nil handler", "QUOTA", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewServer(":0", WithDriver(driver), WithSiteCommand(tt.cmd, tt.fn)); err == nil {
+				t.Errorf("WithSiteCommand(%q) succeeded, want error", tt.cmd)
+			}
+		})
+	}
+}
+
+func TestPathnameReplyCR(t *testing.T) {
+	t.Parallel()
+	driver, _ := newTestFSDriver(t)
+	tc, err := rawLogin(serveDriver(t, driver), "test", "test")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	// The CR of the name is padded with a single NUL (RFC 2640 Section 3.1)
+	for _, tt := range []struct{ cmd, want string }{
+		{"MKD cr\r\x00dir", "257 \"/cr\r\x00dir\" created.\r\n"},
+		{"CWD cr\r\x00dir", "250 Directory successfully changed.\r\n"},
+		{"PWD", "257 \"/cr\r\x00dir\" is the current directory.\r\n"},
+	} {
+		fmt.Fprintf(tc, "%s\r\n", tt.cmd)
+		_, line, err := rawReadResponse(tc)
+		fatalIfErr(t, err, "Failed to read reply to "+tt.cmd)
+		if line != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.cmd, line, tt.want)
+		}
+	}
+}
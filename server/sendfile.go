@@ -0,0 +1,82 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+)
+
+// sendfileChunk is the amount of data sent per zero-copy call, so the
+// progress of large transfers is still updated as they go. It is small
+// enough for slow links to complete a chunk well within the stall and idle
+// timeouts, which only see the progress between chunks.
+const sendfileChunk = 128 << 10
+
+// copyToData copies a file being downloaded to the data connection.
+// Plaintext binary transfers of files without bandwidth limits use the
+// kernel's zero-copy path (sendfile(2) on Linux) through
+// (*net.TCPConn).ReadFrom. Other transfers (TLS, ASCII, rate limited, or
+// drivers not returning an *os.File) are copied through a pooled buffer.
+func (s *session) copyToData(conn net.Conn, src io.Reader, progress *transferProgress) (int64, error) {
+	if tcpConn, file, limit, ok := s.zeroCopyPath(conn, src); ok {
+		return sendFile(tcpConn, file, limit, progress)
+	}
+
+	// Apply bandwidth limiting to the connection (we're writing to it)
+	dst := progress.writer(s.rateLimitWriter(conn))
+	return s.server.copyWithPooledBuffer(dst, src)
+}
+
+// zeroCopyPath returns the TCP connection and file to send with sendFile,
+// and the number of bytes to send (-1 = until EOF), if the transfer can
+// use it.
+func (s *session) zeroCopyPath(conn net.Conn, src io.Reader) (*net.TCPConn, *os.File, int64, bool) {
+	if !s.server.zeroCopy || s.transferType == "A" ||
+		s.bandwidthLimited() || s.server.globalLimiter != nil {
+		return nil, nil, 0, false
+	}
+
+	if tc, ok := conn.(*trackingConn); ok {
+		conn = tc.Conn
+	}
+	tcpConn, ok := conn.(*net.TCPConn) // Not a *tls.Conn (PROT P)
+	if !ok {
+		return nil, nil, 0, false
+	}
+
+	switch r := src.(type) {
+	case *os.File:
+		return tcpConn, r, -1, true
+	case *io.LimitedReader:
+		if file, ok := r.R.(*os.File); ok {
+			return tcpConn, file, r.N, true
+		}
+	}
+	return nil, nil, 0, false
+}
+
+// sendFile sends limit bytes of file (-1 = until EOF) to conn in chunks,
+// recording the progress after each one.
+func sendFile(conn *net.TCPConn, file *os.File, limit int64, progress *transferProgress) (int64, error) {
+	var total int64
+	for limit != 0 {
+		chunk := int64(sendfileChunk)
+		if limit > 0 && limit < chunk {
+			chunk = limit
+		}
+
+		n, err := conn.ReadFrom(&io.LimitedReader{R: file, N: chunk})
+		total += n
+		progress.add(n)
+		if err != nil {
+			return total, err
+		}
+		if n < chunk {
+			break // End of file
+		}
+		if limit > 0 {
+			limit -= n
+		}
+	}
+	return total, nil
+}
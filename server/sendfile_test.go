@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestZeroCopyPath(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	fatalIfErr(t, err, "Failed to dial")
+	defer conn.Close()
+
+	file, err := os.CreateTemp(t.TempDir(), "data")
+	fatalIfErr(t, err, "CreateTemp failed")
+	defer file.Close()
+
+	tracked := &trackingConn{Conn: conn}
+
+	tests := []struct {
+		name  string
+		setup func(*session)
+		conn  net.Conn
+		src   io.Reader
+		limit int64
+		ok    bool
+	}{
+		{"file", nil, tracked, file, -1, true},
+		{"range", nil, tracked, io.LimitReader(file, 10), 10, true},
+		{"not a file", nil, tracked, strings.NewReader("data"), 0, false},
+		{"not TCP", nil, &net.UnixConn{}, file, 0, false},
+		{"ASCII", func(sess *session) { sess.transferType = "A" }, tracked, file, 0, false},
+		{"rate limited", func(sess *session) { sess.server.bandwidthLimitPerUser = 1024 }, tracked, file, 0, false},
+		{"disabled", func(sess *session) { sess.server.zeroCopy = false }, tracked, file, 0, false},
+	}
+	for _, tt := range tests {
+		sess := &session{server: &Server{zeroCopy: true}, transferType: "I"}
+		if tt.setup != nil {
+			tt.setup(sess)
+		}
+		_, _, limit, ok := sess.zeroCopyPath(tt.conn, tt.src)
+		if ok != tt.ok || limit != tt.limit {
+			t.Errorf("%s: zeroCopyPath = %d, %v; want %d, %v", tt.name, limit, ok, tt.limit, tt.ok)
+		}
+	}
+}
+
+func TestRETRZeroCopy(t *testing.T) {
+	t.Parallel()
+
+	// Larger than one sendfile chunk
+	data := make([]byte, sendfileChunk*2+12345)
+	_, _ = rand.Read(data)
+
+	for _, zeroCopy := range []bool{true, false} {
+		c, rootDir := setupWrappedServer(t, nil, WithZeroCopy(zeroCopy))
+		fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "big.bin"), data, 0644), "WriteFile failed")
+
+		var buf bytes.Buffer
+		fatalIfErr(t, c.Retrieve("big.bin", &buf), "Retrieve failed")
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("zeroCopy=%v: content mismatch (%d bytes)", zeroCopy, buf.Len())
+		}
+
+		buf.Reset()
+		start, end := int64(sendfileChunk-10), int64(sendfileChunk+10)
+		fatalIfErr(t, c.RetrieveRange("big.bin", &buf, start, end), "RetrieveRange failed")
+		if !bytes.Equal(buf.Bytes(), data[start:end+1]) {
+			t.Errorf("zeroCopy=%v: range mismatch (%d bytes)", zeroCopy, buf.Len())
+		}
+	}
+}
+
+func TestRETRZeroCopy_SlowClient(t *testing.T) {
+	t.Parallel()
+
+	driver, rootDir := newTestFSDriver(t)
+	data := make([]byte, 2<<20)
+	_, _ = rand.Read(data)
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "big.bin"), data, 0644), "WriteFile failed")
+	// Small socket buffers, so the transfer goes at the pace of the reader
+	addr := serveDriver(t, driver,
+		WithTransferStallTimeout(500*time.Millisecond),
+		WithDataSocketOptions(SocketOptions{WriteBuffer: 16 << 10}),
+	)
+
+	tc, err := rawLogin(addr, "test", "test")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+	fmt.Fprintf(tc, "TYPE I\r\n")
+	_, _, err = rawReadResponse(tc)
+	fatalIfErr(t, err, "TYPE failed")
+	dataAddr, err := rawEnterPasv(tc)
+	fatalIfErr(t, err, "PASV failed")
+	dataConn, err := net.DialTimeout("tcp", dataAddr, 5*time.Second)
+	fatalIfErr(t, err, "Failed to dial data port")
+	defer dataConn.Close()
+	fatalIfErr(t, dataConn.(*net.TCPConn).SetReadBuffer(16<<10), "SetReadBuffer failed")
+
+	fmt.Fprintf(tc, "RETR big.bin\r\n")
+	code, msg, err := rawReadResponse(tc)
+	fatalIfErr(t, err, "Failed to read reply to RETR")
+	if !isPreliminary(code) {
+		t.Fatalf("RETR: got %q, want a preliminary reply", msg)
+	}
+
+	// About 1.5 MB/s: slow but steady, longer than the stall timeout in all
+	var got bytes.Buffer
+	buf := make([]byte, 16<<10)
+	for {
+		n, err := dataConn.Read(buf)
+		got.Write(buf[:n])
+		if err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	code, msg, err = rawReadResponse(tc)
+	fatalIfErr(t, err, "Failed to read transfer reply")
+	if code != 226 || !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("Slow RETR: got %q and %d bytes, want 226 and %d bytes", strings.TrimSpace(msg), got.Len(), len(data))
+	}
+}
+
+func BenchmarkRETR(b *testing.B) {
+	data := make([]byte, 64<<20)
+	_, _ = rand.Read(data)
+
+	for _, bench := range []struct {
+		name     string
+		zeroCopy bool
+	}{
+		{"zero-copy", true},
+		{"buffered", false},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			c, rootDir := setupWrappedServer(b, nil, WithZeroCopy(bench.zeroCopy))
+			if err := os.WriteFile(filepath.Join(rootDir, "big.bin"), data, 0644); err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for b.Loop() {
+				if err := c.Retrieve("big.bin", io.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSTOR compares the durability and performance settings of
+// uploads: buffering saves system calls, UploadSync waits for the disk and
+// UploadDirectIO bypasses the page cache.
+func BenchmarkSTOR(b *testing.B) {
+	data := make([]byte, 64<<20)
+	_, _ = rand.Read(data)
+
+	for _, bench := range []struct {
+		name     string
+		settings Settings
+	}{
+		{"default", Settings{}},
+		{"buffered", Settings{UploadBufferSize: 1 << 20}},
+		{"sync", Settings{UploadSync: true}},
+		{"buffered-sync", Settings{UploadBufferSize: 1 << 20, UploadSync: true}},
+		{"direct", Settings{UploadDirectIO: true}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			rootDir := b.TempDir()
+			driver, err := NewFSDriver(rootDir,
+				WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+					return rootDir, false, nil
+				}),
+				WithSettings(&bench.settings),
+			)
+			if err != nil {
+				b.Fatal(err)
+			}
+			c := dialDriver(b, driver)
+
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				if err := c.Store("big.bin", bytes.NewReader(data)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seqStep is a command of a sequencing test and the reply it should get.
+// PASV and PORT steps also set up the data connection, which transfers
+// replying 150 use: STOR sends data, and RETR and LIST read the data sent,
+// which must match data if set. The passive connection is opened before the
+// transfer, so 125 also matches 150.
+type seqStep struct {
+	cmd  string
+	code int
+	data string
+}
+
+// Stateful command pairs must come in order (RNFR then RNTO, REST then a
+// transfer, PASV or PORT then a transfer), and other commands in between
+// end the sequence.
+func TestCommandSequences(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		noLogin bool
+		steps   []seqStep
+	}{
+		{name: "PASS without USER", noLogin: true, steps: []seqStep{
+			{"PASS secret", 503, ""}, {"USER alice", 331, ""}, {"PASS secret", 230, ""},
+		}},
+		{name: "transfer before login", noLogin: true, steps: []seqStep{
+			{"LIST", 530, ""}, {"PASV", 530, ""},
+		}},
+		{name: "rename", steps: []seqStep{
+			{"RNFR a.txt", 350, ""}, {"RNTO b.txt", 250, ""}, {"SIZE b.txt", 213, ""},
+		}},
+		{name: "RNTO without RNFR", steps: []seqStep{
+			{"RNTO b.txt", 503, ""},
+		}},
+		{name: "RNTO twice", steps: []seqStep{
+			{"RNFR a.txt", 350, ""}, {"RNTO b.txt", 250, ""}, {"RNTO c.txt", 503, ""},
+		}},
+		{name: "rename interrupted", steps: []seqStep{
+			{"RNFR a.txt", 350, ""}, {"NOOP", 200, ""}, {"RNTO b.txt", 503, ""}, {"SIZE a.txt", 213, ""},
+		}},
+		{name: "RNFR of a missing file", steps: []seqStep{
+			{"RNFR a.txt", 350, ""}, {"RNFR nope.txt", 550, ""}, {"RNTO b.txt", 503, ""},
+		}},
+		{name: "failed RNTO", steps: []seqStep{
+			{"RNFR a.txt", 350, ""}, {"RNTO nope/b.txt", 550, ""}, {"RNTO b.txt", 503, ""},
+		}},
+		{name: "REST then RETR", steps: []seqStep{
+			{"REST 4", 350, ""}, {"TYPE I", 200, ""}, {"PASV", 227, ""}, {"RETR a.txt", 150, "456789"},
+			{"PASV", 227, ""}, {"RETR a.txt", 150, "0123456789"},
+		}},
+		{name: "REST interrupted", steps: []seqStep{
+			{"REST 4", 350, ""}, {"SIZE a.txt", 213, ""}, {"PASV", 227, ""}, {"RETR a.txt", 150, "0123456789"},
+		}},
+		{name: "REST used by a failed transfer", steps: []seqStep{
+			{"REST 4", 350, ""}, {"PASV", 227, ""}, {"RETR nope.txt", 550, ""},
+			{"PASV", 227, ""}, {"RETR a.txt", 150, "0123456789"},
+		}},
+		// The file is not truncated by the rejected STOR
+		{name: "transfer without PASV", steps: []seqStep{
+			{"STOR a.txt", 425, ""}, {"REST 4", 350, ""}, {"RETR a.txt", 425, ""},
+			{"PASV", 227, ""}, {"RETR a.txt", 150, "0123456789"},
+		}},
+		{name: "PASV used up", steps: []seqStep{
+			{"PASV", 227, ""}, {"LIST", 150, ""}, {"LIST", 425, ""},
+		}},
+		{name: "PORT replaces PASV", steps: []seqStep{
+			{"PASV", 227, ""}, {"PORT", 200, ""}, {"STOR c.txt", 150, "active"}, {"LIST", 425, ""},
+		}},
+		{name: "PASV replaces PORT", steps: []seqStep{
+			{"PORT", 200, ""}, {"PASV", 227, ""}, {"RETR a.txt", 150, "0123456789"}, {"RETR a.txt", 425, ""},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			rootDir := t.TempDir()
+			fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "a.txt"), []byte("0123456789"), 0644), "WriteFile failed")
+			driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+				return rootDir, false, nil
+			}))
+			fatalIfErr(t, err, "Failed to create FS driver")
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			fatalIfErr(t, err, "Failed to listen")
+			startServer(t, ln, WithDriver(driver))
+
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			fatalIfErr(t, err, "Failed to dial")
+			ctrl := textproto.NewConn(conn)
+			defer ctrl.Close()
+			_, _, err = ctrl.ReadResponse(220)
+			fatalIfErr(t, err, "No greeting")
+
+			steps := tc.steps
+			if !tc.noLogin {
+				steps = append([]seqStep{{"USER alice", 331, ""}, {"PASS secret", 230, ""}}, steps...)
+			}
+			runSequence(t, ctrl, steps)
+		})
+	}
+}
+
+// runSequence sends the commands of steps, checking their replies.
+func runSequence(t *testing.T, c *textproto.Conn, steps []seqStep) {
+	t.Helper()
+	var pasv net.Conn       // Passive data connection
+	var active net.Listener // Listener for active data connections
+	closeData := func() {
+		if pasv != nil {
+			pasv.Close()
+			pasv = nil
+		}
+		if active != nil {
+			active.Close()
+			active = nil
+		}
+	}
+	defer closeData()
+
+	for i, step := range steps {
+		cmd := step.cmd
+		if cmd == "PORT" {
+			closeData()
+			var err error
+			active, err = net.Listen("tcp", "127.0.0.1:0")
+			fatalIfErr(t, err, "Failed to listen")
+			port := active.Addr().(*net.TCPAddr).Port
+			cmd = fmt.Sprintf("PORT 127,0,0,1,%d,%d", port/256, port%256)
+		}
+		fatalIfErr(t, c.PrintfLine("%s", cmd), "Failed to send command")
+		code, msg, err := c.ReadResponse(0)
+		if code != step.code && !(step.code == 150 && isPreliminary(code)) {
+			t.Fatalf("Step %d: %q got %d %q (%v), want %d", i, cmd, code, msg, err, step.code)
+		}
+
+		switch {
+		case cmd == "PASV" && code == 227:
+			closeData()
+			var h1, h2, h3, h4, p1, p2 int
+			_, err := fmt.Sscanf(msg[strings.Index(msg, "("):], "(%d,%d,%d,%d,%d,%d)", &h1, &h2, &h3, &h4, &p1, &p2)
+			fatalIfErr(t, err, "Failed to parse PASV reply")
+			pasv, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", p1*256+p2))
+			fatalIfErr(t, err, "Failed to open passive data connection")
+		case isPreliminary(code):
+			data := pasv
+			pasv = nil
+			if data == nil {
+				_ = active.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+				data, err = active.Accept()
+				fatalIfErr(t, err, "No active data connection")
+			}
+			_ = data.SetDeadline(time.Now().Add(5 * time.Second))
+			if strings.HasPrefix(cmd, "STOR") {
+				_, err = io.WriteString(data, step.data)
+				fatalIfErr(t, err, "Failed to send data")
+				data.Close()
+			} else {
+				got, err := io.ReadAll(data)
+				fatalIfErr(t, err, "Failed to read data")
+				data.Close()
+				if step.data != "" && string(got) != step.data {
+					t.Errorf("Step %d: %q sent %q, want %q", i, cmd, got, step.data)
+				}
+			}
+			if _, msg, err := c.ReadResponse(226); err != nil {
+				t.Fatalf("Step %d: %q did not complete: %q %v", i, cmd, msg, err)
+			}
+		}
+	}
+}
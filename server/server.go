@@ -60,6 +60,27 @@ type Server struct {
 	// If nil, TLS is disabled.
 	tlsConfig *tls.Config
 
+	// certReloader serves the default certificate from files (optional)
+	certReloader *certReloader
+
+	// hostCerts serves certificates by SNI or HOST name (optional)
+	hostCerts map[string]*certReloader
+
+	// virtualHosts are the sites selected by HOST or SNI (optional)
+	virtualHosts map[string]VirtualHost
+
+	// staticCert is the default certificate from tlsConfig when certificates
+	// are selected by name and not reloaded
+	staticCert *tls.Certificate
+
+	// tlsMinVersion and tlsCipherSuites override those of tlsConfig
+	// (optional)
+	tlsMinVersion   uint16
+	tlsCipherSuites []uint16
+
+	// requireTLS refuses logins on clear-text control connections
+	requireTLS bool
+
 	// disableMLSD disables the MLSD command (for compatibility testing).
 	disableMLSD bool
 
@@ -67,6 +88,11 @@ type Server struct {
 	// Defaults to "220 FTP Server Ready".
 	welcomeMessage string
 
+	// bannerFunc and loginMessageFunc return dynamic 220 and 230 messages
+	// (see WithBannerFunc and WithLoginMessageFunc)
+	bannerFunc       BannerFunc
+	loginMessageFunc LoginMessageFunc
+
 	// serverName is the system type returned by the SYST command.
 	// Defaults to "UNIX Type: L8".
 	serverName string
@@ -83,6 +109,14 @@ type Server struct {
 	// If 0, no timeout is applied.
 	writeTimeout time.Duration
 
+	// transferStallTimeout aborts transfers that move no data for this long.
+	// If 0, stalled transfers are not aborted.
+	transferStallTimeout time.Duration
+
+	// maxSessionDuration is the maximum time a session can stay connected.
+	// If 0, there is no limit.
+	maxSessionDuration time.Duration
+
 	// maxConnections is the maximum number of simultaneous connections.
 	// If 0, there is no limit.
 	maxConnections int
@@ -91,6 +125,18 @@ type Server struct {
 	// If 0, there is no per-IP limit.
 	maxConnectionsPerIP int
 
+	// maxCommandRate is the maximum number of commands per second a session
+	// can send. If 0, there is no limit.
+	maxCommandRate int
+
+	// maxPathLength is the maximum length of a path argument.
+	// If 0, there is no limit.
+	maxPathLength int
+
+	// maxSessionsPerListener is the maximum number of sessions served at once
+	// from each listener. If 0, there is no limit.
+	maxSessionsPerListener int
+
 	// activeConns tracks the number of currently active connections.
 	activeConns atomic.Int32
 
@@ -105,43 +151,93 @@ type Server struct {
 	pathRedactor PathRedactor // Custom path redaction function (optional)
 	redactIPs    bool         // Redact last octet of IP addresses in logs
 
+	// auditSink receives security events (see WithAuditSink).
+	auditSink AuditSink
+
 	// Features
-	enableDirMessage bool // Enable directory messages (.message files)
+	enableDirMessage bool                       // Enable directory messages (.message files)
+	allowFXP         bool                       // Allow PORT/EPRT to target hosts other than the client
+	mixedFamilies    bool                       // Allow PORT/EPRT addresses of another family than the client's
+	blockPrivileged  bool                       // Reject PORT/EPRT targets below port 1024
+	activeNetworks   []*net.IPNet               // Networks PORT/EPRT may target besides the client
+	siteSymlink      bool                       // Allow SITE SYMLINK on drivers implementing Symlinker
+	siteCommands     map[string]SiteCommandFunc // Custom SITE commands, by upper-case name
+	recursiveMKD     bool                       // Create missing parents of MKD paths
+	zeroCopy         bool                       // Use sendfile for plaintext downloads (default true)
+
+	// filenameEncoding transcodes file names for clients not using UTF-8 (optional)
+	filenameEncoding FilenameEncoding
+
+	// uploadPolicy restricts uploaded file names and sizes (optional)
+	uploadPolicy *UploadPolicy
+
+	// uniqueName generates the names of STOU uploads (optional)
+	uniqueName UniqueNameFunc
+
+	// streamHooks wrap the data of transfers (optional)
+	streamHooks []StreamHook
+
+	// atomicUploads makes STOR write to a temporary name and rename on success
+	atomicUploads bool
+
+	// partialUploads selects what happens to the files of incomplete uploads
+	partialUploads PartialUploadPolicy
+	quarantineDir  string // destination for PartialUploadQuarantine
+
+	// statCacheTTL is how long SIZE/MDTM/MLST metadata is cached per session (0 = disabled)
+	statCacheTTL time.Duration
 
 	// Metrics collection (optional)
 	metricsCollector MetricsCollector
 
 	// Shutdown handling
 	mu         sync.Mutex
-	listener   net.Listener
+	listeners  map[net.Listener]struct{} // Listeners of running Serve calls
 	conns      map[net.Conn]struct{}
+	languages  map[string]language // LANG catalogs by lowercase tag
 	inShutdown atomic.Bool
 
-	// Transfer logging (xferlog standard format)
-	transferLog io.Writer
+	// sessionsMu protects sessions. Server.Sessions holds it while reading
+	// the sessions, so they are not recycled in the meantime.
+	sessionsMu sync.RWMutex
+	sessions   map[*session]struct{}
+
+	// Transfer logging (see WithTransferLog and WithTransferLogger)
+	transferLoggers []TransferLogger
 
 	// Bandwidth limiting
 	bandwidthLimitGlobal  int64              // bytes per second, 0 = unlimited
 	bandwidthLimitPerUser int64              // bytes per second, 0 = unlimited
 	globalLimiter         *ratelimit.Limiter // shared across all users
+	bandwidthMu           sync.Mutex         // Protects the maps below
+	bandwidthClasses      map[string]int64   // Per-user rates by class (see WithBandwidthClasses)
+	userBandwidth         map[string]int64   // Per-user rates set with SetUserBandwidth
 
 	// Transport abstraction
-	listenerFactory  ListenerFactory // For passive mode data connections
-	disabledCommands map[string]bool // Commands to disable (e.g., PORT, EPRT)
+	listenerFactory  ListenerFactory    // For passive mode data connections
+	dataReuse        bool               // Accept MODE B and keep data connections between transfers
+	activeSource     *net.TCPAddr       // Local address of active mode data connections (optional)
+	passiveAddress   PassiveAddressFunc // Address advertised in PASV replies (optional)
+	socketOptions    *SocketOptions     // TCP tuning of data connections (optional)
+	bufferPool       *sync.Pool         // Transfer buffers of a custom size (optional)
+	disabledCommands map[string]bool    // Commands to disable (e.g., PORT, EPRT)
 }
 
-// transferBufferPool is a pool of byte slices used for data transfers to reduce allocations.
-var transferBufferPool = sync.Pool{
-	New: func() interface{} {
-		buf := make([]byte, 32*1024)
-		return &buf
-	},
-}
+// defaultTransferBufferSize is the size of the buffers used for data transfers.
+const defaultTransferBufferSize = 32 * 1024
 
-// copyWithPooledBuffer copies from src to dst using a buffer from the pool.
-func copyWithPooledBuffer(dst io.Writer, src io.Reader) (int64, error) {
-	pbuf := transferBufferPool.Get().(*[]byte)
-	defer transferBufferPool.Put(pbuf)
+// transferBufferPool is a pool of byte slices used for data transfers to reduce allocations.
+var transferBufferPool = newBufferPool(defaultTransferBufferSize)
+
+// copyWithPooledBuffer copies from src to dst using a buffer from the pool,
+// or from the server's own pool if WithTransferBufferSize was used.
+func (s *Server) copyWithPooledBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	pool := transferBufferPool
+	if s.bufferPool != nil {
+		pool = s.bufferPool
+	}
+	pbuf := pool.Get().(*[]byte)
+	defer pool.Put(pbuf)
 	return io.CopyBuffer(dst, src, *pbuf)
 }
 
@@ -212,9 +308,13 @@ func NewServer(addr string, options ...Option) (*Server, error) {
 		serverName:      "UNIX Type: L8",
 		maxIdleTime:     5 * time.Minute,
 		conns:           make(map[net.Conn]struct{}),
+		sessions:        make(map[*session]struct{}),
+		zeroCopy:        true,
+		maxPathLength:   MaxPathLength,
 		connsByIP:       make(map[string]int32),
 		listenerFactory: &DefaultListenerFactory{},
 	}
+	s.auditSink = logAuditSink{server: s}
 
 	// Apply options
 	for _, opt := range options {
@@ -228,6 +328,11 @@ func NewServer(addr string, options ...Option) (*Server, error) {
 		return nil, fmt.Errorf("driver is required (use WithDriver option)")
 	}
 
+	s.setupCertificates()
+	if err := s.applyTLSPolicy(); err != nil {
+		return nil, err
+	}
+
 	// Initialize global rate limiter if bandwidth limit is set
 	if s.bandwidthLimitGlobal > 0 {
 		s.globalLimiter = ratelimit.New(s.bandwidthLimitGlobal)
@@ -304,9 +409,15 @@ func (s *Server) redactIP(ip string) string {
 // It blocks until the server stops or an error occurs.
 //
 // This is a convenience method that creates a TCP listener and calls Serve().
+// An address of the form "unix:/path/to/socket" listens on a Unix domain
+// socket instead (see Serve).
 // For more control (e.g., graceful shutdown), use net.Listen() and Serve() directly.
 func (s *Server) ListenAndServe() error {
-	ln, err := net.Listen("tcp", s.addr)
+	network, address := "tcp", s.addr
+	if path, ok := strings.CutPrefix(s.addr, "unix:"); ok {
+		network, address = "unix", path
+	}
+	ln, err := net.Listen(network, address)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
 	}
@@ -334,15 +445,17 @@ func (s *Server) ListenAndServe() error {
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.inShutdown.Store(true)
 
-	// Close the listener to stop accepting new connections
+	// Close the listeners to stop accepting new connections
 	s.mu.Lock()
-	ln := s.listener
-	s.listener = nil
+	listeners := s.listeners
+	s.listeners = nil
 	s.mu.Unlock()
 
 	var err error
-	if ln != nil {
-		err = ln.Close()
+	for ln := range listeners {
+		if cerr := ln.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
 	}
 
 	// Wait for active connections to finish or context to expire
@@ -383,7 +496,14 @@ func (s *Server) Shutdown(ctx context.Context) error {
 // It blocks until the listener is closed or an error occurs.
 //
 // Each connection is handled in a separate goroutine. The server enforces
-// connection limits (if configured) and idle timeouts.
+// connection limits (if configured) and idle timeouts. Serve may be called
+// concurrently with several listeners, such as those of SystemdListeners;
+// Shutdown closes all of them.
+//
+// The listener may be a Unix domain socket listener. Passive data
+// connections are still TCP: PASV advertises the loopback address, unless
+// another one is configured (see WithPassiveAddress), and EPSV replies
+// carry no address.
 //
 // For graceful shutdown, close the listener from another goroutine:
 //
@@ -400,21 +520,34 @@ func (s *Server) Serve(l net.Listener) error {
 		l.Close()
 		return ErrServerClosed
 	}
-	s.listener = l
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
 	s.mu.Unlock()
 
 	defer func() {
 		s.mu.Lock()
-		if s.listener == l {
-			s.listener = nil
-		}
+		delete(s.listeners, l)
 		s.mu.Unlock()
 		l.Close()
 	}()
 
+	// Stop accepting while the listener has its maximum number of sessions
+	var slots chan struct{}
+	if s.maxSessionsPerListener > 0 {
+		slots = make(chan struct{}, s.maxSessionsPerListener)
+	}
+
 	for {
+		if slots != nil {
+			slots <- struct{}{}
+		}
 		conn, err := l.Accept()
 		if err != nil {
+			if slots != nil {
+				<-slots
+			}
 			if s.inShutdown.Load() {
 				return ErrServerClosed
 			}
@@ -422,20 +555,58 @@ func (s *Server) Serve(l net.Listener) error {
 			continue
 		}
 
-		go s.handleConnection(conn)
+		go func() {
+			s.handleConnection(conn)
+			if slots != nil {
+				<-slots
+			}
+		}()
+	}
+}
+
+// ServeConn runs an FTP session on conn, a connection accepted or
+// established by the caller. It blocks until the session ends and closes
+// conn.
+//
+// ServeConn is meant for embedders that don't have a net.Listener: inetd
+// style servers, SSH tunnels or multiplexed transports. The session is
+// subject to the same connection limits, and is closed by Shutdown like the
+// ones accepted by Serve. If conn implements ListenerFactory, it is used to
+// open the passive mode data listeners of the session.
+//
+// If the server is shutting down, ServeConn closes conn and returns
+// ErrServerClosed.
+func (s *Server) ServeConn(conn net.Conn) error {
+	if !s.handleConnection(conn) {
+		return ErrServerClosed
+	}
+	return nil
+}
+
+// ServeTLS is like Serve, with implicit FTPS: connections accepted on l
+// start with a TLS handshake, using the configuration of WithTLS (see
+// TLSConfig). It is meant for listeners created by others, such as those of
+// SystemdListeners, which cannot be created with tls.Listen.
+func (s *Server) ServeTLS(l net.Listener) error {
+	if s.tlsConfig == nil {
+		l.Close()
+		return errors.New("implicit TLS requires WithTLS")
 	}
+	return s.Serve(tls.NewListener(l, s.tlsConfig))
 }
 
-// handleConnection handles a new client connection.
-func (s *Server) handleConnection(conn net.Conn) {
+// handleConnection handles a new client connection. It returns false if
+// the server is shutting down.
+func (s *Server) handleConnection(conn net.Conn) bool {
 	if !s.trackConnection(conn, true) {
 		conn.Close()
-		return
+		return false
 	}
 	defer s.trackConnection(conn, false)
 
 	// Create a new session for this connection
 	s.handleSession(conn)
+	return true
 }
 
 // trackConnection returns false if we're shutting down.
@@ -501,14 +672,14 @@ func (c *trackingConn) Close() error {
 func (s *Server) handleSession(conn net.Conn) {
 	// Check global connection limit
 	if s.maxConnections > 0 && s.activeConns.Load() >= int32(s.maxConnections) {
-		// Security audit: connection limit reached
 		remoteAddr := conn.RemoteAddr().String()
 		ip, _, _ := net.SplitHostPort(remoteAddr)
-		s.logger.Warn("connection_rejected",
-			"remote_ip", ip,
-			"reason", "global_limit_reached",
-			"limit", s.maxConnections,
-		)
+		s.audit(AuditEvent{
+			Type:     AuditConnectionRejected,
+			RemoteIP: ip,
+			Reason:   "global_limit_reached",
+			Attrs:    []slog.Attr{slog.Int("limit", s.maxConnections)},
+		})
 		// Metrics collection
 		if s.metricsCollector != nil {
 			s.metricsCollector.RecordConnection(false, "global_limit_reached")
@@ -533,12 +704,12 @@ func (s *Server) handleSession(conn net.Conn) {
 		currentCount := s.connsByIP[ip]
 		if currentCount > int32(s.maxConnectionsPerIP) {
 			s.connsByIPMu.Unlock()
-			// Security audit: per-IP connection limit reached
-			s.logger.Warn("connection_rejected",
-				"remote_ip", ip,
-				"reason", "per_ip_limit_reached",
-				"limit", s.maxConnectionsPerIP,
-			)
+			s.audit(AuditEvent{
+				Type:     AuditConnectionRejected,
+				RemoteIP: ip,
+				Reason:   "per_ip_limit_reached",
+				Attrs:    []slog.Attr{slog.Int("limit", s.maxConnectionsPerIP)},
+			})
 			// Metrics collection
 			if s.metricsCollector != nil {
 				s.metricsCollector.RecordConnection(false, "per_ip_limit_reached")
@@ -559,5 +730,16 @@ func (s *Server) handleSession(conn net.Conn) {
 	}
 
 	session := newSession(s, conn)
+	s.sessionsMu.Lock()
+	s.sessions[session] = struct{}{}
+	s.recordSessions()
+	s.sessionsMu.Unlock()
+
 	session.serve()
+
+	s.sessionsMu.Lock()
+	delete(s.sessions, session)
+	s.recordSessions()
+	s.sessionsMu.Unlock()
+	sessionPool.Put(session)
 }
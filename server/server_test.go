@@ -3,9 +3,11 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 
@@ -232,3 +234,154 @@ func TestListenAndServe(t *testing.T) {
 		// Assume it started successfully if it hasn't returned in 200ms
 	}
 }
+
+func TestServer_ServeConn(t *testing.T) {
+	t.Parallel()
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir, WithAnonWrite(true))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	server, err := NewServer("", WithDriver(driver))
+	fatalIfErr(t, err, "Failed to create server")
+
+	// The caller accepts the connection, not the server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	defer ln.Close()
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- server.ServeConn(conn)
+	}()
+
+	c, err := ftp.Dial(ln.Addr().String(), ftp.WithTimeout(5*time.Second))
+	fatalIfErr(t, err, "Failed to dial")
+	fatalIfErr(t, c.Login("anonymous", "anonymous"), "Login failed")
+	fatalIfErr(t, c.Store("a.txt", bytes.NewBufferString("data")), "Store failed")
+	var buf bytes.Buffer
+	fatalIfErr(t, c.Retrieve("a.txt", &buf), "Retrieve failed")
+	if buf.String() != "data" {
+		t.Errorf("Retrieve = %q, want %q", buf.String(), "data")
+	}
+	fatalIfErr(t, c.Quit(), "Quit failed")
+
+	select {
+	case err := <-done:
+		fatalIfErr(t, err, "ServeConn failed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeConn did not return after QUIT")
+	}
+
+	// After Shutdown, connections are refused
+	fatalIfErr(t, server.Shutdown(context.Background()), "Shutdown failed")
+	client, conn := net.Pipe()
+	defer client.Close()
+	if err := server.ServeConn(conn); err != ErrServerClosed {
+		t.Errorf("ServeConn after Shutdown = %v, want ErrServerClosed", err)
+	}
+}
+
+func TestServer_UnixSocket(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix domain sockets are not tested on Windows")
+	}
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir, WithAnonWrite(true))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	path := filepath.Join(t.TempDir(), "ftp.sock")
+	server, err := NewServer("unix:"+path, WithDriver(driver))
+	fatalIfErr(t, err, "Failed to create server")
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != ErrServerClosed {
+			t.Logf("Server stopped: %v", err)
+		}
+	}()
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	var conn net.Conn
+	for range 100 {
+		if conn, err = net.Dial("unix", path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fatalIfErr(t, err, "Failed to dial")
+
+	// Data connections are TCP, to the loopback address of the PASV reply
+	c, err := ftp.NewClient(conn, ftp.WithTimeout(5*time.Second), ftp.WithDisableEPSV())
+	fatalIfErr(t, err, "Failed to connect")
+	defer func() { _ = c.Quit() }()
+	fatalIfErr(t, c.Login("anonymous", "anonymous"), "Login failed")
+	fatalIfErr(t, c.Store("a.txt", bytes.NewBufferString("data")), "Store failed")
+	entries, err := c.List("/")
+	fatalIfErr(t, err, "List failed")
+	if len(entries) != 1 || entries[0].Name != "a.txt" {
+		t.Errorf("List = %v, want a.txt", entries)
+	}
+}
+
+func TestServer_ServeTLS(t *testing.T) {
+	t.Parallel()
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir, WithAnonWrite(true))
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	server, err := NewServer("", WithDriver(driver))
+	fatalIfErr(t, err, "Failed to create server")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	if err := server.ServeTLS(ln); err == nil {
+		t.Error("ServeTLS without WithTLS succeeded")
+	}
+
+	server, err = NewServer("", WithDriver(driver), WithTLS(testTLSConfig(t)))
+	fatalIfErr(t, err, "Failed to create server")
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	go func() { _ = server.ServeTLS(ln) }()
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	c, err := ftp.Dial(ln.Addr().String(),
+		ftp.WithTimeout(5*time.Second),
+		ftp.WithImplicitTLS(&tls.Config{InsecureSkipVerify: true}),
+	)
+	fatalIfErr(t, err, "Failed to dial")
+	defer func() { _ = c.Quit() }()
+	fatalIfErr(t, c.Login("anonymous", "anonymous"), "Login failed")
+	fatalIfErr(t, c.Store("a.txt", bytes.NewBufferString("data")), "Store failed")
+}
+
+func TestServer_ShutdownListeners(t *testing.T) {
+	t.Parallel()
+	driver, err := NewFSDriver(t.TempDir())
+	fatalIfErr(t, err, "Failed to create FS driver")
+	server, err := NewServer("", WithDriver(driver))
+	fatalIfErr(t, err, "Failed to create server")
+
+	// Shutdown stops every Serve call
+	done := make(chan error, 2)
+	for range 2 {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		fatalIfErr(t, err, "Failed to listen")
+		go func() { done <- server.Serve(ln) }()
+		// Wait until the listener is served
+		c, err := ftp.Dial(ln.Addr().String(), ftp.WithTimeout(5*time.Second))
+		fatalIfErr(t, err, "Failed to dial")
+		fatalIfErr(t, c.Quit(), "Quit failed")
+	}
+	fatalIfErr(t, server.Shutdown(context.Background()), "Shutdown failed")
+	for range 2 {
+		select {
+		case err := <-done:
+			if err != ErrServerClosed {
+				t.Errorf("Serve = %v, want ErrServerClosed", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Serve did not return after Shutdown")
+		}
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"os"
 	"strconv"
@@ -14,6 +16,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gonzalop/ftp/internal/blockmode"
 	"github.com/gonzalop/ftp/internal/ratelimit"
 )
 
@@ -32,19 +35,50 @@ type session struct {
 	// Session tracking
 	sessionID string
 	remoteIP  string
+	localIP   string // Server address the control connection arrived on
+
+	// Creates passive listeners: the control connection if it implements
+	// ListenerFactory, otherwise the server's factory
+	listenerFactory ListenerFactory
+
+	// Parent of the driver operation contexts, canceled when the control
+	// connection closes
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	// State
-	isLoggedIn    bool
-	user          string
-	renameFrom    string // For RNFR/RNTO
-	fs            ClientContext
-	restartOffset int64  // For REST command
-	host          string // From HOST command
-	selectedHash  string // Default SHA-256
-	transferType  string // Transfer type (A=ASCII, I=Binary), default I
+	isLoggedIn     bool
+	user           string
+	renameFrom     string               // For RNFR/RNTO
+	fs             ContextClientContext // File operations, with contexts
+	driverFS       any                  // The driver's value of fs, for the optional interfaces
+	caps           driverCaps           // Optional operations of driverFS
+	restartOffset  int64                // For REST and RANG commands
+	rangeEnd       int64                // For RANG command: last byte (inclusive), valid if hasRange
+	hasRange       bool                 // A RANG byte range is pending
+	host           string               // From HOST command
+	selectedHash   string               // Default SHA-256
+	transferType   string               // Transfer type (A=ASCII, I=Binary), default I
+	utf8Mode       bool                 // OPTS UTF8 ON: names are sent without transcoding
+	lang           string               // Language selected with LANG
+	catalog        MessageCatalog       // Translations for lang (nil for English)
+	statCache      *statCache           // SIZE/MDTM/MLST metadata cache (nil if disabled)
+	limiter        commandLimiter       // Command rate and flood limits
+	bandwidth      *ratelimit.Limiter   // Per-user transfer rate, set at login
+	bandwidthClass string               // Bandwidth class of the user (see WithBandwidthClasses)
+	command        string               // Command being handled, for audit events
+	commandArg     string               // Argument of command
+	lastReply      int                  // Code of the last reply sent, for command metrics
+
+	// TLS configuration selecting certificates by HOST (see sessionTLSConfig)
+	tlsConfig     *tls.Config
+	tlsConfigOnce sync.Once
+	tlsLogged     bool // The TLS parameters of the control connection were logged
 
 	// Background transfer state
 	busy           bool
+	transfer       *transferProgress // Progress of the transfer, for STAT and Server.Sessions
+	lastTransfer   time.Time         // End of the last transfer, for the idle timeout
 	transferCtx    context.Context
 	transferCancel context.CancelFunc
 	transferWG     sync.WaitGroup
@@ -54,10 +88,12 @@ type session struct {
 
 	// Data connection state
 	dataConn   net.Conn
-	pasvList   net.Listener
+	pasvList   *passiveListener
 	activeIP   string
 	activePort int
-	prot       string // PROT P or C
+	prot       string    // PROT P or C
+	blockMode  bool      // MODE B (see WithDataConnectionReuse)
+	keptData   *keptData // Block mode data connection of the last transfer
 
 	// Cache for PASV IP resolution
 	lastPublicHost string
@@ -99,6 +135,7 @@ var commandHandlers = map[string]func(*session, string){
 	"EPSV": (*session).handleEPSV,
 	"EPRT": (*session).handleEPRT,
 	"REST": (*session).handleREST,
+	"RANG": (*session).handleRANG,
 
 	// Information
 	"SIZE": (*session).handleSIZE,
@@ -112,6 +149,7 @@ var commandHandlers = map[string]func(*session, string){
 	"AUTH": (*session).handleAUTH,
 	"PROT": (*session).handlePROT,
 	"PBSZ": (*session).handlePBSZ,
+	"CCC":  (*session).handleCCC,
 
 	// RFC 1123 Compliance
 	"ACCT": (*session).handleACCT,
@@ -126,14 +164,54 @@ var commandHandlers = map[string]func(*session, string){
 	"HOST": (*session).handleHOST,
 	"HASH": (*session).handleHASH,
 	"MFMT": (*session).handleMFMT,
+	"MFCT": (*session).handleMFCT,
+	"MFF":  (*session).handleMFF,
+	"COMB": (*session).handleCOMB,
+	"AVBL": (*session).handleAVBL,
+	"LANG": (*session).handleLANG,
+
+	// Non-standard checksums
+	"XCRC":    (*session).handleXCRC,
+	"XMD5":    (*session).handleXMD5,
+	"XSHA1":   (*session).handleXSHA1,
+	"XSHA256": (*session).handleXSHA256,
 
 	// Special
 	"ABOR": (*session).handleABOR,
 }
 
+// restartPreservingCommands are the commands that keep a pending REST marker.
+// The transfer commands consume the marker themselves; any other command
+// clears it.
+var restartPreservingCommands = map[string]bool{
+	"REST": true,
+	"RANG": true,
+	"RETR": true,
+	"STOR": true,
+	"APPE": true,
+	"TYPE": true,
+	"PASV": true,
+	"EPSV": true,
+	"PORT": true,
+	"EPRT": true,
+	"NOOP": true,
+}
+
 // validateActiveIP ensures the data connection target matches the control connection source.
 // This prevents FTP bounce attacks.
 func (s *session) validateActiveIP(ip net.IP) bool {
+	// FXP deliberately targets a third-party server; only trust that for
+	// authenticated sessions.
+	if s.server.allowFXP && s.isLoggedIn {
+		return true
+	}
+
+	for _, network := range s.server.activeNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
 	remoteAddr := s.conn.RemoteAddr().String()
 	host, _, err := net.SplitHostPort(remoteAddr)
 	if err != nil {
@@ -148,6 +226,51 @@ func (s *session) validateActiveIP(ip net.IP) bool {
 	return ip.Equal(remoteIP)
 }
 
+// checkActiveTarget applies the active mode policy to the target of a PORT
+// or EPRT command. It returns why the target is rejected ("address_family",
+// "privileged_port" or "address_mismatch"), or "" if it is allowed.
+// Rejections are audited.
+func (s *session) checkActiveTarget(cmd string, ip net.IP, port int) string {
+	var reason string
+	switch {
+	case !s.validateActiveFamily(ip):
+		reason = "address_family"
+	case s.server.blockPrivileged && port < 1024:
+		reason = "privileged_port"
+	case !s.validateActiveIP(ip):
+		reason = "address_mismatch"
+	default:
+		return ""
+	}
+
+	s.audit(AuditEvent{
+		Type:    AuditBounceRejected,
+		Command: cmd,
+		Reason:  reason,
+		Attrs:   []slog.Attr{slog.String("target", net.JoinHostPort(ip.String(), strconv.Itoa(port)))},
+	})
+	return reason
+}
+
+// validateActiveFamily ensures the data connection target is of the same
+// address family as the control connection, unless mixed families are allowed.
+func (s *session) validateActiveFamily(ip net.IP) bool {
+	if s.server.mixedFamilies {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(s.conn.RemoteAddr().String())
+	if err != nil {
+		return true // Not an IP transport; nothing to compare
+	}
+	remoteIP := net.ParseIP(host)
+	if remoteIP == nil {
+		return true
+	}
+
+	return (ip.To4() != nil) == (remoteIP.To4() != nil)
+}
+
 // generateSessionID generates a unique 8-character session ID.
 func generateSessionID() string {
 	b := make([]byte, 4)
@@ -169,9 +292,8 @@ func (s *session) redactIP(ip string) string {
 // Applies both global and per-user limits (most restrictive wins).
 func (s *session) rateLimitReader(r io.Reader) io.Reader {
 	// Apply per-user limit
-	if s.server.bandwidthLimitPerUser > 0 {
-		limiter := ratelimit.New(s.server.bandwidthLimitPerUser)
-		r = ratelimit.NewReader(r, limiter)
+	if s.bandwidthLimited() {
+		r = ratelimit.NewReader(r, s.userLimiter())
 	}
 
 	// Apply global limit (chains with per-user if both set)
@@ -186,9 +308,8 @@ func (s *session) rateLimitReader(r io.Reader) io.Reader {
 // Applies both global and per-user limits (most restrictive wins).
 func (s *session) rateLimitWriter(w io.Writer) io.Writer {
 	// Apply per-user limit
-	if s.server.bandwidthLimitPerUser > 0 {
-		limiter := ratelimit.New(s.server.bandwidthLimitPerUser)
-		w = ratelimit.NewWriter(w, limiter)
+	if s.bandwidthLimited() {
+		w = ratelimit.NewWriter(w, s.userLimiter())
 	}
 
 	// Apply global limit (chains with per-user if both set)
@@ -199,6 +320,14 @@ func (s *session) rateLimitWriter(w io.Writer) io.Writer {
 	return w
 }
 
+// sessionPool recycles session structs, as servers with many short-lived
+// connections create and discard them at a high rate.
+var sessionPool = sync.Pool{
+	New: func() any {
+		return new(session)
+	},
+}
+
 // newSession creates a new session.
 func newSession(server *Server, conn net.Conn) *session {
 	// Generate unique session ID
@@ -210,6 +339,7 @@ func newSession(server *Server, conn net.Conn) *session {
 	if err != nil {
 		remoteIP = remoteAddr // Fallback to full address
 	}
+	localIP, _, _ := net.SplitHostPort(conn.LocalAddr().String())
 
 	tr := telnetReaderPool.Get().(*telnetReader)
 	tr.Reset(conn)
@@ -220,7 +350,8 @@ func newSession(server *Server, conn net.Conn) *session {
 	writer := controlWriterPool.Get().(*bufio.Writer)
 	writer.Reset(conn)
 
-	s := &session{
+	s := sessionPool.Get().(*session)
+	*s = session{
 		server:       server,
 		conn:         conn,
 		reader:       reader,
@@ -228,10 +359,19 @@ func newSession(server *Server, conn net.Conn) *session {
 		tnet:         tr,
 		sessionID:    sessionID,
 		remoteIP:     remoteIP,
+		localIP:      localIP,
 		prot:         "C", // Default to clear
 		selectedHash: "SHA-256",
 		transferType: "I",
+		lang:         defaultLanguage,
 		cmdReqChan:   make(chan struct{}),
+		statCache:    newStatCache(server.statCacheTTL),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.listenerFactory = server.listenerFactory
+	if f, ok := conn.(ListenerFactory); ok {
+		s.listenerFactory = f
 	}
 
 	// Detect Implicit TLS (connection is already a *tls.Conn)
@@ -300,12 +440,41 @@ func (s *session) serve() {
 
 	cmdChan := s.startCommandReader(done)
 
+	// A nil channel never fires, so sessions without a limit never expire
+	var expired <-chan time.Time
+	if s.server.maxSessionDuration > 0 {
+		timer := time.NewTimer(s.server.maxSessionDuration)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
 	for {
-		cmd, ok := <-cmdChan
+		var cmd command
+		var ok bool
+		select {
+		case cmd, ok = <-cmdChan:
+		case <-expired:
+			s.server.logger.Info("session_expired",
+				"session_id", s.sessionID,
+				"remote_ip", s.redactIP(s.remoteIP),
+				"user", s.user,
+			)
+			s.disconnect("Maximum session time exceeded; closing control connection.", cmdChan)
+			return
+		}
 		if !ok {
 			return
 		}
 
+		if errors.Is(cmd.err, errIdleTimeout) {
+			s.server.logger.Info("session_idle_timeout",
+				"session_id", s.sessionID,
+				"remote_ip", s.redactIP(s.remoteIP),
+				"user", s.user,
+			)
+			s.disconnect("Idle timeout; closing control connection.", cmdChan)
+			return
+		}
 		if cmd.err != nil {
 			if cmd.err != io.EOF && cmd.err.Error() != "command too long" {
 				s.server.logger.Warn("read error",
@@ -318,6 +487,14 @@ func (s *session) serve() {
 			if cmd.err.Error() == "command too long" {
 				s.reply(500, "Command line too long.")
 			}
+			s.reportTLSFailure(cmd.err)
+			return
+		}
+		s.logTLS()
+
+		if reason := s.checkLimits(cmd.line); reason != "" {
+			s.audit(AuditEvent{Type: AuditSessionAbuse, Reason: reason})
+			s.disconnect("Too many commands; closing control connection.", cmdChan)
 			return
 		}
 
@@ -333,45 +510,89 @@ func (s *session) serve() {
 			_ = s.conn.SetWriteDeadline(time.Time{})
 		}
 
+		// The reader is waiting for this signal: it only stops after
+		// sending an error, which ends this loop
+		s.cmdReqChan <- struct{}{}
+	}
+}
+
+// disconnect replies 421 with message and closes the control connection.
+// It returns once the command reader has stopped, so that close can recycle
+// its buffers.
+func (s *session) disconnect(message string, cmdChan <-chan command) {
+	// Don't wait long for a client that doesn't read its replies
+	_ = s.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	s.reply(421, message)
+	s.conn.Close()
+
+	for {
 		select {
+		case _, ok := <-cmdChan:
+			if !ok {
+				return
+			}
 		case s.cmdReqChan <- struct{}{}:
-		case <-time.After(1 * time.Second):
 		}
 	}
 }
 
 func (s *session) sendWelcome() {
-	if strings.HasPrefix(s.server.welcomeMessage, "220 ") {
-		s.mu.Lock()
-		fmt.Fprintf(s.writer, "%s\r\n", s.server.welcomeMessage)
-		s.writer.Flush()
-		s.mu.Unlock()
-	} else if strings.HasPrefix(s.server.welcomeMessage, "220") {
-		s.mu.Lock()
-		fmt.Fprintf(s.writer, "220 %s\r\n", s.server.welcomeMessage[3:])
-		s.writer.Flush()
-		s.mu.Unlock()
-	} else {
-		s.reply(220, s.server.welcomeMessage)
+	message := s.server.welcomeMessage
+
+	// On implicit FTPS connections, the SNI name can select a virtual host
+	// before the banner is sent.
+	if tlsConn, ok := s.conn.(*tls.Conn); ok && len(s.server.virtualHosts) > 0 && tlsConn.Handshake() == nil {
+		if vh, ok := s.virtualHost(); ok && vh.WelcomeMessage != "" {
+			message = vh.WelcomeMessage
+		}
+	}
+
+	if banner := s.banner(); banner != "" {
+		s.reply(220, banner)
+		return
+	}
+
+	// The welcome message may start with the code
+	if rest, ok := strings.CutPrefix(message, "220"); ok {
+		message = strings.TrimPrefix(rest, " ")
 	}
+	s.reply(220, message)
 }
 
 func (s *session) startCommandReader(done chan struct{}) chan command {
 	cmdChan := make(chan command)
 	go func() {
 		defer close(cmdChan)
+		lastCommand := time.Now()
+		var partial string // Start of a line interrupted by an idle check
 		for {
 			s.mu.Lock()
 			conn := s.conn
 			s.mu.Unlock()
 
+			idleCheck := s.server.readTimeout <= 0 && s.server.maxIdleTime > 0
 			if s.server.readTimeout > 0 {
 				_ = conn.SetReadDeadline(time.Now().Add(s.server.readTimeout))
-			} else if s.server.maxIdleTime > 0 {
-				_ = conn.SetReadDeadline(time.Now().Add(s.server.maxIdleTime))
+			} else if idleCheck {
+				_ = conn.SetReadDeadline(s.idleDeadline(lastCommand))
 			}
 
 			line, err := s.readCommand()
+			if idleCheck && isTimeout(err) {
+				// A transfer may have kept the session busy meanwhile
+				if time.Now().Before(s.idleDeadline(lastCommand)) {
+					partial += line
+					continue
+				}
+				err = errIdleTimeout
+			}
+			line, partial = partial+line, ""
+			lastCommand = time.Now()
+			if err != nil {
+				// The connection is gone: cancel the driver operations
+				// blocking the main loop, if any
+				s.cancel()
+			}
 
 			select {
 			case cmdChan <- command{line, err}:
@@ -393,6 +614,35 @@ func (s *session) startCommandReader(done chan struct{}) chan command {
 	return cmdChan
 }
 
+// errIdleTimeout is the error of the command reader when the session has
+// been idle for longer than maxIdleTime.
+var errIdleTimeout = errors.New("idle timeout")
+
+// idleDeadline returns when the session becomes idle: maxIdleTime after the
+// last command or data transfer activity, whichever is later. Transfers in
+// progress count as activity as long as data moves, so a long transfer on a
+// quiet control connection does not time out.
+func (s *session) idleDeadline(lastCommand time.Time) time.Time {
+	last := lastCommand
+	s.mu.Lock()
+	if s.transfer != nil {
+		if t := time.Unix(0, s.transfer.lastActivity.Load()); t.After(last) {
+			last = t
+		}
+	}
+	if s.lastTransfer.After(last) {
+		last = s.lastTransfer
+	}
+	s.mu.Unlock()
+	return last.Add(s.server.maxIdleTime)
+}
+
+// isTimeout reports whether err is a network timeout.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
 // readCommand reads a line from the reader with a limit.
 func (s *session) readCommand() (string, error) {
 	// Protect reader access (needed because reader might be swapped by AUTH TLS)
@@ -416,25 +666,28 @@ func (s *session) readCommand() (string, error) {
 
 // close closes the session and underlying connection.
 func (s *session) close() {
+	s.cancel()
 	s.mu.Lock()
 	if s.transferCancel != nil {
 		s.transferCancel()
 	}
 	s.mu.Unlock()
 
-	if s.fs != nil {
-		s.fs.Close()
-	}
 	if s.pasvList != nil {
 		s.pasvList.Close()
 	}
 	if s.dataConn != nil {
-		s.dataConn.Close()
+		blockmode.Interrupt(s.dataConn)
 	}
 	s.conn.Close()
 
-	// Wait for all background transfers to finish before returning objects to the pool
+	// Wait for all background transfers to finish before returning objects to the pool.
+	// The driver is closed afterwards, so aborted uploads can still be cleaned up.
 	s.transferWG.Wait()
+	s.closeKeptData()
+	if s.fs != nil {
+		s.fs.Close()
+	}
 
 	// Return pooled objects
 	if s.reader != nil {
@@ -460,6 +713,15 @@ func (s *session) close() {
 	)
 }
 
+// parseCommand splits a command line into the upper-case command name and
+// its argument.
+func parseCommand(line string) (cmd, arg string) {
+	name, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+	// A CR in a path is sent as CR NUL (RFC 2640 Section 3.1)
+	arg = strings.ReplaceAll(arg, "\r\x00", "\r")
+	return strings.ToUpper(name), arg
+}
+
 // handleCommand parses and dispatches a command.
 func (s *session) handleCommand(line string) {
 	line = strings.TrimRight(line, "\r\n")
@@ -467,12 +729,8 @@ func (s *session) handleCommand(line string) {
 		return
 	}
 
-	parts := strings.SplitN(line, " ", 2)
-	cmd := strings.ToUpper(parts[0])
-	arg := ""
-	if len(parts) > 1 {
-		arg = parts[1]
-	}
+	cmd, arg := parseCommand(line)
+	arg = s.decodeName(arg)
 
 	logArg := arg
 	if cmd == "PASS" {
@@ -486,6 +744,20 @@ func (s *session) handleCommand(line string) {
 		"arg", logArg,
 	)
 
+	// Multi-line replies are not tracked, but they are all successful
+	if mc := s.server.metricsCollector; mc != nil {
+		start := time.Now()
+		s.mu.Lock()
+		s.lastReply = 0
+		s.mu.Unlock()
+		defer func() {
+			s.mu.Lock()
+			code := s.lastReply
+			s.mu.Unlock()
+			mc.RecordCommand(metricsCommand(cmd), code < 400, time.Since(start))
+		}()
+	}
+
 	s.mu.Lock()
 	busy := s.busy
 	s.mu.Unlock()
@@ -497,10 +769,43 @@ func (s *session) handleCommand(line string) {
 
 	// Check if command is disabled
 	if s.server.disabledCommands[cmd] {
+		s.audit(AuditEvent{Type: AuditCommandDisabled, Command: cmd})
 		s.reply(502, "Command not implemented.")
 		return
 	}
 
+	// Reject pathological paths before they reach the driver
+	if s.pathTooLong(cmd, arg) {
+		s.reply(553, "File name too long.")
+		return
+	}
+
+	// Report attempts to climb above the root; the driver clamps the path
+	if pathCommands[cmd] && s.fs != nil {
+		if cwd, err := s.fs.GetWd(s.context()); err == nil && escapesRoot(cwd, arg) {
+			s.audit(AuditEvent{Type: AuditPathTraversal, Command: cmd, Path: arg})
+		}
+	}
+
+	// Remembered for the audit events of the handlers
+	s.command, s.commandArg = cmd, arg
+
+	s.clearStaleState(cmd)
+
+	// Checked before the handlers open files, so that a STOR without a
+	// data connection does not truncate the file
+	if dataCommands[cmd] && s.isLoggedIn && s.pasvList == nil && s.activeIP == "" && !s.hasReusableData() {
+		s.restartOffset = 0
+		s.hasRange = false
+		s.reply(425, "Use PORT or PASV first.")
+		return
+	}
+
+	// Commands that modify files make cached metadata stale
+	if statInvalidatingCommands[cmd] {
+		s.statCache.clear()
+	}
+
 	// Handle special commands that return errors
 	var err error
 	switch cmd {
@@ -535,7 +840,38 @@ func (s *session) handleCommand(line string) {
 	}
 }
 
+// clearStaleState ends the command sequences that cmd interrupts:
+//   - RNTO must immediately follow RNFR (RFC 959 Section 4.1.3).
+//   - A REST marker applies only to the transfer command that follows it
+//     (RFC 3659 Section 5.3). Data connection setup is allowed in between.
+//
+// The data connection set up with PASV, EPSV, PORT or EPRT is kept until a
+// transfer uses it, or another of them replaces it.
+func (s *session) clearStaleState(cmd string) {
+	if !restartPreservingCommands[cmd] {
+		s.restartOffset = 0
+		s.hasRange = false
+	}
+	if cmd != "RNTO" {
+		s.renameFrom = ""
+	}
+}
+
+// clearDataSetup forgets the data connection set up with PASV, EPSV, PORT
+// or EPRT.
+func (s *session) clearDataSetup() {
+	if s.pasvList != nil {
+		s.pasvList.Close()
+		s.pasvList = nil
+	}
+	s.activeIP = ""
+	s.closeKeptData()
+}
+
+// connData opens the data connection for a transfer. The setup is used up
+// whether or not the connection succeeds.
 func (s *session) connData() (net.Conn, error) {
+	defer s.clearDataSetup()
 	if s.pasvList != nil {
 		return s.connPassive()
 	}
@@ -552,16 +888,10 @@ func (s *session) connPassive() (net.Conn, error) {
 		"session_id", s.sessionID,
 		"remote_ip", s.redactIP(s.remoteIP),
 	)
-	// Set a deadline for the client to connect
-	if t, ok := s.pasvList.(*net.TCPListener); ok {
-		_ = t.SetDeadline(time.Now().Add(10 * time.Second))
-	}
-	conn, err := s.pasvList.Accept()
+	conn, err := s.pasvList.wait(passiveTimeout)
 	if err != nil {
 		return nil, err
 	}
-	s.pasvList.Close()
-	s.pasvList = nil
 
 	return s.wrapDataConn(conn)
 }
@@ -573,16 +903,27 @@ func (s *session) connActive() (net.Conn, error) {
 		"remote_ip", s.redactIP(s.remoteIP),
 		"addr", addr,
 	)
-	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	if s.server.activeSource != nil {
+		dialer.LocalAddr = s.server.activeSource
+		dialer.Control = reuseAddrControl
+	}
+	conn, err := dialer.Dial("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
-	s.activeIP = "" // Reset after use
 
 	return s.wrapDataConn(conn)
 }
 
 func (s *session) wrapDataConn(conn net.Conn) (net.Conn, error) {
+	if s.server.socketOptions != nil {
+		if err := s.server.socketOptions.apply(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
 	// Wrap in TLS if protected
 	if s.prot == "P" {
 		if s.server.tlsConfig == nil {
@@ -590,7 +931,7 @@ func (s *session) wrapDataConn(conn net.Conn) (net.Conn, error) {
 			return nil, fmt.Errorf("TLS configuration missing")
 		}
 		// RFC 4217: The FTP server MUST act as the TLS server.
-		tlsConn := tls.Server(conn, s.server.tlsConfig)
+		tlsConn := tls.Server(conn, s.sessionTLSConfig())
 		if err := tlsConn.Handshake(); err != nil {
 			conn.Close()
 			return nil, err
@@ -598,24 +939,42 @@ func (s *session) wrapDataConn(conn net.Conn) (net.Conn, error) {
 		conn = tlsConn
 	}
 
-	// Apply timeouts to data connection
+	s.setDataDeadlines(conn)
+
+	// Track data connection
+	s.server.trackConnection(conn, true)
+	return &trackingConn{Conn: conn, server: s.server}, nil
+}
+
+// setDataDeadlines applies the read and write timeouts to a data
+// connection, at the start of each transfer.
+func (s *session) setDataDeadlines(conn net.Conn) {
 	if s.server.readTimeout > 0 {
 		_ = conn.SetReadDeadline(time.Now().Add(s.server.readTimeout))
 	}
 	if s.server.writeTimeout > 0 {
 		_ = conn.SetWriteDeadline(time.Now().Add(s.server.writeTimeout))
 	}
-
-	// Track data connection
-	s.server.trackConnection(conn, true)
-	return &trackingConn{Conn: conn, server: s.server}, nil
 }
 
 func (s *session) handleABOR(_ string) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	busy := s.busy
+	if busy {
+		// Close data connection to interrupt the background transfer goroutine.
+		if s.dataConn != nil {
+			blockmode.Interrupt(s.dataConn)
+		}
+
+		// Signal the transfer context to cancel.
+		if s.transferCancel != nil {
+			s.transferCancel()
+		}
+	}
+	// Release the lock before replying; reply takes it to write.
+	s.mu.Unlock()
 
-	if !s.busy {
+	if !busy {
 		s.reply(226, "ABOR command successful; no transfer in progress.")
 		return
 	}
@@ -623,16 +982,6 @@ func (s *session) handleABOR(_ string) {
 	// Transfer is in progress.
 	s.server.logger.Info("transfer_abort_requested", "session_id", s.sessionID)
 
-	// Close data connection to interrupt the background transfer goroutine.
-	if s.dataConn != nil {
-		s.dataConn.Close()
-	}
-
-	// Signal the transfer context to cancel.
-	if s.transferCancel != nil {
-		s.transferCancel()
-	}
-
 	// Per RFC 959, the server should send a 426 reply for the original
 	// transfer command, followed by a 226 reply for the ABOR command.
 	// Our asynchronous implementation sends 226 immediately, and the
@@ -648,6 +997,12 @@ func (s *session) replyError(err error) {
 		return
 	}
 	if os.IsPermission(err) {
+		s.audit(AuditEvent{
+			Type:    AuditPermissionDenied,
+			Command: s.command,
+			Path:    s.commandArg,
+			Reason:  err.Error(),
+		})
 		s.reply(550, "Permission denied.")
 		return
 	}
@@ -658,80 +1013,42 @@ func (s *session) replyError(err error) {
 	s.reply(550, "Action failed: "+err.Error())
 }
 
-// reply sends a response to the client.
+// reply sends a response to the client. Messages with several lines,
+// separated by "\n", are sent as a multi-line reply.
 func (s *session) reply(code int, message string) {
+	if strings.ContainsAny(message, "\r\n") {
+		s.send(NewReply(code, message))
+		return
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	fmt.Fprintf(s.writer, "%d %s\r\n", code, message)
+	fmt.Fprintf(s.writer, "%d %s\r\n", code, s.encodeName(s.translate(message)))
 	s.writer.Flush()
+	s.lastReply = code
 }
 
-// logTransfer logs a file transfer in standard xferlog format.
-// Format: current-time transfer-time remote-host file-size filename transfer-type special-action-flag direction access-mode username service-name authentication-method authenticated-user-id completion-status
-func (s *session) logTransfer(cmd, filename string, bytes int64, duration time.Duration) {
-	if s.server.transferLog == nil {
+// logTransfer reports a file transfer to the transfer loggers.
+func (s *session) logTransfer(cmd, filename string, bytes int64, duration time.Duration, complete bool) {
+	if len(s.server.transferLoggers) == 0 {
 		return
 	}
 
-	now := time.Now()
-	transferTime := int64(duration.Seconds())
-	if transferTime == 0 {
-		transferTime = 1
+	rec := TransferRecord{
+		Time:      time.Now(),
+		Duration:  duration,
+		SessionID: s.sessionID,
+		RemoteIP:  s.remoteIP,
+		User:      s.user,
+		Command:   cmd,
+		Path:      filename,
+		Bytes:     bytes,
+		ASCII:     s.transferType == "A",
+		TLS:       s.prot == "P",
+		Anonymous: s.user == "anonymous" || s.user == "ftp",
+		Complete:  complete,
 	}
-
-	// Remote host
-	remoteHost := s.remoteIP
-
-	// Transfer type: a (ascii), b (binary)
-	tType := "b"
-	if s.transferType == "A" {
-		tType = "a"
+	for _, l := range s.server.transferLoggers {
+		l.LogTransfer(rec)
 	}
-
-	// Special action flag: _ (none), C (compressed), U (uncompressed), T (tar)
-	actionFlag := "_"
-
-	// Direction: o (outgoing/download), i (incoming/upload)
-	direction := "o"
-	if cmd == "STOR" || cmd == "APPE" || cmd == "STOU" {
-		direction = "i"
-	}
-
-	// Access mode: a (anonymous), g (guest), r (real user)
-	accessMode := "r"
-	if s.user == "anonymous" || s.user == "ftp" {
-		accessMode = "a"
-	}
-
-	// Authentication method: 0 (none), 1 (rfc931 auth)
-	authMethod := "0"
-
-	// Authenticated user ID: * (not available)
-	authUserID := "*"
-
-	// Completion status: c (complete), i (incomplete)
-	// We only log completed transfers for now
-	completionStatus := "c"
-
-	// Format line
-	// Mon Dec 25 15:04:05 2025 1 127.0.0.1 1024 /file.txt b _ o a anonymous ftp 0 * c
-	line := fmt.Sprintf("%s %d %s %d %s %s %s %s %s %s %s %s %s %s\n",
-		now.Format("Mon Jan 02 15:04:05 2006"), // Manually mimicking ctime format
-		transferTime,
-		remoteHost,
-		bytes,
-		filename,
-		tType,
-		actionFlag,
-		direction,
-		accessMode,
-		s.user,
-		"ftp",
-		authMethod,
-		authUserID,
-		completionStatus,
-	)
-
-	// Write to log (ignore errors)
-	_, _ = s.server.transferLog.Write([]byte(line))
 }
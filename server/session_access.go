@@ -1,25 +1,35 @@
 package server
 
-import "net"
+import (
+	"net"
+	"slices"
+)
 
 func (s *session) handleUSER(user string) error {
+	if s.server.requireTLS && !s.secure() {
+		s.audit(AuditEvent{Type: AuditTLSDowngrade, Command: "USER", Reason: "clear_login"})
+		s.reply(530, "TLS required; use AUTH TLS first.")
+		return nil
+	}
+
+	s.mu.Lock()
 	s.user = user
+	s.mu.Unlock()
 	s.reply(331, "User name okay, need password.")
 	return nil
 }
 
 func (s *session) handlePASS(pass string) error {
+	if s.user == "" {
+		s.reply(503, "Login with USER first.")
+		return nil
+	}
+
 	// Parse remote IP string to net.IP
 	remoteIP := net.ParseIP(s.remoteIP)
-	ctx, err := s.server.driver.Authenticate(s.user, pass, s.host, remoteIP)
+	driverFS, fs, err := s.authenticate(pass, remoteIP)
 	if err != nil {
-		// Security audit: failed authentication
-		s.server.logger.Warn("authentication_failed",
-			"session_id", s.sessionID,
-			"remote_ip", s.redactIP(s.remoteIP),
-			"user", s.user,
-			"reason", err.Error(),
-		)
+		s.audit(AuditEvent{Type: AuditLoginFailure, Reason: err.Error()})
 		// Metrics collection
 		if s.server.metricsCollector != nil {
 			s.server.metricsCollector.RecordAuthentication(false, s.user)
@@ -27,18 +37,21 @@ func (s *session) handlePASS(pass string) error {
 		s.reply(530, "Login incorrect.")
 		return nil
 	}
-	s.fs = ctx
+	s.mu.Lock()
+	s.fs = fs
+	s.driverFS = driverFS
+	s.caps = detectCaps(driverFS)
+	if algos := s.caps.hashAlgos; len(algos) > 0 && !slices.Contains(algos, s.selectedHash) {
+		s.selectedHash = algos[0]
+	}
 	s.isLoggedIn = true
-	// Security audit: successful authentication
-	s.server.logger.Info("authentication_success",
-		"session_id", s.sessionID,
-		"remote_ip", s.redactIP(s.remoteIP),
-		"user", s.user,
-	)
+	s.startBandwidth()
+	s.mu.Unlock()
+	s.audit(AuditEvent{Type: AuditLoginSuccess})
 	// Metrics collection
 	if s.server.metricsCollector != nil {
 		s.server.metricsCollector.RecordAuthentication(true, s.user)
 	}
-	s.reply(230, "User logged in, proceed.")
+	s.reply(230, s.loginMessage())
 	return nil
 }
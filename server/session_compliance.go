@@ -1,10 +1,14 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // handleACCT handles the ACCT command.
@@ -14,15 +18,22 @@ func (s *session) handleACCT(_ string) {
 }
 
 // handleMODE handles the MODE command.
-// RFC 1123 requires Stream mode support.
+// RFC 1123 requires Stream mode support. Block mode is accepted with
+// WithDataConnectionReuse.
 func (s *session) handleMODE(arg string) {
 	mode := strings.ToUpper(strings.TrimSpace(arg))
 	switch mode {
 	case "S":
-		// Stream mode (default and only supported mode)
+		s.blockMode = false
+		s.closeKeptData()
 		s.reply(200, "Mode set to Stream.")
 	case "B":
-		s.reply(504, "Block mode not implemented.")
+		if !s.server.dataReuse {
+			s.reply(504, "Block mode not implemented.")
+			return
+		}
+		s.blockMode = true
+		s.reply(200, "Mode set to Block.")
 	case "C":
 		s.reply(504, "Compressed mode not implemented.")
 	default:
@@ -63,25 +74,36 @@ func (s *session) handleSTAT(arg string) {
 		return
 	}
 
-	// Return connection status using multi-line response
-	fmt.Fprintf(s.writer, "211-Status:\r\n")
+	// Return connection status using multi-line response. STAT may arrive
+	// during a transfer, whose goroutine also replies, so hold the lock.
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	r := NewReply(211, "Status:")
 	if s.isLoggedIn {
-		fmt.Fprintf(s.writer, " Logged in as: %s\r\n", s.user)
+		r.Indentf("Logged in as: %s", s.user)
 	} else {
-		fmt.Fprintf(s.writer, " Not logged in\r\n")
+		r.Indent("Not logged in")
 	}
 
-	fmt.Fprintf(s.writer, " TYPE: ASCII, FORM: Nonprint; STRUcture: File; transfer MODE: Stream\r\n")
+	r.Indent("TYPE: ASCII, FORM: Nonprint; STRUcture: File; transfer MODE: Stream")
 
-	if s.pasvList != nil {
-		fmt.Fprintf(s.writer, " Passive mode enabled\r\n")
+	if s.pasvList != nil && s.pasvList.connected() {
+		r.Indent("Passive mode enabled, data connection open")
+	} else if s.pasvList != nil {
+		r.Indent("Passive mode enabled")
 	} else if s.activeIP != "" {
-		fmt.Fprintf(s.writer, " Active mode: %s:%d\r\n", s.activeIP, s.activePort)
+		r.Indentf("Active mode: %s:%d", s.activeIP, s.activePort)
+	}
+
+	// RFC 959: STAT during a transfer reports its progress
+	if s.transfer != nil {
+		t := s.transfer.info()
+		r.Indentf("%s %s: %d bytes transferred in %s (%.1f KB/s)",
+			t.Command, t.Path, t.Bytes, time.Since(t.Started).Round(time.Second), t.Rate()/1024)
 	}
 
-	fmt.Fprintf(s.writer, "211 End of status\r\n")
-	s.writer.Flush()
+	s.sendLocked(r.Line("End of status"))
 }
 
 // handleHELP handles the HELP command.
@@ -94,19 +116,25 @@ func (s *session) handleHELP(arg string) {
 	}
 
 	// List all supported commands using multi-line response
-	fmt.Fprintf(s.writer, "214-The following commands are supported:\r\n")
-	fmt.Fprintf(s.writer, " USER PASS QUIT ACCT\r\n")
-	fmt.Fprintf(s.writer, " CWD XCWD CDUP XCUP PWD XPWD MKD XMKD RMD XRMD\r\n")
-	fmt.Fprintf(s.writer, " LIST NLST MLSD MLST\r\n")
-	fmt.Fprintf(s.writer, " RETR STOR APPE STOU DELE\r\n")
-	fmt.Fprintf(s.writer, " RNFR RNTO REST\r\n")
-	fmt.Fprintf(s.writer, " TYPE MODE STRU PORT PASV EPSV EPRT\r\n")
-	fmt.Fprintf(s.writer, " SIZE MDTM FEAT OPTS\r\n")
-	fmt.Fprintf(s.writer, " AUTH PROT PBSZ\r\n")
-	fmt.Fprintf(s.writer, " SYST STAT HELP NOOP SITE\r\n")
-	fmt.Fprintf(s.writer, " HOST HASH\r\n")
-	fmt.Fprintf(s.writer, "214 End of help\r\n")
-	s.writer.Flush()
+	s.send(NewReply(214, "The following commands are supported:").
+		Indent("USER PASS QUIT ACCT").
+		Indent("CWD XCWD CDUP XCUP PWD XPWD MKD XMKD RMD XRMD").
+		Indent("LIST NLST MLSD MLST").
+		Indent("RETR STOR APPE STOU DELE").
+		Indent("RNFR RNTO REST RANG").
+		Indent("TYPE MODE STRU PORT PASV EPSV EPRT").
+		Indent("SIZE MDTM FEAT OPTS").
+		Indent("AUTH PROT PBSZ").
+		Indent("SYST STAT HELP NOOP SITE").
+		Indent("HOST HASH LANG MFMT MFCT MFF").
+		Line("End of help"))
+}
+
+// siteBuiltins are the SITE commands of the server, which custom commands
+// cannot replace.
+var siteBuiltins = map[string]bool{
+	"HELP": true, "CHMOD": true, "CHOWN": true, "CHGRP": true,
+	"UTIME": true, "FREESPACE": true, "SYMLINK": true,
 }
 
 // handleSITE handles the SITE command.
@@ -122,9 +150,47 @@ func (s *session) handleSITE(arg string) {
 
 	switch cmd {
 	case "HELP":
-		s.reply(214, "Available SITE commands: HELP, CHMOD")
+		// Only list the commands the driver supports
+		commands := []string{"HELP"}
+		if s.caps.chmod != nil {
+			commands = append(commands, "CHMOD")
+		}
+		if s.caps.chown != nil && s.hasPrivilege(PrivilegeChown) {
+			commands = append(commands, "CHOWN", "CHGRP")
+		}
+		if s.caps.setTime != nil && s.hasPrivilege(PrivilegeUtime) {
+			commands = append(commands, "UTIME")
+		}
+		if s.caps.space != nil {
+			commands = append(commands, "FREESPACE")
+		}
+		if s.symlinker() != nil {
+			commands = append(commands, "SYMLINK")
+		}
+		commands = append(commands, slices.Sorted(maps.Keys(s.server.siteCommands))...)
+		s.reply(214, "Available SITE commands: "+strings.Join(commands, ", "))
+	case "SYMLINK":
+		// Syntax: SITE SYMLINK <target> <link>
+		_, args, _ := strings.Cut(strings.TrimLeft(arg, " "), " ")
+		s.handleSiteSymlink(args)
+	case "CHOWN", "CHGRP":
+		// Syntax: SITE CHOWN <owner>[:<group>] <path>, SITE CHGRP <group> <path>
+		_, args, _ := strings.Cut(strings.TrimLeft(arg, " "), " ")
+		s.handleSiteChown(cmd, strings.TrimLeft(args, " "))
+	case "UTIME":
+		// Syntax: SITE UTIME <time> <path> or SITE UTIME <path> <atime> <mtime> <ctime> UTC
+		_, args, _ := strings.Cut(strings.TrimLeft(arg, " "), " ")
+		s.handleSiteUtime(strings.TrimLeft(args, " "))
+	case "FREESPACE":
+		// Syntax: SITE FREESPACE [<path>], an alias for AVBL
+		_, path, _ := strings.Cut(strings.TrimLeft(arg, " "), " ")
+		s.handleAVBL(strings.TrimLeft(path, " "))
 	case "CHMOD":
 		// Syntax: SITE CHMOD <mode> <file>
+		if s.caps.chmod == nil {
+			s.reply(502, "Changing permissions not supported.")
+			return
+		}
 		if len(parts) < 3 {
 			s.reply(501, "Syntax error in parameters or arguments.")
 			return
@@ -145,7 +211,7 @@ func (s *session) handleSITE(arg string) {
 			return
 		}
 
-		if err := s.fs.Chmod(path, os.FileMode(mode)); err != nil {
+		if err := s.caps.chmod(s.context(), path, os.FileMode(mode)); err != nil {
 			s.replyError(err)
 			return
 		}
@@ -163,6 +229,202 @@ func (s *session) handleSITE(arg string) {
 		s.reply(200, "SITE CHMOD command successful.")
 
 	default:
+		fn, ok := s.server.siteCommands[cmd]
+		if !ok {
+			s.reply(502, "SITE command not implemented.")
+			return
+		}
+		if !s.isLoggedIn {
+			s.reply(530, "Not logged in.")
+			return
+		}
+		_, args, _ := strings.Cut(strings.TrimLeft(arg, " "), " ")
+		r := fn(s.info(), strings.TrimLeft(args, " "))
+		if r == nil {
+			r = NewReply(200, "Command okay.")
+		}
+		s.send(r)
+	}
+}
+
+// hasPrivilege reports whether the driver's settings grant p to the
+// logged-in user.
+func (s *session) hasPrivilege(p Privileges) bool {
+	settings := s.fs.GetSettings()
+	return settings != nil && settings.Privileges&p != 0
+}
+
+// handleSiteChown changes the owner or group of a file for SITE CHOWN and
+// SITE CHGRP. CHOWN takes "owner:group" to change both.
+func (s *session) handleSiteChown(cmd, arg string) {
+	if !s.isLoggedIn {
+		s.reply(530, "Not logged in.")
+		return
+	}
+	if s.caps.chown == nil {
+		s.reply(502, "Changing ownership not supported.")
+		return
+	}
+	if !s.hasPrivilege(PrivilegeChown) {
+		s.reply(550, "Permission denied.")
+		return
+	}
+
+	id, path, _ := strings.Cut(arg, " ")
+	if id == "" || path == "" {
+		s.reply(501, "Syntax error in parameters or arguments.")
+		return
+	}
+	owner, group := id, ""
+	if cmd == "CHGRP" {
+		owner, group = "", id
+	} else if o, g, ok := strings.Cut(id, ":"); ok {
+		owner, group = o, g
+	}
+
+	if err := s.caps.chown(s.context(), path, owner, group); err != nil {
+		if errors.Is(err, os.ErrInvalid) {
+			s.reply(550, "Unknown user or group.")
+			return
+		}
+		s.replyError(err)
+		return
+	}
+
+	// Security audit: ownership changed
+	s.server.logger.Info("ownership_changed",
+		"session_id", s.sessionID,
+		"remote_ip", s.redactIP(s.remoteIP),
+		"user", s.user,
+		"host", s.host,
+		"path", s.redactPath(path),
+		"owner", owner,
+		"group", group,
+	)
+
+	s.reply(200, "SITE "+cmd+" command successful.")
+}
+
+// handleSiteUtime sets the modification time of a file for SITE UTIME, in
+// either of the forms ProFTPD accepts: "<YYYYMMDDhhmm[ss]> <path>", or
+// "<path> <atime> <mtime> <ctime> UTC", whose access and creation times are
+// ignored. Times are in UTC.
+func (s *session) handleSiteUtime(arg string) {
+	if !s.isLoggedIn {
+		s.reply(530, "Not logged in.")
+		return
+	}
+	if s.caps.setTime == nil {
+		s.reply(502, "Setting modification times not supported.")
+		return
+	}
+	if !s.hasPrivilege(PrivilegeUtime) {
+		s.reply(550, "Permission denied.")
+		return
+	}
+
+	path, mtime, ok := parseSiteUtime(arg)
+	if !ok {
+		s.reply(501, "Syntax error in parameters or arguments.")
+		return
+	}
+
+	if err := s.caps.setTime(s.context(), path, mtime); err != nil {
+		s.replyError(err)
+		return
+	}
+
+	// Security audit: modification time changed
+	s.server.logger.Info("modification_time_changed",
+		"session_id", s.sessionID,
+		"remote_ip", s.redactIP(s.remoteIP),
+		"user", s.user,
+		"host", s.host,
+		"path", s.redactPath(path),
+		"time", mtime.Format(factTimeLayout),
+	)
+
+	s.reply(200, "SITE UTIME command successful.")
+}
+
+// parseSiteUtime parses the arguments of SITE UTIME, returning the path and
+// the modification time.
+func parseSiteUtime(arg string) (path string, mtime time.Time, ok bool) {
+	parseTime := func(v string) (time.Time, bool) {
+		layout := factTimeLayout
+		if len(v) == len("200601021504") {
+			layout = "200601021504"
+		}
+		t, err := time.Parse(layout, v)
+		return t, err == nil
+	}
+
+	// <path> <atime> <mtime> <ctime> UTC
+	if rest, found := strings.CutSuffix(arg, " UTC"); found {
+		fields := strings.Fields(rest)
+		if n := len(fields); n >= 4 {
+			_, okA := parseTime(fields[n-3])
+			m, okM := parseTime(fields[n-2])
+			_, okC := parseTime(fields[n-1])
+			if okA && okM && okC {
+				for range 3 {
+					rest = strings.TrimRight(rest[:strings.LastIndex(rest, " ")], " ")
+				}
+				return rest, m, rest != ""
+			}
+		}
+	}
+
+	// <time> <path>
+	v, path, _ := strings.Cut(arg, " ")
+	if mtime, ok = parseTime(v); !ok || path == "" {
+		return "", time.Time{}, false
+	}
+	return path, mtime, true
+}
+
+// symlinker returns the driver's Symlinker if SITE SYMLINK is enabled.
+func (s *session) symlinker() Symlinker {
+	if !s.server.siteSymlink {
+		return nil
+	}
+	sl, _ := s.driverFS.(Symlinker)
+	return sl
+}
+
+// handleSiteSymlink creates a symbolic link for SITE SYMLINK. Paths with
+// spaces must be quoted.
+func (s *session) handleSiteSymlink(arg string) {
+	sl := s.symlinker()
+	if sl == nil {
 		s.reply(502, "SITE command not implemented.")
+		return
 	}
+	if !s.isLoggedIn {
+		s.reply(530, "Not logged in.")
+		return
+	}
+
+	args, ok := splitQuotedArgs(arg)
+	if !ok || len(args) != 2 {
+		s.reply(501, "Syntax error in parameters or arguments.")
+		return
+	}
+	target, link := args[0], args[1]
+
+	if err := sl.Symlink(target, link); err != nil {
+		s.replyError(err)
+		return
+	}
+
+	s.server.logger.Info("symlink_created",
+		"session_id", s.sessionID,
+		"remote_ip", s.redactIP(s.remoteIP),
+		"user", s.user,
+		"host", s.host,
+		"path", s.redactPath(link),
+		"target", s.redactPath(target),
+	)
+
+	s.reply(200, "SITE SYMLINK command successful.")
 }
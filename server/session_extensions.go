@@ -1,7 +1,14 @@
 package server
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -11,33 +18,471 @@ func (s *session) handleHOST(arg string) {
 		s.reply(503, "Cannot change host after login.")
 		return
 	}
-	s.host = arg
+	if len(s.server.virtualHosts) > 0 {
+		vh, ok := lookupHost(s.server.virtualHosts, arg)
+		if !ok {
+			s.reply(504, "Unknown host.")
+			return
+		}
+		s.setHost(arg)
+		if banner := s.banner(); banner != "" {
+			s.reply(220, banner)
+			return
+		}
+		if vh.WelcomeMessage != "" {
+			s.reply(220, vh.WelcomeMessage)
+			return
+		}
+	}
+	s.setHost(arg)
+	if banner := s.banner(); banner != "" {
+		s.reply(220, banner)
+		return
+	}
 	s.reply(220, "Host accepted.")
 }
 
+// setHost records the host selected with HOST.
+func (s *session) setHost(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.host = host
+}
+
 func (s *session) handleHASH(arg string) {
 	if !s.isLoggedIn {
 		s.reply(530, "Not logged in.")
 		return
 	}
 
-	path := arg
-	// Use selected hash algorithm
-	hash, err := s.fs.GetHash(path, s.selectedHash)
+	if s.caps.hash == nil {
+		s.reply(502, "Checksums not supported.")
+		return
+	}
+
+	path, start, end, ranged, ok := parseHashArgs(arg)
+	if !ok {
+		s.reply(501, "Syntax error: HASH <path> [<start>-<end>].")
+		return
+	}
+	// An unquoted name may itself end in a range ("backup 1-2")
+	if ranged {
+		if _, err := s.stat(arg); err == nil {
+			path, ranged = arg, false
+		}
+	}
+
+	algo := s.selectedHash
+	if !ranged {
+		hash, err := s.caps.hash(s.context(), path, algo)
+		if err != nil {
+			s.replyError(err)
+			return
+		}
+		s.reply(213, fmt.Sprintf("%s %s %s", algo, hash, path))
+		return
+	}
+
+	// The end is inclusive, and defaults to the end of the file
+	limit := int64(-1)
+	if end >= 0 {
+		limit = end + 1
+	}
+	hash, n, err := s.hashRange(path, algo, start, limit)
 	if err != nil {
 		s.replyError(err)
 		return
 	}
+	if n == 0 && start > 0 {
+		s.reply(501, "Invalid byte range.")
+		return
+	}
+	s.reply(213, fmt.Sprintf("%s %d-%d %s %s", algo, start, start+max(n-1, 0), hash, path))
+}
+
+// parseHashArgs splits the argument of HASH into the path and the optional
+// byte range of the draft's "HASH <path> <start>-<end>" form, where end is
+// inclusive and may be left out for the end of the file (-1).
+func parseHashArgs(arg string) (path string, start, end int64, ranged, ok bool) {
+	i := strings.LastIndex(arg, " ")
+	if i < 0 {
+		return arg, 0, -1, false, true
+	}
+	from, to, found := strings.Cut(arg[i+1:], "-")
+	if !found || from == "" || strings.Trim(from+to, "0123456789") != "" {
+		return arg, 0, -1, false, true
+	}
+
+	start, err := strconv.ParseInt(from, 10, 64)
+	if err != nil {
+		return "", 0, 0, false, false
+	}
+	end = -1
+	if to != "" {
+		if end, err = strconv.ParseInt(to, 10, 64); err != nil || end < start {
+			return "", 0, 0, false, false
+		}
+	}
+	return strings.TrimRight(arg[:i], " "), start, end, true, true
+}
+
+// The non-standard checksum commands predate HASH and are still used by
+// clients such as FlashFXP. Each one is tied to a single algorithm.
+func (s *session) handleXCRC(arg string)    { s.handleLegacyHash("XCRC", "CRC32", arg) }
+func (s *session) handleXMD5(arg string)    { s.handleLegacyHash("XMD5", "MD5", arg) }
+func (s *session) handleXSHA1(arg string)   { s.handleLegacyHash("XSHA1", "SHA-1", arg) }
+func (s *session) handleXSHA256(arg string) { s.handleLegacyHash("XSHA256", "SHA-256", arg) }
+
+// handleLegacyHash replies with the checksum of a file, or of the byte range
+// [start, end) if positions are given: "XCRC <path> [<start> [<end>]]".
+// The path may be quoted. The reply is "250 <checksum>".
+func (s *session) handleLegacyHash(cmd, algo, arg string) {
+	if !s.isLoggedIn {
+		s.reply(530, "Not logged in.")
+		return
+	}
+
+	if s.caps.hash == nil {
+		s.reply(502, "Checksums not supported.")
+		return
+	}
+
+	path, start, end, ok := parseChecksumArgs(arg)
+	if !ok {
+		s.reply(501, fmt.Sprintf("Syntax error: %s <path> [<start> [<end>]].", cmd))
+		return
+	}
+	// An unquoted name may itself end in numbers ("report 2024")
+	if path != arg && !strings.HasPrefix(arg, `"`) {
+		if _, err := s.stat(arg); err == nil {
+			path, start, end = arg, 0, -1
+		}
+	}
+
+	var sum string
+	var err error
+	if start == 0 && end < 0 {
+		sum, err = s.caps.hash(s.context(), path, algo)
+	} else {
+		sum, _, err = s.hashRange(path, algo, start, end)
+	}
+	if err != nil {
+		s.replyError(err)
+		return
+	}
+
+	// CRCs are conventionally reported in upper case
+	if algo == "CRC32" {
+		sum = strings.ToUpper(sum)
+	}
+	s.reply(250, sum)
+}
+
+// parseChecksumArgs splits the argument of a legacy checksum command into the
+// path and the optional start and end positions. end is -1 if not given.
+func parseChecksumArgs(arg string) (path string, start, end int64, ok bool) {
+	var rest string
+	if quoted, ok := strings.CutPrefix(arg, `"`); ok {
+		var found bool
+		path, rest, found = strings.Cut(quoted, `"`)
+		if !found {
+			return "", 0, 0, false
+		}
+	} else {
+		// Up to two trailing numbers are positions
+		path = strings.TrimRight(arg, " ")
+		for range 2 {
+			i := strings.LastIndex(path, " ")
+			if i < 0 {
+				break
+			}
+			if _, err := strconv.ParseInt(path[i+1:], 10, 64); err != nil {
+				break
+			}
+			path = strings.TrimRight(path[:i], " ")
+		}
+		rest = arg[len(path):]
+	}
+	if path == "" {
+		return "", 0, 0, false
+	}
+
+	start, end = 0, -1
+	positions := strings.Fields(rest)
+	if len(positions) > 2 {
+		return "", 0, 0, false
+	}
+	var err error
+	if len(positions) > 0 {
+		if start, err = strconv.ParseInt(positions[0], 10, 64); err != nil || start < 0 {
+			return "", 0, 0, false
+		}
+	}
+	if len(positions) > 1 {
+		if end, err = strconv.ParseInt(positions[1], 10, 64); err != nil || end < start {
+			return "", 0, 0, false
+		}
+	}
+	return path, start, end, true
+}
+
+// hashRange computes the checksum of the bytes [start, end) of a file, or
+// from start to the end of the file if end is negative, and returns the
+// number of bytes hashed.
+func (s *session) hashRange(path, algo string, start, end int64) (sum string, n int64, err error) {
+	h, err := newHash(algo)
+	if err != nil {
+		return "", 0, err
+	}
+
+	file, err := s.fs.OpenFile(s.context(), path, os.O_RDONLY)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err = seeker.Seek(start, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, file, start)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	var r io.Reader = file
+	if end >= 0 {
+		r = io.LimitReader(file, end-start)
+	}
+	if n, err = io.Copy(h, r); err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// handleCOMB concatenates previously uploaded parts into a target file, so
+// clients can upload a large file as parallel segments:
+// "COMB <target> <part> [<part> ...]". Names containing spaces must be
+// quoted. The parts are deleted once the target has been written.
+func (s *session) handleCOMB(arg string) {
+	if !s.isLoggedIn {
+		s.reply(530, "Not logged in.")
+		return
+	}
+
+	args, ok := splitQuotedArgs(arg)
+	if !ok || len(args) < 2 {
+		s.reply(501, "Syntax error: COMB <target> <part> [<part> ...].")
+		return
+	}
+	target, parts := args[0], args[1:]
+	if slices.Contains(parts, target) {
+		s.reply(501, "Target cannot be one of the parts.")
+		return
+	}
+
+	policy := s.uploadPolicy()
+	if !s.checkUploadName(policy, target) {
+		return
+	}
+	version, ok := s.checkOverwrite(policy, target, false)
+	if !ok {
+		return
+	}
+
+	if err := s.combineFiles(policy, target, parts, version); err != nil {
+		if errors.Is(err, errUploadTooLarge) {
+			s.reply(552, "Exceeded storage allocation.")
+			return
+		}
+		s.replyUploadError(policy, err)
+		return
+	}
+
+	for _, part := range parts {
+		if err := s.fs.DeleteFile(s.context(), part); err != nil {
+			s.server.logger.Warn("comb_part_delete_failed",
+				"session_id", s.sessionID,
+				"path", s.redactPath(part),
+				"error", err,
+			)
+		}
+	}
+
+	s.server.logger.Info("files_combined",
+		"session_id", s.sessionID,
+		"remote_ip", s.redactIP(s.remoteIP),
+		"user", s.user,
+		"host", s.host,
+		"path", s.redactPath(target),
+		"parts", len(parts),
+	)
+	s.reply(250, "COMB successful.")
+}
+
+// combineFiles writes target as the concatenation of parts, with the
+// driver's FileCombiner if available. Otherwise the parts are copied through
+// OpenFile, into a temporary file when atomic uploads are enabled or an
+// existing target must be versioned. The parts are checked, and their total
+// size compared with the upload policy, before target is touched.
+func (s *session) combineFiles(policy *UploadPolicy, target string, parts []string, version bool) error {
+	var total int64
+	for _, part := range parts {
+		info, err := s.fs.GetFileInfo(s.context(), part)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", part)
+		}
+		total += info.Size()
+	}
+	if policy != nil && policy.MaxFileSize > 0 && total > policy.MaxFileSize {
+		return errUploadTooLarge
+	}
+
+	useTemp := s.server.atomicUploads || version
+	if c, ok := s.driverFS.(FileCombiner); ok {
+		if !version {
+			return c.CombineFiles(target, parts)
+		}
+		tmpPath := s.uploadTempPath(target)
+		if err := c.CombineFiles(tmpPath, parts); err != nil {
+			s.discardUpload(true, tmpPath)
+			return err
+		}
+		return s.publishVersioned(tmpPath, target)
+	}
+
+	dest := target
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if useTemp {
+		dest = s.uploadTempPath(target)
+	} else if policy.exclusive() {
+		flags |= os.O_EXCL
+	}
+
+	file, err := s.fs.OpenFile(s.context(), dest, flags)
+	if err != nil {
+		return err
+	}
+	err = s.copyParts(file, parts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		s.discardUpload(useTemp, dest)
+		return err
+	}
+
+	if version {
+		return s.publishVersioned(dest, target)
+	}
+	if useTemp {
+		return s.publishUpload(dest, target)
+	}
+	return nil
+}
+
+// copyParts appends the content of each part to w, in order.
+func (s *session) copyParts(w io.Writer, parts []string) error {
+	for _, part := range parts {
+		file, err := s.fs.OpenFile(s.context(), part, os.O_RDONLY)
+		if err != nil {
+			return err
+		}
+		_, err = s.server.copyWithPooledBuffer(w, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitQuotedArgs splits a command argument into space-separated words.
+// Words containing spaces must be enclosed in double quotes.
+func splitQuotedArgs(arg string) ([]string, bool) {
+	var words []string
+	for {
+		arg = strings.TrimLeft(arg, " ")
+		if arg == "" {
+			return words, true
+		}
+
+		var word string
+		if quoted, ok := strings.CutPrefix(arg, `"`); ok {
+			var found bool
+			word, arg, found = strings.Cut(quoted, `"`)
+			if !found || word == "" || (arg != "" && arg[0] != ' ') {
+				return nil, false
+			}
+		} else {
+			word, arg, _ = strings.Cut(arg, " ")
+		}
+		words = append(words, word)
+	}
+}
+
+// handleAVBL reports the bytes available for uploads in the given directory,
+// or in the current one: "AVBL [<path>]" (draft-peterson-streamlined-ftp-command-extensions).
+// It needs a driver that implements SpaceReporter.
+func (s *session) handleAVBL(arg string) {
+	if !s.isLoggedIn {
+		s.reply(530, "Not logged in.")
+		return
+	}
 
-	s.reply(213, fmt.Sprintf("%s %s %s", s.selectedHash, hash, path))
+	if s.caps.space == nil {
+		s.reply(502, "Free space reporting not supported.")
+		return
+	}
+
+	avail, err := s.caps.space.GetAvailableSpace(arg)
+	if errors.Is(err, errors.ErrUnsupported) {
+		s.reply(502, "Free space reporting not supported.")
+		return
+	}
+	if err != nil {
+		s.replyError(err)
+		return
+	}
+	s.reply(213, strconv.FormatInt(avail, 10))
 }
 
+// factTimeLayout is the format of the times of MFMT, MFCT and MFF:
+// YYYYMMDDHHMMSS, in UTC.
+const factTimeLayout = "20060102150405"
+
+// handleMFMT handles the MFMT command (draft-somers-ftp-mfxx):
+// "MFMT <time> <path>" sets the modification time of a file.
 func (s *session) handleMFMT(arg string) {
+	s.handleTimeFact("Modify", s.caps.setTime, arg)
+}
+
+// handleMFCT handles the MFCT command (draft-somers-ftp-mfxx):
+// "MFCT <time> <path>" sets the creation time of a file.
+func (s *session) handleMFCT(arg string) {
+	s.handleTimeFact("Create", s.caps.setCtime, arg)
+}
+
+// handleTimeFact sets the Modify or Create fact of a file with set, and
+// replies "213 <fact>=<time>; <path>".
+func (s *session) handleTimeFact(fact string, set func(ctx context.Context, path string, t time.Time) error, arg string) {
 	if !s.isLoggedIn {
 		s.reply(530, "Not logged in.")
 		return
 	}
 
+	if set == nil {
+		if fact == "Create" {
+			s.reply(502, "Setting creation times not supported.")
+		} else {
+			s.reply(502, "Setting modification times not supported.")
+		}
+		return
+	}
+
 	parts := strings.SplitN(arg, " ", 2)
 	if len(parts) != 2 {
 		s.reply(501, "Syntax error in parameters or arguments.")
@@ -47,21 +492,23 @@ func (s *session) handleMFMT(arg string) {
 	timeStr := parts[0]
 	path := parts[1]
 
-	// Format: YYYYMMDDHHMMSS
-	// Go layout: 20060102150405
-	t, err := time.Parse("20060102150405", timeStr)
+	t, err := time.Parse(factTimeLayout, timeStr)
 	if err != nil {
 		s.reply(501, "Invalid time format.")
 		return
 	}
 
-	if err := s.fs.SetTime(path, t); err != nil {
+	if err := set(s.context(), path, t); err != nil {
 		s.replyError(err)
 		return
 	}
 
-	// Security audit: modification time changed
-	s.server.logger.Info("modification_time_changed",
+	// Security audit: modification or creation time changed
+	event := "modification_time_changed"
+	if fact == "Create" {
+		event = "creation_time_changed"
+	}
+	s.server.logger.Info(event,
 		"session_id", s.sessionID,
 		"remote_ip", s.redactIP(s.remoteIP),
 		"user", s.user,
@@ -71,5 +518,167 @@ func (s *session) handleMFMT(arg string) {
 	)
 
 	// Response format: "Modify=YYYYMMDDHHMMSS; /path"
-	s.reply(213, fmt.Sprintf("Modify=%s; %s", timeStr, path))
+	s.reply(213, fmt.Sprintf("%s=%s; %s", fact, timeStr, path))
+}
+
+// mffFacts returns the facts MFF can change, in the FEAT format
+// ("Modify;UNIX.mode;"), or "" if the driver supports none of them. The
+// ownership facts are only listed for users with PrivilegeChown. Before
+// login, every fact the server can change is listed.
+func (s *session) mffFacts() string {
+	chown := s.isLoggedIn && s.caps.chown != nil && s.hasPrivilege(PrivilegeChown)
+	var facts strings.Builder
+	for _, f := range []struct {
+		name string
+		ok   bool
+	}{
+		{"Modify", s.caps.setTime != nil},
+		{"Create", s.caps.setCtime != nil},
+		{"UNIX.mode", s.caps.chmod != nil},
+		{"UNIX.owner", chown},
+		{"UNIX.group", chown},
+	} {
+		if !s.isLoggedIn || f.ok {
+			facts.WriteString(f.name + ";")
+		}
+	}
+	return facts.String()
+}
+
+// handleMFF handles the MFF command (draft-somers-ftp-mfxx):
+// "MFF Modify=20240101000000;UNIX.mode=0644; <path>" changes several facts
+// of a file. Every fact is checked before any is changed: an unknown or
+// unsupported fact gets 504, an invalid value 501 and an ownership fact
+// without PrivilegeChown 550. The reply lists the
+// facts changed: "213 Modify=20240101000000;UNIX.mode=0644; <path>".
+func (s *session) handleMFF(arg string) {
+	if !s.isLoggedIn {
+		s.reply(530, "Not logged in.")
+		return
+	}
+
+	facts, path, _ := strings.Cut(arg, " ")
+	if facts == "" || path == "" {
+		s.reply(501, "Syntax error in parameters or arguments.")
+		return
+	}
+
+	type change struct {
+		fact, value string
+		apply       func(ctx context.Context) error
+	}
+	var changes []change
+	for f := range strings.SplitSeq(strings.TrimSuffix(facts, ";"), ";") {
+		name, value, ok := strings.Cut(f, "=")
+		if !ok || value == "" {
+			s.reply(501, "Syntax error in facts.")
+			return
+		}
+
+		c := change{value: value}
+		switch strings.ToLower(name) {
+		case "modify", "create":
+			set := s.caps.setTime
+			c.fact = "Modify"
+			if strings.EqualFold(name, "create") {
+				set, c.fact = s.caps.setCtime, "Create"
+			}
+			if set == nil {
+				s.reply(504, fmt.Sprintf("Fact %s not supported.", c.fact))
+				return
+			}
+			t, err := time.Parse(factTimeLayout, value)
+			if err != nil {
+				s.reply(501, "Invalid time format.")
+				return
+			}
+			c.apply = func(ctx context.Context) error { return set(ctx, path, t) }
+		case "unix.mode":
+			c.fact = "UNIX.mode"
+			if s.caps.chmod == nil {
+				s.reply(504, "Fact UNIX.mode not supported.")
+				return
+			}
+			mode, err := strconv.ParseUint(value, 8, 32)
+			if err != nil || mode > 0777 {
+				s.reply(501, "Invalid mode.")
+				return
+			}
+			c.apply = func(ctx context.Context) error { return s.caps.chmod(ctx, path, os.FileMode(mode)) }
+		case "unix.owner", "unix.group":
+			owner, group := value, ""
+			c.fact = "UNIX.owner"
+			if strings.EqualFold(name, "unix.group") {
+				owner, group, c.fact = "", value, "UNIX.group"
+			}
+			if s.caps.chown == nil {
+				s.reply(504, fmt.Sprintf("Fact %s not supported.", c.fact))
+				return
+			}
+			if !s.hasPrivilege(PrivilegeChown) {
+				s.reply(550, "Permission denied.")
+				return
+			}
+			c.apply = func(ctx context.Context) error { return s.caps.chown(ctx, path, owner, group) }
+		default:
+			s.reply(504, fmt.Sprintf("Fact %s not supported.", name))
+			return
+		}
+		changes = append(changes, c)
+	}
+
+	var changed strings.Builder
+	for _, c := range changes {
+		if err := c.apply(s.context()); err != nil {
+			s.replyError(err)
+			return
+		}
+		changed.WriteString(c.fact + "=" + c.value + ";")
+	}
+
+	// Security audit: facts changed
+	s.server.logger.Info("facts_changed",
+		"session_id", s.sessionID,
+		"remote_ip", s.redactIP(s.remoteIP),
+		"user", s.user,
+		"host", s.host,
+		"path", s.redactPath(path),
+		"facts", changed.String(),
+	)
+
+	s.reply(213, changed.String()+" "+path)
+}
+
+// handleRANG handles the RANG command (draft-bryan-ftp-range).
+// It sets an inclusive byte range for the next RETR. "RANG 1 0" resets it.
+func (s *session) handleRANG(arg string) {
+	fields := strings.Fields(arg)
+	if len(fields) != 2 {
+		s.reply(501, "Syntax error: RANG <start> <end>.")
+		return
+	}
+
+	start, err1 := strconv.ParseInt(fields[0], 10, 64)
+	end, err2 := strconv.ParseInt(fields[1], 10, 64)
+	if err1 != nil || err2 != nil || start < 0 || end < 0 {
+		s.reply(501, "Invalid byte range.")
+		return
+	}
+
+	if start == 1 && end == 0 {
+		s.restartOffset = 0
+		s.hasRange = false
+		s.reply(350, "Byte range reset.")
+		return
+	}
+
+	if end < start {
+		s.reply(501, "Invalid byte range.")
+		return
+	}
+
+	s.restartOffset = start
+	s.rangeEnd = end
+	s.hasRange = true
+	s.reply(350, fmt.Sprintf("Restarting at %d. Ending at %d.", start, end))
 }
@@ -1,9 +1,14 @@
 package server
 
 import (
+	"bufio"
+	"cmp"
 	"fmt"
 	"io"
+	"iter"
 	"os"
+	"path"
+	"slices"
 	"strings"
 )
 
@@ -12,12 +17,22 @@ func (s *session) handlePWD(_ string) {
 		s.reply(530, "Please login with USER and PASS.")
 		return
 	}
-	cwd, err := s.fs.GetWd()
+	cwd, err := s.fs.GetWd(s.context())
 	if err != nil {
 		s.replyError(err)
 		return
 	}
-	s.reply(257, fmt.Sprintf("%q is the current directory.", cwd))
+	s.reply(257, quotePathname(cwd)+" is the current directory.")
+}
+
+// pathnameQuoter escapes a pathname for a 257 reply: embedded double
+// quotes are doubled (RFC 959 Appendix II). CR is sent as CR NUL
+// (RFC 2640 Section 3.1) by reply, as in any other reply.
+var pathnameQuoter = strings.NewReplacer(`"`, `""`)
+
+// quotePathname quotes a pathname for a 257 reply.
+func quotePathname(p string) string {
+	return `"` + pathnameQuoter.Replace(p) + `"`
 }
 
 func (s *session) handleCWD(path string) {
@@ -25,31 +40,27 @@ func (s *session) handleCWD(path string) {
 		s.reply(530, "Please login with USER and PASS.")
 		return
 	}
-	if err := s.fs.ChangeDir(path); err != nil {
+	if err := s.fs.ChangeDir(s.context(), path); err != nil {
 		s.replyError(err)
 		return
 	}
 
 	// Check for .message file if enabled
+	var message string
 	if s.server.enableDirMessage {
-		f, err := s.fs.OpenFile(".message", 0)
+		f, err := s.fs.OpenFile(s.context(), ".message", 0)
 		if err == nil {
 			// Read up to 2KB to avoid excessive memory usage
 			lr := io.LimitReader(f, 2048)
 			b, _ := io.ReadAll(lr)
 			f.Close()
-			if len(b) > 0 {
-				fmt.Fprintf(s.writer, "250-Message:\r\n")
-				// Trim trailing newlines to avoid an extra empty line at the end
-				msg := strings.TrimRight(string(b), "\r\n")
-				lines := strings.Split(msg, "\n")
-				for _, line := range lines {
-					line = strings.TrimRight(line, "\r")
-					fmt.Fprintf(s.writer, "250-%s\r\n", line)
-				}
-			}
+			message = string(b)
 		}
 	}
+	if message != "" {
+		s.send(NewReply(250, "Message:").Line(message).Line("Directory successfully changed."))
+		return
+	}
 	s.reply(250, "Directory successfully changed.")
 }
 
@@ -63,69 +74,33 @@ func (s *session) handleLIST(arg string) {
 		return
 	}
 
-	// Parse flags and path
-	// Common flags: -l, -a, -R
 	// Format: LIST [-flags] [path]
-	var path string
-	var recursive bool
-
-	args := strings.Fields(arg)
-	for _, a := range args {
-		if strings.HasPrefix(a, "-") {
-			if strings.Contains(a, "R") {
-				recursive = true
-			}
-		} else {
-			path = a
-		}
-	}
+	opts, path := parseListArgs(arg)
 
-	// If no path provided, list current
-	// if path == "" {
-	// 	// internal logic handles empty path as current dir
-	// }
-
-	conn, err := s.connData()
-	if err != nil {
-		s.reply(425, "Can't open data connection.")
+	conn, ok := s.openDataConn("Here comes the directory listing.", dataConnOpen)
+	if !ok {
 		return
 	}
 	defer conn.Close()
 
-	s.reply(150, "Here comes the directory listing.")
-
-	if recursive {
-		err = s.listRecursive(conn, path)
+	var err error
+	w := bufio.NewWriter(conn)
+	if matches, ok, matchErr := s.listMatches(path); ok {
+		err = cmp.Or(matchErr, s.writeMatches(w, matches, opts))
 	} else {
-		entries, listErr := s.fs.ListDir(path)
-		if listErr != nil {
-			// If not recursive, we might error out.
-			// But for LIST, often empty list is better than error if dir empty,
-			// but ListDir usually returns error if not found.
-			// However, standard says we should probably send error before opening data conn if path invalid?
-			// But we already opened data conn (standard behavior varies).
-			// Let's reply error on control channel if data conn empty?
-			// Actually RFC says if file not found, 550.
-			// But since we already sent 150, we should close data conn and maybe 226 or just empty.
-			// But simplest is to try-catch before 150?
-			// Let's stick to previous pattern: check error first.
-			// Wait, I already opened data conn. If ListDir fails, I should probably close and send 450/550.
-			// But `s.fs.ListDir` was called BEFORE `s.connData` in original code.
-			// I moved it after to handle recursion streaming.
-			// Let's revert to checking first for non-recursive case, or just handle error gracefully.
-			err = listErr
-		} else {
-			for _, entry := range entries {
-				s.printListEntry(conn, entry)
-			}
-		}
+		err = s.writeList(w, path, opts)
+	}
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
 	}
 
+	if te, ok := asTransferError(err); ok {
+		s.reply(te.reply())
+		return
+	}
 	if err != nil {
-		// If we haven't written anything, we could send 550?
-		// But we sent 150. So we must close data conn (done by defer) and send 450 or 550.
-		// Or just 226 Transfer complete (but empty).
-		// If path invalid, better 550.
+		// The 1xx reply has already been sent, so the error is reported
+		// after closing the (possibly empty) listing.
 		s.reply(550, "Error listing directory: "+err.Error())
 		return
 	}
@@ -133,55 +108,288 @@ func (s *session) handleLIST(arg string) {
 	s.reply(226, "Directory send OK.")
 }
 
-func (s *session) listRecursive(w io.Writer, path string) error {
-	// 1. List current dir
-	entries, err := s.fs.ListDir(path)
-	if err != nil {
-		return err
-	}
-
-	// Print current dir header if we are deep? Standard ls -R style:
-	// .:
-	// ...
-	//
-	// ./subdir:
-	// ...
-
-	// Helper to print entries
-	for _, entry := range entries {
-		s.printListEntry(w, entry)
-	}
-
-	// 2. Recurse into directories
-	for _, entry := range entries {
-		if entry.IsDir() && entry.Name() != "." && entry.Name() != ".." {
-			subPath := path
-			if subPath == "" || subPath == "." {
-				subPath = entry.Name()
-			} else {
-				if strings.HasSuffix(subPath, "/") {
-					subPath += entry.Name()
-				} else {
-					subPath += "/" + entry.Name()
+// listOptions holds the LIST flags understood by the server.
+type listOptions struct {
+	all       bool // -a: include the "." and ".." entries
+	recursive bool // -R: list subdirectories recursively
+	sortBy    byte // 0 for directory order, or 'n' (name), 't' (time), 'S' (size)
+	reverse   bool // -r: reverse the sort order
+}
+
+// parseListArgs splits a LIST argument into its leading flags and the path.
+// Everything after the flags is the path, so names with spaces work.
+// Unknown flags (such as -l, which is always implied) are ignored.
+func parseListArgs(arg string) (listOptions, string) {
+	var opts listOptions
+
+	rest := strings.TrimSpace(arg)
+	for strings.HasPrefix(rest, "-") {
+		flags, remainder, _ := strings.Cut(rest, " ")
+		for _, f := range flags[1:] {
+			switch f {
+			case 'a':
+				opts.all = true
+			case 'R':
+				opts.recursive = true
+			case 't', 'S':
+				opts.sortBy = byte(f)
+			case 'r':
+				opts.reverse = true
+			}
+		}
+		rest = strings.TrimLeft(remainder, " ")
+	}
+
+	// -r alone reverses the name order, as in ls
+	if opts.reverse && opts.sortBy == 0 {
+		opts.sortBy = 'n'
+	}
+
+	return opts, rest
+}
+
+// writeList writes the listing of path to w. Unsorted listings are streamed
+// from the driver; only the names of subdirectories are kept for -R.
+func (s *session) writeList(w io.Writer, path string, opts listOptions) error {
+	var subdirs []string
+	emit := func(entry os.FileInfo) error {
+		s.cacheListedEntry(path, entry)
+		if opts.recursive && entry.IsDir() && entry.Name() != "." && entry.Name() != ".." {
+			subdirs = append(subdirs, entry.Name())
+		}
+		return s.printListEntry(w, path, entry)
+	}
+
+	if opts.all {
+		for _, name := range []string{".", ".."} {
+			if info, err := s.fs.GetFileInfo(s.context(), joinListPath(path, name)); err == nil {
+				if err := s.printListEntry(w, path, renamedFileInfo{info, name}); err != nil {
+					return err
 				}
 			}
+		}
+	}
 
-			// Add a blank line and header
-			fmt.Fprintf(w, "\r\n%s:\r\n", subPath)
+	if opts.sortBy != 0 {
+		entries, err := s.fs.ListDir(s.context(), path)
+		if err != nil {
+			return err
+		}
+		sortListEntries(entries, opts)
+		for _, entry := range entries {
+			if err := emit(entry); err != nil {
+				return err
+			}
+		}
+	} else {
+		entries, err := s.listDirStream(path)
+		if err != nil {
+			return err
+		}
+		for entry, err := range entries {
+			if err != nil {
+				return err
+			}
+			if err := emit(entry); err != nil {
+				return err
+			}
+		}
+	}
 
-			// Recurse (ignoring errors for subdirs to keep going)
-			_ = s.listRecursive(w, subPath)
+	for _, name := range subdirs {
+		subPath := joinListPath(path, name)
+
+		// Add a blank line and header, ls -R style
+		if _, err := fmt.Fprintf(w, "\r\n%s:\r\n", s.encodeName(subPath)); err != nil {
+			return err
 		}
+
+		// Recurse (ignoring errors for subdirs to keep going)
+		_ = s.writeList(w, subPath, opts)
 	}
 
 	return nil
 }
 
-func (s *session) printListEntry(w io.Writer, entry os.FileInfo) {
-	// Constructing a Unix-style listing string.
-	sStr := fmt.Sprintf("%s 1 owner group %d %s %s\r\n",
-		entry.Mode().String(), entry.Size(), entry.ModTime().Format("Jan 02 15:04"), entry.Name())
-	fmt.Fprint(w, sStr)
+// writeMatches writes the listing of the entries of listMatches, sorted as
+// opts says or by name.
+func (s *session) writeMatches(w io.Writer, matches []os.FileInfo, opts listOptions) error {
+	if opts.sortBy != 0 {
+		sortListEntries(matches, opts)
+	}
+	for _, entry := range matches {
+		if err := s.printListEntry(w, "", entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listMatches returns the entries named by a LIST or NLST argument that is
+// not a directory: a single file, or a wildcard pattern ("*.txt") in its
+// last element, as most servers accept. As with ls, the entries are named
+// with the directory of the argument ("logs/a.log" for "logs/*.log"), and
+// matches are sorted by name. Patterns do not match names starting with a
+// dot unless they start with one too.
+//
+// ok is false if p is to be listed as a directory, including when it does
+// not exist, so the directory listing reports the error.
+func (s *session) listMatches(p string) (matches []os.FileInfo, ok bool, err error) {
+	if p == "" {
+		return nil, false, nil
+	}
+	if info, err := s.fs.GetFileInfo(s.context(), p); err == nil {
+		if info.IsDir() {
+			return nil, false, nil
+		}
+		return []os.FileInfo{renamedFileInfo{info, p}}, true, nil
+	}
+
+	dir, pattern := "", p
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		dir, pattern = p[:i+1], p[i+1:]
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return nil, false, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, true, err
+	}
+
+	entries, err := s.listDirStream(dir)
+	if err != nil {
+		return nil, true, err
+	}
+	for entry, err := range entries {
+		if err != nil {
+			return nil, true, err
+		}
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(pattern, ".") {
+			continue
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			s.cacheListedEntry(dir, entry)
+			matches = append(matches, renamedFileInfo{entry, dir + name})
+		}
+	}
+	slices.SortFunc(matches, func(a, b os.FileInfo) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return matches, true, nil
+}
+
+// listDirStream returns an iterator over the entries of a directory. It
+// streams them when the driver implements DirStreamer, and otherwise adapts
+// the result of ListDir. An error reading the directory once the listing
+// has started is yielded as errListIncomplete, and ends the iteration.
+func (s *session) listDirStream(path string) (iter.Seq2[os.FileInfo, error], error) {
+	ds, ok := s.driverFS.(DirStreamer)
+	if !ok {
+		entries, err := s.fs.ListDir(s.context(), path)
+		if err != nil {
+			return nil, err
+		}
+		return listValues(entries), nil
+	}
+
+	entries, err := ds.ListDirStream(path)
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func(os.FileInfo, error) bool) {
+		for entry, err := range entries {
+			if err != nil {
+				s.server.logger.Warn("list_incomplete",
+					"session_id", s.sessionID,
+					"path", s.redactPath(path),
+					"error", err,
+				)
+				yield(nil, errListIncomplete)
+				return
+			}
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}, nil
+}
+
+// errListIncomplete ends a listing whose directory could not be read to the
+// end, with 451 instead of 226 so that clients do not take it as complete.
+var errListIncomplete = &TransferError{Code: 451, Message: "Error reading directory; listing incomplete."}
+
+// listValues returns an iterator over entries, without errors.
+func listValues(entries []os.FileInfo) iter.Seq2[os.FileInfo, error] {
+	return func(yield func(os.FileInfo, error) bool) {
+		for _, entry := range entries {
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
+
+// sortListEntries sorts entries for LIST -t (newest first), -S (largest
+// first) or by name, reversed with -r. Ties are broken by name.
+func sortListEntries(entries []os.FileInfo, opts listOptions) {
+	slices.SortStableFunc(entries, func(a, b os.FileInfo) int {
+		c := 0
+		switch opts.sortBy {
+		case 't':
+			c = b.ModTime().Compare(a.ModTime())
+		case 'S':
+			c = cmp.Compare(b.Size(), a.Size())
+		}
+		if c == 0 {
+			c = strings.Compare(a.Name(), b.Name())
+		}
+		if opts.reverse {
+			c = -c
+		}
+		return c
+	})
+}
+
+// joinListPath joins a listed directory and an entry name.
+func joinListPath(dir, name string) string {
+	if dir == "" || dir == "." {
+		return name
+	}
+	if strings.HasSuffix(dir, "/") {
+		return dir + name
+	}
+	return dir + "/" + name
+}
+
+// renamedFileInfo overrides the name of a FileInfo, for the "." and ".."
+// entries of LIST -a and the mount points of FSDriver.
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi renamedFileInfo) Name() string { return fi.name }
+
+// printListEntry writes a Unix-style listing line for an entry of dir.
+// Symbolic links are shown as "name -> target" when the driver implements
+// Symlinker.
+func (s *session) printListEntry(w io.Writer, dir string, entry os.FileInfo) error {
+	mode := entry.Mode().String()
+	name := s.encodeName(entry.Name())
+	if entry.Mode()&os.ModeSymlink != 0 {
+		// FileMode.String uses 'L' for links; ls uses 'l'
+		mode = "l" + mode[1:]
+		if sl, ok := s.driverFS.(Symlinker); ok {
+			if target, err := sl.ReadLink(joinListPath(dir, entry.Name())); err == nil {
+				name += " -> " + s.encodeName(target)
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%s 1 owner group %d %s %s\r\n",
+		mode, entry.Size(), entry.ModTime().Format("Jan 02 15:04"), name)
+	return err
 }
 
 func (s *session) handleNLST(arg string) {
@@ -190,24 +398,47 @@ func (s *session) handleNLST(arg string) {
 		return
 	}
 
-	path := arg
-	entries, err := s.fs.ListDir(path)
+	var entries iter.Seq2[os.FileInfo, error]
+	matches, ok, err := s.listMatches(arg)
+	if ok {
+		entries = listValues(matches)
+	} else {
+		entries, err = s.listDirStream(arg)
+	}
+	if te, ok := asTransferError(err); ok {
+		s.reply(te.reply())
+		return
+	}
 	if err != nil {
 		s.replyError(err)
 		return
 	}
 
-	conn, err := s.connData()
-	if err != nil {
-		s.reply(425, "Can't open data connection.")
+	conn, ok := s.openDataConn("Here comes the file list.", dataConnOpen)
+	if !ok {
 		return
 	}
 	defer conn.Close()
 
-	s.reply(150, "Here comes the file list.")
-
-	for _, entry := range entries {
-		fmt.Fprintf(conn, "%s\r\n", entry.Name())
+	w := bufio.NewWriter(conn)
+	for entry, readErr := range entries {
+		if err = readErr; err != nil {
+			break
+		}
+		if _, err = fmt.Fprintf(w, "%s\r\n", s.encodeName(entry.Name())); err != nil {
+			break
+		}
+	}
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	if te, ok := asTransferError(err); ok {
+		s.reply(te.reply())
+		return
+	}
+	if err != nil {
+		s.reply(426, "Connection closed; transfer aborted.")
+		return
 	}
 
 	s.reply(226, "Transfer complete.")
@@ -218,7 +449,11 @@ func (s *session) handleMKD(path string) {
 		s.reply(530, "Not logged in.")
 		return
 	}
-	if err := s.fs.MakeDir(path); err != nil {
+	err := s.fs.MakeDir(s.context(), path)
+	if err != nil && s.server.recursiveMKD && os.IsNotExist(err) {
+		err = s.makeDirAll(path)
+	}
+	if err != nil {
 		s.replyError(err)
 		return
 	}
@@ -230,9 +465,30 @@ func (s *session) handleMKD(path string) {
 		"host", s.host,
 		"path", s.redactPath(path),
 	)
-	// RFC 959: 257 "PATHNAME" created.
-	// Quote the path.
-	s.reply(257, fmt.Sprintf("%q created.", path))
+	// RFC 959: 257 "PATHNAME" created, with the absolute pathname
+	if abs, ok := s.absolutePath(path); ok {
+		path = abs
+	}
+	s.reply(257, quotePathname(path)+" created.")
+}
+
+// makeDirAll creates dir and its missing parents, for WithRecursiveMKD.
+// Parents that already exist are skipped.
+func (s *session) makeDirAll(dir string) error {
+	dir, ok := s.absolutePath(dir)
+	if !ok {
+		return os.ErrNotExist
+	}
+	for i := 1; i <= len(dir); i++ {
+		if i < len(dir) && dir[i] != '/' {
+			continue
+		}
+		err := s.fs.MakeDir(s.context(), dir[:i])
+		if err != nil && (i == len(dir) || !os.IsExist(err)) {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *session) handleRMD(path string) {
@@ -240,7 +496,7 @@ func (s *session) handleRMD(path string) {
 		s.reply(530, "Not logged in.")
 		return
 	}
-	if err := s.fs.RemoveDir(path); err != nil {
+	if err := s.fs.RemoveDir(s.context(), path); err != nil {
 		s.replyError(err)
 		return
 	}
@@ -260,7 +516,7 @@ func (s *session) handleDELE(path string) {
 		s.reply(530, "Not logged in.")
 		return
 	}
-	if err := s.fs.DeleteFile(path); err != nil {
+	if err := s.fs.DeleteFile(s.context(), path); err != nil {
 		s.replyError(err)
 		return
 	}
@@ -282,7 +538,7 @@ func (s *session) handleRNFR(path string) {
 	}
 
 	// Verify file exists
-	_, err := s.fs.GetFileInfo(path)
+	_, err := s.fs.GetFileInfo(s.context(), path)
 	if err != nil {
 		s.reply(550, "File not found.")
 		return
@@ -303,7 +559,7 @@ func (s *session) handleRNTO(path string) {
 		return
 	}
 
-	err := s.fs.Rename(s.renameFrom, path)
+	err := s.fs.Rename(s.context(), s.renameFrom, path)
 	if err != nil {
 		s.replyError(err)
 		s.renameFrom = ""
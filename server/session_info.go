@@ -1,9 +1,10 @@
 package server
 
 import (
+	"bufio"
 	"fmt"
 	"io"
-	"os"
+	"slices"
 	"strings"
 )
 
@@ -13,13 +14,13 @@ func (s *session) handleSIZE(path string) {
 		return
 	}
 
-	info, err := s.fs.GetFileInfo(path)
+	info, err := s.stat(path)
 	if err != nil {
 		s.reply(550, "Could not get file size.")
 		return
 	}
 
-	s.reply(213, fmt.Sprintf("%d", info.Size()))
+	s.reply(213, fmt.Sprintf("%d", info.Size))
 }
 
 func (s *session) handleMDTM(path string) {
@@ -28,7 +29,7 @@ func (s *session) handleMDTM(path string) {
 		return
 	}
 
-	info, err := s.fs.GetFileInfo(path)
+	info, err := s.stat(path)
 	if err != nil {
 		s.reply(550, "Could not get file modification time.")
 		return
@@ -36,14 +37,10 @@ func (s *session) handleMDTM(path string) {
 
 	// YYYYMMDDHHMMSS format
 	// RFC 3659 Section 2.3: "Time values are always represented in UTC"
-	s.reply(213, info.ModTime().UTC().Format("20060102150405"))
+	s.reply(213, info.ModTime.UTC().Format("20060102150405"))
 }
 
 func (s *session) handleFEAT(_ string) {
-	if _, err := s.writer.WriteString("211-Features:\r\n"); err != nil {
-		return
-	}
-
 	features := []string{
 		"SIZE",
 		"MDTM",
@@ -56,49 +53,115 @@ func (s *session) handleFEAT(_ string) {
 		"MLST type*;size*;modify*;",
 		"REST STREAM",
 		"HOST",
-		"HASH SHA-1;SHA-256;SHA-512;MD5;CRC32",
-		"MFMT",
+		"COMB",
+	}
+
+	// The driver's optional capabilities are known once logged in; before
+	// that, everything the server can do is listed.
+	if !s.isLoggedIn || s.caps.openRange != nil {
+		features = append(features, "RANG STREAM")
+	}
+	if !s.isLoggedIn || s.caps.hash != nil {
+		features = append(features, "HASH "+s.hashFeature(), "XCRC", "XMD5", "XSHA1", "XSHA256")
+	}
+	if !s.isLoggedIn || s.caps.setTime != nil {
+		features = append(features, "MFMT")
+	}
+	if !s.isLoggedIn || s.caps.setCtime != nil {
+		features = append(features, "MFCT")
+	}
+	if facts := s.mffFacts(); facts != "" {
+		features = append(features, "MFF "+facts)
+	}
+	if !s.isLoggedIn || s.caps.space != nil {
+		features = append(features, "AVBL")
 	}
 
 	if !s.server.disableMLSD {
 		features = append(features, "MLSD")
 	}
 
+	features = append(features, "LANG "+s.languageTags())
+
+	if s.server.dataReuse {
+		features = append(features, "DATA-REUSE")
+	}
+
 	if s.server.tlsConfig != nil {
 		features = append(features, "AUTH TLS", "PBSZ", "PROT")
 	}
 
+	r := NewReply(211, "Features:")
 	for _, f := range features {
-		if _, err := s.writer.WriteString(" " + f + "\r\n"); err != nil {
-			return
-		}
+		r.Indent(f)
 	}
+	s.send(r.Line("End"))
+}
 
-	if _, err := s.writer.WriteString("211 End\r\n"); err != nil {
+func (s *session) handleOPTS(arg string) {
+	// OPTS UTF8 [ON|OFF] (RFC 2640)
+	if fields := strings.Fields(strings.ToUpper(arg)); len(fields) > 0 && fields[0] == "UTF8" {
+		switch {
+		case len(fields) == 1 || fields[1] == "ON":
+			s.setUTF8Mode(true)
+			s.reply(200, "UTF8 mode enabled.")
+		case fields[1] == "OFF":
+			s.setUTF8Mode(false)
+			s.reply(200, "UTF8 mode disabled.")
+		default:
+			s.reply(501, "Option not understood.")
+		}
 		return
 	}
-	_ = s.writer.Flush()
+	// OPTS HASH [ALGO] (draft-bryan-ftp-hash)
+	if fields := strings.Fields(arg); len(fields) > 0 && strings.EqualFold(fields[0], "HASH") {
+		s.handleOPTSHASH(fields[1:])
+		return
+	}
+	s.reply(501, "Option not understood.")
 }
 
-func (s *session) handleOPTS(arg string) {
-	if strings.HasPrefix(strings.ToUpper(arg), "UTF8 ON") {
-		s.reply(200, "Always in UTF8 mode.")
+// handleOPTSHASH replies with the selected HASH algorithm, or selects one.
+func (s *session) handleOPTSHASH(args []string) {
+	if s.isLoggedIn && s.caps.hash == nil {
+		s.reply(502, "Checksums not supported.")
 		return
 	}
-	// OPTS HASH [ALGO]
-	if strings.HasPrefix(strings.ToUpper(arg), "HASH") {
-		parts := strings.Split(arg, " ")
-		if len(parts) > 1 {
-			algo := strings.ToUpper(parts[1])
-			switch algo {
-			case "SHA-1", "SHA-256", "SHA-512", "MD5", "CRC32":
-				s.selectedHash = algo
-				s.reply(200, algo+" selected.")
-				return
-			}
+	switch len(args) {
+	case 0:
+		s.reply(200, s.selectedHash)
+	case 1:
+		algo := strings.ToUpper(args[0])
+		if !slices.Contains(s.hashAlgorithms(), algo) {
+			s.reply(504, "Unsupported algorithm.")
+			return
 		}
+		s.selectedHash = algo
+		s.reply(200, algo)
+	default:
+		s.reply(501, "Syntax error: OPTS HASH [<algorithm>].")
 	}
-	s.reply(501, "Option not understood.")
+}
+
+// hashAlgorithms returns the algorithms of HASH: the driver's once logged
+// in, and the default ones before.
+func (s *session) hashAlgorithms() []string {
+	if s.isLoggedIn {
+		return s.caps.hashAlgos
+	}
+	return defaultHashAlgorithms
+}
+
+// hashFeature returns the parameters of the HASH feature: the algorithms,
+// with the selected one marked with "*", e.g. "SHA-1;SHA-256*;MD5".
+func (s *session) hashFeature() string {
+	algos := slices.Clone(s.hashAlgorithms())
+	for i, algo := range algos {
+		if algo == s.selectedHash {
+			algos[i] += "*"
+		}
+	}
+	return strings.Join(algos, ";")
 }
 
 func (s *session) handleMLSD(arg string) {
@@ -113,23 +176,40 @@ func (s *session) handleMLSD(arg string) {
 	}
 
 	path := arg
-	entries, err := s.fs.ListDir(path)
+	entries, err := s.listDirStream(path)
 	if err != nil {
 		s.replyError(err)
 		return
 	}
 
-	conn, err := s.connData()
-	if err != nil {
-		s.reply(425, "Can't open data connection.")
+	conn, ok := s.openDataConn("MLSD listing started.", dataConnOpen)
+	if !ok {
 		return
 	}
 	defer conn.Close()
 
-	s.reply(150, "MLSD listing started.")
-
-	for _, entry := range entries {
-		s.writeMLEntry(conn, entry)
+	// Entries are read from the driver only as fast as the client accepts
+	// them, so a slow reader holds back the listing instead of buffering it.
+	w := bufio.NewWriter(conn)
+	for entry, readErr := range entries {
+		if err = readErr; err != nil {
+			break
+		}
+		s.cacheListedEntry(path, entry)
+		if err = s.writeMLEntry(w, statInfoFromFileInfo(entry)); err != nil {
+			break
+		}
+	}
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	if te, ok := asTransferError(err); ok {
+		s.reply(te.reply())
+		return
+	}
+	if err != nil {
+		s.reply(426, "Connection closed; transfer aborted.")
+		return
 	}
 
 	s.reply(226, "MLSD listing complete.")
@@ -141,30 +221,33 @@ func (s *session) handleMLST(arg string) {
 		return
 	}
 
-	info, err := s.fs.GetFileInfo(arg)
+	info, err := s.stat(arg)
 	if err != nil {
 		s.reply(550, "Could not get file info.")
 		return
 	}
 
-	_, _ = s.writer.WriteString("250- Listing follows\r\n")
-	if err := s.writer.WriteByte(' '); err != nil {
-		return
-	}
-	s.writeMLEntry(s.writer, info)
-	_, _ = s.writer.WriteString("250 End\r\n")
-	_ = s.writer.Flush()
+	s.send(NewReply(250, " Listing follows").Indent(mlEntry(info)).Line("End"))
+}
+
+func (s *session) writeMLEntry(w io.Writer, info StatInfo) error {
+	_, err := fmt.Fprintf(w, "%s\r\n", s.encodeName(mlEntry(info)))
+	return err
 }
 
-func (s *session) writeMLEntry(w io.Writer, info os.FileInfo) {
+// mlEntry formats info as an MLSD or MLST entry.
+func mlEntry(info StatInfo) string {
 	// Format: type=file;size=123;modify=20210101120000; name
 	t := "file"
-	if info.IsDir() {
+	switch {
+	case info.Symlink:
+		// RFC 3659 Section 7.5.1: OS-specific types use the "OS." prefix
+		t = "OS.unix=slink"
+	case info.IsDir:
 		t = "dir"
 	}
 
 	// RFC 3659 Section 2.3: "Time values are always represented in UTC"
-	sStr := fmt.Sprintf("type=%s;size=%d;modify=%s; %s\r\n",
-		t, info.Size(), info.ModTime().UTC().Format("20060102150405"), info.Name())
-	fmt.Fprint(w, sStr)
+	return fmt.Sprintf("type=%s;size=%d;modify=%s; %s",
+		t, info.Size, info.ModTime.UTC().Format("20060102150405"), info.Name)
 }
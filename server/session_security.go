@@ -1,7 +1,6 @@
 package server
 
 import (
-	"bufio"
 	"crypto/tls"
 	"strings"
 )
@@ -12,7 +11,14 @@ func (s *session) handleAUTH(arg string) {
 		s.reply(502, "TLS not configured.")
 		return
 	}
-	if strings.ToUpper(arg) != "TLS" {
+	switch strings.ToUpper(arg) {
+	case "TLS":
+	case "SSL":
+		// Obsolete (draft-murray-auth-ftp-ssl); crypto/tls has no SSL
+		s.audit(AuditEvent{Type: AuditTLSDowngrade, Command: "AUTH", Reason: "auth_ssl"})
+		s.reply(504, "AUTH SSL is obsolete; use AUTH TLS.")
+		return
+	default:
 		s.reply(504, "Only AUTH TLS is supported.")
 		return
 	}
@@ -20,12 +26,15 @@ func (s *session) handleAUTH(arg string) {
 	s.reply(234, "AUTH TLS successful.")
 
 	// Upgrade connection
-	tlsConn := tls.Server(s.conn, s.server.tlsConfig)
+	tlsConn := tls.Server(s.conn, s.sessionTLSConfig())
 
+	// Reuse the pooled buffers. Telnet sequences, such as the escaped 0xFF
+	// bytes of legacy file names, are still filtered over TLS.
 	s.mu.Lock()
 	s.conn = tlsConn
-	s.reader = bufio.NewReader(tlsConn)
-	s.writer = bufio.NewWriter(tlsConn)
+	s.tnet.Reset(tlsConn)
+	s.reader.Reset(s.tnet)
+	s.writer.Reset(tlsConn)
 	s.mu.Unlock()
 }
 
@@ -37,11 +46,18 @@ func (s *session) handlePROT(arg string) {
 	// RFC 4217
 	// P - Private (TLS)
 	// C - Clear (No TLS)
+	// A block mode connection kept open keeps its old protection, so it is
+	// closed.
 	switch strings.ToUpper(arg) {
 	case "P":
+		s.closeKeptData()
 		s.prot = "P"
 		s.reply(200, "PROT P OK.")
 	case "C":
+		if _, ok := s.conn.(*tls.Conn); ok {
+			s.audit(AuditEvent{Type: AuditTLSDowngrade, Command: "PROT", Reason: "clear_data_channel"})
+		}
+		s.closeKeptData()
 		s.prot = "C"
 		s.reply(200, "PROT C OK.")
 	default:
@@ -49,6 +65,17 @@ func (s *session) handlePROT(arg string) {
 	}
 }
 
+// handleCCC refuses to clear the command channel (RFC 4217 Section 6),
+// which would expose the rest of the session to tampering.
+func (s *session) handleCCC(_ string) {
+	if _, ok := s.conn.(*tls.Conn); !ok {
+		s.reply(533, "Command channel is not protected.")
+		return
+	}
+	s.audit(AuditEvent{Type: AuditTLSDowngrade, Command: "CCC", Reason: "clear_command_channel"})
+	s.reply(534, "CCC not permitted.")
+}
+
 func (s *session) handlePBSZ(_ string) {
 	if s.server.tlsConfig == nil {
 		s.reply(502, "TLS not configured.")
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -10,25 +11,86 @@ import (
 	"strings"
 	"sync/atomic"
 	"time"
+
+	"github.com/gonzalop/ftp/internal/blockmode"
 )
 
-func (s *session) startTransfer() context.Context {
+// transferContext returns the context of a transfer's driver operations,
+// which ABOR cancels once the transfer is started with startTransfer. The
+// caller must call cancel if the transfer does not start.
+func (s *session) transferContext() (context.Context, context.CancelFunc) {
+	return context.WithCancel(s.context())
+}
+
+func (s *session) startTransfer(ctx context.Context, cancel context.CancelFunc, command, path string, offset int64) *transferProgress {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.busy = true
-	s.transferCtx, s.transferCancel = context.WithCancel(context.Background())
-	return s.transferCtx
+	s.transferCtx, s.transferCancel = ctx, cancel
+	s.transfer = newTransferProgress(command, path, offset)
+	if s.server.transferStallTimeout > 0 {
+		ctx, progress := s.transferCtx, s.transfer
+		s.transferWG.Add(1)
+		go func() {
+			defer s.transferWG.Done()
+			s.watchTransfer(ctx, progress, s.server.transferStallTimeout)
+		}()
+	}
+	return s.transfer
+}
+
+// watchTransfer aborts the transfer if it moves no data for timeout (see
+// WithTransferStallTimeout). Like ABOR, it closes the data connection and
+// cancels the transfer, so the transfer goroutine replies 426.
+func (s *session) watchTransfer(ctx context.Context, progress *transferProgress, timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		lastActivity := time.Unix(0, progress.lastActivity.Load())
+		if time.Since(lastActivity) < timeout {
+			continue
+		}
+
+		s.mu.Lock()
+		if s.transfer == progress {
+			if s.dataConn != nil {
+				blockmode.Interrupt(s.dataConn)
+			}
+			s.transferCancel()
+		}
+		s.mu.Unlock()
+
+		s.server.logger.Warn("transfer_stalled",
+			"session_id", s.sessionID,
+			"remote_ip", s.redactIP(s.remoteIP),
+			"user", s.user,
+			"operation", progress.command,
+			"path", s.redactPath(progress.path),
+			"bytes", progress.bytes.Load(),
+		)
+		return
+	}
 }
 
 func (s *session) endTransfer() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.busy = false
+	if s.transfer != nil {
+		s.lastTransfer = time.Now()
+	}
 	if s.transferCancel != nil {
 		s.transferCancel()
 	}
 	s.transferCtx = nil
 	s.transferCancel = nil
+	s.transfer = nil
 }
 
 func (s *session) handleRETR(path string) {
@@ -37,47 +99,51 @@ func (s *session) handleRETR(path string) {
 		return
 	}
 
-	file, err := s.fs.OpenFile(path, os.O_RDONLY)
-	if err != nil {
-		s.replyError(err)
+	// The restart marker applies to this command only, whatever its outcome
+	offset := s.restartOffset
+	rangeEnd, hasRange := s.rangeEnd, s.hasRange
+	s.restartOffset = 0
+	s.hasRange = false
+
+	ctx, cancel := s.transferContext()
+	length := int64(-1)
+	if hasRange {
+		length = rangeEnd - offset + 1
+	}
+	file, ok := s.openDownload(ctx, path, offset, length)
+	if !ok {
+		cancel()
 		return
 	}
 
-	if s.restartOffset > 0 {
-		if seeker, ok := file.(io.Seeker); ok {
-			_, err := seeker.Seek(s.restartOffset, io.SeekStart)
-			if err != nil {
-				file.Close()
-				s.replyError(err)
-				return
-			}
-		} else {
-			file.Close()
-			s.reply(550, "Resume not supported for this file.")
-			s.restartOffset = 0
-			return
-		}
+	var src io.Reader = file
+	if hasRange {
+		src = io.LimitReader(src, rangeEnd-offset+1)
 	}
-
-	conn, err := s.connData()
+	src, hooks, err := s.wrapDownload(path, offset, src)
 	if err != nil {
 		file.Close()
-		s.reply(425, "Can't open data connection.")
+		cancel()
+		s.replyTransferError("RETR", path, err)
 		return
 	}
-	s.dataConn = conn // Store for ABOR
 
-	if s.restartOffset > 0 {
-		s.reply(150, fmt.Sprintf("Opening data connection for RETR (restarting at %d).", s.restartOffset))
-	} else {
-		s.reply(150, "Opening data connection for RETR.")
+	opening := "Opening data connection for RETR."
+	if hasRange {
+		opening = fmt.Sprintf("Opening data connection for RETR (bytes %d-%d).", offset, rangeEnd)
+	} else if offset > 0 {
+		opening = fmt.Sprintf("Opening data connection for RETR (restarting at %d).", offset)
 	}
+	conn, ok := s.openDataConn(opening, dataConnOpen)
+	if !ok {
+		hooks.Close()
+		file.Close()
+		cancel()
+		return
+	}
+	s.dataConn = conn // Store for ABOR
 
-	// Reset offset after use
-	offset := s.restartOffset
-	s.restartOffset = 0
-
-	ctx := s.startTransfer()
+	progress := s.startTransfer(ctx, cancel, "RETR", path, offset)
 	s.transferWG.Add(1)
 
 	go func() {
@@ -86,28 +152,33 @@ func (s *session) handleRETR(path string) {
 		defer file.Close()
 		defer conn.Close()
 
-		var src io.Reader = file
 		if s.transferType == "A" {
-			src = newASCIIReader(file)
+			src = newASCIIReader(src)
 		}
 
 		// Track transfer metrics
 		startTime := time.Now()
 
-		// Apply bandwidth limiting to the connection (we're writing to it)
-		dst := s.rateLimitWriter(conn)
-
-		bytesTransferred, err := copyWithPooledBuffer(dst, src)
+		bytesTransferred, err := s.copyToData(conn, src, progress)
+		if hookErr := hooks.Close(); err == nil {
+			err = hookErr
+		}
 
 		// Check for cancellation
 		select {
 		case <-ctx.Done():
+			s.logIncompleteTransfer("RETR", path, bytesTransferred, time.Since(startTime))
 			s.reply(426, "Transfer aborted.")
 			return
 		default:
 		}
 
 		if err != nil {
+			s.logIncompleteTransfer("RETR", path, bytesTransferred, time.Since(startTime))
+			if _, ok := asTransferError(err); ok {
+				s.replyTransferError("RETR", path, err)
+				return
+			}
 			s.reply(426, "Connection closed; transfer aborted.")
 			return
 		}
@@ -149,7 +220,7 @@ func (s *session) handleRETR(path string) {
 		}
 
 		// Transfer logging
-		s.logTransfer("RETR", path, bytesTransferred, duration)
+		s.logTransfer("RETR", path, bytesTransferred, duration, true)
 
 		s.endTransfer()
 		s.reply(226, "Transfer complete.")
@@ -161,54 +232,85 @@ func (s *session) handleSTOR(path string) {
 		return
 	}
 
+	// The restart marker applies to this command only, whatever its outcome
+	offset := s.restartOffset
+	hasRange := s.hasRange
+	s.restartOffset = 0
+	s.hasRange = false
+
+	if hasRange {
+		s.reply(504, "Byte ranges are only supported for RETR.")
+		return
+	}
+
+	policy := s.uploadPolicy()
+	if !s.checkUploadName(policy, path) {
+		return
+	}
+	version, ok := s.checkOverwrite(policy, path, offset > 0)
+	if !ok {
+		return
+	}
+
 	// Determine flags based on restart
 	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
-	if s.restartOffset > 0 {
+	if offset > 0 {
 		flags = os.O_WRONLY | os.O_CREATE
 	}
 
-	file, err := s.fs.OpenFile(path, flags)
+	// Atomic and versioned uploads are written under a temporary name and
+	// renamed into place once complete. Resumed uploads must continue the
+	// existing file, and are never versioned.
+	atomicUpload := (s.server.atomicUploads || version) && offset == 0
+	target := path
+	if atomicUpload {
+		target = s.uploadTempPath(path)
+	} else if policy.exclusive() {
+		flags |= os.O_EXCL
+	}
+
+	// Hooks can reject the upload before an existing file is truncated
+	sink := &uploadSink{}
+	dst, hooks, err := s.wrapUpload("STOR", path, offset, sink)
 	if err != nil {
-		s.replyError(err)
+		s.replyTransferError("STOR", path, err)
 		return
 	}
 
-	if s.restartOffset > 0 {
-		if seeker, ok := file.(io.Seeker); ok {
-			_, err := seeker.Seek(s.restartOffset, io.SeekStart)
-			if err != nil {
-				file.Close()
-				s.replyError(err)
-				return
-			}
-		} else {
+	ctx, cancel := s.transferContext()
+	file, err := s.fs.OpenFile(ctx, target, flags)
+	if err != nil {
+		hooks.Close()
+		cancel()
+		s.replyUploadError(policy, err)
+		return
+	}
+	sink.w = file
+
+	if offset > 0 {
+		if !s.seekRestart(file, offset) {
+			hooks.Close()
 			file.Close()
-			s.reply(550, "Resume not supported for this file.")
-			s.restartOffset = 0
+			cancel()
 			return
 		}
 	}
 
-	conn, err := s.connData()
-	if err != nil {
+	conn, ok := s.openDataConn("Opening data connection for STOR.", dataConnOpen)
+	if !ok {
+		hooks.Close()
 		file.Close()
-		s.reply(425, "Can't open data connection.")
+		cancel()
 		return
 	}
 	s.dataConn = conn
 
-	s.reply(150, "Opening data connection for STOR.")
-
-	// Reset offset after use
-	s.restartOffset = 0
-
-	ctx := s.startTransfer()
+	progress := s.startTransfer(ctx, cancel, "STOR", path, offset)
 	s.transferWG.Add(1)
 
 	go func() {
 		defer s.transferWG.Done()
 		defer s.endTransfer()
-		defer file.Close()
 		defer conn.Close()
 
 		// Track transfer metrics
@@ -221,19 +323,60 @@ func (s *session) handleSTOR(path string) {
 		// Apply bandwidth limiting
 		src = s.rateLimitReader(src)
 
-		bytesTransferred, err := copyWithPooledBuffer(file, src)
+		bytesTransferred, err := s.server.copyWithPooledBuffer(progress.writer(policy.limitWriter(dst, offset)), src)
+		if hookErr := hooks.Close(); err == nil {
+			err = hookErr
+		}
+		closeErr := file.Close()
+
+		// Rejected new uploads are removed; resumed ones keep what was written
+		if errors.Is(err, errUploadTooLarge) {
+			s.discardUpload(atomicUpload || offset == 0, target)
+			s.reply(552, "Exceeded storage allocation.")
+			return
+		}
+		if _, ok := asTransferError(err); ok {
+			s.discardUpload(atomicUpload || offset == 0, target)
+			s.replyTransferError("STOR", path, err)
+			return
+		}
 
 		select {
 		case <-ctx.Done():
+			s.abortUpload("STOR", path, target, offset == 0, bytesTransferred, time.Since(startTime))
 			s.reply(426, "Transfer aborted.")
 			return
 		default:
 		}
 
 		if err != nil {
+			s.abortUpload("STOR", path, target, offset == 0, bytesTransferred, time.Since(startTime))
 			s.reply(426, "Connection closed; transfer aborted.")
 			return
 		}
+
+		if offset == 0 && policy.tooSmall(bytesTransferred) {
+			s.discardUpload(true, target)
+			s.reply(552, "File is smaller than the minimum allowed size.")
+			return
+		}
+
+		if atomicUpload {
+			if closeErr != nil {
+				s.discardUpload(true, target)
+			} else if version {
+				closeErr = s.publishVersioned(target, path)
+			} else {
+				closeErr = s.publishUpload(target, path)
+			}
+			if closeErr != nil {
+				s.reply(451, "Upload not published: "+closeErr.Error())
+				return
+			}
+		} else if closeErr != nil {
+			s.reply(451, "Upload failed: "+closeErr.Error())
+			return
+		}
 		duration := time.Since(startTime)
 
 		// Calculate throughput in MB/s
@@ -271,35 +414,136 @@ func (s *session) handleSTOR(path string) {
 		}
 
 		// Transfer logging
-		s.logTransfer("STOR", path, bytesTransferred, duration)
+		s.logTransfer("STOR", path, bytesTransferred, duration, true)
 
 		s.endTransfer()
 		s.reply(226, "Transfer complete.")
 	}()
 }
+
+// uploadTempPath returns the temporary name an atomic upload to path is
+// written to: a hidden file in the same directory, unique to the session.
+func (s *session) uploadTempPath(path string) string {
+	i := strings.LastIndex(path, "/")
+	dir, base := path[:i+1], path[i+1:]
+	return fmt.Sprintf("%s.%s.%s.part", dir, base, s.sessionID)
+}
+
+// publishUpload runs the driver's UploadFinalizer, if any, and renames a
+// completed atomic upload into place. The temporary file is removed if
+// either step fails.
+func (s *session) publishUpload(tmpPath, finalPath string) error {
+	if f, ok := s.driverFS.(UploadFinalizer); ok {
+		if err := f.OnUploadFinalize(tmpPath, finalPath); err != nil {
+			s.discardUpload(true, tmpPath)
+			return err
+		}
+	}
+	if err := s.fs.Rename(s.context(), tmpPath, finalPath); err != nil {
+		s.discardUpload(true, tmpPath)
+		return err
+	}
+	return nil
+}
+
+// discardUpload removes the file of a failed or rejected upload, if discard
+// is true (e.g. the temporary file of an atomic upload).
+func (s *session) discardUpload(discard bool, path string) {
+	if !discard {
+		return
+	}
+	if err := s.fs.DeleteFile(s.context(), path); err != nil && !os.IsNotExist(err) {
+		s.server.logger.Warn("upload_discard_failed",
+			"session_id", s.sessionID,
+			"path", s.redactPath(path),
+			"error", err,
+		)
+	}
+}
+
 func (s *session) handleAPPE(path string) {
 	if !s.isLoggedIn {
 		s.reply(530, "Not logged in.")
 		return
 	}
 
-	file, err := s.fs.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE)
+	// The restart marker applies to this command only, whatever its outcome
+	offset := s.restartOffset
+	hasRange := s.hasRange
+	s.restartOffset = 0
+	s.hasRange = false
+
+	if hasRange {
+		s.reply(504, "Byte ranges are only supported for RETR.")
+		return
+	}
+
+	policy := s.uploadPolicy()
+	if !s.checkUploadName(policy, path) {
+		return
+	}
+	if _, ok := s.checkOverwrite(policy, path, true); !ok {
+		return
+	}
+
+	// The maximum size applies to the whole file, including existing data
+	existing := offset
+	if offset == 0 && policy != nil && policy.MaxFileSize > 0 {
+		if info, err := s.fs.GetFileInfo(s.context(), path); err == nil {
+			existing = info.Size()
+		}
+	}
+
+	// With a restart marker, APPE writes from the offset instead of the end
+	// of the file, so an interrupted append can be resumed.
+	flags := os.O_WRONLY | os.O_APPEND | os.O_CREATE
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_CREATE
+	}
+	if policy.exclusive() {
+		flags |= os.O_EXCL
+	}
+
+	sink := &uploadSink{}
+	dst, hooks, err := s.wrapUpload("APPE", path, offset, sink)
 	if err != nil {
-		s.replyError(err)
+		s.replyTransferError("APPE", path, err)
 		return
 	}
 
-	conn, err := s.connData()
+	ctx, cancel := s.transferContext()
+	file, err := s.fs.OpenFile(ctx, path, flags)
 	if err != nil {
+		hooks.Close()
+		cancel()
+		s.replyUploadError(policy, err)
+		return
+	}
+	sink.w = file
+
+	if offset > 0 {
+		if !s.seekRestart(file, offset) {
+			hooks.Close()
+			file.Close()
+			cancel()
+			return
+		}
+	}
+
+	opening := "Opening data connection for APPE."
+	if offset > 0 {
+		opening = fmt.Sprintf("Opening data connection for APPE (restarting at %d).", offset)
+	}
+	conn, ok := s.openDataConn(opening, dataConnOpen)
+	if !ok {
+		hooks.Close()
 		file.Close()
-		s.reply(425, "Can't open data connection.")
+		cancel()
 		return
 	}
 	s.dataConn = conn
 
-	s.reply(150, "Opening data connection for APPE.")
-
-	ctx := s.startTransfer()
+	progress := s.startTransfer(ctx, cancel, "APPE", path, offset)
 	s.transferWG.Add(1)
 
 	go func() {
@@ -316,20 +560,37 @@ func (s *session) handleAPPE(path string) {
 		// Apply bandwidth limiting
 		src = s.rateLimitReader(src)
 
-		bytesTransferred, err := copyWithPooledBuffer(file, src)
+		bytesTransferred, err := s.server.copyWithPooledBuffer(progress.writer(policy.limitWriter(dst, existing)), src)
+		if hookErr := hooks.Close(); err == nil {
+			err = hookErr
+		}
 		if err != nil {
+			_, rejected := asTransferError(err)
 			select {
 			case <-ctx.Done():
+				s.abortUpload("APPE", path, path, false, bytesTransferred, time.Since(startTime))
 				s.reply(426, "Transfer aborted.")
 			default:
-				s.reply(426, "Connection closed; transfer aborted.")
+				if errors.Is(err, errUploadTooLarge) {
+					s.reply(552, "Exceeded storage allocation.")
+				} else if rejected {
+					s.replyTransferError("APPE", path, err)
+				} else {
+					s.abortUpload("APPE", path, path, false, bytesTransferred, time.Since(startTime))
+					s.reply(426, "Connection closed; transfer aborted.")
+				}
 			}
 			return
 		}
+		// Buffered data must be written out before the upload is complete
+		if err := file.Close(); err != nil {
+			s.reply(451, "Upload failed: "+err.Error())
+			return
+		}
 		duration := time.Since(startTime)
 
 		// Transfer logging
-		s.logTransfer("APPE", path, bytesTransferred, duration)
+		s.logTransfer("APPE", path, bytesTransferred, duration, true)
 
 		// Metrics collection
 		if s.server.metricsCollector != nil {
@@ -341,32 +602,63 @@ func (s *session) handleAPPE(path string) {
 	}()
 }
 
-func (s *session) handleSTOU(_ string) {
+func (s *session) handleSTOU(base string) {
 	if !s.isLoggedIn {
 		s.reply(530, "Not logged in.")
 		return
 	}
 
-	uuid := fmt.Sprintf("ftp-%d", time.Now().UnixNano())
-	path := uuid
+	policy := s.uploadPolicy()
+	if base != "" && !s.checkUploadName(policy, base) {
+		return
+	}
 
-	file, err := s.fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	// The name is chosen by the server and the file must not exist yet, so
+	// STOU never overwrites anything, even in write-only directories.
+	ctx, cancel := s.transferContext()
+	var path string
+	var file io.ReadWriteCloser
+	var err error
+	for attempt := range stouAttempts {
+		if path = s.uniqueName(base, attempt); path == "" {
+			break
+		}
+		file, err = s.fs.OpenFile(ctx, path, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if path == "" || os.IsExist(err) {
+		cancel()
+		s.reply(451, "Unable to generate a unique file name.")
+		return
+	}
 	if err != nil {
+		cancel()
 		s.replyError(err)
 		return
 	}
 
-	conn, err := s.connData()
+	dst, hooks, err := s.wrapUpload("STOU", path, 0, file)
 	if err != nil {
 		file.Close()
-		s.reply(425, "Can't open data connection.")
+		cancel()
+		s.discardUpload(true, path)
+		s.replyTransferError("STOU", path, err)
 		return
 	}
-	s.dataConn = conn
 
-	s.reply(150, fmt.Sprintf("FILE: %s", path))
+	// RFC 1123 Section 4.1.2.9: the preliminary reply gives the name
+	conn, ok := s.openDataConn("FILE: "+path, "FILE: "+path)
+	if !ok {
+		hooks.Close()
+		file.Close()
+		cancel()
+		return
+	}
+	s.dataConn = conn
 
-	ctx := s.startTransfer()
+	progress := s.startTransfer(ctx, cancel, "STOU", path, 0)
 	s.transferWG.Add(1)
 
 	go func() {
@@ -383,8 +675,25 @@ func (s *session) handleSTOU(_ string) {
 		// Apply bandwidth limiting
 		src = s.rateLimitReader(src)
 
-		bytesTransferred, err := copyWithPooledBuffer(file, src)
+		bytesTransferred, err := s.server.copyWithPooledBuffer(progress.writer(policy.limitWriter(dst, 0)), src)
+		if hookErr := hooks.Close(); err == nil {
+			err = hookErr
+		}
+		if errors.Is(err, errUploadTooLarge) {
+			file.Close()
+			s.discardUpload(true, path)
+			s.reply(552, "Exceeded storage allocation.")
+			return
+		}
+		if _, ok := asTransferError(err); ok {
+			file.Close()
+			s.discardUpload(true, path)
+			s.replyTransferError("STOU", path, err)
+			return
+		}
 		if err != nil {
+			file.Close()
+			s.abortUpload("STOU", path, path, true, bytesTransferred, time.Since(startTime))
 			select {
 			case <-ctx.Done():
 				s.reply(426, "Transfer aborted.")
@@ -393,10 +702,21 @@ func (s *session) handleSTOU(_ string) {
 			}
 			return
 		}
+		if policy.tooSmall(bytesTransferred) {
+			file.Close()
+			s.discardUpload(true, path)
+			s.reply(552, "File is smaller than the minimum allowed size.")
+			return
+		}
+		// Buffered data must be written out before the upload is complete
+		if err := file.Close(); err != nil {
+			s.reply(451, "Upload failed: "+err.Error())
+			return
+		}
 		duration := time.Since(startTime)
 
 		// Transfer logging
-		s.logTransfer("STOU", path, bytesTransferred, duration)
+		s.logTransfer("STOU", path, bytesTransferred, duration, true)
 
 		// Metrics collection
 		if s.server.metricsCollector != nil {
@@ -404,7 +724,9 @@ func (s *session) handleSTOU(_ string) {
 		}
 
 		s.endTransfer()
-		s.reply(226, "Transfer complete.")
+		// The name again, in the form of wu-ftpd, for clients that only
+		// read the final reply
+		s.reply(226, fmt.Sprintf("Transfer complete (unique file name: %s).", path))
 	}()
 }
 
@@ -432,34 +754,25 @@ func (s *session) handlePORT(arg string) {
 		return
 	}
 
-	// Format: h1,h2,h3,h4,p1,p2
-	parts := strings.Split(arg, ",")
-	if len(parts) != 6 {
-		s.reply(501, "Syntax error in parameters or arguments.")
-		return
-	}
-
-	p1, err1 := strconv.Atoi(parts[4])
-	p2, err2 := strconv.Atoi(parts[5])
-	if err1 != nil || err2 != nil || p1 < 0 || p1 > 255 || p2 < 0 || p2 > 255 {
-		s.reply(501, "Invalid port number.")
+	ip, port, perr := parsePORT(arg)
+	if perr != nil {
+		s.reply(perr.code, perr.message)
 		return
 	}
 
-	ipStr := strings.Join(parts[0:4], ".")
-	ip := net.ParseIP(ipStr)
-	if ip == nil {
-		s.reply(501, "Invalid IP address.")
+	switch s.checkActiveTarget("PORT", ip, port) {
+	case "":
+	case "address_family":
+		s.reply(522, "Network protocol not supported, use (2).")
 		return
-	}
-
-	if !s.validateActiveIP(ip) {
+	default:
 		s.reply(500, "Illegal PORT command.")
 		return
 	}
 
+	s.clearDataSetup()
 	s.activeIP = ip.String()
-	s.activePort = p1*256 + p2
+	s.activePort = port
 
 	s.reply(200, "PORT command successful.")
 }
@@ -478,14 +791,14 @@ func (s *session) listenPassive() (net.Listener, error) {
 			offset := (startOffset + i) % rangeLen
 			port := int(int32(minPort) + offset)
 
-			ln, err := s.server.listenerFactory.Listen("tcp", fmt.Sprintf(":%d", port))
+			ln, err := s.listenerFactory.Listen("tcp", fmt.Sprintf(":%d", port))
 			if err == nil {
 				return ln, nil
 			}
 		}
 		return nil, fmt.Errorf("no available ports in range [%d, %d]", minPort, maxPort)
 	}
-	return s.server.listenerFactory.Listen("tcp", ":0")
+	return s.listenerFactory.Listen("tcp", ":0")
 }
 
 func (s *session) handlePASV(_ string) {
@@ -494,8 +807,17 @@ func (s *session) handlePASV(_ string) {
 		return
 	}
 
-	if s.pasvList != nil {
-		s.pasvList.Close()
+	s.clearDataSetup()
+
+	ip, err := s.passiveIP()
+	if err != nil {
+		s.server.logger.Warn("passive_address_failed",
+			"session_id", s.sessionID,
+			"remote_ip", s.redactIP(s.remoteIP),
+			"error", err,
+		)
+		s.reply(425, "Can't open passive connection.")
+		return
 	}
 
 	ln, err := s.listenPassive()
@@ -503,44 +825,12 @@ func (s *session) handlePASV(_ string) {
 		s.reply(425, "Can't open passive connection.")
 		return
 	}
-	s.pasvList = ln
+	s.pasvList = newPassiveListener(ln)
 
 	_, portStr, _ := net.SplitHostPort(ln.Addr().String())
 	port, _ := strconv.Atoi(portStr)
 
-	// Determine IP to send
-	// 1. Get local connection IP
-	host, _, _ := net.SplitHostPort(s.conn.LocalAddr().String())
-
-	// 2. Override with PublicHost if set
-	settings := s.fs.GetSettings()
-	if settings != nil && settings.PublicHost != "" {
-		host = settings.PublicHost
-	}
-
-	// 3. Resolve to IPv4
-	ip := net.ParseIP(host)
-	if ip == nil {
-		// Use cached resolution if available
-		if host == s.lastPublicHost && s.resolvedIP != nil {
-			ip = s.resolvedIP
-		} else {
-			// Try resolving hostname
-			fileArgs, err := net.LookupIP(host)
-			if err == nil {
-				for _, resolvedIP := range fileArgs {
-					if ipv4 := resolvedIP.To4(); ipv4 != nil {
-						ip = ipv4
-						s.lastPublicHost = host
-						s.resolvedIP = ip
-						break
-					}
-				}
-			}
-		}
-	}
-
-	// 4. Format for PASV response (h1,h2,h3,h4)
+	// Format for PASV response (h1,h2,h3,h4)
 	var ipParts []string
 	if ip != nil && ip.To4() != nil {
 		ip = ip.To4()
@@ -564,16 +854,14 @@ func (s *session) handleEPSV(_ string) {
 		return
 	}
 
-	if s.pasvList != nil {
-		s.pasvList.Close()
-	}
+	s.clearDataSetup()
 
 	ln, err := s.listenPassive()
 	if err != nil {
 		s.reply(425, "Can't open passive connection.")
 		return
 	}
-	s.pasvList = ln
+	s.pasvList = newPassiveListener(ln)
 
 	_, portStr, _ := net.SplitHostPort(ln.Addr().String())
 	s.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%s|)", portStr))
@@ -585,70 +873,177 @@ func (s *session) handleEPRT(arg string) {
 		return
 	}
 
-	if len(arg) < 4 {
-		s.reply(501, "Syntax error in parameters or arguments.")
+	ip, port, perr := parseEPRT(arg)
+	if perr != nil {
+		s.reply(perr.code, perr.message)
 		return
 	}
 
-	delim := string(arg[0])
-	parts := strings.Split(arg, delim)
-
-	// Expected format: <delim><proto><delim><ip><delim><port><delim>
-	// Split results in: ["", "proto", "ip", "port", ""]
-	if len(parts) != 5 {
-		s.reply(501, "Syntax error in parameters or arguments.")
+	switch s.checkActiveTarget("EPRT", ip, port) {
+	case "":
+	case "address_family":
+		if ip.To4() != nil {
+			s.reply(522, "Network protocol not supported, use (2).")
+		} else {
+			s.reply(522, "Network protocol not supported, use (1).")
+		}
+		return
+	default:
+		s.reply(500, "Illegal EPRT command.")
 		return
 	}
 
-	// Protocol: 1 = IPv4, 2 = IPv6
-	proto := parts[1]
-	ipStr := parts[2]
-	portStr := parts[3]
+	s.clearDataSetup()
+	s.activeIP = ip.String()
+	s.activePort = port
 
-	// Validate IP
-	ip := net.ParseIP(ipStr)
-	if ip == nil {
-		s.reply(501, "Invalid network address.")
-		return
+	s.reply(200, "EPRT command successful.")
+}
+
+// activeAddrError is an invalid PORT or EPRT argument, with its reply.
+type activeAddrError struct {
+	code    int
+	message string
+}
+
+var (
+	errActiveSyntax  = &activeAddrError{501, "Syntax error in parameters or arguments."}
+	errActivePort    = &activeAddrError{501, "Invalid port number."}
+	errActiveAddress = &activeAddrError{501, "Invalid network address."}
+)
+
+// parsePORT parses the argument of PORT (RFC 959): h1,h2,h3,h4,p1,p2,
+// where each field is a decimal number from 0 to 255.
+func parsePORT(arg string) (net.IP, int, *activeAddrError) {
+	parts := strings.Split(arg, ",")
+	if len(parts) != 6 {
+		return nil, 0, errActiveSyntax
 	}
 
-	// Validate Protocol vs IP type
-	if proto == "1" && ip.To4() == nil {
-		s.reply(522, "Network protocol not supported, use (2).")
-		return
+	var fields [6]byte
+	for i, part := range parts {
+		v, ok := parseDecimal(part, 255)
+		if !ok {
+			if i < 4 {
+				return nil, 0, &activeAddrError{501, "Invalid IP address."}
+			}
+			return nil, 0, errActivePort
+		}
+		fields[i] = byte(v)
 	}
-	// if proto == "2" && ip.To4() != nil {
-	// 	// Strictly speaking, IPv4-mapped IPv6 is valid in Go, but RFC implies 2 is for IPv6.
-	// 	// We'll accept it but verify parsing.
-	// }
-	if proto != "1" && proto != "2" {
-		s.reply(522, "Network protocol not supported, use (1,2).")
-		return
+
+	port := int(fields[4])<<8 | int(fields[5])
+	if port == 0 {
+		return nil, 0, errActivePort
 	}
+	return net.IPv4(fields[0], fields[1], fields[2], fields[3]), port, nil
+}
 
-	port, err := strconv.Atoi(portStr)
-	if err != nil || port < 0 || port > 65535 {
-		s.reply(501, "Invalid port number.")
-		return
+// parseEPRT parses the argument of EPRT (RFC 2428):
+// <d><proto><d><address><d><port><d>, where <d> is a printable ASCII
+// delimiter, usually "|".
+func parseEPRT(arg string) (net.IP, int, *activeAddrError) {
+	if len(arg) < 4 || arg[0] < 33 || arg[0] > 126 {
+		return nil, 0, errActiveSyntax
 	}
 
-	if !s.validateActiveIP(ip) {
-		s.reply(500, "Illegal EPRT command.")
-		return
+	// Splitting gives ["", proto, address, port, ""]
+	parts := strings.Split(arg, arg[:1])
+	if len(parts) != 5 || parts[4] != "" {
+		return nil, 0, errActiveSyntax
 	}
+	proto, ipStr, portStr := parts[1], parts[2], parts[3]
 
-	s.activeIP = ip.String()
-	s.activePort = port
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return nil, 0, errActiveAddress
+	}
 
-	s.reply(200, "EPRT command successful.")
+	// Protocol 2 requires an address in IPv6 notation; IPv4-mapped
+	// addresses ("::ffff:192.0.2.1") are IPv4.
+	switch proto {
+	case "1":
+		if ip.To4() == nil || strings.Contains(ipStr, ":") {
+			return nil, 0, errActiveAddress
+		}
+	case "2":
+		if !strings.Contains(ipStr, ":") {
+			return nil, 0, errActiveAddress
+		}
+	default:
+		return nil, 0, &activeAddrError{522, "Network protocol not supported, use (1,2)."}
+	}
+
+	port, ok := parseDecimal(portStr, 65535)
+	if !ok || port < 1 {
+		return nil, 0, errActivePort
+	}
+	return ip, port, nil
+}
+
+// parseDecimal parses a non-negative decimal number up to limit, without
+// the signs and other forms strconv.Atoi accepts.
+func parseDecimal(s string, limit int) (int, bool) {
+	if s == "" || len(s) > len(strconv.Itoa(limit)) {
+		return 0, false
+	}
+	v := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+		v = v*10 + int(s[i]-'0')
+	}
+	return v, v <= limit
 }
 
 func (s *session) handleREST(arg string) {
 	offset, err := strconv.ParseInt(arg, 10, 64)
-	if err != nil {
+	if err != nil || offset < 0 {
 		s.reply(501, "Invalid offset.")
 		return
 	}
 	s.restartOffset = offset
-	s.reply(350, fmt.Sprintf("Restarting at %d. Send STOR or RETR to initiate transfer.", offset))
+	s.hasRange = false
+	s.reply(350, fmt.Sprintf("Restarting at %d. Send STOR, APPE or RETR to initiate transfer.", offset))
+}
+
+// openDownload opens path for RETR, positioned at offset. Partial reads use
+// the driver's Ranger if it has one, and seek the file otherwise. On failure
+// it replies to the client and returns false.
+func (s *session) openDownload(ctx context.Context, path string, offset, length int64) (io.ReadCloser, bool) {
+	if (offset > 0 || length >= 0) && s.caps.openRange != nil {
+		file, err := s.caps.openRange(ctx, path, offset, length)
+		if err != nil {
+			s.replyError(err)
+			return nil, false
+		}
+		return file, true
+	}
+
+	file, err := s.fs.OpenFile(ctx, path, os.O_RDONLY)
+	if err != nil {
+		s.replyError(err)
+		return nil, false
+	}
+	if offset > 0 && !s.seekRestart(file, offset) {
+		file.Close()
+		return nil, false
+	}
+	return file, true
+}
+
+// seekRestart positions file at the restart offset for a resumed transfer.
+// On failure it replies to the client and returns false.
+func (s *session) seekRestart(file io.ReadWriteCloser, offset int64) bool {
+	seeker, ok := file.(io.Seeker)
+	if !ok {
+		s.reply(550, "Resume not supported for this file.")
+		return false
+	}
+	if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+		s.replyError(err)
+		return false
+	}
+	return true
 }
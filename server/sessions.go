@@ -0,0 +1,166 @@
+package server
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// SessionInfo describes a client session, as returned by Server.Sessions.
+type SessionInfo struct {
+	// ID is the session ID used in log messages
+	ID string
+
+	// RemoteIP is the client's IP address
+	RemoteIP string
+
+	// LocalIP is the server address the client connected to, which tells
+	// the interfaces of a multi-homed server apart
+	LocalIP string
+
+	// User is the user name sent with USER, if any
+	User string
+
+	// Host is the host name sent with HOST, if any
+	Host string
+
+	// LoggedIn reports whether the user has authenticated
+	LoggedIn bool
+
+	// Transfer is the transfer in progress, or nil if the session is idle
+	Transfer *TransferInfo
+}
+
+// TransferInfo describes a file transfer in progress.
+type TransferInfo struct {
+	// Command is the transfer command: RETR, STOR, APPE or STOU
+	Command string
+
+	// Path is the file being transferred
+	Path string
+
+	// Offset is the restart offset the transfer started at
+	Offset int64
+
+	// Bytes is the number of bytes transferred so far
+	Bytes int64
+
+	// Started is when the transfer started
+	Started time.Time
+
+	// LastActivity is when data was last transferred. A transfer with no
+	// activity for a long time is likely stuck.
+	LastActivity time.Time
+}
+
+// Rate returns the average transfer rate in bytes per second.
+func (t TransferInfo) Rate() float64 {
+	elapsed := time.Since(t.Started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(t.Bytes) / elapsed
+}
+
+// transferProgress tracks a transfer in progress. It is updated by the
+// transfer goroutine and read by STAT and Server.Sessions.
+type transferProgress struct {
+	command string
+	path    string
+	offset  int64
+	started time.Time
+
+	bytes        atomic.Int64
+	lastActivity atomic.Int64 // UnixNano
+}
+
+// newTransferProgress starts tracking a transfer.
+func newTransferProgress(command, path string, offset int64) *transferProgress {
+	p := &transferProgress{command: command, path: path, offset: offset, started: time.Now()}
+	p.lastActivity.Store(p.started.UnixNano())
+	return p
+}
+
+// writer returns w, counting the bytes written to it.
+func (p *transferProgress) writer(w io.Writer) io.Writer {
+	return &progressWriter{w: w, p: p}
+}
+
+// add records n more bytes transferred.
+func (p *transferProgress) add(n int64) {
+	if n > 0 {
+		p.bytes.Add(n)
+		p.lastActivity.Store(time.Now().UnixNano())
+	}
+}
+
+// info returns a snapshot of the transfer.
+func (p *transferProgress) info() *TransferInfo {
+	return &TransferInfo{
+		Command:      p.command,
+		Path:         p.path,
+		Offset:       p.offset,
+		Bytes:        p.bytes.Load(),
+		Started:      p.started,
+		LastActivity: time.Unix(0, p.lastActivity.Load()),
+	}
+}
+
+// progressWriter counts the bytes written through it.
+type progressWriter struct {
+	w io.Writer
+	p *transferProgress
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.add(int64(n))
+	return n, err
+}
+
+// info returns a snapshot of the session.
+func (s *session) info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := SessionInfo{
+		ID:       s.sessionID,
+		RemoteIP: s.remoteIP,
+		LocalIP:  s.localIP,
+		User:     s.user,
+		Host:     s.host,
+		LoggedIn: s.isLoggedIn,
+	}
+	if s.transfer != nil {
+		info.Transfer = s.transfer.info()
+	}
+	return info
+}
+
+// Sessions returns the sessions currently connected, with the progress of
+// their transfers. Use it to monitor the server, e.g. to find stalled
+// uploads:
+//
+//	for _, sess := range s.Sessions() {
+//	    if t := sess.Transfer; t != nil && time.Since(t.LastActivity) > time.Minute {
+//	        log.Printf("%s: %s %s stalled at %d bytes", sess.User, t.Command, t.Path, t.Bytes)
+//	    }
+//	}
+func (s *Server) Sessions() []SessionInfo {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	infos := make([]SessionInfo, 0, len(s.sessions))
+	for sess := range s.sessions {
+		infos = append(infos, sess.info())
+	}
+	return infos
+}
+
+// recordSessions reports the number of sessions to the metrics collector,
+// if it implements SessionMetricsCollector. The caller must hold sessionsMu.
+func (s *Server) recordSessions() {
+	if sc, ok := s.metricsCollector.(SessionMetricsCollector); ok {
+		sc.RecordSessions(len(s.sessions))
+	}
+}
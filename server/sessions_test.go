@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestSessionsAndTransferProgress(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return rootDir, false, nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+	s := startServer(t, ln, WithDriver(driver))
+
+	tc, err := rawLogin(ln.Addr().String(), "alice", "secret")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	dataAddr, err := rawEnterPasv(tc)
+	fatalIfErr(t, err, "PASV failed")
+	fmt.Fprintf(tc, "STOR upload.bin\r\n")
+	dataConn, err := net.Dial("tcp", dataAddr)
+	fatalIfErr(t, err, "Failed to dial data connection")
+	if code, msg, err := rawReadResponse(tc); err != nil || !isPreliminary(code) {
+		t.Fatalf("STOR failed: %q %v", msg, err)
+	}
+
+	_, err = dataConn.Write(make([]byte, 1000))
+	fatalIfErr(t, err, "Write failed")
+
+	// The transfer is visible while in progress
+	var transfer *TransferInfo
+	waitFor(t, func() bool {
+		sessions := s.Sessions()
+		if len(sessions) != 1 {
+			return false
+		}
+		transfer = sessions[0].Transfer
+		return transfer != nil && transfer.Bytes == 1000
+	}, "transfer not reported")
+	info := s.Sessions()[0]
+	if info.User != "alice" || !info.LoggedIn || info.RemoteIP != "127.0.0.1" {
+		t.Errorf("session = %+v", info)
+	}
+	if transfer.Command != "STOR" || transfer.Path != "upload.bin" || transfer.Started.IsZero() ||
+		transfer.LastActivity.Before(transfer.Started) {
+		t.Errorf("transfer = %+v", transfer)
+	}
+
+	// STAT reports it too
+	fmt.Fprintf(tc, "STAT\r\n")
+	var stat strings.Builder
+	for {
+		code, line, err := rawReadResponse(tc)
+		fatalIfErr(t, err, "STAT failed")
+		stat.WriteString(line)
+		if code == 211 && strings.HasPrefix(line, "211 ") {
+			break
+		}
+	}
+	if !strings.Contains(stat.String(), "STOR upload.bin: 1000 bytes transferred") {
+		t.Errorf("STAT reply does not report the transfer:\n%s", stat.String())
+	}
+
+	dataConn.Close()
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 226 {
+		t.Fatalf("STOR did not complete: %q %v", msg, err)
+	}
+	if sessions := s.Sessions(); len(sessions) != 1 || sessions[0].Transfer != nil {
+		t.Errorf("sessions after transfer = %+v", sessions)
+	}
+
+	tc.Close()
+	waitFor(t, func() bool { return len(s.Sessions()) == 0 }, "session not removed")
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// SocketOptions tunes the TCP sockets of data connections. High
+// bandwidth-delay-product links (fast and far away) need larger buffers to
+// reach line rate. Zero values keep the system defaults.
+type SocketOptions struct {
+	// ReadBuffer sets the receive buffer size (SO_RCVBUF), used by uploads
+	ReadBuffer int
+
+	// WriteBuffer sets the send buffer size (SO_SNDBUF), used by downloads
+	WriteBuffer int
+
+	// Nagle enables Nagle's algorithm by clearing TCP_NODELAY, which Go sets
+	// on all TCP connections by default
+	Nagle bool
+
+	// KeepAlive sets the TCP keep-alive period. Negative values disable
+	// keep-alives.
+	KeepAlive time.Duration
+}
+
+// apply sets the options on a data connection. Connections that are not
+// TCP (e.g. from a custom ListenerFactory) are left unchanged.
+func (o *SocketOptions) apply(conn net.Conn) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	if o.ReadBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(o.ReadBuffer); err != nil {
+			return fmt.Errorf("failed to set receive buffer: %w", err)
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return fmt.Errorf("failed to set send buffer: %w", err)
+		}
+	}
+	if o.Nagle {
+		if err := tcpConn.SetNoDelay(false); err != nil {
+			return fmt.Errorf("failed to set TCP_NODELAY: %w", err)
+		}
+	}
+	if o.KeepAlive != 0 {
+		if err := tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{Enable: o.KeepAlive > 0, Idle: o.KeepAlive, Interval: o.KeepAlive}); err != nil {
+			return fmt.Errorf("failed to set keep-alive: %w", err)
+		}
+	}
+	return nil
+}
+
+// newBufferPool returns a pool of transfer buffers of the given size.
+func newBufferPool(size int) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		},
+	}
+}
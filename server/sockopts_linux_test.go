@@ -0,0 +1,38 @@
+//go:build linux
+
+package server
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestSocketOptions_SendBuffer(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Listen failed")
+	defer ln.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	fatalIfErr(t, err, "Dial failed")
+	defer conn.Close()
+
+	opts := &SocketOptions{WriteBuffer: 64 << 10}
+	fatalIfErr(t, opts.apply(conn), "apply failed")
+
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	fatalIfErr(t, err, "SyscallConn failed")
+	var size int
+	var serr error
+	fatalIfErr(t, raw.Control(func(fd uintptr) {
+		size, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	}), "Control failed")
+	fatalIfErr(t, serr, "getsockopt failed")
+
+	// Linux doubles the requested value to account for bookkeeping
+	if size < 64<<10 {
+		t.Errorf("SO_SNDBUF = %d, want at least %d", size, 64<<10)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingWriter records the size of each write.
+type countingWriter struct {
+	sizes []int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.sizes = append(w.sizes, len(p))
+	return len(p), nil
+}
+
+func TestWithTransferBufferSize(t *testing.T) {
+	t.Parallel()
+	driver, _ := NewFSDriver(t.TempDir())
+
+	if _, err := NewServer(":0", WithDriver(driver), WithTransferBufferSize(0)); err == nil {
+		t.Error("Expected error for zero buffer size")
+	}
+
+	s, err := NewServer(":0", WithDriver(driver), WithTransferBufferSize(1024))
+	fatalIfErr(t, err, "NewServer failed")
+
+	// Hide the reader's WriterTo so the buffer is used
+	src := struct{ io.Reader }{strings.NewReader(strings.Repeat("x", 3000))}
+	var w countingWriter
+	n, err := s.copyWithPooledBuffer(&w, src)
+	fatalIfErr(t, err, "copy failed")
+	if n != 3000 {
+		t.Errorf("copied %d bytes, want 3000", n)
+	}
+	for _, size := range w.sizes {
+		if size > 1024 {
+			t.Errorf("write of %d bytes exceeds the buffer size", size)
+		}
+	}
+}
+
+func TestSocketOptions(t *testing.T) {
+	t.Parallel()
+	driver, _ := NewFSDriver(t.TempDir())
+
+	if _, err := NewServer(":0", WithDriver(driver), WithDataSocketOptions(SocketOptions{ReadBuffer: -1})); err == nil {
+		t.Error("Expected error for negative buffer size")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Listen failed")
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			_, _ = io.Copy(io.Discard, conn)
+			conn.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	fatalIfErr(t, err, "Dial failed")
+	defer conn.Close()
+
+	opts := &SocketOptions{ReadBuffer: 1 << 20, WriteBuffer: 1 << 20, Nagle: true, KeepAlive: 30 * time.Second}
+	fatalIfErr(t, opts.apply(conn), "apply failed")
+
+	// Non-TCP connections are left alone
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	fatalIfErr(t, opts.apply(p1), "apply on pipe failed")
+}
+
+func TestDataSocketOptions_Transfer(t *testing.T) {
+	t.Parallel()
+	c, _ := setupWrappedServer(t, nil,
+		WithTransferBufferSize(4096),
+		WithDataSocketOptions(SocketOptions{WriteBuffer: 256 << 10, ReadBuffer: 256 << 10, KeepAlive: time.Minute}))
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	fatalIfErr(t, c.Store("file.bin", bytes.NewReader(data)), "Store failed")
+
+	var buf bytes.Buffer
+	fatalIfErr(t, c.Retrieve("file.bin", &buf), "Retrieve failed")
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("retrieved %d bytes, want %d", buf.Len(), len(data))
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// statCacheMaxEntries bounds the size of a session's stat cache. When it is
+// full, the cache is emptied rather than evicting entries one by one.
+const statCacheMaxEntries = 10000
+
+// statInvalidatingCommands are the commands that may change file metadata.
+// They clear the session's stat cache.
+var statInvalidatingCommands = map[string]bool{
+	"STOR": true,
+	"STOU": true,
+	"APPE": true,
+	"DELE": true,
+	"RNTO": true,
+	"MKD":  true,
+	"XMKD": true,
+	"RMD":  true,
+	"XRMD": true,
+	"MFMT": true,
+	"MFCT": true,
+	"MFF":  true,
+	"COMB": true,
+	"SITE": true,
+}
+
+// statCache is a per-session cache of file metadata with a TTL.
+// A nil *statCache is valid and caches nothing.
+type statCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]statCacheEntry
+}
+
+type statCacheEntry struct {
+	info    StatInfo
+	expires time.Time
+}
+
+// newStatCache returns a cache with the given TTL, or nil if ttl is zero.
+func newStatCache(ttl time.Duration) *statCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &statCache{ttl: ttl, entries: make(map[string]statCacheEntry)}
+}
+
+// get returns the cached metadata for an absolute path, if not expired.
+func (c *statCache) get(key string) (StatInfo, bool) {
+	if c == nil {
+		return StatInfo{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return StatInfo{}, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return StatInfo{}, false
+	}
+	return e.info, true
+}
+
+// put caches the metadata of an absolute path.
+func (c *statCache) put(key string, info StatInfo) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= statCacheMaxEntries {
+		clear(c.entries)
+	}
+	c.entries[key] = statCacheEntry{info: info, expires: time.Now().Add(c.ttl)}
+}
+
+// clear removes all cached metadata.
+func (c *statCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
+
+// statInfoFromFileInfo converts an os.FileInfo to a StatInfo.
+func statInfoFromFileInfo(info os.FileInfo) StatInfo {
+	return StatInfo{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+		Symlink: info.Mode()&os.ModeSymlink != 0,
+	}
+}
+
+// statKey returns the absolute virtual path used as cache key for p, or
+// false if it cannot be determined.
+func (s *session) statKey(p string) (string, bool) {
+	if s.statCache == nil {
+		return "", false
+	}
+	return s.absolutePath(p)
+}
+
+// absolutePath returns p as a clean absolute virtual path, resolving
+// relative paths against the working directory. It returns false if the
+// working directory cannot be determined.
+func (s *session) absolutePath(p string) (string, bool) {
+	if path.IsAbs(p) {
+		return path.Clean(p), true
+	}
+	cwd, err := s.fs.GetWd(s.context())
+	if err != nil {
+		return "", false
+	}
+	return path.Join("/", cwd, p), true
+}
+
+// stat returns the metadata used by SIZE, MDTM and MLST, from the cache, the
+// driver's StatExtended implementation or GetFileInfo.
+func (s *session) stat(p string) (StatInfo, error) {
+	key, cacheable := s.statKey(p)
+	if cacheable {
+		if info, ok := s.statCache.get(key); ok {
+			return info, nil
+		}
+	}
+
+	var info StatInfo
+	if se, ok := s.driverFS.(StatExtended); ok {
+		var err error
+		if info, err = se.StatObject(p); err != nil {
+			return StatInfo{}, err
+		}
+		if info.Name == "" {
+			info.Name = path.Base(p)
+		}
+	} else {
+		fi, err := s.fs.GetFileInfo(s.context(), p)
+		if err != nil {
+			return StatInfo{}, err
+		}
+		info = statInfoFromFileInfo(fi)
+	}
+
+	if cacheable {
+		s.statCache.put(key, info)
+	}
+	return info, nil
+}
+
+// cacheListedEntry caches the metadata of an entry seen in a listing of dir.
+// Symbolic links are not cached, as SIZE and MDTM report their target.
+func (s *session) cacheListedEntry(dir string, info os.FileInfo) {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return
+	}
+	if name := info.Name(); name != "." && name != ".." {
+		if key, ok := s.statKey(path.Join(dir, name)); ok {
+			s.statCache.put(key, statInfoFromFileInfo(info))
+		}
+	}
+}
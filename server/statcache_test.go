@@ -0,0 +1,129 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStatCache(t *testing.T) {
+	t.Parallel()
+
+	var nilCache *statCache
+	nilCache.put("/a", StatInfo{Size: 1})
+	if _, ok := nilCache.get("/a"); ok {
+		t.Error("nil cache should not return entries")
+	}
+	nilCache.clear()
+
+	if newStatCache(0) != nil {
+		t.Error("zero TTL should disable the cache")
+	}
+
+	c := newStatCache(50 * time.Millisecond)
+	c.put("/a", StatInfo{Name: "a", Size: 42})
+	if info, ok := c.get("/a"); !ok || info.Size != 42 {
+		t.Errorf("get = %+v, %v; want size 42", info, ok)
+	}
+
+	c.clear()
+	if _, ok := c.get("/a"); ok {
+		t.Error("entry still cached after clear")
+	}
+
+	c.put("/a", StatInfo{Name: "a", Size: 42})
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := c.get("/a"); ok {
+		t.Error("entry still cached after TTL")
+	}
+}
+
+// statCountingDriver wraps a driver so its contexts implement StatExtended
+// and count the calls.
+type statCountingDriver struct {
+	Driver
+	calls *atomic.Int32
+}
+
+func (d *statCountingDriver) Authenticate(user, pass, host string, remoteIP net.IP) (ClientContext, error) {
+	ctx, err := d.Driver.Authenticate(user, pass, host, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	return &statCountingContext{ClientContext: ctx, calls: d.calls}, nil
+}
+
+type statCountingContext struct {
+	ClientContext
+	calls *atomic.Int32
+}
+
+func (c *statCountingContext) StatObject(path string) (StatInfo, error) {
+	c.calls.Add(1)
+	info, err := c.GetFileInfo(path)
+	if err != nil {
+		return StatInfo{}, err
+	}
+	return StatInfo{Size: info.Size(), ModTime: info.ModTime(), IsDir: info.IsDir()}, nil
+}
+
+func TestStatExtendedAndCache(t *testing.T) {
+	t.Parallel()
+
+	t.Run("uncached", func(t *testing.T) {
+		t.Parallel()
+		calls := &atomic.Int32{}
+		c, rootDir := setupWrappedServer(t, func(d Driver) Driver { return &statCountingDriver{d, calls} })
+		fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "a.txt"), []byte("hello"), 0644), "WriteFile failed")
+
+		for range 2 {
+			size, err := c.Size("a.txt")
+			fatalIfErr(t, err, "SIZE failed")
+			if size != 5 {
+				t.Errorf("SIZE = %d, want 5", size)
+			}
+		}
+		if n := calls.Load(); n != 2 {
+			t.Errorf("StatObject called %d times, want 2", n)
+		}
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		t.Parallel()
+		calls := &atomic.Int32{}
+		c, rootDir := setupWrappedServer(t, func(d Driver) Driver { return &statCountingDriver{d, calls} }, WithStatCacheTTL(time.Minute))
+		fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "a.txt"), []byte("hello"), 0644), "WriteFile failed")
+		fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "b.txt"), []byte("hi"), 0644), "WriteFile failed")
+
+		_, err := c.Size("a.txt")
+		fatalIfErr(t, err, "SIZE failed")
+		_, err = c.ModTime("/a.txt")
+		fatalIfErr(t, err, "MDTM failed")
+		_, err = c.MLStat("a.txt")
+		fatalIfErr(t, err, "MLST failed")
+		if n := calls.Load(); n != 1 {
+			t.Errorf("StatObject called %d times, want 1", n)
+		}
+
+		// Listed entries are cached
+		_, err = c.List("")
+		fatalIfErr(t, err, "LIST failed")
+		size, err := c.Size("b.txt")
+		fatalIfErr(t, err, "SIZE failed")
+		if size != 2 || calls.Load() != 1 {
+			t.Errorf("SIZE b.txt = %d after %d stats, want 2 from the listing", size, calls.Load())
+		}
+
+		// Uploads invalidate the cache
+		fatalIfErr(t, c.Store("a.txt", strings.NewReader("hello, world")), "STOR failed")
+		size, err = c.Size("a.txt")
+		fatalIfErr(t, err, "SIZE failed")
+		if size != 12 {
+			t.Errorf("SIZE after STOR = %d, want 12", size)
+		}
+	})
+}
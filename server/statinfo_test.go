@@ -0,0 +1,96 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/server"
+)
+
+// linkDriver is a driver from outside the package, whose contexts report
+// the paths ending in ".lnk" as symbolic links.
+type linkDriver struct {
+	server.Driver
+}
+
+func (d linkDriver) Authenticate(user, pass, host string, remoteIP net.IP) (server.ClientContext, error) {
+	ctx, err := d.Driver.Authenticate(user, pass, host, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	return linkContext{ctx}, nil
+}
+
+type linkContext struct {
+	server.ClientContext
+}
+
+func (c linkContext) StatObject(path string) (server.StatInfo, error) {
+	info, err := c.GetFileInfo(path)
+	if err != nil {
+		return server.StatInfo{}, err
+	}
+	return server.StatInfo{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+		Symlink: strings.HasSuffix(path, ".lnk"),
+	}, nil
+}
+
+func TestStatExtended_Symlink(t *testing.T) {
+	t.Parallel()
+	rootDir := t.TempDir()
+	for _, name := range []string{"data.lnk", "data.txt"} {
+		if err := os.WriteFile(filepath.Join(rootDir, name), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsDriver, err := server.NewFSDriver(rootDir, server.WithAuthenticator(func(string, string, string, net.IP) (string, bool, error) {
+		return rootDir, false, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.NewServer(ln.Addr().String(), server.WithDriver(linkDriver{fsDriver}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(ln) }()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	}()
+
+	c, err := ftp.Dial(ln.Addr().String(), ftp.WithTimeout(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Quit() }()
+	if err := c.Login("test", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{"data.lnk": "os.unix=slink", "data.txt": "file"} {
+		entry, err := c.MLStat(name)
+		if err != nil {
+			t.Fatalf("MLST %s failed: %v", name, err)
+		}
+		if entry.Type != want {
+			t.Errorf("MLST %s: type = %q, want %q", name, entry.Type, want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UniqueNameFunc returns a name for a file stored with STOU. base is the
+// argument of the command, empty if the client sent none: RFC 959 defines
+// STOU without one, but some clients send the name they would prefer.
+// attempt is 0 for the first name and counts the names already taken. The
+// server creates the file through the driver only if it does not exist yet,
+// and asks for another name while it does.
+//
+// Names are relative to the session's working directory unless they are
+// absolute. Returning an empty name fails the command with 451.
+type UniqueNameFunc func(session SessionInfo, base string, attempt int) string
+
+// UniqueNameTemplate returns a UniqueNameFunc for names made of prefix, a
+// unique number and suffix, such as "upload-1767366245123456789.dat" for
+// UniqueNameTemplate("upload-", ".dat"). The argument of STOU is ignored.
+// The default names are those of UniqueNameTemplate("ftp-", "").
+func UniqueNameTemplate(prefix, suffix string) UniqueNameFunc {
+	return func(_ SessionInfo, _ string, attempt int) string {
+		unique := strconv.FormatInt(time.Now().UnixNano(), 10)
+		if attempt > 0 {
+			// Coarse clocks may give the same number again
+			unique += "-" + strconv.Itoa(attempt)
+		}
+		return prefix + unique + suffix
+	}
+}
+
+// NumberedUniqueName is a UniqueNameFunc that stores into the name given
+// as the argument of STOU if it is free, and otherwise appends a number to
+// it, as wu-ftpd does: "report.txt", then "report.txt.1", "report.txt.2"
+// and so on. Without an argument, it gives the default names.
+func NumberedUniqueName(session SessionInfo, base string, attempt int) string {
+	if base == "" {
+		return defaultUniqueName(session, base, attempt)
+	}
+	if attempt == 0 {
+		return base
+	}
+	return base + "." + strconv.Itoa(attempt)
+}
+
+// defaultUniqueName generates STOU names when WithUniqueNames is not set.
+var defaultUniqueName = UniqueNameTemplate("ftp-", "")
+
+// stouAttempts is the number of names STOU tries before giving up.
+const stouAttempts = 100
+
+// uniqueName returns the name to try for the attempt-th time for a STOU
+// command with argument base, or "" if the name cannot be used in a reply.
+func (s *session) uniqueName(base string, attempt int) string {
+	fn := s.server.uniqueName
+	if fn == nil {
+		fn = defaultUniqueName
+	}
+	name := fn(s.info(), base, attempt)
+	// The name is sent back in the preliminary reply, alone on its line
+	if strings.ContainsAny(name, "\r\n\x00") {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUniqueNames(t *testing.T) {
+	t.Parallel()
+
+	name := UniqueNameTemplate("scan-", ".pdf")(SessionInfo{}, "", 0)
+	if !strings.HasPrefix(name, "scan-") || !strings.HasSuffix(name, ".pdf") {
+		t.Errorf("UniqueNameTemplate name = %q", name)
+	}
+	if retry := UniqueNameTemplate("scan-", ".pdf")(SessionInfo{}, "", 2); !strings.HasSuffix(retry, "-2.pdf") {
+		t.Errorf("UniqueNameTemplate retry = %q, want a -2 suffix", retry)
+	}
+
+	for attempt, want := range []string{"report.txt", "report.txt.1", "report.txt.2"} {
+		if got := NumberedUniqueName(SessionInfo{}, "report.txt", attempt); got != want {
+			t.Errorf("NumberedUniqueName(%d) = %q, want %q", attempt, got, want)
+		}
+	}
+	if got := NumberedUniqueName(SessionInfo{}, "", 0); !strings.HasPrefix(got, "ftp-") {
+		t.Errorf("NumberedUniqueName without a base = %q, want a default name", got)
+	}
+}
+
+func TestSTOU_Collisions(t *testing.T) {
+	t.Parallel()
+
+	// The first names are taken, so the driver's exclusive create fails
+	// until a free one comes up.
+	var allTaken atomic.Bool
+	namer := func(_ SessionInfo, _ string, attempt int) string {
+		if attempt < 2 || allTaken.Load() {
+			return "taken.dat"
+		}
+		return "free.dat"
+	}
+	c, rootDir := setupWrappedServer(t, nil, WithUniqueNames(namer))
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "taken.dat"), []byte("old"), 0644), "Failed to create file")
+
+	name, err := c.StoreUnique(strings.NewReader("new"))
+	fatalIfErr(t, err, "StoreUnique failed")
+	if name != "free.dat" {
+		t.Errorf("StoreUnique stored into %q, want free.dat", name)
+	}
+	for file, want := range map[string]string{"taken.dat": "old", "free.dat": "new"} {
+		got, err := os.ReadFile(filepath.Join(rootDir, file))
+		fatalIfErr(t, err, "Failed to read "+file)
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", file, got, want)
+		}
+	}
+
+	// All names taken
+	allTaken.Store(true)
+	if _, err := c.StoreUnique(strings.NewReader("lost")); err == nil || !strings.Contains(err.Error(), "451") {
+		t.Errorf("StoreUnique with no free name = %v, want 451", err)
+	}
+}
+
+func TestSTOU_NumberedNames(t *testing.T) {
+	t.Parallel()
+
+	driver, rootDir := newTestFSDriver(t)
+	for _, name := range []string{"report.txt", "report.txt.1"} {
+		fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, name), []byte("old"), 0644), "Failed to create file")
+	}
+	addr := serveDriver(t, driver,
+		WithUniqueNames(NumberedUniqueName),
+		WithUploadPolicy(UploadPolicy{AllowedExtensions: []string{".txt"}}),
+	)
+
+	tc, err := rawLogin(addr, "test", "test")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+
+	// stou sends data with STOU and returns both replies.
+	stou := func(arg, data string) (preliminary, final string) {
+		t.Helper()
+		dataAddr, err := rawEnterPasv(tc)
+		fatalIfErr(t, err, "PASV failed")
+		dataConn, err := net.DialTimeout("tcp", dataAddr, 5*time.Second)
+		fatalIfErr(t, err, "Failed to dial data port")
+		defer dataConn.Close()
+
+		fmt.Fprintf(tc, "STOU %s\r\n", arg)
+		code, msg, err := rawReadResponse(tc)
+		fatalIfErr(t, err, "Failed to read reply to STOU")
+		preliminary = strings.TrimSpace(msg)
+		if !isPreliminary(code) {
+			return preliminary, ""
+		}
+		_, err = io.WriteString(dataConn, data)
+		fatalIfErr(t, err, "Failed to write data")
+		dataConn.Close()
+		code, msg, err = rawReadResponse(tc)
+		fatalIfErr(t, err, "Failed to read transfer reply")
+		return preliminary, strings.TrimSpace(msg)
+	}
+
+	preliminary, final := stou("report.txt", "new")
+	// 125, as the data connection is open
+	if preliminary != "125 FILE: report.txt.2" {
+		t.Errorf("Preliminary reply = %q, want 125 FILE: report.txt.2", preliminary)
+	}
+	if !strings.HasPrefix(final, "226 ") || !strings.Contains(final, "unique file name: report.txt.2") {
+		t.Errorf("Final reply = %q, want 226 naming report.txt.2", final)
+	}
+	got, err := os.ReadFile(filepath.Join(rootDir, "report.txt.2"))
+	fatalIfErr(t, err, "Failed to read upload")
+	if !bytes.Equal(got, []byte("new")) {
+		t.Errorf("report.txt.2 = %q, want %q", got, "new")
+	}
+
+	// The client's name must pass the upload policy
+	if preliminary, _ := stou("report.exe", "new"); !strings.HasPrefix(preliminary, "553 ") {
+		t.Errorf("STOU report.exe: got %q, want 553", preliminary)
+	}
+}
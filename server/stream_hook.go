@@ -0,0 +1,245 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// StreamHook wraps the data stream of transfers, to process it on the fly:
+// computing checksums of uploads, scanning content, or compressing stored
+// files. Hooks are set with WithStreamHooks; a driver's ClientContext can
+// also implement StreamHook, in which case it is applied closest to the
+// file.
+//
+// Hooks see the file data: ASCII mode conversion happens between the hooks
+// and the data connection. Zero-copy downloads are disabled while hooks are
+// set.
+//
+// An error returned by a hook, or by the Write, Read or Close methods of
+// its wrappers, fails the transfer. A *TransferError selects the reply;
+// other errors are reported with 451. Rejected new uploads are deleted.
+type StreamHook interface {
+	// WrapUpload returns the writer the data of a STOR, APPE or STOU is
+	// written to, which must write the processed data to w. It is called
+	// before the file is opened, so it must not write to w yet. Close is
+	// called at the end of the transfer, whether it succeeded or not,
+	// before the file is closed.
+	WrapUpload(info *StreamInfo, w io.Writer) (io.WriteCloser, error)
+
+	// WrapDownload returns the reader the data of a RETR is read from,
+	// which must read the data of the file from r. Close is called at the
+	// end of the transfer, before the file is closed.
+	WrapDownload(info *StreamInfo, r io.Reader) (io.ReadCloser, error)
+}
+
+// StreamInfo describes the transfer whose data a StreamHook wraps.
+type StreamInfo struct {
+	// SessionID is the session ID used in log messages
+	SessionID string
+
+	// RemoteIP is the client's IP address
+	RemoteIP string
+
+	// User is the authenticated user
+	User string
+
+	// Command is the transfer command: RETR, STOR, APPE or STOU
+	Command string
+
+	// Path is the file being transferred
+	Path string
+
+	// Offset is the restart offset the transfer started at
+	Offset int64
+
+	bytes atomic.Int64
+}
+
+// Bytes returns the number of bytes that have gone through the hooks so
+// far: received from the client for uploads, or sent to it for downloads.
+func (i *StreamInfo) Bytes() int64 {
+	return i.bytes.Load()
+}
+
+// TransferError fails a transfer with a specific reply, when returned by a
+// StreamHook. Code should be a 4xx or 5xx reply code, such as 451 (local
+// error), 552 (exceeded storage allocation) or 553 (file name not allowed);
+// other codes are replaced with 451.
+type TransferError struct {
+	Code    int
+	Message string
+}
+
+// Error implements the error interface.
+func (e *TransferError) Error() string {
+	return e.Message
+}
+
+// reply returns the reply to send for e.
+func (e *TransferError) reply() (int, string) {
+	if e.Code < 400 || e.Code > 599 {
+		return 451, e.Message
+	}
+	return e.Code, e.Message
+}
+
+// asTransferError returns the TransferError in err's chain, if any.
+func asTransferError(err error) (*TransferError, bool) {
+	var te *TransferError
+	ok := errors.As(err, &te)
+	return te, ok
+}
+
+// hookError turns the error of a hook into a *TransferError.
+func hookError(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	if _, ok := asTransferError(err); ok {
+		return err
+	}
+	return &TransferError{Code: 451, Message: "Transfer failed: " + err.Error()}
+}
+
+// streamHooks returns the hooks applied to the session's transfers,
+// outermost first.
+func (s *session) streamHooks() []StreamHook {
+	hooks := s.server.streamHooks
+	if h, ok := s.driverFS.(StreamHook); ok {
+		hooks = append(hooks[:len(hooks):len(hooks)], h)
+	}
+	return hooks
+}
+
+// streamInfo returns the StreamInfo of a transfer starting in the session.
+func (s *session) streamInfo(command, path string, offset int64) *StreamInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &StreamInfo{
+		SessionID: s.sessionID,
+		RemoteIP:  s.remoteIP,
+		User:      s.user,
+		Command:   command,
+		Path:      path,
+		Offset:    offset,
+	}
+}
+
+// wrapUpload passes w through the session's stream hooks. The returned
+// closer closes the hooks' writers; it must be called once the transfer
+// ends.
+func (s *session) wrapUpload(command, path string, offset int64, w io.Writer) (io.Writer, io.Closer, error) {
+	hooks := s.streamHooks()
+	if len(hooks) == 0 {
+		return w, hookClosers(nil), nil
+	}
+
+	info := s.streamInfo(command, path, offset)
+	var closers hookClosers
+	for i := len(hooks) - 1; i >= 0; i-- {
+		wc, err := hooks[i].WrapUpload(info, w)
+		if err != nil {
+			closers.Close()
+			return nil, nil, hookError(err)
+		}
+		w = wc
+		closers = append(closers, wc)
+	}
+	return &hookWriter{w: w, info: info}, closers, nil
+}
+
+// wrapDownload passes r through the session's stream hooks. The returned
+// closer closes the hooks' readers; it must be called once the transfer
+// ends.
+func (s *session) wrapDownload(path string, offset int64, r io.Reader) (io.Reader, io.Closer, error) {
+	hooks := s.streamHooks()
+	if len(hooks) == 0 {
+		return r, hookClosers(nil), nil
+	}
+
+	info := s.streamInfo("RETR", path, offset)
+	var closers hookClosers
+	for i := len(hooks) - 1; i >= 0; i-- {
+		rc, err := hooks[i].WrapDownload(info, r)
+		if err != nil {
+			closers.Close()
+			return nil, nil, hookError(err)
+		}
+		r = rc
+		closers = append(closers, rc)
+	}
+	return &hookReader{r: r, info: info}, closers, nil
+}
+
+// replyTransferError sends the reply of a transfer failed by a stream hook.
+func (s *session) replyTransferError(command, path string, err error) {
+	te, ok := asTransferError(err)
+	if !ok {
+		te = &TransferError{Code: 451, Message: "Transfer failed: " + err.Error()}
+	}
+	code, msg := te.reply()
+	s.server.logger.Warn("transfer_rejected",
+		"session_id", s.sessionID,
+		"remote_ip", s.redactIP(s.remoteIP),
+		"user", s.user,
+		"operation", command,
+		"path", s.redactPath(path),
+		"code", code,
+		"reason", msg,
+	)
+	s.reply(code, msg)
+}
+
+// uploadSink forwards the output of the stream hooks of an upload to its
+// file, which is opened once the hooks have accepted the upload.
+type uploadSink struct {
+	w io.Writer
+}
+
+func (u *uploadSink) Write(b []byte) (int, error) {
+	if u.w == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return u.w.Write(b)
+}
+
+// hookClosers closes the wrappers of a hook chain, outermost first, so
+// buffered data is flushed inwards.
+type hookClosers []io.Closer
+
+func (c hookClosers) Close() error {
+	var first error
+	for i := len(c) - 1; i >= 0; i-- {
+		if err := c[i].Close(); err != nil && first == nil {
+			first = hookError(err)
+		}
+	}
+	return first
+}
+
+// hookWriter counts the bytes written to a hook chain and reports its
+// errors as *TransferError.
+type hookWriter struct {
+	w    io.Writer
+	info *StreamInfo
+}
+
+func (h *hookWriter) Write(b []byte) (int, error) {
+	n, err := h.w.Write(b)
+	h.info.bytes.Add(int64(n))
+	return n, hookError(err)
+}
+
+// hookReader counts the bytes read from a hook chain and reports its
+// errors as *TransferError.
+type hookReader struct {
+	r    io.Reader
+	info *StreamInfo
+}
+
+func (h *hookReader) Read(b []byte) (int, error) {
+	n, err := h.r.Read(b)
+	h.info.bytes.Add(int64(n))
+	return n, hookError(err)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+// gzipHook stores files compressed and decompresses them on download.
+type gzipHook struct{}
+
+func (gzipHook) WrapUpload(_ *StreamInfo, w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipHook) WrapDownload(_ *StreamInfo, r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// scanHook rejects blocked names and uploads containing "VIRUS", and
+// records the size of the uploads it accepts.
+type scanHook struct {
+	mu    sync.Mutex
+	sizes map[string]int64
+}
+
+func (h *scanHook) WrapUpload(info *StreamInfo, w io.Writer) (io.WriteCloser, error) {
+	if strings.HasSuffix(info.Path, "blocked.txt") {
+		return nil, &TransferError{Code: 553, Message: "Name blocked."}
+	}
+	return &scanWriter{w: w, hook: h, info: info}, nil
+}
+
+func (h *scanHook) WrapDownload(info *StreamInfo, r io.Reader) (io.ReadCloser, error) {
+	if strings.HasSuffix(info.Path, "secret.txt") {
+		return nil, &TransferError{Code: 550, Message: "Access denied."}
+	}
+	return io.NopCloser(r), nil
+}
+
+type scanWriter struct {
+	w        io.Writer
+	hook     *scanHook
+	info     *StreamInfo
+	infected bool
+}
+
+func (s *scanWriter) Write(b []byte) (int, error) {
+	if bytes.Contains(b, []byte("VIRUS")) {
+		s.infected = true
+		return 0, &TransferError{Code: 552, Message: "Content rejected."}
+	}
+	return s.w.Write(b)
+}
+
+func (s *scanWriter) Close() error {
+	if !s.infected {
+		s.hook.mu.Lock()
+		s.hook.sizes[s.info.User+":"+s.info.Command+":"+s.info.Path] = s.info.Bytes()
+		s.hook.mu.Unlock()
+	}
+	return nil
+}
+
+func TestStreamHooks(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir, WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+		return rootDir, false, nil
+	}))
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	scan := &scanHook{sizes: make(map[string]int64)}
+	startServer(t, ln, WithDriver(driver), WithStreamHooks(scan, gzipHook{}))
+	addr := ln.Addr().String()
+
+	// A new connection per transfer, as rejected transfers may break the data stream
+	dial := func() *ftp.Client {
+		c, err := ftp.Dial(addr, ftp.WithTimeout(2*time.Second))
+		fatalIfErr(t, err, "Failed to dial")
+		t.Cleanup(func() { _ = c.Quit() })
+		fatalIfErr(t, c.Login("alice", "pass"), "Login failed")
+		return c
+	}
+	expectCode := func(err error, code int, what string) {
+		t.Helper()
+		var pe *ftp.ProtocolError
+		if !errors.As(err, &pe) || pe.Code != code {
+			t.Errorf("%s: expected %d, got %v", what, code, err)
+		}
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(rootDir, name))
+		return err == nil
+	}
+
+	data := strings.Repeat("stream me ", 500)
+	c := dial()
+	fatalIfErr(t, c.Store("data.txt", strings.NewReader(data)), "Store failed")
+
+	// The file is stored compressed, and decompressed on download
+	stored, err := os.ReadFile(filepath.Join(rootDir, "data.txt"))
+	fatalIfErr(t, err, "Failed to read stored file")
+	zr, err := gzip.NewReader(bytes.NewReader(stored))
+	fatalIfErr(t, err, "Stored file is not compressed")
+	if got, _ := io.ReadAll(zr); string(got) != data {
+		t.Errorf("Stored file decompresses to %d bytes, want %d", len(got), len(data))
+	}
+
+	var buf bytes.Buffer
+	fatalIfErr(t, c.Retrieve("data.txt", &buf), "Retrieve failed")
+	if buf.String() != data {
+		t.Errorf("Retrieve returned %d bytes, want %d", buf.Len(), len(data))
+	}
+
+	scan.mu.Lock()
+	size := scan.sizes["alice:STOR:data.txt"]
+	scan.mu.Unlock()
+	if size != int64(len(data)) {
+		t.Errorf("Hook saw %d bytes, want %d (%v)", size, len(data), scan.sizes)
+	}
+
+	// Hooks fail transfers with their own replies
+	expectCode(dial().Store("blocked.txt", strings.NewReader("x")), 553, "blocked name")
+	expectCode(dial().Store("infected.txt", strings.NewReader("a VIRUS")), 552, "rejected content")
+	if exists("blocked.txt") || exists("infected.txt") {
+		t.Error("Rejected uploads were not removed")
+	}
+
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "secret.txt"), stored, 0644), "Failed to write file")
+	expectCode(dial().Retrieve("secret.txt", io.Discard), 550, "denied download")
+
+	// Other hook errors are reported with 451
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "plain.txt"), []byte(data), 0644), "Failed to write file")
+	expectCode(dial().Retrieve("plain.txt", io.Discard), 451, "corrupt file")
+}
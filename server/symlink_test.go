@@ -0,0 +1,195 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// symlinkFarm skips the test on Windows, or creates a link farm in rootDir:
+//
+//	data/file.txt
+//	current -> data
+//	latest  -> data/file.txt
+//	abs     -> <root>/data/file.txt
+//	escape  -> <outside dir>
+func symlinkFarm(t *testing.T, rootDir string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need special privileges on Windows")
+	}
+
+	outside := t.TempDir()
+	fatalIfErr(t, os.Mkdir(filepath.Join(rootDir, "data"), 0755), "Mkdir failed")
+	writeParts(t, rootDir, map[string]string{"data/file.txt": "linked content"})
+	links := map[string]string{
+		"current": "data",
+		"latest":  "data/file.txt",
+		"abs":     filepath.Join(rootDir, "data", "file.txt"),
+		"escape":  outside,
+	}
+	for name, target := range links {
+		fatalIfErr(t, os.Symlink(target, filepath.Join(rootDir, name)), "Symlink failed")
+	}
+}
+
+func TestSymlinks_Listing(t *testing.T) {
+	t.Parallel()
+	driver, rootDir := newTestFSDriver(t, WithFollowSymlinks(SymlinksWithinRoot))
+	symlinkFarm(t, rootDir)
+	c := dialDriver(t, driver)
+
+	entries, err := c.List("/")
+	fatalIfErr(t, err, "List failed")
+	targets := make(map[string]string)
+	for _, e := range entries {
+		if e.Type == "link" {
+			targets[e.Name] = e.Target
+		}
+	}
+	want := map[string]string{
+		"current": "data",
+		"latest":  "data/file.txt",
+		"abs":     "/data/file.txt",
+		"escape":  "", // Targets outside the root are not revealed
+	}
+	for name, target := range want {
+		if got, ok := targets[name]; !ok || got != target {
+			t.Errorf("LIST %s: target %q (link: %v), want %q", name, got, ok, target)
+		}
+	}
+
+	mlEntries, err := c.MLList("/")
+	fatalIfErr(t, err, "MLList failed")
+	for _, e := range mlEntries {
+		isLink := e.Type == "os.unix=slink"
+		if _, want := want[e.Name]; isLink != want {
+			t.Errorf("MLSD %s: type %q", e.Name, e.Type)
+		}
+	}
+}
+
+func TestSymlinks_WithinRoot(t *testing.T) {
+	t.Parallel()
+	driver, rootDir := newTestFSDriver(t, WithFollowSymlinks(SymlinksWithinRoot))
+	symlinkFarm(t, rootDir)
+	c := dialDriver(t, driver)
+
+	for _, path := range []string{"latest", "current/file.txt"} {
+		var buf bytes.Buffer
+		if err := c.Retrieve(path, &buf); err != nil {
+			t.Errorf("Retrieve %s failed: %v", path, err)
+		} else if buf.String() != "linked content" {
+			t.Errorf("Retrieve %s: content = %q", path, buf.String())
+		}
+	}
+
+	if size, err := c.Size("latest"); err != nil || size != int64(len("linked content")) {
+		t.Errorf("SIZE latest = %d, %v; want the target's size", size, err)
+	}
+	fatalIfErr(t, c.ChangeDir("current"), "CWD through link failed")
+	fatalIfErr(t, c.ChangeDir("/"), "CWD / failed")
+
+	if err := c.ChangeDir("escape"); err == nil {
+		t.Error("CWD to a link outside the root succeeded")
+	}
+	// os.Root does not follow absolute links, even into the root
+	if err := c.Retrieve("abs", &bytes.Buffer{}); err == nil {
+		t.Error("Retrieve through an absolute link succeeded")
+	}
+}
+
+func TestSymlinks_Never(t *testing.T) {
+	t.Parallel()
+	driver, rootDir := newTestFSDriver(t, WithFollowSymlinks(SymlinksNever))
+	symlinkFarm(t, rootDir)
+	c := dialDriver(t, driver)
+
+	var buf bytes.Buffer
+	if err := c.Retrieve("latest", &buf); err == nil {
+		t.Error("Retrieve through link succeeded")
+	}
+	if err := c.Retrieve("current/file.txt", &buf); err == nil {
+		t.Error("Retrieve through linked directory succeeded")
+	}
+	if err := c.ChangeDir("current"); err == nil {
+		t.Error("CWD through link succeeded")
+	}
+	if err := c.Retrieve("data/file.txt", &buf); err != nil {
+		t.Errorf("Retrieve of a regular file failed: %v", err)
+	}
+
+	// Links can still be listed and deleted
+	entries, err := c.List("/")
+	fatalIfErr(t, err, "List failed")
+	if len(entries) != 5 {
+		t.Errorf("LIST returned %d entries, want 5", len(entries))
+	}
+	fatalIfErr(t, c.Delete("latest"), "DELE of a link failed")
+	if _, err := os.Lstat(filepath.Join(rootDir, "latest")); !os.IsNotExist(err) {
+		t.Errorf("link not deleted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, "data", "file.txt")); err != nil {
+		t.Errorf("link target deleted: %v", err)
+	}
+}
+
+func TestSiteSymlink(t *testing.T) {
+	t.Parallel()
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+		driver, rootDir := newTestFSDriver(t, WithFollowSymlinks(SymlinksWithinRoot))
+		symlinkFarm(t, rootDir)
+		c := dialDriver(t, driver)
+		resp, err := c.Quote("SITE", "SYMLINK", "data", "link")
+		fatalIfErr(t, err, "SITE SYMLINK failed")
+		if resp.Code != 502 {
+			t.Errorf("Expected 502, got %d %s", resp.Code, resp.Message)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		t.Parallel()
+		driver, rootDir := newTestFSDriver(t, WithFollowSymlinks(SymlinksWithinRoot))
+		symlinkFarm(t, rootDir)
+		c := dialDriver(t, driver, WithSiteSymlink(true))
+
+		tests := []struct {
+			args []string
+			code int
+		}{
+			{[]string{"data/file.txt", "rel"}, 200},
+			{[]string{"/data/file.txt", `"data/abs link"`}, 200},
+			{[]string{"../../etc/passwd", "bad"}, 550},
+			{[]string{"data", "rel"}, 550},
+			{[]string{"data"}, 501},
+		}
+		for _, tt := range tests {
+			resp, err := c.Quote("SITE", append([]string{"SYMLINK"}, tt.args...)...)
+			fatalIfErr(t, err, "SITE SYMLINK failed")
+			if resp.Code != tt.code {
+				t.Errorf("SITE SYMLINK %v: expected %d, got %d %s", tt.args, tt.code, resp.Code, resp.Message)
+			}
+		}
+
+		// Absolute targets are stored relative to the link
+		for link, want := range map[string]string{"rel": "data/file.txt", "data/abs link": "file.txt"} {
+			target, err := os.Readlink(filepath.Join(rootDir, link))
+			if err != nil || target != want {
+				t.Errorf("link %s -> %q (%v), want %q", link, target, err, want)
+			}
+		}
+		if _, err := os.Lstat(filepath.Join(rootDir, "bad")); !os.IsNotExist(err) {
+			t.Errorf("escaping link created: %v", err)
+		}
+
+		resp, err := c.Quote("SITE", "HELP")
+		fatalIfErr(t, err, "SITE HELP failed")
+		if !bytes.Contains([]byte(resp.Message), []byte("SYMLINK")) {
+			t.Errorf("SITE HELP does not list SYMLINK: %s", resp.Message)
+		}
+	})
+}
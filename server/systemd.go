@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFDsStart = 3
+
+// SystemdListeners returns the listeners passed to the process by systemd
+// socket activation (see sd_listen_fds(3)), keyed by the FileDescriptorName
+// of their socket units ("unknown" if the names are not passed). It returns
+// a nil map if the process was not socket activated.
+//
+// Socket activation lets the init system bind privileged ports such as 21
+// and 990, or Unix sockets, while the server runs as an unprivileged user.
+// The LISTEN_* environment variables are removed, so child processes do not
+// take the sockets for their own.
+//
+// Example socket units, with ftpd.service running the server:
+//
+//	# ftp.socket
+//	[Socket]
+//	ListenStream=21
+//	FileDescriptorName=ftp
+//	Service=ftpd.service
+//
+//	# ftps.socket
+//	[Socket]
+//	ListenStream=990
+//	FileDescriptorName=ftps
+//	Service=ftpd.service
+//
+// And the server, with implicit FTPS on the second socket:
+//
+//	listeners, err := server.SystemdListeners()
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, ln := range listeners["ftp"] {
+//	    go s.Serve(ln)
+//	}
+//	for _, ln := range listeners["ftps"] {
+//	    go s.ServeTLS(ln)
+//	}
+func SystemdListeners() (map[string][]net.Listener, error) {
+	pid := os.Getenv("LISTEN_PID")
+	fds := os.Getenv("LISTEN_FDS")
+	names := os.Getenv("LISTEN_FDNAMES")
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(key)
+	}
+
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+
+	listeners := make(map[string][]net.Listener)
+	for i := range n {
+		name := "unknown"
+		if i < len(fdNames) {
+			name = fdNames[i]
+		}
+		// net.FileListener duplicates the descriptor
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, lns := range listeners {
+				for _, ln := range lns {
+					ln.Close()
+				}
+			}
+			return nil, fmt.Errorf("systemd socket %d (%s): %w", listenFDsStart+i, name, err)
+		}
+		listeners[name] = append(listeners[name], ln)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSystemdListeners_NotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := SystemdListeners()
+	if listeners != nil || err != nil {
+		t.Errorf("SystemdListeners = %v, %v; want nil, nil", listeners, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Error("LISTEN_FDS not removed")
+	}
+}
+
+// TestSystemdListeners runs the test binary as a socket activated server
+// (see TestSystemdHelperProcess), passing it two listeners.
+func TestSystemdListeners(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("socket activation is not supported on Windows")
+	}
+	var files []*os.File
+	var addrs []string
+	for range 2 {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		fatalIfErr(t, err, "Failed to listen")
+		defer ln.Close()
+		f, err := ln.(*net.TCPListener).File()
+		fatalIfErr(t, err, "Failed to get listener file")
+		defer f.Close()
+		files = append(files, f)
+		addrs = append(addrs, ln.Addr().String())
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSystemdHelperProcess$")
+	cmd.Env = append(os.Environ(), "FTP_SYSTEMD_HELPER=1", "LISTEN_FDS=2", "LISTEN_FDNAMES=ftp:ftps")
+	cmd.ExtraFiles = files // Descriptors 3 and 4
+	cmd.Stderr = os.Stderr
+	fatalIfErr(t, cmd.Start(), "Failed to start helper")
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	// The helper serves plain FTP on the first listener, implicit FTPS on
+	// the second
+	for i, addr := range addrs {
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		fatalIfErr(t, err, "Failed to dial")
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if i == 1 {
+			conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "220 " {
+			t.Errorf("Listener %d sent %q, %v; want a greeting", i, buf, err)
+		}
+	}
+}
+
+// TestSystemdHelperProcess is a socket activated server, when run by
+// TestSystemdListeners.
+func TestSystemdHelperProcess(t *testing.T) {
+	if os.Getenv("FTP_SYSTEMD_HELPER") != "1" {
+		t.Skip("helper process for TestSystemdListeners")
+	}
+	// systemd sets LISTEN_PID once the process is started
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	listeners, err := SystemdListeners()
+	if err != nil || len(listeners["ftp"]) != 1 || len(listeners["ftps"]) != 1 {
+		fmt.Fprintf(os.Stderr, "SystemdListeners = %v, %v\n", listeners, err)
+		os.Exit(1)
+	}
+	driver, err := NewFSDriver(t.TempDir())
+	fatalIfErr(t, err, "Failed to create FS driver")
+	s, err := NewServer("", WithDriver(driver), WithTLS(testTLSConfig(t)))
+	fatalIfErr(t, err, "Failed to create server")
+	go func() { _ = s.Serve(listeners["ftp"][0]) }()
+	_ = s.ServeTLS(listeners["ftps"][0])
+}
@@ -18,6 +18,11 @@ const (
 	telnetDONT = 0xFE
 )
 
+// telnetBufferSize is the size of the telnetReader buffer. Commands are
+// buffered again by the control reader, so a small buffer is enough and
+// keeps the memory of idle sessions low.
+const telnetBufferSize = 512
+
 // telnetReader is a reader that filters out Telnet commands.
 // It implements the io.Reader interface.
 type telnetReader struct {
@@ -27,7 +32,7 @@ type telnetReader struct {
 // newTelnetReader creates a new telnetReader.
 func newTelnetReader(r io.Reader) *telnetReader {
 	return &telnetReader{
-		reader: bufio.NewReader(r),
+		reader: bufio.NewReaderSize(r, telnetBufferSize),
 	}
 }
 
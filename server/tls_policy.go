@@ -0,0 +1,113 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// applyTLSPolicy applies the TLS version and cipher suites of
+// WithTLSMinVersion and WithTLSCipherSuites to the TLS configuration.
+// Called by NewServer once the certificates are set up.
+func (s *Server) applyTLSPolicy() error {
+	override := s.tlsMinVersion != 0 || len(s.tlsCipherSuites) > 0
+	if (override || s.requireTLS) && s.tlsConfig == nil {
+		return fmt.Errorf("TLS policy options require TLS (use WithTLS)")
+	}
+	if !override {
+		return nil
+	}
+
+	// The configuration may be shared with other servers or listeners
+	config := s.tlsConfig.Clone()
+	if s.tlsMinVersion != 0 {
+		config.MinVersion = s.tlsMinVersion
+		if config.MaxVersion != 0 && config.MaxVersion < config.MinVersion {
+			return fmt.Errorf("TLS minimum version %s is above the configured maximum %s",
+				tls.VersionName(config.MinVersion), tls.VersionName(config.MaxVersion))
+		}
+	}
+	if len(s.tlsCipherSuites) > 0 {
+		config.CipherSuites = s.tlsCipherSuites
+	}
+	s.tlsConfig = config
+	return nil
+}
+
+// validTLSMinVersion reports whether version can be the minimum TLS version.
+func validTLSMinVersion(version uint16) bool {
+	switch version {
+	case tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13:
+		return true
+	}
+	return false
+}
+
+// checkCipherSuite returns an error unless id is a secure, configurable
+// cipher suite. The TLS 1.3 suites are always enabled and cannot be chosen.
+func checkCipherSuite(id uint16) error {
+	for _, suite := range tls.CipherSuites() {
+		if suite.ID != id {
+			continue
+		}
+		for _, v := range suite.SupportedVersions {
+			if v != tls.VersionTLS13 {
+				return nil
+			}
+		}
+		return fmt.Errorf("TLS 1.3 cipher suite %s cannot be configured", suite.Name)
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.ID == id {
+			return fmt.Errorf("cipher suite %s is insecure", suite.Name)
+		}
+	}
+	return fmt.Errorf("unknown cipher suite 0x%04x", id)
+}
+
+// secure reports whether the control connection is protected by TLS.
+func (s *session) secure() bool {
+	_, ok := s.conn.(*tls.Conn)
+	return ok
+}
+
+// logTLS logs the parameters negotiated for the control connection, once
+// its TLS handshake is complete.
+func (s *session) logTLS() {
+	if s.tlsLogged {
+		return
+	}
+	tlsConn, ok := s.conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+	state := tlsConn.ConnectionState()
+	if !state.HandshakeComplete {
+		return
+	}
+	s.tlsLogged = true
+	s.server.logger.Info("tls_established",
+		"session_id", s.sessionID,
+		"remote_ip", s.redactIP(s.remoteIP),
+		"version", tls.VersionName(state.Version),
+		"cipher_suite", tls.CipherSuiteName(state.CipherSuite),
+		"server_name", state.ServerName,
+		"resumed", state.DidResume,
+	)
+}
+
+// reportTLSFailure reports err, which ended the session, if the TLS
+// handshake of the control connection failed, as when a client only
+// supports versions or cipher suites the server does not accept. Clients
+// that disconnect without a handshake are not reported.
+func (s *session) reportTLSFailure(err error) {
+	tlsConn, ok := s.conn.(*tls.Conn)
+	if !ok || errors.Is(err, io.EOF) || errors.Is(err, errIdleTimeout) {
+		return
+	}
+	if tlsConn.ConnectionState().HandshakeComplete {
+		return
+	}
+	s.audit(AuditEvent{Type: AuditTLSHandshakeFailed, Reason: err.Error()})
+}
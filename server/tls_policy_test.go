@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+// testTLSConfig returns a server TLS configuration with a new certificate.
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	certPath, keyPath, _, _ := generateCert(t, false, nil, nil)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	fatalIfErr(t, err, "Failed to load certificate")
+	return &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+// waitAudit waits for an event of type typ.
+func waitAudit(t *testing.T, rec *auditRecorder, typ AuditEventType) AuditEvent {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if e, ok := rec.find(typ); ok {
+			return e
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("No %s event", typ)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRequireTLS(t *testing.T) {
+	t.Parallel()
+	rootDir := t.TempDir()
+	driver, err := NewFSDriver(rootDir,
+		WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+			return rootDir, false, nil
+		}),
+	)
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	var logBuf safeBuffer
+	rec := &auditRecorder{}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	startServer(t, ln,
+		WithDriver(driver),
+		WithTLS(testTLSConfig(t)),
+		WithRequireTLS(true),
+		WithAuditSink(rec),
+		WithLogger(slog.New(slog.NewTextHandler(&logBuf, nil))),
+	)
+
+	conn, err := net.DialTimeout("tcp", ln.Addr().String(), 5*time.Second)
+	fatalIfErr(t, err, "Dial failed")
+	defer conn.Close()
+	tc := &textConn{Conn: conn, Reader: bufio.NewReader(conn)}
+	_, _, err = rawReadResponse(tc)
+	fatalIfErr(t, err, "Failed to read greeting")
+
+	commands := []struct {
+		line string
+		code int
+	}{
+		{"USER test", 530},
+		{"AUTH SSL", 504},
+	}
+	for _, c := range commands {
+		fmt.Fprintf(tc, "%s\r\n", c.line)
+		code, msg, err := rawReadResponse(tc)
+		fatalIfErr(t, err, "Failed to read reply to "+c.line)
+		if code != c.code {
+			t.Errorf("%s: got %q, want %d", c.line, msg, c.code)
+		}
+	}
+	rec.mu.Lock()
+	var reasons []string
+	for _, e := range rec.events {
+		if e.Type == AuditTLSDowngrade {
+			reasons = append(reasons, e.Command+" "+e.Reason)
+		}
+	}
+	rec.mu.Unlock()
+	if want := []string{"USER clear_login", "AUTH auth_ssl"}; !slices.Equal(reasons, want) {
+		t.Errorf("tls_downgrade events = %q, want %q", reasons, want)
+	}
+
+	// Over TLS, the login succeeds and the session's TLS parameters are
+	// logged
+	c, err := ftp.Dial(ln.Addr().String(),
+		ftp.WithTimeout(5*time.Second),
+		ftp.WithExplicitTLS(&tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}),
+	)
+	fatalIfErr(t, err, "Dial with TLS failed")
+	defer c.Quit()
+	fatalIfErr(t, c.Login("test", "test"), "Login over TLS failed")
+
+	logs := logBuf.String()
+	if !strings.Contains(logs, "msg=tls_established") || !strings.Contains(logs, `version="TLS 1.2"`) ||
+		!strings.Contains(logs, "cipher_suite=TLS_ECDHE_") {
+		t.Errorf("TLS parameters not logged:\n%s", logs)
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	t.Parallel()
+	config := testTLSConfig(t)
+	rec := &auditRecorder{}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	driver, err := NewFSDriver(t.TempDir())
+	fatalIfErr(t, err, "Failed to create FS driver")
+	s, err := NewServer(ln.Addr().String(),
+		WithDriver(driver),
+		WithTLS(config),
+		WithTLSMinVersion(tls.VersionTLS13),
+		WithAuditSink(rec),
+	)
+	fatalIfErr(t, err, "Failed to create server")
+	if s.TLSConfig().MinVersion != tls.VersionTLS13 || config.MinVersion != 0 {
+		t.Errorf("MinVersion = %x (WithTLS config %x), want TLS 1.3 on a copy",
+			s.TLSConfig().MinVersion, config.MinVersion)
+	}
+
+	// Implicit FTPS, with the server's configuration
+	go func() {
+		_ = s.Serve(tls.NewListener(ln, s.TLSConfig()))
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+
+	old, err := net.DialTimeout("tcp", ln.Addr().String(), 5*time.Second)
+	fatalIfErr(t, err, "Dial failed")
+	defer old.Close()
+	oldTLS := tls.Client(old, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	_ = oldTLS.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := oldTLS.Handshake(); err == nil {
+		t.Fatal("TLS 1.2 handshake succeeded, want failure")
+	}
+	e := waitAudit(t, rec, AuditTLSHandshakeFailed)
+	if e.SessionID == "" || !strings.Contains(e.Reason, "version") {
+		t.Errorf("tls_handshake_failed event = %+v", e)
+	}
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	fatalIfErr(t, err, "TLS 1.3 dial failed")
+	defer conn.Close()
+	tc := &textConn{Conn: conn, Reader: bufio.NewReader(conn)}
+	if code, msg, err := rawReadResponse(tc); err != nil || code != 220 {
+		t.Errorf("greeting = %q, %v", msg, err)
+	}
+}
+
+func TestTLSCipherSuites(t *testing.T) {
+	t.Parallel()
+	driver, _ := NewFSDriver(t.TempDir())
+	suites := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+	s, err := NewServer(":0", WithDriver(driver), WithTLS(testTLSConfig(t)), WithTLSCipherSuites(suites...))
+	fatalIfErr(t, err, "Failed to create server")
+	if !slices.Equal(s.TLSConfig().CipherSuites, suites) {
+		t.Errorf("CipherSuites = %v, want %v", s.TLSConfig().CipherSuites, suites)
+	}
+}
+
+func TestTLSPolicyOptions_Invalid(t *testing.T) {
+	t.Parallel()
+	driver, _ := NewFSDriver(t.TempDir())
+	config := testTLSConfig(t)
+	maxTLS12 := config.Clone()
+	maxTLS12.MaxVersion = tls.VersionTLS12
+
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"SSL 3.0", []Option{WithTLS(config), WithTLSMinVersion(0x0300)}},
+		{"minimum above maximum", []Option{WithTLS(maxTLS12), WithTLSMinVersion(tls.VersionTLS13)}},
+		{"no cipher suites", []Option{WithTLS(config), WithTLSCipherSuites()}},
+		{"insecure cipher suite", []Option{WithTLS(config), WithTLSCipherSuites(tls.TLS_RSA_WITH_RC4_128_SHA)}},
+		{"TLS 1.3 cipher suite", []Option{WithTLS(config), WithTLSCipherSuites(tls.TLS_AES_128_GCM_SHA256)}},
+		{"unknown cipher suite", []Option{WithTLS(config), WithTLSCipherSuites(0xfefe)}},
+		{"minimum version without TLS", []Option{WithTLSMinVersion(tls.VersionTLS13)}},
+		{"require TLS without TLS", []Option{WithRequireTLS(true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewServer(":0", append([]Option{WithDriver(driver)}, tt.opts...)...); err == nil {
+				t.Error("NewServer succeeded, want error")
+			}
+		})
+	}
+}
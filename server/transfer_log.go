@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// TransferRecord describes a finished file transfer, complete or not.
+type TransferRecord struct {
+	Time      time.Time // When the transfer ended
+	Duration  time.Duration
+	SessionID string
+	RemoteIP  string
+	User      string
+	Command   string // RETR, STOR, APPE or STOU
+	Path      string
+	Bytes     int64
+	ASCII     bool // Transferred with TYPE A
+	TLS       bool // The data connection was encrypted (PROT P)
+	Anonymous bool // Logged in as "anonymous" or "ftp"
+	Complete  bool // False for transfers that ended before their 226 reply
+}
+
+// Upload reports whether the transfer sent data to the server.
+func (r *TransferRecord) Upload() bool {
+	return r.Command == "STOR" || r.Command == "APPE" || r.Command == "STOU"
+}
+
+// TransferLogger records file transfers. See WithTransferLogger.
+//
+// LogTransfer is called synchronously from the session goroutine once the
+// transfer has ended, so it must not block for long. It may be called
+// concurrently from different sessions.
+type TransferLogger interface {
+	LogTransfer(rec TransferRecord)
+}
+
+// TransferLoggerFunc adapts a function to the TransferLogger interface.
+type TransferLoggerFunc func(rec TransferRecord)
+
+// LogTransfer calls f(rec).
+func (f TransferLoggerFunc) LogTransfer(rec TransferRecord) {
+	f(rec)
+}
+
+// logOutput is a writer that can be replaced while records are written to
+// it, for log rotation. Each record is written with a single Write call.
+type logOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// write writes a record to the current writer. Errors are ignored, as a
+// broken log must not fail transfers.
+func (o *logOutput) write(b []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, _ = o.w.Write(b)
+}
+
+// swap replaces the writer and returns the previous one.
+func (o *logOutput) swap(w io.Writer) io.Writer {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	old := o.w
+	o.w = w
+	return old
+}
+
+// XferLogger writes transfers in the standard xferlog format, understood by
+// most FTP log analyzers. It is safe for concurrent use.
+type XferLogger struct {
+	out logOutput
+}
+
+// NewXferLogger returns an XferLogger writing to w.
+func NewXferLogger(w io.Writer) *XferLogger {
+	return &XferLogger{out: logOutput{w: w}}
+}
+
+// Swap replaces the writer records are written to and returns the previous
+// one. Records being written finish on the previous writer, and later ones
+// go to w, so no record is lost or split between files when the caller
+// closes the previous writer after Swap returns.
+//
+// Example, reopening the log when logrotate sends SIGHUP:
+//
+//	xferlog := server.NewXferLogger(logFile)
+//	hup := make(chan os.Signal, 1)
+//	signal.Notify(hup, syscall.SIGHUP)
+//	go func() {
+//	    for range hup {
+//	        f, err := os.OpenFile("/var/log/xferlog", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+//	        if err != nil {
+//	            log.Print(err)
+//	            continue
+//	        }
+//	        xferlog.Swap(f).(*os.File).Close()
+//	    }
+//	}()
+func (l *XferLogger) Swap(w io.Writer) io.Writer {
+	return l.out.swap(w)
+}
+
+// LogTransfer implements TransferLogger.
+// Format: current-time transfer-time remote-host file-size filename transfer-type special-action-flag direction access-mode username service-name authentication-method authenticated-user-id completion-status
+func (l *XferLogger) LogTransfer(rec TransferRecord) {
+	transferTime := int64(rec.Duration.Seconds())
+	if transferTime == 0 {
+		transferTime = 1
+	}
+
+	// Transfer type: a (ascii), b (binary)
+	tType := "b"
+	if rec.ASCII {
+		tType = "a"
+	}
+
+	// Special action flag: _ (none), C (compressed), U (uncompressed), T (tar)
+	actionFlag := "_"
+
+	// Direction: o (outgoing/download), i (incoming/upload)
+	direction := "o"
+	if rec.Upload() {
+		direction = "i"
+	}
+
+	// Access mode: a (anonymous), g (guest), r (real user)
+	accessMode := "r"
+	if rec.Anonymous {
+		accessMode = "a"
+	}
+
+	// Authentication method: 0 (none), 1 (rfc931 auth)
+	authMethod := "0"
+
+	// Authenticated user ID: * (not available)
+	authUserID := "*"
+
+	// Completion status: c (complete), i (incomplete)
+	completionStatus := "c"
+	if !rec.Complete {
+		completionStatus = "i"
+	}
+
+	// Format line
+	// Mon Dec 25 15:04:05 2025 1 127.0.0.1 1024 /file.txt b _ o a anonymous ftp 0 * c
+	line := fmt.Sprintf("%s %d %s %d %s %s %s %s %s %s %s %s %s %s\n",
+		rec.Time.Format("Mon Jan 02 15:04:05 2006"), // Manually mimicking ctime format
+		transferTime,
+		rec.RemoteIP,
+		rec.Bytes,
+		rec.Path,
+		tType,
+		actionFlag,
+		direction,
+		accessMode,
+		rec.User,
+		"ftp",
+		authMethod,
+		authUserID,
+		completionStatus,
+	)
+	l.out.write([]byte(line))
+}
+
+// JSONTransferLogger writes transfers as JSON lines, one object per
+// transfer:
+//
+//	{"time":"2025-12-25T15:04:05.123Z","session_id":"a1b2c3d4","remote_ip":"192.0.2.1","user":"alice","command":"STOR","direction":"upload","path":"/data.bin","bytes":1048576,"duration_ms":842,"type":"binary","tls":true,"anonymous":false,"status":"complete"}
+//
+// status is "complete" or "incomplete". It is safe for concurrent use.
+type JSONTransferLogger struct {
+	out logOutput
+}
+
+// NewJSONTransferLogger returns a JSONTransferLogger writing to w.
+func NewJSONTransferLogger(w io.Writer) *JSONTransferLogger {
+	return &JSONTransferLogger{out: logOutput{w: w}}
+}
+
+// Swap replaces the writer records are written to and returns the previous
+// one, as XferLogger.Swap does.
+func (l *JSONTransferLogger) Swap(w io.Writer) io.Writer {
+	return l.out.swap(w)
+}
+
+// jsonTransfer is the JSON form of a TransferRecord.
+type jsonTransfer struct {
+	Time       time.Time `json:"time"`
+	SessionID  string    `json:"session_id"`
+	RemoteIP   string    `json:"remote_ip"`
+	User       string    `json:"user"`
+	Command    string    `json:"command"`
+	Direction  string    `json:"direction"`
+	Path       string    `json:"path"`
+	Bytes      int64     `json:"bytes"`
+	DurationMS int64     `json:"duration_ms"`
+	Type       string    `json:"type"`
+	TLS        bool      `json:"tls"`
+	Anonymous  bool      `json:"anonymous"`
+	Status     string    `json:"status"`
+}
+
+// LogTransfer implements TransferLogger.
+func (l *JSONTransferLogger) LogTransfer(rec TransferRecord) {
+	j := jsonTransfer{
+		Time:       rec.Time.UTC(),
+		SessionID:  rec.SessionID,
+		RemoteIP:   rec.RemoteIP,
+		User:       rec.User,
+		Command:    rec.Command,
+		Direction:  "download",
+		Path:       rec.Path,
+		Bytes:      rec.Bytes,
+		DurationMS: rec.Duration.Milliseconds(),
+		Type:       "binary",
+		TLS:        rec.TLS,
+		Anonymous:  rec.Anonymous,
+		Status:     "complete",
+	}
+	if rec.Upload() {
+		j.Direction = "upload"
+	}
+	if rec.ASCII {
+		j.Type = "ascii"
+	}
+	if !rec.Complete {
+		j.Status = "incomplete"
+	}
+
+	b, err := json.Marshal(j)
+	if err != nil {
+		return
+	}
+	l.out.write(append(b, '\n'))
+}
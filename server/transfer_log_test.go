@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+func TestTransferLoggers(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	driver, err := NewFSDriver(t.TempDir(), WithAnonWrite(true))
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	var xferlog, jsonLog safeBuffer
+	jsonLogger := NewJSONTransferLogger(&jsonLog)
+	var mu sync.Mutex
+	var records []TransferRecord
+	startServer(t, ln,
+		WithDriver(driver),
+		WithTransferLog(&xferlog),
+		WithTransferLogger(jsonLogger),
+		WithTransferLogger(TransferLoggerFunc(func(rec TransferRecord) {
+			mu.Lock()
+			records = append(records, rec)
+			mu.Unlock()
+		})),
+	)
+
+	c, err := ftp.Dial(ln.Addr().String(), ftp.WithTimeout(2*time.Second))
+	fatalIfErr(t, err, "Failed to dial")
+	defer c.Quit()
+	fatalIfErr(t, c.Login("anonymous", "guest"), "Login failed")
+	fatalIfErr(t, c.Store("up.txt", strings.NewReader("hello")), "Store failed")
+
+	// Records written after Swap go to the new writer only
+	var rotated safeBuffer
+	if old := jsonLogger.Swap(&rotated); old != &jsonLog {
+		t.Errorf("Swap returned %v, want the previous writer", old)
+	}
+	fatalIfErr(t, c.Retrieve("up.txt", &bytes.Buffer{}), "Retrieve failed")
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(records) == 2
+	}, "transfers not logged")
+
+	if lines := strings.Split(strings.TrimSpace(xferlog.String()), "\n"); len(lines) != 2 ||
+		!strings.HasSuffix(lines[0], " 5 up.txt b _ i a anonymous ftp 0 * c") ||
+		!strings.HasSuffix(lines[1], " 5 up.txt b _ o a anonymous ftp 0 * c") {
+		t.Errorf("Unexpected xferlog:\n%s", xferlog.String())
+	}
+
+	var upload, download map[string]any
+	if err := json.Unmarshal([]byte(jsonLog.String()), &upload); err != nil {
+		t.Fatalf("Invalid JSON log %q: %v", jsonLog.String(), err)
+	}
+	if err := json.Unmarshal([]byte(rotated.String()), &download); err != nil {
+		t.Fatalf("Invalid rotated JSON log %q: %v", rotated.String(), err)
+	}
+	for key, want := range map[string]any{
+		"command":   "STOR",
+		"direction": "upload",
+		"path":      "up.txt",
+		"bytes":     5.0,
+		"user":      "anonymous",
+		"anonymous": true,
+		"tls":       false,
+		"type":      "binary",
+		"status":    "complete",
+	} {
+		if upload[key] != want {
+			t.Errorf("JSON %s = %v, want %v", key, upload[key], want)
+		}
+	}
+	if upload["session_id"] == "" || upload["session_id"] != download["session_id"] {
+		t.Errorf("Session IDs %v and %v, want the same non-empty ID", upload["session_id"], download["session_id"])
+	}
+	if download["direction"] != "download" {
+		t.Errorf("Rotated log direction = %v, want download", download["direction"])
+	}
+
+	if _, err := NewServer(":0", WithDriver(driver), WithTransferLogger(nil)); err == nil {
+		t.Error("WithTransferLogger(nil) accepted")
+	}
+}
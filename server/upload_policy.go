@@ -0,0 +1,250 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// OverwritePolicy selects what an upload does to an existing file. See
+// UploadPolicy.Overwrite.
+type OverwritePolicy int
+
+const (
+	// OverwriteAllow replaces existing files. It is the default.
+	OverwriteAllow OverwritePolicy = iota
+
+	// OverwriteDeny rejects uploads to existing files with 553, including
+	// appends and resumed uploads, so files never change once written.
+	OverwriteDeny
+
+	// OverwriteVersion renames an existing file to the first free name of
+	// the form "name.1", "name.2", and so on, once the new file has been
+	// uploaded under a temporary name, which then takes its place. Failed
+	// uploads leave the existing file as it was. Appends and resumed
+	// uploads to existing files are rejected as with OverwriteDeny.
+	OverwriteVersion
+)
+
+// maxFileVersions is the number of versions OverwriteVersion keeps of a
+// file. Further uploads to the file are rejected.
+const maxFileVersions = 1000
+
+// UploadPolicy restricts the files clients may upload with STOR, APPE and
+// STOU. It is enforced by the server before the driver is called, so drivers
+// do not need to implement it. File name rules do not apply to STOU, whose
+// names are chosen by the server. The zero value allows everything.
+type UploadPolicy struct {
+	// MaxFileSize is the maximum size of an uploaded file in bytes (0 = unlimited).
+	// The transfer is aborted with 552 as soon as the file would exceed it.
+	// For APPE and resumed uploads, the size already on the server counts.
+	MaxFileSize int64
+
+	// MinFileSize is the minimum size of a new file uploaded with STOR or STOU,
+	// in bytes (0 = no minimum). Smaller uploads are deleted and rejected with
+	// 552. It does not apply to APPE or resumed uploads.
+	MinFileSize int64
+
+	// AllowedExtensions lists the allowed file name extensions, such as
+	// ".jpg" (case-insensitive). If empty, all extensions are allowed.
+	AllowedExtensions []string
+
+	// AllowedPattern, if set, must match the file name (without directory).
+	AllowedPattern *regexp.Regexp
+
+	// BannedChars lists characters that may not appear anywhere in the path.
+	BannedChars string
+
+	// Overwrite is what STOR, APPE and COMB do when the file exists. It is
+	// checked before the file is opened, and new files are then created
+	// with O_EXCL, so that drivers supporting it reject a file created in
+	// between. Atomic uploads are renamed into place without that check.
+	Overwrite OverwritePolicy
+}
+
+// errUploadTooLarge is returned by limitedWriter once MaxFileSize is reached.
+var errUploadTooLarge = errors.New("upload exceeds maximum file size")
+
+// allowsName reports whether a file may be uploaded to path.
+func (p *UploadPolicy) allowsName(path string) bool {
+	if p.BannedChars != "" && strings.ContainsAny(path, p.BannedChars) {
+		return false
+	}
+
+	name := path[strings.LastIndex(path, "/")+1:]
+	if p.AllowedPattern != nil && !p.AllowedPattern.MatchString(name) {
+		return false
+	}
+
+	if len(p.AllowedExtensions) > 0 {
+		dot := strings.LastIndex(name, ".")
+		if dot == -1 {
+			return false
+		}
+		ext := name[dot:]
+		for _, allowed := range p.AllowedExtensions {
+			if strings.EqualFold(ext, allowed) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// limitWriter returns w limited so that a file already existing bytes long
+// cannot grow beyond MaxFileSize.
+func (p *UploadPolicy) limitWriter(w io.Writer, existing int64) io.Writer {
+	if p == nil || p.MaxFileSize <= 0 {
+		return w
+	}
+	return &limitedWriter{w: w, remaining: max(p.MaxFileSize-existing, 0)}
+}
+
+// tooSmall reports whether an uploaded file of the given size is below
+// MinFileSize.
+func (p *UploadPolicy) tooSmall(size int64) bool {
+	return p != nil && size < p.MinFileSize
+}
+
+// limitedWriter writes at most remaining bytes, then fails with
+// errUploadTooLarge.
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (l *limitedWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) <= l.remaining {
+		n, err := l.w.Write(b)
+		l.remaining -= int64(n)
+		return n, err
+	}
+
+	n, err := l.w.Write(b[:l.remaining])
+	l.remaining -= int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, errUploadTooLarge
+}
+
+// uploadPolicy returns the policy for this session: the one from the
+// driver's settings for the user if set, or the server-wide one (may be nil).
+func (s *session) uploadPolicy() *UploadPolicy {
+	if settings := s.fs.GetSettings(); settings != nil && settings.UploadPolicy != nil {
+		return settings.UploadPolicy
+	}
+	return s.server.uploadPolicy
+}
+
+// checkUploadName replies 553 and returns false if the session's upload
+// policy does not allow a file to be uploaded to path.
+func (s *session) checkUploadName(policy *UploadPolicy, path string) bool {
+	if policy == nil || policy.allowsName(path) {
+		return true
+	}
+	s.reply(553, "File name not allowed.")
+	return false
+}
+
+// msgNoOverwrite is the reply to uploads rejected by the overwrite policy.
+const msgNoOverwrite = "File exists; overwriting is not allowed."
+
+// exclusive reports whether uploads must create new files, with O_EXCL.
+func (p *UploadPolicy) exclusive() bool {
+	return p != nil && p.Overwrite != OverwriteAllow
+}
+
+// checkOverwrite applies the session's overwrite policy to an upload to
+// path, before the file is opened. It replies and returns ok false if the
+// upload must not proceed. inPlace tells whether the upload writes into the
+// existing file (APPE and resumed uploads), which cannot be versioned.
+// version is true if the existing file must be versioned: the upload is
+// then written under a temporary name and swapped in by publishVersioned
+// once complete, so that failed uploads leave the file alone.
+func (s *session) checkOverwrite(policy *UploadPolicy, path string, inPlace bool) (version, ok bool) {
+	if !policy.exclusive() {
+		return false, true
+	}
+
+	// Files that cannot be examined, such as those of others in a dropbox,
+	// are left to the exclusive open
+	info, err := s.fs.GetFileInfo(s.context(), path)
+	if os.IsNotExist(err) || os.IsPermission(err) {
+		return false, true
+	}
+	if err != nil {
+		s.replyError(err)
+		return false, false
+	}
+	if info.IsDir() {
+		// Opening the directory fails with the usual reply
+		return false, true
+	}
+
+	if policy.Overwrite != OverwriteVersion || inPlace {
+		s.reply(553, msgNoOverwrite)
+		return false, false
+	}
+	return true, true
+}
+
+// publishVersioned renames the existing file at path to its first free
+// version name, then publishes the completed upload at tmpPath in its
+// place. The existing file is restored if the upload cannot be published,
+// and the temporary file is removed on failure.
+func (s *session) publishVersioned(tmpPath, path string) error {
+	version, err := s.versionFile(path)
+	if err != nil {
+		s.server.logger.Warn("upload_version_failed",
+			"session_id", s.sessionID,
+			"path", s.redactPath(path),
+			"error", err,
+		)
+		s.discardUpload(true, tmpPath)
+		return fmt.Errorf("existing file could not be versioned: %w", err)
+	}
+	if err := s.publishUpload(tmpPath, path); err != nil {
+		if restoreErr := s.fs.Rename(s.context(), version, path); restoreErr != nil {
+			s.server.logger.Warn("upload_version_restore_failed",
+				"session_id", s.sessionID,
+				"path", s.redactPath(path),
+				"error", restoreErr,
+			)
+		}
+		return err
+	}
+	return nil
+}
+
+// versionFile renames the file at path to its first free version name,
+// which it returns.
+func (s *session) versionFile(path string) (string, error) {
+	for n := 1; n <= maxFileVersions; n++ {
+		version := fmt.Sprintf("%s.%d", path, n)
+		_, err := s.fs.GetFileInfo(s.context(), version)
+		if os.IsNotExist(err) {
+			return version, s.fs.Rename(s.context(), path, version)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("all %d versions are in use", maxFileVersions)
+}
+
+// replyUploadError replies to a failure to open or write the file of an
+// upload. Files created by someone else since checkOverwrite are rejected
+// as if they had been there before.
+func (s *session) replyUploadError(policy *UploadPolicy, err error) {
+	if policy.exclusive() && os.IsExist(err) {
+		s.reply(553, msgNoOverwrite)
+		return
+	}
+	s.replyError(err)
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+func TestUploadPolicy_AllowsName(t *testing.T) {
+	t.Parallel()
+	policy := &UploadPolicy{
+		AllowedExtensions: []string{".txt", ".JPG"},
+		AllowedPattern:    regexp.MustCompile(`^[A-Za-z0-9_.-]+$`),
+		BannedChars:       "#%",
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"notes.txt", true},
+		{"dir/photo.jpg", true},
+		{"/abs/path/photo.Jpg", true},
+		{"script.sh", false},
+		{"noextension", false},
+		{"has space.txt", false},
+		{"bad#name.txt", false},
+		{"dir%/notes.txt", false},
+	}
+	for _, tt := range tests {
+		if got := policy.allowsName(tt.path); got != tt.want {
+			t.Errorf("allowsName(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	if !(&UploadPolicy{}).allowsName("anything.exe") {
+		t.Error("zero policy should allow all names")
+	}
+}
+
+func TestUploadPolicy_LimitWriter(t *testing.T) {
+	t.Parallel()
+	policy := &UploadPolicy{MaxFileSize: 10}
+
+	var buf bytes.Buffer
+	w := policy.limitWriter(&buf, 4)
+	if _, err := w.Write([]byte("abc")); err != nil {
+		t.Fatalf("Write within limit failed: %v", err)
+	}
+	n, err := w.Write([]byte("defgh"))
+	if !errors.Is(err, errUploadTooLarge) || n != 3 {
+		t.Errorf("Write over limit = %d, %v; want 3, errUploadTooLarge", n, err)
+	}
+	if buf.String() != "abcdef" {
+		t.Errorf("written = %q, want %q", buf.String(), "abcdef")
+	}
+
+	var nilPolicy *UploadPolicy
+	if nilPolicy.limitWriter(&buf, 0) != &buf || nilPolicy.tooSmall(0) {
+		t.Error("nil policy should not limit uploads")
+	}
+}
+
+func TestUploadPolicyEnforcement(t *testing.T) {
+	t.Parallel()
+	rootDir := t.TempDir()
+
+	driver, err := NewFSDriver(rootDir,
+		WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+			return rootDir, false, nil
+		}),
+		WithUserSettings(func(user string) *Settings {
+			if user == "big" {
+				return &Settings{UploadPolicy: &UploadPolicy{MaxFileSize: 1 << 20}}
+			}
+			return nil
+		}),
+	)
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	addr := ln.Addr().String()
+
+	s, err := NewServer(addr, WithDriver(driver), WithUploadPolicy(UploadPolicy{
+		MaxFileSize:       1000,
+		MinFileSize:       2,
+		AllowedExtensions: []string{".txt"},
+		BannedChars:       "#",
+	}))
+	fatalIfErr(t, err, "Failed to create server")
+	go func() {
+		_ = s.Serve(ln)
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	}()
+
+	// A new connection per upload, as rejected transfers may break the data stream
+	store := func(user, name string, size int) error {
+		c, err := ftp.Dial(addr, ftp.WithTimeout(2*time.Second))
+		fatalIfErr(t, err, "Failed to dial")
+		defer func() { _ = c.Quit() }()
+		fatalIfErr(t, c.Login(user, "pass"), "Login failed")
+		return c.Store(name, bytes.NewReader(bytes.Repeat([]byte("x"), size)))
+	}
+	expectCode := func(err error, code int, what string) {
+		t.Helper()
+		var pe *ftp.ProtocolError
+		if !errors.As(err, &pe) || pe.Code != code {
+			t.Errorf("%s: expected %d, got %v", what, code, err)
+		}
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(rootDir, name))
+		return err == nil
+	}
+
+	expectCode(store("user", "a.bin", 10), 553, "disallowed extension")
+	expectCode(store("user", "bad#.txt", 10), 553, "banned character")
+	if exists("a.bin") || exists("bad#.txt") {
+		t.Error("rejected names were created")
+	}
+
+	expectCode(store("user", "small.txt", 1), 552, "below minimum size")
+	if exists("small.txt") {
+		t.Error("undersized upload was not removed")
+	}
+
+	if err := store("user", "large.txt", 5000); err == nil {
+		t.Error("oversized upload succeeded")
+	}
+	if exists("large.txt") {
+		t.Error("oversized upload was not removed")
+	}
+
+	if err := store("user", "ok.txt", 500); err != nil {
+		t.Errorf("upload within limits failed: %v", err)
+	}
+
+	// Appends count the existing data towards the maximum
+	c, err := ftp.Dial(addr, ftp.WithTimeout(2*time.Second))
+	fatalIfErr(t, err, "Failed to dial")
+	fatalIfErr(t, c.Login("user", "pass"), "Login failed")
+	err = c.Append("ok.txt", strings.NewReader(strings.Repeat("y", 600)))
+	_ = c.Quit()
+	if err == nil {
+		t.Error("append beyond maximum size succeeded")
+	}
+	if info, err := os.Stat(filepath.Join(rootDir, "ok.txt")); err != nil || info.Size() != 1000 {
+		t.Errorf("ok.txt after append = %v, %v; want size 1000", info, err)
+	}
+
+	// Per-user settings override the server policy
+	if err := store("big", "large.bin", 5000); err != nil {
+		t.Errorf("upload with per-user policy failed: %v", err)
+	}
+}
+
+func TestUploadPolicyOverwrite(t *testing.T) {
+	t.Parallel()
+	rootDir := t.TempDir()
+	fatalIfErr(t, os.WriteFile(filepath.Join(rootDir, "drop.txt"), []byte("v1"), 0644), "Failed to write file")
+
+	driver, err := NewFSDriver(rootDir,
+		WithAuthenticator(func(user, pass, host string, _ net.IP) (string, bool, error) {
+			return rootDir, false, nil
+		}),
+		WithUserSettings(func(user string) *Settings {
+			if user == "archiver" {
+				return &Settings{UploadPolicy: &UploadPolicy{Overwrite: OverwriteVersion}}
+			}
+			return nil
+		}),
+	)
+	fatalIfErr(t, err, "Failed to create FS driver")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	startServer(t, ln, WithDriver(driver), WithUploadPolicy(UploadPolicy{Overwrite: OverwriteDeny}))
+
+	login := func(user string) *ftp.Client {
+		c, err := ftp.Dial(ln.Addr().String(), ftp.WithTimeout(2*time.Second))
+		fatalIfErr(t, err, "Failed to dial")
+		t.Cleanup(func() { _ = c.Quit() })
+		fatalIfErr(t, c.Login(user, "pass"), "Login failed")
+		return c
+	}
+	content := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(rootDir, name))
+		return string(data)
+	}
+	expect553 := func(err error, what string) {
+		t.Helper()
+		var pe *ftp.ProtocolError
+		if !errors.As(err, &pe) || pe.Code != 553 {
+			t.Errorf("%s: expected 553, got %v", what, err)
+		}
+	}
+
+	c := login("user")
+	expect553(c.Store("drop.txt", strings.NewReader("clobbered")), "STOR over existing file")
+	expect553(c.Append("drop.txt", strings.NewReader("more")), "APPE to existing file")
+	if got := content("drop.txt"); got != "v1" {
+		t.Errorf("drop.txt = %q after denied uploads, want v1", got)
+	}
+	fatalIfErr(t, c.Store("new.txt", strings.NewReader("new")), "Upload of a new file failed")
+
+	// Per-user settings keep the old versions instead
+	a := login("archiver")
+	fatalIfErr(t, a.Store("drop.txt", strings.NewReader("v2")), "Versioned upload failed")
+	fatalIfErr(t, a.Store("drop.txt", strings.NewReader("v3")), "Versioned upload failed")
+	for name, want := range map[string]string{"drop.txt": "v3", "drop.txt.1": "v1", "drop.txt.2": "v2"} {
+		if got := content(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// An aborted upload leaves the existing file and its versions alone
+	tc, err := rawLogin(ln.Addr().String(), "archiver", "pass")
+	fatalIfErr(t, err, "Login failed")
+	defer tc.Close()
+	dataAddr, err := rawEnterPasv(tc)
+	fatalIfErr(t, err, "PASV failed")
+	fmt.Fprintf(tc, "STOR drop.txt\r\n")
+	dataConn, err := net.Dial("tcp", dataAddr)
+	fatalIfErr(t, err, "Failed to dial data connection")
+	defer dataConn.Close()
+	if code, msg, err := rawReadResponse(tc); err != nil || !isPreliminary(code) {
+		t.Fatalf("STOR failed: %q %v", msg, err)
+	}
+	_, err = dataConn.Write([]byte("partial"))
+	fatalIfErr(t, err, "Failed to write data")
+	fmt.Fprintf(tc, "ABOR\r\n")
+	for range 2 {
+		_, _, err := rawReadResponse(tc)
+		fatalIfErr(t, err, "Failed to read reply")
+	}
+	for name, want := range map[string]string{"drop.txt": "v3", "drop.txt.1": "v1", "drop.txt.2": "v2", "drop.txt.3": ""} {
+		if got := content(name); got != want {
+			t.Errorf("%s = %q after aborted upload, want %q", name, got, want)
+		}
+	}
+	if entries, _ := os.ReadDir(rootDir); len(entries) != 4 {
+		t.Errorf("%d files after aborted upload, want 4 (no temporary file left)", len(entries))
+	}
+
+	if _, err := NewServer(":0", WithDriver(driver), WithUploadPolicy(UploadPolicy{Overwrite: 7})); err == nil {
+		t.Error("Invalid overwrite policy accepted")
+	}
+}
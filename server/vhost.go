@@ -0,0 +1,71 @@
+package server
+
+import (
+	"crypto/tls"
+	"strings"
+)
+
+// VirtualHost is a site served by WithVirtualHosts.
+type VirtualHost struct {
+	// Driver authenticates users and serves files for the host. Required.
+	Driver Driver
+
+	// WelcomeMessage, if set, is sent in the reply to HOST, and as the
+	// banner of implicit FTPS connections whose SNI name selects the host.
+	WelcomeMessage string
+
+	// CertFile and KeyFile, if set, are the TLS certificate of the host. It is
+	// selected by SNI or HOST and reloaded when the files change, as with
+	// WithTLSHostCertificate.
+	CertFile string
+	KeyFile  string
+}
+
+// normalizeHost lowercases a host name and strips a trailing dot.
+func normalizeHost(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// lookupHost finds the entry for a host name, matching names exactly and
+// then against wildcard entries such as "*.example.com".
+func lookupHost[T any](hosts map[string]T, name string) (T, bool) {
+	name = normalizeHost(name)
+	if v, ok := hosts[name]; ok {
+		return v, true
+	}
+	if _, rest, ok := strings.Cut(name, "."); ok {
+		if v, ok := hosts["*."+rest]; ok {
+			return v, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// virtualHost returns the virtual host selected by the HOST command or, if
+// none was sent, by the SNI name of the control connection.
+func (s *session) virtualHost() (VirtualHost, bool) {
+	if len(s.server.virtualHosts) == 0 {
+		return VirtualHost{}, false
+	}
+
+	name := s.host
+	if name == "" {
+		if tlsConn, ok := s.conn.(*tls.Conn); ok {
+			name = tlsConn.ConnectionState().ServerName
+		}
+	}
+	if name == "" {
+		return VirtualHost{}, false
+	}
+	return lookupHost(s.server.virtualHosts, name)
+}
+
+// driver returns the driver for the session: the virtual host's, or the
+// server's default driver.
+func (s *session) driver() Driver {
+	if vh, ok := s.virtualHost(); ok {
+		return vh.Driver
+	}
+	return s.server.driver
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+)
+
+// siteDriver returns a driver serving a new directory holding one file, name.
+func siteDriver(t *testing.T, name string) Driver {
+	t.Helper()
+	root := t.TempDir()
+	fatalIfErr(t, os.WriteFile(filepath.Join(root, name), []byte(name), 0644), "WriteFile failed")
+	driver, err := NewFSDriver(root)
+	fatalIfErr(t, err, "Failed to create FS driver")
+	return driver
+}
+
+// startServer starts a server on ln, shut down when the test ends.
+func startServer(t *testing.T, ln net.Listener, opts ...Option) *Server {
+	t.Helper()
+	s, err := NewServer(ln.Addr().String(), opts...)
+	fatalIfErr(t, err, "Failed to create server")
+	go func() {
+		_ = s.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+	return s
+}
+
+func TestVirtualHosts(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	startServer(t, ln,
+		WithDriver(siteDriver(t, "default.txt")),
+		WithVirtualHosts(map[string]VirtualHost{
+			"ftp.example.com": {Driver: siteDriver(t, "com.txt"), WelcomeMessage: "Welcome to example.com"},
+			"*.example.org":   {Driver: siteDriver(t, "org.txt")},
+		}),
+	)
+	addr := ln.Addr().String()
+
+	tests := []struct {
+		host string
+		file string
+	}{
+		{"", "default.txt"},
+		{"ftp.example.com", "com.txt"},
+		{"FTP.Example.COM.", "com.txt"},
+		{"files.example.org", "org.txt"},
+	}
+	for _, tt := range tests {
+		c, err := ftp.Dial(addr, ftp.WithTimeout(2*time.Second))
+		fatalIfErr(t, err, "Failed to dial")
+		if tt.host != "" {
+			fatalIfErr(t, c.Host(tt.host), "HOST %s failed", tt.host)
+		}
+		fatalIfErr(t, c.Login("anonymous", "anonymous"), "Login failed")
+
+		names, err := c.NameList(".")
+		fatalIfErr(t, err, "NameList failed")
+		if len(names) != 1 || names[0] != tt.file {
+			t.Errorf("host %q: listing = %v, want [%s]", tt.host, names, tt.file)
+		}
+		_ = c.Quit()
+	}
+
+	t.Run("unknown host", func(t *testing.T) {
+		c, err := ftp.Dial(addr, ftp.WithTimeout(2*time.Second))
+		fatalIfErr(t, err, "Failed to dial")
+		defer func() { _ = c.Quit() }()
+
+		var pe *ftp.ProtocolError
+		if err := c.Host("ftp.example.net"); !errors.As(err, &pe) || pe.Code != 504 {
+			t.Errorf("Expected 504 error, got %v", err)
+		}
+	})
+
+	t.Run("welcome message", func(t *testing.T) {
+		conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+		fatalIfErr(t, err, "Failed to dial")
+		defer conn.Close()
+		tc := &textConn{Conn: conn, Reader: bufio.NewReader(conn)}
+		_, _, err = rawReadResponse(tc)
+		fatalIfErr(t, err, "Failed to read greeting")
+
+		fmt.Fprintf(conn, "HOST ftp.example.com\r\n")
+		code, msg, err := rawReadResponse(tc)
+		fatalIfErr(t, err, "HOST failed")
+		if code != 220 || !strings.Contains(msg, "Welcome to example.com") {
+			t.Errorf("HOST reply = %q", msg)
+		}
+	})
+}
+
+func TestVirtualHostsImplicitTLS(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, _, _ := generateCert(t, true, nil, nil)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	fatalIfErr(t, err, "LoadX509KeyPair failed")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err, "Failed to listen")
+	s, err := NewServer(ln.Addr().String(),
+		WithDriver(siteDriver(t, "default.txt")),
+		WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		WithVirtualHosts(map[string]VirtualHost{
+			"ftp.example.com": {Driver: siteDriver(t, "com.txt"), WelcomeMessage: "Welcome to example.com"},
+		}),
+	)
+	fatalIfErr(t, err, "Failed to create server")
+	tlsLn := tls.NewListener(ln, s.TLSConfig())
+	go func() {
+		_ = s.Serve(tlsLn)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	})
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		ServerName:         "ftp.example.com",
+		InsecureSkipVerify: true,
+	})
+	fatalIfErr(t, err, "Failed to dial")
+	defer conn.Close()
+	tc := &textConn{Conn: conn, Reader: bufio.NewReader(conn)}
+
+	code, msg, err := rawReadResponse(tc)
+	fatalIfErr(t, err, "Failed to read greeting")
+	if code != 220 || !strings.Contains(msg, "Welcome to example.com") {
+		t.Errorf("greeting = %q", msg)
+	}
+}
+
+func TestWithVirtualHostsRequiresDriver(t *testing.T) {
+	t.Parallel()
+	_, err := NewServer(":0",
+		WithDriver(siteDriver(t, "default.txt")),
+		WithVirtualHosts(map[string]VirtualHost{"ftp.example.com": {}}),
+	)
+	if err == nil {
+		t.Fatal("Expected error for virtual host without driver")
+	}
+}
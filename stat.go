@@ -0,0 +1,160 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Stat returns information about a file or directory.
+//
+// If the server supports MLST (RFC 3659), a single MLST command is used.
+// Otherwise, the entry is looked up in a LIST of its parent directory, and
+// the size and modification time of files are refined with SIZE and MDTM
+// when available. SIZE is never sent for directories, since some servers
+// reply with a meaningless size for them.
+//
+// If the path does not exist, the error matches fs.ErrNotExist. Servers
+// reply 550 both for missing paths and for paths the user cannot access, so
+// inaccessible paths are reported as missing too.
+//
+// Example:
+//
+//	entry, err := client.Stat("reports/2024.csv")
+//	if errors.Is(err, fs.ErrNotExist) {
+//	    fmt.Println("no report yet")
+//	} else if err != nil {
+//	    log.Fatal(err)
+//	}
+//	fmt.Printf("%s: %d bytes, modified %s\n", entry.Name, entry.Size, entry.ModTime)
+func (c *Client) Stat(p string) (*Entry, error) {
+	if c.HasFeature("MLST") {
+		ml, err := c.MLStat(p)
+		if err == nil {
+			return entryFromMLEntry(p, ml), nil
+		}
+		if !notImplemented(err) {
+			return nil, statError(p, err)
+		}
+		// MLST advertised but not implemented; use the fallback
+	}
+
+	return c.statFromList(p)
+}
+
+// Exists reports whether a file or directory exists. It returns false and a
+// nil error if the path does not exist, and an error only if the server
+// could not be asked (see Stat).
+//
+// Example:
+//
+//	exists, err := client.Exists("upload.lock")
+func (c *Client) Exists(p string) (bool, error) {
+	_, err := c.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// IsDir reports whether path exists and is a directory. It returns false
+// and a nil error if the path does not exist or is not a directory. Symbolic
+// links are reported as not being directories when the server identifies
+// them as links.
+//
+// Example:
+//
+//	isDir, err := client.IsDir("backups")
+func (c *Client) IsDir(p string) (bool, error) {
+	entry, err := c.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return entry.Type == "dir", nil
+}
+
+// statFromList implements Stat for servers without MLST, using LIST on the
+// parent directory, then SIZE and MDTM for files.
+func (c *Client) statFromList(p string) (*Entry, error) {
+	clean := strings.TrimSuffix(p, "/")
+	if clean == "" || clean == "." {
+		// The root or the current directory always exists
+		return &Entry{Name: path.Base(p), Type: "dir"}, nil
+	}
+
+	dir, name := path.Split(clean)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := c.List(dir)
+	if err != nil {
+		return nil, statError(p, err)
+	}
+
+	var entry *Entry
+	for _, e := range entries {
+		if e.Name == name {
+			entry = e
+			break
+		}
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("ftp: stat %s: %w", p, fs.ErrNotExist)
+	}
+
+	if entry.Type == "file" {
+		// LIST sizes may be rounded (e.g. in blocks), and times lack seconds
+		if size, err := c.Size(p); err == nil {
+			entry.Size = size
+		}
+		if modTime, err := c.ModTime(p); err == nil {
+			entry.ModTime = modTime
+		}
+	}
+	return entry, nil
+}
+
+// entryFromMLEntry converts an MLST entry for path p to an Entry.
+func entryFromMLEntry(p string, ml *MLEntry) *Entry {
+	entry := &Entry{
+		Name:    path.Base(ml.Name),
+		Size:    ml.Size,
+		ModTime: ml.ModTime,
+	}
+	if ml.Name == "" {
+		entry.Name = path.Base(p)
+	}
+
+	switch strings.ToLower(ml.Type) {
+	case "dir", "cdir", "pdir":
+		entry.Type = "dir"
+	case "file":
+		entry.Type = "file"
+	default:
+		// Symbolic links are reported as "OS.unix=slink:target" (RFC 3659
+		// Section 7.5.2) or "link"
+		entry.Type = "file"
+		if strings.Contains(strings.ToLower(ml.Type), "link") {
+			entry.Type = "link"
+			if _, target, ok := strings.Cut(ml.Type, ":"); ok {
+				entry.Target = target
+			}
+		}
+	}
+	return entry
+}
+
+// statError converts a 550 reply for path p to an error matching
+// fs.ErrNotExist. Other errors are returned unchanged.
+func statError(p string, err error) error {
+	var pe *ProtocolError
+	if errors.As(err, &pe) && pe.Code == 550 {
+		return fmt.Errorf("ftp: stat %s: %w: %w", p, fs.ErrNotExist, err)
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package ftp_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
+)
+
+func TestStat(t *testing.T) {
+	t.Parallel()
+
+	for _, mlst := range []bool{true, false} {
+		name := "MLST"
+		if !mlst {
+			name = "LIST fallback"
+		}
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := ftptest.NewServer(t)
+			if err := s.WriteFile("/pub/a.txt", []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			if !mlst {
+				s.FailCommand("MLST", 502, "Command not implemented.")
+			}
+
+			c, err := ftp.Dial(s.Addr, ftp.WithTimeout(2*time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Quit()
+			if err := c.Login("user", "pass"); err != nil {
+				t.Fatal(err)
+			}
+
+			entry, err := c.Stat("/pub/a.txt")
+			if err != nil {
+				t.Fatalf("Stat file: %v", err)
+			}
+			if entry.Name != "a.txt" || entry.Type != "file" || entry.Size != 5 {
+				t.Errorf("Stat file = %+v", entry)
+			}
+			if time.Since(entry.ModTime) > time.Hour {
+				t.Errorf("Stat file ModTime = %v", entry.ModTime)
+			}
+
+			entry, err = c.Stat("/pub")
+			if err != nil {
+				t.Fatalf("Stat dir: %v", err)
+			}
+			if entry.Name != "pub" || entry.Type != "dir" {
+				t.Errorf("Stat dir = %+v", entry)
+			}
+
+			if _, err := c.Stat("/pub/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Stat missing file: %v, want fs.ErrNotExist", err)
+			}
+			if _, err := c.Stat("/missing/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Stat file in missing dir: %v, want fs.ErrNotExist", err)
+			}
+
+			tests := []struct {
+				path          string
+				exists, isDir bool
+			}{
+				{"/", true, true},
+				{"/pub", true, true},
+				{"/pub/a.txt", true, false},
+				{"/pub/missing.txt", false, false},
+			}
+			for _, tt := range tests {
+				exists, err := c.Exists(tt.path)
+				if err != nil || exists != tt.exists {
+					t.Errorf("Exists(%q) = %v, %v; want %v", tt.path, exists, err, tt.exists)
+				}
+				isDir, err := c.IsDir(tt.path)
+				if err != nil || isDir != tt.isDir {
+					t.Errorf("IsDir(%q) = %v, %v; want %v", tt.path, isDir, err, tt.isDir)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package ftp
+
+import (
+	"crypto/tls"
+	"sync/atomic"
+)
+
+// TLSResumptionStats counts the TLS handshakes of a client, for diagnosing
+// workloads slowed down by full handshakes.
+type TLSResumptionStats struct {
+	Handshakes int64 // Completed handshakes, control and data connections
+	Resumed    int64 // Handshakes that resumed a cached session
+}
+
+// HitRate returns the fraction of handshakes that resumed a session, or 0
+// before the first handshake.
+func (s TLSResumptionStats) HitRate() float64 {
+	if s.Handshakes == 0 {
+		return 0
+	}
+	return float64(s.Resumed) / float64(s.Handshakes)
+}
+
+// tlsResumptionCounters accumulates TLSResumptionStats.
+type tlsResumptionCounters struct {
+	handshakes atomic.Int64
+	resumed    atomic.Int64
+}
+
+// TLSSessionCache returns the cache of TLS sessions used by the client, or
+// nil if TLS is not enabled. It can be passed to WithTLSResumptionCache so
+// that other clients resume this client's sessions.
+func (c *Client) TLSSessionCache() tls.ClientSessionCache {
+	if c.tlsConfig == nil {
+		return nil
+	}
+	return c.tlsConfig.ClientSessionCache
+}
+
+// TLSResumptionStats returns the number of TLS handshakes made by the client
+// and how many of them resumed a session. The connections opened for
+// parallel transfers are included.
+//
+// A low hit rate usually means the server does not support resumption, or
+// the tls.Config has no ServerName, so sessions are cached per address. For
+// workloads with many short-lived clients, sharing a cache with
+// WithTLSResumptionCache raises it.
+func (c *Client) TLSResumptionStats() TLSResumptionStats {
+	if c.tlsStats == nil {
+		return TLSResumptionStats{}
+	}
+	return TLSResumptionStats{
+		Handshakes: c.tlsStats.handshakes.Load(),
+		Resumed:    c.tlsStats.resumed.Load(),
+	}
+}
+
+// recordTLSHandshake counts a completed handshake.
+func (c *Client) recordTLSHandshake(conn *tls.Conn) {
+	if c.tlsStats == nil {
+		return
+	}
+	c.tlsStats.handshakes.Add(1)
+	if conn.ConnectionState().DidResume {
+		c.tlsStats.resumed.Add(1)
+	}
+}
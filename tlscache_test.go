@@ -0,0 +1,57 @@
+package ftp_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"strings"
+	"testing"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
+	"github.com/gonzalop/ftp/server"
+)
+
+func TestTLSResumptionCache(t *testing.T) {
+	t.Parallel()
+
+	certPath, keyPath, _, _ := generateCert(t, false, nil, nil)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := ftptest.NewServer(t, server.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+
+	cache := tls.NewLRUClientSessionCache(8)
+	dial := func() *ftp.Client {
+		return dialTestServer(t, s,
+			ftp.WithExplicitTLS(&tls.Config{ServerName: "localhost", InsecureSkipVerify: true}),
+			ftp.WithTLSResumptionCache(cache),
+		)
+	}
+
+	first := dial()
+	if first.TLSSessionCache() != cache {
+		t.Error("TLSSessionCache does not return the shared cache")
+	}
+	if err := first.Store("a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	stats := first.TLSResumptionStats()
+	if stats.Handshakes != 2 {
+		t.Errorf("First client: %d handshakes, want 2 (control and data)", stats.Handshakes)
+	}
+
+	// The second client resumes the sessions cached by the first one
+	second := dial()
+	if err := second.Retrieve("a.txt", &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	stats = second.TLSResumptionStats()
+	if stats.Handshakes != 2 || stats.Resumed != 2 || stats.HitRate() != 1 {
+		t.Errorf("Second client: %+v (hit rate %v), want 2 resumed handshakes", stats, stats.HitRate())
+	}
+
+	if _, err := ftp.Dial(s.Addr, ftp.WithTLSResumptionCache(cache)); err == nil {
+		t.Error("WithTLSResumptionCache without TLS accepted")
+	}
+}
@@ -1,20 +1,64 @@
 package ftp
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
-	"path"
-	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/gonzalop/ftp/internal/ratelimit"
 )
 
-// StoreUnique uploads data from an io.Reader to a server-generated unique file.
-// Returns the filename generated by the server.
+var (
+	// stouFileRegex matches "FILE: name", the format suggested by RFC 1123
+	// and used by most servers
+	stouFileRegex = regexp.MustCompile(`(?i)^FILE:\s*(.+)$`)
+
+	// stouUniqueRegex matches wu-ftpd's "Transfer complete (unique file
+	// name:name)."
+	stouUniqueRegex = regexp.MustCompile(`(?i)unique file name:\s*([^)]+)\)`)
+
+	// stouQuotedRegex matches a quoted name, as in FileZilla Server's
+	// "Opening data channel for file upload to server of "/name""
+	stouQuotedRegex = regexp.MustCompile(`"([^"]+)"|'([^']+)'`)
+
+	// stouForRegex matches "Opening BINARY mode data connection for name
+	// (N bytes).", used by IIS and Serv-U
+	stouForRegex = regexp.MustCompile(`(?i)data connection for (.+?)(?: \(\d+ bytes\))?\.?$`)
+)
+
+// stouName returns the file name chosen by the server from a reply to STOU,
+// or "" if the reply does not name it.
+func stouName(msg string) string {
+	for _, re := range []*regexp.Regexp{stouFileRegex, stouUniqueRegex, stouQuotedRegex, stouForRegex} {
+		for line := range strings.Lines(msg) {
+			m := re.FindStringSubmatch(strings.TrimSpace(line))
+			if m == nil {
+				continue
+			}
+			for _, name := range m[1:] {
+				if name = strings.TrimSpace(name); name != "" {
+					return name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// StoreUnique uploads data from an io.Reader to a file with a unique name
+// chosen by the server (STOU), and returns that name, so that callers can
+// record where the data landed.
 // The transfer is performed in binary mode (TYPE I).
+//
+// The name is read from the 150 reply, or from the 226 reply for servers
+// that only report it there. The formats of vsftpd, ProFTPD, Pure-FTPd,
+// wu-ftpd, IIS, Serv-U and FileZilla Server are recognized. If neither reply
+// names the file, the upload still succeeds and the returned name is empty.
 func (c *Client) StoreUnique(r io.Reader) (string, error) {
 	// Set binary mode
 	if err := c.Type("I"); err != nil {
@@ -26,16 +70,7 @@ func (c *Client) StoreUnique(r io.Reader) (string, error) {
 	if err != nil {
 		return "", err
 	}
-
-	// The filename is in the response message (150 FILE: <name>)
-	// Standard response format for STOU 150 is "FILE: %s"
-	msg := resp.Message
-	var filename string
-	if strings.HasPrefix(msg, "FILE: ") {
-		filename = strings.TrimPrefix(msg, "FILE: ")
-	} else {
-		filename = msg // Best effort
-	}
+	filename := stouName(resp.Message)
 
 	// Apply bandwidth limiting if configured
 	limiter := ratelimit.New(c.bandwidthLimit)
@@ -45,7 +80,7 @@ func (c *Client) StoreUnique(r io.Reader) (string, error) {
 	_, copyErr := copyWithPooledBuffer(dataConn, limitedReader)
 
 	// Always finish the data connection (close and read response)
-	finishErr := c.finishDataConn(dataConn)
+	final, finishErr := c.finishDataConnReply(dataConn)
 
 	// Return the first error that occurred
 	if copyErr != nil {
@@ -55,11 +90,19 @@ func (c *Client) StoreUnique(r io.Reader) (string, error) {
 		return "", finishErr
 	}
 
+	if filename == "" {
+		filename = stouName(final.Message)
+	}
 	return filename, nil
 }
 
-// Store uploads data from an io.Reader to the remote path.
-// The transfer is performed in binary mode (TYPE I).
+// Store uploads data from an io.Reader to the remote path, replacing the
+// file if it exists. The transfer is performed in binary mode (TYPE I).
+// Options can report progress (WithProgress), cancel the transfer
+// (WithTransferContext) or keep existing files (WithWriteMode).
+// The data is passed through the client's upload transform, if any (see
+// WithUploadTransform). Failed uploads are retried as set with
+// WithRetryPolicy.
 //
 // Example:
 //
@@ -70,17 +113,168 @@ func (c *Client) StoreUnique(r io.Reader) (string, error) {
 //	defer file.Close()
 //
 //	err = client.Store("remote.txt", file)
-func (c *Client) Store(remotePath string, r io.Reader) error {
+func (c *Client) Store(remotePath string, r io.Reader, opts ...TransferOption) error {
+	o := newTransferOptions(opts)
+	if err := c.checkWriteMode(remotePath, o); err != nil {
+		return err
+	}
+	size, sized := int64(0), false
+	if c.allocate || o.progress != nil {
+		size, sized = readerSize(r)
+	}
+	if c.allocate && sized && c.uploadTransform == nil {
+		return c.StoreWithSize(remotePath, r, size, opts...)
+	}
+	if err := c.makeParents(remotePath); err != nil {
+		return err
+	}
+	return c.storeRetrying(r, o, func(r io.Reader) error {
+		return c.store(remotePath, r, size, o)
+	})
+}
+
+// StoreWithSize uploads size bytes of data from an io.Reader to the remote
+// path, like Store, announcing the size first with ALLO. Servers that don't
+// need ALLO may reject it; that is not an error, but a refusal for lack of
+// space (552) is. If r is a *ProgressReader without a Total, Total is set to
+// size so its callback can report a percentage.
+//
+// Example:
+//
+//	pr := &ftp.ProgressReader{Reader: resp.Body}
+//	pr.Callback = func(n int64) {
+//	    fmt.Printf("\r%.0f%%", pr.Percent())
+//	}
+//	err := client.StoreWithSize("remote.bin", pr, resp.ContentLength)
+func (c *Client) StoreWithSize(remotePath string, r io.Reader, size int64, opts ...TransferOption) error {
+	o := newTransferOptions(opts)
+	if err := c.checkWriteMode(remotePath, o); err != nil {
+		return err
+	}
+
+	// Directories are created first, since ALLO must precede STOR
+	if err := c.makeParents(remotePath); err != nil {
+		return err
+	}
+	if pr, ok := r.(*ProgressReader); ok && pr.Total == 0 {
+		pr.Total = size
+	}
+	return c.storeRetrying(r, o, func(r io.Reader) error {
+		if err := c.Allocate(size); err != nil {
+			return err
+		}
+		return c.store(remotePath, r, size, o)
+	})
+}
+
+// makeParents creates the parent directories of remotePath if
+// WithCreateParents is set.
+func (c *Client) makeParents(remotePath string) error {
+	if !c.createParents {
+		return nil
+	}
+	if err := c.MkdirAll(c.remotePaths().dir(remotePath)); err != nil {
+		return fmt.Errorf("failed to create parent directories: %w", err)
+	}
+	return nil
+}
+
+// Allocate sends ALLO to reserve size bytes for the next upload (RFC 959).
+// Replies meaning that ALLO is not needed (202) or not implemented (500, 502)
+// are accepted.
+func (c *Client) Allocate(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("invalid allocation size: %d", size)
+	}
+	resp, err := c.sendCommand("ALLO", strconv.FormatInt(size, 10))
+	if err != nil {
+		return err
+	}
+	if resp.Is2xx() || resp.Code == 500 || resp.Code == 502 {
+		return nil
+	}
+	return &ProtocolError{
+		Command:  "ALLO",
+		Response: resp.Message,
+		Code:     resp.Code,
+	}
+}
+
+// readerSize returns the number of bytes left in r, if it can be known
+// without reading it.
+func readerSize(r io.Reader) (int64, bool) {
+	switch v := r.(type) {
+	case *ProgressReader:
+		return readerSize(v.Reader)
+	case interface{ Len() int }:
+		// *bytes.Reader, *strings.Reader and *bytes.Buffer report what is left
+		return int64(v.Len()), true
+	case interface{ Size() int64 }:
+		// *io.SectionReader
+		if s, ok := r.(io.Seeker); ok {
+			if pos, err := s.Seek(0, io.SeekCurrent); err == nil {
+				return v.Size() - pos, true
+			}
+		}
+		return v.Size(), true
+	case io.Seeker:
+		// *os.File and other seekable readers
+		pos, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		end, err := v.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false
+		}
+		if _, err := v.Seek(pos, io.SeekStart); err != nil {
+			return 0, false
+		}
+		return end - pos, true
+	}
+	return 0, false
+}
+
+// store uploads data with STOR, or APPE in WriteAppend mode, transforming
+// and verifying it if enabled. size is the size of the data for progress
+// reports (0 = unknown).
+func (c *Client) store(remotePath string, r io.Reader, size int64, o transferOptions) error {
+	if err := o.canceled(); err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+
+	if c.uploadTransform != nil {
+		tr := c.transformUpload(r)
+		defer tr.Close()
+		r, size = tr, 0
+	}
+
 	// Set binary mode
 	if err := c.Type("I"); err != nil {
 		return fmt.Errorf("failed to set binary mode: %w", err)
 	}
 
-	// Open data connection and send STOR command
-	_, dataConn, err := c.cmdDataConnFrom("STOR", remotePath)
+	// Hash the data as it is sent if verification is enabled. Appended data
+	// is only part of the remote file, so it cannot be verified.
+	var verifier *transferVerifier
+	if o.mode != WriteAppend {
+		verifier = c.newTransferVerifier()
+	}
+	if verifier != nil {
+		r = io.TeeReader(r, verifier)
+	}
+	r = o.wrapReader(r, size)
+
+	// Open data connection and send STOR or APPE command
+	cmd := "STOR"
+	if o.mode == WriteAppend {
+		cmd = "APPE"
+	}
+	_, dataConn, err := c.cmdDataConnFrom(cmd, remotePath)
 	if err != nil {
 		return err
 	}
+	defer o.cancelOnDone(dataConn)()
 
 	// Apply bandwidth limiting if configured
 	limiter := ratelimit.New(c.bandwidthLimit)
@@ -93,6 +287,9 @@ func (c *Client) Store(remotePath string, r io.Reader) error {
 	finishErr := c.finishDataConn(dataConn)
 
 	// Return the first error that occurred
+	if err := o.canceled(); err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
 	if copyErr != nil {
 		return fmt.Errorf("upload failed: %w", copyErr)
 	}
@@ -100,23 +297,29 @@ func (c *Client) Store(remotePath string, r io.Reader) error {
 		return finishErr
 	}
 
+	if verifier != nil {
+		return verifier.verify(remotePath)
+	}
 	return nil
 }
 
 // StoreFrom uploads a local file to the remote path.
 // This is a convenience wrapper around Store.
-func (c *Client) StoreFrom(remotePath, localPath string) error {
+func (c *Client) StoreFrom(remotePath, localPath string, opts ...TransferOption) error {
 	file, err := os.Open(localPath)
 	if err != nil {
 		return fmt.Errorf("failed to open local file: %w", err)
 	}
 	defer file.Close()
 
-	return c.Store(remotePath, file)
+	return c.Store(remotePath, file, opts...)
 }
 
 // Retrieve downloads data from the remote path to an io.Writer.
-// The transfer is performed in binary mode (TYPE I).
+// The transfer is performed in binary mode (TYPE I). Options can report
+// progress (WithProgress) or cancel the transfer (WithTransferContext).
+// The data is passed through the client's download transform, if any (see
+// WithDownloadTransform).
 //
 // Example:
 //
@@ -127,29 +330,52 @@ func (c *Client) StoreFrom(remotePath, localPath string) error {
 //	defer file.Close()
 //
 //	err = client.Retrieve("remote.txt", file)
-func (c *Client) Retrieve(remotePath string, w io.Writer) error {
+func (c *Client) Retrieve(remotePath string, w io.Writer, opts ...TransferOption) error {
+	o := newTransferOptions(opts)
+	if err := o.canceled(); err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+
 	// Set binary mode
 	if err := c.Type("I"); err != nil {
 		return fmt.Errorf("failed to set binary mode: %w", err)
 	}
 
+	// Hash the data as it is received if verification is enabled
+	verifier := c.newTransferVerifier()
+
 	// Open data connection and send RETR command
-	_, dataConn, err := c.cmdDataConnFrom("RETR", remotePath)
+	resp, dataConn, err := c.cmdDataConnFrom("RETR", remotePath)
 	if err != nil {
 		return err
 	}
+	defer o.cancelOnDone(dataConn)()
 
 	// Apply bandwidth limiting if configured
 	limiter := ratelimit.New(c.bandwidthLimit)
-	limitedReader := ratelimit.NewReader(dataConn, limiter)
+	var r io.Reader = ratelimit.NewReader(dataConn, limiter)
+	if verifier != nil {
+		r = io.TeeReader(r, verifier)
+	}
+
+	size := transferSize(resp.Message)
+	if c.downloadTransform != nil {
+		tr := c.downloadTransform(r)
+		defer tr.Close()
+		r, size = tr, 0
+	}
+	w = o.wrapWriter(w, size)
 
 	// Copy data from the connection
-	_, copyErr := copyWithPooledBuffer(w, limitedReader)
+	_, copyErr := copyWithPooledBuffer(w, r)
 
 	// Always finish the data connection (close and read response)
 	finishErr := c.finishDataConn(dataConn)
 
 	// Return the first error that occurred
+	if err := o.canceled(); err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
 	if copyErr != nil {
 		return fmt.Errorf("download failed: %w", copyErr)
 	}
@@ -157,19 +383,22 @@ func (c *Client) Retrieve(remotePath string, w io.Writer) error {
 		return finishErr
 	}
 
+	if verifier != nil {
+		return verifier.verify(remotePath)
+	}
 	return nil
 }
 
 // RetrieveTo downloads a remote file to a local path.
 // This is a convenience wrapper around Retrieve.
-func (c *Client) RetrieveTo(remotePath, localPath string) error {
+func (c *Client) RetrieveTo(remotePath, localPath string, opts ...TransferOption) error {
 	file, err := os.Create(localPath)
 	if err != nil {
 		return fmt.Errorf("failed to create local file: %w", err)
 	}
 	defer file.Close()
 
-	return c.Retrieve(remotePath, file)
+	return c.Retrieve(remotePath, file, opts...)
 }
 
 // Append appends data from an io.Reader to the remote path.
@@ -221,6 +450,10 @@ func (c *Client) Append(remotePath string, r io.Reader) error {
 //	}
 //	err = client.Retrieve("file.bin", writer) // Resumes from byte 1024
 func (c *Client) RestartAt(offset int64) error {
+	// Switch to block mode first: MODE between the marker and the
+	// transfer command would clear it
+	c.useBlockMode()
+
 	resp, err := c.sendCommand("REST", fmt.Sprintf("%d", offset))
 	if err != nil {
 		return err
@@ -292,6 +525,99 @@ func (c *Client) RetrieveFrom(remotePath string, w io.Writer, offset int64) erro
 	return nil
 }
 
+// RetrieveRange downloads the bytes from start to end (inclusive) of a file.
+// This is useful for segmented downloads of large files.
+// The transfer is performed in binary mode (TYPE I).
+//
+// If the server advertises RANG (draft-bryan-ftp-range), the range is
+// requested with it. Otherwise the transfer is restarted at start with REST
+// and the data connection is closed once the range has been read.
+//
+// Example:
+//
+//	// Download the second megabyte of a file
+//	var buf bytes.Buffer
+//	err := client.RetrieveRange("large.iso", &buf, 1<<20, 2<<20-1)
+func (c *Client) RetrieveRange(remotePath string, w io.Writer, start, end int64) error {
+	if start < 0 || end < start {
+		return fmt.Errorf("invalid byte range %d-%d", start, end)
+	}
+
+	// Set binary mode
+	if err := c.Type("I"); err != nil {
+		return fmt.Errorf("failed to set binary mode: %w", err)
+	}
+
+	useRANG := c.HasFeature("RANG")
+	if useRANG {
+		c.useBlockMode() // Before the range, as in RestartAt
+		resp, err := c.sendCommand("RANG", strconv.FormatInt(start, 10), strconv.FormatInt(end, 10))
+		if err != nil {
+			return err
+		}
+		switch {
+		case resp.Code == 350:
+		case resp.Code == 500 || resp.Code == 502:
+			// Advertised but not enabled; fall back to REST
+			useRANG = false
+		default:
+			return &ProtocolError{
+				Command:  "RANG",
+				Response: resp.Message,
+				Code:     resp.Code,
+			}
+		}
+	}
+	if !useRANG && start > 0 {
+		if err := c.RestartAt(start); err != nil {
+			return fmt.Errorf("failed to set restart marker: %w", err)
+		}
+	}
+
+	// Open data connection and send RETR command
+	_, dataConn, err := c.cmdDataConnFrom("RETR", remotePath)
+	if err != nil {
+		return err
+	}
+
+	// Apply bandwidth limiting if configured
+	limiter := ratelimit.New(c.bandwidthLimit)
+	limitedReader := ratelimit.NewReader(dataConn, limiter)
+
+	// Copy only the requested range from the connection
+	length := end - start + 1
+	n, copyErr := copyWithPooledBuffer(w, io.LimitReader(limitedReader, length))
+	if useRANG && copyErr == nil && n == length {
+		// Read the end of the data (the end of file block in block mode,
+		// so that the connection can be kept), without waiting for more
+		// than a byte from a server sending too much
+		_, _ = io.CopyN(io.Discard, dataConn, 1)
+	}
+
+	// Always finish the data connection (close and read response)
+	finishErr := c.finishDataConn(dataConn)
+
+	// Without RANG the server is still sending when we close the data
+	// connection, so an aborted transfer is expected once the range is read.
+	var pe *ProtocolError
+	if !useRANG && n == length && errors.As(finishErr, &pe) && (pe.Code == 426 || pe.Code == 451) {
+		finishErr = nil
+	}
+
+	// Return the first error that occurred
+	if copyErr != nil {
+		return fmt.Errorf("download failed: %w", copyErr)
+	}
+	if finishErr != nil {
+		return finishErr
+	}
+	if n < length {
+		return fmt.Errorf("download failed: got %d of %d bytes: %w", n, length, io.ErrUnexpectedEOF)
+	}
+
+	return nil
+}
+
 // StoreAt uploads a file starting from the specified byte offset.
 // This allows resuming an interrupted upload by appending to an existing file.
 // The transfer is performed in binary mode (TYPE I).
@@ -342,123 +668,25 @@ func (c *Client) StoreAt(remotePath string, r io.Reader, offset int64) error {
 }
 
 // UploadDir uploads a local directory to the remote server recursively.
-// It creates the remote directory structure if needed.
+// It creates the remote directory structure if needed. Symbolic links are
+// skipped, so files outside the directory are never uploaded by accident.
+// Use UploadDirWithOptions for filters and symlink and overwrite policies.
 //
 // Example:
 //
 //	err := client.UploadDir("local_files", "/remote/files")
 func (c *Client) UploadDir(localDir, remoteDir string) error {
-	localDir = filepath.Clean(localDir)
-
-	// Walk the local directory
-	return filepath.Walk(localDir, func(pathStr string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip symlinks for safety
-		// We don't want to accidentally upload files outside the directory
-		// that are linked to.
-		if info.Mode()&os.ModeSymlink != 0 {
-			return nil
-		}
-
-		// Calculate relative path from localDir
-		relPath, err := filepath.Rel(localDir, pathStr)
-		if err != nil {
-			return err
-		}
-
-		if relPath == "." {
-			// Don't recreate the root remoteDir, it is assumed to be the target
-			// But maybe we should create it if it doesn't exist?
-			// Let's try to create it just in case, but ignore error
-			_ = c.MakeDir(remoteDir)
-			return nil
-		}
-
-		// Construct remote path using forward slashes
-		// On Windows relPath might use backslashes, so we convert them
-		remotePath := path.Join(remoteDir, filepath.ToSlash(relPath))
-
-		if info.IsDir() {
-			// Create remote directory
-			// We try to create it. If it fails, we assume it might already exist.
-			// Ideally we would check the error code (550) but for now we'll proceed.
-			// If we really can't create it and it doesn't exist, file uploads inside will fail.
-			_ = c.MakeDir(remotePath)
-		} else {
-			// Upload file
-			file, err := os.Open(pathStr)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-
-			if err := c.Store(remotePath, file); err != nil {
-				return err
-			}
-		}
-		return nil
-	})
+	return c.UploadDirWithOptions(localDir, remoteDir, DirOptions{})
 }
 
 // DownloadDir downloads a remote directory to the local filesystem recursively.
-// It creates the local directory structure if needed.
+// It creates the local directory structure if needed. Symbolic links are
+// skipped. Use DownloadDirWithOptions for filters and symlink and overwrite
+// policies.
 //
 // Example:
 //
 //	err := client.DownloadDir("/remote/files", "local_backup")
 func (c *Client) DownloadDir(remoteDir, localDir string) error {
-	// Ensure local root dir exists
-	if err := os.MkdirAll(localDir, 0755); err != nil {
-		return err
-	}
-
-	// Walk remote directory
-	return c.Walk(remoteDir, func(pathStr string, info *Entry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Calculate relative path from remoteDir
-		// path.Rel does not exist in standard library.
-		// Since we are walking, we know pathStr starts with remoteDir.
-		if !strings.HasPrefix(pathStr, remoteDir) {
-			return fmt.Errorf("invalid path in walk: %s (expected prefix %s)", pathStr, remoteDir)
-		}
-
-		relPath := strings.TrimPrefix(pathStr, remoteDir)
-		relPath = strings.TrimPrefix(relPath, "/")
-
-		if relPath == "" {
-			return nil
-		}
-
-		localPath := filepath.Join(localDir, filepath.FromSlash(relPath))
-
-		if info.Type == "dir" {
-			// Create local directory
-			if err := os.MkdirAll(localPath, 0755); err != nil {
-				return err
-			}
-		} else {
-			// File
-			// Ensure parent dir exists (should already match "dir" case, but just to be safe)
-			if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
-				return err
-			}
-
-			file, err := os.Create(localPath)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-
-			if err := c.Retrieve(pathStr, file); err != nil {
-				return err
-			}
-		}
-		return nil
-	})
+	return c.DownloadDirWithOptions(remoteDir, localDir, DirOptions{})
 }
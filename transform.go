@@ -0,0 +1,40 @@
+package ftp
+
+import (
+	"io"
+	"path"
+)
+
+// transformUpload returns a reader of the data of r passed through the
+// upload transform. The transform runs in a goroutine, which ends when all
+// the data has been read or the returned reader is closed.
+func (c *Client) transformUpload(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		w := c.uploadTransform(pw)
+		_, err := io.Copy(w, r)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// remoteFilePath returns the path under remoteDir that the local file rel
+// is uploaded to, renamed with the remote naming callback.
+func (c *Client) remoteFilePath(remoteDir, rel string) string {
+	if c.remoteName != nil {
+		rel = path.Join(path.Dir(rel), c.remoteName(path.Base(rel)))
+	}
+	return joinRel(c.remotePaths(), remoteDir, rel, false)
+}
+
+// localFileRel returns the relative path that the remote file rel is
+// downloaded to, renamed with the local naming callback.
+func (c *Client) localFileRel(rel string) string {
+	if c.localName != nil {
+		rel = path.Join(path.Dir(rel), c.localName(path.Base(rel)))
+	}
+	return rel
+}
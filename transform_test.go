@@ -0,0 +1,100 @@
+package ftp_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
+)
+
+func gzipReader(r io.Reader) io.ReadCloser {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return io.NopCloser(iotest.ErrReader(err))
+	}
+	return zr
+}
+
+func TestTransforms(t *testing.T) {
+	t.Parallel()
+
+	s := ftptest.NewServer(t)
+	c := dialTestServer(t, s,
+		ftp.WithUploadTransform(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }),
+		ftp.WithDownloadTransform(gzipReader),
+		ftp.WithTransformNames(
+			func(name string) string { return name + ".gz" },
+			func(name string) string { return strings.TrimSuffix(name, ".gz") },
+		),
+		ftp.WithVerifyTransfers(),
+	)
+
+	data := strings.Repeat("compress me ", 1000)
+	if err := c.Store("/plain.txt", strings.NewReader(data)); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	stored, err := s.ReadFile("/plain.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		t.Fatalf("Stored file is not gzipped: %v", err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != data {
+		t.Errorf("Stored file decompresses to %d bytes, want %d", len(got), len(data))
+	}
+	if len(stored) >= len(data) {
+		t.Errorf("Stored %d bytes for %d bytes of data", len(stored), len(data))
+	}
+
+	var buf bytes.Buffer
+	if err := c.Retrieve("/plain.txt", &buf); err != nil {
+		t.Fatalf("Retrieve: %v", err)
+	}
+	if buf.String() != data {
+		t.Errorf("Retrieve returned %d bytes, want %d", buf.Len(), len(data))
+	}
+
+	// The directory helpers rename the files
+	local := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(local, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(local, "sub", "a.txt"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UploadDir(local, "/backup"); err != nil {
+		t.Fatalf("UploadDir: %v", err)
+	}
+	if _, err := s.ReadFile("/backup/sub/a.txt.gz"); err != nil {
+		t.Errorf("Uploaded file not renamed: %v", err)
+	}
+
+	restored := t.TempDir()
+	if err := c.DownloadDir("/backup", restored); err != nil {
+		t.Fatalf("DownloadDir: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(restored, "sub", "a.txt"))
+	if err != nil {
+		t.Fatalf("Downloaded file not renamed: %v", err)
+	}
+	if string(got) != data {
+		t.Errorf("Downloaded %d bytes, want %d", len(got), len(data))
+	}
+
+	// A failing transform fails the download
+	if err := s.WriteFile("/bad.gz", []byte("not gzip")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Retrieve("/bad.gz", io.Discard); err == nil {
+		t.Error("Retrieve of a corrupt file succeeded")
+	}
+}
@@ -0,0 +1,186 @@
+package ftp
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"slices"
+	"strings"
+)
+
+// hashAlgorithms lists the HASH algorithms the client can compute locally,
+// in order of preference.
+var hashAlgorithms = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"SHA-256", sha256.New},
+	{"SHA-512", sha512.New},
+	{"SHA-1", sha1.New},
+	{"MD5", md5.New},
+	{"CRC32", func() hash.Hash { return crc32.NewIEEE() }},
+}
+
+// legacyHashCommands lists the non-standard checksum commands, in order of
+// preference, with the algorithm each one computes.
+var legacyHashCommands = []struct {
+	command string
+	algo    string
+	new     func() hash.Hash
+}{
+	{"XSHA1", "SHA-1", sha1.New},
+	{"XSHA", "SHA-1", sha1.New},
+	{"XMD5", "MD5", md5.New},
+	{"XCRC", "CRC32", func() hash.Hash { return crc32.NewIEEE() }},
+}
+
+// XCRC requests the CRC-32 checksum of a file with the non-standard XCRC
+// command, supported by many Windows servers (e.g. Serv-U, FileZilla Server).
+// Prefer Hash on servers that advertise HASH.
+//
+// Example:
+//
+//	crc, err := client.XCRC("file.zip")
+func (c *Client) XCRC(path string) (string, error) {
+	return c.legacyHash("XCRC", path)
+}
+
+// XMD5 requests the MD5 digest of a file with the non-standard XMD5 command.
+// Prefer Hash on servers that advertise HASH.
+func (c *Client) XMD5(path string) (string, error) {
+	return c.legacyHash("XMD5", path)
+}
+
+// XSHA1 requests the SHA-1 digest of a file with the non-standard XSHA1
+// command. Prefer Hash on servers that advertise HASH.
+func (c *Client) XSHA1(path string) (string, error) {
+	return c.legacyHash("XSHA1", path)
+}
+
+// legacyHash sends one of the X* checksum commands. Servers disagree on the
+// reply code (213 or 250) and on whether the path is echoed, so any 2xx reply
+// is accepted and the checksum is taken to be the last hexadecimal field.
+func (c *Client) legacyHash(cmd, path string) (string, error) {
+	resp, err := c.sendCommand(cmd, path)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Code < 200 || resp.Code >= 300 {
+		return "", &ProtocolError{
+			Command:  cmd,
+			Response: resp.Message,
+			Code:     resp.Code,
+		}
+	}
+
+	fields := strings.Fields(resp.Message)
+	for i := len(fields) - 1; i >= 0; i-- {
+		if isHex(fields[i]) {
+			return fields[i], nil
+		}
+	}
+	return "", fmt.Errorf("invalid %s response: %s", cmd, resp.Message)
+}
+
+// isHex reports whether s is a non-empty string of hexadecimal digits.
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// transferVerifier computes the digest of transferred data and compares it
+// with the server's checksum of the file.
+type transferVerifier struct {
+	hash.Hash
+	algo   string
+	remote func(path string) (string, error)
+}
+
+// newTransferVerifier returns a verifier for the best checksum the server
+// supports, or nil if verification is disabled or not possible. HASH is
+// preferred; the algorithm is selected with OPTS HASH. Otherwise the legacy
+// XSHA1 (or XSHA), XMD5 or XCRC commands are used.
+func (c *Client) newTransferVerifier() *transferVerifier {
+	if !c.verifyTransfers {
+		return nil
+	}
+
+	if c.HasFeature("HASH") {
+		supported, _ := c.HashAlgorithms()
+		for _, a := range hashAlgorithms {
+			if !slices.ContainsFunc(supported, func(s string) bool { return strings.EqualFold(s, a.name) }) {
+				continue
+			}
+			if err := c.SetHashAlgo(a.name); err != nil {
+				break // Try the legacy commands instead
+			}
+			return &transferVerifier{
+				Hash: a.new(),
+				algo: a.name,
+				remote: func(path string) (string, error) {
+					res, err := c.Hash(path)
+					if err != nil {
+						return "", err
+					}
+					return res.Value, nil
+				},
+			}
+		}
+	}
+
+	for _, l := range legacyHashCommands {
+		if c.HasFeature(l.command) {
+			cmd := l.command
+			return &transferVerifier{
+				Hash: l.new(),
+				algo: l.algo,
+				remote: func(path string) (string, error) {
+					return c.legacyHash(cmd, path)
+				},
+			}
+		}
+	}
+
+	return nil
+}
+
+// verify asks the server for the checksum of remotePath and compares it with
+// the digest of the transferred data.
+func (v *transferVerifier) verify(remotePath string) error {
+	remote, err := v.remote(remotePath)
+	if err != nil {
+		return fmt.Errorf("transfer verification failed: %w", err)
+	}
+
+	local := hex.EncodeToString(v.Sum(nil))
+	if !sameChecksum(local, remote) {
+		return &ChecksumMismatchError{
+			Path:      remotePath,
+			Algorithm: v.algo,
+			Local:     local,
+			Remote:    remote,
+		}
+	}
+	return nil
+}
+
+// sameChecksum compares two hexadecimal checksums, ignoring case and leading
+// zeros (some servers print CRCs without them).
+func sameChecksum(a, b string) bool {
+	return strings.EqualFold(strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0"))
+}
@@ -0,0 +1,164 @@
+package ftp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// newVerifyMockServer returns a mock server that advertises feat, serves
+// content for RETR, discards STOR data and replies to checksum commands
+// with the given handler.
+func newVerifyMockServer(t *testing.T, feat string, content []byte, checksum func(cmd, args string) string) *mockServer {
+	t.Helper()
+	ms := newMockServer(t)
+	ms.serveData(t)
+
+	ms.handlers["FEAT"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("211-Features:")
+		_ = c.PrintfLine(" %s", feat)
+		_ = c.PrintfLine("211 End")
+	}
+	ms.handlers["RETR"] = ms.transfer(func(dconn net.Conn) {
+		_, _ = dconn.Write(content)
+	})
+	ms.handlers["STOR"] = ms.transfer(func(dconn net.Conn) {
+		_, _ = io.Copy(io.Discard, dconn)
+	})
+	for _, cmd := range []string{"HASH", "XCRC", "XMD5", "XSHA1"} {
+		ms.handlers[cmd] = func(c *textproto.Conn, args string) {
+			_ = c.PrintfLine("%s", checksum(cmd, args))
+		}
+	}
+	ms.handlers["OPTS"] = func(c *textproto.Conn, args string) {
+		_ = c.PrintfLine("200 %s", args)
+	}
+	return ms
+}
+
+func TestVerifyTransfers_XCRC(t *testing.T) {
+	t.Parallel()
+	content := []byte("hello, checksum")
+	crc := fmt.Sprintf("%X", crc32.ChecksumIEEE(content))
+
+	ms := newVerifyMockServer(t, "XCRC", content, func(cmd, args string) string {
+		if args == "bad.txt" {
+			return "250 DEADBEEF"
+		}
+		return "250 " + crc
+	})
+	c := dialMock(t, ms, WithVerifyTransfers())
+
+	var buf bytes.Buffer
+	if err := c.Retrieve("good.txt", &buf); err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	if err := c.Store("good.txt", bytes.NewReader(content)); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	err := c.Retrieve("bad.txt", io.Discard)
+	var mismatch *ChecksumMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected ChecksumMismatchError, got %v", err)
+	}
+	if mismatch.Algorithm != "CRC32" || mismatch.Remote != "DEADBEEF" || mismatch.Path != "bad.txt" {
+		t.Errorf("Unexpected mismatch error: %+v", mismatch)
+	}
+}
+
+func TestVerifyTransfers_HASH(t *testing.T) {
+	t.Parallel()
+	content := []byte("hash me")
+	// MD5 is the only offered algorithm the client supports. The server
+	// reports the digest of different content.
+	md5sum := fmt.Sprintf("%x", md5.Sum([]byte("other content")))
+
+	selected := make(chan string, 1)
+	ms := newVerifyMockServer(t, "HASH SHA-3;MD5*", content, func(cmd, args string) string {
+		return fmt.Sprintf("213 MD5 %s %s", md5sum, args)
+	})
+	ms.handlers["OPTS"] = func(c *textproto.Conn, args string) {
+		selected <- args
+		_ = c.PrintfLine("200 %s", args)
+	}
+	c := dialMock(t, ms, WithVerifyTransfers())
+
+	err := c.Retrieve("file.txt", io.Discard)
+	var mismatch *ChecksumMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected ChecksumMismatchError, got %v", err)
+	}
+	if mismatch.Algorithm != "MD5" || mismatch.Remote != md5sum {
+		t.Errorf("Unexpected mismatch error: %+v", mismatch)
+	}
+	if opts := <-selected; opts != "HASH MD5" {
+		t.Errorf("OPTS = %q, want %q", opts, "HASH MD5")
+	}
+}
+
+func TestVerifyTransfers_Unsupported(t *testing.T) {
+	t.Parallel()
+	ms := newVerifyMockServer(t, "SIZE", []byte("data"), func(cmd, args string) string {
+		return "500 unexpected"
+	})
+	c := dialMock(t, ms, WithVerifyTransfers())
+
+	if err := c.Retrieve("file.txt", io.Discard); err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	for _, cmd := range ms.receivedCommands {
+		if cmd == "HASH" || strings.HasPrefix(cmd, "X") {
+			t.Errorf("Unexpected checksum command %s", cmd)
+		}
+	}
+}
+
+func TestLegacyHashCommands(t *testing.T) {
+	t.Parallel()
+	ms := newVerifyMockServer(t, "XMD5", nil, func(cmd, args string) string {
+		switch cmd {
+		case "XCRC":
+			return "250 1A2B3C"
+		case "XMD5":
+			return "213 " + args + " d41d8cd98f00b204e9800998ecf8427e"
+		}
+		return "550 " + args + ": No such file"
+	})
+	c := dialMock(t, ms, WithVerifyTransfers())
+
+	if crc, err := c.XCRC("file"); err != nil || crc != "1A2B3C" {
+		t.Errorf("XCRC = %q, %v", crc, err)
+	}
+	if sum, err := c.XMD5("dir/file.txt"); err != nil || sum != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Errorf("XMD5 = %q, %v", sum, err)
+	}
+	var pe *ProtocolError
+	if _, err := c.XSHA1("missing"); !errors.As(err, &pe) || pe.Code != 550 {
+		t.Errorf("Expected 550 error, got %v", err)
+	}
+}
+
+func TestSameChecksum(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"0a1b2c3d", "0A1B2C3D", true},
+		{"00ab12cd", "AB12CD", true},
+		{"0a1b2c3d", "0a1b2c3e", false},
+	}
+	for _, tt := range tests {
+		if got := sameChecksum(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameChecksum(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package ftp
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// WriteMode is what an upload does with an existing remote file. See
+// WithWriteMode.
+type WriteMode int
+
+const (
+	// WriteOverwrite replaces an existing file (STOR). It is the default.
+	WriteOverwrite WriteMode = iota
+
+	// WriteExclusive fails if the file exists, with an error matching
+	// fs.ErrExist.
+	WriteExclusive
+
+	// WriteAppend adds the data to the end of an existing file, creating it
+	// if needed (APPE). Appended data is not verified (see
+	// WithVerifyTransfers).
+	WriteAppend
+)
+
+func (m WriteMode) String() string {
+	switch m {
+	case WriteOverwrite:
+		return "overwrite"
+	case WriteExclusive:
+		return "exclusive"
+	case WriteAppend:
+		return "append"
+	}
+	return fmt.Sprintf("WriteMode(%d)", int(m))
+}
+
+// WithWriteMode sets what Store, StoreWithSize and StoreFrom do when the
+// remote file exists.
+//
+// FTP has no command to create a file only if it is missing, so
+// WriteExclusive looks for the file (see Exists) before sending STOR. A
+// file created by someone else between the check and the upload is still
+// replaced, unless the server refuses overwrites itself, as many servers
+// can be configured to do; that refusal is returned as a *ProtocolError.
+//
+// Example:
+//
+//	err := client.Store("incoming/batch-42.csv", r, ftp.WithWriteMode(ftp.WriteAppend))
+func WithWriteMode(mode WriteMode) TransferOption {
+	return func(o *transferOptions) {
+		o.mode = mode
+	}
+}
+
+// StoreNew uploads data to the remote path like Store, but fails without
+// sending anything if the file exists. The error matches fs.ErrExist. See
+// WithWriteMode for the limits of the check.
+//
+// Example:
+//
+//	err := client.StoreNew("archive/2024-06-01.tar", r)
+//	if errors.Is(err, fs.ErrExist) {
+//	    log.Printf("already archived")
+//	}
+func (c *Client) StoreNew(remotePath string, r io.Reader, opts ...TransferOption) error {
+	return c.Store(remotePath, r, append(opts, WithWriteMode(WriteExclusive))...)
+}
+
+// checkWriteMode validates the write mode of an upload, and in
+// WriteExclusive mode fails if remotePath exists.
+func (c *Client) checkWriteMode(remotePath string, o transferOptions) error {
+	switch o.mode {
+	case WriteOverwrite, WriteAppend:
+		return nil
+	case WriteExclusive:
+	default:
+		return fmt.Errorf("invalid write mode: %v", o.mode)
+	}
+
+	exists, err := c.Exists(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", remotePath, err)
+	}
+	if exists {
+		return fmt.Errorf("ftp: store %s: %w", remotePath, fs.ErrExist)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package ftp_test
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/gonzalop/ftp"
+	"github.com/gonzalop/ftp/ftptest"
+)
+
+func TestWriteMode(t *testing.T) {
+	t.Parallel()
+	s := ftptest.NewServer(t)
+	if err := s.WriteFile("/log.txt", []byte("one\n")); err != nil {
+		t.Fatal(err)
+	}
+	c := dialTestServer(t, s)
+
+	err := c.StoreNew("/log.txt", strings.NewReader("clobbered"))
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("StoreNew on an existing file: %v, want fs.ErrExist", err)
+	}
+	if err := c.StoreNew("/new.txt", strings.NewReader("new")); err != nil {
+		t.Errorf("StoreNew on a new file: %v", err)
+	}
+
+	if err := c.Store("/log.txt", strings.NewReader("two\n"), ftp.WithWriteMode(ftp.WriteAppend)); err != nil {
+		t.Errorf("Store in append mode: %v", err)
+	}
+	if err := c.Store("/bad.txt", strings.NewReader("x"), ftp.WithWriteMode(ftp.WriteMode(9))); err == nil {
+		t.Error("Invalid write mode accepted")
+	}
+
+	for p, want := range map[string]string{"/log.txt": "one\ntwo\n", "/new.txt": "new"} {
+		if got, err := s.ReadFile(p); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", p, got, err, want)
+		}
+	}
+	if _, err := s.ReadFile("/bad.txt"); err == nil {
+		t.Error("Upload with an invalid write mode was sent")
+	}
+}